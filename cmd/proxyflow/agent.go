@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rfym21/ProxyFlow/internal/agent"
+	"github.com/rfym21/ProxyFlow/internal/config"
+)
+
+// runAgent 以客户端代理模式运行（proxyflow agent）。
+//
+// 在本机提供普通HTTP代理，通过多路复用传输把连接转发到中心ProxyFlow。
+func runAgent() {
+	cfg := config.LoadAgent()
+
+	var tlsConfig *tls.Config
+	if cfg.TLS {
+		var err error
+		tlsConfig, err = agent.TLSConfig(cfg.Server, cfg.TLSServerName, cfg.TLSCAFile)
+		if err != nil {
+			log.Fatalf("初始化客户端代理TLS配置失败: %v", err)
+		}
+	}
+	a, err := agent.New(agent.Options{
+		Server:         cfg.Server,
+		Token:          cfg.Token,
+		TLS:            tlsConfig,
+		Connections:    cfg.Connections,
+		DialTimeout:    cfg.DialTimeout,
+		ReconnectDelay: cfg.ReconnectDelay,
+	})
+	if err != nil {
+		log.Fatalf("创建客户端代理失败: %v", err)
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		log.Println("收到关闭信号，正在关闭客户端代理...")
+		a.Shutdown()
+	}()
+
+	if err := a.Start(cfg.Listen); err != nil {
+		log.Fatalf("客户端代理退出: %v", err)
+	}
+	log.Printf("客户端代理已关闭")
+}
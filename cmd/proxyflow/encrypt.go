@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rfym21/ProxyFlow/internal/config"
+	"github.com/rfym21/ProxyFlow/internal/pool"
+)
+
+// runEncryptProxies 加密或解密代理列表文件（proxyflow encrypt-proxies）。
+//
+// 密钥按 PROXY_FILE_KEY 或 PROXY_FILE_KEY_FILE 读取，与运行时解密使用同一配置；
+// 加密后的文件可以直接作为 PROXY_FILE 使用，代理凭据不再以明文保存在磁盘上。
+//
+// 参数：
+//   - args: 子命令之后的命令行参数
+func runEncryptProxies(args []string) {
+	flags := flag.NewFlagSet("encrypt-proxies", flag.ExitOnError)
+	decrypt := flags.Bool("d", false, "解密而不是加密")
+	genKey := flags.Bool("genkey", false, "生成一个新的随机密钥并输出")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "用法: proxyflow encrypt-proxies [-d] <输入文件> <输出文件>")
+		fmt.Fprintln(flags.Output(), "      proxyflow encrypt-proxies -genkey")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *genKey {
+		key := make([]byte, pool.FileKeySize)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("生成密钥失败: %v", err)
+		}
+		fmt.Println(hex.EncodeToString(key))
+		return
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	key, err := pool.LoadFileKey(cfg.ProxyFileKey, cfg.ProxyFileKeyFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if key == nil {
+		log.Fatalf("需要配置 PROXY_FILE_KEY 或 PROXY_FILE_KEY_FILE")
+	}
+
+	input, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("读取输入文件失败: %v", err)
+	}
+	var output []byte
+	if *decrypt {
+		output, err = pool.DecryptList(input, key)
+	} else {
+		output, err = pool.EncryptList(input, key)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := os.WriteFile(flags.Arg(1), output, 0o600); err != nil {
+		log.Fatalf("写入输出文件失败: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/config"
+)
+
+// setupLogging 按 LOG_FORMAT 和 LOG_FILE 配置主日志的输出。
+//
+// JSON格式下主日志的每一行包装为 {"time":...,"type":"log","msg":...}，
+// 访问日志以 "type":"access" 的记录写入同一输出，整个日志流都是JSON行。
+//
+// 参数：
+//   - cfg: 应用配置
+//
+// 返回值：
+//   - io.Writer: JSON格式时访问日志的输出，文本格式时为nil（访问日志写入主日志）
+func setupLogging(cfg *config.Config) io.Writer {
+	out := io.Writer(os.Stderr)
+	if cfg.LogFile != "" {
+		file, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			log.Fatalf("打开日志文件失败: %v", err)
+		}
+		out = file
+	}
+
+	switch cfg.LogFormat {
+	case "text":
+		log.SetOutput(out)
+		return nil
+	case "json":
+		log.SetFlags(0)
+		log.SetOutput(&jsonLogWriter{out: out})
+		return out
+	default:
+		log.Fatalf("未知的日志格式: %s（可选 text、json）", cfg.LogFormat)
+		return nil
+	}
+}
+
+// jsonLogWriter 将主日志的每一行包装为一行JSON。
+type jsonLogWriter struct {
+	out io.Writer // 实际输出
+}
+
+// Write 写入一行日志。log包每条日志调用一次，并保证串行调用。
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	line, err := json.Marshal(struct {
+		Time string `json:"time"`
+		Type string `json:"type"`
+		Msg  string `json:"msg"`
+	}{
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+		Type: "log",
+		Msg:  strings.TrimSuffix(string(p), "\n"),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,65 +1,813 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/rfym21/ProxyFlow/internal/admin"
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/budget"
+	"github.com/rfym21/ProxyFlow/internal/client"
 	"github.com/rfym21/ProxyFlow/internal/config"
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+	"github.com/rfym21/ProxyFlow/internal/labels"
 	"github.com/rfym21/ProxyFlow/internal/pool"
+	"github.com/rfym21/ProxyFlow/internal/profile"
+	"github.com/rfym21/ProxyFlow/internal/quota"
+	"github.com/rfym21/ProxyFlow/internal/rewrite"
+	"github.com/rfym21/ProxyFlow/internal/routing"
 	"github.com/rfym21/ProxyFlow/internal/server"
+	_ "github.com/rfym21/ProxyFlow/internal/shadowsocks" // 注册ss://上游
+	"github.com/rfym21/ProxyFlow/internal/slo"
+	"github.com/rfym21/ProxyFlow/internal/socks5"
+	"github.com/rfym21/ProxyFlow/internal/sshtunnel"
+	"github.com/rfym21/ProxyFlow/internal/traffic"
+	"github.com/rfym21/ProxyFlow/internal/update"
+	"github.com/rfym21/ProxyFlow/internal/version"
+	"github.com/rfym21/ProxyFlow/internal/watchlist"
+	"github.com/rfym21/ProxyFlow/internal/wgtunnel"
 )
 
 // main 程序入口点，负责初始化配置、创建代理池和启动服务器。
+//
+// 以 "proxyflow agent" 启动时改为客户端代理模式，"proxyflow config-schema" 输出配置项的JSON Schema，
+// "proxyflow version"（或 --version）输出构建版本信息，"proxyflow update" 自更新到发布清单中的版本，
+// "proxyflow encrypt-proxies" 加密或解密代理列表文件，"proxyflow sign-release" 为发布的可执行文件签名。
 func main() {
+	// 输出配置Schema，不读取 .env 文件
+	if len(os.Args) > 1 && os.Args[1] == "config-schema" {
+		runConfigSchema()
+		return
+	}
+
+	// 输出版本信息
+	if len(os.Args) > 1 && (os.Args[1] == "version" || os.Args[1] == "--version" || os.Args[1] == "-version") {
+		fmt.Println(version.Get())
+		return
+	}
+
+	// 为发布签名，不读取 .env 文件
+	if len(os.Args) > 1 && os.Args[1] == "sign-release" {
+		runSignRelease(os.Args[2:])
+		return
+	}
+
 	// 加载环境变量
 	if err := godotenv.Load(); err != nil {
 		log.Printf("警告: 未找到 .env 文件: %v", err)
 	}
 
+	// 客户端代理模式
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		runAgent()
+		return
+	}
+
+	// 自更新
+	if len(os.Args) > 1 && os.Args[1] == "update" {
+		runUpdate(os.Args[2:])
+		return
+	}
+
+	// 加密或解密代理列表文件
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-proxies" {
+		runEncryptProxies(os.Args[2:])
+		return
+	}
+
 	// 加载配置
 	cfg := config.Load()
-	log.Printf("启动 ProxyFlow，配置信息: 端口=%s, 代理API=%s, 连接池大小=%d",
-		cfg.ProxyPort, cfg.ProxyAPI, cfg.PoolSize)
+	accessLog := setupLogging(cfg)
+	log.Printf("启动 ProxyFlow %s，配置信息: 端口=%s, 代理API=%s, 连接池大小=%d",
+		version.Get().Short(), cfg.ProxyPort, cfg.ProxyAPI, cfg.PoolSize)
+
+	// SSH上游的私钥和主机密钥校验
+	if err := sshtunnel.Configure(sshtunnel.Options{
+		KeyFile:        cfg.SSHKeyFile,
+		KnownHostsFile: cfg.SSHKnownHosts,
+	}); err != nil {
+		log.Fatalf("配置SSH上游失败: %v", err)
+	}
+
+	// SOCKS5上游在严格DNS模式下同样由上游解析目标主机名
+	socks5.Configure(socks5.Options{StrictDNS: cfg.DNSStrict})
+
+	// 入站和上游连接的TCP keepalive，及时发现NAT后面已经失效的长连接
+	keepAlive := net.KeepAliveConfig{
+		Enable:   cfg.TCPKeepAlive > 0,
+		Idle:     cfg.TCPKeepAlive,
+		Interval: cfg.TCPKeepAliveInterval,
+		Count:    cfg.TCPKeepAliveCount,
+	}
+	dialer.ConfigureKeepAlive(keepAlive)
+
+	// 启动作为上游使用的WireGuard隧道
+	if err := wgtunnel.Configure(cfg.WireGuardTunnels); err != nil {
+		log.Fatalf("配置WireGuard隧道失败: %v", err)
+	}
+
+	// 加载提供额外上游协议的插件，需在解析代理列表之前完成
+	if err := dialer.LoadPlugins(cfg.UpstreamPlugins); err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	// 创建代理池
-	proxyPool, err := pool.NewPool(cfg.ProxyAPI)
+	poolOpts := pool.Options{
+		SessionMaxRequests: cfg.SessionMaxRequests,
+		SessionQuotaWindow: cfg.SessionQuotaWindow,
+		StickySessionTTL:   cfg.StickySessionTTL,
+		StickySessionMax:   cfg.StickySessionMax,
+		AvoidRepeatExit:    cfg.AvoidRepeatExit,
+		QueueMaxWait:       cfg.QueueMaxWait,
+		QueueMaxSize:       cfg.QueueMaxSize,
+		UpstreamRPS:        cfg.UpstreamRPS,
+		UpstreamBurst:      cfg.UpstreamBurst,
+		UpstreamMaxWait:    cfg.UpstreamMaxWait,
+		Concurrency: pool.ConcurrencyOptions{
+			Enabled:   cfg.AdaptiveConcurrency,
+			Initial:   cfg.ConcurrencyInitial,
+			Min:       cfg.ConcurrencyMin,
+			Max:       cfg.ConcurrencyMax,
+			Tolerance: cfg.LatencyTolerance,
+		},
+		APIErrorBackoff: cfg.ProxyAPIErrorBackoff,
+		PrefetchMaxAge:  cfg.ProxyPrefetchMaxAge,
+		Strategy:        cfg.ProxyStrategy,
+		Probe: pool.ProbeOptions{
+			Enabled:    cfg.CapabilityProbe,
+			Target:     cfg.CapabilityProbeTarget,
+			Ports:      cfg.CapabilityProbePorts,
+			IPv6Target: cfg.CapabilityProbeIPv6Target,
+			Timeout:    cfg.CapabilityProbeTimeout,
+			TTL:        cfg.CapabilityProbeTTL,
+		},
+		Health: pool.HealthOptions{
+			Enabled:    cfg.HealthCheck,
+			Passive:    cfg.HealthPassive,
+			Mode:       cfg.HealthCheckMode,
+			URL:        cfg.HealthCheckURL,
+			Interval:   cfg.HealthCheckInterval,
+			Timeout:    cfg.HealthCheckTimeout,
+			Workers:    cfg.HealthCheckWorkers,
+			Jitter:     float64(cfg.HealthCheckJitter) / 100,
+			Threshold:  cfg.HealthCheckThreshold,
+			Recovery:   cfg.HealthCheckRecovery,
+			MaxBackoff: cfg.HealthCheckMaxBackoff,
+			ExitIPURL:  cfg.HealthCheckExitIPURL,
+			CertHosts:  cfg.CertWatchHosts,
+
+			Adaptive:   cfg.HealthCheckAdaptive,
+			IdleFactor: cfg.HealthCheckIdleFactor,
+			Budget:     pool.NewProbeBudget(cfg.HealthCheckMaxRate),
+
+			CanaryURL:     cfg.CanaryURL,
+			CanarySHA256:  cfg.CanarySHA256,
+			CanaryExclude: cfg.CanaryExclude,
+		},
+	}
+	if cfg.ProxyPrefetch {
+		poolOpts.PrefetchSize = cfg.PoolSize
+	}
+	if cfg.HealthCheck && poolOpts.Health.Budget != nil {
+		log.Printf("健康检查速率上限: 全部代理池每秒 %g 次", cfg.HealthCheckMaxRate)
+	}
+	proxyPool, err := pool.NewPool(cfg.ProxyAPI, optionsFor(poolOpts, cfg, pool.DefaultPoolName))
 	if err != nil {
 		log.Fatalf("创建代理池失败: %v", err)
 	}
 
+	// 创建具名代理池及切换计划
+	namedPools := make(map[string]*pool.Pool, len(cfg.Pools))
+	for name, apiURL := range cfg.Pools {
+		if name == pool.DefaultPoolName {
+			log.Fatalf("代理池名称 %s 保留给 PROXY_API", name)
+		}
+		namedPools[name], err = pool.NewPool(apiURL, optionsFor(poolOpts, cfg, name))
+		if err != nil {
+			log.Fatalf("创建代理池 %s 失败: %v", name, err)
+		}
+	}
+	schedule, err := pool.ParseSchedule(cfg.PoolSchedule)
+	if err != nil {
+		log.Fatalf("解析代理池切换计划失败: %v", err)
+	}
+	for _, name := range schedule.Names() {
+		if _, ok := namedPools[name]; !ok && name != pool.DefaultPoolName {
+			log.Fatalf("代理池切换计划引用了未配置的代理池: %s", name)
+		}
+	}
+	tiers, err := pool.ParseFailoverTiers(cfg.FailoverTiers)
+	if err != nil {
+		log.Fatalf("解析 FAILOVER_TIERS 失败: %v", err)
+	}
+	if tiers != nil && schedule != nil {
+		log.Fatalf("FAILOVER_TIERS 与 POOL_SCHEDULE 不能同时使用")
+	}
+	failover := pool.NewFailover(tiers, pool.FailoverOptions{
+		Threshold: cfg.FailoverThreshold,
+		Recover:   max(cfg.FailoverRecover, cfg.FailoverThreshold),
+		Hold:      cfg.FailoverHold,
+	})
+	for _, name := range failover.Names() {
+		if _, ok := namedPools[name]; !ok && name != pool.DefaultPoolName {
+			log.Fatalf("FAILOVER_TIERS 引用了未配置的代理池: %s", name)
+		}
+	}
+	for name := range cfg.HealthCheckPoolModes {
+		if _, ok := namedPools[name]; !ok && name != pool.DefaultPoolName && name != pool.FallbackPoolName {
+			log.Fatalf("HEALTH_CHECK_POOL_MODES 引用了未配置的代理池: %s", name)
+		}
+	}
+	for name := range cfg.PoolChains {
+		if _, ok := namedPools[name]; !ok && name != pool.DefaultPoolName && name != pool.FallbackPoolName {
+			log.Fatalf("POOL_CHAINS 引用了未配置的代理池: %s", name)
+		}
+	}
+
+	// 创建流量预算及备用代理池
+	bandwidthBudget, err := budget.New(budget.Options{
+		Limit:     cfg.BandwidthBudget,
+		Action:    cfg.BandwidthAction,
+		StateFile: cfg.BandwidthUsageFile,
+	})
+	if err != nil {
+		log.Fatalf("初始化流量预算失败: %v", err)
+	}
+	trafficLimiter, err := traffic.New(traffic.Options{
+		ConnectionRate: cfg.BandwidthPerConnection,
+		UserRate:       cfg.BandwidthPerUser,
+		UserQuota:      cfg.UserTrafficQuota,
+		Period:         cfg.UserTrafficPeriod,
+		StateFile:      cfg.UserTrafficUsageFile,
+	})
+	if err != nil {
+		log.Fatalf("初始化带宽限制失败: %v", err)
+	}
+	var fallbackPool *pool.Pool
+	if bandwidthBudget != nil && cfg.BandwidthFallbackAPI != "" {
+		fallbackPool, err = pool.NewPool(cfg.BandwidthFallbackAPI, optionsFor(poolOpts, cfg, pool.FallbackPoolName))
+		if err != nil {
+			log.Fatalf("创建备用代理池失败: %v", err)
+		}
+	}
+
+	// 加载认证用户
+	users, err := auth.NewUsers(cfg.AuthUsersFile, cfg.AuthUsers, cfg.AuthUsername, cfg.AuthPassword, cfg.AuthUsersReload)
+	if err != nil {
+		log.Fatalf("加载认证用户失败: %v", err)
+	}
+	if users != nil {
+		log.Printf("已加载 %d 个认证用户", users.Len())
+	}
+
+	// 解析客户端IP访问控制
+	clientIPs, err := auth.ParseIPFilter(cfg.AllowIPs, cfg.DenyIPs, cfg.AllowIPsSkipAuth)
+	if err != nil {
+		log.Fatalf("解析客户端IP访问控制失败: %v", err)
+	}
+	if clientIPs != nil {
+		log.Printf("客户端IP访问控制已启用: %s", clientIPs)
+	}
+
+	// 解析用户访问时间段
+	accessSchedules, err := auth.ParseAccessSchedules(cfg.AccessHours)
+	if err != nil {
+		log.Fatalf("解析 ACCESS_HOURS 失败: %v", err)
+	}
+
+	// 打开认证失败记录文件
+	var authFailures io.Writer
+	if cfg.AuthFailureLog != "" {
+		file, err := os.OpenFile(cfg.AuthFailureLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			log.Fatalf("打开认证失败记录文件失败: %v", err)
+		}
+		authFailures = file
+	}
+
+	// 创建407认证质询
+	challenge, err := auth.NewChallenge(cfg.AuthRealm, cfg.AuthSchemes, cfg.AuthChallengeBody)
+	if err != nil {
+		log.Fatalf("创建认证质询失败: %v", err)
+	}
+
+	// 加载可疑目标规则
+	watchedDestinations, err := watchlist.New(cfg.AlertDestinations, cfg.BlockDestinations, cfg.DestinationRulesFile)
+	if err != nil {
+		log.Fatalf("加载可疑目标规则失败: %v", err)
+	}
+	if watchedDestinations != nil {
+		log.Printf("已加载 %d 条可疑目标规则", watchedDestinations.Len())
+	}
+
+	// 加载按目标主机的路由规则
+	var routes *routing.Router
+	if cfg.RoutesFile != "" {
+		if routes, err = routing.Load(cfg.RoutesFile); err != nil {
+			log.Fatalf("加载路由规则失败: %v", err)
+		}
+		for _, name := range routes.Pools() {
+			_, named := namedPools[name]
+			if !named && name != pool.DefaultPoolName && (name != pool.FallbackPoolName || fallbackPool == nil) {
+				log.Fatalf("路由规则引用了不存在的代理池: %s", name)
+			}
+		}
+		log.Printf("已加载 %d 条路由规则", routes.Len())
+	}
+	bypass, err := routing.ParseBypass(cfg.ProxyBypass)
+	if err != nil {
+		log.Fatalf("解析直连绕过列表失败: %v", err)
+	}
+	if cfg.DNSStrict {
+		if err := checkStrictDNS(routes, bypass, wgtunnel.WithoutDNS()); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	// 按目标主机的每日请求上限
+	capRules, err := quota.ParseRules(cfg.DailyCaps, cfg.DailyCapsPerUser)
+	if err != nil {
+		log.Fatalf("解析每日请求上限失败: %v", err)
+	}
+	dailyCaps := quota.New(capRules)
+	if dailyCaps != nil {
+		log.Printf("已加载 %d 条每日请求上限", dailyCaps.Len())
+	}
+
+	// 流量分类标签
+	labelRules, err := labels.ParseRules(cfg.TrafficLabels)
+	if err != nil {
+		log.Fatalf("解析流量标签规则失败: %v", err)
+	}
+	trafficLabels := labels.New(labelRules)
+	if trafficLabels != nil {
+		log.Printf("已加载 %d 条流量标签规则", len(labelRules))
+	}
+
+	// 按粘性会话保存Cookie的目标
+	cookieJarHosts, err := hostmatch.CompileList(cfg.CookieJarHosts)
+	if err != nil {
+		log.Fatalf("解析 COOKIE_JAR_HOSTS 失败: %v", err)
+	}
+
+	// 上游服务水平目标监控
+	objectives, err := slo.ParseObjectives(cfg.SLOObjectives)
+	if err != nil {
+		log.Fatalf("解析SLO目标失败: %v", err)
+	}
+	sloMonitor := slo.New(slo.Options{
+		Objectives: objectives,
+		Window:     cfg.SLOWindow,
+		MinSamples: cfg.SLOMinSamples,
+		PerProxy:   cfg.SLOPerProxy,
+		Interval:   cfg.SLOInterval,
+		Webhook:    cfg.SLOWebhook,
+	})
+
+	// 加载出站请求头画像
+	var profiles *profile.Set
+	if cfg.HeaderProfiles != "" {
+		profiles, err = profile.Load(cfg.HeaderProfiles)
+		if err != nil {
+			log.Fatalf("加载请求头画像失败: %v", err)
+		}
+		log.Printf("已加载 %d 个请求头画像", profiles.Len())
+	}
+
+	// 加载响应体改写规则
+	var rewrites *rewrite.Set
+	if cfg.RewriteRulesFile != "" {
+		rewrites, err = rewrite.Load(cfg.RewriteRulesFile, cfg.RewriteMaxBytes)
+		if err != nil {
+			log.Fatalf("加载响应改写规则失败: %v", err)
+		}
+		log.Printf("已加载 %d 条响应改写规则", rewrites.Len())
+	}
+
+	retryOnStatus := make(map[int]bool, len(cfg.RetryOnStatus))
+	for _, status := range cfg.RetryOnStatus {
+		retryOnStatus[status] = true
+	}
+
 	// 创建代理服务器
-	proxyServer := server.NewServer(proxyPool, cfg.RequestTimeout, cfg.AuthUsername, cfg.AuthPassword)
+	proxyServer := server.NewServer(proxyPool, server.Options{
+		Layers:       cfg.Layers(),
+		Users:        users,
+		ClientIPs:    clientIPs,
+		Access:       accessSchedules,
+		AuthFailures: authFailures,
+		Challenge:    challenge,
+		AuthCacheTTL: cfg.AuthCacheTTL,
+		StrictDNS:    cfg.DNSStrict,
+		KeepAlive:    keepAlive,
+		AccessLog:    accessLog,
+		PortPools:    cfg.PortPools,
+		PortSessions: cfg.PortSessions,
+		Profiles:     profiles,
+		Watchlist:    watchedDestinations,
+		Routes:       routes,
+		Bypass:       bypass,
+		Caps:         dailyCaps,
+		Labels:       trafficLabels,
+		RobotsAgents: cfg.RobotsAgents,
+		RobotsTTL:    cfg.RobotsCacheTTL,
+		SLO:          sloMonitor,
+
+		DynamicPorts:   cfg.DynamicPorts,
+		DynamicPortTTL: cfg.DynamicPortTTL,
+
+		SessionSources:   cfg.SessionSources,
+		SessionSeparator: cfg.SessionSeparator,
+
+		UserPools:       cfg.UserPools,
+		UsernameOptions: cfg.UsernameOptions,
+
+		MaxResponseHeaderBytes: cfg.MaxResponseHeaderBytes,
+		MaxResponseHeaders:     cfg.MaxResponseHeaders,
+
+		MaxRequestHeaderBytes: cfg.MaxRequestHeaderBytes,
+		MaxRequestHeaders:     cfg.MaxRequestHeaders,
+		MaxRequestBodyBytes:   cfg.MaxRequestBodyBytes,
+
+		Retry: client.RetryPolicy{
+			MaxAttempts:        cfg.RetryMaxAttempts,
+			RetryNonIdempotent: cfg.RetryNonIdempotent,
+			AttemptTimeout:     cfg.RetryAttemptTimeout,
+			RetryOnStatus:      retryOnStatus,
+		},
+
+		DestStatsHalfLife: cfg.DestStatsHalfLife,
+		DestStatsMaxHosts: cfg.DestStatsMaxHosts,
+		RotationWindow:    cfg.RotationWindow,
+		BodyHashMaxBytes:  cfg.ResponseHashMaxBytes,
+
+		SizeMetricsTopHosts: cfg.SizeMetricsTopHosts,
+
+		Rewrites: rewrites,
+
+		VersionHeader: cfg.VersionHeader,
+
+		CookieJarHosts: cookieJarHosts,
+		CookieJarTTL:   cfg.StickySessionTTL,
+
+		Budget:       bandwidthBudget,
+		FallbackPool: fallbackPool,
+		Traffic:      trafficLimiter,
+
+		Pools:    namedPools,
+		Schedule: schedule,
+		Failover: failover,
+
+		MaintenanceStatus:  cfg.MaintenanceStatus,
+		MaintenanceMessage: cfg.MaintenanceMessage,
+
+		DrainTimeout: cfg.DrainTimeout,
+
+		MaxConnections:    cfg.MaxConnections,
+		ShedLowPercent:    cfg.ShedLowPercent,
+		ShedNormalPercent: cfg.ShedNormalPercent,
+
+		ClientLimits: server.ClientLimitOptions{
+			IPRate:          cfg.ClientRPS,
+			IPConnections:   cfg.ClientMaxConnections,
+			UserRate:        cfg.UserRPS,
+			UserConnections: cfg.UserMaxConnections,
+			Burst:           cfg.ClientBurst,
+			MaxWait:         cfg.ClientRPSMaxWait,
+		},
+	})
+
+	// 创建管理API，未配置端口时仍可通过TLS分流访问
+	var adminServer *admin.Admin
+	if cfg.AdminPort != "" || slices.Contains(slices.Collect(maps.Values(cfg.TLSRoutes)), "admin") {
+		adminServer = admin.NewAdmin(proxyServer, cfg.AdminToken)
+	}
+
+	// 启动TLS代理监听器
+	if cfg.TLSPort != "" {
+		routes, err := tlsRoutes(cfg.TLSRoutes, adminServer, proxyServer)
+		if err != nil {
+			log.Fatalf("解析 TLS_ROUTES 失败: %v", err)
+		}
+		go func() {
+			err := proxyServer.StartTLS(cfg.TLSPort, server.TLSOptions{
+				CertFile:      cfg.TLSCertFile,
+				KeyFile:       cfg.TLSKeyFile,
+				MinVersion:    cfg.TLSMinVersion,
+				CipherSuites:  cfg.TLSCiphers,
+				Curves:        cfg.TLSCurves,
+				MaxHandshakes: cfg.TLSMaxHandshake,
+				HandshakeRate: cfg.TLSHandshakeRPM,
+				Routes:        routes,
+			})
+			if err != nil {
+				log.Printf("TLS代理监听器退出: %v", err)
+			}
+		}()
+	}
+
+	// 启动多路复用监听器
+	if cfg.MuxPort != "" {
+		if cfg.MuxToken == "" && len(cfg.MuxAgents) == 0 {
+			log.Fatalf("启用 MUX_PORT 时必须设置 MUX_TOKEN 或 MUX_AGENTS")
+		}
+		for agent := range cfg.MuxAgentPools {
+			if _, ok := cfg.MuxAgents[agent]; !ok && agent != server.AnonymousAgent {
+				log.Fatalf("MUX_AGENT_POOLS 引用了未配置的客户端代理: %s", agent)
+			}
+		}
+		opts := server.MuxOptions{
+			Token:      cfg.MuxToken,
+			Agents:     cfg.MuxAgents,
+			AgentPools: cfg.MuxAgentPools,
+			MaxStreams: cfg.MuxAgentMaxStreams,
+		}
+		if cfg.MuxTLS {
+			opts.CertFile = cfg.TLSCertFile
+			opts.KeyFile = cfg.TLSKeyFile
+		}
+		go func() {
+			if err := proxyServer.StartMux(cfg.MuxPort, opts); err != nil {
+				log.Printf("多路复用监听器退出: %v", err)
+			}
+		}()
+	}
+
+	// 启动WebSocket监听器
+	if cfg.WebSocketPort != "" {
+		opts := server.WebSocketOptions{Path: cfg.WebSocketPath}
+		if cfg.WebSocketTLS {
+			opts.CertFile = cfg.TLSCertFile
+			opts.KeyFile = cfg.TLSKeyFile
+		}
+		go func() {
+			if err := proxyServer.StartWebSocket(cfg.WebSocketPort, opts); err != nil {
+				log.Printf("WebSocket监听器退出: %v", err)
+			}
+		}()
+	}
+
+	// 启动SOCKS5监听器
+	if cfg.SOCKSPort != "" {
+		go func() {
+			if err := proxyServer.StartSOCKS(cfg.SOCKSPort); err != nil {
+				log.Printf("SOCKS5监听器退出: %v", err)
+			}
+		}()
+	}
+
+	// 启动HTTP/3代理监听器
+	if cfg.HTTP3Port != "" {
+		go func() {
+			err := proxyServer.StartHTTP3(cfg.HTTP3Port, server.TLSOptions{
+				CertFile: cfg.TLSCertFile,
+				KeyFile:  cfg.TLSKeyFile,
+				Curves:   cfg.TLSCurves,
+			})
+			if err != nil {
+				log.Printf("HTTP/3代理监听器退出: %v", err)
+			}
+		}()
+	}
+
+	// 启动管理API
+	if cfg.AdminPort != "" {
+		go func() {
+			if err := adminServer.Start(cfg.AdminPort); err != nil {
+				log.Printf("管理API异常退出: %v", err)
+			}
+		}()
+	}
+
+	// 替换可执行文件后收到 SIGUSR2 或 POST /admin/upgrade 时交接到新进程
+	var upgrade func() (int, error)
+	if update.Signal != nil {
+		upgrade = upgradeFunc(cfg, proxyServer, adminServer)
+		if adminServer != nil {
+			adminServer.SetUpgrade(upgrade)
+		}
+	}
 
 	// 设置优雅关闭
-	setupGracefulShutdown(proxyServer)
+	setupGracefulShutdown(proxyServer, adminServer, upgrade)
 
 	// 启动服务器
 	log.Printf("ProxyFlow 已准备就绪，开始处理请求")
 	if err := proxyServer.Start(cfg.ProxyPort); err != nil {
 		log.Printf("服务器关闭: %v", err)
+		return
 	}
+
+	// 监听器已由关闭流程关闭，等待关闭流程完成后退出进程
+	select {}
+}
+
+// checkStrictDNS 检查严格DNS模式与其他配置是否冲突。
+//
+// 直连路由、直连绕过列表和没有配置隧道内DNS的WireGuard隧道都会在本地解析目标主机名，
+// 与严格DNS模式不能同时使用。
+//
+// 参数：
+//   - routes: 路由规则，可以为nil
+//   - bypass: 直连绕过列表，可以为nil
+//   - tunnelsWithoutDNS: 没有配置隧道内DNS的WireGuard隧道名称
+//
+// 返回值：
+//   - error: 存在冲突的配置时返回错误
+func checkStrictDNS(routes *routing.Router, bypass *routing.Bypass, tunnelsWithoutDNS []string) error {
+	switch {
+	case routes.HasDirect():
+		return errors.New("严格DNS模式下不能配置直连路由")
+	case bypass != nil:
+		return errors.New("严格DNS模式下不能配置直连绕过列表")
+	case len(tunnelsWithoutDNS) > 0:
+		return fmt.Errorf("严格DNS模式下WireGuard隧道必须配置DNS，以免在本地解析目标主机名: %s", strings.Join(tunnelsWithoutDNS, ", "))
+	}
+	return nil
+}
+
+// optionsFor 生成指定代理池使用的配置。
+//
+// 应用按池覆盖的健康检查方式和代理链，主代理池还会应用 PROXY_FILE、PROXY_APIS、PROXY_LIST 等额外来源
+// 和 PROXY_CREDENTIALS 中的凭据覆盖。
+//
+// 参数：
+//   - base: 全部代理池共用的配置
+//   - cfg: 应用配置
+//   - name: 代理池名称
+//
+// 返回值：
+//   - pool.Options: 该代理池使用的配置
+func optionsFor(base pool.Options, cfg *config.Config, name string) pool.Options {
+	if mode, ok := cfg.HealthCheckPoolModes[name]; ok {
+		base.Health.Mode = mode
+	}
+	if name == pool.DefaultPoolName {
+		base.File = cfg.ProxyFile
+		base.APIWeight = cfg.ProxyAPIWeight
+		base.FileWeight = cfg.ProxyFileWeight
+		base.FileReload = cfg.ProxyFileReload
+		key, err := pool.LoadFileKey(cfg.ProxyFileKey, cfg.ProxyFileKeyFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		base.FileKey = key
+		base.APIs = cfg.ProxyAPIs
+		base.List = cfg.ProxyList
+		base.SourceWeights, base.SourceRefresh = sourceSettings(cfg)
+	}
+	base.Sessions = sessionStore(cfg, name)
+	if spec, ok := cfg.PoolChains[name]; ok {
+		chain, err := dialer.ParseChain(spec)
+		if err != nil {
+			log.Fatalf("解析代理池 %s 的代理链失败: %v", name, err)
+		}
+		base.Chain = chain
+		if len(chain) > 0 {
+			log.Printf("代理池 %s 经 %d 跳代理链连接上游代理", name, len(chain))
+		}
+	}
+	if name == pool.DefaultPoolName && len(cfg.ProxyCredentials) > 0 {
+		base.Credentials = make(map[string]pool.Credentials, len(cfg.ProxyCredentials))
+		for host, value := range cfg.ProxyCredentials {
+			username, password, _ := strings.Cut(value, ":")
+			base.Credentials[host] = pool.Credentials{Username: username, Password: password}
+		}
+	}
+	return base
+}
+
+// sessionStore 按 SESSION_STORE 创建代理池的粘性会话存储，配置无效或无法连接Redis时退出。
+//
+// 参数：
+//   - cfg: 应用配置
+//   - name: 代理池名称，redis 后端用它区分各代理池的键
+//
+// 返回值：
+//   - pool.SessionStore: 粘性会话存储，memory 后端返回nil，由代理池自行创建
+func sessionStore(cfg *config.Config, name string) pool.SessionStore {
+	switch cfg.SessionStore {
+	case "", "memory":
+		return nil
+	case "redis":
+		store, err := pool.NewRedisSessionStore(cfg.SessionRedisURL, cfg.SessionRedisPrefix+name+":", cfg.StickySessionTTL)
+		if err != nil {
+			log.Fatalf("创建代理池 %s 的会话存储失败: %v", name, err)
+		}
+		return store
+	default:
+		log.Fatalf("未知的会话存储: %s", cfg.SessionStore)
+		return nil
+	}
+}
+
+// sourceSettings 解析按来源名称的选择权重和刷新间隔，格式错误时退出。
+//
+// 参数：
+//   - cfg: 应用配置
+//
+// 返回值：
+//   - map[string]int: 来源名称到选择权重
+//   - map[string]time.Duration: 来源名称到刷新间隔
+func sourceSettings(cfg *config.Config) (map[string]int, map[string]time.Duration) {
+	weights := make(map[string]int, len(cfg.ProxySourceWeights))
+	for name, value := range cfg.ProxySourceWeights {
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 {
+			log.Fatalf("PROXY_SOURCE_WEIGHTS 中来源 %s 的权重无效: %q", name, value)
+		}
+		weights[name] = weight
+	}
+	refresh := make(map[string]time.Duration, len(cfg.ProxySourceRefresh))
+	for name, value := range cfg.ProxySourceRefresh {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			log.Fatalf("PROXY_SOURCE_REFRESH 中来源 %s 的刷新间隔无效: %q", name, value)
+		}
+		refresh[name] = time.Duration(seconds) * time.Second
+	}
+	return weights, refresh
+}
+
+// tlsRoutes 将TLS分流配置解析为处理器映射。
+//
+// 端点名称目前支持 admin（管理API）、websocket（经WebSocket封装的代理流量）
+// 和 proxy（按代理处理，与未配置分流相同）。
+//
+// 参数：
+//   - routes: 路由键（SNI主机名或 "alpn:<协议>"）到端点名称的映射
+//   - adminServer: 管理API服务
+//   - proxyServer: 代理服务器
+//
+// 返回值：
+//   - map[string]http.Handler: 路由键到处理器的映射
+//   - error: 端点名称未知时返回错误
+func tlsRoutes(routes map[string]string, adminServer *admin.Admin, proxyServer *server.Server) (map[string]http.Handler, error) {
+	handlers := make(map[string]http.Handler, len(routes))
+	for key, target := range routes {
+		switch target {
+		case "admin":
+			handlers[key] = adminServer.Handler()
+		case "websocket":
+			handlers[key] = proxyServer.WebSocketHandler()
+		case "proxy":
+		default:
+			return nil, fmt.Errorf("未知的分流端点 %s（%s）", target, key)
+		}
+	}
+	return handlers, nil
 }
 
 // setupGracefulShutdown 设置优雅关闭处理。
 //
 // 监听系统中断信号（SIGINT、SIGTERM），在接收到信号时
-// 执行优雅的服务关闭流程。
+// 执行优雅的服务关闭流程；支持进程交接时还监听交接信号（SIGUSR2），收到时交接到新进程。
 //
 // 参数：
 //   - server: 代理服务器实例
-func setupGracefulShutdown(server *server.Server) {
+//   - adminServer: 管理API服务实例，未启用时为nil
+//   - upgrade: 收到交接信号时调用的进程交接函数，为nil时不处理交接信号
+func setupGracefulShutdown(server *server.Server, adminServer *admin.Admin, upgrade func() (int, error)) {
+	if upgrade != nil {
+		u := make(chan os.Signal, 1)
+		signal.Notify(u, update.Signal)
+		go func() {
+			for range u {
+				log.Println("收到交接信号，正在启动新进程...")
+				upgrade()
+			}
+		}()
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		<-c
 		log.Println("收到关闭信号，正在关闭 ProxyFlow...")
+		if adminServer != nil {
+			if err := adminServer.Shutdown(); err != nil {
+				log.Printf("关闭管理API时出错: %v", err)
+			}
+		}
 		if err := server.Shutdown(); err != nil {
 			log.Printf("关闭服务器时出错: %v", err)
 		}
+		wgtunnel.Close()
 		os.Exit(0)
 	}()
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rfym21/ProxyFlow/internal/routing"
+)
+
+// loadRoutes 把YAML写入临时文件并加载路由规则。
+func loadRoutes(t *testing.T, content string) *routing.Router {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := routing.Load(path)
+	if err != nil {
+		t.Fatalf("加载路由规则失败: %v", err)
+	}
+	return routes
+}
+
+func TestCheckStrictDNS(t *testing.T) {
+	proxyRoutes := loadRoutes(t, "rules:\n  - hosts: [\".example.com\"]\n    pool: backup\n")
+	directRoutes := loadRoutes(t, "rules:\n  - hosts: [\".internal\"]\n    action: direct\n")
+	directDefault := loadRoutes(t, "default:\n  action: direct\n")
+	bypass, err := routing.ParseBypass([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		routes  *routing.Router
+		bypass  *routing.Bypass
+		tunnels []string
+		want    string
+	}{
+		{"没有冲突的配置", nil, nil, nil, ""},
+		{"只有代理路由", proxyRoutes, nil, nil, ""},
+		{"直连路由", directRoutes, nil, nil, "直连路由"},
+		{"默认直连", directDefault, nil, nil, "直连路由"},
+		{"直连绕过列表", nil, bypass, nil, "直连绕过列表"},
+		{"没有DNS的WireGuard隧道", nil, nil, []string{"nl", "se"}, "nl, se"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStrictDNS(tt.routes, tt.bypass, tt.tunnels)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("不应报错: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/rfym21/ProxyFlow/internal/config"
+)
+
+// runConfigSchema 输出全部配置项的JSON Schema（proxyflow config-schema）。
+//
+// 可用于在CI中校验配置，或为编辑器提供配置项补全。
+func runConfigSchema() {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(config.Schema()); err != nil {
+		log.Fatalf("输出配置Schema失败: %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/admin"
+	"github.com/rfym21/ProxyFlow/internal/config"
+	"github.com/rfym21/ProxyFlow/internal/server"
+	"github.com/rfym21/ProxyFlow/internal/update"
+	"github.com/rfym21/ProxyFlow/internal/version"
+	"github.com/rfym21/ProxyFlow/internal/wgtunnel"
+)
+
+// runUpdate 自更新（proxyflow update）。
+//
+// 读取 UPDATE_URL 的发布清单并用 UPDATE_PUBLIC_KEY 校验签名，版本高于当前时下载当前平台的可执行文件，
+// 替换当前程序后通过管理API通知运行中的进程交接到新版本。低于当前的版本只有指定 --force 时才安装。
+// 未配置 ADMIN_PORT 时需要手动向运行中的进程发送 SIGUSR2。
+//
+// 参数：
+//   - args: 子命令之后的命令行参数
+func runUpdate(args []string) {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	check := flags.Bool("check", false, "只检查是否有新版本，不下载")
+	force := flags.Bool("force", false, "版本相同或低于当前版本时也安装")
+	noRestart := flags.Bool("no-restart", false, "只替换可执行文件，不通知运行中的进程交接")
+	flags.Parse(args)
+
+	cfg := config.Load()
+	updater, err := update.NewUpdater(cfg.UpdateURL, cfg.UpdatePublicKey)
+	if err != nil {
+		log.Fatalf("无法自更新: %v", err)
+	}
+	release, err := updater.Check()
+	if err != nil {
+		log.Fatalf("检查更新失败: %v", err)
+	}
+
+	current := version.Get().Version
+	order, ok := update.CompareVersions(release.Version, current)
+	if !ok {
+		// 版本号无法解析（例如开发构建）时无法判断新旧，只要版本不同就视为新版本
+		order = 1
+		if release.Version == current {
+			order = 0
+		}
+	}
+	if order == 0 && !*force {
+		fmt.Printf("已是最新版本 %s\n", current)
+		return
+	}
+	if order < 0 && !*force {
+		log.Fatalf("可用版本 %s 低于当前版本 %s，拒绝降级（确需安装时使用 --force）", release.Version, current)
+	}
+	fmt.Printf("当前版本 %s，可用版本 %s\n", current, release.Version)
+	if *check {
+		return
+	}
+
+	data, err := updater.Download(release)
+	if err != nil {
+		log.Fatalf("更新失败: %v", err)
+	}
+	path, err := update.Install(data)
+	if err != nil {
+		log.Fatalf("更新失败: %v", err)
+	}
+	fmt.Printf("已将 %s 更新到 %s，原版本保留为 %s.old\n", path, release.Version, path)
+
+	if *noRestart {
+		return
+	}
+	if cfg.AdminPort == "" {
+		fmt.Println("未配置 ADMIN_PORT，请向运行中的 ProxyFlow 进程发送 SIGUSR2 完成切换")
+		return
+	}
+	pid, err := requestUpgrade(cfg.AdminPort, cfg.AdminToken, cfg.UpdateReadyTimeout)
+	if err != nil {
+		log.Fatalf("通知运行中的进程交接失败: %v（可执行文件已更新，可稍后发送 SIGUSR2 重试）", err)
+	}
+	fmt.Printf("新版本已由进程 %d 接管\n", pid)
+}
+
+// runSignRelease 为发布的可执行文件签名（proxyflow sign-release）。
+//
+// 输出发布清单中该平台的文件信息（JSON），签名覆盖版本号、平台和文件的SHA-256；
+// 私钥文件内容为Base64编码的Ed25519私钥或32字节种子，可用 -genkey 生成。
+//
+// 参数：
+//   - args: 子命令之后的命令行参数
+func runSignRelease(args []string) {
+	flags := flag.NewFlagSet("sign-release", flag.ExitOnError)
+	keyFile := flags.String("key", "", "Ed25519私钥文件")
+	releaseVersion := flags.String("version", "", "发布的版本号")
+	platform := flags.String("platform", runtime.GOOS+"/"+runtime.GOARCH, "可执行文件的平台（GOOS/GOARCH）")
+	assetURL := flags.String("url", "", "清单中的下载地址，默认为文件名")
+	genKey := flags.Bool("genkey", false, "生成新的密钥对，私钥写入文件，输出 UPDATE_PUBLIC_KEY 使用的公钥")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "用法: proxyflow sign-release -key <私钥文件> -version <版本号> [-platform GOOS/GOARCH] [-url 地址] <可执行文件>")
+		fmt.Fprintln(flags.Output(), "      proxyflow sign-release -genkey <私钥文件>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *genKey {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("生成密钥失败: %v", err)
+		}
+		if err := os.WriteFile(flags.Arg(0), []byte(base64.StdEncoding.EncodeToString(private)+"\n"), 0o600); err != nil {
+			log.Fatalf("写入私钥失败: %v", err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(public))
+		return
+	}
+	if flags.NArg() != 1 || *keyFile == "" || *releaseVersion == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if _, ok := update.CompareVersions(*releaseVersion, *releaseVersion); !ok {
+		log.Fatalf("版本号 %q 不是有效的语义化版本", *releaseVersion)
+	}
+
+	encoded, err := os.ReadFile(*keyFile)
+	if err != nil {
+		log.Fatalf("读取私钥失败: %v", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	var privateKey ed25519.PrivateKey
+	switch {
+	case err == nil && len(raw) == ed25519.PrivateKeySize:
+		privateKey = ed25519.PrivateKey(raw)
+	case err == nil && len(raw) == ed25519.SeedSize:
+		privateKey = ed25519.NewKeyFromSeed(raw)
+	default:
+		log.Fatalf("%s 不是有效的Ed25519私钥", *keyFile)
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("读取可执行文件失败: %v", err)
+	}
+	if *assetURL == "" {
+		*assetURL = filepath.Base(flags.Arg(0))
+	}
+	asset := update.Sign(privateKey, *releaseVersion, *platform, *assetURL, data)
+	out, _ := json.MarshalIndent(map[string]update.Asset{*platform: asset}, "", "  ")
+	fmt.Println(string(out))
+}
+
+// requestUpgrade 通过本机管理API请求运行中的进程交接。
+//
+// 参数：
+//   - port: 管理API端口
+//   - token: 管理API访问令牌
+//   - timeout: 运行中的进程等待新进程就绪的时间
+//
+// 返回值：
+//   - int: 新进程的PID
+//   - error: 请求失败或交接失败
+func requestUpgrade(port, token string, timeout time.Duration) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:"+port+"/admin/upgrade", nil)
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: timeout + 10*time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		PID   int    `json:"pid"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("管理API返回 %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("管理API返回 %s: %s", resp.Status, body.Error)
+	}
+	return body.PID, nil
+}
+
+// upgradeFunc 返回进程交接函数。
+//
+// 交接成功后本进程关闭管理API、停止接受新连接，等待活跃隧道结束或超时后退出；
+// 失败时新进程已被终止，本进程照常运行。
+//
+// 参数：
+//   - cfg: 配置
+//   - proxyServer: 代理服务器实例
+//   - adminServer: 管理API服务实例，未启用时为nil
+//
+// 返回值：
+//   - func() (int, error): 交接函数，成功时返回新进程的PID
+func upgradeFunc(cfg *config.Config, proxyServer *server.Server, adminServer *admin.Admin) func() (int, error) {
+	return func() (int, error) {
+		pid, err := update.Handoff(cfg.UpdateReadyTimeout)
+		if err != nil {
+			log.Printf("进程交接失败，继续运行: %v", err)
+			return 0, err
+		}
+		log.Printf("新进程 %d 已就绪，本进程停止接受新连接", pid)
+
+		go func() {
+			if adminServer != nil {
+				if err := adminServer.Shutdown(); err != nil {
+					log.Printf("关闭管理API时出错: %v", err)
+				}
+			}
+			proxyServer.Drain(cfg.UpdateDrainTimeout)
+			if err := proxyServer.Shutdown(); err != nil {
+				log.Printf("关闭服务器时出错: %v", err)
+			}
+			wgtunnel.Close()
+			log.Printf("已交接到新进程 %d，旧进程退出", pid)
+			os.Exit(0)
+		}()
+		return pid, nil
+	}
+}
@@ -0,0 +1,631 @@
+// Package admin 提供管理API服务。
+//
+// 本包实现了独立于代理端口的HTTP管理接口，供运维人员在运行时
+// 查询和调整代理服务器状态，例如强制轮换粘性会话的上游代理。
+// 配置了令牌时，除用代理凭据认证的 /admin/me/ 接口外，所有请求都需要携带 Authorization: Bearer <token> 头。
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/pool"
+	"github.com/rfym21/ProxyFlow/internal/server"
+	"github.com/rfym21/ProxyFlow/internal/update"
+)
+
+// Admin 管理API服务。
+type Admin struct {
+	server     *server.Server // 代理服务器
+	token      string         // 访问令牌，为空则不校验
+	httpServer *http.Server   // HTTP服务
+
+	upgrade func() (int, error) // 进程交接，未设置时不提供交接接口
+}
+
+// NewAdmin 创建管理API服务实例。
+//
+// 参数：
+//   - proxyServer: 代理服务器实例
+//   - token: 访问令牌，为空则不校验
+//
+// 返回值：
+//   - *Admin: 管理API服务实例
+func NewAdmin(proxyServer *server.Server, token string) *Admin {
+	a := &Admin{
+		server: proxyServer,
+		token:  token,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/sessions/{id}/rotate", a.handleRotateSession)
+	mux.HandleFunc("GET /admin/tunnels", a.handleTunnels)
+	mux.HandleFunc("GET /admin/ports", a.handlePorts)
+	mux.HandleFunc("POST /admin/ports", a.handleAllocatePort)
+	mux.HandleFunc("DELETE /admin/ports/{port}", a.handleReleasePort)
+	mux.HandleFunc("GET /admin/destinations", a.handleDestinations)
+	mux.HandleFunc("GET /admin/metrics", a.handleMetrics)
+	mux.HandleFunc("GET /admin/maintenance", a.handleGetMaintenance)
+	mux.HandleFunc("GET /admin/budget", a.handleBudget)
+	mux.HandleFunc("GET /admin/traffic", a.handleTraffic)
+	mux.HandleFunc("DELETE /admin/traffic/{user}", a.handleResetTraffic)
+	mux.HandleFunc("GET /admin/schedule", a.handleSchedule)
+	mux.HandleFunc("GET /admin/failover", a.handleFailover)
+	mux.HandleFunc("GET /admin/exits", a.handleExits)
+	mux.HandleFunc("GET /admin/health", a.handleHealth)
+	mux.HandleFunc("GET /admin/reuse", a.handleReuse)
+	mux.HandleFunc("GET /admin/concurrency", a.handleConcurrency)
+	mux.HandleFunc("GET /admin/certs", a.handleCerts)
+	mux.HandleFunc("GET /admin/sources", a.handleSources)
+	mux.HandleFunc("GET /admin/credentials", a.handleGetCredentials)
+	mux.HandleFunc("PUT /admin/credentials", a.handleSetCredentials)
+	mux.HandleFunc("DELETE /admin/credentials", a.handleDeleteCredentials)
+	mux.HandleFunc("DELETE /admin/proxies/{proxy}", a.handleRemoveProxy)
+	mux.HandleFunc("POST /admin/proxies/{proxy}/restore", a.handleRestoreProxy)
+	mux.HandleFunc("GET /admin/drains", a.handleDrains)
+	mux.HandleFunc("GET /admin/shedding", a.handleShedding)
+	mux.HandleFunc("GET /admin/client-limits", a.handleClientLimits)
+	mux.HandleFunc("GET /admin/auth-failures", a.handleAuthFailures)
+	mux.HandleFunc("GET /admin/alerts", a.handleAlerts)
+	mux.HandleFunc("GET /admin/routes", a.handleRoutes)
+	mux.HandleFunc("GET /admin/version", a.handleVersion)
+	mux.HandleFunc("GET /admin/caps", a.handleCaps)
+	mux.HandleFunc("GET /admin/labels", a.handleLabels)
+	mux.HandleFunc("GET /admin/robots", a.handleRobots)
+	mux.HandleFunc("GET /admin/agents", a.handleAgents)
+	mux.HandleFunc("GET /admin/rotation", a.handleRotation)
+	mux.HandleFunc("GET /admin/explain", a.handleExplain)
+	mux.HandleFunc("GET /admin/slo", a.handleSLO)
+	mux.HandleFunc("GET /admin/deployment", a.handleDeployment)
+	mux.HandleFunc("PUT /admin/deployment", a.handleStageDeployment)
+	mux.HandleFunc("POST /admin/deployment/promote", a.handlePromoteDeployment)
+	mux.HandleFunc("POST /admin/deployment/rollback", a.handleRollbackDeployment)
+	mux.HandleFunc("PUT /admin/maintenance", a.handleSetMaintenance)
+	mux.HandleFunc("POST /admin/upgrade", a.handleUpgrade)
+
+	// 用户自助接口用代理凭据认证，不需要管理令牌
+	root := http.NewServeMux()
+	root.Handle("/", a.authorize(mux))
+	root.HandleFunc("GET /admin/me/rotation", a.handleOwnRotation)
+
+	a.httpServer = &http.Server{
+		Handler:           root,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if token == "" {
+		log.Printf("警告: 管理API未设置 ADMIN_TOKEN，任何人都可以访问")
+	}
+	return a
+}
+
+// Handler 返回管理API的请求处理器，用于挂载到其他监听器上。
+func (a *Admin) Handler() http.Handler {
+	return a.httpServer.Handler
+}
+
+// SetUpgrade 设置进程交接函数，供 POST /admin/upgrade 调用。
+//
+// 参数：
+//   - upgrade: 启动新进程并交接监听器，成功时返回新进程的PID
+func (a *Admin) SetUpgrade(upgrade func() (int, error)) {
+	a.upgrade = upgrade
+}
+
+// Start 启动管理API服务并监听指定端口。
+//
+// 参数：
+//   - port: 监听端口号
+//
+// 返回值：
+//   - error: 服务启动错误，正常关闭时为nil
+func (a *Admin) Start(port string) error {
+	listener, err := update.Listen(&net.ListenConfig{}, ":"+port)
+	if err != nil {
+		return err
+	}
+	log.Printf("管理API正在端口 %s 上启动", port)
+
+	err = a.httpServer.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown 关闭管理API服务。
+//
+// 返回值：
+//   - error: 关闭过程中的错误，成功时为nil
+func (a *Admin) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return a.httpServer.Shutdown(ctx)
+}
+
+// authorize 校验访问令牌的中间件。
+func (a *Admin) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "未授权"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleRotateSession 处理粘性会话轮换请求。
+//
+// 解除会话与当前上游代理的绑定并关闭其活跃隧道，
+// 会话的下一个连接将分配到新的上游代理。
+func (a *Admin) handleRotateSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	bound, closed := a.server.RotateSession(id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"session":        id,
+		"was_bound":      bound,
+		"closed_tunnels": closed,
+	})
+}
+
+// handlePorts 返回当前已分配的动态端口。
+func (a *Admin) handlePorts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.DynamicPorts())
+}
+
+// handleAllocatePort 分配一个绑定新粘性会话的动态端口。
+//
+// 请求体为 {"pool": "alt", "ttl": 600}，均可省略（或不带请求体），
+// 省略时使用主代理池和配置的默认有效期（秒）。
+func (a *Admin) handleAllocatePort(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Pool string `json:"pool"`
+		TTL  int    `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "请求体格式错误: " + err.Error()})
+		return
+	}
+	if body.TTL < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "ttl 必须是非负整数"})
+		return
+	}
+	port, err := a.server.AllocatePort(body.Pool, time.Duration(body.TTL)*time.Second)
+	switch {
+	case errors.Is(err, server.ErrDynamicPortsDisabled), errors.Is(err, server.ErrUnknownPool):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusCreated, port)
+	}
+}
+
+// handleReleasePort 提前释放动态端口，轮换其会话并关闭会话的隧道。
+func (a *Admin) handleReleasePort(w http.ResponseWriter, r *http.Request) {
+	port, ok := a.server.ReleasePort(r.PathValue("port"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "动态端口未分配: " + r.PathValue("port")})
+		return
+	}
+	writeJSON(w, http.StatusOK, port)
+}
+
+// handleTunnels 返回活跃隧道统计，流式长连接隧道单独计数。
+func (a *Admin) handleTunnels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.TunnelStats())
+}
+
+// handleDestinations 返回按目标主机聚合的统计。
+//
+// 查询参数 limit 指定返回数量（默认20，0表示全部），
+// sort 指定排序维度（requests、failures、bytes、latency、duplicates，默认requests）。
+func (a *Admin) handleDestinations(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit 必须是非负整数"})
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, a.server.DestinationStats(limit, r.URL.Query().Get("sort")))
+}
+
+// handleMetrics 按Prometheus文本格式返回指标，供Prometheus抓取。
+func (a *Admin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.server.WriteMetrics(w)
+}
+
+// handleGetMaintenance 返回维护模式状态及仍在排空的隧道数。
+func (a *Admin) handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.Maintenance())
+}
+
+// handleSetMaintenance 开启或关闭维护模式。
+//
+// 请求体为 {"enabled": true, "status": 503, "message": "..."}，
+// status和message可省略，省略时使用配置的默认值。
+func (a *Admin) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled bool   `json:"enabled"`
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "请求体格式错误: " + err.Error()})
+		return
+	}
+	if body.Status != 0 && (body.Status < 400 || body.Status > 599) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status 必须是4xx或5xx状态码"})
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.SetMaintenance(body.Enabled, body.Status, body.Message))
+}
+
+// handleTraffic 返回带宽限制及各用户本周期的流量用量。
+func (a *Admin) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.TrafficStatus())
+}
+
+// handleResetTraffic 清零用户本周期的流量用量，用于临时追加配额。
+func (a *Admin) handleResetTraffic(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !a.server.ResetTraffic(user) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "用户没有流量用量记录: " + user})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"user": user})
+}
+
+// handleBudget 返回主代理池本月的流量预算状态。
+func (a *Admin) handleBudget(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.BudgetStatus())
+}
+
+// handleSchedule 返回代理池切换计划及当前生效的规则。
+func (a *Admin) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.ScheduleStatus())
+}
+
+// handleFailover 返回代理池故障转移的当前层和各层健康度。
+func (a *Admin) handleFailover(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.FailoverStatus())
+}
+
+// handleExits 返回各代理池的出口IP唯一性报告，列出共用同一出口IP的代理。
+func (a *Admin) handleExits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.ExitReports())
+}
+
+// handleHealth 返回各代理池中代理的健康统计，分别列出主动检查和实际流量的结果。
+func (a *Admin) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.HealthStats())
+}
+
+// handleReuse 返回各代理池转发HTTP请求时复用上游连接和恢复TLS会话的统计。
+func (a *Admin) handleReuse(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.ReuseStats())
+}
+
+// handleConcurrency 返回各代理池中代理的自适应并发上限、进行中的连接数和延迟。
+func (a *Admin) handleConcurrency(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.ConcurrencyStats())
+}
+
+// handleCerts 返回各代理池通过健康检查观察到的目标TLS证书指纹和证书异常事件。
+func (a *Admin) handleCerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.CertReports())
+}
+
+// handleSources 返回各代理池的代理来源统计，比较API和静态代理列表的获取情况和实际流量成功率。
+func (a *Admin) handleSources(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.SourceStats())
+}
+
+// handleGetCredentials 返回各代理池设置了凭据覆盖的代理地址，不返回凭据本身。
+func (a *Admin) handleGetCredentials(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.CredentialOverrides())
+}
+
+// handleSetCredentials 更新上游代理的凭据。
+//
+// 请求体为 {"pool": "default", "proxy": "1.2.3.4:8080", "username": "...", "password": "..."}，
+// pool省略时为主代理池，proxy省略或为 "*" 时更新该代理池全部代理的凭据。
+func (a *Admin) handleSetCredentials(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Pool     string `json:"pool"`
+		Proxy    string `json:"proxy"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "请求体格式错误: " + err.Error()})
+		return
+	}
+	if body.Username == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "username 不能为空"})
+		return
+	}
+	if body.Proxy == "" {
+		body.Proxy = pool.AllProxies
+	}
+
+	creds := &pool.Credentials{Username: body.Username, Password: body.Password}
+	if err := a.server.SetCredentials(body.Pool, body.Proxy, creds); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"pool": body.Pool, "proxy": body.Proxy})
+}
+
+// handleDeleteCredentials 删除凭据覆盖，恢复使用API返回的凭据。
+//
+// 查询参数 pool 指定代理池（默认主代理池），proxy 指定代理地址（默认 "*"）。
+func (a *Admin) handleDeleteCredentials(w http.ResponseWriter, r *http.Request) {
+	poolName := r.URL.Query().Get("pool")
+	proxy := r.URL.Query().Get("proxy")
+	if proxy == "" {
+		proxy = pool.AllProxies
+	}
+	if err := a.server.SetCredentials(poolName, proxy, nil); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"pool": poolName, "proxy": proxy})
+}
+
+// handleRemoveProxy 移除上游代理并开始排空其隧道。
+//
+// 查询参数 pool 指定代理池（默认主代理池），drain_timeout 指定排空超时秒数
+// （默认使用 DRAIN_TIMEOUT，0表示等待隧道自然结束）。
+func (a *Admin) handleRemoveProxy(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(-1)
+	if value := r.URL.Query().Get("drain_timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "drain_timeout 必须是非负整数"})
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	status, err := a.server.RemoveProxy(r.URL.Query().Get("pool"), r.PathValue("proxy"), timeout)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleRestoreProxy 恢复被移除的上游代理。
+func (a *Admin) handleRestoreProxy(w http.ResponseWriter, r *http.Request) {
+	proxy := r.PathValue("proxy")
+	restored, err := a.server.RestoreProxy(r.URL.Query().Get("pool"), proxy)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"proxy": proxy, "was_removed": restored})
+}
+
+// handleDrains 返回被移除代理的排空进度。
+func (a *Admin) handleDrains(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.Drains())
+}
+
+// handleShedding 返回按优先级的负载削减统计。
+func (a *Admin) handleShedding(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.Shedding())
+}
+
+// handleClientLimits 返回按客户端IP和用户的限流统计。
+func (a *Admin) handleClientLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.ClientLimits())
+}
+
+// handleAuthFailures 返回按原因和监听器统计的认证失败次数。
+func (a *Admin) handleAuthFailures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.AuthFailures())
+}
+
+// handleAlerts 返回可疑目标规则的命中统计和最近的告警事件。
+func (a *Admin) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.Alerts())
+}
+
+// handleRoutes 返回路由规则及各规则的命中次数。
+func (a *Admin) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.RouteStats())
+}
+
+// handleVersion 返回处理流量的程序的构建版本信息。
+func (a *Admin) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.Version())
+}
+
+// handleUpgrade 启动新进程并交接监听器，新进程就绪后返回其PID，本进程随后排空并退出。
+//
+// 通常在 proxyflow update 替换可执行文件后调用，切换到新版本而不中断服务。
+func (a *Admin) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	if a.upgrade == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "当前平台不支持进程交接"})
+		return
+	}
+	pid, err := a.upgrade()
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"pid": pid})
+}
+
+// handleAgents 返回经多路复用传输连接的各客户端代理的统计。
+func (a *Admin) handleAgents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.AgentStats())
+}
+
+// handleRotation 返回用户最近一段时间拿到的不同出口IP数量和分布。
+//
+// 查询参数user指定用户名，省略时返回全部用户；minutes指定统计时长，省略时使用全部保留时长。
+func (a *Admin) handleRotation(w http.ResponseWriter, r *http.Request) {
+	period, ok := rotationPeriod(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.RotationStats(r.URL.Query().Get("user"), period))
+}
+
+// handleOwnRotation 返回请求方本人最近一段时间拿到的不同出口IP数量和分布。
+//
+// 不需要管理令牌，用代理凭据认证：凭据放在 Proxy-Authorization 头或 Authorization: Basic 头中
+// （例如 curl -u user:pass），只返回该用户的记录。查询参数minutes与 /admin/rotation 相同。
+func (a *Admin) handleOwnRotation(w http.ResponseWriter, r *http.Request) {
+	period, ok := rotationPeriod(w, r)
+	if !ok {
+		return
+	}
+	authHeader := r.Header.Get("Proxy-Authorization")
+	if value := r.Header.Get("Authorization"); authHeader == "" && strings.HasPrefix(value, "Basic ") {
+		authHeader = value
+	}
+	stats, ok := a.server.OwnRotationStats(r.RemoteAddr, authHeader, period)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ProxyFlow"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "代理认证失败"})
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// rotationPeriod 解析出口轮换统计的查询参数minutes，无效时返回400。
+func rotationPeriod(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	value := r.URL.Query().Get("minutes")
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "minutes 必须是正整数"})
+		return 0, false
+	}
+	return time.Duration(n) * time.Minute, true
+}
+
+// handleCaps 返回按目标主机的每日请求上限及当日用量。
+func (a *Admin) handleCaps(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.CapStatus())
+}
+
+// handleLabels 返回流量标签规则以及按标签的请求数和流量。
+func (a *Admin) handleLabels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.LabelStatus())
+}
+
+// handleRobots 返回已缓存站点的robots.txt状态和被拒绝的请求数。
+func (a *Admin) handleRobots(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.RobotsStatus())
+}
+
+// handleSLO 返回上游服务水平目标的监控状态，包括各代理池和代理的实际值与最近的告警事件。
+func (a *Admin) handleSLO(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.SLOStatus())
+}
+
+// handleDeployment 返回蓝绿切换状态，试运行期间对比当前代理池和候选代理池的成功率、延迟和健康度。
+func (a *Admin) handleDeployment(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.DeploymentStatus())
+}
+
+// handleStageDeployment 开始或调整候选代理池的试运行。
+//
+// 请求体为 {"candidate": "green", "percent": 10}，candidate 为 POOLS 中配置的代理池，
+// percent 为分给候选代理池的主代理池流量百分比。
+func (a *Admin) handleStageDeployment(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Candidate string `json:"candidate"`
+		Percent   int    `json:"percent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "请求体格式错误: " + err.Error()})
+		return
+	}
+	if body.Candidate == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "candidate 不能为空"})
+		return
+	}
+	status, err := a.server.StageCandidate(body.Candidate, body.Percent)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handlePromoteDeployment 提升候选代理池，由其承载全部主代理池流量。
+func (a *Admin) handlePromoteDeployment(w http.ResponseWriter, r *http.Request) {
+	status, err := a.server.PromoteCandidate()
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleRollbackDeployment 结束候选代理池的试运行，流量全部回到当前代理池。
+func (a *Admin) handleRollbackDeployment(w http.ResponseWriter, r *http.Request) {
+	status, err := a.server.RollbackCandidate()
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleExplain 预演一次请求的路由，返回会使用的代理池和代理以及每一步的原因。
+//
+// 查询参数url必填；user、listener、agent、session、tags可选，分别对应认证用户名、
+// 监听器名称、客户端代理身份、X-Proxy-Session和X-Proxy-Tags。
+func (a *Admin) handleExplain(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	explanation, err := a.server.Explain(server.ExplainRequest{
+		URL:      query.Get("url"),
+		User:     query.Get("user"),
+		Listener: query.Get("listener"),
+		Agent:    query.Get("agent"),
+		Session:  query.Get("session"),
+		Tags:     query.Get("tags"),
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+// writeJSON 以JSON格式写入响应。
+//
+// 参数：
+//   - w: 响应写入器
+//   - status: HTTP状态码
+//   - v: 响应数据
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("写入管理API响应失败: %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+// Package agent 实现ProxyFlow客户端代理模式。
+//
+// 客户端代理运行在应用旁边，对本机应用提供普通HTTP代理，并把每条本地连接
+// 作为一个流经多路复用传输转发到中心ProxyFlow，由中心完成认证和上游选择。
+// 应用只需指向本机代理，中心地址、令牌和TLS等配置集中在客户端代理上，
+// 与中心的连接断开后按指数退避自动重连。
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/mux"
+)
+
+const (
+	// minReconnectDelay 首次重连前的等待时间
+	minReconnectDelay = time.Second
+	// sessionWait 没有可用的多路复用连接时，本地连接等待连接恢复的最长时间
+	sessionWait = 5 * time.Second
+)
+
+// errNoSession 没有可用的多路复用连接
+var errNoSession = errors.New("与中心ProxyFlow的连接不可用")
+
+// Options 客户端代理配置。
+type Options struct {
+	Server         string        // 中心ProxyFlow多路复用端口地址（host:port）
+	Token          string        // 多路复用访问令牌
+	TLS            *tls.Config   // 连接中心使用的TLS配置，nil表示明文
+	Connections    int           // 与中心保持的多路复用连接数，小于1时按1处理
+	DialTimeout    time.Duration // 连接中心的超时时间
+	ReconnectDelay time.Duration // 断线后重连的最长退避时间
+}
+
+// Agent 客户端代理。
+type Agent struct {
+	opts     Options
+	slots    []*slot       // 多路复用连接槽位
+	next     atomic.Uint32 // 轮询起点
+	ready    chan struct{} // 有连接恢复时关闭并替换，用于唤醒等待中的本地连接
+	listener net.Listener  // 本地代理监听器
+	closed   chan struct{} // 关闭信号
+	mutex    sync.Mutex    // 保护ready和listener
+}
+
+// slot 一条与中心保持的多路复用连接。
+type slot struct {
+	session atomic.Pointer[mux.Session] // 当前会话，断线期间为nil
+}
+
+// New 创建客户端代理并开始连接中心。
+//
+// 参数：
+//   - opts: 客户端代理配置
+//
+// 返回值：
+//   - *Agent: 客户端代理
+//   - error: 配置无效
+func New(opts Options) (*Agent, error) {
+	if opts.Server == "" {
+		return nil, errors.New("未配置中心ProxyFlow地址")
+	}
+	if opts.Token == "" {
+		return nil, errors.New("未配置多路复用访问令牌")
+	}
+	a := &Agent{
+		opts:   opts,
+		slots:  make([]*slot, max(opts.Connections, 1)),
+		ready:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	for i := range a.slots {
+		a.slots[i] = &slot{}
+		go a.maintain(a.slots[i])
+	}
+	return a, nil
+}
+
+// TLSConfig 生成连接中心使用的TLS配置。
+//
+// 参数：
+//   - server: 中心地址（host:port）
+//   - serverName: 校验证书时使用的服务器名称，为空则取server中的主机名
+//   - caFile: CA证书文件，为空则使用系统根证书
+//
+// 返回值：
+//   - *tls.Config: TLS配置
+//   - error: 读取或解析CA文件失败
+func TLSConfig(server, serverName, caFile string) (*tls.Config, error) {
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, fmt.Errorf("中心地址格式错误: %v", err)
+		}
+		serverName = host
+	}
+	config := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA文件失败: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA文件中没有有效的证书: %s", caFile)
+		}
+	}
+	return config, nil
+}
+
+// maintain 保持一个槽位的多路复用连接，断开后按指数退避重连。
+func (a *Agent) maintain(s *slot) {
+	delay := minReconnectDelay
+	for {
+		session, err := a.connect()
+		if err != nil {
+			log.Printf("连接中心ProxyFlow失败: %v，%v 后重试", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-a.closed:
+				return
+			}
+			delay = min(delay*2, max(a.opts.ReconnectDelay, minReconnectDelay))
+			continue
+		}
+
+		delay = minReconnectDelay
+		s.session.Store(session)
+		a.signalReady()
+		log.Printf("已连接中心ProxyFlow: %s", a.opts.Server)
+
+		select {
+		case <-session.Closed():
+			s.session.Store(nil)
+			log.Printf("与中心ProxyFlow的连接已断开，正在重连")
+		case <-a.closed:
+			session.Close()
+			return
+		}
+	}
+}
+
+// connect 建立一条多路复用连接。
+func (a *Agent) connect() (*mux.Session, error) {
+	dialer := &net.Dialer{Timeout: a.opts.DialTimeout, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if a.opts.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", a.opts.Server, a.opts.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", a.opts.Server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := mux.ClientHandshake(conn, a.opts.Token); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return mux.Client(conn), nil
+}
+
+// signalReady 唤醒等待连接恢复的本地连接。
+func (a *Agent) signalReady() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	close(a.ready)
+	a.ready = make(chan struct{})
+}
+
+// open 在流数最少的可用连接上打开一个流，没有可用连接时等待连接恢复。
+func (a *Agent) open() (*mux.Stream, error) {
+	deadline := time.After(sessionWait)
+	for {
+		a.mutex.Lock()
+		ready := a.ready
+		a.mutex.Unlock()
+
+		if session := a.pick(); session != nil {
+			stream, err := session.Open()
+			if err == nil {
+				return stream, nil
+			}
+			// 会话恰好断开，稍后由maintain重连，继续尝试其他连接
+			continue
+		}
+		select {
+		case <-ready:
+		case <-deadline:
+			return nil, errNoSession
+		case <-a.closed:
+			return nil, errNoSession
+		}
+	}
+}
+
+// pick 从当前流数最少的可用连接中选择一条。
+func (a *Agent) pick() *mux.Session {
+	var best *mux.Session
+	start := int(a.next.Add(1))
+	for i := range a.slots {
+		session := a.slots[(start+i)%len(a.slots)].session.Load()
+		if session == nil || session.IsClosed() {
+			continue
+		}
+		if best == nil || session.NumStreams() < best.NumStreams() {
+			best = session
+		}
+	}
+	return best
+}
+
+// Start 启动本地代理监听器。
+//
+// 参数：
+//   - addr: 本地监听地址
+//
+// 返回值：
+//   - error: 监听器启动错误或运行中的致命错误，通过Shutdown关闭时为nil
+func (a *Agent) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	a.listener = listener
+	a.mutex.Unlock()
+
+	log.Printf("客户端代理正在 %s 上监听，转发到 %s（%d 条多路复用连接）", addr, a.opts.Server, len(a.slots))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-a.closed:
+				return nil
+			default:
+			}
+			log.Printf("客户端代理接受连接时出错: %v", err)
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+// handle 把一条本地连接转发到中心。
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+
+	stream, err := a.open()
+	if err != nil {
+		body := err.Error() + "\n"
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+		return
+	}
+	defer stream.Close()
+
+	go func() {
+		io.Copy(stream, conn)
+		stream.CloseWrite()
+	}()
+	// 中心关闭流后本地连接随之关闭，另一方向的复制因连接关闭而结束
+	io.Copy(conn, stream)
+}
+
+// Shutdown 关闭本地监听器和全部多路复用连接。
+func (a *Agent) Shutdown() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	select {
+	case <-a.closed:
+		return
+	default:
+	}
+	close(a.closed)
+	if a.listener != nil {
+		a.listener.Close()
+	}
+	for _, s := range a.slots {
+		if session := s.session.Load(); session != nil {
+			session.Close()
+		}
+	}
+}
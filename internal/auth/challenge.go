@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultRealm 未配置时质询使用的realm
+const DefaultRealm = "ProxyFlow"
+
+// Challenge 407响应中的认证质询。
+//
+// 每个认证方案对应一个 Proxy-Authenticate 头，按配置顺序发送。代理只校验Basic凭据，
+// 声明其他方案仅用于兼容要求特定质询的客户端。响应体用于向新接入的用户说明如何获取凭据。
+type Challenge struct {
+	Realm       string   // 质询中的realm
+	Schemes     []string // Proxy-Authenticate中声明的认证方案
+	Body        []byte   // 407响应体，为空则不带响应体
+	ContentType string   // 响应体的Content-Type
+}
+
+// NewChallenge 创建认证质询。
+//
+// 响应体的Content-Type按文件扩展名推断（如 .html、.json），无法推断时按内容检测。
+//
+// 参数：
+//   - realm: 质询中的realm，为空使用 DefaultRealm
+//   - schemes: 认证方案，为空只声明Basic
+//   - bodyFile: 407响应体文件路径，为空则不带响应体
+//
+// 返回值：
+//   - *Challenge: 认证质询
+//   - error: realm或认证方案无效，或读取响应体文件失败
+func NewChallenge(realm string, schemes []string, bodyFile string) (*Challenge, error) {
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	if strings.ContainsFunc(realm, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return nil, errors.New("realm不能包含控制字符")
+	}
+	if len(schemes) == 0 {
+		schemes = []string{"Basic"}
+	}
+	for _, scheme := range schemes {
+		if !isToken(scheme) {
+			return nil, fmt.Errorf("无效的认证方案: %q", scheme)
+		}
+	}
+
+	c := &Challenge{Realm: realm, Schemes: schemes}
+	if bodyFile != "" {
+		body, err := os.ReadFile(bodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取407响应体文件失败: %v", err)
+		}
+		c.Body = body
+		c.ContentType = mime.TypeByExtension(filepath.Ext(bodyFile))
+		if c.ContentType == "" {
+			c.ContentType = http.DetectContentType(body)
+		}
+	}
+	return c, nil
+}
+
+// Headers 返回 Proxy-Authenticate 头的值，每个认证方案一个。
+//
+// 返回值：
+//   - []string: 形如 `Basic realm="ProxyFlow"` 的质询
+func (c *Challenge) Headers() []string {
+	realm := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(c.Realm)
+	headers := make([]string, len(c.Schemes))
+	for i, scheme := range c.Schemes {
+		headers[i] = fmt.Sprintf(`%s realm="%s"`, scheme, realm)
+	}
+	return headers
+}
+
+// isToken 判断字符串是否为HTTP token（RFC 9110）。
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > 0x7e || r <= 0x20 || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
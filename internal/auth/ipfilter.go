@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// IPFilter 按客户端IP限制入站连接。
+//
+// 拒绝列表优先；允许列表非空时只接受其中的客户端。
+type IPFilter struct {
+	allow      []netip.Prefix // 允许的网段，为空表示不限制
+	deny       []netip.Prefix // 拒绝的网段
+	bypassAuth bool           // 允许列表中的客户端未提供凭据时是否免于代理认证
+}
+
+// ParseIPFilter 解析客户端IP访问控制。
+//
+// 每一项可以是单个IP或CIDR网段，如 "203.0.113.7"、"198.51.100.0/24"、"2001:db8::/32"。
+//
+// 参数：
+//   - allow: 允许的IP或网段
+//   - deny: 拒绝的IP或网段
+//   - bypassAuth: 允许列表中的客户端未提供凭据时是否免于代理认证
+//
+// 返回值：
+//   - *IPFilter: 访问控制，两个列表都为空时为nil
+//   - error: IP或网段格式错误，或免认证时没有配置允许列表
+func ParseIPFilter(allow, deny []string, bypassAuth bool) (*IPFilter, error) {
+	allowed, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, err
+	}
+	if bypassAuth && len(allowed) == 0 {
+		return nil, fmt.Errorf("允许列表中的客户端免认证需要配置允许列表")
+	}
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	return &IPFilter{allow: allowed, deny: denied, bypassAuth: bypassAuth}, nil
+}
+
+// parsePrefixes 解析IP或CIDR网段列表，单个IP视为只含该地址的网段。
+func parsePrefixes(items []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("无效的CIDR网段: %s", item)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的IP地址: %s", item)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// clientAddr 从 host:port 或单独的IP中解析客户端IP。
+func clientAddr(client string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// containsAddr 判断地址是否落在任意一个网段中。
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits 判断是否接受来自该客户端的连接。
+//
+// 参数：
+//   - client: 客户端地址（host:port 或IP）
+//
+// 返回值：
+//   - bool: 是否接受，未配置访问控制时始终为true；无法解析的地址在配置了访问控制时拒绝
+func (f *IPFilter) Permits(client string) bool {
+	if f == nil {
+		return true
+	}
+	addr, ok := clientAddr(client)
+	if !ok || containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// BypassesAuth 判断来自该客户端且未提供凭据的请求是否免于代理认证。
+//
+// 参数：
+//   - client: 客户端地址（host:port 或IP）
+//
+// 返回值：
+//   - bool: 启用了免认证且客户端在允许列表中（且不在拒绝列表中）时为true
+func (f *IPFilter) BypassesAuth(client string) bool {
+	return f != nil && f.bypassAuth && len(f.allow) > 0 && f.Permits(client)
+}
+
+// String 返回访问控制的摘要，用于启动日志。
+func (f *IPFilter) String() string {
+	if f == nil {
+		return "未启用"
+	}
+	summary := fmt.Sprintf("允许 %d 个网段，拒绝 %d 个网段", len(f.allow), len(f.deny))
+	if len(f.allow) == 0 {
+		summary = fmt.Sprintf("不限制允许的网段，拒绝 %d 个网段", len(f.deny))
+	}
+	if f.bypassAuth {
+		summary += "，允许列表中的客户端免认证"
+	}
+	return summary
+}
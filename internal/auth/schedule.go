@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/daytime"
+)
+
+// AccessHours 用户每天允许使用代理的时间段。
+type AccessHours struct {
+	spec     string           // 原始配置，用于提示信息
+	windows  []daytime.Window // 允许访问的时间段，任意一个覆盖当前时刻即允许
+	location *time.Location   // 时间段所在的时区
+}
+
+// AccessSchedules 按用户名索引的访问时间段，未配置的用户不受限制。
+type AccessSchedules map[string]*AccessHours
+
+// ParseAccessHours 解析访问时间段。
+//
+// 时间段之间用逗号分隔，形如 "09:00-18:00" 或 "22:00-06:00"（跨越午夜），
+// 末尾可以用空格隔开一个IANA时区名称，省略时按UTC计算，例如 "09:00-12:00,13:00-18:00 Asia/Shanghai"。
+//
+// 参数：
+//   - spec: 访问时间段配置
+//
+// 返回值：
+//   - *AccessHours: 访问时间段
+//   - error: 格式错误或时区不存在
+func ParseAccessHours(spec string) (*AccessHours, error) {
+	spec = strings.TrimSpace(spec)
+	hours := &AccessHours{spec: spec, location: time.UTC}
+
+	windows, zone, hasZone := strings.Cut(spec, " ")
+	if hasZone {
+		location, err := time.LoadLocation(strings.TrimSpace(zone))
+		if err != nil {
+			return nil, fmt.Errorf("访问时间段的时区无效: %s", zone)
+		}
+		hours.location = location
+	}
+	for _, item := range strings.Split(windows, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		window, err := daytime.ParseWindow(item)
+		if err != nil {
+			return nil, fmt.Errorf("访问时间段无效: %w", err)
+		}
+		hours.windows = append(hours.windows, window)
+	}
+	if len(hours.windows) == 0 {
+		return nil, fmt.Errorf("访问时间段为空: %s", spec)
+	}
+	if !hasZone {
+		hours.spec += " UTC"
+	}
+	return hours, nil
+}
+
+// ParseAccessSchedules 解析按用户名配置的访问时间段。
+//
+// 参数：
+//   - specs: 用户名到访问时间段配置的映射
+//
+// 返回值：
+//   - AccessSchedules: 按用户名索引的访问时间段，没有配置时为nil
+//   - error: 任一用户的配置格式错误
+func ParseAccessSchedules(specs map[string]string) (AccessSchedules, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	schedules := make(AccessSchedules, len(specs))
+	for username, spec := range specs {
+		hours, err := ParseAccessHours(spec)
+		if err != nil {
+			return nil, fmt.Errorf("用户 %s: %w", username, err)
+		}
+		schedules[username] = hours
+	}
+	return schedules, nil
+}
+
+// Check 检查用户在指定时刻是否允许使用代理。
+//
+// 参数：
+//   - username: 用户名
+//   - now: 当前时间
+//
+// 返回值：
+//   - error: 不在允许的时间段内时返回说明原因的错误，未配置时间段的用户始终为nil
+func (s AccessSchedules) Check(username string, now time.Time) error {
+	hours, ok := s[username]
+	if !ok {
+		return nil
+	}
+	local := now.In(hours.location)
+	minute := daytime.Minute(local)
+	for _, window := range hours.windows {
+		if window.Contains(minute) {
+			return nil
+		}
+	}
+	return fmt.Errorf("用户 %s 只允许在 %s 使用代理，当前时间为 %s",
+		username, hours.spec, local.Format("15:04 MST"))
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// credential 单个用户的密码。
+type credential struct {
+	hash  string // 密码哈希，格式为bcrypt（$2a$/$2b$/$2y$）、{SHA}或明文
+	plain bool   // 是否为不按前缀识别格式的明文密码（AUTH_PASSWORD）
+}
+
+// matches 判断密码是否与用户的密码哈希匹配。
+func (c credential) matches(password string) bool {
+	switch {
+	case c.plain:
+		return subtle.ConstantTimeCompare([]byte(c.hash), []byte(password)) == 1
+	case isBcrypt(c.hash):
+		return bcrypt.CompareHashAndPassword([]byte(c.hash), []byte(password)) == nil
+	case strings.HasPrefix(c.hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(c.hash), []byte(expected)) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(c.hash), []byte(password)) == 1
+}
+
+// isBcrypt 判断密码哈希是否为bcrypt格式。
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// parseCredential 解析 "用户名:密码哈希" 格式的用户条目。
+//
+// 参数：
+//   - entry: 用户条目
+//
+// 返回值：
+//   - string: 用户名
+//   - credential: 用户的密码
+//   - error: 格式错误或密码哈希格式不受支持
+func parseCredential(entry string) (string, credential, error) {
+	username, hash, ok := strings.Cut(entry, ":")
+	if !ok || username == "" || hash == "" {
+		return "", credential{}, fmt.Errorf("用户条目格式错误，应为 用户名:密码哈希")
+	}
+	switch {
+	case isBcrypt(hash):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return "", credential{}, fmt.Errorf("用户 %s 的bcrypt哈希无效: %v", username, err)
+		}
+	case strings.HasPrefix(hash, "$"):
+		return "", credential{}, fmt.Errorf("用户 %s 的密码哈希格式不受支持，只支持bcrypt、{SHA}和明文", username)
+	}
+	return username, credential{hash: hash}, nil
+}
+
+// userSet 某一时刻的用户列表，重新加载时整体替换。
+type userSet struct {
+	users    map[string]credential // 按用户名索引的密码
+	verified map[string][32]byte   // 用户最近一次验证通过的密码的SHA-256，避免每次请求都计算bcrypt
+	gen      uint64                // 用户列表的代数，每次重新加载加一
+	mutex    sync.Mutex            // 保护verified
+}
+
+// Users 代理服务器的认证用户。
+//
+// 用户来自环境变量中的用户列表和htpasswd格式的用户文件，文件按修改时间和大小定期检查，
+// 变化后重新加载，读取失败时继续使用原列表。每个用户验证通过的密码会被记住，
+// 同一密码再次验证时不再计算bcrypt，重新加载后清空。缓存认证结果的调用方用 Generation
+// 判断用户列表是否已重新加载，重新加载后不能继续沿用之前的结果。
+type Users struct {
+	path     string                  // 用户文件路径，为空表示没有用户文件
+	inline   map[string]credential   // 环境变量中的用户，文件中的同名用户优先
+	interval time.Duration           // 检查用户文件的间隔，0表示不重新加载
+	set      atomic.Pointer[userSet] // 当前用户列表
+	stop     chan struct{}           // 关闭时停止检查用户文件
+
+	modTime  time.Time // 最近一次成功读取时文件的修改时间，只由检查方访问
+	fileSize int64     // 最近一次成功读取时文件的大小，只由检查方访问
+	stopOnce sync.Once // 保证只关闭一次
+}
+
+// NewUsers 加载认证用户，配置了用户文件且重新加载间隔大于0时开始定期检查文件。
+//
+// 用户文件每行一个 "用户名:密码哈希"，与 htpasswd -B 生成的格式相同，空行和 # 开头的行被忽略。
+// 密码哈希支持bcrypt（$2a$/$2b$/$2y$）、{SHA}（htpasswd -s）和明文，其他以 $ 开头的格式（如apr1）被拒绝。
+//
+// 参数：
+//   - path: 用户文件路径，为空表示没有用户文件
+//   - entries: "用户名:密码哈希" 格式的用户列表
+//   - username: 单个用户的用户名（AUTH_USERNAME），为空表示没有
+//   - password: 单个用户的明文密码（AUTH_PASSWORD）
+//   - interval: 检查用户文件的间隔，0表示不重新加载
+//
+// 返回值：
+//   - *Users: 认证用户，没有配置任何用户时为nil
+//   - error: 用户条目格式错误或无法读取用户文件
+func NewUsers(path string, entries []string, username, password string, interval time.Duration) (*Users, error) {
+	inline := make(map[string]credential)
+	for _, entry := range entries {
+		name, cred, err := parseCredential(entry)
+		if err != nil {
+			return nil, err
+		}
+		inline[name] = cred
+	}
+	if username != "" || password != "" {
+		inline[username] = credential{hash: password, plain: true}
+	}
+	if path == "" && len(inline) == 0 {
+		return nil, nil
+	}
+
+	u := &Users{path: path, inline: inline, interval: interval, stop: make(chan struct{})}
+	if err := u.load(); err != nil {
+		return nil, err
+	}
+	if path != "" && interval > 0 {
+		go u.watch()
+	}
+	return u, nil
+}
+
+// load 读取用户文件并与环境变量中的用户合并，替换当前用户列表。
+func (u *Users) load() error {
+	users := make(map[string]credential, len(u.inline))
+	for name, cred := range u.inline {
+		users[name] = cred
+	}
+	if u.path != "" {
+		info, err := os.Stat(u.path)
+		if err != nil {
+			return fmt.Errorf("读取认证用户文件失败: %v", err)
+		}
+		file, err := os.Open(u.path)
+		if err != nil {
+			return fmt.Errorf("读取认证用户文件失败: %v", err)
+		}
+		defer file.Close()
+		if err := readUsers(file, users); err != nil {
+			return fmt.Errorf("%w: %s", err, u.path)
+		}
+		u.modTime, u.fileSize = info.ModTime(), info.Size()
+	}
+	set := &userSet{users: users, verified: make(map[string][32]byte)}
+	if previous := u.set.Load(); previous != nil {
+		set.gen = previous.gen + 1
+	}
+	u.set.Store(set)
+	return nil
+}
+
+// readUsers 逐行解析用户文件，加入用户列表。
+func readUsers(r io.Reader, users map[string]credential) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, cred, err := parseCredential(text)
+		if err != nil {
+			return fmt.Errorf("认证用户文件第 %d 行: %v", line, err)
+		}
+		users[name] = cred
+	}
+	return scanner.Err()
+}
+
+// changed 用户文件的修改时间或大小变化、或者无法读取文件信息时返回true。
+func (u *Users) changed() bool {
+	info, err := os.Stat(u.path)
+	return err != nil || !info.ModTime().Equal(u.modTime) || info.Size() != u.fileSize
+}
+
+// watch 定期检查用户文件是否变化，变化时重新加载。
+//
+// 读取失败过的文件也会在下一次检查时重试，同一错误只输出一次日志。
+func (u *Users) watch() {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	var lastErr string
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !u.changed() && lastErr == "" {
+			continue
+		}
+		previous := u.Len()
+		if err := u.load(); err != nil {
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				log.Printf("重新加载认证用户失败，继续使用原列表: %v", err)
+			}
+			continue
+		}
+		lastErr = ""
+		log.Printf("认证用户文件 %s 已重新加载: %d 个用户（原 %d 个）", u.path, u.Len(), previous)
+	}
+}
+
+// Verify 验证用户名和密码。
+//
+// 参数：
+//   - username: 用户名
+//   - password: 密码
+//
+// 返回值：
+//   - bool: 用户存在且密码正确
+func (u *Users) Verify(username, password string) bool {
+	set := u.set.Load()
+	cred, ok := set.users[username]
+	if !ok {
+		return false
+	}
+	sum := sha256.Sum256([]byte(password))
+
+	set.mutex.Lock()
+	last, seen := set.verified[username]
+	set.mutex.Unlock()
+	if seen && subtle.ConstantTimeCompare(last[:], sum[:]) == 1 {
+		return true
+	}
+	if !cred.matches(password) {
+		return false
+	}
+	set.mutex.Lock()
+	set.verified[username] = sum
+	set.mutex.Unlock()
+	return true
+}
+
+// Generation 返回用户列表的代数，用户列表每次重新加载后改变。
+//
+// 返回值：
+//   - uint64: 当前用户列表的代数，未配置认证时为0
+func (u *Users) Generation() uint64 {
+	if u == nil {
+		return 0
+	}
+	return u.set.Load().gen
+}
+
+// Len 返回当前的用户数。
+func (u *Users) Len() int {
+	return len(u.set.Load().users)
+}
+
+// Close 停止检查用户文件，可以重复调用。
+func (u *Users) Close() {
+	if u == nil {
+		return
+	}
+	u.stopOnce.Do(func() { close(u.stop) })
+}
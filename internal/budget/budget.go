@@ -0,0 +1,335 @@
+// Package budget 提供上游代理流量预算管理功能。
+//
+// 住宅代理等按流量计费的供应商容易产生意外的超额账单。本包按自然月
+// 统计经由主代理池传输的字节数，用量达到预算后由调用方按配置的策略
+// 处理：阻止流量、切换到备用代理池或仅告警。用量可持久化到文件，
+// 重启后继续累计。
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 预算用尽后的处理策略
+const (
+	ActionBlock    = "block"    // 拒绝新请求
+	ActionFallback = "fallback" // 切换到备用代理池
+	ActionAlert    = "alert"    // 仅记录告警，继续放行
+)
+
+// alertRatio 用量达到预算的该比例时提前告警
+const alertRatio = 0.8
+
+// Options 流量预算配置。
+type Options struct {
+	Limit        int64         // 每月字节数预算，0表示不启用
+	Action       string        // 预算用尽后的处理策略
+	StateFile    string        // 用量持久化文件路径，为空则不持久化
+	SaveInterval time.Duration // 用量持久化间隔，0表示每分钟
+}
+
+// Status 流量预算状态。
+type Status struct {
+	Enabled   bool    `json:"enabled"`   // 是否启用
+	Period    string  `json:"period"`    // 统计周期（YYYY-MM）
+	Limit     int64   `json:"limit"`     // 每月字节数预算
+	Used      int64   `json:"used"`      // 本月已用字节数
+	Ratio     float64 `json:"ratio"`     // 已用比例
+	Action    string  `json:"action"`    // 预算用尽后的处理策略
+	Exhausted bool    `json:"exhausted"` // 预算是否已用尽
+}
+
+// state 持久化文件内容。
+type state struct {
+	Period string `json:"period"`
+	Used   int64  `json:"used"`
+}
+
+// Budget 按自然月统计的流量预算。
+type Budget struct {
+	opts   Options       // 预算配置
+	used   atomic.Int64  // 本月已用字节数
+	period string        // 当前统计周期
+	warned bool          // 本周期是否已发出提前告警
+	spent  bool          // 本周期是否已发出用尽告警
+	mutex  sync.Mutex    // 保护统计周期和告警状态
+	stop   chan struct{} // 停止持久化的信号
+}
+
+// New 创建流量预算实例，并从持久化文件恢复本月用量。
+//
+// 参数：
+//   - opts: 流量预算配置
+//
+// 返回值：
+//   - *Budget: 流量预算实例，未启用时为nil
+//   - error: 配置错误或读取持久化文件失败
+func New(opts Options) (*Budget, error) {
+	if opts.Limit <= 0 {
+		return nil, nil
+	}
+	switch opts.Action {
+	case ActionBlock, ActionFallback, ActionAlert:
+	default:
+		return nil, fmt.Errorf("未知的流量预算策略: %s", opts.Action)
+	}
+
+	if opts.SaveInterval <= 0 {
+		opts.SaveInterval = time.Minute
+	}
+
+	b := &Budget{opts: opts, period: currentPeriod(), stop: make(chan struct{})}
+	if opts.StateFile != "" {
+		if err := b.load(); err != nil {
+			return nil, err
+		}
+		go b.persist()
+	}
+
+	log.Printf("流量预算已启用: 每月 %s，已用 %s，用尽后策略: %s",
+		FormatBytes(opts.Limit), FormatBytes(b.used.Load()), opts.Action)
+	return b, nil
+}
+
+// Action 返回预算用尽后的处理策略，未启用时为空。
+func (b *Budget) Action() string {
+	if b == nil {
+		return ""
+	}
+	return b.opts.Action
+}
+
+// Add 累加已用字节数，并在跨月时重置用量。
+//
+// 参数：
+//   - n: 字节数
+func (b *Budget) Add(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.rollover()
+	used := b.used.Add(n)
+
+	ratio := float64(used) / float64(b.opts.Limit)
+	if ratio < alertRatio {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ratio >= 1 && !b.spent {
+		b.spent = true
+		log.Printf("告警: 本月流量预算已用尽 (%s / %s)，后续按策略 %s 处理",
+			FormatBytes(used), FormatBytes(b.opts.Limit), b.opts.Action)
+	} else if !b.warned {
+		b.warned = true
+		log.Printf("告警: 本月流量已使用预算的 %.0f%% (%s / %s)",
+			ratio*100, FormatBytes(used), FormatBytes(b.opts.Limit))
+	}
+}
+
+// Exhausted 判断本月预算是否已用尽。
+func (b *Budget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.rollover()
+	return b.used.Load() >= b.opts.Limit
+}
+
+// ResetIn 返回距离下一个统计周期开始、预算重置的时间。
+func (b *Budget) ResetIn() time.Duration {
+	now := time.Now().UTC()
+	next := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	return next.Sub(now)
+}
+
+// Status 获取流量预算状态。
+func (b *Budget) Status() Status {
+	if b == nil {
+		return Status{}
+	}
+	b.rollover()
+	b.mutex.Lock()
+	period := b.period
+	b.mutex.Unlock()
+
+	used := b.used.Load()
+	return Status{
+		Enabled:   true,
+		Period:    period,
+		Limit:     b.opts.Limit,
+		Used:      used,
+		Ratio:     float64(used) / float64(b.opts.Limit),
+		Action:    b.opts.Action,
+		Exhausted: used >= b.opts.Limit,
+	}
+}
+
+// WrapConn 包装连接，读写的字节数计入预算。
+func (b *Budget) WrapConn(conn net.Conn) net.Conn {
+	if b == nil {
+		return conn
+	}
+	return &countingConn{Conn: conn, budget: b}
+}
+
+// WrapBody 包装响应体，读取的字节数计入预算。
+func (b *Budget) WrapBody(body io.ReadCloser) io.ReadCloser {
+	if b == nil {
+		return body
+	}
+	return &countingBody{ReadCloser: body, budget: b}
+}
+
+// Close 停止定期持久化并保存最终用量。
+func (b *Budget) Close() error {
+	if b == nil || b.opts.StateFile == "" {
+		return nil
+	}
+	close(b.stop)
+	return b.save()
+}
+
+// rollover 进入新的自然月时重置用量和告警状态。
+func (b *Budget) rollover() {
+	period := currentPeriod()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if period == b.period {
+		return
+	}
+	log.Printf("流量预算进入新周期 %s，上一周期 %s 用量 %s", period, b.period, FormatBytes(b.used.Load()))
+	b.period = period
+	b.used.Store(0)
+	b.warned = false
+	b.spent = false
+}
+
+// load 从持久化文件恢复本月用量，文件不存在或属于其他月份时从0开始。
+func (b *Budget) load() error {
+	data, err := os.ReadFile(b.opts.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取流量用量文件失败: %v", err)
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("解析流量用量文件失败: %v", err)
+	}
+	if s.Period == b.period {
+		b.used.Store(s.Used)
+	}
+	return nil
+}
+
+// save 将当前用量写入持久化文件。
+func (b *Budget) save() error {
+	b.mutex.Lock()
+	data, err := json.Marshal(state{Period: b.period, Used: b.used.Load()})
+	b.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := b.opts.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.opts.StateFile)
+}
+
+// persist 定期保存用量，直到Close被调用。
+func (b *Budget) persist() {
+	ticker := time.NewTicker(b.opts.SaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.rollover()
+			if err := b.save(); err != nil {
+				log.Printf("保存流量用量失败: %v", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// currentPeriod 返回当前统计周期（UTC自然月）。
+func currentPeriod() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+// FormatBytes 将字节数格式化为易读的字符串。
+//
+// 参数：
+//   - n: 字节数
+//
+// 返回值：
+//   - string: 形如 "1.50 GB" 的字符串
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		value /= unit
+		if value < unit || suffix == "TB" {
+			return fmt.Sprintf("%.2f %s", value, suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// countingConn 读写字节数计入预算的连接。
+type countingConn struct {
+	net.Conn
+	budget *Budget
+}
+
+// Read 读取数据并计入预算。
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.budget.Add(int64(n))
+	return n, err
+}
+
+// Write 写入数据并计入预算。
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.budget.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite 关闭发送方向，底层连接支持半关闭时生效。
+func (c *countingConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
+
+// countingBody 读取字节数计入预算的响应体。
+type countingBody struct {
+	io.ReadCloser
+	budget *Budget
+}
+
+// Read 读取数据并计入预算。
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.budget.Add(int64(n))
+	return n, err
+}
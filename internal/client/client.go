@@ -6,13 +6,20 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/dialer"
 	"github.com/rfym21/ProxyFlow/internal/models"
 	"github.com/rfym21/ProxyFlow/internal/pool"
 )
@@ -39,77 +46,190 @@ func (t *proxyAuthTransport) RoundTrip(req *http.Request) (*http.Response, error
 	return t.base.RoundTrip(req)
 }
 
+// CloseIdleConnections 关闭底层传输层的空闲连接。
+func (t *proxyAuthTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// cachedClient 缓存的HTTP客户端及创建时使用的凭据。
+type cachedClient struct {
+	client   *http.Client // HTTP客户端
+	username string       // 创建时的认证用户名
+	password string       // 创建时的认证密码
+}
+
+// matches 判断缓存的客户端是否使用代理当前的凭据。
+func (c *cachedClient) matches(proxy models.ProxyInfo) bool {
+	return c.username == proxy.Username && c.password == proxy.Password
+}
+
 // Client HTTP客户端连接池管理器。
 //
 // 管理多个代理服务器的HTTP客户端实例，提供连接复用、
 // 负载均衡和认证管理等功能。每个代理对应一个独立的
 // HTTP客户端实例，含有专门的连接池配置。
 type Client struct {
-	pool       *pool.Pool              // 代理池
-	clients    map[string]*http.Client // 每个代理的HTTP客户端
-	clientsMux sync.RWMutex            // 客户端映射锁
-	timeout    time.Duration           // 请求超时时间
+	pool       *pool.Pool               // 代理池
+	clients    map[string]*cachedClient // 每个代理的HTTP客户端
+	clientsMux sync.RWMutex             // 客户端映射锁
+	timeout    time.Duration            // 请求超时时间
+	strictDNS  bool                     // 严格DNS模式，禁止绕过上游代理直连目标
+
+	maxHeaderBytes int64 // 上游响应头最大字节数，0表示使用默认值
+	maxHeaders     int   // 上游响应头最大数量，0表示不限制
+
+	observe func(proxy string, latency time.Duration, err error) // 每次尝试的结果回调，nil表示不回调
+	retry   RetryPolicy                                          // 请求失败时的重试策略
+
+	reuse    *reuseTracker          // 按代理的连接复用和TLS会话恢复统计
+	sessions tls.ClientSessionCache // 与HTTPS上游代理的TLS会话缓存，重建客户端后仍可恢复会话
+}
+
+// Options HTTP客户端管理器配置。
+type Options struct {
+	Timeout   time.Duration // HTTP请求超时时间
+	StrictDNS bool          // 严格DNS模式，只允许连接上游代理本身
+
+	MaxResponseHeaderBytes int64 // 上游响应头最大字节数，0表示使用标准库默认值
+	MaxResponseHeaders     int   // 上游响应头最大数量，0表示不限制
+
+	Observe func(proxy string, latency time.Duration, err error) // 每次尝试经由代理发送请求后回调，latency为收到响应头的耗时
+	Retry   RetryPolicy                                          // 请求失败时改用其他代理重试的策略
 }
 
 // NewClient 创建新的HTTP客户端管理器实例。
 //
 // 参数：
 //   - proxyPool: 代理池实例，用于提供可用的代理服务器
-//   - timeout: HTTP请求超时时间
+//   - opts: 客户端管理器配置
 //
 // 返回值：
 //   - *Client: 初始化完成的客户端管理器实例
-func NewClient(proxyPool *pool.Pool, timeout time.Duration) *Client {
+func NewClient(proxyPool *pool.Pool, opts Options) *Client {
 	return &Client{
-		pool:    proxyPool,
-		clients: make(map[string]*http.Client),
-		timeout: timeout,
+		pool:      proxyPool,
+		clients:   make(map[string]*cachedClient),
+		timeout:   opts.Timeout,
+		strictDNS: opts.StrictDNS,
+
+		maxHeaderBytes: opts.MaxResponseHeaderBytes,
+		maxHeaders:     opts.MaxResponseHeaders,
+
+		observe: opts.Observe,
+		retry:   opts.Retry,
+
+		reuse:    newReuseTracker(),
+		sessions: tls.NewLRUClientSessionCache(maxReuseProxies),
 	}
 }
 
 // Do 通过代理服务器执行HTTP请求。
 //
-// 尝试使用代理池中的所有代理服务器执行请求，直到成功或全部失败。
-// 使用轮询机制选择代理，确保负载均衡。
+// 按重试策略依次尝试代理池中的代理，直到成功、请求不可重试或达到最多尝试次数。
+// 使用代理池的选择策略选择代理，确保负载均衡。
 //
 // 参数：
 //   - req: 要执行的HTTP请求
+//   - sel: 代理选择条件
 //
 // 返回值：
 //   - *http.Response: HTTP响应实例
 //   - models.ProxyInfo: 成功使用的代理服务器信息
 //   - error: 请求执行错误，成功时为nil
-func (c *Client) Do(req *http.Request) (*http.Response, models.ProxyInfo, error) {
+func (c *Client) Do(req *http.Request, sel pool.Selection) (*http.Response, models.ProxyInfo, error) {
 	if c.pool.Size() == 0 {
 		return nil, models.ProxyInfo{}, fmt.Errorf("没有可用的代理")
 	}
 
-	// 尝试所有代理
 	var lastErr error
-	for i := 0; i < c.pool.Size(); i++ {
-		proxy := c.pool.NextProxy()
-		if proxy.Host == "" {
+	attempts := c.Attempts()
+	for i := 0; i < attempts; i++ {
+		proxy, err := c.pool.Select(sel)
+		if err != nil {
+			lastErr = err
 			continue
 		}
 
-		// 获取或创建对应的HTTP客户端
+		attemptReq := req
+		if i > 0 {
+			if attemptReq, err = rewind(req); err != nil {
+				return nil, models.ProxyInfo{}, fmt.Errorf("重试前重新读取请求体失败: %w", err)
+			}
+		}
+
+		// 获取或创建对应的HTTP客户端，记录请求复用连接还是新建连接
 		client := c.getClient(proxy)
+		attemptReq = attemptReq.WithContext(httptrace.WithClientTrace(attemptReq.Context(), c.reuse.trace(proxy.Host, proxy.URL.Scheme == "https")))
 
-		// 执行请求
-		resp, err := client.Do(req)
+		// 执行请求，结果计入代理的被动健康状态，进行中的请求计入代理负载直到响应体关闭
+		release := c.pool.Acquire(proxy.Host)
+		start := time.Now()
+		resp, err := c.roundTrip(client, attemptReq)
+		c.pool.ReportOutcome(proxy.Host, err)
+		if c.observe != nil {
+			c.observe(proxy.Host, time.Since(start), err)
+		}
 		if err == nil {
-			return resp, proxy, nil
+			c.pool.ObserveLatency(proxy.Host, time.Since(start))
+			if release != nil {
+				resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+			}
+			if err = c.checkResponse(resp); err != nil {
+				resp.Body.Close()
+				log.Printf("丢弃代理 %s 返回的异常响应: %v", proxy.Host, err)
+			} else if !c.retry.RetryOnStatus[resp.StatusCode] || i == attempts-1 || !c.canRetry(req, nil) {
+				return resp, proxy, nil
+			} else {
+				resp.Body.Close()
+				log.Printf("代理 %s 返回状态码 %d，改用其他代理重试 %s %s", proxy.Host, resp.StatusCode, req.Method, req.URL.Host)
+				err = fmt.Errorf("上游返回状态码 %d", resp.StatusCode)
+			}
+		} else if release != nil {
+			release()
 		}
 		lastErr = err
+		if req.Context().Err() != nil || !c.canRetry(req, err) {
+			break
+		}
 	}
 
-	return nil, models.ProxyInfo{}, fmt.Errorf("所有代理都失败了，最后错误: %v", lastErr)
+	return nil, models.ProxyInfo{}, fmt.Errorf("所有代理都失败了，最后错误: %w", lastErr)
+}
+
+// checkResponse 检查上游响应是否合理，防止异常或恶意的上游代理影响客户端。
+//
+// 响应头的字节数由传输层限制，这里检查头部数量以及
+// 标准库解析器允许但无法安全转发的内容。
+//
+// 参数：
+//   - resp: 上游响应
+//
+// 返回值：
+//   - error: 响应不合理的原因，合理时为nil
+func (c *Client) checkResponse(resp *http.Response) error {
+	count := 0
+	for name, values := range resp.Header {
+		count += len(values)
+		if name == "" {
+			return fmt.Errorf("上游响应包含空的头部名称")
+		}
+	}
+	if c.maxHeaders > 0 && count > c.maxHeaders {
+		return fmt.Errorf("上游响应头数量 %d 超过上限 %d", count, c.maxHeaders)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 599 {
+		return fmt.Errorf("上游响应状态码 %d 无效", resp.StatusCode)
+	}
+	return nil
 }
 
 // getClient 获取或创建指定代理的HTTP客户端。
 //
 // 使用双重检查锁定模式确保线程安全，避免重复创建客户端。
-// 如果对应的客户端不存在，则创建新的客户端实例。
+// 如果对应的客户端不存在，或缓存的客户端使用的是旧凭据，则创建新的客户端实例，
+// 旧客户端的空闲连接随之关闭。
 //
 // 参数：
 //   - proxy: 代理服务器信息
@@ -121,9 +241,9 @@ func (c *Client) getClient(proxy models.ProxyInfo) *http.Client {
 
 	// 先尝试读锁获取现有客户端
 	c.clientsMux.RLock()
-	if client, exists := c.clients[proxyKey]; exists {
+	if cached, exists := c.clients[proxyKey]; exists && cached.matches(proxy) {
 		c.clientsMux.RUnlock()
-		return client
+		return cached.client
 	}
 	c.clientsMux.RUnlock()
 
@@ -132,13 +252,18 @@ func (c *Client) getClient(proxy models.ProxyInfo) *http.Client {
 	defer c.clientsMux.Unlock()
 
 	// 双重检查，防止并发创建
-	if client, exists := c.clients[proxyKey]; exists {
-		return client
+	cached, exists := c.clients[proxyKey]
+	if exists && cached.matches(proxy) {
+		return cached.client
+	}
+	if exists {
+		log.Printf("代理 %s 的凭据已变更，重建HTTP客户端", proxyKey)
+		cached.client.CloseIdleConnections()
 	}
 
 	// 创建新的HTTP客户端
 	client := c.createClient(proxy)
-	c.clients[proxyKey] = client
+	c.clients[proxyKey] = &cachedClient{client: client, username: proxy.Username, password: proxy.Password}
 
 	return client
 }
@@ -146,7 +271,7 @@ func (c *Client) getClient(proxy models.ProxyInfo) *http.Client {
 // createClient 创建新的HTTP客户端实例。
 //
 // 根据代理信息配置客户端，设置代理URL、认证信息、
-// 连接池参数和超时配置。支持HTTP和HTTPS代理。
+// 连接池参数和超时配置。支持HTTP、HTTPS、SSH、WireGuard和Shadowsocks上游。
 //
 // 参数：
 //   - proxy: 代理服务器信息
@@ -172,6 +297,32 @@ func (c *Client) createClient(proxy models.ProxyInfo) *http.Client {
 		MaxIdleConnsPerHost: 100,
 		IdleConnTimeout:     90 * time.Second,
 		DisableKeepAlives:   false,
+
+		MaxResponseHeaderBytes: c.maxHeaderBytes,
+	}
+
+	// 严格DNS模式下只允许连接上游代理本身，目标主机名始终交给上游代理解析
+	if c.strictDNS {
+		transport.DialContext = strictDialContext(proxy.Host)
+	}
+
+	// 配置了代理链时经代理链连接上游代理，同样只允许连接上游代理本身
+	if len(proxy.Chain) > 0 {
+		transport.DialContext = dialer.TransportDialProxy(proxy)
+	}
+
+	// 注册了拨号器的上游协议直接经拨号器连接目标，目标主机名同样由上游解析
+	if d, ok := dialer.Lookup(proxy); ok {
+		transport.Proxy = nil
+		transport.DialContext = dialer.TransportDial(d, proxy)
+		return &http.Client{Transport: transport, Timeout: c.timeout}
+	}
+
+	// HTTPS上游代理使用共享的会话缓存，新建连接时可以恢复会话而不是完整握手；
+	// 自定义TLS配置会关闭HTTP/2的自动启用，没有自定义拨号时显式保留
+	if proxy.URL.Scheme == "https" {
+		transport.TLSClientConfig = &tls.Config{ClientSessionCache: c.sessions}
+		transport.ForceAttemptHTTP2 = transport.DialContext == nil
 	}
 
 	// 如果需要认证，包一层添加Proxy-Authorization
@@ -188,6 +339,47 @@ func (c *Client) createClient(proxy models.ProxyInfo) *http.Client {
 	}
 }
 
+// ReuseStats 获取经由上游代理转发的HTTP请求的连接复用和TLS会话恢复统计。
+//
+// 返回值：
+//   - ReuseReport: 汇总统计和按代理的统计
+func (c *Client) ReuseStats() ReuseReport {
+	return c.reuse.report()
+}
+
+// strictDialContext 创建只允许连接指定代理地址的拨号函数。
+//
+// 传输层如果尝试连接代理以外的地址，意味着需要在本地解析目标主机名，
+// 严格DNS模式下直接拒绝该连接。
+//
+// 参数：
+//   - proxyHost: 上游代理地址（host:port格式）
+//
+// 返回值：
+//   - func(context.Context, string, string) (net.Conn, error): 拨号函数
+func strictDialContext(proxyHost string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != proxyHost {
+			return nil, fmt.Errorf("严格DNS模式禁止绕过上游代理直接连接 %s", addr)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// releaseOnClose 关闭时释放代理池负载登记的响应体。
+type releaseOnClose struct {
+	io.ReadCloser
+	release func() // 释放负载登记，可重复调用
+}
+
+// Close 关闭响应体并释放负载登记。
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
+
 // Close 清理所有客户端连接池。
 //
 // 关闭所有缓存的HTTP客户端的空闲连接，释放资源。
@@ -196,11 +388,9 @@ func (c *Client) Close() {
 	c.clientsMux.Lock()
 	defer c.clientsMux.Unlock()
 
-	for _, client := range c.clients {
-		if transport, ok := client.Transport.(*http.Transport); ok {
-			transport.CloseIdleConnections()
-		}
+	for _, cached := range c.clients {
+		cached.client.CloseIdleConnections()
 	}
 
-	c.clients = make(map[string]*http.Client)
+	c.clients = make(map[string]*cachedClient)
 }
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy HTTP请求经代理转发失败时的重试策略。
+//
+// 连接上游代理失败时请求还没有发出，任何方法都可以改用其他代理重试；请求发出后的失败
+// 和 RetryOnStatus 中的状态码只对幂等方法重试，除非设置了 RetryNonIdempotent。
+// 请求体无法重新读取（没有 GetBody）时不重试。
+type RetryPolicy struct {
+	MaxAttempts        int           // 最多尝试次数，每次重新选择代理，0表示按代理池大小
+	RetryNonIdempotent bool          // 是否也重试POST、PATCH等非幂等方法
+	AttemptTimeout     time.Duration // 每次尝试等待响应头的超时时间，0表示不限制
+	RetryOnStatus      map[int]bool  // 上游返回这些状态码时改用其他代理重试，最后一次尝试的响应原样返回
+}
+
+// idempotentMethods 可以安全重试的HTTP方法（RFC 9110）。
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// attemptTimeoutError 单次尝试等待响应头超时。
+type attemptTimeoutError struct {
+	timeout time.Duration // 单次尝试的超时时间
+}
+
+// Error 返回超时说明。
+func (e *attemptTimeoutError) Error() string {
+	return fmt.Sprintf("等待上游响应头超过 %v", e.timeout)
+}
+
+// Timeout 实现net.Error，超时错误。
+func (e *attemptTimeoutError) Timeout() bool { return true }
+
+// Temporary 实现net.Error。
+func (e *attemptTimeoutError) Temporary() bool { return true }
+
+// Attempts 返回一次请求或CONNECT隧道最多尝试的次数，每次重新选择代理。
+//
+// 未配置最多尝试次数时按代理池中可供选择的代理数，至少为1。
+func (c *Client) Attempts() int {
+	if c.retry.MaxAttempts > 0 {
+		return c.retry.MaxAttempts
+	}
+	return max(c.pool.Size(), 1)
+}
+
+// canRetry 判断失败的尝试能否改用其他代理重试。
+//
+// 参数：
+//   - req: 请求
+//   - err: 本次尝试的错误，按状态码重试时为nil
+//
+// 返回值：
+//   - bool: 是否可以重试
+func (c *Client) canRetry(req *http.Request, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if err != nil && notSent(err) {
+		return true
+	}
+	return idempotentMethods[req.Method] || c.retry.RetryNonIdempotent
+}
+
+// notSent 判断错误是否发生在请求发出之前（连接上游代理失败）。
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+// rewind 为重试准备请求体。
+//
+// 返回值：
+//   - *http.Request: 可以再次发送的请求
+//   - error: 重新获取请求体失败
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	return retry, nil
+}
+
+// roundTrip 经代理的HTTP客户端发送一次请求，配置了单次尝试超时时限制等待响应头的时间。
+//
+// 参数：
+//   - client: 代理对应的HTTP客户端
+//   - req: 请求
+//
+// 返回值：
+//   - *http.Response: 响应，响应体关闭时释放本次尝试的上下文
+//   - error: 请求错误，等待响应头超时时为 *attemptTimeoutError
+func (c *Client) roundTrip(client *http.Client, req *http.Request) (*http.Response, error) {
+	if c.retry.AttemptTimeout <= 0 {
+		return client.Do(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(c.retry.AttemptTimeout, cancel)
+	resp, err := client.Do(req.WithContext(ctx))
+	if !timer.Stop() && req.Context().Err() == nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, &attemptTimeoutError{timeout: c.retry.AttemptTimeout}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose 关闭时释放请求上下文的响应体。
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close 关闭响应体并释放请求上下文。
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxReuseProxies 按代理统计连接复用的代理数上限，超出时淘汰最久未使用的代理
+const maxReuseProxies = 1000
+
+// ConnStats 经由上游代理转发的HTTP请求的连接复用统计。
+//
+// CONNECT隧道每次都新建到上游代理的连接，不计入统计。
+type ConnStats struct {
+	Proxy          string  `json:"proxy,omitempty"`  // 上游代理地址，汇总统计时为空
+	Requests       int64   `json:"requests"`         // 取得连接的请求数
+	ReusedConns    int64   `json:"reused_conns"`     // 复用空闲连接的请求数
+	NewConns       int64   `json:"new_conns"`        // 新建连接的请求数
+	ReuseRate      float64 `json:"reuse_rate"`       // 复用连接的比例
+	AvgIdleMs      float64 `json:"avg_idle_ms"`      // 被复用的连接平均空闲时长（毫秒）
+	TLSHandshakes  int64   `json:"tls_handshakes"`   // 与HTTPS上游代理完成的TLS握手数
+	TLSResumed     int64   `json:"tls_resumed"`      // 其中恢复已有会话的握手数
+	TLSFull        int64   `json:"tls_full"`         // 其中完整握手数
+	TLSResumeRate  float64 `json:"tls_resume_rate"`  // 恢复会话的比例
+	TLSHandshakeMs float64 `json:"tls_handshake_ms"` // 平均握手耗时（毫秒）
+}
+
+// ReuseReport 连接复用统计报告。
+type ReuseReport struct {
+	Total   ConnStats   `json:"total"`   // 全部代理的汇总
+	Proxies []ConnStats `json:"proxies"` // 按代理的统计，按请求数降序排列
+}
+
+// reuseCounters 单个代理或汇总的复用计数。
+type reuseCounters struct {
+	requests    int64         // 取得连接的请求数
+	reused      int64         // 复用连接的请求数
+	idle        time.Duration // 被复用连接的空闲时长总和
+	handshakes  int64         // TLS握手数
+	resumed     int64         // 恢复会话的TLS握手数
+	handshaking time.Duration // TLS握手耗时总和
+	used        time.Time     // 最近一次取得连接的时间
+}
+
+// stats 将计数换算为统计。
+func (c *reuseCounters) stats(proxy string) ConnStats {
+	stats := ConnStats{
+		Proxy:         proxy,
+		Requests:      c.requests,
+		ReusedConns:   c.reused,
+		NewConns:      c.requests - c.reused,
+		TLSHandshakes: c.handshakes,
+		TLSResumed:    c.resumed,
+		TLSFull:       c.handshakes - c.resumed,
+	}
+	if c.requests > 0 {
+		stats.ReuseRate = float64(c.reused) / float64(c.requests)
+	}
+	if c.reused > 0 {
+		stats.AvgIdleMs = float64(c.idle) / float64(c.reused) / float64(time.Millisecond)
+	}
+	if c.handshakes > 0 {
+		stats.TLSResumeRate = float64(c.resumed) / float64(c.handshakes)
+		stats.TLSHandshakeMs = float64(c.handshaking) / float64(c.handshakes) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// reuseTracker 按上游代理统计连接复用和TLS会话恢复。
+//
+// 汇总计数不受淘汰影响，只增不减。
+type reuseTracker struct {
+	total   reuseCounters             // 全部代理的汇总
+	proxies map[string]*reuseCounters // 按代理地址索引的计数
+	mutex   sync.Mutex                // 互斥锁
+}
+
+// newReuseTracker 创建连接复用统计。
+func newReuseTracker() *reuseTracker {
+	return &reuseTracker{proxies: make(map[string]*reuseCounters)}
+}
+
+// counters 返回代理的计数，不存在时创建，调用方需持有锁。
+func (r *reuseTracker) counters(proxy string, now time.Time) *reuseCounters {
+	c, ok := r.proxies[proxy]
+	if !ok {
+		if len(r.proxies) >= maxReuseProxies {
+			var victim string
+			var oldest time.Time
+			for host, other := range r.proxies {
+				if victim == "" || other.used.Before(oldest) {
+					victim, oldest = host, other.used
+				}
+			}
+			delete(r.proxies, victim)
+		}
+		c = &reuseCounters{}
+		r.proxies[proxy] = c
+	}
+	c.used = now
+	return c
+}
+
+// trace 返回记录经由指定代理的请求如何取得连接的跟踪钩子。
+//
+// 参数：
+//   - proxy: 上游代理地址
+//   - tlsProxy: 是否为HTTPS上游代理，只统计新建连接时与代理的第一次TLS握手，经隧道与目标的握手不计入
+//
+// 返回值：
+//   - *httptrace.ClientTrace: 跟踪钩子
+func (r *reuseTracker) trace(proxy string, tlsProxy bool) *httptrace.ClientTrace {
+	var handshakeStart time.Time
+	var handshaken bool
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			for _, c := range []*reuseCounters{&r.total, r.counters(proxy, time.Now())} {
+				c.requests++
+				if info.Reused {
+					c.reused++
+					c.idle += info.IdleTime
+				}
+			}
+		},
+		TLSHandshakeStart: func() {
+			handshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if !tlsProxy || handshaken {
+				return
+			}
+			handshaken = true
+			if err != nil {
+				return
+			}
+			elapsed := time.Since(handshakeStart)
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			for _, c := range []*reuseCounters{&r.total, r.counters(proxy, time.Now())} {
+				c.handshakes++
+				c.handshaking += elapsed
+				if state.DidResume {
+					c.resumed++
+				}
+			}
+		},
+	}
+}
+
+// report 返回汇总统计和按代理的统计。
+func (r *reuseTracker) report() ReuseReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := make([]ConnStats, 0, len(r.proxies))
+	for proxy, c := range r.proxies {
+		result = append(result, c.stats(proxy))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].Proxy < result[j].Proxy
+	})
+	return ReuseReport{Total: r.total.stats(""), Proxies: result}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// forbidResolver 在测试期间替换默认解析器，任何本地DNS查询都会使测试失败。
+func forbidResolver(t *testing.T) {
+	t.Helper()
+	original := net.DefaultResolver
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			t.Errorf("严格DNS模式下不应在本地解析（连接DNS服务器 %s）", address)
+			return nil, errors.New("禁止本地解析")
+		},
+	}
+	t.Cleanup(func() { net.DefaultResolver = original })
+}
+
+func TestStrictDialContextRejectsTargets(t *testing.T) {
+	forbidResolver(t)
+	dial := strictDialContext("127.0.0.1:3128")
+
+	for _, addr := range []string{"example.com:443", "localhost:3128", "10.0.0.1:80", "127.0.0.1:3129"} {
+		conn, err := dial(context.Background(), "tcp", addr)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("严格DNS模式下连接 %s 应被拒绝", addr)
+		}
+		if !strings.Contains(err.Error(), "严格DNS模式") {
+			t.Fatalf("连接 %s 的错误 = %v，期望严格DNS模式的拒绝", addr, err)
+		}
+	}
+}
+
+func TestStrictDialContextAllowsProxy(t *testing.T) {
+	forbidResolver(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	proxyHost := listener.Addr().String()
+	conn, err := strictDialContext(proxyHost)(context.Background(), "tcp", proxyHost)
+	if err != nil {
+		t.Fatalf("连接上游代理本身不应被拒绝: %v", err)
+	}
+	conn.Close()
+}
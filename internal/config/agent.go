@@ -0,0 +1,37 @@
+package config
+
+import "time"
+
+// AgentConfig 客户端代理模式的配置。
+//
+// 客户端代理运行在应用所在的主机上，对本机应用提供普通HTTP代理，
+// 并通过多路复用传输把全部连接转发到中心ProxyFlow。
+type AgentConfig struct {
+	Listen         string        // 本地代理监听地址
+	Server         string        // 中心ProxyFlow多路复用端口地址（host:port）
+	Token          string        // 多路复用访问令牌，与中心的 MUX_TOKEN 相同
+	TLS            bool          // 是否使用TLS连接中心
+	TLSServerName  string        // 校验证书时使用的服务器名称，为空则取Server中的主机名
+	TLSCAFile      string        // 校验中心证书的CA文件，为空则使用系统根证书
+	Connections    int           // 与中心保持的多路复用连接数
+	DialTimeout    time.Duration // 连接中心的超时时间
+	ReconnectDelay time.Duration // 断线后重连的最长退避时间
+}
+
+// LoadAgent 从环境变量加载客户端代理模式的配置。
+//
+// 返回值：
+//   - *AgentConfig: 客户端代理配置
+func LoadAgent() *AgentConfig {
+	return &AgentConfig{
+		Listen:         getEnv("AGENT_LISTEN", "127.0.0.1:8282"),
+		Server:         getEnv("AGENT_SERVER", ""),
+		Token:          getEnv("AGENT_TOKEN", ""),
+		TLS:            getEnvBool("AGENT_TLS", false),
+		TLSServerName:  getEnv("AGENT_TLS_SERVER_NAME", ""),
+		TLSCAFile:      getEnv("AGENT_TLS_CA_FILE", ""),
+		Connections:    getEnvInt("AGENT_CONNECTIONS", 2),
+		DialTimeout:    time.Duration(getEnvInt("AGENT_DIAL_TIMEOUT", 10)) * time.Second,
+		ReconnectDelay: time.Duration(getEnvInt("AGENT_RECONNECT_MAX_DELAY", 30)) * time.Second,
+	}
+}
@@ -8,6 +8,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,12 +17,253 @@ import (
 // 包含了代理服务器运行所需的所有配置参数，包括网络设置、
 // 资源配置和认证参数等。
 type Config struct {
-	ProxyPort      string        // 代理服务监听端口
-	ProxyAPI       string        // 代理API端点地址
-	PoolSize       int           // 连接池大小
-	RequestTimeout time.Duration // 请求超时时间
-	AuthUsername   string        // 代理服务器认证用户名
-	AuthPassword   string        // 代理服务器认证密码
+	ProxyPort       string        // 代理服务监听端口，可以是逗号分隔的列表或范围（如 8282-8291）
+	TLSPort         string        // TLS代理监听端口，为空则不启用
+	TLSCertFile     string        // TLS证书文件路径
+	TLSKeyFile      string        // TLS私钥文件路径
+	TLSMinVersion   string        // TLS监听器最低版本
+	TLSCiphers      []string      // TLS监听器允许的密码套件
+	TLSCurves       []string      // TLS监听器允许的密钥交换曲线
+	TLSMaxHandshake int           // TLS监听器同时进行的握手数上限
+	TLSHandshakeRPM int           // 单个来源IP每分钟允许的TLS握手次数
+	ProxyAPI        string        // 代理API端点地址
+	PoolSize        int           // 连接池大小，启用预取时为每个代理API来源预取的代理数
+	RequestTimeout  time.Duration // 请求超时时间
+	AuthUsername    string        // 代理服务器认证用户名
+	AuthPassword    string        // 代理服务器认证密码，与认证用户名一起作为一个明文密码的用户
+	MaxConnAge      time.Duration // 客户端连接和隧道的最大存活时间，0表示不限制
+	StreamingWindow time.Duration // 流式隧道识别窗口，0表示不识别
+	DNSStrict       bool          // 严格DNS模式，禁止在本地解析目标主机名
+	HeaderProfiles  string        // 出站请求头画像文件路径，为空则不启用
+
+	AuthUsersFile   string        // htpasswd格式的认证用户文件路径，为空表示没有用户文件
+	AuthUsers       []string      // "用户名:密码哈希" 格式的认证用户列表
+	AuthUsersReload time.Duration // 检查认证用户文件是否变化的间隔，0表示不重新加载
+
+	AllowIPs         []string // 允许连接的客户端IP或CIDR网段，为空表示不限制
+	DenyIPs          []string // 拒绝连接的客户端IP或CIDR网段，优先于允许列表
+	AllowIPsSkipAuth bool     // 允许列表中的客户端未提供凭据时免于代理认证
+
+	PortPools    map[string]string // 代理端口绑定的代理池（端口到代理池名称）
+	PortSessions bool              // 是否为每个代理端口分配固定的粘性会话
+
+	DynamicPorts   string        // 可通过管理API动态分配的端口列表或范围，为空表示不启用
+	DynamicPortTTL time.Duration // 动态端口的默认有效期
+
+	TunnelIdleTimeout time.Duration // 隧道双向都没有数据传输多久后关闭，0表示不限制
+	TunnelMaxDuration time.Duration // 隧道的绝对最长存活时间，流式隧道同样受限，0表示不限制
+
+	TCPKeepAlive         time.Duration // 连接空闲多久后开始发送TCP keepalive探测，0表示关闭keepalive
+	TCPKeepAliveInterval time.Duration // TCP keepalive探测的间隔
+	TCPKeepAliveCount    int           // 连续多少次keepalive探测无响应后断开连接
+
+	ProxyFile       string        // 静态代理列表文件路径，为空则只使用代理API
+	ProxyAPIWeight  int           // 同时配置代理API和代理列表文件时API来源的选择权重
+	ProxyFileWeight int           // 同时配置代理API和代理列表文件时代理列表文件的选择权重
+	ProxyFileReload time.Duration // 检查代理列表文件变化的间隔，0表示不重新加载
+
+	ProxyFileKey     string // 解密代理列表文件的密钥（十六进制或base64），为空表示文件为明文
+	ProxyFileKeyFile string // 保存代理列表文件密钥的文件路径，与ProxyFileKey只能配置一项
+
+	ProxyAPIs          map[string]string // 额外的代理API端点（来源名称到URL）
+	ProxyList          []string          // 直接配置的静态代理列表
+	ProxySourceWeights map[string]string // 按来源名称覆盖的选择权重
+	ProxySourceRefresh map[string]string // 按来源名称的刷新间隔（秒），API来源设置后改为定期拉取完整列表
+
+	ProxyAPIErrorBackoff time.Duration // 代理API失败后暂停请求并改用最近获取的代理的时间，0表示不暂停
+
+	ProxyPrefetch       bool          // 是否在后台预取代理API的代理，缓冲容量为 PoolSize
+	ProxyPrefetchMaxAge time.Duration // 预取的代理在缓冲中的最长停留时间，0表示不限制
+
+	ProxyStrategy string // 从代理列表文件中选择代理的策略：round-robin、random、least-connections、weighted或ewma
+
+	SSHKeyFile    string // SSH上游默认使用的私钥文件，为空则只使用URL中的密码或key参数
+	SSHKnownHosts string // 校验SSH上游主机密钥的known_hosts文件，为空则接受任意主机密钥
+
+	WireGuardTunnels map[string]string // 用户态WireGuard隧道（名称到 wg-quick 配置文件路径）
+
+	UpstreamPlugins []string // 提供额外上游协议拨号器的Go插件（.so）路径，需要以cgo构建
+
+	TLSRoutes      map[string]string // TLS监听器按SNI或ALPN分流的端点（路由键到端点名称）
+	AccessHours    map[string]string // 按用户名限制的访问时间段
+	AuthFailureLog string            // 认证失败记录文件路径，为空则写入主日志
+
+	LogFormat string // 日志格式：text 或 json
+	LogFile   string // 日志文件路径，为空则输出到标准错误
+
+	AuthRealm         string   // 407质询中的realm
+	AuthSchemes       []string // 407质询中声明的认证方案，为空只声明Basic
+	AuthChallengeBody string   // 407响应体文件路径，按扩展名推断内容类型，为空则不带响应体
+
+	AuthCacheTTL time.Duration // 按客户端IP缓存认证通过结果的时长，0表示只在连接内缓存
+
+	MuxPort  string // 多路复用监听端口，为空则不启用
+	MuxToken string // 客户端代理连接多路复用端口时使用的访问令牌
+	MuxTLS   bool   // 多路复用端口是否使用TLS（复用TLS证书配置）
+
+	MuxAgents          map[string]string // 按客户端代理身份分配的访问令牌（身份到令牌）
+	MuxAgentPools      map[string]string // 客户端代理绑定的代理池（身份到代理池名称）
+	MuxAgentMaxStreams int               // 每个客户端代理的并发连接数上限，0表示不限制
+
+	WebSocketPort string // WebSocket监听端口，为空则不启用
+	WebSocketPath string // 接受WebSocket升级请求的路径
+	WebSocketTLS  bool   // WebSocket端口是否使用TLS（复用TLS证书配置）
+
+	SOCKSPort string // SOCKS5监听端口，为空则不启用
+
+	HTTP3Port string // HTTP/3代理监听的UDP端口，为空则不启用，复用TLS证书配置
+
+	SLOObjectives string        // 上游服务水平目标，例如 latency_p95<800ms;error_rate<5%
+	SLOWindow     time.Duration // SLO滑动窗口长度
+	SLOMinSamples int           // 窗口内样本数少于该值时不评估SLO
+	SLOPerProxy   bool          // 是否同时按单个代理评估SLO
+	SLOInterval   time.Duration // SLO评估间隔
+	SLOWebhook    string        // SLO告警推送地址，为空则不推送
+
+	AlertDestinations    []string // 命中后产生告警事件的目标模式
+	BlockDestinations    []string // 命中后产生告警事件并拒绝请求的目标模式
+	DestinationRulesFile string   // 可疑目标规则文件路径，为空则不加载
+
+	RoutesFile  string   // 按目标主机的路由规则文件（YAML）路径，为空则不启用
+	ProxyBypass []string // 直连绕过列表，格式同 NO_PROXY（域名、IP、CIDR、端口）
+
+	DailyCaps        map[string]string // 全部用户共享的每日请求上限（目标模式到次数）
+	DailyCapsPerUser map[string]string // 按用户分别计数的每日请求上限（目标模式到次数）
+
+	TrafficLabels map[string]string // 流量标签到匹配条件的映射，为空则不打标签
+
+	RobotsAgents   []string      // 需要遵守robots.txt的爬虫身份（按User-Agent包含匹配），为空则不检查
+	RobotsCacheTTL time.Duration // robots.txt缓存时长
+
+	ResponseHashMaxBytes int64 // 计算响应体校验和的大小上限（字节），0表示不计算
+
+	RewriteRulesFile string // 响应体改写规则文件路径，为空则不改写
+	RewriteMaxBytes  int64  // 可改写的响应体大小上限（字节），超过上限的响应原样转发
+
+	VersionHeader bool // 是否在响应中添加 X-ProxyFlow-Version 头，标明处理请求的构建版本
+
+	UpdateURL          string        // 自更新使用的发布清单地址，为空则不能自更新
+	UpdatePublicKey    string        // 校验发布文件签名的Ed25519公钥（Base64），为空则拒绝更新
+	UpdateReadyTimeout time.Duration // 进程交接时等待新进程就绪的时间，超时则放弃交接继续运行
+	UpdateDrainTimeout time.Duration // 进程交接后旧进程等待活跃隧道结束的时间，超时后强制退出
+
+	SessionMaxRequests int               // 每个上游代理对同一目标的最大请求数，0表示不限制
+	SessionQuotaWindow time.Duration     // 会话配额统计窗口
+	StickySessionTTL   time.Duration     // 粘性会话空闲过期时间
+	StickySessionMax   int               // 每个代理池的粘性会话数上限，0表示不限制
+	SessionSources     []string          // 粘性会话ID的来源，按优先级排列
+	SessionSeparator   string            // 用户名中会话后缀的分隔符
+	CookieJarHosts     []string          // 按粘性会话在服务端保存Cookie的目标模式，为空表示不启用
+	AvoidRepeatExit    bool              // 避免同一目标连续使用相同的出口IP
+	ProxyCredentials   map[string]string // 主代理池的凭据覆盖（代理地址或*到 user:pass）
+	QueueMaxWait       time.Duration     // 暂时没有可用代理时请求的最长等待时间，0表示不排队
+	QueueMaxSize       int               // 同时等待可用代理的请求数上限
+	UpstreamRPS        float64           // 每个上游代理每秒允许的请求数，0表示不限制
+	UpstreamBurst      int               // 每个上游代理允许的突发请求数
+	UpstreamMaxWait    time.Duration     // 请求等待上游代理速率令牌的最长时间
+
+	AdaptiveConcurrency bool    // 按上游代理自适应地限制并发连接和请求数
+	ConcurrencyInitial  int     // 每个上游代理的初始并发上限
+	ConcurrencyMin      int     // 自适应并发上限的下限
+	ConcurrencyMax      int     // 自适应并发上限的上限
+	LatencyTolerance    float64 // 延迟超过基准延迟多少倍时缩减并发上限
+
+	SessionStore       string // 粘性会话存储后端：memory 或 redis
+	SessionRedisURL    string // redis 后端的Redis地址
+	SessionRedisPrefix string // redis 后端的键前缀，之后依次拼接代理池名称和会话ID
+
+	CapabilityProbe           bool          // 是否探测上游代理能力
+	CapabilityProbeTarget     string        // CONNECT端口探测目标主机
+	CapabilityProbePorts      []int         // 需要探测的CONNECT端口
+	CapabilityProbeIPv6Target string        // IPv6出口探测目标地址
+	CapabilityProbeTimeout    time.Duration // 单项探测超时时间
+	CapabilityProbeTTL        time.Duration // 探测结果有效期
+
+	HealthCheck           bool              // 是否启用主动健康检查
+	HealthPassive         bool              // 是否根据实际流量的结果更新代理健康状态
+	HealthCheckMode       string            // 健康检查方式：http或connect
+	HealthCheckPoolModes  map[string]string // 按代理池名称覆盖的健康检查方式
+	HealthCheckURL        string            // 健康检查通过代理访问的地址
+	HealthCheckInterval   time.Duration     // 同一代理两次健康检查的间隔
+	HealthCheckTimeout    time.Duration     // 单次健康检查超时时间
+	HealthCheckWorkers    int               // 同时进行的健康检查数上限
+	HealthCheckJitter     int               // 检查间隔的随机抖动百分比
+	HealthCheckThreshold  int               // 连续失败多少次后判定为不健康
+	HealthCheckRecovery   int               // 不健康的代理连续成功多少次后恢复使用
+	HealthCheckMaxBackoff time.Duration     // 不健康代理重试失败后等待时间加倍的上限，不大于检查间隔时不加倍，需要启用主动或被动健康检查
+	HealthCheckExitIPURL  string            // 健康检查时查询出口IP的地址，为空则不记录
+
+	HealthCheckAdaptive   bool    // 按代理的失败和使用情况自适应调整健康检查间隔
+	HealthCheckIdleFactor int     // 空闲的健康代理检查间隔最多放大到检查间隔的多少倍
+	HealthCheckMaxRate    float64 // 全部代理池每秒最多发起的健康检查数，0表示不限制
+
+	CertWatchHosts []string // 健康检查成功后通过代理观察TLS证书的目标，为空则不观察
+
+	CanaryURL     string // 健康检查成功后通过代理请求的金丝雀地址，为空则不检查内容完整性
+	CanarySHA256  string // 金丝雀响应体的预期SHA-256，为空则直接请求获取
+	CanaryExclude bool   // 是否停止使用篡改了金丝雀响应的代理
+
+	MaxResponseHeaderBytes int64 // 上游响应头最大字节数
+	MaxResponseHeaders     int   // 上游响应头最大数量，0表示不限制
+
+	MaxRequestHeaderBytes int   // 客户端请求行和请求头合计的最大字节数，0表示不限制
+	MaxRequestHeaders     int   // 客户端请求头最大数量，0表示不限制
+	MaxRequestBodyBytes   int64 // 明文HTTP请求体的最大字节数，0表示不限制
+
+	RetryMaxAttempts    int           // HTTP请求和CONNECT隧道最多尝试的次数，每次重新选择代理，0表示按代理池大小
+	RetryOnStatus       []int         // 上游返回这些状态码时改用其他代理重试
+	RetryNonIdempotent  bool          // 是否也重试POST、PATCH等非幂等方法
+	RetryAttemptTimeout time.Duration // 每次尝试等待响应头的超时时间，0表示不限制
+
+	DestStatsHalfLife time.Duration // 目标主机统计的衰减半衰期
+	DestStatsMaxHosts int           // 最多统计的目标主机数，0表示不统计
+	RotationWindow    time.Duration // 按用户统计出口轮换的保留时长，0表示不统计
+
+	SizeMetricsTopHosts int // 请求和响应大小直方图中单独统计的目标主机数，0表示全部归入 other，小于0表示不统计
+
+	Pools        map[string]string // 可按计划切换的具名代理池（名称到代理API）
+	PoolSchedule string            // 代理池切换计划
+
+	PoolChains map[string]string // 按代理池名称设置的上游代理链，值为逗号分隔的HTTP代理地址，连接池中的代理前按顺序经过
+
+	UserPools       map[string]string // 认证用户绑定的代理池（用户名到代理池名称）
+	UsernameOptions []string          // 允许写在认证用户名中的参数键，pool 选择代理池，其他键作为代理标签
+
+	FailoverTiers     string        // 代理池故障转移的优先级层，为空则不启用
+	FailoverThreshold float64       // 当前层健康代理比例低于该值时切换到下一层
+	FailoverRecover   float64       // 更高优先级的层健康代理比例达到该值时切回
+	FailoverHold      time.Duration // 两次切换之间的最短间隔
+
+	BandwidthBudget      int64  // 主代理池每月流量预算（字节），0表示不启用
+	BandwidthAction      string // 预算用尽后的处理策略：block、fallback、alert
+	BandwidthFallbackAPI string // 预算用尽后使用的备用代理API
+	BandwidthUsageFile   string // 流量用量持久化文件
+
+	BandwidthPerConnection int64  // 单个连接或请求每秒允许传输的字节数，0表示不限制
+	BandwidthPerUser       int64  // 单个用户所有连接合计每秒允许传输的字节数，0表示不限制
+	UserTrafficQuota       int64  // 单个用户每个统计周期允许传输的字节数，0表示不限制
+	UserTrafficPeriod      string // 用户流量配额的统计周期：day、week、month
+	UserTrafficUsageFile   string // 用户流量用量持久化文件
+
+	MaintenanceStatus  int    // 维护模式下拒绝新请求的状态码
+	MaintenanceMessage string // 维护模式下拒绝新请求的说明
+
+	DrainTimeout time.Duration // 移除上游代理时默认的排空超时，0表示不强制关闭
+
+	Priority          string // 全局默认的过载优先级：high、normal、low
+	MaxConnections    int    // 同时处理的请求和隧道数上限，0表示不限制
+	ShedLowPercent    int    // 低优先级流量可使用的连接数百分比
+	ShedNormalPercent int    // 普通优先级流量可使用的连接数百分比
+
+	ClientRPS            float64       // 单个客户端IP每秒允许的请求数，0表示不限制
+	ClientMaxConnections int           // 单个客户端IP同时进行的请求和隧道数上限，0表示不限制
+	UserRPS              float64       // 单个用户每秒允许的请求数，0表示不限制
+	UserMaxConnections   int           // 单个用户同时进行的请求和隧道数上限，0表示不限制
+	ClientBurst          int           // 按客户端IP和用户限流时允许的突发请求数
+	ClientRPSMaxWait     time.Duration // 超过请求速率时最多延迟多久再处理，0表示直接返回429
+
+	AdminPort  string // 管理API监听端口，为空则不启用
+	AdminToken string // 管理API访问令牌
 }
 
 // Load 从环境变量加载应用配置。
@@ -33,12 +275,253 @@ type Config struct {
 //   - *Config: 配置实例指针
 func Load() *Config {
 	return &Config{
-		ProxyPort:      getEnv("PROXY_PORT", "8282"),
-		ProxyAPI:       getEnv("PROXY_API", ""),
-		PoolSize:       getEnvInt("POOL_SIZE", 100),
-		RequestTimeout: time.Duration(getEnvInt("REQUEST_TIMEOUT", 30)) * time.Second,
-		AuthUsername:   getEnv("AUTH_USERNAME", ""),
-		AuthPassword:   getEnv("AUTH_PASSWORD", ""),
+		ProxyPort:       getEnv("PROXY_PORT", "8282"),
+		TLSPort:         getEnv("TLS_PORT", ""),
+		TLSCertFile:     getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:      getEnv("TLS_KEY_FILE", ""),
+		TLSMinVersion:   getEnv("TLS_MIN_VERSION", "1.2"),
+		TLSCiphers:      getEnvList("TLS_CIPHER_SUITES"),
+		TLSCurves:       getEnvList("TLS_CURVES"),
+		TLSMaxHandshake: getEnvInt("TLS_MAX_HANDSHAKES", 256),
+		TLSHandshakeRPM: getEnvInt("TLS_HANDSHAKE_RATE", 60),
+		ProxyAPI:        ExpandVars(getEnv("PROXY_API", "")),
+		PoolSize:        getEnvInt("POOL_SIZE", 100),
+		RequestTimeout:  time.Duration(getEnvInt("REQUEST_TIMEOUT", 30)) * time.Second,
+		AuthUsername:    getEnv("AUTH_USERNAME", ""),
+		AuthPassword:    getEnv("AUTH_PASSWORD", ""),
+		MaxConnAge:      time.Duration(getEnvInt("MAX_CONNECTION_AGE", 0)) * time.Second,
+		StreamingWindow: time.Duration(getEnvInt("STREAMING_TUNNEL_WINDOW", 30)) * time.Second,
+		DNSStrict:       getEnvBool("DNS_STRICT", false),
+		HeaderProfiles:  getEnv("HEADER_PROFILES_FILE", ""),
+
+		AuthUsersFile:   getEnv("AUTH_USERS_FILE", ""),
+		AuthUsers:       getEnvList("AUTH_USERS"),
+		AuthUsersReload: time.Duration(getEnvInt("AUTH_USERS_RELOAD", 5)) * time.Second,
+
+		AllowIPs:         getEnvList("ALLOW_IPS"),
+		DenyIPs:          getEnvList("DENY_IPS"),
+		AllowIPsSkipAuth: getEnvBool("ALLOW_IPS_SKIP_AUTH", false),
+
+		PortPools:    getEnvMap("PORT_POOLS"),
+		PortSessions: getEnvBool("PORT_SESSIONS", false),
+
+		DynamicPorts:   getEnv("DYNAMIC_PORTS", ""),
+		DynamicPortTTL: time.Duration(getEnvInt("DYNAMIC_PORT_TTL", 1800)) * time.Second,
+
+		TunnelIdleTimeout: time.Duration(getEnvInt("TUNNEL_IDLE_TIMEOUT", 0)) * time.Second,
+		TunnelMaxDuration: time.Duration(getEnvInt("TUNNEL_MAX_DURATION", 0)) * time.Second,
+
+		TCPKeepAlive:         time.Duration(getEnvInt("TCP_KEEPALIVE", 30)) * time.Second,
+		TCPKeepAliveInterval: time.Duration(getEnvInt("TCP_KEEPALIVE_INTERVAL", 10)) * time.Second,
+		TCPKeepAliveCount:    getEnvInt("TCP_KEEPALIVE_COUNT", 3),
+
+		ProxyFile:       getEnv("PROXY_FILE", ""),
+		ProxyAPIWeight:  getEnvInt("PROXY_API_WEIGHT", 1),
+		ProxyFileWeight: getEnvInt("PROXY_FILE_WEIGHT", 1),
+		ProxyFileReload: time.Duration(getEnvInt("PROXY_FILE_RELOAD", 5)) * time.Second,
+
+		ProxyFileKey:     getEnv("PROXY_FILE_KEY", ""),
+		ProxyFileKeyFile: getEnv("PROXY_FILE_KEY_FILE", ""),
+
+		ProxyAPIs:          getEnvMap("PROXY_APIS"),
+		ProxyList:          strings.Fields(getEnv("PROXY_LIST", "")),
+		ProxySourceWeights: getEnvMap("PROXY_SOURCE_WEIGHTS"),
+		ProxySourceRefresh: getEnvMap("PROXY_SOURCE_REFRESH"),
+
+		ProxyAPIErrorBackoff: time.Duration(getEnvInt("PROXY_API_ERROR_BACKOFF", 0)) * time.Second,
+
+		ProxyPrefetch:       getEnvBool("PROXY_PREFETCH", false),
+		ProxyPrefetchMaxAge: time.Duration(getEnvInt("PROXY_PREFETCH_MAX_AGE", 60)) * time.Second,
+
+		ProxyStrategy: getEnv("PROXY_STRATEGY", "round-robin"),
+
+		SSHKeyFile:    getEnv("SSH_KEY_FILE", ""),
+		SSHKnownHosts: getEnv("SSH_KNOWN_HOSTS", ""),
+
+		WireGuardTunnels: getEnvMap("WIREGUARD_TUNNELS"),
+
+		UpstreamPlugins: getEnvList("UPSTREAM_PLUGINS"),
+
+		TLSRoutes:      getEnvMap("TLS_ROUTES"),
+		AccessHours:    getEnvMap("ACCESS_HOURS"),
+		AuthFailureLog: getEnv("AUTH_FAILURE_LOG", ""),
+
+		LogFormat: getEnv("LOG_FORMAT", "text"),
+		LogFile:   getEnv("LOG_FILE", ""),
+
+		AuthRealm:         getEnv("AUTH_REALM", "ProxyFlow"),
+		AuthSchemes:       getEnvList("AUTH_SCHEMES"),
+		AuthChallengeBody: getEnv("AUTH_CHALLENGE_BODY", ""),
+
+		AuthCacheTTL: time.Duration(getEnvInt("AUTH_CACHE_TTL", 0)) * time.Second,
+
+		MuxPort:  getEnv("MUX_PORT", ""),
+		MuxToken: getEnv("MUX_TOKEN", ""),
+		MuxTLS:   getEnvBool("MUX_TLS", false),
+
+		MuxAgents:          getEnvMap("MUX_AGENTS"),
+		MuxAgentPools:      getEnvMap("MUX_AGENT_POOLS"),
+		MuxAgentMaxStreams: getEnvInt("MUX_AGENT_MAX_STREAMS", 0),
+
+		WebSocketPort: getEnv("WS_PORT", ""),
+		WebSocketPath: getEnv("WS_PATH", "/proxy"),
+		WebSocketTLS:  getEnvBool("WS_TLS", false),
+
+		SOCKSPort: getEnv("SOCKS_PORT", ""),
+
+		HTTP3Port: getEnv("H3_PORT", ""),
+
+		SLOObjectives: getEnv("SLO_OBJECTIVES", ""),
+		SLOWindow:     time.Duration(getEnvInt("SLO_WINDOW", 300)) * time.Second,
+		SLOMinSamples: getEnvInt("SLO_MIN_SAMPLES", 20),
+		SLOPerProxy:   getEnvBool("SLO_PER_PROXY", false),
+		SLOInterval:   time.Duration(getEnvInt("SLO_EVAL_INTERVAL", 15)) * time.Second,
+		SLOWebhook:    ExpandVars(getEnv("SLO_WEBHOOK_URL", "")),
+
+		AlertDestinations:    getEnvList("ALERT_DESTINATIONS"),
+		BlockDestinations:    getEnvList("BLOCK_DESTINATIONS"),
+		DestinationRulesFile: getEnv("DESTINATION_RULES_FILE", ""),
+
+		RoutesFile:  getEnv("ROUTES_FILE", ""),
+		ProxyBypass: getEnvList("PROXY_BYPASS"),
+
+		DailyCaps:        getEnvMap("DAILY_CAPS"),
+		DailyCapsPerUser: getEnvMap("DAILY_CAPS_PER_USER"),
+
+		TrafficLabels: getEnvMap("TRAFFIC_LABELS"),
+
+		RobotsAgents:   getEnvList("ROBOTS_AGENTS"),
+		RobotsCacheTTL: time.Duration(getEnvInt("ROBOTS_CACHE_TTL", 3600)) * time.Second,
+
+		ResponseHashMaxBytes: getEnvBytes("RESPONSE_HASH_MAX_BYTES", 0),
+
+		RewriteRulesFile: getEnv("REWRITE_RULES_FILE", ""),
+		RewriteMaxBytes:  getEnvBytes("REWRITE_MAX_BYTES", 1<<20),
+
+		VersionHeader: getEnvBool("VERSION_HEADER", false),
+
+		UpdateURL:          getEnv("UPDATE_URL", ""),
+		UpdatePublicKey:    getEnv("UPDATE_PUBLIC_KEY", ""),
+		UpdateReadyTimeout: time.Duration(getEnvInt("UPDATE_READY_TIMEOUT", 30)) * time.Second,
+		UpdateDrainTimeout: time.Duration(getEnvInt("UPDATE_DRAIN_TIMEOUT", 300)) * time.Second,
+
+		SessionMaxRequests: getEnvInt("SESSION_MAX_REQUESTS", 0),
+		SessionQuotaWindow: time.Duration(getEnvInt("SESSION_QUOTA_WINDOW", 3600)) * time.Second,
+		StickySessionTTL:   time.Duration(getEnvInt("STICKY_SESSION_TTL", 1800)) * time.Second,
+		StickySessionMax:   getEnvInt("STICKY_SESSION_MAX", 100000),
+		SessionSources:     getEnvList("SESSION_SOURCES"),
+		SessionSeparator:   getEnv("SESSION_SEPARATOR", "-session-"),
+		CookieJarHosts:     getEnvList("COOKIE_JAR_HOSTS"),
+		AvoidRepeatExit:    getEnvBool("ROTATION_AVOID_REPEAT_EXIT", false),
+		ProxyCredentials:   getEnvMap("PROXY_CREDENTIALS"),
+		QueueMaxWait:       time.Duration(getEnvInt("QUEUE_MAX_WAIT", 0)) * time.Second,
+		QueueMaxSize:       getEnvInt("QUEUE_MAX_SIZE", 100),
+		UpstreamRPS:        getEnvFloat("UPSTREAM_RPS", 0),
+		UpstreamBurst:      getEnvInt("UPSTREAM_BURST", 1),
+		UpstreamMaxWait:    time.Duration(getEnvInt("UPSTREAM_RPS_MAX_WAIT", 5)) * time.Second,
+
+		AdaptiveConcurrency: getEnvBool("UPSTREAM_ADAPTIVE_CONCURRENCY", false),
+		ConcurrencyInitial:  getEnvInt("UPSTREAM_CONCURRENCY_INITIAL", 10),
+		ConcurrencyMin:      getEnvInt("UPSTREAM_CONCURRENCY_MIN", 1),
+		ConcurrencyMax:      getEnvInt("UPSTREAM_CONCURRENCY_MAX", 200),
+		LatencyTolerance:    getEnvFloat("UPSTREAM_LATENCY_TOLERANCE", 2),
+
+		SessionStore:       getEnv("SESSION_STORE", "memory"),
+		SessionRedisURL:    getEnv("SESSION_REDIS_URL", "redis://127.0.0.1:6379"),
+		SessionRedisPrefix: getEnv("SESSION_REDIS_PREFIX", "proxyflow:session:"),
+
+		CapabilityProbe:           getEnvBool("CAPABILITY_PROBE", false),
+		CapabilityProbeTarget:     getEnv("CAPABILITY_PROBE_TARGET", "example.com"),
+		CapabilityProbePorts:      getEnvIntList("CAPABILITY_PROBE_PORTS", []int{443, 80}),
+		CapabilityProbeIPv6Target: getEnv("CAPABILITY_PROBE_IPV6_TARGET", "[2606:4700:4700::1111]:443"),
+		CapabilityProbeTimeout:    time.Duration(getEnvInt("CAPABILITY_PROBE_TIMEOUT", 5)) * time.Second,
+		CapabilityProbeTTL:        time.Duration(getEnvInt("CAPABILITY_PROBE_TTL", 3600)) * time.Second,
+
+		HealthCheck:           getEnvBool("HEALTH_CHECK", false),
+		HealthPassive:         getEnvBool("HEALTH_PASSIVE", false),
+		HealthCheckMode:       getEnv("HEALTH_CHECK_MODE", "http"),
+		HealthCheckPoolModes:  getEnvMap("HEALTH_CHECK_POOL_MODES"),
+		HealthCheckURL:        getEnv("HEALTH_CHECK_URL", "http://www.gstatic.com/generate_204"),
+		HealthCheckInterval:   time.Duration(getEnvInt("HEALTH_CHECK_INTERVAL", 300)) * time.Second,
+		HealthCheckTimeout:    time.Duration(getEnvInt("HEALTH_CHECK_TIMEOUT", 10)) * time.Second,
+		HealthCheckWorkers:    getEnvInt("HEALTH_CHECK_WORKERS", 16),
+		HealthCheckJitter:     getEnvInt("HEALTH_CHECK_JITTER", 20),
+		HealthCheckThreshold:  getEnvInt("HEALTH_CHECK_THRESHOLD", 2),
+		HealthCheckRecovery:   getEnvInt("HEALTH_CHECK_RECOVERY", 1),
+		HealthCheckMaxBackoff: time.Duration(getEnvInt("HEALTH_CHECK_MAX_BACKOFF", 0)) * time.Second,
+		HealthCheckExitIPURL:  getEnv("HEALTH_CHECK_EXIT_IP_URL", ""),
+
+		HealthCheckAdaptive:   getEnvBool("HEALTH_CHECK_ADAPTIVE", false),
+		HealthCheckIdleFactor: getEnvInt("HEALTH_CHECK_IDLE_FACTOR", 4),
+		HealthCheckMaxRate:    getEnvFloat("HEALTH_CHECK_MAX_RATE", 0),
+
+		CertWatchHosts: getEnvList("CERT_WATCH_HOSTS"),
+
+		CanaryURL:     getEnv("CANARY_URL", ""),
+		CanarySHA256:  getEnv("CANARY_SHA256", ""),
+		CanaryExclude: getEnvBool("CANARY_EXCLUDE", true),
+
+		MaxResponseHeaderBytes: int64(getEnvInt("MAX_RESPONSE_HEADER_BYTES", 64<<10)),
+		MaxResponseHeaders:     getEnvInt("MAX_RESPONSE_HEADERS", 200),
+
+		MaxRequestHeaderBytes: getEnvInt("MAX_REQUEST_HEADER_BYTES", 64<<10),
+		MaxRequestHeaders:     getEnvInt("MAX_REQUEST_HEADERS", 100),
+		MaxRequestBodyBytes:   getEnvBytes("MAX_REQUEST_BODY_BYTES", 32<<20),
+
+		RetryMaxAttempts:    getEnvInt("RETRY_MAX_ATTEMPTS", 1),
+		RetryOnStatus:       getEnvIntList("RETRY_ON_STATUS", nil),
+		RetryNonIdempotent:  getEnvBool("RETRY_NON_IDEMPOTENT", false),
+		RetryAttemptTimeout: time.Duration(getEnvInt("RETRY_ATTEMPT_TIMEOUT", 0)) * time.Second,
+
+		DestStatsHalfLife: time.Duration(getEnvInt("DEST_STATS_HALF_LIFE", 3600)) * time.Second,
+		DestStatsMaxHosts: getEnvInt("DEST_STATS_MAX_HOSTS", 1000),
+		RotationWindow:    time.Duration(getEnvInt("ROTATION_STATS_WINDOW", 60)) * time.Minute,
+
+		SizeMetricsTopHosts: getEnvInt("SIZE_METRICS_TOP_HOSTS", 10),
+
+		Pools:        getEnvMap("POOLS"),
+		PoolSchedule: getEnv("POOL_SCHEDULE", ""),
+
+		PoolChains: getEnvMap("POOL_CHAINS"),
+
+		UserPools:       getEnvMap("USER_POOLS"),
+		UsernameOptions: getEnvList("USERNAME_OPTIONS"),
+
+		FailoverTiers:     getEnv("FAILOVER_TIERS", ""),
+		FailoverThreshold: getEnvFloat("FAILOVER_THRESHOLD", 0.5),
+		FailoverRecover:   getEnvFloat("FAILOVER_RECOVER", 0.8),
+		FailoverHold:      time.Duration(getEnvInt("FAILOVER_HOLD", 60)) * time.Second,
+
+		BandwidthBudget:      getEnvBytes("BANDWIDTH_BUDGET", 0),
+		BandwidthAction:      getEnv("BANDWIDTH_BUDGET_ACTION", "block"),
+		BandwidthFallbackAPI: ExpandVars(getEnv("BANDWIDTH_FALLBACK_API", "")),
+		BandwidthUsageFile:   getEnv("BANDWIDTH_USAGE_FILE", ""),
+
+		BandwidthPerConnection: getEnvBytes("BANDWIDTH_PER_CONNECTION", 0),
+		BandwidthPerUser:       getEnvBytes("BANDWIDTH_PER_USER", 0),
+		UserTrafficQuota:       getEnvBytes("USER_TRAFFIC_QUOTA", 0),
+		UserTrafficPeriod:      strings.ToLower(getEnv("USER_TRAFFIC_PERIOD", "month")),
+		UserTrafficUsageFile:   getEnv("USER_TRAFFIC_USAGE_FILE", ""),
+
+		MaintenanceStatus:  getEnvInt("MAINTENANCE_STATUS", 503),
+		MaintenanceMessage: getEnv("MAINTENANCE_MESSAGE", "ProxyFlow 正在维护，请稍后重试"),
+
+		DrainTimeout: time.Duration(getEnvInt("DRAIN_TIMEOUT", 300)) * time.Second,
+
+		Priority:          getEnv("PRIORITY", "normal"),
+		MaxConnections:    getEnvInt("MAX_CONNECTIONS", 0),
+		ShedLowPercent:    getEnvInt("SHED_LOW_PERCENT", 70),
+		ShedNormalPercent: getEnvInt("SHED_NORMAL_PERCENT", 90),
+
+		ClientRPS:            getEnvFloat("CLIENT_RPS", 0),
+		ClientMaxConnections: getEnvInt("CLIENT_MAX_CONNECTIONS", 0),
+		UserRPS:              getEnvFloat("USER_RPS", 0),
+		UserMaxConnections:   getEnvInt("USER_MAX_CONNECTIONS", 0),
+		ClientBurst:          getEnvInt("CLIENT_BURST", 10),
+		ClientRPSMaxWait:     time.Duration(getEnvInt("CLIENT_RPS_MAX_WAIT", 0)) * time.Second,
+
+		AdminPort:  getEnv("ADMIN_PORT", ""),
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
 	}
 }
 
@@ -51,6 +534,7 @@ func Load() *Config {
 // 返回值：
 //   - string: 环境变量值或默认值
 func getEnv(key, defaultValue string) string {
+	record(key, "string", defaultValue)
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
@@ -66,6 +550,7 @@ func getEnv(key, defaultValue string) string {
 // 返回值：
 //   - int: 解析后的整数值或默认值
 func getEnvInt(key string, defaultValue int) int {
+	record(key, "integer", defaultValue)
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			return intValue
@@ -73,3 +558,138 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvFloat 获取环境变量浮点数值。
+//
+// 参数：
+//   - key: 环境变量名称
+//   - defaultValue: 默认值，当环境变量不存在或解析失败时使用
+//
+// 返回值：
+//   - float64: 解析后的浮点数值或默认值
+func getEnvFloat(key string, defaultValue float64) float64 {
+	record(key, "number", defaultValue)
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvBool 获取环境变量布尔值。
+//
+// 参数：
+//   - key: 环境变量名称
+//   - defaultValue: 默认值，当环境变量不存在或解析失败时使用
+//
+// 返回值：
+//   - bool: 解析后的布尔值或默认值
+func getEnvBool(key string, defaultValue bool) bool {
+	record(key, "boolean", defaultValue)
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvBytes 获取字节数环境变量，支持 KB、MB、GB、TB 单位（按1024进制）。
+//
+// 参数：
+//   - key: 环境变量名称
+//   - defaultValue: 默认值，当环境变量不存在或解析失败时使用
+//
+// 返回值：
+//   - int64: 解析后的字节数或默认值
+func getEnvBytes(key string, defaultValue int64) int64 {
+	record(key, "bytes", defaultValue)
+	value := strings.ToUpper(strings.TrimSpace(os.Getenv(key)))
+	if value == "" {
+		return defaultValue
+	}
+
+	multiplier := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		if strings.HasSuffix(value, suffix) {
+			multiplier = int64(1) << (10 * (i + 1))
+			value = strings.TrimSpace(strings.TrimSuffix(value, suffix))
+			break
+		}
+	}
+	value = strings.TrimSuffix(value, "B")
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return defaultValue
+	}
+	return int64(number * float64(multiplier))
+}
+
+// getEnvIntList 获取逗号分隔的整数列表环境变量。
+//
+// 参数：
+//   - key: 环境变量名称
+//   - defaultValue: 默认值，当环境变量不存在时使用
+//
+// 返回值：
+//   - []int: 解析后的整数列表，无法解析的项将被忽略
+func getEnvIntList(key string, defaultValue []int) []int {
+	record(key, "intlist", defaultValue)
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []int
+	for _, item := range strings.Split(value, ",") {
+		if intValue, err := strconv.Atoi(strings.TrimSpace(item)); err == nil {
+			result = append(result, intValue)
+		}
+	}
+	return result
+}
+
+// getEnvList 获取逗号分隔的字符串列表环境变量。
+//
+// 参数：
+//   - key: 环境变量名称
+//
+// 返回值：
+//   - []string: 去除空白后的非空项，环境变量不存在时为nil
+func getEnvList(key string) []string {
+	record(key, "list", nil)
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvMap 获取分号分隔的 name=value 映射环境变量。
+//
+// 值中可以包含等号和逗号，例如 "residential=http://a/api?n=1;dc=http://b/api"。
+//
+// 参数：
+//   - key: 环境变量名称
+//
+// 返回值：
+//   - map[string]string: 解析后的映射，环境变量不存在时为nil
+func getEnvMap(key string) map[string]string {
+	record(key, "map", nil)
+	var result map[string]string
+	for _, item := range strings.Split(os.Getenv(key), ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[strings.TrimSpace(name)] = ExpandVars(strings.TrimSpace(value))
+	}
+	return result
+}
@@ -0,0 +1,205 @@
+// Code generated by gen_descriptions.go; DO NOT EDIT.
+
+package config
+
+// descriptions 配置项说明，取自配置结构体的字段注释。
+var descriptions = map[string]string{
+	"ACCESS_HOURS":                  "按用户名限制的访问时间段",
+	"ADMIN_PORT":                    "管理API监听端口，为空则不启用",
+	"ADMIN_TOKEN":                   "管理API访问令牌",
+	"AGENT_CONNECTIONS":             "与中心保持的多路复用连接数",
+	"AGENT_DIAL_TIMEOUT":            "连接中心的超时时间",
+	"AGENT_LISTEN":                  "本地代理监听地址",
+	"AGENT_RECONNECT_MAX_DELAY":     "断线后重连的最长退避时间",
+	"AGENT_SERVER":                  "中心ProxyFlow多路复用端口地址（host:port）",
+	"AGENT_TLS":                     "是否使用TLS连接中心",
+	"AGENT_TLS_CA_FILE":             "校验中心证书的CA文件，为空则使用系统根证书",
+	"AGENT_TLS_SERVER_NAME":         "校验证书时使用的服务器名称，为空则取Server中的主机名",
+	"AGENT_TOKEN":                   "多路复用访问令牌，与中心的 MUX_TOKEN 相同",
+	"ALERT_DESTINATIONS":            "命中后产生告警事件的目标模式",
+	"ALLOW_IPS":                     "允许连接的客户端IP或CIDR网段，为空表示不限制",
+	"ALLOW_IPS_SKIP_AUTH":           "允许列表中的客户端未提供凭据时免于代理认证",
+	"AUTH_CACHE_TTL":                "按客户端IP缓存认证通过结果的时长，0表示只在连接内缓存",
+	"AUTH_CHALLENGE_BODY":           "407响应体文件路径，按扩展名推断内容类型，为空则不带响应体",
+	"AUTH_FAILURE_LOG":              "认证失败记录文件路径，为空则写入主日志",
+	"AUTH_PASSWORD":                 "代理服务器认证密码，与认证用户名一起作为一个明文密码的用户",
+	"AUTH_REALM":                    "407质询中的realm",
+	"AUTH_SCHEMES":                  "407质询中声明的认证方案，为空只声明Basic",
+	"AUTH_USERNAME":                 "代理服务器认证用户名",
+	"AUTH_USERS":                    "\"用户名:密码哈希\" 格式的认证用户列表",
+	"AUTH_USERS_FILE":               "htpasswd格式的认证用户文件路径，为空表示没有用户文件",
+	"AUTH_USERS_RELOAD":             "检查认证用户文件是否变化的间隔，0表示不重新加载",
+	"BANDWIDTH_BUDGET":              "主代理池每月流量预算（字节），0表示不启用",
+	"BANDWIDTH_BUDGET_ACTION":       "预算用尽后的处理策略：block、fallback、alert",
+	"BANDWIDTH_FALLBACK_API":        "预算用尽后使用的备用代理API",
+	"BANDWIDTH_PER_CONNECTION":      "单个连接或请求每秒允许传输的字节数，0表示不限制",
+	"BANDWIDTH_PER_USER":            "单个用户所有连接合计每秒允许传输的字节数，0表示不限制",
+	"BANDWIDTH_USAGE_FILE":          "流量用量持久化文件",
+	"BLOCK_DESTINATIONS":            "命中后产生告警事件并拒绝请求的目标模式",
+	"CANARY_EXCLUDE":                "是否停止使用篡改了金丝雀响应的代理",
+	"CANARY_SHA256":                 "金丝雀响应体的预期SHA-256，为空则直接请求获取",
+	"CANARY_URL":                    "健康检查成功后通过代理请求的金丝雀地址，为空则不检查内容完整性",
+	"CAPABILITY_PROBE":              "是否探测上游代理能力",
+	"CAPABILITY_PROBE_IPV6_TARGET":  "IPv6出口探测目标地址",
+	"CAPABILITY_PROBE_PORTS":        "需要探测的CONNECT端口",
+	"CAPABILITY_PROBE_TARGET":       "CONNECT端口探测目标主机",
+	"CAPABILITY_PROBE_TIMEOUT":      "单项探测超时时间",
+	"CAPABILITY_PROBE_TTL":          "探测结果有效期",
+	"CERT_WATCH_HOSTS":              "健康检查成功后通过代理观察TLS证书的目标，为空则不观察",
+	"CLIENT_BURST":                  "按客户端IP和用户限流时允许的突发请求数",
+	"CLIENT_MAX_CONNECTIONS":        "单个客户端IP同时进行的请求和隧道数上限，0表示不限制",
+	"CLIENT_RPS":                    "单个客户端IP每秒允许的请求数，0表示不限制",
+	"CLIENT_RPS_MAX_WAIT":           "超过请求速率时最多延迟多久再处理，0表示直接返回429",
+	"COOKIE_JAR_HOSTS":              "按粘性会话在服务端保存Cookie的目标模式，为空表示不启用",
+	"DAILY_CAPS":                    "全部用户共享的每日请求上限（目标模式到次数）",
+	"DAILY_CAPS_PER_USER":           "按用户分别计数的每日请求上限（目标模式到次数）",
+	"DENY_IPS":                      "拒绝连接的客户端IP或CIDR网段，优先于允许列表",
+	"DESTINATION_RULES_FILE":        "可疑目标规则文件路径，为空则不加载",
+	"DEST_STATS_HALF_LIFE":          "目标主机统计的衰减半衰期",
+	"DEST_STATS_MAX_HOSTS":          "最多统计的目标主机数，0表示不统计",
+	"DNS_STRICT":                    "严格DNS模式，禁止在本地解析目标主机名",
+	"DRAIN_TIMEOUT":                 "移除上游代理时默认的排空超时，0表示不强制关闭",
+	"DYNAMIC_PORTS":                 "可通过管理API动态分配的端口列表或范围，为空表示不启用",
+	"DYNAMIC_PORT_TTL":              "动态端口的默认有效期",
+	"FAILOVER_HOLD":                 "两次切换之间的最短间隔",
+	"FAILOVER_RECOVER":              "更高优先级的层健康代理比例达到该值时切回",
+	"FAILOVER_THRESHOLD":            "当前层健康代理比例低于该值时切换到下一层",
+	"FAILOVER_TIERS":                "代理池故障转移的优先级层，为空则不启用",
+	"H3_PORT":                       "HTTP/3代理监听的UDP端口，为空则不启用，复用TLS证书配置",
+	"HEADER_PROFILES_FILE":          "出站请求头画像文件路径，为空则不启用",
+	"HEALTH_CHECK":                  "是否启用主动健康检查",
+	"HEALTH_CHECK_ADAPTIVE":         "按代理的失败和使用情况自适应调整健康检查间隔",
+	"HEALTH_CHECK_EXIT_IP_URL":      "健康检查时查询出口IP的地址，为空则不记录",
+	"HEALTH_CHECK_IDLE_FACTOR":      "空闲的健康代理检查间隔最多放大到检查间隔的多少倍",
+	"HEALTH_CHECK_INTERVAL":         "同一代理两次健康检查的间隔",
+	"HEALTH_CHECK_JITTER":           "检查间隔的随机抖动百分比",
+	"HEALTH_CHECK_MAX_BACKOFF":      "不健康代理重试失败后等待时间加倍的上限，不大于检查间隔时不加倍，需要启用主动或被动健康检查",
+	"HEALTH_CHECK_MAX_RATE":         "全部代理池每秒最多发起的健康检查数，0表示不限制",
+	"HEALTH_CHECK_MODE":             "健康检查方式：http或connect",
+	"HEALTH_CHECK_POOL_MODES":       "按代理池名称覆盖的健康检查方式",
+	"HEALTH_CHECK_RECOVERY":         "不健康的代理连续成功多少次后恢复使用",
+	"HEALTH_CHECK_THRESHOLD":        "连续失败多少次后判定为不健康",
+	"HEALTH_CHECK_TIMEOUT":          "单次健康检查超时时间",
+	"HEALTH_CHECK_URL":              "健康检查通过代理访问的地址",
+	"HEALTH_CHECK_WORKERS":          "同时进行的健康检查数上限",
+	"HEALTH_PASSIVE":                "是否根据实际流量的结果更新代理健康状态",
+	"LOG_FILE":                      "日志文件路径，为空则输出到标准错误",
+	"LOG_FORMAT":                    "日志格式：text 或 json",
+	"MAINTENANCE_MESSAGE":           "维护模式下拒绝新请求的说明",
+	"MAINTENANCE_STATUS":            "维护模式下拒绝新请求的状态码",
+	"MAX_CONNECTIONS":               "同时处理的请求和隧道数上限，0表示不限制",
+	"MAX_CONNECTION_AGE":            "客户端连接和隧道的最大存活时间，0表示不限制",
+	"MAX_REQUEST_BODY_BYTES":        "明文HTTP请求体的最大字节数，0表示不限制",
+	"MAX_REQUEST_HEADERS":           "客户端请求头最大数量，0表示不限制",
+	"MAX_REQUEST_HEADER_BYTES":      "客户端请求行和请求头合计的最大字节数，0表示不限制",
+	"MAX_RESPONSE_HEADERS":          "上游响应头最大数量，0表示不限制",
+	"MAX_RESPONSE_HEADER_BYTES":     "上游响应头最大字节数",
+	"MUX_AGENTS":                    "按客户端代理身份分配的访问令牌（身份到令牌）",
+	"MUX_AGENT_MAX_STREAMS":         "每个客户端代理的并发连接数上限，0表示不限制",
+	"MUX_AGENT_POOLS":               "客户端代理绑定的代理池（身份到代理池名称）",
+	"MUX_PORT":                      "多路复用监听端口，为空则不启用",
+	"MUX_TLS":                       "多路复用端口是否使用TLS（复用TLS证书配置）",
+	"MUX_TOKEN":                     "客户端代理连接多路复用端口时使用的访问令牌",
+	"POOLS":                         "可按计划切换的具名代理池（名称到代理API）",
+	"POOL_CHAINS":                   "按代理池名称设置的上游代理链，值为逗号分隔的HTTP代理地址，连接池中的代理前按顺序经过",
+	"POOL_SCHEDULE":                 "代理池切换计划",
+	"POOL_SIZE":                     "连接池大小，启用预取时为每个代理API来源预取的代理数",
+	"PORT_POOLS":                    "代理端口绑定的代理池（端口到代理池名称）",
+	"PORT_SESSIONS":                 "是否为每个代理端口分配固定的粘性会话",
+	"PRIORITY":                      "全局默认的过载优先级：high、normal、low",
+	"PROXY_API":                     "代理API端点地址",
+	"PROXY_APIS":                    "额外的代理API端点（来源名称到URL）",
+	"PROXY_API_ERROR_BACKOFF":       "代理API失败后暂停请求并改用最近获取的代理的时间，0表示不暂停",
+	"PROXY_API_WEIGHT":              "同时配置代理API和代理列表文件时API来源的选择权重",
+	"PROXY_BYPASS":                  "直连绕过列表，格式同 NO_PROXY（域名、IP、CIDR、端口）",
+	"PROXY_CREDENTIALS":             "主代理池的凭据覆盖（代理地址或*到 user:pass）",
+	"PROXY_FILE":                    "静态代理列表文件路径，为空则只使用代理API",
+	"PROXY_FILE_KEY":                "解密代理列表文件的密钥（十六进制或base64），为空表示文件为明文",
+	"PROXY_FILE_KEY_FILE":           "保存代理列表文件密钥的文件路径，与ProxyFileKey只能配置一项",
+	"PROXY_FILE_RELOAD":             "检查代理列表文件变化的间隔，0表示不重新加载",
+	"PROXY_FILE_WEIGHT":             "同时配置代理API和代理列表文件时代理列表文件的选择权重",
+	"PROXY_LIST":                    "直接配置的静态代理列表",
+	"PROXY_PORT":                    "代理服务监听端口，可以是逗号分隔的列表或范围（如 8282-8291）",
+	"PROXY_PREFETCH":                "是否在后台预取代理API的代理，缓冲容量为 PoolSize",
+	"PROXY_PREFETCH_MAX_AGE":        "预取的代理在缓冲中的最长停留时间，0表示不限制",
+	"PROXY_SOURCE_REFRESH":          "按来源名称的刷新间隔（秒），API来源设置后改为定期拉取完整列表",
+	"PROXY_SOURCE_WEIGHTS":          "按来源名称覆盖的选择权重",
+	"PROXY_STRATEGY":                "从代理列表文件中选择代理的策略：round-robin、random、least-connections、weighted或ewma",
+	"QUEUE_MAX_SIZE":                "同时等待可用代理的请求数上限",
+	"QUEUE_MAX_WAIT":                "暂时没有可用代理时请求的最长等待时间，0表示不排队",
+	"REQUEST_TIMEOUT":               "请求超时时间",
+	"RESPONSE_HASH_MAX_BYTES":       "计算响应体校验和的大小上限（字节），0表示不计算",
+	"RETRY_ATTEMPT_TIMEOUT":         "每次尝试等待响应头的超时时间，0表示不限制",
+	"RETRY_MAX_ATTEMPTS":            "HTTP请求和CONNECT隧道最多尝试的次数，每次重新选择代理，0表示按代理池大小",
+	"RETRY_NON_IDEMPOTENT":          "是否也重试POST、PATCH等非幂等方法",
+	"RETRY_ON_STATUS":               "上游返回这些状态码时改用其他代理重试",
+	"REWRITE_MAX_BYTES":             "可改写的响应体大小上限（字节），超过上限的响应原样转发",
+	"REWRITE_RULES_FILE":            "响应体改写规则文件路径，为空则不改写",
+	"ROBOTS_AGENTS":                 "需要遵守robots.txt的爬虫身份（按User-Agent包含匹配），为空则不检查",
+	"ROBOTS_CACHE_TTL":              "robots.txt缓存时长",
+	"ROTATION_AVOID_REPEAT_EXIT":    "避免同一目标连续使用相同的出口IP",
+	"ROTATION_STATS_WINDOW":         "按用户统计出口轮换的保留时长，0表示不统计",
+	"ROUTES_FILE":                   "按目标主机的路由规则文件（YAML）路径，为空则不启用",
+	"SESSION_MAX_REQUESTS":          "每个上游代理对同一目标的最大请求数，0表示不限制",
+	"SESSION_QUOTA_WINDOW":          "会话配额统计窗口",
+	"SESSION_REDIS_PREFIX":          "redis 后端的键前缀，之后依次拼接代理池名称和会话ID",
+	"SESSION_REDIS_URL":             "redis 后端的Redis地址",
+	"SESSION_SEPARATOR":             "用户名中会话后缀的分隔符",
+	"SESSION_SOURCES":               "粘性会话ID的来源，按优先级排列",
+	"SESSION_STORE":                 "粘性会话存储后端：memory 或 redis",
+	"SHED_LOW_PERCENT":              "低优先级流量可使用的连接数百分比",
+	"SHED_NORMAL_PERCENT":           "普通优先级流量可使用的连接数百分比",
+	"SIZE_METRICS_TOP_HOSTS":        "请求和响应大小直方图中单独统计的目标主机数，0表示全部归入 other，小于0表示不统计",
+	"SLO_EVAL_INTERVAL":             "SLO评估间隔",
+	"SLO_MIN_SAMPLES":               "窗口内样本数少于该值时不评估SLO",
+	"SLO_OBJECTIVES":                "上游服务水平目标，例如 latency_p95<800ms;error_rate<5%",
+	"SLO_PER_PROXY":                 "是否同时按单个代理评估SLO",
+	"SLO_WEBHOOK_URL":               "SLO告警推送地址，为空则不推送",
+	"SLO_WINDOW":                    "SLO滑动窗口长度",
+	"SOCKS_PORT":                    "SOCKS5监听端口，为空则不启用",
+	"SSH_KEY_FILE":                  "SSH上游默认使用的私钥文件，为空则只使用URL中的密码或key参数",
+	"SSH_KNOWN_HOSTS":               "校验SSH上游主机密钥的known_hosts文件，为空则接受任意主机密钥",
+	"STICKY_SESSION_MAX":            "每个代理池的粘性会话数上限，0表示不限制",
+	"STICKY_SESSION_TTL":            "粘性会话空闲过期时间",
+	"STREAMING_TUNNEL_WINDOW":       "流式隧道识别窗口，0表示不识别",
+	"TCP_KEEPALIVE":                 "连接空闲多久后开始发送TCP keepalive探测，0表示关闭keepalive",
+	"TCP_KEEPALIVE_COUNT":           "连续多少次keepalive探测无响应后断开连接",
+	"TCP_KEEPALIVE_INTERVAL":        "TCP keepalive探测的间隔",
+	"TLS_CERT_FILE":                 "TLS证书文件路径",
+	"TLS_CIPHER_SUITES":             "TLS监听器允许的密码套件",
+	"TLS_CURVES":                    "TLS监听器允许的密钥交换曲线",
+	"TLS_HANDSHAKE_RATE":            "单个来源IP每分钟允许的TLS握手次数",
+	"TLS_KEY_FILE":                  "TLS私钥文件路径",
+	"TLS_MAX_HANDSHAKES":            "TLS监听器同时进行的握手数上限",
+	"TLS_MIN_VERSION":               "TLS监听器最低版本",
+	"TLS_PORT":                      "TLS代理监听端口，为空则不启用",
+	"TLS_ROUTES":                    "TLS监听器按SNI或ALPN分流的端点（路由键到端点名称）",
+	"TRAFFIC_LABELS":                "流量标签到匹配条件的映射，为空则不打标签",
+	"TUNNEL_IDLE_TIMEOUT":           "隧道双向都没有数据传输多久后关闭，0表示不限制",
+	"TUNNEL_MAX_DURATION":           "隧道的绝对最长存活时间，流式隧道同样受限，0表示不限制",
+	"UPDATE_DRAIN_TIMEOUT":          "进程交接后旧进程等待活跃隧道结束的时间，超时后强制退出",
+	"UPDATE_PUBLIC_KEY":             "校验发布文件签名的Ed25519公钥（Base64），为空则拒绝更新",
+	"UPDATE_READY_TIMEOUT":          "进程交接时等待新进程就绪的时间，超时则放弃交接继续运行",
+	"UPDATE_URL":                    "自更新使用的发布清单地址，为空则不能自更新",
+	"UPSTREAM_ADAPTIVE_CONCURRENCY": "按上游代理自适应地限制并发连接和请求数",
+	"UPSTREAM_BURST":                "每个上游代理允许的突发请求数",
+	"UPSTREAM_CONCURRENCY_INITIAL":  "每个上游代理的初始并发上限",
+	"UPSTREAM_CONCURRENCY_MAX":      "自适应并发上限的上限",
+	"UPSTREAM_CONCURRENCY_MIN":      "自适应并发上限的下限",
+	"UPSTREAM_LATENCY_TOLERANCE":    "延迟超过基准延迟多少倍时缩减并发上限",
+	"UPSTREAM_PLUGINS":              "提供额外上游协议拨号器的Go插件（.so）路径，需要以cgo构建",
+	"UPSTREAM_RPS":                  "每个上游代理每秒允许的请求数，0表示不限制",
+	"UPSTREAM_RPS_MAX_WAIT":         "请求等待上游代理速率令牌的最长时间",
+	"USERNAME_OPTIONS":              "允许写在认证用户名中的参数键，pool 选择代理池，其他键作为代理标签",
+	"USER_MAX_CONNECTIONS":          "单个用户同时进行的请求和隧道数上限，0表示不限制",
+	"USER_POOLS":                    "认证用户绑定的代理池（用户名到代理池名称）",
+	"USER_RPS":                      "单个用户每秒允许的请求数，0表示不限制",
+	"USER_TRAFFIC_PERIOD":           "用户流量配额的统计周期：day、week、month",
+	"USER_TRAFFIC_QUOTA":            "单个用户每个统计周期允许传输的字节数，0表示不限制",
+	"USER_TRAFFIC_USAGE_FILE":       "用户流量用量持久化文件",
+	"VERSION_HEADER":                "是否在响应中添加 X-ProxyFlow-Version 头，标明处理请求的构建版本",
+	"WIREGUARD_TUNNELS":             "用户态WireGuard隧道（名称到 wg-quick 配置文件路径）",
+	"WS_PATH":                       "接受WebSocket升级请求的路径",
+	"WS_PORT":                       "WebSocket监听端口，为空则不启用",
+	"WS_TLS":                        "WebSocket端口是否使用TLS（复用TLS证书配置）",
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"os"
+	"regexp"
+)
+
+// varPattern 匹配 ${VAR} 形式的变量引用
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandVars 展开字符串中 ${VAR} 形式的环境变量引用。
+//
+// 只识别带花括号的形式，避免误改密码等值中出现的 "$" 字符；
+// 未定义的变量保持原样，便于在日志和错误信息中发现配置遗漏。
+//
+// 参数：
+//   - s: 待展开的字符串
+//
+// 返回值：
+//   - string: 展开后的字符串
+func ExpandVars(s string) string {
+	return varPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if value, ok := os.LookupEnv(ref[2 : len(ref)-1]); ok {
+			return value
+		}
+		return ref
+	})
+}
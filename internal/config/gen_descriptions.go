@@ -0,0 +1,114 @@
+//go:build ignore
+
+// gen_descriptions 从配置结构体的字段注释生成配置项说明（descriptions.go）。
+//
+// 扫描 Load 和 LoadAgent 中 字段: getEnv*("KEY", ...) 形式的赋值，
+// 以字段注释作为对应环境变量的说明。修改配置项后运行 go generate ./internal/config。
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	fset := token.NewFileSet()
+	comments := make(map[string]string) // 结构体名.字段名 -> 注释
+	keys := make(map[string]string)     // 环境变量名 -> 结构体名.字段名
+
+	for _, name := range []string{"config.go", "agent.go"} {
+		file, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.TypeSpec:
+				st, ok := n.Type.(*ast.StructType)
+				if !ok {
+					return true
+				}
+				for _, field := range st.Fields.List {
+					if field.Comment == nil {
+						continue
+					}
+					for _, ident := range field.Names {
+						comments[n.Name.Name+"."+ident.Name] = strings.TrimSpace(field.Comment.Text())
+					}
+				}
+			case *ast.CompositeLit:
+				typeName, ok := n.Type.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				for _, elt := range n.Elts {
+					kv, ok := elt.(*ast.KeyValueExpr)
+					if !ok {
+						continue
+					}
+					field, ok := kv.Key.(*ast.Ident)
+					if !ok {
+						continue
+					}
+					if key := envKey(kv.Value); key != "" {
+						keys[key] = typeName.Name + "." + field.Name
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gen_descriptions.go; DO NOT EDIT.\n\npackage config\n\n")
+	buf.WriteString("// descriptions 配置项说明，取自配置结构体的字段注释。\nvar descriptions = map[string]string{\n")
+	for _, key := range names {
+		if comment := comments[keys[key]]; comment != "" {
+			fmt.Fprintf(&buf, "\t%q: %q,\n", key, comment)
+		}
+	}
+	buf.WriteString("}\n")
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("descriptions.go", source, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// envKey 返回表达式中第一个 getEnv* 调用读取的环境变量名。
+func envKey(expr ast.Expr) string {
+	var key string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || key != "" {
+			return key == ""
+		}
+		fun, ok := call.Fun.(*ast.Ident)
+		if !ok || !strings.HasPrefix(fun.Name, "getEnv") || len(call.Args) == 0 {
+			return true
+		}
+		if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			key, _ = strconv.Unquote(lit.Value)
+		}
+		return false
+	})
+	return key
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings 可按监听器和用户分层覆盖的设置。
+type Settings struct {
+	RequestTimeout  time.Duration // 请求超时时间
+	MaxConnAge      time.Duration // 客户端连接和隧道的最大存活时间，0表示不限制
+	StreamingWindow time.Duration // 流式隧道识别窗口，0表示不识别
+	Priority        string        // 过载时的优先级：high、normal、low
+
+	TunnelIdleTimeout time.Duration // 隧道双向都没有数据传输多久后关闭，0表示不限制
+	TunnelMaxDuration time.Duration // 隧道的绝对最长存活时间，流式隧道同样受限，0表示不限制
+}
+
+// Overrides 某一层对设置的覆盖，nil字段表示沿用上一层的值。
+type Overrides struct {
+	RequestTimeout  *time.Duration // 请求超时时间
+	MaxConnAge      *time.Duration // 客户端连接和隧道的最大存活时间
+	StreamingWindow *time.Duration // 流式隧道识别窗口
+	Priority        *string        // 过载时的优先级
+
+	TunnelIdleTimeout *time.Duration // 隧道空闲超时
+	TunnelMaxDuration *time.Duration // 隧道的绝对最长存活时间
+}
+
+// Apply 将覆盖应用到设置上，返回新的设置。
+//
+// 参数：
+//   - o: 覆盖项
+//
+// 返回值：
+//   - Settings: 覆盖后的设置
+func (s Settings) Apply(o Overrides) Settings {
+	if o.RequestTimeout != nil {
+		s.RequestTimeout = *o.RequestTimeout
+	}
+	if o.MaxConnAge != nil {
+		s.MaxConnAge = *o.MaxConnAge
+	}
+	if o.StreamingWindow != nil {
+		s.StreamingWindow = *o.StreamingWindow
+	}
+	if o.Priority != nil {
+		s.Priority = *o.Priority
+	}
+	if o.TunnelIdleTimeout != nil {
+		s.TunnelIdleTimeout = *o.TunnelIdleTimeout
+	}
+	if o.TunnelMaxDuration != nil {
+		s.TunnelMaxDuration = *o.TunnelMaxDuration
+	}
+	return s
+}
+
+// Layers 分层配置：全局默认值 -> 监听器 -> 用户。
+//
+// 后一层只覆盖显式设置的项，未设置的项沿用前一层的值。
+type Layers struct {
+	Global    Settings             // 全局默认值
+	Listeners map[string]Overrides // 按监听器名称（http、tls）覆盖
+	Users     map[string]Overrides // 按认证用户名覆盖
+}
+
+// Resolve 计算指定监听器和用户的最终设置。
+//
+// 参数：
+//   - listener: 监听器名称
+//   - user: 认证用户名，为空表示匿名或尚未认证
+//
+// 返回值：
+//   - Settings: 逐层覆盖后的设置
+func (l Layers) Resolve(listener, user string) Settings {
+	settings := l.Global
+	if o, ok := l.Listeners[listener]; ok {
+		settings = settings.Apply(o)
+	}
+	if o, ok := l.Users[user]; ok && user != "" {
+		settings = settings.Apply(o)
+	}
+	return settings
+}
+
+// Layers 从配置和环境变量构建分层配置。
+//
+// 监听器覆盖项形如 LISTENER_<名称>_REQUEST_TIMEOUT，用户覆盖项形如
+// USER_<用户名>_REQUEST_TIMEOUT，可覆盖 REQUEST_TIMEOUT、MAX_CONNECTION_AGE、
+// STREAMING_TUNNEL_WINDOW、TUNNEL_IDLE_TIMEOUT 和 TUNNEL_MAX_DURATION（单位均为秒）以及 PRIORITY。
+//
+// 返回值：
+//   - Layers: 分层配置
+func (c *Config) Layers() Layers {
+	return Layers{
+		Global: Settings{
+			RequestTimeout:  c.RequestTimeout,
+			MaxConnAge:      c.MaxConnAge,
+			StreamingWindow: c.StreamingWindow,
+			Priority:        c.Priority,
+
+			TunnelIdleTimeout: c.TunnelIdleTimeout,
+			TunnelMaxDuration: c.TunnelMaxDuration,
+		},
+		Listeners: loadOverrides("LISTENER_", strings.ToLower),
+		Users:     loadOverrides("USER_", func(name string) string { return name }),
+	}
+}
+
+// overrideKeys 可分层覆盖的设置项及其在Overrides中的位置。
+var overrideKeys = map[string]func(*Overrides) **time.Duration{
+	"_REQUEST_TIMEOUT":         func(o *Overrides) **time.Duration { return &o.RequestTimeout },
+	"_MAX_CONNECTION_AGE":      func(o *Overrides) **time.Duration { return &o.MaxConnAge },
+	"_STREAMING_TUNNEL_WINDOW": func(o *Overrides) **time.Duration { return &o.StreamingWindow },
+	"_TUNNEL_IDLE_TIMEOUT":     func(o *Overrides) **time.Duration { return &o.TunnelIdleTimeout },
+	"_TUNNEL_MAX_DURATION":     func(o *Overrides) **time.Duration { return &o.TunnelMaxDuration },
+}
+
+// loadOverrides 扫描指定前缀的环境变量，构建按名称索引的覆盖项。
+//
+// 参数：
+//   - prefix: 环境变量前缀
+//   - normalize: 名称规范化函数
+//
+// 返回值：
+//   - map[string]Overrides: 名称到覆盖项的映射，没有覆盖项时为nil
+func loadOverrides(prefix string, normalize func(string) string) map[string]Overrides {
+	var result map[string]Overrides
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if name, ok := strings.CutSuffix(strings.TrimPrefix(key, prefix), "_PRIORITY"); ok && name != "" {
+			if result == nil {
+				result = make(map[string]Overrides)
+			}
+			name = normalize(name)
+			o := result[name]
+			priority := strings.ToLower(strings.TrimSpace(value))
+			o.Priority = &priority
+			result[name] = o
+			continue
+		}
+		for suffix, field := range overrideKeys {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(key, prefix), suffix)
+			if !ok || name == "" {
+				continue
+			}
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			if result == nil {
+				result = make(map[string]Overrides)
+			}
+			name = normalize(name)
+			o := result[name]
+			d := time.Duration(seconds) * time.Second
+			*field(&o) = &d
+			result[name] = o
+		}
+	}
+	return result
+}
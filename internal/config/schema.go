@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//go:generate go run gen_descriptions.go
+
+// Option 一个配置项的说明。
+type Option struct {
+	Name        string      // 环境变量名称
+	Type        string      // 值类型：string、integer、number、boolean、bytes、list、intlist、map
+	Default     interface{} // 默认值，nil表示没有默认值
+	Description string      // 说明，取自配置结构体字段的注释
+}
+
+var (
+	// recording 正在记录的配置项，为nil时不记录
+	recording map[string]Option
+	// recordMutex 保证同一时间只有一次记录
+	recordMutex sync.Mutex
+)
+
+// record 在记录配置项期间登记一次环境变量读取。
+//
+// 只在Options中调用Load等函数时生效，正常加载配置时recording为nil。
+//
+// 参数：
+//   - key: 环境变量名称
+//   - kind: 值类型
+//   - defaultValue: 默认值
+func record(key, kind string, defaultValue interface{}) {
+	if recording == nil {
+		return
+	}
+	if _, ok := recording[key]; ok {
+		return
+	}
+	recording[key] = Option{Name: key, Type: kind, Default: defaultValue, Description: descriptions[key]}
+}
+
+// Options 列出全部配置项，包括服务端和客户端代理模式的配置。
+//
+// 通过记录加载配置时读取的环境变量得到，新增配置项无需另外登记；
+// 默认值与环境变量的当前值无关。
+//
+// 返回值：
+//   - []Option: 按名称排序的配置项
+func Options() []Option {
+	recordMutex.Lock()
+	defer recordMutex.Unlock()
+
+	recording = make(map[string]Option)
+	defer func() { recording = nil }()
+	Load()
+	LoadAgent()
+
+	options := make([]Option, 0, len(recording))
+	for _, option := range recording {
+		options = append(options, option)
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Name < options[j].Name })
+	return options
+}
+
+// Schema 生成描述全部配置项的JSON Schema。
+//
+// 环境变量和 .env 文件中的值均为字符串，Schema中的类型表示解析后的值；
+// 列表、映射和字节数另以 x-env-format 说明在环境变量中的写法。
+// 分层配置的 LISTENER_<名称>_* 和 USER_<用户名>_* 覆盖项以 patternProperties 描述。
+//
+// 返回值：
+//   - map[string]interface{}: JSON Schema（draft-07）
+func Schema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, option := range Options() {
+		property := map[string]interface{}{}
+		switch option.Type {
+		case "list":
+			property["type"] = "array"
+			property["items"] = map[string]string{"type": "string"}
+			property["x-env-format"] = "逗号分隔，例如 a,b"
+		case "intlist":
+			property["type"] = "array"
+			property["items"] = map[string]string{"type": "integer"}
+			property["x-env-format"] = "逗号分隔，例如 80,443"
+		case "map":
+			property["type"] = "object"
+			property["additionalProperties"] = map[string]string{"type": "string"}
+			property["x-env-format"] = "分号分隔的 name=value，例如 a=1;b=2"
+		case "bytes":
+			property["type"] = "string"
+			property["pattern"] = `^\s*[0-9.]+\s*([KMGTkmgt]?[Bb])?\s*$`
+			property["x-env-format"] = "字节数，支持 KB、MB、GB、TB 单位（按1024进制）"
+			option.Default = fmt.Sprint(option.Default)
+		default:
+			property["type"] = option.Type
+		}
+		if option.Description != "" {
+			property["description"] = option.Description
+		}
+		if option.Default != nil {
+			property["default"] = option.Default
+		}
+		properties[option.Name] = property
+	}
+
+	layered := map[string]interface{}{
+		"type":        "integer",
+		"description": "按监听器或用户覆盖 REQUEST_TIMEOUT、MAX_CONNECTION_AGE、STREAMING_TUNNEL_WINDOW（秒）",
+	}
+	priority := map[string]interface{}{
+		"type":        "string",
+		"enum":        []string{"high", "normal", "low"},
+		"description": "按监听器或用户覆盖 PRIORITY",
+	}
+	return map[string]interface{}{
+		"$schema":    "http://json-schema.org/draft-07/schema#",
+		"title":      "ProxyFlow",
+		"type":       "object",
+		"properties": properties,
+		"patternProperties": map[string]interface{}{
+			`^(LISTENER|USER)_.+_(REQUEST_TIMEOUT|MAX_CONNECTION_AGE|STREAMING_TUNNEL_WINDOW)$`: layered,
+			`^(LISTENER|USER)_.+_PRIORITY$`: priority,
+		},
+		"additionalProperties": false,
+	}
+}
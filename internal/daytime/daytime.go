@@ -0,0 +1,67 @@
+// Package daytime 提供一天中的时间段，供代理池切换计划和用户访问时间段共用。
+package daytime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window 一天中的时间段，左闭右开。
+type Window struct {
+	Start int `json:"start"` // 开始时间（一天中的分钟数）
+	End   int `json:"end"`   // 结束时间（一天中的分钟数），不大于开始时间表示跨越午夜
+}
+
+// ParseWindow 解析 "HH:MM-HH:MM" 格式的时间段，例如 "09:00-18:00" 或 "22:00-06:00"（跨越午夜）。
+//
+// 参数：
+//   - text: 时间段
+//
+// 返回值：
+//   - Window: 时间段
+//   - error: 格式错误
+func ParseWindow(text string) (Window, error) {
+	startText, endText, ok := strings.Cut(text, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("时间段格式错误: %s", text)
+	}
+	start, err := ParseClock(startText)
+	if err != nil {
+		return Window{}, err
+	}
+	end, err := ParseClock(endText)
+	if err != nil {
+		return Window{}, err
+	}
+	return Window{Start: start, End: end}, nil
+}
+
+// Contains 判断一天中的某一分钟是否落在时间段内。
+func (w Window) Contains(minute int) bool {
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// ParseClock 将 "HH:MM" 解析为一天中的分钟数。
+//
+// 参数：
+//   - text: 时刻，首尾空白被忽略
+//
+// 返回值：
+//   - int: 一天中的分钟数
+//   - error: 格式错误
+func ParseClock(text string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误: %s", text)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Minute 返回时刻在其所在时区中是一天中的第几分钟。
+func Minute(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
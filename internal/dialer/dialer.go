@@ -0,0 +1,240 @@
+// Package dialer 提供按URL协议注册的上游拨号器。
+//
+// HTTP和HTTPS代理由内置的CONNECT逻辑处理；其他上游协议（如ssh、wg、ss）
+// 各自在init中注册一个Dialer，代理池解析、健康检查、能力探测和请求转发
+// 都通过本包查找拨号器，新增协议时无需修改这些代码。
+// 也可以用 LoadPlugins 从Go插件加载拨号器。
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// Dialer 经某种上游协议连接目标地址。
+type Dialer interface {
+	// DialContext 经上游连接目标地址（host:port格式），目标主机名交给上游解析。
+	DialContext(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error)
+}
+
+// Func 将普通函数适配为Dialer。
+type Func func(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error)
+
+// DialContext 调用函数本身。
+func (f Func) DialContext(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+	return f(ctx, proxy, addr)
+}
+
+// Normalizer 可由Dialer实现，在解析URL之前改写原始代理地址，
+// 用于无法直接按URL解析的写法（如整体base64编码的地址）。
+type Normalizer interface {
+	Normalize(raw string) (string, error)
+}
+
+// Preparer 可由Dialer实现，在解析出代理信息后校验并补全，
+// 例如补充默认端口或从用户信息中解码凭据。
+type Preparer interface {
+	Prepare(proxy *models.ProxyInfo) error
+}
+
+var (
+	dialers = make(map[string]Dialer) // 按协议索引的拨号器
+	mutex   sync.RWMutex              // 读写锁
+
+	tcpDialer net.Dialer // 连接上游代理服务器的TCP拨号器，只在启动时设置
+)
+
+// ConfigureKeepAlive 设置连接上游代理服务器时的TCP keepalive。
+//
+// 隧道可能长时间没有数据，开启keepalive后NAT后面已经失效的上游连接能被及时发现并释放。
+//
+// 参数：
+//   - cfg: keepalive配置，Enable为false时关闭keepalive
+func ConfigureKeepAlive(cfg net.KeepAliveConfig) {
+	tcpDialer = net.Dialer{KeepAliveConfig: cfg}
+	if !cfg.Enable {
+		tcpDialer.KeepAlive = -1
+	}
+}
+
+// DialTCP 按 ConfigureKeepAlive 的设置建立到上游代理服务器的TCP连接。
+//
+// 内置的HTTP CONNECT和各上游协议连接代理服务器时应使用 DialProxy，它在代理没有代理链时调用本函数；
+// 直接连接目标时使用本函数。
+//
+// 参数：
+//   - ctx: 控制建立连接的上下文
+//   - addr: 代理服务器地址（host:port格式）
+//
+// 返回值：
+//   - net.Conn: TCP连接
+//   - error: 连接失败的原因
+func DialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	return tcpDialer.DialContext(ctx, "tcp", addr)
+}
+
+// Register 注册协议的拨号器。
+//
+// 通常在实现协议的包的init中调用；协议名不区分大小写，不能是http或https，
+// 重复注册时后注册的覆盖先注册的。
+//
+// 参数：
+//   - scheme: URL协议名
+//   - d: 拨号器
+func Register(scheme string, d Dialer) {
+	scheme = strings.ToLower(scheme)
+	if scheme == "http" || scheme == "https" {
+		panic("dialer: http和https由内置逻辑处理，不能注册")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := dialers[scheme]; ok {
+		log.Printf("上游协议 %s 的拨号器被重新注册", scheme)
+	}
+	dialers[scheme] = d
+}
+
+// Lookup 查找代理使用的拨号器。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+//
+// 返回值：
+//   - Dialer: 拨号器
+//   - bool: 代理协议是否注册了拨号器，HTTP和HTTPS代理始终为false
+func Lookup(proxy models.ProxyInfo) (Dialer, bool) {
+	if proxy.URL == nil {
+		return nil, false
+	}
+	return ForScheme(proxy.URL.Scheme)
+}
+
+// ForScheme 按协议名查找拨号器。
+func ForScheme(scheme string) (Dialer, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	d, ok := dialers[strings.ToLower(scheme)]
+	return d, ok
+}
+
+// Schemes 返回已注册的协议名，按字母排序。
+func Schemes() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	schemes := make([]string, 0, len(dialers))
+	for scheme := range dialers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Dial 经注册的拨号器连接目标，timeout大于0时限制建立连接的时间。
+//
+// 参数：
+//   - d: 拨号器
+//   - proxy: 代理服务器信息
+//   - addr: 目标地址（host:port格式）
+//   - timeout: 建立连接的超时时间，0表示不限制
+//
+// 返回值：
+//   - net.Conn: 到目标的连接
+//   - error: 连接失败的原因
+func Dial(d Dialer, proxy models.ProxyInfo, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return d.DialContext(ctx, proxy, addr)
+}
+
+// TransportDial 返回经拨号器连接目标的函数，供 http.Transport.DialContext 使用。
+func TransportDial(d Dialer, proxy models.ProxyInfo) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, proxy, addr)
+	}
+}
+
+// ErrPluginsUnsupported 当前构建不支持Go插件：未启用cgo（如官方Docker镜像），或平台不是Linux、macOS、FreeBSD
+var ErrPluginsUnsupported = errors.New("此构建不支持上游协议插件，需要在Linux、macOS或FreeBSD上以 CGO_ENABLED=1 构建")
+
+// PluginDialFunc Go插件导出的 Dial 符号的类型。
+//
+// 插件无法引用本模块的内部包，因此只使用标准库类型：proxy为带认证信息的代理URL
+// （不含片段中的标签）。
+type PluginDialFunc = func(ctx context.Context, proxy *url.URL, addr string) (net.Conn, error)
+
+// LoadPlugins 加载Go插件中的拨号器。
+//
+// 每个插件需导出 Schemes（[]string，处理的协议名）和 Dial（PluginDialFunc）两个符号。
+// Go插件要求与主程序使用相同的Go版本和依赖版本构建，且主程序需要启用cgo构建，
+// 否则配置了插件时返回 ErrPluginsUnsupported。
+//
+// 参数：
+//   - paths: 插件文件（.so）路径
+//
+// 返回值：
+//   - error: 任一插件无法加载或符号不符合要求时返回错误
+func LoadPlugins(paths []string) error {
+	for _, path := range paths {
+		lookup, err := openPlugin(path)
+		if err != nil {
+			return fmt.Errorf("加载上游协议插件 %s 失败: %w", path, err)
+		}
+		schemesSym, err := lookup("Schemes")
+		if err != nil {
+			return fmt.Errorf("上游协议插件 %s 未导出 Schemes: %v", path, err)
+		}
+		schemes, ok := schemesSym.(*[]string)
+		if !ok || len(*schemes) == 0 {
+			return fmt.Errorf("上游协议插件 %s 的 Schemes 必须是非空的 []string", path)
+		}
+		dialSym, err := lookup("Dial")
+		if err != nil {
+			return fmt.Errorf("上游协议插件 %s 未导出 Dial: %v", path, err)
+		}
+		dial, ok := dialSym.(PluginDialFunc)
+		if !ok {
+			if ptr, isPtr := dialSym.(*PluginDialFunc); isPtr && *ptr != nil {
+				dial, ok = *ptr, true
+			}
+		}
+		if !ok {
+			return errors.New("上游协议插件 " + path + " 的 Dial 签名必须是 func(context.Context, *url.URL, string) (net.Conn, error)")
+		}
+
+		d := pluginDialer(dial)
+		for _, scheme := range *schemes {
+			Register(scheme, d)
+		}
+		log.Printf("已加载上游协议插件 %s: %s", path, strings.Join(*schemes, ", "))
+	}
+	return nil
+}
+
+// pluginDialer 将插件的Dial函数适配为Dialer，传入带当前凭据的代理URL。
+func pluginDialer(dial PluginDialFunc) Dialer {
+	return Func(func(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+		proxyURL := *proxy.URL
+		proxyURL.Fragment = ""
+		proxyURL.User = nil
+		if proxy.Username != "" {
+			proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+		}
+		return dial(ctx, &proxyURL, addr)
+	})
+}
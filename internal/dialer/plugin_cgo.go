@@ -0,0 +1,16 @@
+//go:build cgo && (linux || darwin || freebsd)
+
+package dialer
+
+import "plugin"
+
+// openPlugin 打开Go插件，返回查找导出符号的函数。
+func openPlugin(path string) (func(name string) (any, error), error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(name string) (any, error) {
+		return p.Lookup(name)
+	}, nil
+}
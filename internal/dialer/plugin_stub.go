@@ -0,0 +1,8 @@
+//go:build !cgo || !(linux || darwin || freebsd)
+
+package dialer
+
+// openPlugin 未启用cgo或平台不支持Go插件时的实现，总是返回 ErrPluginsUnsupported。
+func openPlugin(string) (func(name string) (any, error), error) {
+	return nil, ErrPluginsUnsupported
+}
@@ -0,0 +1,147 @@
+// Package hostmatch 提供各类规则共用的目标主机模式。
+//
+// 路由、可疑目标、请求头画像、响应改写、Cookie Jar、流量标签和每日请求上限都按目标主机匹配规则，
+// 统一使用本包的模式写法：
+//   - "example.com" 精确匹配
+//   - ".example.com" 匹配example.com及其所有子域名
+//   - "*.example.com"、"api-?.example.com" 通配符，"*" 匹配任意字符（包括 "."），"?" 匹配单个字符，"*" 匹配所有主机
+//   - "regex:^shop[0-9]+\.example\.com$" 正则表达式
+//
+// 匹配不区分大小写。
+package hostmatch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pattern 编译后的主机模式。
+type Pattern struct {
+	raw    string         // 原始模式
+	exact  string         // 精确匹配的主机名
+	suffix string         // 后缀匹配，以 "." 开头
+	apex   bool           // 后缀匹配时是否也匹配去掉开头 "." 的域名本身
+	any    bool           // 是否匹配所有主机
+	regex  *regexp.Regexp // 其他通配符或正则表达式
+}
+
+// Compile 编译主机模式。
+//
+// 参数：
+//   - pattern: 主机模式，首尾空白被忽略
+//
+// 返回值：
+//   - Pattern: 编译后的模式
+//   - error: 模式为空或正则表达式无效
+func Compile(pattern string) (Pattern, error) {
+	pattern = strings.TrimSpace(pattern)
+	p := Pattern{raw: pattern}
+	lower := strings.ToLower(pattern)
+	switch {
+	case pattern == "":
+		return p, fmt.Errorf("主机模式为空")
+	case strings.HasPrefix(pattern, "regex:"):
+		re, err := regexp.Compile("(?i)" + strings.TrimPrefix(pattern, "regex:"))
+		if err != nil {
+			return p, fmt.Errorf("无效的正则表达式 %s: %v", pattern, err)
+		}
+		p.regex = re
+	case lower == "*":
+		p.any = true
+	case strings.HasPrefix(lower, "*.") && !strings.ContainsAny(lower[2:], "*?"):
+		p.suffix = lower[1:]
+	case strings.ContainsAny(lower, "*?"):
+		var b strings.Builder
+		b.WriteString("^")
+		for _, c := range lower {
+			switch c {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		b.WriteString("$")
+		p.regex = regexp.MustCompile(b.String())
+	case strings.HasPrefix(lower, "."):
+		p.suffix, p.apex = lower, true
+	default:
+		p.exact = lower
+	}
+	return p, nil
+}
+
+// Match 判断主机名是否匹配，主机名应已经过 Normalize。
+//
+// 参数：
+//   - host: 目标主机名或IP地址
+//
+// 返回值：
+//   - bool: 是否匹配
+func (p Pattern) Match(host string) bool {
+	switch {
+	case p.any:
+		return true
+	case p.regex != nil:
+		return p.regex.MatchString(host)
+	case p.suffix != "":
+		return strings.HasSuffix(host, p.suffix) || (p.apex && host == p.suffix[1:])
+	default:
+		return host == p.exact
+	}
+}
+
+// String 返回原始模式。
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// List 一组主机模式，任一模式匹配即视为匹配。
+type List []Pattern
+
+// CompileList 编译一组主机模式。
+//
+// 参数：
+//   - patterns: 主机模式
+//
+// 返回值：
+//   - List: 编译后的模式，patterns为空时为nil
+//   - error: 任一模式无效
+func CompileList(patterns []string) (List, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	list := make(List, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// Match 判断主机名是否匹配任一模式，主机名应已经过 Normalize。
+func (l List) Match(host string) bool {
+	for i := range l {
+		if l[i].Match(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize 将目标主机转为匹配使用的形式：小写，去掉IPv6地址的方括号和末尾的 "."。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//
+// 返回值：
+//   - string: 规范化的主机名
+func Normalize(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
@@ -0,0 +1,55 @@
+package hostmatch
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		match   bool
+	}{
+		{"example.com", "example.com", true},
+		{"Example.COM", "example.com", true},
+		{"example.com", "api.example.com", false},
+		{".example.com", "example.com", true},
+		{".example.com", "a.b.example.com", true},
+		{".example.com", "badexample.com", false},
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*", "anything.test", true},
+		{"api-?.example.com", "api-1.example.com", true},
+		{"api-?.example.com", "api-12.example.com", false},
+		{"shop*.example.com", "shop-eu.example.com", true},
+		{"regex:^shop[0-9]+\\.example\\.com$", "shop42.example.com", true},
+		{"regex:^shop[0-9]+\\.example\\.com$", "shop.example.com", false},
+		{"regex:^API\\.", "api.example.com", true},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.host); got != tt.match {
+			t.Errorf("%q 匹配 %q = %v，期望 %v", tt.pattern, tt.host, got, tt.match)
+		}
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "  ", "regex:("} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("Compile(%q) 应返回错误", pattern)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("API.Example.com."); got != "api.example.com" {
+		t.Errorf("Normalize = %q", got)
+	}
+	if got := Normalize("[::1]"); got != "::1" {
+		t.Errorf("Normalize = %q", got)
+	}
+}
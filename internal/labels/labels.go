@@ -0,0 +1,243 @@
+// Package labels 提供按规则给代理流量打分类标签的功能。
+//
+// 多个团队共用一个部署时，按认证用户、监听器和目标主机把请求归入
+// crawler-A、monitoring 等标签，标签写入日志并按标签统计请求数和流量，
+// 无需为每类流量单独开设监听器即可区分各自的用量。
+package labels
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+)
+
+// Unlabeled 未命中任何规则的流量在统计中使用的标签
+const Unlabeled = "-"
+
+// Rule 单条标签规则，全部非空条件都满足时命中。
+type Rule struct {
+	Label    string `json:"label"`              // 命中后使用的标签
+	User     string `json:"user,omitempty"`     // 认证用户名
+	Listener string `json:"listener,omitempty"` // 监听器名称
+	Host     string `json:"host,omitempty"`     // 目标主机模式（见 hostmatch 包）
+
+	host hostmatch.Pattern // 编译后的目标主机模式
+}
+
+// conditions 返回规则的非空条件数。
+func (r Rule) conditions() int {
+	var n int
+	for _, value := range []string{r.User, r.Listener, r.Host} {
+		if value != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Stats 单个标签的流量统计，自启动以来累计。
+type Stats struct {
+	Label         string  `json:"label"`          // 标签，未命中规则的流量为 "-"
+	Requests      int64   `json:"requests"`       // 请求数（含CONNECT隧道）
+	Failures      int64   `json:"failures"`       // 失败数
+	SuccessRate   float64 `json:"success_rate"`   // 成功率
+	BytesSent     int64   `json:"bytes_sent"`     // 发往目标的字节数
+	BytesReceived int64   `json:"bytes_received"` // 从目标收到的字节数
+}
+
+// Status 标签规则和各标签的统计。
+type Status struct {
+	Rules []Rule  `json:"rules"` // 按匹配顺序排列的规则
+	Stats []Stats `json:"stats"` // 按请求数从多到少排列的统计
+}
+
+// Set 标签规则及按标签的统计。
+type Set struct {
+	rules []Rule            // 按匹配顺序排列的规则
+	stats map[string]*Stats // 按标签索引的统计
+	mutex sync.Mutex        // 互斥锁
+}
+
+// ParseRules 解析标签规则。
+//
+// 每个标签对应一组逗号分隔的条件，例如 "user:alice,host:*.example.com"，
+// 支持 user、listener、host 三种条件。条件越多的规则越先匹配，条件数相同时按标签名排序。
+//
+// 参数：
+//   - spec: 标签到条件的映射
+//
+// 返回值：
+//   - []Rule: 按匹配顺序排列的规则
+//   - error: 标签为空、条件格式错误或没有条件时返回错误
+func ParseRules(spec map[string]string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(spec))
+	for label, conditions := range spec {
+		label = strings.TrimSpace(label)
+		if label == "" || label == Unlabeled {
+			return nil, fmt.Errorf("无效的流量标签: %q", label)
+		}
+		rule := Rule{Label: label}
+		for _, condition := range strings.Split(conditions, ",") {
+			condition = strings.TrimSpace(condition)
+			if condition == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(condition, ":")
+			value = strings.TrimSpace(value)
+			if !ok || value == "" {
+				return nil, fmt.Errorf("流量标签 %s 的条件格式错误: %s", label, condition)
+			}
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "user":
+				rule.User = value
+			case "listener":
+				rule.Listener = strings.ToLower(value)
+			case "host":
+				host, err := hostmatch.Compile(value)
+				if err != nil {
+					return nil, fmt.Errorf("流量标签 %s: %v", label, err)
+				}
+				rule.Host, rule.host = value, host
+			default:
+				return nil, fmt.Errorf("流量标签 %s 的条件未知: %s", label, key)
+			}
+		}
+		if rule.conditions() == 0 {
+			return nil, fmt.Errorf("流量标签 %s 没有任何条件", label)
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if ci, cj := rules[i].conditions(), rules[j].conditions(); ci != cj {
+			return ci > cj
+		}
+		return rules[i].Label < rules[j].Label
+	})
+	return rules, nil
+}
+
+// New 创建标签规则集。
+//
+// 参数：
+//   - rules: 按匹配顺序排列的规则
+//
+// 返回值：
+//   - *Set: 标签规则集，没有规则时为nil
+func New(rules []Rule) *Set {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Set{rules: rules, stats: make(map[string]*Stats)}
+}
+
+// Match 返回请求命中的第一条规则的标签。
+//
+// 参数：
+//   - user: 认证用户名，匿名为空
+//   - listener: 监听器名称
+//   - host: 目标主机名或IP地址（不含端口）
+//
+// 返回值：
+//   - string: 标签，未命中任何规则或规则集为nil时为空
+func (s *Set) Match(user, listener, host string) string {
+	if s == nil {
+		return ""
+	}
+	host = hostmatch.Normalize(host)
+	for _, rule := range s.rules {
+		if rule.User != "" && rule.User != user {
+			continue
+		}
+		if rule.Listener != "" && rule.Listener != listener {
+			continue
+		}
+		if rule.Host != "" && !rule.host.Match(host) {
+			continue
+		}
+		return rule.Label
+	}
+	return ""
+}
+
+// entry 返回标签的统计，调用方需持有锁。
+func (s *Set) entry(label string) *Stats {
+	if label == "" {
+		label = Unlabeled
+	}
+	stats, ok := s.stats[label]
+	if !ok {
+		stats = &Stats{Label: label}
+		s.stats[label] = stats
+	}
+	return stats
+}
+
+// Record 记录一次请求结果。
+//
+// 参数：
+//   - label: 标签，为空时计入未命中规则的流量
+//   - ok: 是否成功建立到目标的请求或隧道
+func (s *Set) Record(label string, ok bool) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := s.entry(label)
+	stats.Requests++
+	if !ok {
+		stats.Failures++
+	}
+}
+
+// AddBytes 累加标签与目标之间传输的字节数。
+//
+// 参数：
+//   - label: 标签，为空时计入未命中规则的流量
+//   - sent: 发往目标的字节数
+//   - received: 从目标收到的字节数
+func (s *Set) AddBytes(label string, sent, received int64) {
+	if s == nil || sent+received == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := s.entry(label)
+	stats.BytesSent += sent
+	stats.BytesReceived += received
+}
+
+// Status 返回标签规则和各标签的统计。
+//
+// 返回值：
+//   - Status: 规则和按请求数从多到少排列的统计，规则集为nil时均为空
+func (s *Set) Status() Status {
+	if s == nil {
+		return Status{Rules: []Rule{}, Stats: []Stats{}}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := Status{Rules: s.rules, Stats: make([]Stats, 0, len(s.stats))}
+	for _, stats := range s.stats {
+		item := *stats
+		if item.Requests > 0 {
+			item.SuccessRate = 1 - float64(item.Failures)/float64(item.Requests)
+		}
+		status.Stats = append(status.Stats, item)
+	}
+	sort.Slice(status.Stats, func(i, j int) bool {
+		a, b := status.Stats[i], status.Stats[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Label < b.Label
+	})
+	return status
+}
@@ -4,7 +4,10 @@
 // 主要用于表示代理信息、配置参数等核心数据类型。
 package models
 
-import "net/url"
+import (
+	"net/url"
+	"time"
+)
 
 // ProxyInfo 代理服务器信息结构。
 //
@@ -15,4 +18,22 @@ type ProxyInfo struct {
 	Host     string   // 代理主机地址
 	Username string   // 认证用户名
 	Password string   // 认证密码
+
+	Tags         map[string]string // 代理标签，如 isp=comcast、type=mobile
+	Weight       int               // 按权重选择时的权重，0表示未声明（按1计算）
+	Capabilities *Capabilities     // 能力探测结果，nil表示尚未探测
+
+	Chain []*url.URL // 连接该代理前依次经过的HTTP代理，为空表示直接连接
+}
+
+// Capabilities 上游代理能力探测结果。
+//
+// 记录代理允许CONNECT的端口、支持的SOCKS版本、代理端口是否支持TLS
+// 以及是否具备IPv6出口，供代理选择时避开无法处理请求的代理。
+type Capabilities struct {
+	ProbedAt     time.Time    // 探测时间
+	ConnectPorts map[int]bool // 各探测端口是否允许CONNECT
+	SOCKSVersion int          // 支持的SOCKS版本，0表示不支持
+	TLS          bool         // 代理端口是否支持TLS（HTTPS代理）
+	IPv6         bool         // 是否具备IPv6出口
 }
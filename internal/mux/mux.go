@@ -0,0 +1,382 @@
+// Package mux 提供在一条TCP连接上承载多条逻辑连接的多路复用传输。
+//
+// 客户端代理（agent）与ProxyFlow服务端之间建立少量长连接，每条逻辑连接
+// 对应一个流，流实现net.Conn接口，服务端按普通客户端连接处理。这样一台
+// 抓取主机上的成千上万条隧道只占用几条TCP连接，减少握手开销和NAT表压力。
+//
+// 连接建立后客户端先发送一行握手 "PFMUX/1 <令牌>\n"，服务端回复 "OK\n"
+// 或 "ERR <原因>\n"。之后双方交换帧，帧头为9字节：类型(1) 流ID(4) 长度(4)，
+// 长度对数据帧表示负载字节数，对窗口帧表示增加的发送窗口。每个流有独立的
+// 接收窗口，接收方读取数据后归还窗口，单个慢速流不会占满整条连接的缓冲。
+package mux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// protocolVersion 握手行中的协议版本
+	protocolVersion = "PFMUX/1"
+
+	// frameHeaderSize 帧头字节数
+	frameHeaderSize = 9
+	// maxFrameSize 单个数据帧的最大负载字节数
+	maxFrameSize = 16 << 10
+	// initialWindow 每个流的初始接收窗口
+	initialWindow = 256 << 10
+	// handshakeTimeout 握手超时时间
+	handshakeTimeout = 10 * time.Second
+	// acceptBacklog 服务端尚未接受的新流的最大数量
+	acceptBacklog = 256
+)
+
+// 帧类型。
+const (
+	frameOpen   byte = iota // 客户端打开新流
+	frameData               // 流数据
+	frameWindow             // 归还接收窗口
+	frameClose              // 发送方不再写入（半关闭）
+	frameReset              // 立即终止流
+)
+
+var (
+	// ErrSessionClosed 多路复用会话已关闭
+	ErrSessionClosed = errors.New("多路复用会话已关闭")
+
+	// errStreamReset 流被对端终止
+	errStreamReset = errors.New("流已被对端终止")
+
+	// errStreamClosed 流已在本端关闭
+	errStreamClosed = errors.New("流已关闭")
+)
+
+// ClientHandshake 在连接上完成客户端握手。
+//
+// 参数：
+//   - conn: 到服务端的连接
+//   - token: 访问令牌
+//
+// 返回值：
+//   - error: 握手失败或被服务端拒绝时返回错误
+func ClientHandshake(conn net.Conn, token string) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(conn, "%s %s\n", protocolVersion, token); err != nil {
+		return err
+	}
+	reply, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("读取握手响应失败: %v", err)
+	}
+	if reply != "OK" {
+		return fmt.Errorf("服务端拒绝多路复用连接: %s", strings.TrimPrefix(reply, "ERR "))
+	}
+	return nil
+}
+
+// ServerHandshake 在连接上完成服务端握手并校验令牌。
+//
+// 参数：
+//   - conn: 来自客户端的连接
+//   - check: 令牌校验函数
+//
+// 返回值：
+//   - error: 握手失败或令牌无效时返回错误，此时已向客户端回复拒绝原因
+func ServerHandshake(conn net.Conn, check func(token string) bool) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	line, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("读取握手失败: %v", err)
+	}
+	version, token, _ := strings.Cut(line, " ")
+	if version != protocolVersion {
+		fmt.Fprintf(conn, "ERR 不支持的协议版本\n")
+		return fmt.Errorf("不支持的协议版本: %q", version)
+	}
+	if !check(token) {
+		fmt.Fprintf(conn, "ERR 令牌无效\n")
+		return errors.New("令牌无效")
+	}
+	_, err = fmt.Fprintf(conn, "OK\n")
+	return err
+}
+
+// readLine 逐字节读取一行，避免预读属于后续帧的数据。
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for len(line) < 1024 {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		if buf[0] == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		line = append(line, buf[0])
+	}
+	return "", errors.New("握手行过长")
+}
+
+// Session 一条多路复用连接。
+type Session struct {
+	conn    net.Conn           // 底层连接
+	reader  *bufio.Reader      // 底层连接的读缓冲
+	client  bool               // 是否为客户端一侧，只有客户端可以打开流
+	nextID  uint32             // 客户端下一个流ID
+	streams map[uint32]*Stream // 活跃的流
+	accept  chan *Stream       // 服务端待接受的新流
+	mutex   sync.Mutex         // 保护streams和nextID
+
+	writeMutex sync.Mutex    // 串行化帧写入
+	closed     chan struct{} // 会话关闭信号
+	closeOnce  sync.Once     // 保证只关闭一次
+	err        error         // 会话关闭的原因
+}
+
+// Client 在已完成握手的连接上创建客户端会话。
+func Client(conn net.Conn) *Session {
+	return newSession(conn, true)
+}
+
+// Server 在已完成握手的连接上创建服务端会话。
+func Server(conn net.Conn) *Session {
+	return newSession(conn, false)
+}
+
+// newSession 创建会话并启动接收循环。
+func newSession(conn net.Conn, client bool) *Session {
+	s := &Session{
+		conn:    conn,
+		reader:  bufio.NewReaderSize(conn, maxFrameSize+frameHeaderSize),
+		client:  client,
+		nextID:  1,
+		streams: make(map[uint32]*Stream),
+		accept:  make(chan *Stream, acceptBacklog),
+		closed:  make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// Open 打开一个新流，仅客户端可用。
+//
+// 返回值：
+//   - *Stream: 新的流
+//   - error: 会话已关闭时返回错误
+func (s *Session) Open() (*Stream, error) {
+	if !s.client {
+		return nil, errors.New("只有客户端可以打开流")
+	}
+	s.mutex.Lock()
+	if s.IsClosed() {
+		s.mutex.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mutex.Unlock()
+
+	if err := s.writeFrame(frameOpen, id, 0, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept 等待客户端打开的下一个流，仅服务端可用。
+//
+// 返回值：
+//   - *Stream: 新的流
+//   - error: 会话已关闭时返回错误
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case stream := <-s.accept:
+		return stream, nil
+	case <-s.closed:
+		return nil, s.closeErr()
+	}
+}
+
+// NumStreams 返回活跃的流数量。
+func (s *Session) NumStreams() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed 判断会话是否已关闭。
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Closed 返回会话关闭时关闭的通道。
+func (s *Session) Closed() <-chan struct{} {
+	return s.closed
+}
+
+// RemoteAddr 返回底层连接的远端地址。
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Close 关闭会话及其全部流。
+func (s *Session) Close() error {
+	s.shutdown(ErrSessionClosed)
+	return nil
+}
+
+// shutdown 以指定原因关闭会话。
+func (s *Session) shutdown(err error) {
+	s.closeOnce.Do(func() {
+		s.mutex.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mutex.Unlock()
+
+		close(s.closed)
+		s.conn.Close()
+		for _, stream := range streams {
+			stream.notify()
+		}
+	})
+}
+
+// closeErr 返回会话关闭的原因。
+func (s *Session) closeErr() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err == nil || errors.Is(s.err, io.EOF) {
+		return ErrSessionClosed
+	}
+	return fmt.Errorf("%w: %v", ErrSessionClosed, s.err)
+}
+
+// writeFrame 写入一个帧。
+func (s *Session) writeFrame(kind byte, id, length uint32, payload []byte) error {
+	var header [frameHeaderSize]byte
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:5], id)
+	binary.BigEndian.PutUint32(header[5:9], length)
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if s.IsClosed() {
+		return s.closeErr()
+	}
+	if _, err := s.conn.Write(header[:]); err != nil {
+		s.shutdown(err)
+		return s.closeErr()
+	}
+	if len(payload) > 0 {
+		if _, err := s.conn.Write(payload); err != nil {
+			s.shutdown(err)
+			return s.closeErr()
+		}
+	}
+	return nil
+}
+
+// recvLoop 读取帧并分发给对应的流，底层连接出错时关闭会话。
+func (s *Session) recvLoop() {
+	var header [frameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(s.reader, header[:]); err != nil {
+			s.shutdown(err)
+			return
+		}
+		kind := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		length := binary.BigEndian.Uint32(header[5:9])
+
+		var payload []byte
+		if kind == frameData {
+			if length > maxFrameSize {
+				s.shutdown(fmt.Errorf("数据帧过大: %d", length))
+				return
+			}
+			payload = make([]byte, length)
+			if _, err := io.ReadFull(s.reader, payload); err != nil {
+				s.shutdown(err)
+				return
+			}
+		}
+		if err := s.handleFrame(kind, id, length, payload); err != nil {
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+// handleFrame 处理一个帧。
+func (s *Session) handleFrame(kind byte, id, length uint32, payload []byte) error {
+	if kind == frameOpen {
+		if s.client || id%2 == 0 {
+			return fmt.Errorf("无效的打开流帧: %d", id)
+		}
+		s.mutex.Lock()
+		if _, exists := s.streams[id]; exists {
+			s.mutex.Unlock()
+			return fmt.Errorf("流ID重复: %d", id)
+		}
+		stream := newStream(s, id)
+		s.streams[id] = stream
+		s.mutex.Unlock()
+
+		select {
+		case s.accept <- stream:
+		default:
+			// 服务端来不及接受新流时直接拒绝，避免无限堆积
+			s.removeStream(id)
+			s.writeFrame(frameReset, id, 0, nil)
+		}
+		return nil
+	}
+
+	s.mutex.Lock()
+	stream := s.streams[id]
+	s.mutex.Unlock()
+	if stream == nil {
+		// 已在本端关闭的流可能仍有在途的帧，直接丢弃
+		return nil
+	}
+
+	switch kind {
+	case frameData:
+		return stream.receive(payload)
+	case frameWindow:
+		stream.grant(length)
+	case frameClose:
+		stream.remoteClose()
+	case frameReset:
+		stream.reset()
+	default:
+		return fmt.Errorf("未知的帧类型: %d", kind)
+	}
+	return nil
+}
+
+// removeStream 从会话中移除流。
+func (s *Session) removeStream(id uint32) {
+	s.mutex.Lock()
+	delete(s.streams, id)
+	s.mutex.Unlock()
+}
@@ -0,0 +1,279 @@
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream 多路复用会话中的一条逻辑连接，实现net.Conn接口。
+type Stream struct {
+	session *Session // 所属会话
+	id      uint32   // 流ID
+
+	buffer       bytes.Buffer // 已收到但尚未读取的数据
+	unacked      int          // 已读取但尚未归还给对端的窗口
+	sendWindow   int          // 还可以向对端发送的字节数
+	remoteClosed bool         // 对端已半关闭，读完缓冲后返回EOF
+	remoteReset  bool         // 对端已完全关闭，写入返回错误
+	writeClosed  bool         // 本端已半关闭
+	closed       bool         // 本端已关闭
+
+	readDeadline  time.Time  // 读超时时间
+	writeDeadline time.Time  // 写超时时间
+	mutex         sync.Mutex // 保护以上状态
+
+	readReady  chan struct{} // 有新数据或状态变化时通知读取方
+	writeReady chan struct{} // 有新窗口或状态变化时通知写入方
+}
+
+// newStream 创建流。
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		session:    session,
+		id:         id,
+		sendWindow: initialWindow,
+		readReady:  make(chan struct{}, 1),
+		writeReady: make(chan struct{}, 1),
+	}
+}
+
+// ID 返回流ID。
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// notify 唤醒等待中的读取方和写入方。
+func (st *Stream) notify() {
+	select {
+	case st.readReady <- struct{}{}:
+	default:
+	}
+	select {
+	case st.writeReady <- struct{}{}:
+	default:
+	}
+}
+
+// wait 等待通知、会话关闭或超时。
+//
+// 参数：
+//   - ready: 通知通道
+//   - deadline: 超时时间，零值表示不超时
+//
+// 返回值：
+//   - error: 超时时返回os.ErrDeadlineExceeded
+func (st *Stream) wait(ready chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-st.session.closed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// receive 保存对端发来的数据。
+func (st *Stream) receive(payload []byte) error {
+	st.mutex.Lock()
+	if st.buffer.Len()+len(payload) > initialWindow {
+		st.mutex.Unlock()
+		return fmt.Errorf("流 %d 超出接收窗口", st.id)
+	}
+	if !st.closed {
+		st.buffer.Write(payload)
+	}
+	st.mutex.Unlock()
+	st.notify()
+	return nil
+}
+
+// grant 增加发送窗口。
+func (st *Stream) grant(n uint32) {
+	st.mutex.Lock()
+	st.sendWindow += int(n)
+	st.mutex.Unlock()
+	st.notify()
+}
+
+// remoteClose 标记对端已半关闭。
+func (st *Stream) remoteClose() {
+	st.mutex.Lock()
+	st.remoteClosed = true
+	st.mutex.Unlock()
+	st.notify()
+}
+
+// reset 标记对端已完全关闭。
+func (st *Stream) reset() {
+	st.mutex.Lock()
+	st.remoteClosed = true
+	st.remoteReset = true
+	st.mutex.Unlock()
+	st.session.removeStream(st.id)
+	st.notify()
+}
+
+// Read 读取数据，对端关闭且缓冲读完后返回io.EOF。
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mutex.Lock()
+		if st.closed {
+			st.mutex.Unlock()
+			return 0, errStreamClosed
+		}
+		if st.buffer.Len() > 0 {
+			n, _ := st.buffer.Read(p)
+			st.unacked += n
+			var credit int
+			// 攒够一定数量再归还窗口，避免每次小读取都产生一个窗口帧
+			if st.unacked >= initialWindow/4 || (st.buffer.Len() == 0 && !st.remoteClosed) {
+				credit, st.unacked = st.unacked, 0
+			}
+			st.mutex.Unlock()
+			if credit > 0 {
+				st.session.writeFrame(frameWindow, st.id, uint32(credit), nil)
+			}
+			return n, nil
+		}
+		if st.remoteClosed {
+			st.mutex.Unlock()
+			return 0, io.EOF
+		}
+		if st.session.IsClosed() {
+			st.mutex.Unlock()
+			return 0, st.session.closeErr()
+		}
+		deadline := st.readDeadline
+		st.mutex.Unlock()
+
+		if err := st.wait(st.readReady, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 写入数据，发送窗口用完时等待对端归还窗口。
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.mutex.Lock()
+		switch {
+		case st.closed:
+			st.mutex.Unlock()
+			return written, errStreamClosed
+		case st.writeClosed:
+			st.mutex.Unlock()
+			return written, io.ErrClosedPipe
+		case st.remoteReset:
+			st.mutex.Unlock()
+			return written, errStreamReset
+		case st.session.IsClosed():
+			st.mutex.Unlock()
+			return written, st.session.closeErr()
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mutex.Unlock()
+			if err := st.wait(st.writeReady, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := min(len(p)-written, st.sendWindow, maxFrameSize)
+		st.sendWindow -= n
+		st.mutex.Unlock()
+
+		if err := st.session.writeFrame(frameData, st.id, uint32(n), p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// CloseWrite 半关闭流，对端读完已发送的数据后收到EOF，本端仍可继续读取。
+func (st *Stream) CloseWrite() error {
+	st.mutex.Lock()
+	if st.closed || st.writeClosed {
+		st.mutex.Unlock()
+		return nil
+	}
+	st.writeClosed = true
+	st.mutex.Unlock()
+	st.notify()
+	return st.session.writeFrame(frameClose, st.id, 0, nil)
+}
+
+// Close 关闭流，对端读完已发送的数据后收到EOF，之后的写入返回错误。
+func (st *Stream) Close() error {
+	st.mutex.Lock()
+	if st.closed {
+		st.mutex.Unlock()
+		return nil
+	}
+	st.closed = true
+	remoteReset := st.remoteReset
+	st.buffer.Reset()
+	st.mutex.Unlock()
+
+	st.session.removeStream(st.id)
+	st.notify()
+	if remoteReset {
+		return nil
+	}
+	return st.session.writeFrame(frameReset, st.id, 0, nil)
+}
+
+// LocalAddr 返回底层连接的本地地址。
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+// RemoteAddr 返回底层连接的远端地址。
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+// SetDeadline 同时设置读写超时时间。
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.mutex.Lock()
+	st.readDeadline = t
+	st.writeDeadline = t
+	st.mutex.Unlock()
+	st.notify()
+	return nil
+}
+
+// SetReadDeadline 设置读超时时间。
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mutex.Lock()
+	st.readDeadline = t
+	st.mutex.Unlock()
+	st.notify()
+	return nil
+}
+
+// SetWriteDeadline 设置写超时时间。
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mutex.Lock()
+	st.writeDeadline = t
+	st.mutex.Unlock()
+	st.notify()
+	return nil
+}
@@ -0,0 +1,175 @@
+package pool
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+const (
+	// apiCacheSize 本地缓存最近从API获取的代理数量
+	apiCacheSize = 32
+)
+
+// API请求失败的类型。
+const (
+	apiErrorRequest = "request" // 请求失败（连接失败、超时等）
+	apiErrorStatus  = "status"  // 返回非200状态码
+	apiErrorEmpty   = "empty"   // 返回空的代理URL
+	apiErrorInvalid = "invalid" // 返回的内容无法解析为代理URL
+)
+
+// apiError 代理API请求失败。
+type apiError struct {
+	kind string // 失败类型
+	err  error  // 失败原因
+}
+
+// Error 返回失败原因。
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap 返回失败原因。
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// APIStats 代理API的请求统计。
+type APIStats struct {
+	Requests     int64            `json:"requests"`                // 实际发出的API请求数
+	Errors       map[string]int64 `json:"errors"`                  // 按类型的失败次数：request、status、empty、invalid
+	ErrorRate    float64          `json:"error_rate"`              // API请求失败比例，没有请求时为0
+	Cached       int64            `json:"served_from_cache"`       // 暂停请求期间由本地缓存提供的代理数
+	Rejected     int64            `json:"rejected"`                // 暂停请求期间本地缓存为空而直接失败的次数
+	LastError    string           `json:"last_error,omitempty"`    // 最近一次失败的原因
+	BackoffUntil *time.Time       `json:"backoff_until,omitempty"` // 暂停请求API的截止时间，未暂停时省略
+}
+
+// apiCache 代理API的失败缓存。
+//
+// API请求失败或返回无法解析的内容后，本次和之后backoff时间内的请求不再请求API，
+// 改为轮流返回最近从API成功获取的代理；本地缓存为空时直接返回上次的错误，
+// 避免API故障期间每个入站请求都去请求API。backoff为0时只统计不缓存。
+type apiCache struct {
+	fetch   func() (*models.ProxyInfo, error) // 实际请求API
+	backoff time.Duration                     // 失败后暂停请求API的时间
+
+	recent  []models.ProxyInfo // 最近从API获取的代理，环形缓冲
+	head    int                // 下一次写入recent的位置
+	cursor  int                // 暂停期间轮流返回的位置
+	until   time.Time          // 暂停请求API的截止时间
+	lastErr error              // 最近一次失败的原因
+
+	requests int64            // 实际发出的API请求数
+	errors   map[string]int64 // 按类型的失败次数
+	cached   int64            // 由本地缓存提供的代理数
+	rejected int64            // 本地缓存为空而直接失败的次数
+	mutex    sync.Mutex       // 互斥锁
+}
+
+// newAPICache 创建代理API的失败缓存。
+//
+// 参数：
+//   - fetch: 请求API获取一个代理的函数
+//   - backoff: 失败后暂停请求API的时间，0表示不暂停
+//
+// 返回值：
+//   - *apiCache: 失败缓存
+func newAPICache(fetch func() (*models.ProxyInfo, error), backoff time.Duration) *apiCache {
+	return &apiCache{fetch: fetch, backoff: backoff, errors: make(map[string]int64)}
+}
+
+// next 获取一个代理，暂停请求API期间由本地缓存提供。
+//
+// 返回值：
+//   - *models.ProxyInfo: 代理信息
+//   - error: API请求失败，或暂停期间本地缓存为空
+func (c *apiCache) next() (*models.ProxyInfo, error) {
+	c.mutex.Lock()
+	if time.Now().Before(c.until) {
+		defer c.mutex.Unlock()
+		if proxy := c.fromCache(); proxy != nil {
+			return proxy, nil
+		}
+		c.rejected++
+		return nil, fmt.Errorf("代理API暂停请求至 %s: %w", c.until.Format(time.TimeOnly), c.lastErr)
+	}
+	c.requests++
+	c.mutex.Unlock()
+
+	proxy, err := c.fetch()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		kind := apiErrorRequest
+		if e, ok := err.(*apiError); ok {
+			kind = e.kind
+		}
+		c.errors[kind]++
+		c.lastErr = err
+		if c.backoff <= 0 {
+			return nil, err
+		}
+		c.until = time.Now().Add(c.backoff)
+		log.Printf("代理API请求失败，%v 内改用最近获取的 %d 个代理: %v", c.backoff, len(c.recent), err)
+		if proxy := c.fromCache(); proxy != nil {
+			return proxy, nil
+		}
+		return nil, err
+	}
+	if len(c.recent) < apiCacheSize {
+		c.recent = append(c.recent, *proxy)
+	} else {
+		c.recent[c.head] = *proxy
+	}
+	c.head = (c.head + 1) % apiCacheSize
+	return proxy, nil
+}
+
+// fromCache 轮流返回最近从API获取的代理，调用方需持有锁。
+//
+// 返回值：
+//   - *models.ProxyInfo: 代理信息，本地缓存为空时为nil
+func (c *apiCache) fromCache() *models.ProxyInfo {
+	if len(c.recent) == 0 {
+		return nil
+	}
+	proxy := c.recent[c.cursor%len(c.recent)]
+	c.cursor++
+	c.cached++
+	return &proxy
+}
+
+// stats 返回API的请求统计。
+func (c *apiCache) stats() *APIStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := &APIStats{
+		Requests: c.requests,
+		Errors:   make(map[string]int64, len(c.errors)),
+		Cached:   c.cached,
+		Rejected: c.rejected,
+	}
+	var failed int64
+	for kind, n := range c.errors {
+		stats.Errors[kind] = n
+		failed += n
+	}
+	if c.requests > 0 {
+		stats.ErrorRate = float64(failed) / float64(c.requests)
+	}
+	if c.lastErr != nil {
+		stats.LastError = c.lastErr.Error()
+	}
+	if time.Now().Before(c.until) {
+		until := c.until
+		stats.BackoffUntil = &until
+	}
+	return stats
+}
@@ -0,0 +1,132 @@
+package pool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// canaryMaxBytes 金丝雀响应体的最大字节数
+const canaryMaxBytes = 1 << 20
+
+// canaryChecker 上游代理内容完整性检查器。
+//
+// 通过代理请求受控的金丝雀地址，将响应体的SHA-256与预期值比较，
+// 找出注入广告或改写内容的上游代理。未配置预期值时不经代理直接请求金丝雀地址作为基准，
+// 基准在一个检查间隔内复用。
+type canaryChecker struct {
+	url        string        // 金丝雀地址
+	fixed      string        // 配置的预期SHA-256，为空时直接请求获取基准
+	interval   time.Duration // 基准的有效期
+	client     *http.Client  // 直接请求基准使用的客户端
+	baseline   string        // 最近一次直接请求得到的SHA-256
+	baselineAt time.Time     // 获取基准的时间
+	mutex      sync.Mutex    // 互斥锁
+}
+
+// newCanaryChecker 创建内容完整性检查器。
+//
+// 参数：
+//   - rawURL: 金丝雀地址，为空时不检查
+//   - sum: 预期的响应体SHA-256（十六进制），为空时直接请求获取
+//   - interval: 直接请求得到的基准的有效期
+//   - timeout: 直接请求的超时时间
+//
+// 返回值：
+//   - *canaryChecker: 内容完整性检查器，未配置金丝雀地址时为nil
+//   - error: 地址或预期值无效时返回错误
+func newCanaryChecker(rawURL, sum string, interval, timeout time.Duration) (*canaryChecker, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Hostname() == "" {
+		return nil, fmt.Errorf("无效的金丝雀地址: %s", rawURL)
+	}
+	sum = strings.ToLower(strings.TrimSpace(sum))
+	if decoded, err := hex.DecodeString(sum); sum != "" && (err != nil || len(decoded) != sha256.Size) {
+		return nil, fmt.Errorf("无效的金丝雀SHA-256: %s", sum)
+	}
+	return &canaryChecker{
+		url:      rawURL,
+		fixed:    sum,
+		interval: interval,
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// expected 返回预期的响应体SHA-256。
+//
+// 返回值：
+//   - string: 预期的SHA-256
+//   - error: 直接请求基准失败时返回错误
+func (c *canaryChecker) expected() (string, error) {
+	if c.fixed != "" {
+		return c.fixed, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.baseline != "" && time.Since(c.baselineAt) < c.interval {
+		return c.baseline, nil
+	}
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return "", fmt.Errorf("直接请求金丝雀地址失败: %w", err)
+	}
+	sum, err := hashCanary(resp)
+	if err != nil {
+		return "", fmt.Errorf("直接请求金丝雀地址失败: %w", err)
+	}
+	c.baseline, c.baselineAt = sum, time.Now()
+	return sum, nil
+}
+
+// verify 通过代理请求金丝雀地址并与预期值比较。
+//
+// 参数：
+//   - get: 通过代理发起GET请求的函数
+//
+// 返回值：
+//   - string: 经代理得到的响应体SHA-256
+//   - bool: 响应体是否被篡改
+//   - error: 请求失败或无法获取基准时返回错误，此时不判断是否篡改
+func (c *canaryChecker) verify(get func(target string) (*http.Response, error)) (string, bool, error) {
+	expected, err := c.expected()
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := get(c.url)
+	if err != nil {
+		return "", false, err
+	}
+	sum, err := hashCanary(resp)
+	if err != nil {
+		return "", false, err
+	}
+	return sum, sum != expected, nil
+}
+
+// hashCanary 读取金丝雀响应并计算响应体SHA-256，响应不是200时返回错误。
+func hashCanary(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("金丝雀地址返回 %s", resp.Status)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.LimitReader(resp.Body, canaryMaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if n > canaryMaxBytes {
+		return "", fmt.Errorf("金丝雀响应体超过 %d 字节", canaryMaxBytes)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
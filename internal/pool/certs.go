@@ -0,0 +1,327 @@
+package pool
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// 证书事件类型
+const (
+	CertEventChanged  = "changed"  // 同一代理观察到的证书指纹发生变化
+	CertEventMismatch = "mismatch" // 代理观察到的证书指纹与多数代理不同
+)
+
+// maxCertEvents 保留的证书事件数量
+const maxCertEvents = 200
+
+// CertEvent 一次证书异常事件。
+type CertEvent struct {
+	Time        time.Time `json:"time"`               // 发生时间
+	Kind        string    `json:"kind"`               // 事件类型：changed或mismatch
+	Destination string    `json:"destination"`        // 目标地址（host:port）
+	Proxy       string    `json:"proxy"`              // 代理地址
+	Fingerprint string    `json:"fingerprint"`        // 观察到的叶子证书SHA-256指纹
+	Previous    string    `json:"previous,omitempty"` // changed事件中之前观察到的指纹
+	Expected    string    `json:"expected,omitempty"` // mismatch事件中多数代理观察到的指纹
+	Subject     string    `json:"subject"`            // 观察到的证书主题
+	Issuer      string    `json:"issuer"`             // 观察到的证书签发者
+}
+
+// CertFingerprint 目标上观察到的一个证书指纹。
+type CertFingerprint struct {
+	Fingerprint string    `json:"fingerprint"` // 叶子证书SHA-256指纹
+	Subject     string    `json:"subject"`     // 证书主题
+	Issuer      string    `json:"issuer"`      // 证书签发者
+	NotAfter    time.Time `json:"not_after"`   // 证书到期时间
+	Verified    bool      `json:"verified"`    // 证书链能否通过系统根证书校验
+	Proxies     []string  `json:"proxies"`     // 观察到该指纹的代理地址
+}
+
+// CertDestination 单个目标的证书观察结果。
+type CertDestination struct {
+	Destination  string            `json:"destination"`  // 目标地址（host:port）
+	Fingerprints []CertFingerprint `json:"fingerprints"` // 观察到的指纹，按代理数从多到少排列
+}
+
+// CertReport 目标证书观察报告。
+type CertReport struct {
+	Destinations []CertDestination `json:"destinations"` // 各目标的观察结果
+	Events       []CertEvent       `json:"events"`       // 近期的证书异常事件，从新到旧排列
+}
+
+// certObservation 某个代理对某个目标最近一次观察到的证书。
+type certObservation struct {
+	fingerprint string    // 叶子证书SHA-256指纹
+	subject     string    // 证书主题
+	issuer      string    // 证书签发者
+	notAfter    time.Time // 证书到期时间
+	verified    bool      // 证书链能否通过系统根证书校验
+}
+
+// certWatcher 目标TLS证书观察器。
+//
+// 健康检查成功后通过代理与各观察目标完成TLS握手，记录叶子证书指纹。
+// 同一代理观察到的指纹变化，或与其他代理的多数结果不一致时记录告警事件，
+// 用于发现上游代理对TLS流量的中间人拦截。
+type certWatcher struct {
+	targets  []string                              // 观察目标（host:port）
+	timeout  time.Duration                         // 单次握手超时时间
+	observed map[string]map[string]certObservation // 按目标和代理地址索引的观察结果
+	events   []CertEvent                           // 证书事件，环形保存
+	next     int                                   // 下一个被替换的事件位置
+	mutex    sync.Mutex                            // 互斥锁
+}
+
+// newCertWatcher 创建证书观察器。
+//
+// 参数：
+//   - hosts: 观察目标，格式为host或host:port，缺省端口为443
+//   - timeout: 单次握手超时时间
+//
+// 返回值：
+//   - *certWatcher: 证书观察器，没有观察目标时为nil
+func newCertWatcher(hosts []string, timeout time.Duration) *certWatcher {
+	var targets []string
+	for _, host := range hosts {
+		if host = strings.TrimSpace(host); host != "" {
+			targets = append(targets, withPort(host, "443"))
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return &certWatcher{
+		targets:  targets,
+		timeout:  timeout,
+		observed: make(map[string]map[string]certObservation),
+	}
+}
+
+// withPort 为缺少端口的地址补充默认端口。
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// observe 通过代理与各观察目标完成TLS握手并记录证书。
+//
+// 握手失败时保留之前的观察结果，不记录事件。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+func (c *certWatcher) observe(proxy models.ProxyInfo) {
+	if c == nil {
+		return
+	}
+	for _, target := range c.targets {
+		obs, err := c.handshake(proxy, target)
+		if err != nil {
+			log.Printf("通过代理 %s 获取 %s 的证书失败: %v", proxy.Host, target, err)
+			continue
+		}
+		c.record(target, proxy.Host, obs)
+	}
+}
+
+// handshake 通过代理的CONNECT隧道与目标完成TLS握手，返回叶子证书。
+func (c *certWatcher) handshake(proxy models.ProxyInfo, target string) (certObservation, error) {
+	conn, err := connectTunnel(proxy, target, c.timeout)
+	if err != nil {
+		return certObservation{}, err
+	}
+	defer conn.Close()
+
+	serverName, _, _ := net.SplitHostPort(target)
+	// 不在握手时校验证书，才能记录被替换的证书；校验结果单独计算
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		return certObservation{}, err
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	leaf := certs[0]
+	sum := sha256.Sum256(leaf.Raw)
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates})
+
+	return certObservation{
+		fingerprint: hex.EncodeToString(sum[:]),
+		subject:     leaf.Subject.String(),
+		issuer:      leaf.Issuer.String(),
+		notAfter:    leaf.NotAfter,
+		verified:    verifyErr == nil,
+	}, nil
+}
+
+// record 登记一次观察结果，指纹变化或与多数代理不一致时记录事件。
+//
+// 参数：
+//   - target: 目标地址
+//   - host: 代理地址
+//   - obs: 观察到的证书
+func (c *certWatcher) record(target, host string, obs certObservation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	byProxy := c.observed[target]
+	if byProxy == nil {
+		byProxy = make(map[string]certObservation)
+		c.observed[target] = byProxy
+	}
+	previous, seen := byProxy[host]
+	byProxy[host] = obs
+	if seen && previous.fingerprint == obs.fingerprint {
+		return
+	}
+
+	event := CertEvent{
+		Time:        time.Now(),
+		Destination: target,
+		Proxy:       host,
+		Fingerprint: obs.fingerprint,
+		Subject:     obs.subject,
+		Issuer:      obs.issuer,
+	}
+	if seen {
+		event.Kind = CertEventChanged
+		event.Previous = previous.fingerprint
+		c.addEvent(event)
+		log.Printf("[证书告警] 代理 %s 观察到 %s 的证书发生变化: %s -> %s (签发者: %s)",
+			host, target, shortFingerprint(previous.fingerprint), shortFingerprint(obs.fingerprint), obs.issuer)
+	}
+
+	if expected := c.majority(target, host); expected != "" && expected != obs.fingerprint {
+		event.Kind = CertEventMismatch
+		event.Previous = ""
+		event.Expected = expected
+		c.addEvent(event)
+		log.Printf("[证书告警] 代理 %s 观察到 %s 的证书与多数代理不同: %s，预期 %s (签发者: %s)",
+			host, target, shortFingerprint(obs.fingerprint), shortFingerprint(expected), obs.issuer)
+	}
+}
+
+// majority 返回除指定代理外多数代理观察到的指纹，调用方需持有锁。
+//
+// 至少两个其他代理观察到同一指纹且该指纹占其他代理的半数以上时才视为多数，
+// 避免代理数很少或目标轮换证书期间误报。
+//
+// 参数：
+//   - target: 目标地址
+//   - exclude: 排除的代理地址
+//
+// 返回值：
+//   - string: 多数代理观察到的指纹，无法确定时为空
+func (c *certWatcher) majority(target, exclude string) string {
+	counts := make(map[string]int)
+	var total int
+	for host, obs := range c.observed[target] {
+		if host == exclude {
+			continue
+		}
+		counts[obs.fingerprint]++
+		total++
+	}
+	for fingerprint, count := range counts {
+		if count >= 2 && count*2 > total {
+			return fingerprint
+		}
+	}
+	return ""
+}
+
+// addEvent 记录事件，调用方需持有锁。
+func (c *certWatcher) addEvent(event CertEvent) {
+	if len(c.events) < maxCertEvents {
+		c.events = append(c.events, event)
+		return
+	}
+	c.events[c.next] = event
+	c.next = (c.next + 1) % maxCertEvents
+}
+
+// forget 移除代理的全部观察结果。
+func (c *certWatcher) forget(host string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, byProxy := range c.observed {
+		delete(byProxy, host)
+	}
+}
+
+// report 汇总各目标的观察结果和近期事件。
+//
+// 返回值：
+//   - CertReport: 证书观察报告
+func (c *certWatcher) report() CertReport {
+	report := CertReport{Destinations: []CertDestination{}, Events: []CertEvent{}}
+	if c == nil {
+		return report
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, target := range c.targets {
+		dest := CertDestination{Destination: target, Fingerprints: []CertFingerprint{}}
+		index := make(map[string]int)
+		for host, obs := range c.observed[target] {
+			i, ok := index[obs.fingerprint]
+			if !ok {
+				i = len(dest.Fingerprints)
+				index[obs.fingerprint] = i
+				dest.Fingerprints = append(dest.Fingerprints, CertFingerprint{
+					Fingerprint: obs.fingerprint,
+					Subject:     obs.subject,
+					Issuer:      obs.issuer,
+					NotAfter:    obs.notAfter,
+					Verified:    obs.verified,
+				})
+			}
+			dest.Fingerprints[i].Proxies = append(dest.Fingerprints[i].Proxies, host)
+		}
+		for _, fp := range dest.Fingerprints {
+			sort.Strings(fp.Proxies)
+		}
+		sort.Slice(dest.Fingerprints, func(i, j int) bool {
+			a, b := dest.Fingerprints[i], dest.Fingerprints[j]
+			if len(a.Proxies) != len(b.Proxies) {
+				return len(a.Proxies) > len(b.Proxies)
+			}
+			return a.Fingerprint < b.Fingerprint
+		})
+		report.Destinations = append(report.Destinations, dest)
+	}
+
+	// 环形缓冲区中c.next之前是最新的事件
+	for i := len(c.events) - 1; i >= 0; i-- {
+		report.Events = append(report.Events, c.events[(c.next+i)%len(c.events)])
+	}
+	return report
+}
+
+// shortFingerprint 返回指纹的前16位，用于日志。
+func shortFingerprint(fingerprint string) string {
+	if len(fingerprint) > 16 {
+		return fingerprint[:16]
+	}
+	return fingerprint
+}
@@ -0,0 +1,243 @@
+package pool
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxConcurrencyEntries 最多记录多少个代理的并发上限，超出时清理没有进行中连接的记录
+	maxConcurrencyEntries = 10000
+	// baselineWindow 每个基准延迟窗口的样本数，基准取最近两个窗口中的最小延迟
+	baselineWindow = 100
+	// concurrencyBackoff 出现拥塞信号时并发上限的缩减比例
+	concurrencyBackoff = 0.8
+	// congestionMargin 平均延迟比基准延迟高出不到这个值时视为抖动，不算拥塞
+	congestionMargin = 10 * time.Millisecond
+)
+
+// ConcurrencyOptions 按代理的自适应并发控制配置。
+type ConcurrencyOptions struct {
+	Enabled   bool    // 是否启用自适应并发控制
+	Initial   int     // 每个代理的初始并发上限
+	Min       int     // 并发上限的下限
+	Max       int     // 并发上限的上限
+	Tolerance float64 // 延迟超过基准延迟多少倍视为拥塞
+}
+
+// ConcurrencyStats 单个代理的自适应并发状态。
+type ConcurrencyStats struct {
+	Proxy      string  `json:"proxy"`       // 代理地址
+	Limit      int     `json:"limit"`       // 当前并发上限
+	InFlight   int     `json:"in_flight"`   // 进行中的连接和请求数
+	BaselineMs float64 `json:"baseline_ms"` // 基准延迟（毫秒）
+	LatencyMs  float64 `json:"latency_ms"`  // 延迟的指数加权移动平均（毫秒）
+	Decreases  int64   `json:"decreases"`   // 并发上限因拥塞被缩减的次数
+}
+
+// concurrencyLimit 单个代理的并发上限。
+type concurrencyLimit struct {
+	limit        float64       // 并发上限，取整后使用
+	inflight     int           // 进行中的连接和请求数
+	windowMin    time.Duration // 当前窗口的最小延迟
+	previousMin  time.Duration // 上一个窗口的最小延迟
+	samples      int           // 当前窗口的样本数
+	latency      float64       // 延迟的指数加权移动平均（纳秒）
+	lastDecrease time.Time     // 上次缩减并发上限的时间
+	decreases    int64         // 缩减次数
+}
+
+// baseline 返回基准延迟：最近两个窗口中的最小延迟，还没有样本时为0。
+func (c *concurrencyLimit) baseline() time.Duration {
+	switch {
+	case c.previousMin == 0:
+		return c.windowMin
+	case c.windowMin == 0:
+		return c.previousMin
+	default:
+		return min(c.windowMin, c.previousMin)
+	}
+}
+
+// concurrencyLimiter 按代理自适应地限制进行中的连接和请求数。
+//
+// 采用AIMD：平均延迟接近基准延迟且并发上限已被充分使用时缓慢增加上限（每个上限数量的样本加一），
+// 平均延迟超过基准延迟的 Tolerance 倍或连接失败时按比例缩减上限，
+// 从而找到每个代理在延迟不明显上升前能承受的并发数，而不必为所有代理设置同一个固定上限。
+// 基准延迟取最近两个窗口中的最小延迟，代理所在线路的延迟整体变化后会随窗口滚动跟上。
+type concurrencyLimiter struct {
+	opts   ConcurrencyOptions           // 配置
+	limits map[string]*concurrencyLimit // 代理地址到并发上限的映射
+	mutex  sync.Mutex                   // 互斥锁
+}
+
+// newConcurrencyLimiter 创建按代理的自适应并发控制。
+//
+// 参数：
+//   - opts: 自适应并发控制配置，下限小于1时按1处理，上限小于下限时取下限，初始值限制在两者之间
+//
+// 返回值：
+//   - *concurrencyLimiter: 并发控制，未启用时为nil
+func newConcurrencyLimiter(opts ConcurrencyOptions) *concurrencyLimiter {
+	if !opts.Enabled {
+		return nil
+	}
+	opts.Min = max(opts.Min, 1)
+	opts.Max = max(opts.Max, opts.Min)
+	opts.Initial = min(max(opts.Initial, opts.Min), opts.Max)
+	if opts.Tolerance <= 1 {
+		opts.Tolerance = 2
+	}
+	return &concurrencyLimiter{opts: opts, limits: make(map[string]*concurrencyLimit)}
+}
+
+// enabled 判断是否启用了自适应并发控制。
+func (c *concurrencyLimiter) enabled() bool {
+	return c != nil
+}
+
+// entry 返回代理的并发上限，不存在时创建，调用方需持有锁。
+func (c *concurrencyLimiter) entry(host string) *concurrencyLimit {
+	entry, ok := c.limits[host]
+	if ok {
+		return entry
+	}
+	if len(c.limits) >= maxConcurrencyEntries {
+		for key, e := range c.limits {
+			if e.inflight == 0 {
+				delete(c.limits, key)
+			}
+		}
+	}
+	entry = &concurrencyLimit{limit: float64(c.opts.Initial)}
+	c.limits[host] = entry
+	return entry
+}
+
+// allows 判断代理进行中的连接和请求数是否低于其并发上限。
+func (c *concurrencyLimiter) allows(host string) bool {
+	if !c.enabled() {
+		return true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.limits[host]
+	return !ok || entry.inflight < int(entry.limit)
+}
+
+// acquire 登记一个经由代理的连接或请求。
+//
+// 返回值：
+//   - func(): 连接或请求结束时调用的释放函数，可重复调用
+func (c *concurrencyLimiter) acquire(host string) func() {
+	c.mutex.Lock()
+	c.entry(host).inflight++
+	c.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if entry, ok := c.limits[host]; ok && entry.inflight > 0 {
+				entry.inflight--
+			}
+		})
+	}
+}
+
+// observe 按一次延迟样本调整代理的并发上限。
+//
+// 参数：
+//   - host: 代理地址
+//   - latency: 建立连接或收到响应头的耗时
+func (c *concurrencyLimiter) observe(host string, latency time.Duration) {
+	if !c.enabled() || latency <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := c.entry(host)
+	if entry.latency == 0 {
+		entry.latency = float64(latency)
+	} else {
+		entry.latency = ewmaDecay*float64(latency) + (1-ewmaDecay)*entry.latency
+	}
+	if entry.windowMin == 0 || latency < entry.windowMin {
+		entry.windowMin = latency
+	}
+	if entry.samples++; entry.samples >= baselineWindow {
+		entry.previousMin, entry.windowMin, entry.samples = entry.windowMin, 0, 0
+	}
+
+	// 按平滑后的延迟判断拥塞，个别慢请求不会缩减上限
+	baseline := float64(entry.baseline())
+	if entry.latency > baseline*c.opts.Tolerance && entry.latency > baseline+float64(congestionMargin) {
+		c.decrease(entry, latency)
+		return
+	}
+	// 只有上限被充分使用时延迟才能说明代理还能承受更多并发
+	if entry.inflight*2 >= int(entry.limit) {
+		entry.limit = min(entry.limit+1/entry.limit, float64(c.opts.Max))
+	}
+}
+
+// fail 在经由代理的连接或请求失败时缩减其并发上限。
+func (c *concurrencyLimiter) fail(host string) {
+	if !c.enabled() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := c.entry(host)
+	c.decrease(entry, time.Duration(entry.latency))
+}
+
+// decrease 按比例缩减并发上限，调用方需持有锁。
+//
+// 同一批进行中的请求会几乎同时报告拥塞，一个平均延迟之内只缩减一次，避免上限骤降到下限。
+//
+// 参数：
+//   - entry: 代理的并发上限
+//   - latency: 触发缩减的请求的耗时
+func (c *concurrencyLimiter) decrease(entry *concurrencyLimit, latency time.Duration) {
+	now := time.Now()
+	if now.Sub(entry.lastDecrease) < max(latency, time.Duration(entry.latency)) {
+		return
+	}
+	entry.lastDecrease = now
+	entry.limit = max(entry.limit*concurrencyBackoff, float64(c.opts.Min))
+	entry.decreases++
+}
+
+// stats 返回各代理的并发状态，按进行中的连接数降序排列。
+func (c *concurrencyLimiter) stats() []ConcurrencyStats {
+	if !c.enabled() {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result := make([]ConcurrencyStats, 0, len(c.limits))
+	for host, entry := range c.limits {
+		result = append(result, ConcurrencyStats{
+			Proxy:      host,
+			Limit:      int(entry.limit),
+			InFlight:   entry.inflight,
+			BaselineMs: float64(entry.baseline()) / float64(time.Millisecond),
+			LatencyMs:  entry.latency / float64(time.Millisecond),
+			Decreases:  entry.decreases,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].InFlight != result[j].InFlight {
+			return result[i].InFlight > result[j].InFlight
+		}
+		return result[i].Proxy < result[j].Proxy
+	})
+	return result
+}
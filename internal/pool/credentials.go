@@ -0,0 +1,142 @@
+package pool
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// AllProxies 凭据覆盖中表示代理池全部代理的地址
+const AllProxies = "*"
+
+// Credentials 上游代理认证凭据。
+type Credentials struct {
+	Username string // 认证用户名
+	Password string // 认证密码
+}
+
+// credentialStore 上游代理凭据覆盖。
+//
+// 代理服务商轮换密码后，API返回的代理地址或已绑定会话的代理仍可能携带旧凭据，
+// 这里记录的凭据会在代理交给调用方之前替换其原有凭据。
+// 按代理地址设置的凭据优先于对全部代理设置的凭据。
+type credentialStore struct {
+	byHost map[string]Credentials // 代理地址（含AllProxies）到凭据的映射
+	mutex  sync.RWMutex           // 读写锁
+}
+
+// newCredentialStore 创建凭据覆盖存储。
+//
+// 参数：
+//   - initial: 初始凭据，键为代理地址或AllProxies
+//
+// 返回值：
+//   - *credentialStore: 凭据覆盖存储
+func newCredentialStore(initial map[string]Credentials) *credentialStore {
+	store := &credentialStore{byHost: make(map[string]Credentials, len(initial))}
+	for host, creds := range initial {
+		store.byHost[host] = creds
+	}
+	return store
+}
+
+// set 设置或删除代理的凭据覆盖。
+//
+// 参数：
+//   - host: 代理地址，AllProxies表示全部代理
+//   - creds: 新凭据，为nil时删除覆盖
+func (s *credentialStore) set(host string, creds *Credentials) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if creds == nil {
+		delete(s.byHost, host)
+		return
+	}
+	s.byHost[host] = *creds
+}
+
+// hosts 返回设置了凭据覆盖的代理地址。
+func (s *credentialStore) hosts() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]string, 0, len(s.byHost))
+	for host := range s.byHost {
+		result = append(result, host)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// apply 用覆盖的凭据替换代理原有的凭据。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+//
+// 返回值：
+//   - models.ProxyInfo: 替换凭据后的代理服务器信息
+func (s *credentialStore) apply(proxy models.ProxyInfo) models.ProxyInfo {
+	if proxy.Host == "" {
+		return proxy
+	}
+
+	s.mutex.RLock()
+	creds, ok := s.byHost[proxy.Host]
+	if !ok {
+		creds, ok = s.byHost[AllProxies]
+	}
+	s.mutex.RUnlock()
+	if !ok || (creds.Username == proxy.Username && creds.Password == proxy.Password) {
+		return proxy
+	}
+
+	return withCredentials(proxy, creds)
+}
+
+// credentialCache 进程内记录的会话绑定代理的凭据。
+//
+// 外部会话存储（如Redis）不保存代理凭据，取回绑定时先按这里的记录恢复，
+// 其次按列表型来源中的同一代理恢复，最后再应用凭据覆盖。
+type credentialCache struct {
+	byHost map[string]Credentials // 代理地址到凭据的映射
+	mutex  sync.RWMutex           // 读写锁
+}
+
+// remember 记录代理的凭据，没有凭据的代理不记录；记录数达到上限时清空重新记录。
+func (c *credentialCache) remember(proxy models.ProxyInfo) {
+	if proxy.Username == "" && proxy.Password == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.byHost[proxy.Host]; !ok && len(c.byHost) >= maxSourceOwners {
+		c.byHost = nil
+	}
+	if c.byHost == nil {
+		c.byHost = make(map[string]Credentials)
+	}
+	c.byHost[proxy.Host] = Credentials{Username: proxy.Username, Password: proxy.Password}
+}
+
+// lookup 返回记录的代理凭据。
+func (c *credentialCache) lookup(host string) (Credentials, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	creds, ok := c.byHost[host]
+	return creds, ok
+}
+
+// withCredentials 返回使用指定凭据的代理副本。
+func withCredentials(proxy models.ProxyInfo, creds Credentials) models.ProxyInfo {
+	proxy.Username = creds.Username
+	proxy.Password = creds.Password
+	if proxy.URL != nil {
+		u := *proxy.URL
+		u.User = url.UserPassword(creds.Username, creds.Password)
+		proxy.URL = &u
+	}
+	return proxy
+}
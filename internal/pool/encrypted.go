@@ -0,0 +1,144 @@
+package pool
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedMagic 加密代理列表文件的文件头，同时作为AES-GCM的附加认证数据
+const encryptedMagic = "PROXYFLOW-ENC-1\n"
+
+// FileKeySize 代理列表文件密钥的字节数（AES-256）
+const FileKeySize = 32
+
+// ParseFileKey 解析代理列表文件的密钥。
+//
+// 密钥为32字节，写作64个十六进制字符或base64编码（标准或URL安全字母表，可以省略填充）。
+//
+// 参数：
+//   - value: 编码后的密钥
+//
+// 返回值：
+//   - []byte: 密钥
+//   - error: 编码无效或长度不是32字节
+func ParseFileKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == hex.EncodedLen(FileKeySize) {
+		if key, err := hex.DecodeString(value); err == nil {
+			return key, nil
+		}
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := encoding.DecodeString(value); err == nil && len(key) == FileKeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("代理列表文件密钥必须是 %d 字节，写作十六进制或base64", FileKeySize)
+}
+
+// LoadFileKey 按配置加载代理列表文件的密钥。
+//
+// 密钥可以直接在环境变量中给出，也可以从文件读取（例如由Vault Agent等密钥管理工具写入的文件），
+// 两者只能配置一项。
+//
+// 参数：
+//   - value: 环境变量中的密钥（PROXY_FILE_KEY）
+//   - path: 密钥文件路径（PROXY_FILE_KEY_FILE）
+//
+// 返回值：
+//   - []byte: 密钥，两者都未配置时为nil
+//   - error: 同时配置、文件无法读取或密钥无效
+func LoadFileKey(value, path string) ([]byte, error) {
+	switch {
+	case value != "" && path != "":
+		return nil, errors.New("PROXY_FILE_KEY 和 PROXY_FILE_KEY_FILE 只能配置一项")
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取代理列表文件密钥失败: %v", err)
+		}
+		return ParseFileKey(string(data))
+	case value != "":
+		return ParseFileKey(value)
+	default:
+		return nil, nil
+	}
+}
+
+// EncryptList 用AES-256-GCM加密代理列表。
+//
+// 输出为文件头、12字节随机nonce和密文（含认证标签），文件头参与认证，不能被替换。
+//
+// 参数：
+//   - plaintext: 明文代理列表
+//   - key: 32字节密钥
+//
+// 返回值：
+//   - []byte: 加密后的文件内容
+//   - error: 密钥无效或无法生成nonce
+func EncryptList(plaintext, key []byte) ([]byte, error) {
+	aead, err := newListCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("生成nonce失败: %v", err)
+	}
+	out := append([]byte(encryptedMagic), nonce...)
+	return aead.Seal(out, nonce, plaintext, []byte(encryptedMagic)), nil
+}
+
+// DecryptList 解密 EncryptList 生成的代理列表。
+//
+// 参数：
+//   - data: 文件内容
+//   - key: 32字节密钥
+//
+// 返回值：
+//   - []byte: 明文代理列表
+//   - error: 不是加密文件、密钥错误或内容被篡改
+func DecryptList(data, key []byte) ([]byte, error) {
+	if !isEncryptedList(data) {
+		return nil, errors.New("文件未加密，缺少加密文件头")
+	}
+	aead, err := newListCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("加密的代理列表文件不完整")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(encryptedMagic))
+	if err != nil {
+		return nil, errors.New("解密代理列表文件失败: 密钥错误或文件已被篡改")
+	}
+	return plaintext, nil
+}
+
+// isEncryptedList 判断文件内容是否为加密的代理列表。
+func isEncryptedList(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// newListCipher 创建加解密代理列表的AES-GCM实例。
+func newListCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != FileKeySize {
+		return nil, fmt.Errorf("代理列表文件密钥必须是 %d 字节", FileKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,95 @@
+package pool
+
+import "sync"
+
+const (
+	// maxExitDestinations 最多记录多少个目标主机最近使用的出口IP
+	maxExitDestinations = 10000
+)
+
+// exitRotation 记录每个目标主机最近一次使用的出口IP。
+//
+// 多个代理可能共用同一个出口IP，仅按代理地址轮换时，
+// 同一目标仍可能连续看到相同的来源IP；选择代理时据此避开上一次的出口。
+type exitRotation struct {
+	lastExit map[string]string // 目标主机到最近出口IP的映射
+	mutex    sync.Mutex        // 互斥锁
+}
+
+// newExitRotation 创建出口IP轮换记录。
+//
+// 参数：
+//   - enabled: 是否启用
+//
+// 返回值：
+//   - *exitRotation: 出口IP轮换记录，未启用时为nil
+func newExitRotation(enabled bool) *exitRotation {
+	if !enabled {
+		return nil
+	}
+	return &exitRotation{lastExit: make(map[string]string)}
+}
+
+// enabled 判断是否启用了出口IP轮换。
+func (e *exitRotation) enabled() bool {
+	return e != nil
+}
+
+// repeats 判断使用该出口IP访问目标是否与上一次相同。
+//
+// 参数：
+//   - destHost: 目标主机名
+//   - exitIP: 候选代理的出口IP，为空表示未知
+//
+// 返回值：
+//   - bool: 是否与上一次出口相同，出口未知时为false
+func (e *exitRotation) repeats(destHost, exitIP string) bool {
+	if !e.enabled() || destHost == "" || exitIP == "" {
+		return false
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.lastExit[destHost] == exitIP
+}
+
+// last 返回目标上一次使用的出口IP。
+//
+// 参数：
+//   - destHost: 目标主机名
+//
+// 返回值：
+//   - string: 出口IP，未启用或没有记录时为空
+func (e *exitRotation) last(destHost string) string {
+	if !e.enabled() {
+		return ""
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.lastExit[destHost]
+}
+
+// record 记录目标本次使用的出口IP。
+//
+// 记录的目标数达到上限时随机淘汰一个目标。
+//
+// 参数：
+//   - destHost: 目标主机名
+//   - exitIP: 本次使用的出口IP，为空表示未知
+func (e *exitRotation) record(destHost, exitIP string) {
+	if !e.enabled() || destHost == "" || exitIP == "" {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, ok := e.lastExit[destHost]; !ok && len(e.lastExit) >= maxExitDestinations {
+		for host := range e.lastExit {
+			delete(e.lastExit, host)
+			break
+		}
+	}
+	e.lastExit[destHost] = exitIP
+}
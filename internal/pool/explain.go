@@ -0,0 +1,68 @@
+package pool
+
+import (
+	"fmt"
+	"net"
+)
+
+// SelectionPlan 代理池对一次选择的预判，不实际获取代理。
+type SelectionPlan struct {
+	SessionProxy string   `json:"session_proxy,omitempty"` // 粘性会话当前绑定的代理，选择时直接使用
+	Sources      []string `json:"sources"`                 // 代理来源，按权重从高到低排列
+	HealthyRatio float64  `json:"healthy_ratio"`           // 健康代理的比例
+	Filters      []string `json:"filters"`                 // 重新选择代理时生效的筛选条件
+}
+
+// Plan 预判按选择条件选择代理的过程，不获取代理也不改变任何状态。
+//
+// 粘性会话已绑定代理时报告绑定的代理；否则代理在请求时从来源获取，
+// 报告会依次应用的筛选条件。
+//
+// 参数：
+//   - sel: 代理选择条件
+//
+// 返回值：
+//   - SelectionPlan: 选择过程的预判
+func (p *Pool) Plan(sel Selection) SelectionPlan {
+	plan := SelectionPlan{HealthyRatio: p.health.healthyRatio(), Filters: []string{}}
+	for _, source := range p.sources.stats() {
+		plan.Sources = append(plan.Sources, fmt.Sprintf("%s（权重 %d）", source.Name, source.Weight))
+	}
+
+	if sel.SessionID != "" {
+		if proxy, ok := p.sticky.Peek(sel.SessionID); ok && !p.isRemoved(proxy.Host) {
+			plan.SessionProxy = proxy.Host
+			return plan
+		}
+		plan.Filters = append(plan.Filters, fmt.Sprintf("选中的代理将绑定到会话 %s", sel.SessionID))
+	}
+
+	p.mutex.RLock()
+	removed := len(p.removed)
+	p.mutex.RUnlock()
+	if removed > 0 {
+		plan.Filters = append(plan.Filters, fmt.Sprintf("跳过已移除的代理 %d 个", removed))
+	}
+	if p.health.enabled() {
+		plan.Filters = append(plan.Filters, "跳过不健康的代理")
+	}
+	if len(sel.Tags) > 0 {
+		plan.Filters = append(plan.Filters, "要求标签 "+FormatTags(sel.Tags))
+	}
+	if p.prober.enabled() && (sel.DestPort != 0 || net.ParseIP(sel.DestHost) != nil) {
+		plan.Filters = append(plan.Filters, "要求代理具备访问该目标所需的能力")
+	}
+	if last := p.exits.last(sel.DestHost); last != "" {
+		plan.Filters = append(plan.Filters, "优先避开该目标上一次的出口IP "+last)
+	}
+	if p.rate.enabled() {
+		plan.Filters = append(plan.Filters, fmt.Sprintf("每个代理每秒最多 %g 次请求，最长等待 %v", p.rate.rate, p.rate.maxWait))
+	}
+	if p.quota.enabled() {
+		plan.Filters = append(plan.Filters, fmt.Sprintf("每个代理对该目标最多 %d 次请求", p.quota.maxRequests))
+	}
+	if p.queue != nil {
+		plan.Filters = append(plan.Filters, fmt.Sprintf("暂时没有可用代理时最多排队 %v", p.queue.maxWait))
+	}
+	return plan
+}
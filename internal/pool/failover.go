@@ -0,0 +1,214 @@
+package pool
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// failoverEvalInterval 两次评估代理层健康度的最短间隔
+	failoverEvalInterval = time.Second
+)
+
+// FailoverOptions 代理池故障转移配置。
+type FailoverOptions struct {
+	Threshold float64       // 当前层健康代理比例低于该值时切换到下一层
+	Recover   float64       // 更高优先级的层健康代理比例达到该值时切回
+	Hold      time.Duration // 两次切换之间的最短间隔，避免来回切换
+}
+
+// FailoverTier 单个优先级层的状态。
+type FailoverTier struct {
+	Pools        []string `json:"pools"`         // 层内的代理池
+	HealthyRatio float64  `json:"healthy_ratio"` // 最近一次评估的健康代理比例
+}
+
+// FailoverStatus 故障转移状态。
+type FailoverStatus struct {
+	Enabled    bool           `json:"enabled"`               // 是否配置了故障转移
+	Active     int            `json:"active"`                // 当前使用的层（从0开始，0为主层）
+	Tiers      []FailoverTier `json:"tiers"`                 // 各层状态，按优先级排列
+	Switches   int            `json:"switches"`              // 累计切换次数
+	LastSwitch *time.Time     `json:"last_switch,omitempty"` // 最近一次切换的时间
+}
+
+// Failover 按优先级分层的代理池故障转移。
+//
+// 流量使用优先级最高的可用层；当前层的健康代理比例低于阈值时切换到下一层，
+// 更高优先级的层恢复到更高的恢复阈值后才切回，并且两次切换之间至少间隔Hold，
+// 避免健康度在阈值附近波动时来回切换。层内有多个代理池时轮流使用。
+type Failover struct {
+	opts       FailoverOptions
+	tiers      [][]string // 各层的代理池名称
+	ratios     []float64  // 最近一次评估的健康代理比例
+	active     int        // 当前使用的层
+	switches   int        // 累计切换次数
+	lastSwitch time.Time  // 最近一次切换的时间
+	lastEval   time.Time  // 最近一次评估的时间
+	next       int        // 层内轮询位置
+	mutex      sync.Mutex // 互斥锁
+}
+
+// ParseFailoverTiers 解析故障转移的优先级层。
+//
+// 层之间用分号分隔，按优先级从高到低排列，层内的代理池用逗号分隔，
+// 例如 "default;residential,mobile"。
+//
+// 参数：
+//   - spec: 分层配置
+//
+// 返回值：
+//   - [][]string: 各层的代理池名称，spec为空时为nil
+//   - error: 格式错误
+func ParseFailoverTiers(spec string) ([][]string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	var tiers [][]string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ";") {
+		var tier []string
+		for _, name := range strings.Split(item, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("代理池 %s 出现在多个故障转移层中", name)
+			}
+			seen[name] = true
+			tier = append(tier, name)
+		}
+		if len(tier) == 0 {
+			return nil, fmt.Errorf("故障转移层为空: %q", spec)
+		}
+		tiers = append(tiers, tier)
+	}
+	if len(tiers) < 2 {
+		return nil, fmt.Errorf("故障转移至少需要两层: %s", spec)
+	}
+	return tiers, nil
+}
+
+// NewFailover 创建代理池故障转移。
+//
+// 参数：
+//   - tiers: 各层的代理池名称，按优先级从高到低排列
+//   - opts: 故障转移配置
+//
+// 返回值：
+//   - *Failover: 故障转移，tiers为空时为nil
+func NewFailover(tiers [][]string, opts FailoverOptions) *Failover {
+	if len(tiers) == 0 {
+		return nil
+	}
+	ratios := make([]float64, len(tiers))
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return &Failover{opts: opts, tiers: tiers, ratios: ratios}
+}
+
+// Names 返回故障转移引用的全部代理池名称。
+func (f *Failover) Names() []string {
+	if f == nil {
+		return nil
+	}
+	var names []string
+	for _, tier := range f.tiers {
+		names = append(names, tier...)
+	}
+	return names
+}
+
+// Pick 选择本次请求使用的代理池。
+//
+// 参数：
+//   - ratio: 返回代理池健康代理比例的函数
+//
+// 返回值：
+//   - string: 代理池名称
+func (f *Failover) Pick(ratio func(name string) float64) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(f.lastEval) >= failoverEvalInterval {
+		f.lastEval = now
+		f.evaluate(ratio, now)
+	}
+	tier := f.tiers[f.active]
+	f.next = (f.next + 1) % len(tier)
+	return tier[f.next]
+}
+
+// evaluate 重新计算各层健康度并决定是否切换，调用方需持有锁。
+func (f *Failover) evaluate(ratio func(name string) float64, now time.Time) {
+	for i, tier := range f.tiers {
+		var sum float64
+		for _, name := range tier {
+			sum += ratio(name)
+		}
+		f.ratios[i] = sum / float64(len(tier))
+	}
+	if !f.lastSwitch.IsZero() && now.Sub(f.lastSwitch) < f.opts.Hold {
+		return
+	}
+
+	target := f.active
+	// 优先切回恢复到恢复阈值的更高优先级层
+	for i := 0; i < f.active; i++ {
+		if f.ratios[i] >= f.opts.Recover {
+			target = i
+			break
+		}
+	}
+	// 当前层低于阈值时切换到下一个不低于阈值的层，都不满足时使用最后一层
+	if target == f.active && f.ratios[f.active] < f.opts.Threshold {
+		target = len(f.tiers) - 1
+		for i := f.active + 1; i < len(f.tiers); i++ {
+			if f.ratios[i] >= f.opts.Threshold {
+				target = i
+				break
+			}
+		}
+	}
+	if target == f.active {
+		return
+	}
+
+	log.Printf("代理池故障转移: 第 %d 层(%s，健康比例 %.0f%%) -> 第 %d 层(%s，健康比例 %.0f%%)",
+		f.active, strings.Join(f.tiers[f.active], ","), f.ratios[f.active]*100,
+		target, strings.Join(f.tiers[target], ","), f.ratios[target]*100)
+	f.active = target
+	f.next = 0
+	f.switches++
+	f.lastSwitch = now
+}
+
+// Status 返回故障转移状态。
+//
+// 返回值：
+//   - FailoverStatus: 故障转移状态，未配置时Enabled为false
+func (f *Failover) Status() FailoverStatus {
+	if f == nil {
+		return FailoverStatus{Tiers: []FailoverTier{}}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	status := FailoverStatus{Enabled: true, Active: f.active, Switches: f.switches}
+	for i, tier := range f.tiers {
+		status.Tiers = append(status.Tiers, FailoverTier{Pools: tier, HealthyRatio: f.ratios[i]})
+	}
+	if !f.lastSwitch.IsZero() {
+		lastSwitch := f.lastSwitch
+		status.LastSwitch = &lastSwitch
+	}
+	return status
+}
@@ -0,0 +1,829 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+const (
+	// healthForgetIntervals 代理连续多少个检查周期未被API返回后停止检查
+	healthForgetIntervals = 10
+
+	// HealthModeHTTP 通过代理完整请求检查地址
+	HealthModeHTTP = "http"
+	// HealthModeConnect 只向检查地址所在主机完成TCP连接和CONNECT握手，不传输请求数据
+	HealthModeConnect = "connect"
+
+	// healthSourceActive 健康状态来自主动检查
+	healthSourceActive = "active"
+	// healthSourcePassive 健康状态来自实际流量
+	healthSourcePassive = "passive"
+)
+
+// HealthOptions 健康检查配置。
+type HealthOptions struct {
+	Enabled    bool          // 是否启用主动健康检查
+	Passive    bool          // 是否根据实际流量的结果更新健康状态
+	Mode       string        // 检查方式：http或connect，为空时使用http
+	URL        string        // 通过代理访问的检查地址
+	Interval   time.Duration // 同一代理两次检查的间隔；仅被动检查时为不健康代理重新放行的等待时间
+	Timeout    time.Duration // 单次检查超时时间
+	Workers    int           // 同时进行的检查数上限
+	Jitter     float64       // 检查间隔的随机抖动比例（0~1）
+	Threshold  int           // 连续失败多少次后判定为不健康
+	Recovery   int           // 不健康的代理连续成功多少次后恢复使用
+	MaxBackoff time.Duration // 不健康代理重试等待时间的上限，每次重试失败后等待时间加倍；不大于Interval时固定为Interval
+	ExitIPURL  string        // 返回出口IP的地址，为空时不记录出口IP
+
+	Adaptive   bool         // 是否按代理的失败和使用情况自适应调整检查间隔
+	IdleFactor int          // 自适应调度下空闲的健康代理检查间隔最多放大到检查间隔的多少倍
+	Budget     *ProbeBudget // 各代理池共用的检查速率预算，nil表示不限制
+
+	CertHosts []string // 检查成功后观察TLS证书的目标（host或host:port），为空时不观察
+
+	CanaryURL     string // 检查成功后通过代理请求的金丝雀地址，为空时不检查内容完整性
+	CanarySHA256  string // 金丝雀响应体的预期SHA-256，为空时不经代理直接请求获取
+	CanaryExclude bool   // 是否停止使用篡改了金丝雀响应的代理
+}
+
+// healthEntry 单个代理的健康状态。
+type healthEntry struct {
+	proxy     models.ProxyInfo // 代理信息
+	healthy   bool             // 是否健康
+	failures  int              // 连续失败次数
+	successes int              // 不健康时的连续成功次数
+	nextCheck time.Time        // 下次检查时间
+	lastSeen  time.Time        // 最近一次被API返回的时间
+	inflight  bool             // 是否正在检查
+	exitIP    string           // 最近一次观察到的出口IP，为空表示未知
+	retryAt   time.Time        // 仅被动检查时，不健康代理重新放行的时间
+	backoffs  int              // 不健康期间连续重试失败的次数，决定下次重试的等待时间
+	source    string           // 最近一次更新健康状态的来源
+
+	uses       int // 上次检查以来被选中的次数
+	idleChecks int // 连续没有被选中的检查次数，决定空闲代理的检查间隔
+
+	tampered  bool   // 最近一次金丝雀检查是否发现响应被篡改
+	canarySum string // 最近一次经代理得到的金丝雀响应体SHA-256
+
+	activeChecks     int64 // 主动检查次数
+	activeFailures   int64 // 主动检查失败次数
+	passiveSuccesses int64 // 实际流量成功次数
+	passiveFailures  int64 // 实际流量失败次数
+}
+
+// HealthSignals 一类健康信号的计数。
+type HealthSignals struct {
+	Successes int64 `json:"successes"` // 成功次数
+	Failures  int64 `json:"failures"`  // 失败次数
+}
+
+// ProxyHealth 单个代理的健康统计。
+type ProxyHealth struct {
+	Proxy      string        `json:"proxy"`                        // 代理地址
+	Healthy    bool          `json:"healthy"`                      // 是否健康
+	Failures   int           `json:"consecutive_failures"`         // 连续失败次数
+	Successes  int           `json:"recovery_successes,omitempty"` // 不健康代理恢复前已连续成功的次数
+	Backoffs   int           `json:"retry_failures,omitempty"`     // 不健康代理连续重试失败的次数
+	RetryAt    *time.Time    `json:"retry_at,omitempty"`           // 不健康代理下次重试的时间
+	NextCheck  *time.Time    `json:"next_check,omitempty"`         // 健康代理下次主动检查的时间
+	LastSource string        `json:"last_source,omitempty"`        // 最近一次更新健康状态的来源：active或passive
+	ExitIP     string        `json:"exit_ip,omitempty"`            // 出口IP
+	Active     HealthSignals `json:"active"`                       // 主动检查结果
+	Passive    HealthSignals `json:"passive"`                      // 实际流量结果
+
+	Tampered bool   `json:"tampered,omitempty"`      // 最近一次金丝雀检查是否发现响应被篡改
+	Canary   string `json:"canary_sha256,omitempty"` // 最近一次经代理得到的金丝雀响应体SHA-256
+}
+
+// SharedExit 被多个代理共用的出口IP。
+type SharedExit struct {
+	ExitIP  string   `json:"exit_ip"` // 出口IP
+	Proxies []string `json:"proxies"` // 共用该出口的代理地址
+}
+
+// ExitReport 代理出口IP唯一性报告。
+type ExitReport struct {
+	Proxies     int          `json:"proxies"`      // 正在检查的代理数
+	Known       int          `json:"known"`        // 已知出口IP的代理数
+	UniqueExits int          `json:"unique_exits"` // 不同出口IP的数量
+	Shared      []SharedExit `json:"shared"`       // 被多个代理共用的出口IP
+}
+
+// healthChecker 上游代理健康检查器。
+//
+// 代理首次被API返回时登记，首次检查时间在一个检查间隔内随机分布，
+// 之后每次检查的间隔都叠加随机抖动，使大量代理的检查在时间上错开；
+// 到期的检查按优先级交给固定数量的工作协程执行，配置了全局检查速率时不超过该速率，避免对代理服务商造成突发负载。
+// 启用自适应调度时，最近失败和繁忙的代理检查得更频繁，空闲的健康代理检查得更少。
+// 启用被动检查时，实际流量的结果同样计入健康状态，成功的流量会推迟下一次主动检查。
+type healthChecker struct {
+	opts    HealthOptions           // 检查配置
+	entries map[string]*healthEntry // 按代理地址索引的健康状态
+	certs   *certWatcher            // 目标证书观察器，未配置观察目标时为nil
+	canary  *canaryChecker          // 内容完整性检查器，未配置金丝雀地址时为nil
+	jobs    chan *healthEntry       // 待执行的检查
+	budget  *ProbeBudget            // 各代理池共用的检查速率预算，不限制时为nil
+	stop    chan struct{}           // 停止信号
+	once    sync.Once               // 保证只停止一次
+	mutex   sync.Mutex              // 互斥锁
+}
+
+// newHealthChecker 创建健康检查器并启动调度协程，启用主动检查时同时启动工作协程。
+//
+// 参数：
+//   - opts: 健康检查配置
+//
+// 返回值：
+//   - *healthChecker: 健康检查器，主动和被动检查都未启用时为nil
+//   - error: 检查方式或检查地址无效时返回错误
+func newHealthChecker(opts HealthOptions) (*healthChecker, error) {
+	if !opts.Enabled && !opts.Passive {
+		return nil, nil
+	}
+	if opts.Enabled {
+		if opts.Mode == "" {
+			opts.Mode = HealthModeHTTP
+		}
+		target, err := url.Parse(opts.URL)
+		if err != nil || target.Hostname() == "" {
+			return nil, fmt.Errorf("无效的健康检查地址: %s", opts.URL)
+		}
+		switch opts.Mode {
+		case HealthModeHTTP:
+		case HealthModeConnect:
+			port := target.Port()
+			if port == "" {
+				port = "80"
+				if target.Scheme == "https" {
+					port = "443"
+				}
+			}
+			opts.URL = net.JoinHostPort(target.Hostname(), port)
+		default:
+			return nil, fmt.Errorf("未知的健康检查方式: %s", opts.Mode)
+		}
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 1
+	}
+	if opts.Recovery <= 0 {
+		opts.Recovery = 1
+	}
+
+	h := &healthChecker{
+		opts:    opts,
+		entries: make(map[string]*healthEntry),
+		jobs:    make(chan *healthEntry, opts.Workers),
+		budget:  opts.Budget,
+		stop:    make(chan struct{}),
+	}
+	if opts.Enabled {
+		h.certs = newCertWatcher(opts.CertHosts, opts.Timeout)
+		canary, err := newCanaryChecker(opts.CanaryURL, opts.CanarySHA256, opts.Interval, opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		h.canary = canary
+	}
+	go h.schedule()
+	if opts.Passive {
+		log.Printf("被动健康检查已启用，实际流量的结果将计入代理健康状态")
+	}
+	if !opts.Enabled {
+		return h, nil
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		go h.worker()
+	}
+	log.Printf("健康检查已启用: 方式=%s, 地址=%s, 间隔=%v, 并发=%d, 抖动=%.0f%%",
+		opts.Mode, opts.URL, opts.Interval, opts.Workers, opts.Jitter*100)
+	if opts.Adaptive {
+		log.Printf("健康检查自适应调度已启用: 空闲代理的检查间隔最多放大 %d 倍", max(opts.IdleFactor, 1))
+	}
+	if h.certs != nil {
+		log.Printf("证书观察已启用: 目标=%s", strings.Join(h.certs.targets, ","))
+	}
+	if h.canary != nil {
+		log.Printf("内容完整性检查已启用: 金丝雀地址=%s, 停用篡改代理=%v", opts.CanaryURL, opts.CanaryExclude)
+	}
+	return h, nil
+}
+
+// enabled 判断是否启用了健康检查。
+func (h *healthChecker) enabled() bool {
+	return h != nil
+}
+
+// observe 登记API返回的代理，已登记的代理只刷新最近出现时间。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+func (h *healthChecker) observe(proxy models.ProxyInfo) {
+	if !h.enabled() {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	if entry, ok := h.entries[proxy.Host]; ok {
+		entry.proxy = proxy
+		entry.lastSeen = now
+		entry.uses++
+		return
+	}
+	h.entries[proxy.Host] = &healthEntry{
+		proxy:     proxy,
+		healthy:   true,
+		nextCheck: now.Add(time.Duration(rand.Int63n(int64(h.opts.Interval) + 1))),
+		lastSeen:  now,
+	}
+}
+
+// healthy 判断代理是否健康，未登记的代理视为健康。
+//
+// 启用停用篡改代理时，最近一次金丝雀检查发现响应被篡改的代理视为不健康。
+// 仅启用被动检查时没有主动检查能让不健康的代理恢复，
+// 这类代理在等待一个检查间隔后重新放行，由下一次实际流量验证。
+//
+// 参数：
+//   - host: 代理地址
+//
+// 返回值：
+//   - bool: 是否健康
+func (h *healthChecker) healthy(host string) bool {
+	if !h.enabled() {
+		return true
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entry, ok := h.entries[host]
+	if !ok {
+		return true
+	}
+	if entry.tampered && h.opts.CanaryExclude {
+		return false
+	}
+	if entry.healthy {
+		return true
+	}
+	return !h.opts.Enabled && time.Now().After(entry.retryAt)
+}
+
+// schedule 定期找出到期的检查并按优先级交给工作协程执行。
+//
+// 每轮最多分派空闲的工作协程数和剩余检查速率预算允许的检查，
+// 其余到期的检查留到下一轮调度，不会堆积。
+func (h *healthChecker) schedule() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case now := <-ticker.C:
+			granted := h.budget.take(now, cap(h.jobs)-len(h.jobs))
+			dispatched := 0
+			for _, entry := range h.due(now, granted) {
+				select {
+				case h.jobs <- entry:
+					dispatched++
+				default:
+					h.release(entry)
+				}
+			}
+			h.budget.refund(granted - dispatched)
+		}
+	}
+}
+
+// due 按优先级返回至多limit个已到期的检查，并清理长期未被API返回的代理。
+func (h *healthChecker) due(now time.Time, limit int) []*healthEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var queue probeQueue
+	for host, entry := range h.entries {
+		if now.Sub(entry.lastSeen) > healthForgetIntervals*h.opts.Interval {
+			delete(h.entries, host)
+			h.certs.forget(host)
+			continue
+		}
+		if !h.opts.Enabled || entry.inflight || now.Before(entry.nextCheck) {
+			continue
+		}
+		queue = append(queue, probeItem{entry: entry, priority: h.priority(entry, now)})
+	}
+
+	result := queue.top(limit)
+	for _, entry := range result {
+		entry.inflight = true
+	}
+	return result
+}
+
+// release 撤销未能分派的检查，留待下一轮调度。
+func (h *healthChecker) release(entry *healthEntry) {
+	h.mutex.Lock()
+	entry.inflight = false
+	h.mutex.Unlock()
+}
+
+// worker 执行检查并更新健康状态。
+func (h *healthChecker) worker() {
+	for {
+		select {
+		case <-h.stop:
+			return
+		case entry := <-h.jobs:
+			h.mutex.Lock()
+			proxy := entry.proxy
+			h.mutex.Unlock()
+
+			err := h.check(proxy)
+			h.record(entry, err)
+			if err == nil && h.opts.ExitIPURL != "" {
+				h.discoverExit(entry, proxy)
+			}
+			if err == nil {
+				h.certs.observe(proxy)
+			}
+			if err == nil && h.canary != nil {
+				h.verifyCanary(entry, proxy)
+			}
+		}
+	}
+}
+
+// check 按检查方式检查代理。
+//
+// connect方式只完成CONNECT握手，不发送任何请求数据，适合按流量计费的代理。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+//
+// 返回值：
+//   - error: 检查失败的原因，成功时为nil
+func (h *healthChecker) check(proxy models.ProxyInfo) error {
+	if h.opts.Mode == HealthModeConnect {
+		return probeConnect(proxy, h.opts.URL, h.opts.Timeout)
+	}
+
+	proxyURL := &url.URL{Scheme: "http", Host: proxy.Host}
+	if proxy.URL != nil && proxy.URL.Scheme != "" {
+		proxyURL.Scheme = proxy.URL.Scheme
+	}
+	if proxy.Username != "" {
+		proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+	}
+
+	resp, err := h.get(proxy, h.opts.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("检查地址返回 %s", resp.Status)
+	}
+	return nil
+}
+
+// discoverExit 通过代理访问出口IP查询地址，记录代理的出口IP。
+//
+// 查询失败时保留之前记录的出口IP。
+//
+// 参数：
+//   - entry: 代理的健康状态
+//   - proxy: 代理服务器信息
+func (h *healthChecker) discoverExit(entry *healthEntry, proxy models.ProxyInfo) {
+	resp, err := h.get(proxy, h.opts.ExitIPURL)
+	if err != nil {
+		log.Printf("查询代理 %s 的出口IP失败: %v", proxy.Host, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		log.Printf("查询代理 %s 的出口IP失败: 状态 %s", proxy.Host, resp.Status)
+		return
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		log.Printf("查询代理 %s 的出口IP失败: 响应不是IP地址", proxy.Host)
+		return
+	}
+
+	h.mutex.Lock()
+	entry.exitIP = ip.String()
+	h.mutex.Unlock()
+}
+
+// verifyCanary 通过代理请求金丝雀地址，记录响应是否被篡改。
+//
+// 请求失败时保留之前的结论；篡改状态变化时记录日志。
+//
+// 参数：
+//   - entry: 代理的健康状态
+//   - proxy: 代理服务器信息
+func (h *healthChecker) verifyCanary(entry *healthEntry, proxy models.ProxyInfo) {
+	sum, tampered, err := h.canary.verify(func(target string) (*http.Response, error) {
+		return h.get(proxy, target)
+	})
+	if err != nil {
+		log.Printf("代理 %s 的内容完整性检查失败: %v", proxy.Host, err)
+		return
+	}
+
+	h.mutex.Lock()
+	was := entry.tampered
+	entry.tampered, entry.canarySum = tampered, sum
+	h.mutex.Unlock()
+
+	switch {
+	case tampered && !was:
+		log.Printf("[完整性告警] 代理 %s 返回的金丝雀响应被篡改: SHA-256 %s", proxy.Host, sum)
+	case !tampered && was:
+		log.Printf("代理 %s 返回的金丝雀响应已恢复正常", proxy.Host)
+	}
+}
+
+// get 通过代理发起GET请求，不跟随重定向，不复用连接。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+//   - target: 请求地址
+//
+// 返回值：
+//   - *http.Response: 响应
+//   - error: 请求失败的原因
+func (h *healthChecker) get(proxy models.ProxyInfo, target string) (*http.Response, error) {
+	proxyURL := &url.URL{Scheme: "http", Host: proxy.Host}
+	if proxy.URL != nil && proxy.URL.Scheme != "" {
+		proxyURL.Scheme = proxy.URL.Scheme
+	}
+	if proxy.Username != "" {
+		proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+	}
+
+	transport := &http.Transport{
+		Proxy:             http.ProxyURL(proxyURL),
+		DisableKeepAlives: true,
+	}
+	if len(proxy.Chain) > 0 {
+		transport.DialContext = dialer.TransportDialProxy(proxy)
+	}
+	if d, ok := dialer.Lookup(proxy); ok {
+		transport.Proxy, transport.DialContext = nil, dialer.TransportDial(d, proxy)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   h.opts.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return client.Get(target)
+}
+
+// certReport 返回目标证书观察报告，未启用健康检查时为空报告。
+func (h *healthChecker) certReport() CertReport {
+	var certs *certWatcher
+	if h.enabled() {
+		certs = h.certs
+	}
+	return certs.report()
+}
+
+// healthyRatio 返回健康代理占已登记代理的比例。
+//
+// 返回值：
+//   - float64: 健康代理比例，未启用健康检查或没有登记的代理时为1
+func (h *healthChecker) healthyRatio() float64 {
+	if !h.enabled() {
+		return 1
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.entries) == 0 {
+		return 1
+	}
+	var healthy int
+	for _, entry := range h.entries {
+		if entry.healthy {
+			healthy++
+		}
+	}
+	return float64(healthy) / float64(len(h.entries))
+}
+
+// exitIP 返回代理最近一次观察到的出口IP。
+//
+// 参数：
+//   - host: 代理地址
+//
+// 返回值：
+//   - string: 出口IP，未知时为空
+func (h *healthChecker) exitIP(host string) string {
+	if !h.enabled() {
+		return ""
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if entry, ok := h.entries[host]; ok {
+		return entry.exitIP
+	}
+	return ""
+}
+
+// exitReport 按出口IP汇总正在检查的代理，找出共用同一出口的代理。
+//
+// 返回值：
+//   - ExitReport: 出口IP唯一性报告
+func (h *healthChecker) exitReport() ExitReport {
+	report := ExitReport{Shared: []SharedExit{}}
+	if !h.enabled() {
+		return report
+	}
+
+	h.mutex.Lock()
+	byExit := make(map[string][]string)
+	for host, entry := range h.entries {
+		report.Proxies++
+		if entry.exitIP != "" {
+			report.Known++
+			byExit[entry.exitIP] = append(byExit[entry.exitIP], host)
+		}
+	}
+	h.mutex.Unlock()
+
+	report.UniqueExits = len(byExit)
+	for ip, hosts := range byExit {
+		if len(hosts) > 1 {
+			sort.Strings(hosts)
+			report.Shared = append(report.Shared, SharedExit{ExitIP: ip, Proxies: hosts})
+		}
+	}
+	sort.Slice(report.Shared, func(i, j int) bool {
+		if len(report.Shared[i].Proxies) != len(report.Shared[j].Proxies) {
+			return len(report.Shared[i].Proxies) > len(report.Shared[j].Proxies)
+		}
+		return report.Shared[i].ExitIP < report.Shared[j].ExitIP
+	})
+	return report
+}
+
+// record 记录主动检查结果。
+func (h *healthChecker) record(entry *healthEntry, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entry.inflight = false
+	entry.nextCheck = time.Now().Add(h.jitter(h.interval(entry, err)))
+	entry.activeChecks++
+	if err != nil {
+		entry.activeFailures++
+	}
+	h.update(entry, err, healthSourceActive)
+}
+
+// report 记录实际流量的结果。
+//
+// 只有连接被拒绝、被重置、超时等网络层错误计为失败，上游代理返回的
+// 错误状态码可能来自目标或代理的访问策略，不计入健康状态。
+// 成功的流量会推迟该代理的下一次主动检查。
+//
+// 参数：
+//   - host: 代理地址
+//   - err: 流量的错误，成功时为nil
+func (h *healthChecker) report(host string, err error) {
+	if !h.enabled() || !h.opts.Passive {
+		return
+	}
+	if err != nil && !isNetworkFailure(err) {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entry, ok := h.entries[host]
+	if !ok {
+		return
+	}
+	if err == nil {
+		entry.passiveSuccesses++
+		if h.opts.Enabled && !entry.inflight {
+			entry.nextCheck = time.Now().Add(h.jitter(h.opts.Interval))
+		}
+	} else {
+		entry.passiveFailures++
+	}
+	h.update(entry, err, healthSourcePassive)
+}
+
+// update 根据一次检查或流量的结果更新健康状态，并在状态变化时输出日志。
+//
+// 不健康的代理需要连续成功 Recovery 次才恢复使用，期间的任何失败都会重新计数，
+// 配置了 MaxBackoff 时下次重试的等待时间加倍。
+func (h *healthChecker) update(entry *healthEntry, err error, source string) {
+	entry.source = source
+	if err == nil {
+		entry.failures = 0
+		if !entry.healthy {
+			entry.successes++
+			if entry.successes < h.opts.Recovery {
+				return
+			}
+			log.Printf("代理 %s 已恢复健康（%s）", entry.proxy.Host, source)
+		}
+		entry.healthy = true
+		entry.successes = 0
+		entry.backoffs = 0
+		return
+	}
+
+	entry.failures++
+	entry.successes = 0
+	if !entry.healthy {
+		entry.backoffs++
+		delay := h.retryDelay(entry.backoffs)
+		entry.retryAt = time.Now().Add(delay)
+		if delay > h.opts.Interval {
+			entry.nextCheck = entry.retryAt
+			log.Printf("代理 %s 重试失败 %d 次（%s），%v 后再试: %v", entry.proxy.Host, entry.backoffs, source, delay, err)
+		}
+		return
+	}
+	if entry.failures >= h.opts.Threshold {
+		entry.healthy = false
+		entry.backoffs = 0
+		entry.retryAt = time.Now().Add(h.opts.Interval)
+		log.Printf("代理 %s 连续 %d 次检查失败（%s），暂停使用: %v", entry.proxy.Host, entry.failures, source, err)
+	}
+}
+
+// retryDelay 返回不健康代理重试失败若干次后的等待时间。
+//
+// 每失败一次等待时间加倍，不超过 MaxBackoff；MaxBackoff 不大于检查间隔时固定为检查间隔。
+//
+// 参数：
+//   - backoffs: 连续重试失败的次数
+//
+// 返回值：
+//   - time.Duration: 下次重试前的等待时间
+func (h *healthChecker) retryDelay(backoffs int) time.Duration {
+	delay := h.opts.Interval
+	for i := 0; i < backoffs && delay < h.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > h.opts.MaxBackoff {
+		delay = max(h.opts.MaxBackoff, h.opts.Interval)
+	}
+	return delay
+}
+
+// recoveryIn 估计最早有不健康代理可能恢复使用的时间。
+//
+// 启用主动检查时以下次检查时间为准，检查已到期或正在进行时按一次检查超时估计；
+// 仅被动检查时以重新放行的时间为准。
+//
+// 返回值：
+//   - time.Duration: 距离最早可能恢复的时间，没有不健康的代理时为0
+func (h *healthChecker) recoveryIn() time.Duration {
+	if !h.enabled() {
+		return 0
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	var earliest time.Time
+	for _, entry := range h.entries {
+		if entry.healthy {
+			continue
+		}
+		at := entry.retryAt
+		if h.opts.Enabled {
+			at = entry.nextCheck
+			if entry.inflight || at.Before(now) {
+				at = now.Add(h.opts.Timeout)
+			}
+		}
+		if earliest.IsZero() || at.Before(earliest) {
+			earliest = at
+		}
+	}
+	if earliest.IsZero() {
+		return 0
+	}
+	return max(earliest.Sub(now), 0)
+}
+
+// isNetworkFailure 判断错误是否为连接被拒绝、被重置或超时等网络层错误。
+//
+// 客户端主动取消的请求不计为失败；url.Error本身实现了net.Error，需要先取出其包装的错误。
+func isNetworkFailure(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// stats 返回全部登记代理的健康统计。
+//
+// 返回值：
+//   - []ProxyHealth: 按代理地址排序的健康统计
+func (h *healthChecker) stats() []ProxyHealth {
+	result := []ProxyHealth{}
+	if !h.enabled() {
+		return result
+	}
+
+	h.mutex.Lock()
+	for host, entry := range h.entries {
+		var retryAt, nextCheck *time.Time
+		if !entry.healthy {
+			at := entry.retryAt
+			if h.opts.Enabled {
+				at = entry.nextCheck
+			}
+			retryAt = &at
+		} else if h.opts.Enabled {
+			at := entry.nextCheck
+			nextCheck = &at
+		}
+		result = append(result, ProxyHealth{
+			Proxy:      host,
+			Healthy:    entry.healthy,
+			Failures:   entry.failures,
+			Successes:  entry.successes,
+			Backoffs:   entry.backoffs,
+			RetryAt:    retryAt,
+			NextCheck:  nextCheck,
+			LastSource: entry.source,
+			ExitIP:     entry.exitIP,
+			Active:     HealthSignals{Successes: entry.activeChecks - entry.activeFailures, Failures: entry.activeFailures},
+			Passive:    HealthSignals{Successes: entry.passiveSuccesses, Failures: entry.passiveFailures},
+
+			Tampered: entry.tampered,
+			Canary:   entry.canarySum,
+		})
+	}
+	h.mutex.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Proxy < result[j].Proxy })
+	return result
+}
+
+// jitter 返回叠加随机抖动后的检查间隔。
+func (h *healthChecker) jitter(interval time.Duration) time.Duration {
+	spread := time.Duration(float64(interval) * h.opts.Jitter)
+	if spread <= 0 {
+		return interval
+	}
+	return interval - spread + time.Duration(rand.Int63n(int64(2*spread)+1))
+}
+
+// close 停止健康检查。
+func (h *healthChecker) close() {
+	if !h.enabled() {
+		return
+	}
+	h.once.Do(func() { close(h.stop) })
+}
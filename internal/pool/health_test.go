@@ -0,0 +1,30 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		interval   time.Duration
+		maxBackoff time.Duration
+		backoffs   int
+		want       time.Duration
+	}{
+		{"未配置上限时固定为检查间隔", 30 * time.Second, 0, 3, 30 * time.Second},
+		{"上限不大于间隔时固定为检查间隔", 30 * time.Second, 20 * time.Second, 3, 30 * time.Second},
+		{"第一次失败加倍", 30 * time.Second, 10 * time.Minute, 1, time.Minute},
+		{"连续失败继续加倍", 30 * time.Second, 10 * time.Minute, 3, 4 * time.Minute},
+		{"不超过上限", 30 * time.Second, 10 * time.Minute, 10, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &healthChecker{opts: HealthOptions{Interval: tt.interval, MaxBackoff: tt.maxBackoff}}
+			if got := h.retryDelay(tt.backoffs); got != tt.want {
+				t.Errorf("retryDelay(%d) = %v，期望 %v", tt.backoffs, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package pool
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+const (
+	// healthBusyUses 两次检查之间被选中至少多少次的代理视为繁忙
+	healthBusyUses = 10
+	// healthMinInterval 自适应调度下检查间隔的下限
+	healthMinInterval = time.Second
+	// maxIdleDoublings 空闲代理检查间隔连续加倍的次数上限，防止位移溢出
+	maxIdleDoublings = 30
+)
+
+// ProbeBudget 全部代理池共用的健康检查速率预算，按令牌桶每秒补充，最多积累一秒的用量。
+type ProbeBudget struct {
+	rate   float64    // 每秒允许发起的检查数
+	tokens float64    // 当前可用的检查数
+	last   time.Time  // 上次补充的时间
+	mutex  sync.Mutex // 互斥锁
+}
+
+// NewProbeBudget 创建健康检查速率预算。
+//
+// 参数：
+//   - rate: 全部代理池每秒允许发起的检查数，0表示不限制
+//
+// 返回值：
+//   - *ProbeBudget: 检查速率预算，不限制时为nil
+func NewProbeBudget(rate float64) *ProbeBudget {
+	if rate <= 0 {
+		return nil
+	}
+	return &ProbeBudget{rate: rate, tokens: max(rate, 1), last: time.Now()}
+}
+
+// take 补充令牌并取出至多want次检查的预算，不限制时原样返回want。
+//
+// 参数：
+//   - now: 当前时间
+//   - want: 希望发起的检查数
+//
+// 返回值：
+//   - int: 允许发起的检查数
+func (b *ProbeBudget) take(now time.Time, want int) int {
+	if b == nil {
+		return want
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens = min(max(b.rate, 1), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	granted := min(want, int(b.tokens))
+	b.tokens -= float64(granted)
+	return granted
+}
+
+// refund 退回取出后没有用掉的预算。
+func (b *ProbeBudget) refund(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	b.tokens += float64(n)
+	b.mutex.Unlock()
+}
+
+// interval 返回代理本次检查后到下次检查的基础间隔（叠加抖动前），调用方需持有锁。
+//
+// 未启用自适应调度时固定为检查间隔。启用后：本次检查失败、之前有连续失败或代理不健康时缩短为四分之一，
+// 尽快确认状态变化；两次检查之间被频繁选中的代理缩短为一半；没有被选中的健康代理每次加倍，
+// 最多为检查间隔的 IdleFactor 倍。
+//
+// 参数：
+//   - entry: 代理的健康状态，尚未按本次结果更新
+//   - err: 本次检查的错误，成功时为nil
+//
+// 返回值：
+//   - time.Duration: 检查间隔
+func (h *healthChecker) interval(entry *healthEntry, err error) time.Duration {
+	if !h.opts.Adaptive {
+		return h.opts.Interval
+	}
+	uses := entry.uses
+	entry.uses = 0
+
+	base := h.opts.Interval
+	switch {
+	case err != nil || entry.failures > 0 || !entry.healthy:
+		entry.idleChecks = 0
+		return max(base/4, healthMinInterval)
+	case uses >= healthBusyUses:
+		entry.idleChecks = 0
+		return max(base/2, healthMinInterval)
+	case uses == 0:
+		entry.idleChecks = min(entry.idleChecks+1, maxIdleDoublings)
+		return base * time.Duration(min(1<<entry.idleChecks, max(h.opts.IdleFactor, 1)))
+	default:
+		entry.idleChecks = 0
+		return base
+	}
+}
+
+// priority 返回到期检查的优先级，越大越先执行，调用方需持有锁。
+//
+// 最近失败或不健康的代理权重最高，其次是繁忙的代理；同一权重下逾期越久越优先，
+// 因此预算不足时低权重的检查只会推迟而不会一直得不到执行。
+func (h *healthChecker) priority(entry *healthEntry, now time.Time) float64 {
+	weight := 1.0
+	switch {
+	case entry.failures > 0 || !entry.healthy:
+		weight = 4
+	case entry.uses >= healthBusyUses:
+		weight = 2
+	}
+	return weight * (now.Sub(entry.nextCheck).Seconds() + 1)
+}
+
+// probeItem 检查队列中的一项。
+type probeItem struct {
+	entry    *healthEntry // 代理的健康状态
+	priority float64      // 优先级，越大越先执行
+}
+
+// probeQueue 按优先级排列的到期检查，实现 heap.Interface。
+type probeQueue []probeItem
+
+func (q probeQueue) Len() int           { return len(q) }
+func (q probeQueue) Less(i, j int) bool { return q[i].priority > q[j].priority }
+func (q probeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *probeQueue) Push(x any)        { *q = append(*q, x.(probeItem)) }
+func (q *probeQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// top 返回优先级最高的至多limit项，limit小于0时按优先级返回全部。
+func (q probeQueue) top(limit int) []*healthEntry {
+	if limit < 0 || limit > len(q) {
+		limit = len(q)
+	}
+	heap.Init(&q)
+	result := make([]*healthEntry, 0, limit)
+	for len(result) < limit {
+		result = append(result, heap.Pop(&q).(probeItem).entry)
+	}
+	return result
+}
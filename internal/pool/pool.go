@@ -6,115 +6,380 @@
 package pool
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/rfym21/ProxyFlow/internal/config"
+	"github.com/rfym21/ProxyFlow/internal/dialer"
 	"github.com/rfym21/ProxyFlow/internal/models"
 )
 
+const (
+	// maxSelectAttempts 为满足选择条件而重新获取代理的最大次数。
+	// 代理列表在选择前已按与目标无关的条件过滤，这里只限制逐次请求的API来源
+	// 以及出口IP、速率和会话配额等与目标相关的条件的重试次数
+	maxSelectAttempts = 5
+)
+
+// Options 代理池可选配置。
+type Options struct {
+	SessionMaxRequests int                    // 每个代理对同一目标的最大请求数，0表示不限制
+	SessionQuotaWindow time.Duration          // 会话配额统计窗口
+	StickySessionTTL   time.Duration          // 粘性会话空闲过期时间
+	StickySessionMax   int                    // 粘性会话数上限，0表示不限制
+	Sessions           SessionStore           // 粘性会话存储，nil时按TTL和上限创建进程内存储
+	Probe              ProbeOptions           // 代理能力探测配置
+	Health             HealthOptions          // 主动健康检查配置
+	AvoidRepeatExit    bool                   // 避免同一目标连续使用相同的出口IP（依赖健康检查记录的出口IP）
+	Credentials        map[string]Credentials // 启动时的凭据覆盖，键为代理地址或AllProxies
+	QueueMaxWait       time.Duration          // 暂时没有可用代理时请求的最长等待时间，0表示不排队
+	QueueMaxSize       int                    // 同时等待可用代理的请求数上限
+	UpstreamRPS        float64                // 每个代理每秒允许的请求数，0表示不限制
+	UpstreamBurst      int                    // 每个代理允许的突发请求数
+	UpstreamMaxWait    time.Duration          // 请求等待代理速率令牌的最长时间
+	Concurrency        ConcurrencyOptions     // 按代理的自适应并发控制配置
+
+	File       string        // 静态代理列表文件路径，与API同时配置时按权重组合两个来源
+	FileKey    []byte        // 解密静态代理列表文件的AES-256密钥，nil表示文件为明文
+	APIWeight  int           // 组合来源时API来源的选择权重
+	FileWeight int           // 组合来源时静态列表来源的选择权重
+	FileReload time.Duration // 检查静态代理列表文件变化的间隔，0表示不重新加载
+
+	APIs          map[string]string        // 额外的代理API端点（来源名称到URL）
+	List          []string                 // 配置中直接给出的静态代理列表
+	SourceWeights map[string]int           // 按来源名称覆盖的选择权重
+	SourceRefresh map[string]time.Duration // 按来源名称的刷新间隔，API来源设置后改为定期拉取完整列表
+
+	APIErrorBackoff time.Duration // 代理API失败后暂停请求并改用本地缓存的时间，0表示不暂停
+
+	PrefetchSize   int           // 每个逐次请求的API来源在后台预取的代理数，0表示不预取
+	PrefetchMaxAge time.Duration // 预取的代理在缓冲中的最长停留时间，0表示不限制
+
+	Strategy string // 从静态代理列表中选择代理的策略名称，为空时按顺序轮流选择
+
+	Chain []*url.URL // 连接本池代理前依次经过的HTTP代理，为空表示直接连接
+}
+
 // Pool 代理池管理器。
 //
 // 通过API动态获取代理服务器连接信息，每次请求时获取一个新的随机代理。
 // 提供线程安全的代理获取机制。
 type Pool struct {
-	apiURL     string        // 代理API端点URL
-	httpClient *http.Client  // HTTP客户端
-	mutex      sync.RWMutex  // 读写锁
+	httpClient *http.Client        // HTTP客户端
+	quota      *quotaTracker       // 会话配额跟踪器
+	sticky     SessionStore        // 粘性会话存储
+	prober     *capabilityProber   // 能力探测器
+	health     *healthChecker      // 健康检查器
+	exits      *exitRotation       // 出口IP轮换记录
+	creds      *credentialStore    // 凭据覆盖
+	bound      credentialCache     // 绑定到会话的代理的凭据
+	removed    map[string]bool     // 已移除、不再分配新连接的代理地址
+	queue      *selectionQueue     // 暂时没有可用代理时的请求等待队列
+	rate       *rateLimiter        // 按代理的请求速率限制
+	limits     *concurrencyLimiter // 按代理的自适应并发控制
+	sources    *sourceSet          // 代理来源组合
+	load       *loadTracker        // 代理负载记录，只在选择策略需要时创建
+	chain      []*url.URL          // 连接代理前依次经过的HTTP代理
+	mutex      sync.RWMutex        // 读写锁
 }
 
 // NewPool 创建新的代理池实例。
 //
-// 初始化用于从API动态获取代理的代理池。
+// 初始化用于从API动态获取代理的代理池，opts.File、opts.APIs或opts.List非空时同时使用这些来源，
+// 各来源按权重组合，列表型来源合并去重，apiURL为空时只使用其他来源。
 //
 // 参数：
 //   - apiURL: 代理API端点URL
+//   - opts: 代理池可选配置
 //
 // 返回值：
 //   - *Pool: 初始化完成的代理池实例
 //   - error: 初始化错误，成功时为nil
-func NewPool(apiURL string) (*Pool, error) {
-	if apiURL == "" {
-		return nil, fmt.Errorf("PROXY_API 配置不能为空")
+func NewPool(apiURL string, opts Options) (*Pool, error) {
+	if apiURL == "" && opts.File == "" && len(opts.APIs) == 0 && len(opts.List) == 0 {
+		return nil, fmt.Errorf("PROXY_API、PROXY_APIS、PROXY_FILE 和 PROXY_LIST 至少配置一项")
+	}
+
+	health, err := newHealthChecker(opts.Health)
+	if err != nil {
+		return nil, err
+	}
+	var load *loadTracker
+	if needsLoad(opts.Strategy) {
+		load = newLoadTracker()
+	}
+	strategy, err := newStrategy(opts.Strategy, load)
+	if err != nil {
+		health.close()
+		return nil, err
 	}
 
 	pool := &Pool{
-		apiURL: apiURL,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		quota:   newQuotaTracker(opts.SessionMaxRequests, opts.SessionQuotaWindow),
+		sticky:  opts.Sessions,
+		prober:  newCapabilityProber(opts.Probe),
+		health:  health,
+		exits:   newExitRotation(opts.AvoidRepeatExit),
+		creds:   newCredentialStore(opts.Credentials),
+		removed: make(map[string]bool),
+		queue:   newSelectionQueue(opts.QueueMaxWait, opts.QueueMaxSize),
+		rate:    newRateLimiter(opts.UpstreamRPS, opts.UpstreamBurst, opts.UpstreamMaxWait),
+		limits:  newConcurrencyLimiter(opts.Concurrency),
+		load:    load,
+		chain:   opts.Chain,
 	}
 
-	log.Printf("代理池已初始化，API端点: %s", apiURL)
+	if pool.sticky == nil {
+		pool.sticky = NewMemorySessionStore(opts.StickySessionTTL, opts.StickySessionMax)
+	}
+
+	sources, err := pool.newSources(apiURL, opts)
+	if err != nil {
+		health.close()
+		return nil, err
+	}
+	pool.sources = newSourceSet(sources, strategy)
+	log.Printf("代理池已初始化，代理来源: %s", pool.sources.describe())
+	if opts.Strategy != "" && opts.Strategy != StrategyRoundRobin {
+		log.Printf("代理列表选择策略: %s", opts.Strategy)
+	}
+	if pool.quota.enabled() {
+		log.Printf("会话配额已启用: 每个代理对同一目标最多 %d 次请求，统计窗口 %v",
+			opts.SessionMaxRequests, opts.SessionQuotaWindow)
+	}
+	if pool.rate.enabled() {
+		log.Printf("上游请求速率限制已启用: 每个代理每秒 %g 次请求，突发 %d 次，最长等待 %v",
+			opts.UpstreamRPS, max(opts.UpstreamBurst, 1), opts.UpstreamMaxWait)
+	}
+	if pool.limits.enabled() {
+		log.Printf("上游自适应并发控制已启用: 每个代理初始 %d 个并发，范围 %d-%d，延迟超过基准 %g 倍时缩减",
+			pool.limits.opts.Initial, pool.limits.opts.Min, pool.limits.opts.Max, pool.limits.opts.Tolerance)
+	}
+	if opts.AvoidRepeatExit && (!opts.Health.Enabled || opts.Health.ExitIPURL == "") {
+		log.Printf("警告: 出口IP轮换需要启用健康检查并配置 HEALTH_CHECK_EXIT_IP_URL，当前不会生效")
+	}
+	if len(opts.Health.CertHosts) > 0 && !opts.Health.Enabled {
+		log.Printf("警告: 证书观察需要启用主动健康检查(HEALTH_CHECK)，当前不会生效")
+	}
+	if opts.Health.MaxBackoff > 0 && !opts.Health.Enabled && !opts.Health.Passive {
+		log.Printf("警告: 重试退避需要启用主动健康检查(HEALTH_CHECK)或被动健康检查(HEALTH_PASSIVE)，当前不会生效")
+	}
+	if opts.Health.CanaryURL != "" && !opts.Health.Enabled {
+		log.Printf("警告: 内容完整性检查需要启用主动健康检查(HEALTH_CHECK)，当前不会生效")
+	}
 	return pool, nil
 }
 
+// newSources 按配置创建代理来源。
+//
+// 依次为 PROXY_API（api）、PROXY_APIS 中的各端点（按名称排序）、代理列表文件（file）
+// 和配置中的静态列表（list）创建来源；API来源配置了刷新间隔时改为定期拉取完整列表。
+//
+// 参数：
+//   - apiURL: 主代理API端点URL，为空表示不使用
+//   - opts: 代理池可选配置
+//
+// 返回值：
+//   - []*proxySource: 代理来源
+//   - error: 来源名称冲突或列表读取失败
+func (p *Pool) newSources(apiURL string, opts Options) ([]*proxySource, error) {
+	weight := func(name string, fallback int) int {
+		if w, ok := opts.SourceWeights[name]; ok {
+			return max(w, 1)
+		}
+		return max(fallback, 1)
+	}
+
+	var sources []*proxySource
+	closeAll := func() {
+		for _, source := range sources {
+			if source.list != nil {
+				source.list.close()
+			}
+			if source.prefetch != nil {
+				source.prefetch.close()
+			}
+		}
+	}
+	addAPI := func(name, endpoint string, fallbackWeight int) error {
+		if refresh := opts.SourceRefresh[name]; refresh > 0 {
+			reader := &apiListReader{url: endpoint, client: p.httpClient, parse: p.parseProxy}
+			source, err := newListSource(name, SourceAPI, weight(name, fallbackWeight), endpoint, reader, refresh, false)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, source)
+			return nil
+		}
+		api := newAPICache(func() (*models.ProxyInfo, error) { return p.fetchProxyFromAPI(endpoint) }, opts.APIErrorBackoff)
+		source := &proxySource{
+			name:     name,
+			kind:     SourceAPI,
+			weight:   weight(name, fallbackWeight),
+			location: endpoint,
+			api:      api,
+			fetch:    api.next,
+		}
+		if opts.PrefetchSize > 0 {
+			source.prefetch = newPrefetcher(api.next, opts.PrefetchSize, opts.PrefetchMaxAge)
+			source.fetch = source.prefetch.next
+		}
+		sources = append(sources, source)
+		return nil
+	}
+
+	if apiURL != "" {
+		if err := addAPI(SourceAPI, apiURL, opts.APIWeight); err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(opts.APIs))
+	for name := range opts.APIs {
+		if name == SourceAPI || name == SourceFile || name == SourceList {
+			closeAll()
+			return nil, fmt.Errorf("代理来源名称 %s 为保留名称", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := addAPI(name, opts.APIs[name], 1); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+	if opts.File != "" {
+		reload := opts.FileReload
+		if refresh, ok := opts.SourceRefresh[SourceFile]; ok {
+			reload = refresh
+		}
+		reader := &fileReader{path: opts.File, key: opts.FileKey, parse: p.parseProxy}
+		source, err := newListSource(SourceFile, SourceFile, weight(SourceFile, opts.FileWeight), opts.File, reader, reload, true)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	if len(opts.List) > 0 {
+		reader := &staticReader{entries: opts.List, parse: p.parseProxy}
+		source, err := newListSource(SourceList, SourceList, weight(SourceList, 1), "", reader, 0, true)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
 // fetchProxyFromAPI 从API获取代理。
 //
-// 向配置的API端点发送HTTP GET请求，获取一个随机代理URL。
+// 向API端点发送HTTP GET请求，获取一个随机代理URL。
 // 解析返回的代理URL并返回代理信息结构。
 //
+// 参数：
+//   - apiURL: 代理API端点URL
+//
 // 返回值：
 //   - *models.ProxyInfo: 从API获取的代理信息
 //   - error: API请求或解析错误，成功时为nil
-func (p *Pool) fetchProxyFromAPI() (*models.ProxyInfo, error) {
+func (p *Pool) fetchProxyFromAPI(apiURL string) (*models.ProxyInfo, error) {
 	p.mutex.RLock()
-	apiURL := p.apiURL
 	client := p.httpClient
 	p.mutex.RUnlock()
 
 	resp, err := client.Get(apiURL)
 	if err != nil {
-		return nil, fmt.Errorf("API请求失败: %v", err)
+		return nil, &apiError{kind: apiErrorRequest, err: fmt.Errorf("API请求失败: %v", err)}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API返回错误状态码: %d", resp.StatusCode)
+		return nil, &apiError{kind: apiErrorStatus, err: fmt.Errorf("API返回错误状态码: %d", resp.StatusCode)}
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("读取API响应失败: %v", err)
+		return nil, &apiError{kind: apiErrorRequest, err: fmt.Errorf("读取API响应失败: %v", err)}
 	}
 
 	proxyURL := strings.TrimSpace(string(body))
 	if proxyURL == "" {
-		return nil, fmt.Errorf("API返回空的代理URL")
+		return nil, &apiError{kind: apiErrorEmpty, err: fmt.Errorf("API返回空的代理URL")}
 	}
 
-	return p.parseProxy(proxyURL)
+	proxy, err := p.parseProxy(proxyURL)
+	if err != nil {
+		return nil, &apiError{kind: apiErrorInvalid, err: err}
+	}
+	return proxy, nil
 }
 
 // parseProxy 解析代理字符串。
 //
 // 将代理URL字符串解析为ProxyInfo结构，提取协议、
-// 主机地址和认证信息。仅支持HTTP和HTTPS协议。
+// 主机地址、认证信息和标签。标签 weight 不参与标签匹配，作为按权重选择时的权重。HTTP和HTTPS之外的协议需在 dialer 包中注册，
+// 由其拨号器改写原始地址或补全解析结果。
 //
 // 参数：
-//   - proxyStr: 代理URL字符串，格式为scheme://[user:pass@]host:port
+//   - proxyStr: 代理URL字符串，格式为scheme://[user:pass@]host:port[#key=value,...]
 //
 // 返回值：
 //   - *models.ProxyInfo: 解析后的代理信息结构
-//   - error: 解析错误，成功时为nil  
+//   - error: 解析错误，成功时为nil
 func (p *Pool) parseProxy(proxyStr string) (*models.ProxyInfo, error) {
-	proxyURL, err := url.Parse(proxyStr)
+	raw := expandProxyURL(proxyStr)
+	if scheme, _, ok := strings.Cut(raw, "://"); ok {
+		if n, ok := lookupDialer(scheme).(dialer.Normalizer); ok {
+			var err error
+			if raw, err = n.Normalize(raw); err != nil {
+				return nil, err
+			}
+		}
+	}
+	proxyURL, err := url.Parse(raw)
 	if err != nil {
 		return nil, fmt.Errorf("无效的代理URL: %v", err)
 	}
 
-	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+	d := lookupDialer(proxyURL.Scheme)
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && d == nil {
 		return nil, fmt.Errorf("不支持的代理协议: %s", proxyURL.Scheme)
 	}
 
+	// URL片段用于携带代理标签，例如 http://host:port#isp=comcast,type=mobile
+	tags := ParseTags(proxyURL.Fragment)
+	proxyURL.Fragment = ""
+
 	proxyInfo := &models.ProxyInfo{
 		URL:  proxyURL,
 		Host: proxyURL.Host,
+		Tags: tags,
+	}
+	if value, ok := tags["weight"]; ok {
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("无效的代理权重: %q", value)
+		}
+		proxyInfo.Weight = weight
+		delete(tags, "weight")
+		if len(tags) == 0 {
+			proxyInfo.Tags = nil
+		}
 	}
 
 	// 提取认证信息
@@ -125,32 +390,538 @@ func (p *Pool) parseProxy(proxyStr string) (*models.ProxyInfo, error) {
 		}
 	}
 
+	// 由上游协议校验并补全，例如补充默认端口
+	if prep, ok := d.(dialer.Preparer); ok {
+		if err := prep.Prepare(proxyInfo); err != nil {
+			return nil, err
+		}
+	}
+
 	return proxyInfo, nil
 }
 
+// lookupDialer 返回协议注册的拨号器，未注册时返回nil。
+func lookupDialer(scheme string) dialer.Dialer {
+	d, _ := dialer.ForScheme(scheme)
+	return d
+}
+
+// expandProxyURL 展开代理URL中的 ${VAR} 环境变量引用。
+//
+// 认证信息中的用户名和密码分别展开后再进行URL编码，因此共享凭据中
+// 出现 "@"、":" 等字符时不会破坏URL结构；其余部分直接展开。
+//
+// 参数：
+//   - raw: 原始代理URL
+//
+// 返回值：
+//   - string: 展开后的代理URL
+func expandProxyURL(raw string) string {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return config.ExpandVars(raw)
+	}
+
+	authorityEnd := strings.IndexAny(rest, "/?#")
+	if authorityEnd < 0 {
+		authorityEnd = len(rest)
+	}
+	authority, tail := rest[:authorityEnd], rest[authorityEnd:]
+
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return config.ExpandVars(raw)
+	}
+	userinfo, host := authority[:at], authority[at+1:]
+
+	username, password, hasPassword := strings.Cut(userinfo, ":")
+	user := url.User(config.ExpandVars(username))
+	if hasPassword {
+		user = url.UserPassword(config.ExpandVars(username), config.ExpandVars(password))
+	}
+	return config.ExpandVars(scheme) + "://" + user.String() + "@" + config.ExpandVars(host) + config.ExpandVars(tail)
+}
+
 // NextProxy 获取下一个代理服务器信息。
 //
-// 从API动态获取一个随机代理。每次调用都会向API请求一个新的代理。
+// 从API动态获取一个随机代理。每次调用都会向API请求一个新的代理，
+// 同时配置了静态代理列表时按权重选择来源，选中的来源失败时改用其他来源。
 // 提供线程安全的代理获取机制。
 //
 // 返回值：
-//   - models.ProxyInfo: 从API获取的代理服务器信息
+//   - models.ProxyInfo: 获取到的代理服务器信息
 func (p *Pool) NextProxy() models.ProxyInfo {
-	proxyInfo, err := p.fetchProxyFromAPI()
+	proxy, err := p.nextProxy(nil)
 	if err != nil {
-		log.Printf("从API获取代理失败: %v", err)
 		return models.ProxyInfo{}
 	}
+	return proxy
+}
+
+// nextProxy 获取下一个代理，代理列表中只考虑满足条件的代理。
+//
+// 参数：
+//   - accept: 代理列表的候选过滤条件，为nil时不过滤
+//
+// 返回值：
+//   - models.ProxyInfo: 获取到的代理服务器信息
+//   - error: 全部来源都获取失败，代理列表中没有满足条件的代理时为 errNoCandidate
+func (p *Pool) nextProxy(accept func(*models.ProxyInfo) bool) (models.ProxyInfo, error) {
+	proxyInfo, err := p.sources.next(accept)
+	if err != nil {
+		if !errors.Is(err, errNoCandidate) {
+			log.Printf("获取代理失败: %v", err)
+		}
+		return models.ProxyInfo{}, err
+	}
+
+	proxy := p.creds.apply(*proxyInfo)
+	proxy.Chain = p.chain
+	proxy.Capabilities = p.prober.lookup(proxy)
+	p.health.observe(proxy)
+	return proxy, nil
+}
 
-	return *proxyInfo
+// Selection 代理选择条件。
+type Selection struct {
+	DestHost  string            // 目标主机名（不含端口），用于会话配额
+	DestPort  int               // 目标端口，仅CONNECT请求设置，用于能力过滤
+	Tags      map[string]string // 要求代理具备的标签，为空时不过滤
+	SessionID string            // 粘性会话ID，为空时不绑定会话
+	Label     string            // 流量标签，只用于日志和统计，不影响代理选择
 }
 
-// Size 获取代理池中的代理数量。
+// Select 按选择条件获取代理服务器信息。
+//
+// 指定了粘性会话ID时，优先返回该会话已绑定的代理，绑定的代理已被移除或对目标的会话配额已用完时
+// 解除绑定；否则重新选择代理，并将结果绑定到该会话。重新选择时代理不能已被移除，标签必须匹配，启用健康检查时代理必须健康，
+// 且在启用会话配额时该代理对目标的使用次数未达到上限；代理列表先按与目标无关的条件过滤再按选择策略选择，
+// 因此只要列表中还有满足条件的代理就不会因为其他代理不满足条件而失败；
+// 启用出口IP轮换时优先选择与该目标上一次出口IP不同的代理。
+// 启用上游请求速率限制时，请求会等待所选代理的令牌，等待时间超过上限的代理不会被选中。
+// 启用自适应并发控制时，进行中的连接数已达到并发上限的代理不会被选中，已绑定到会话的代理不受此限制。
+// 没有任何条件时等同于NextProxy。启用请求排队时，暂时没有可用代理的请求会等待一段时间再失败。
 //
-// 对于API模式，始终返回1，表示可以动态获取代理。
+// 参数：
+//   - sel: 代理选择条件
 //
 // 返回值：
-//   - int: 始终返回1（表示API可用）
+//   - models.ProxyInfo: 满足条件的代理服务器信息
+//   - error: 找不到满足条件的代理时返回错误
+func (p *Pool) Select(sel Selection) (models.ProxyInfo, error) {
+	if sel.SessionID == "" {
+		return p.selectQueued(sel)
+	}
+
+	if proxy, ok := p.sticky.Get(sel.SessionID); ok {
+		proxy = p.restoreCredentials(proxy)
+		switch {
+		case p.isRemoved(proxy.Host):
+			p.sticky.Remove(sel.SessionID)
+			log.Printf("会话 %s 绑定的代理 %s 已被移除，重新选择代理", sel.SessionID, proxy.Host)
+		case !p.quota.acquire(proxy.Host, sel.DestHost):
+			p.sticky.Remove(sel.SessionID)
+			log.Printf("会话 %s 绑定的代理 %s 对目标 %s 已达到会话配额上限，重新选择代理", sel.SessionID, proxy.Host, sel.DestHost)
+		default:
+			delay, ok := p.rate.reserve(proxy.Host)
+			if !ok {
+				p.quota.release(proxy.Host, sel.DestHost)
+				err := fmt.Errorf("会话 %s 绑定的%w，需要等待 %v", sel.SessionID, ErrRateLimited, delay.Round(time.Millisecond))
+				return models.ProxyInfo{}, withRetryAfter(err, delay)
+			}
+			time.Sleep(delay)
+			// 会话存储可能在其他进程中，不保存代理链，按本池的配置补上
+			proxy.Chain = p.chain
+			return p.creds.apply(proxy), nil
+		}
+	}
+
+	proxy, err := p.selectQueued(sel)
+	if err != nil {
+		return proxy, err
+	}
+	p.bound.remember(proxy)
+	p.sticky.Bind(sel.SessionID, proxy)
+	log.Printf("会话 %s 已绑定到代理 %s", sel.SessionID, proxy.Host)
+	return proxy, nil
+}
+
+// restoreCredentials 为会话存储中取回的、不带凭据的代理恢复凭据。
+//
+// 依次使用本进程绑定会话时记录的凭据和列表型来源中同一代理的凭据，都没有时原样返回，
+// 之后仍会应用凭据覆盖。
+//
+// 参数：
+//   - proxy: 会话绑定的代理
+//
+// 返回值：
+//   - models.ProxyInfo: 恢复凭据后的代理
+func (p *Pool) restoreCredentials(proxy models.ProxyInfo) models.ProxyInfo {
+	if proxy.Username != "" || proxy.Password != "" {
+		return proxy
+	}
+	creds, ok := p.bound.lookup(proxy.Host)
+	if !ok && p.sources.merged != nil {
+		creds, ok = p.sources.merged.credentials(proxy.Host)
+	}
+	if !ok {
+		return proxy
+	}
+	return withCredentials(proxy, creds)
+}
+
+// selectQueued 重新选择代理，暂时没有可用代理时在等待队列中重试。
+//
+// 参数：
+//   - sel: 代理选择条件
+//
+// 返回值：
+//   - models.ProxyInfo: 满足条件的代理服务器信息
+//   - error: 找不到满足条件的代理或等待超时时返回错误
+func (p *Pool) selectQueued(sel Selection) (models.ProxyInfo, error) {
+	proxy, err := p.selectFresh(sel)
+	err = p.queue.wait(err, func() error {
+		proxy, err = p.selectFresh(sel)
+		return err
+	})
+	return proxy, err
+}
+
+// RotateSession 解除粘性会话与当前代理的绑定。
+//
+// 会话的下一个连接将重新选择上游代理，从而获得新的出口IP。
+//
+// 参数：
+//   - id: 会话ID
+//
+// 返回值：
+//   - models.ProxyInfo: 解除前绑定的代理
+//   - bool: 会话是否存在
+func (p *Pool) RotateSession(id string) (models.ProxyInfo, bool) {
+	return p.sticky.Remove(id)
+}
+
+// selectFresh 不考虑粘性会话，按条件重新选择代理。
+//
+// 参数：
+//   - sel: 代理选择条件
+//
+// 返回值：
+//   - models.ProxyInfo: 满足条件的代理服务器信息
+//   - error: 找不到满足条件的代理时返回错误
+func (p *Pool) selectFresh(sel Selection) (models.ProxyInfo, error) {
+	needsCapability := p.prober.enabled() && (sel.DestPort != 0 || net.ParseIP(sel.DestHost) != nil)
+	avoidExit := p.exits.enabled() && sel.DestHost != ""
+	if !p.quota.enabled() && len(sel.Tags) == 0 && !needsCapability && !p.health.enabled() && !avoidExit && !p.hasRemoved() && !p.rate.enabled() && !p.limits.enabled() {
+		proxy := p.NextProxy()
+		if proxy.Host == "" {
+			return proxy, errNoProxy
+		}
+		return proxy, nil
+	}
+
+	quotaExceeded := false
+	incapable := false
+	unhealthy := false
+	removed := false
+	rateLimited := false
+	saturated := false
+	var rateDelay time.Duration
+	var repeated *models.ProxyInfo
+
+	// accept 判断代理是否满足与目标无关的条件，不满足时记录原因；
+	// 代理列表按它过滤后再按策略选择，逐次请求的API返回的代理逐个检查
+	accept := func(proxy *models.ProxyInfo) bool {
+		caps := proxy.Capabilities
+		if caps == nil {
+			caps = p.prober.cached(proxy.Host)
+		}
+		switch {
+		case p.isRemoved(proxy.Host):
+			removed = true
+		case !p.health.healthy(proxy.Host):
+			unhealthy = true
+		case !matchTags(proxy.Tags, sel.Tags):
+		case !p.prober.allows(caps, sel):
+			incapable = true
+		case !p.limits.allows(proxy.Host):
+			saturated = true
+		default:
+			return true
+		}
+		return false
+	}
+
+	for i := 0; i < maxSelectAttempts; i++ {
+		proxy, err := p.nextProxy(accept)
+		if errors.Is(err, errNoCandidate) {
+			break
+		}
+		if err != nil || !accept(&proxy) {
+			continue
+		}
+		exitIP := p.health.exitIP(proxy.Host)
+		if p.exits.repeats(sel.DestHost, exitIP) {
+			if repeated == nil {
+				repeated = &proxy
+			}
+			continue
+		}
+		// 先占用配额再预约令牌，预约失败时归还配额，避免为最终不使用的代理消耗令牌
+		if !p.quota.acquire(proxy.Host, sel.DestHost) {
+			quotaExceeded = true
+			continue
+		}
+		delay, ok := p.rate.reserve(proxy.Host)
+		if !ok {
+			p.quota.release(proxy.Host, sel.DestHost)
+			if !rateLimited || delay < rateDelay {
+				rateDelay = delay
+			}
+			rateLimited = true
+			continue
+		}
+		p.exits.record(sel.DestHost, exitIP)
+		time.Sleep(delay)
+		return proxy, nil
+	}
+
+	// 找不到其他出口时退而使用与上一次出口相同的代理
+	if repeated != nil && p.limits.allows(repeated.Host) && p.quota.acquire(repeated.Host, sel.DestHost) {
+		if delay, ok := p.rate.reserve(repeated.Host); ok {
+			time.Sleep(delay)
+			return *repeated, nil
+		}
+		p.quota.release(repeated.Host, sel.DestHost)
+	}
+
+	if incapable && !quotaExceeded {
+		return models.ProxyInfo{}, fmt.Errorf("没有能够连接 %s:%d 的可用代理", sel.DestHost, sel.DestPort)
+	}
+	if len(sel.Tags) > 0 && !quotaExceeded {
+		return models.ProxyInfo{}, fmt.Errorf("没有匹配标签 %s 的可用代理", FormatTags(sel.Tags))
+	}
+	if quotaExceeded {
+		return models.ProxyInfo{}, fmt.Errorf("目标 %s 的候选代理均已达到会话配额上限", sel.DestHost)
+	}
+	if rateLimited {
+		return models.ProxyInfo{}, withRetryAfter(fmt.Errorf("%w: 候选%w", errNoProxy, ErrRateLimited), rateDelay)
+	}
+	if saturated {
+		return models.ProxyInfo{}, fmt.Errorf("%w: 候选代理的并发数均已达到自适应上限", errNoProxy)
+	}
+	if unhealthy {
+		err := fmt.Errorf("%w: 候选代理均未通过健康检查", errNoProxy)
+		return models.ProxyInfo{}, withRetryAfter(err, p.health.recoveryIn())
+	}
+	if removed {
+		return models.ProxyInfo{}, fmt.Errorf("%w: 候选代理均已被移除", errNoProxy)
+	}
+	return models.ProxyInfo{}, errNoProxy
+}
+
+// Acquire 登记一个经由代理的连接或请求，供 least-connections 和 ewma 策略统计负载，
+// 启用自适应并发控制时同时计入代理的并发数。
+//
+// 参数：
+//   - host: 代理地址
+//
+// 返回值：
+//   - func(): 连接关闭或请求结束时调用的释放函数，可重复调用；不需要统计负载和并发数时为nil
+func (p *Pool) Acquire(host string) func() {
+	var release func()
+	if p.load != nil {
+		release = p.load.acquire(host)
+	}
+	if p.limits.enabled() {
+		releaseLoad, releaseLimit := release, p.limits.acquire(host)
+		release = func() {
+			if releaseLoad != nil {
+				releaseLoad()
+			}
+			releaseLimit()
+		}
+	}
+	return release
+}
+
+// ObserveLatency 记录一次经由代理建立连接或收到响应头的耗时，供 ewma 策略和自适应并发控制使用。
+//
+// 参数：
+//   - host: 代理地址
+//   - latency: 耗时
+func (p *Pool) ObserveLatency(host string, latency time.Duration) {
+	p.load.observe(host, latency)
+	p.limits.observe(host, latency)
+}
+
+// ReportOutcome 报告一次经由代理的实际流量的结果，启用被动健康检查时计入代理的健康状态，
+// 启用自适应并发控制时失败会缩减代理的并发上限。
+//
+// 参数：
+//   - host: 代理地址
+//   - err: 流量的错误，成功时为nil
+func (p *Pool) ReportOutcome(host string, err error) {
+	p.health.report(host, err)
+	p.sources.report(host, err)
+	if err != nil {
+		p.limits.fail(host)
+	}
+}
+
+// ConcurrencyStats 获取代理池中各代理的自适应并发状态。
+//
+// 返回值：
+//   - []ConcurrencyStats: 按进行中的连接数降序排列的并发状态，未启用自适应并发控制时为nil
+func (p *Pool) ConcurrencyStats() []ConcurrencyStats {
+	return p.limits.stats()
+}
+
+// SourceStats 获取代理池各来源的统计。
+//
+// 返回值：
+//   - []SourceStats: 按权重从高到低排序的来源统计
+func (p *Pool) SourceStats() []SourceStats {
+	return p.sources.stats()
+}
+
+// HealthStats 获取代理池中各代理的健康统计，包括主动检查和实际流量两类信号。
+//
+// 返回值：
+//   - []ProxyHealth: 按代理地址排序的健康统计
+func (p *Pool) HealthStats() []ProxyHealth {
+	return p.health.stats()
+}
+
+// ExitReport 获取代理池的出口IP唯一性报告。
+//
+// 只有启用健康检查并配置了出口IP查询地址时才有数据。
+//
+// 返回值：
+//   - ExitReport: 出口IP唯一性报告
+func (p *Pool) ExitReport() ExitReport {
+	return p.health.exitReport()
+}
+
+// CertReport 获取代理池观察到的目标TLS证书报告。
+//
+// 只有启用健康检查并配置了证书观察目标时才有数据。
+//
+// 返回值：
+//   - CertReport: 各目标观察到的证书指纹和近期的证书异常事件
+func (p *Pool) CertReport() CertReport {
+	return p.health.certReport()
+}
+
+// HealthyRatio 获取代理池中健康代理的比例。
+//
+// 返回值：
+//   - float64: 健康代理占已登记代理的比例，未启用健康检查或没有登记的代理时为1
+func (p *Pool) HealthyRatio() float64 {
+	return p.health.healthyRatio()
+}
+
+// ExitIP 获取代理最近一次观察到的出口IP。
+//
+// 参数：
+//   - host: 代理地址
+//
+// 返回值：
+//   - string: 出口IP，未启用健康检查出口IP记录或尚未观察到时为空
+func (p *Pool) ExitIP(host string) string {
+	return p.health.exitIP(host)
+}
+
+// SetCredentials 在运行时设置或删除上游代理的凭据覆盖。
+//
+// 之后选出的代理（包括已绑定粘性会话的代理）都会使用新凭据，
+// HTTP客户端按凭据缓存，旧凭据对应的客户端会在下次使用时被替换。
+//
+// 参数：
+//   - host: 代理地址（host:port格式），AllProxies表示全部代理
+//   - creds: 新凭据，为nil时删除覆盖，恢复使用API返回的凭据
+func (p *Pool) SetCredentials(host string, creds *Credentials) {
+	p.creds.set(host, creds)
+	if creds == nil {
+		log.Printf("已删除代理 %s 的凭据覆盖", host)
+		return
+	}
+	log.Printf("已更新代理 %s 的凭据", host)
+}
+
+// CredentialOverrides 获取设置了凭据覆盖的代理地址，不包含凭据本身。
+//
+// 返回值：
+//   - []string: 代理地址列表，AllProxies表示全部代理
+func (p *Pool) CredentialOverrides() []string {
+	return p.creds.hosts()
+}
+
+// Remove 移除代理，之后不再为新连接分配该代理。
+//
+// 已建立的连接不受影响；绑定到该代理的粘性会话在下次请求时重新选择代理。
+//
+// 参数：
+//   - host: 代理地址（host:port格式）
+func (p *Pool) Remove(host string) {
+	p.mutex.Lock()
+	p.removed[host] = true
+	p.mutex.Unlock()
+	log.Printf("代理 %s 已从代理池移除", host)
+}
+
+// Restore 恢复之前移除的代理。
+//
+// 参数：
+//   - host: 代理地址（host:port格式）
+//
+// 返回值：
+//   - bool: 该代理此前是否处于移除状态
+func (p *Pool) Restore(host string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.removed[host] {
+		return false
+	}
+	delete(p.removed, host)
+	log.Printf("代理 %s 已恢复使用", host)
+	return true
+}
+
+// isRemoved 判断代理是否已被移除。
+func (p *Pool) isRemoved(host string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.removed[host]
+}
+
+// hasRemoved 判断是否存在被移除的代理。
+func (p *Pool) hasRemoved() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return len(p.removed) > 0
+}
+
+// Close 停止代理池的后台任务。
+func (p *Pool) Close() {
+	p.health.close()
+	p.sources.close()
+	if store, ok := p.sticky.(*memoryStore); ok {
+		store.close()
+	}
+}
+
+// Size 获取代理池中可供选择的代理数量。
+//
+// 列表型来源按合并去重后未被移除的代理计数；逐次请求的API来源每次返回一个新代理，每个来源计为1。
+//
+// 返回值：
+//   - int: 可供选择的代理数量，0表示当前没有可用的代理
 func (p *Pool) Size() int {
-	return 1 // API动态模式，始终可用
+	removed := p.isRemoved
+	if !p.hasRemoved() {
+		removed = nil
+	}
+	return p.sources.size(removed)
 }
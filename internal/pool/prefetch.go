@@ -0,0 +1,131 @@
+package pool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+const (
+	// prefetchWorkers 每个API来源并发预取的协程数
+	prefetchWorkers = 4
+	// prefetchRetryDelay 预取失败后重试前的等待时间
+	prefetchRetryDelay = time.Second
+)
+
+// PrefetchStats 代理API预取缓冲的统计。
+type PrefetchStats struct {
+	Size     int   `json:"size"`     // 缓冲容量
+	Buffered int   `json:"buffered"` // 当前缓冲中的代理数
+	Hits     int64 `json:"hits"`     // 由缓冲直接提供代理的次数
+	Misses   int64 `json:"misses"`   // 缓冲为空、同步请求API的次数
+	Stale    int64 `json:"stale"`    // 因超过最长缓冲时间而丢弃的代理数
+}
+
+// prefetched 缓冲中的一个代理。
+type prefetched struct {
+	proxy     *models.ProxyInfo // 代理信息
+	fetchedAt time.Time         // 获取时间
+}
+
+// prefetcher 代理API的预取缓冲。
+//
+// 后台协程持续请求API，把获取的代理放入固定容量的缓冲，缓冲满时暂停；
+// 获取代理时直接从缓冲中取出，缓冲为空时才同步请求API，避免每个入站请求都等待API响应。
+// 在缓冲中停留超过最长时间的代理被丢弃，以免使用已经失效的代理。
+type prefetcher struct {
+	fetch  func() (*models.ProxyInfo, error) // 请求API获取一个代理
+	buffer chan prefetched                   // 预取的代理
+	maxAge time.Duration                     // 代理在缓冲中的最长停留时间，0表示不限制
+	stop   chan struct{}                     // 关闭时停止预取
+
+	hits     atomic.Int64 // 由缓冲提供的次数
+	misses   atomic.Int64 // 同步请求的次数
+	stale    atomic.Int64 // 丢弃的过期代理数
+	stopOnce sync.Once    // 保证只关闭一次
+}
+
+// newPrefetcher 创建预取缓冲并开始后台预取。
+//
+// 参数：
+//   - fetch: 请求API获取一个代理的函数
+//   - size: 缓冲容量
+//   - maxAge: 代理在缓冲中的最长停留时间，0表示不限制
+//
+// 返回值：
+//   - *prefetcher: 预取缓冲
+func newPrefetcher(fetch func() (*models.ProxyInfo, error), size int, maxAge time.Duration) *prefetcher {
+	p := &prefetcher{
+		fetch:  fetch,
+		buffer: make(chan prefetched, size),
+		maxAge: maxAge,
+		stop:   make(chan struct{}),
+	}
+	for range min(prefetchWorkers, size) {
+		go p.run()
+	}
+	return p
+}
+
+// run 持续请求API填充缓冲，失败时等待一段时间后重试。
+func (p *prefetcher) run() {
+	for {
+		proxy, err := p.fetch()
+		if err != nil {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(prefetchRetryDelay):
+			}
+			continue
+		}
+		select {
+		case <-p.stop:
+			return
+		case p.buffer <- prefetched{proxy: proxy, fetchedAt: time.Now()}:
+		}
+	}
+}
+
+// next 从缓冲中取出一个代理，缓冲为空时同步请求API。
+//
+// 返回值：
+//   - *models.ProxyInfo: 代理信息
+//   - error: 同步请求API失败
+func (p *prefetcher) next() (*models.ProxyInfo, error) {
+	for {
+		select {
+		case item := <-p.buffer:
+			if p.maxAge > 0 && time.Since(item.fetchedAt) > p.maxAge {
+				p.stale.Add(1)
+				continue
+			}
+			p.hits.Add(1)
+			return item.proxy, nil
+		default:
+			p.misses.Add(1)
+			return p.fetch()
+		}
+	}
+}
+
+// stats 返回预取统计，预取缓冲为nil时返回nil。
+func (p *prefetcher) stats() *PrefetchStats {
+	if p == nil {
+		return nil
+	}
+	return &PrefetchStats{
+		Size:     cap(p.buffer),
+		Buffered: len(p.buffer),
+		Hits:     p.hits.Load(),
+		Misses:   p.misses.Load(),
+		Stale:    p.stale.Load(),
+	}
+}
+
+// close 停止后台预取。
+func (p *prefetcher) close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
@@ -0,0 +1,290 @@
+package pool
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// ProbeOptions 代理能力探测配置。
+type ProbeOptions struct {
+	Enabled    bool          // 是否启用能力探测
+	Target     string        // CONNECT探测使用的目标主机
+	Ports      []int         // 需要探测的CONNECT端口
+	IPv6Target string        // IPv6出口探测使用的目标地址（host:port）
+	Timeout    time.Duration // 单项探测超时时间
+	TTL        time.Duration // 探测结果有效期
+}
+
+// capabilityProber 上游代理能力探测器。
+//
+// 首次遇到某个代理时在后台探测其能力，并按代理地址缓存结果，
+// 结果过期后在下次遇到该代理时重新探测。
+type capabilityProber struct {
+	opts     ProbeOptions                    // 探测配置
+	results  map[string]*models.Capabilities // 按代理地址缓存的探测结果
+	inflight map[string]bool                 // 正在探测的代理
+	mutex    sync.Mutex                      // 互斥锁
+}
+
+// newCapabilityProber 创建能力探测器。
+func newCapabilityProber(opts ProbeOptions) *capabilityProber {
+	return &capabilityProber{
+		opts:     opts,
+		results:  make(map[string]*models.Capabilities),
+		inflight: make(map[string]bool),
+	}
+}
+
+// enabled 判断是否启用了能力探测。
+func (c *capabilityProber) enabled() bool {
+	return c != nil && c.opts.Enabled
+}
+
+// lookup 返回代理已缓存的能力，未探测或已过期时在后台发起探测。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+//
+// 返回值：
+//   - *models.Capabilities: 探测结果，尚无可用结果时为nil
+func (c *capabilityProber) lookup(proxy models.ProxyInfo) *models.Capabilities {
+	if !c.enabled() {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	caps := c.results[proxy.Host]
+	if caps != nil && time.Since(caps.ProbedAt) < c.opts.TTL {
+		return caps
+	}
+	if !c.inflight[proxy.Host] {
+		c.inflight[proxy.Host] = true
+		go c.probe(proxy)
+	}
+	return caps
+}
+
+// cached 返回代理已缓存的能力，不发起探测。
+//
+// 参数：
+//   - host: 代理地址
+//
+// 返回值：
+//   - *models.Capabilities: 最近一次探测结果，未启用或尚未探测时为nil
+func (c *capabilityProber) cached(host string) *models.Capabilities {
+	if !c.enabled() {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.results[host]
+}
+
+// probe 探测代理的各项能力并缓存结果。
+func (c *capabilityProber) probe(proxy models.ProxyInfo) {
+	caps := &models.Capabilities{ConnectPorts: make(map[int]bool)}
+
+	for _, port := range c.opts.Ports {
+		target := net.JoinHostPort(c.opts.Target, strconv.Itoa(port))
+		caps.ConnectPorts[port] = probeConnect(proxy, target, c.opts.Timeout) == nil
+	}
+	if c.opts.IPv6Target != "" {
+		caps.IPv6 = probeConnect(proxy, c.opts.IPv6Target, c.opts.Timeout) == nil
+	}
+	// 经代理链才能到达的代理无法直接探测端口协议
+	if _, ok := dialer.Lookup(proxy); !ok && len(proxy.Chain) == 0 {
+		caps.TLS = probeTLS(proxy.Host, c.opts.Timeout)
+		caps.SOCKSVersion = probeSOCKS(proxy.Host, c.opts.Timeout)
+	}
+	caps.ProbedAt = time.Now()
+
+	c.mutex.Lock()
+	c.results[proxy.Host] = caps
+	delete(c.inflight, proxy.Host)
+	c.mutex.Unlock()
+
+	log.Printf("代理 %s 能力探测完成: CONNECT端口=%v, SOCKS版本=%d, TLS=%v, IPv6=%v",
+		proxy.Host, caps.ConnectPorts, caps.SOCKSVersion, caps.TLS, caps.IPv6)
+}
+
+// allows 判断代理能力是否满足选择条件。
+//
+// 尚未探测的代理视为满足条件；已探测的代理在目标端口被探测为不允许CONNECT，
+// 或目标为IPv6地址而代理没有IPv6出口时不满足条件。
+//
+// 参数：
+//   - caps: 代理能力，可为nil
+//   - sel: 代理选择条件
+//
+// 返回值：
+//   - bool: 是否满足条件
+func (c *capabilityProber) allows(caps *models.Capabilities, sel Selection) bool {
+	if caps == nil {
+		return true
+	}
+	if allowed, probed := caps.ConnectPorts[sel.DestPort]; probed && !allowed {
+		return false
+	}
+	if ip := net.ParseIP(sel.DestHost); ip != nil && ip.To4() == nil && !caps.IPv6 {
+		return false
+	}
+	return true
+}
+
+// probeConnect 通过代理向目标地址发起CONNECT握手，不传输任何数据。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+//   - target: 目标地址（host:port格式）
+//   - timeout: 超时时间
+//
+// 返回值：
+//   - error: 握手失败的原因，成功时为nil
+func probeConnect(proxy models.ProxyInfo, target string, timeout time.Duration) error {
+	conn, err := connectTunnel(proxy, target, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// connectTunnel 通过代理建立到目标地址的CONNECT隧道。
+//
+// 注册了拨号器的上游协议（如SSH、WireGuard）改由其拨号器连接目标。
+//
+// 参数：
+//   - proxy: 代理服务器信息
+//   - target: 目标地址（host:port格式）
+//   - timeout: 超时时间，同时作为返回连接的读写截止时间
+//
+// 返回值：
+//   - net.Conn: 已建立的隧道连接，由调用方关闭
+//   - error: 握手失败的原因
+func connectTunnel(proxy models.ProxyInfo, target string, timeout time.Duration) (net.Conn, error) {
+	if d, ok := dialer.Lookup(proxy); ok {
+		conn, err := dialer.Dial(d, proxy, target, timeout)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		return conn, nil
+	}
+
+	conn, err := dialProxy(proxy, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
+	if proxy.Username != "" {
+		request += "Proxy-Authorization: " + auth.EncodeBasicAuth(proxy.Username, proxy.Password) + "\r\n"
+	}
+	request += "\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// 目标在客户端发送数据前不会发来任何数据，读取响应后缓冲区中不会残留隧道数据
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s 返回 %s", target, resp.Status)
+	}
+	return conn, nil
+}
+
+// dialProxy 连接到上游代理，配置了代理链时经过代理链，HTTPS代理使用TLS连接。
+func dialProxy(proxy models.ProxyInfo, timeout time.Duration) (net.Conn, error) {
+	if len(proxy.Chain) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err := dialer.DialProxy(ctx, proxy)
+		if err != nil || proxy.URL == nil || proxy.URL.Scheme != "https" {
+			return conn, err
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.URL.Hostname()})
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	netDialer := &net.Dialer{Timeout: timeout}
+	if proxy.URL != nil && proxy.URL.Scheme == "https" {
+		return tls.DialWithDialer(netDialer, "tcp", proxy.Host, &tls.Config{ServerName: proxy.URL.Hostname()})
+	}
+	return netDialer.Dial("tcp", proxy.Host)
+}
+
+// probeTLS 检测代理端口是否接受TLS握手。
+func probeTLS(host string, timeout time.Duration) bool {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// probeSOCKS 检测代理端口支持的SOCKS版本。
+//
+// 先发送SOCKS5问候报文，失败后再尝试SOCKS4a请求。
+//
+// 返回值：
+//   - int: 支持的SOCKS版本（5或4），都不支持时为0
+func probeSOCKS(host string, timeout time.Duration) int {
+	// SOCKS5：版本5，2种认证方式（无需认证、用户名密码）
+	if reply, err := exchange(host, []byte{0x05, 0x02, 0x00, 0x02}, 2, timeout); err == nil && reply[0] == 0x05 {
+		return 5
+	}
+
+	// SOCKS4a：CONNECT 0.0.0.1:80，用户ID为空，域名为example.com
+	request := append([]byte{0x04, 0x01, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00}, []byte("example.com\x00")...)
+	if reply, err := exchange(host, request, 8, timeout); err == nil && reply[0] == 0x00 && reply[1] >= 0x5A && reply[1] <= 0x5D {
+		return 4
+	}
+	return 0
+}
+
+// exchange 建立连接，发送请求并读取固定长度的响应。
+func exchange(host string, request []byte, replyLen int, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, replyLen)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,113 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// queueRetryInterval 排队请求重新尝试选择代理的间隔
+	queueRetryInterval = 500 * time.Millisecond
+)
+
+var (
+	// errNoProxy 暂时没有可用代理：API没有返回代理，或候选代理均不健康、已被移除
+	errNoProxy = errors.New("没有可用的代理")
+
+	// errNoCandidate 合并后的代理列表中没有满足选择条件的代理
+	errNoCandidate = errors.New("代理列表中没有满足条件的代理")
+
+	// ErrQueueFull 等待可用代理的请求数已达上限
+	ErrQueueFull = errors.New("等待可用代理的请求过多")
+)
+
+// retryAfterError 附带重试等待时间估计的错误。
+type retryAfterError struct {
+	err   error         // 原始错误
+	after time.Duration // 估计多久之后重试可能成功
+}
+
+// withRetryAfter 为错误附加重试等待时间估计。
+//
+// 参数：
+//   - err: 原始错误
+//   - after: 估计多久之后重试可能成功，不大于0时不附加
+//
+// 返回值：
+//   - error: 附加了重试等待时间的错误
+func withRetryAfter(err error, after time.Duration) error {
+	if after <= 0 {
+		return err
+	}
+	return &retryAfterError{err: err, after: after}
+}
+
+// Error 返回原始错误信息。
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap 返回原始错误。
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfter 返回估计多久之后重试可能成功。
+func (e *retryAfterError) RetryAfter() time.Duration {
+	return e.after
+}
+
+// selectionQueue 代理池暂时为空时的请求等待队列。
+//
+// 选择代理因暂时没有可用代理而失败时，请求在队列中定期重试，
+// 直到选到代理或等待超时；同时等待的请求数有上限，超出时立即失败。
+type selectionQueue struct {
+	maxWait time.Duration // 最长等待时间
+	maxSize int64         // 同时等待的请求数上限
+	waiting atomic.Int64  // 正在等待的请求数
+}
+
+// newSelectionQueue 创建请求等待队列。
+//
+// 参数：
+//   - maxWait: 最长等待时间，0表示不排队
+//   - maxSize: 同时等待的请求数上限
+//
+// 返回值：
+//   - *selectionQueue: 请求等待队列，不排队时为nil
+func newSelectionQueue(maxWait time.Duration, maxSize int) *selectionQueue {
+	if maxWait <= 0 || maxSize <= 0 {
+		return nil
+	}
+	return &selectionQueue{maxWait: maxWait, maxSize: int64(maxSize)}
+}
+
+// wait 在暂时没有可用代理时排队重试选择代理。
+//
+// 参数：
+//   - err: 首次选择代理的错误
+//   - retry: 重新选择代理的函数
+//
+// 返回值：
+//   - error: 重试后仍然失败的错误；首次失败不是因为暂时没有可用代理时原样返回
+func (q *selectionQueue) wait(err error, retry func() error) error {
+	if q == nil || !errors.Is(err, errNoProxy) {
+		return err
+	}
+	if q.waiting.Add(1) > q.maxSize {
+		q.waiting.Add(-1)
+		return fmt.Errorf("%w: %w", ErrQueueFull, err)
+	}
+	defer q.waiting.Add(-1)
+
+	deadline := time.Now().Add(q.maxWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(min(queueRetryInterval, time.Until(deadline)))
+		if err = retry(); !errors.Is(err, errNoProxy) {
+			return err
+		}
+	}
+	return fmt.Errorf("等待 %v 后仍然%w", q.maxWait, err)
+}
@@ -0,0 +1,105 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// quotaKey 会话配额计数键，由上游代理地址和目标主机组成。
+type quotaKey struct {
+	proxyHost string // 上游代理地址
+	destHost  string // 目标主机
+}
+
+// quotaTracker 会话配额跟踪器。
+//
+// 按（上游代理，目标主机）统计请求次数，当某个代理对同一目标的
+// 使用次数达到上限后，在当前统计窗口内不再为该目标选择此代理，
+// 模拟代理服务商建议的"最大使用次数"策略，降低被目标封禁的风险。
+type quotaTracker struct {
+	maxRequests int              // 每个代理对每个目标的最大请求数，0表示不限制
+	window      time.Duration    // 统计窗口长度，到期后计数清零
+	counts      map[quotaKey]int // 请求计数
+	windowStart time.Time        // 当前窗口开始时间
+	mutex       sync.Mutex       // 互斥锁
+}
+
+// newQuotaTracker 创建会话配额跟踪器。
+//
+// 参数：
+//   - maxRequests: 每个代理对每个目标的最大请求数，0表示不限制
+//   - window: 统计窗口长度，小于等于0时计数永不清零
+//
+// 返回值：
+//   - *quotaTracker: 配额跟踪器实例
+func newQuotaTracker(maxRequests int, window time.Duration) *quotaTracker {
+	return &quotaTracker{
+		maxRequests: maxRequests,
+		window:      window,
+		counts:      make(map[quotaKey]int),
+		windowStart: time.Now(),
+	}
+}
+
+// enabled 判断是否启用了会话配额。
+func (q *quotaTracker) enabled() bool {
+	return q != nil && q.maxRequests > 0
+}
+
+// acquire 尝试为指定代理和目标占用一次配额。
+//
+// 如果该代理对目标的使用次数未达到上限，则计数加一并返回true；
+// 否则返回false，调用方应换用其他代理。
+//
+// 参数：
+//   - proxyHost: 上游代理地址
+//   - destHost: 目标主机
+//
+// 返回值：
+//   - bool: 是否成功占用配额
+func (q *quotaTracker) acquire(proxyHost, destHost string) bool {
+	if !q.enabled() || destHost == "" {
+		return true
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// 窗口到期后清空计数
+	if q.window > 0 && time.Since(q.windowStart) >= q.window {
+		q.counts = make(map[quotaKey]int)
+		q.windowStart = time.Now()
+	}
+
+	key := quotaKey{proxyHost: proxyHost, destHost: destHost}
+	if q.counts[key] >= q.maxRequests {
+		return false
+	}
+	q.counts[key]++
+	return true
+}
+
+// release 归还一次通过 acquire 占用的配额。
+//
+// 用于占用配额后因其他条件（如速率限制）放弃该代理的情况；占用后统计窗口已经重置时不做处理。
+//
+// 参数：
+//   - proxyHost: 上游代理地址
+//   - destHost: 目标主机
+func (q *quotaTracker) release(proxyHost, destHost string) {
+	if !q.enabled() || destHost == "" {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := quotaKey{proxyHost: proxyHost, destHost: destHost}
+	switch q.counts[key] {
+	case 0:
+	case 1:
+		delete(q.counts, key)
+	default:
+		q.counts[key]--
+	}
+}
@@ -0,0 +1,133 @@
+package pool
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestPool 创建使用静态代理列表的测试代理池，测试结束时关闭。
+func newTestPool(t *testing.T, opts Options, proxies ...string) *Pool {
+	t.Helper()
+	opts.List = proxies
+	p, err := NewPool("", opts)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestQuotaTracker(t *testing.T) {
+	type step struct {
+		proxy, dest string
+		want        bool
+	}
+	tests := []struct {
+		name  string
+		max   int
+		steps []step
+	}{
+		{"不限制", 0, []step{{"p1", "a", true}, {"p1", "a", true}, {"p1", "a", true}}},
+		{"达到上限后拒绝", 2, []step{{"p1", "a", true}, {"p1", "a", true}, {"p1", "a", false}}},
+		{"按代理和目标分别计数", 1, []step{{"p1", "a", true}, {"p1", "b", true}, {"p2", "a", true}, {"p1", "a", false}}},
+		{"没有目标时不计数", 1, []step{{"p1", "", true}, {"p1", "", true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQuotaTracker(tt.max, time.Hour)
+			for i, step := range tt.steps {
+				if got := q.acquire(step.proxy, step.dest); got != step.want {
+					t.Errorf("第 %d 次 acquire(%s, %s) = %v，期望 %v", i+1, step.proxy, step.dest, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaTrackerWindowReset(t *testing.T) {
+	q := newQuotaTracker(1, time.Minute)
+	if !q.acquire("p1", "a") {
+		t.Fatal("第一次请求应占用配额")
+	}
+	if q.acquire("p1", "a") {
+		t.Fatal("窗口内第二次请求应被拒绝")
+	}
+	q.windowStart = time.Now().Add(-time.Minute)
+	if !q.acquire("p1", "a") {
+		t.Error("窗口到期后计数应清零")
+	}
+}
+
+func TestQuotaTrackerRelease(t *testing.T) {
+	q := newQuotaTracker(1, time.Hour)
+	q.acquire("p1", "a")
+	q.release("p1", "a")
+	if !q.acquire("p1", "a") {
+		t.Error("归还后应能再次占用配额")
+	}
+	q.release("p2", "a")
+	if len(q.counts) != 1 {
+		t.Errorf("归还未占用的配额不应产生计数: %v", q.counts)
+	}
+}
+
+func TestSelectQuotaBeforeRate(t *testing.T) {
+	p := newTestPool(t, Options{
+		SessionMaxRequests: 1,
+		SessionQuotaWindow: time.Hour,
+		UpstreamRPS:        0.001,
+		UpstreamBurst:      1,
+	}, "http://10.0.0.1:8080")
+
+	if _, err := p.Select(Selection{DestHost: "a.test"}); err != nil {
+		t.Fatalf("第一次选择失败: %v", err)
+	}
+	tokens := p.rate.buckets["10.0.0.1:8080"].tokens
+
+	// 配额已用完，不应再预约令牌
+	_, err := p.Select(Selection{DestHost: "a.test"})
+	if err == nil || !strings.Contains(err.Error(), "会话配额") {
+		t.Errorf("配额用完时应返回配额错误，实际: %v", err)
+	}
+	if got := p.rate.buckets["10.0.0.1:8080"].tokens; got < tokens {
+		t.Errorf("配额被拒绝的选择消耗了令牌: %g -> %g", tokens, got)
+	}
+
+	// 令牌不足时应归还已占用的配额
+	_, err = p.Select(Selection{DestHost: "b.test"})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("令牌不足时应返回速率限制错误，实际: %v", err)
+	}
+	if n := p.quota.counts[quotaKey{proxyHost: "10.0.0.1:8080", destHost: "b.test"}]; n != 0 {
+		t.Errorf("速率限制失败后配额计数 = %d，期望 0", n)
+	}
+}
+
+func TestSelectStickyQuota(t *testing.T) {
+	p := newTestPool(t, Options{
+		SessionMaxRequests: 2,
+		SessionQuotaWindow: time.Hour,
+	}, "http://10.0.0.1:8080", "http://10.0.0.2:8080")
+
+	sel := Selection{DestHost: "a.test", SessionID: "job"}
+	first, err := p.Select(sel)
+	if err != nil {
+		t.Fatalf("第一次选择失败: %v", err)
+	}
+	second, err := p.Select(sel)
+	if err != nil || second.Host != first.Host {
+		t.Fatalf("第二次选择应返回会话绑定的 %s，实际 %s %v", first.Host, second.Host, err)
+	}
+	third, err := p.Select(sel)
+	if err != nil {
+		t.Fatalf("第三次选择失败: %v", err)
+	}
+	if third.Host == first.Host {
+		t.Errorf("绑定代理的配额用完后仍返回 %s", first.Host)
+	}
+	if bound, ok := p.sticky.Peek("job"); !ok || bound.Host != third.Host {
+		t.Errorf("会话应改绑到 %s，实际 %s", third.Host, bound.Host)
+	}
+}
@@ -0,0 +1,113 @@
+package pool
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRateBuckets 最多记录多少个代理的令牌桶，超出时清理已填满的令牌桶
+	maxRateBuckets = 10000
+)
+
+// ErrRateLimited 代理的请求速率已达上限，且等待令牌的时间超过允许的最长等待时间
+var ErrRateLimited = errors.New("代理请求速率已达上限")
+
+// tokenBucket 单个代理的令牌桶。
+type tokenBucket struct {
+	tokens float64   // 当前令牌数，为负数表示已预约的未来令牌
+	last   time.Time // 上次更新令牌数的时间
+}
+
+// rateLimiter 按代理限制发往上游的请求速率。
+//
+// 每个代理一个令牌桶，以固定速率补充令牌，最多积累burst个；
+// 令牌不足的请求预约未来的令牌并排队等待，而不是立即发往上游，
+// 从而把客户端的突发请求平滑为服务商能够接受的速率。
+type rateLimiter struct {
+	rate    float64                 // 每秒补充的令牌数
+	burst   float64                 // 令牌桶容量
+	maxWait time.Duration           // 等待令牌的最长时间
+	buckets map[string]*tokenBucket // 代理地址到令牌桶的映射
+	mutex   sync.Mutex              // 互斥锁
+}
+
+// newRateLimiter 创建按代理的请求速率限制器。
+//
+// 参数：
+//   - rps: 每个代理每秒允许的请求数，0表示不限制
+//   - burst: 允许的突发请求数，小于1时按1处理
+//   - maxWait: 等待令牌的最长时间
+//
+// 返回值：
+//   - *rateLimiter: 速率限制器，不限制时为nil
+func newRateLimiter(rps float64, burst int, maxWait time.Duration) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:    rps,
+		burst:   float64(max(burst, 1)),
+		maxWait: maxWait,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// enabled 判断是否启用了请求速率限制。
+func (r *rateLimiter) enabled() bool {
+	return r != nil
+}
+
+// reserve 为发往代理的一个请求预约令牌。
+//
+// 参数：
+//   - host: 代理地址
+//
+// 返回值：
+//   - time.Duration: 需要等待多久令牌才可用
+//   - bool: 是否预约成功，需要等待的时间超过最长等待时间时为false且不占用令牌
+func (r *rateLimiter) reserve(host string) (time.Duration, bool) {
+	if !r.enabled() {
+		return 0, true
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	bucket, ok := r.buckets[host]
+	if !ok {
+		r.cleanup(now)
+		bucket = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[host] = bucket
+	}
+	bucket.tokens = min(r.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*r.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, true
+	}
+	delay := time.Duration((1 - bucket.tokens) / r.rate * float64(time.Second))
+	if delay > r.maxWait {
+		return delay, false
+	}
+	bucket.tokens--
+	return delay, true
+}
+
+// cleanup 令牌桶数量达到上限时清理已经填满的令牌桶，调用方需持有锁。
+//
+// 参数：
+//   - now: 当前时间
+func (r *rateLimiter) cleanup(now time.Time) {
+	if len(r.buckets) < maxRateBuckets {
+		return
+	}
+	for host, bucket := range r.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*r.rate >= r.burst {
+			delete(r.buckets, host)
+		}
+	}
+}
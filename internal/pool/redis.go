@@ -0,0 +1,408 @@
+package pool
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+const (
+	// redisTimeout Redis连接建立和单条命令的超时时间
+	redisTimeout = 3 * time.Second
+	// redisMaxIdle 保留的空闲Redis连接数
+	redisMaxIdle = 16
+	// redisErrorLogInterval Redis出错时两次日志之间的最短间隔，避免故障期间刷屏
+	redisErrorLogInterval = 10 * time.Second
+)
+
+// redisError Redis返回的错误回复。
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisConn 一条Redis连接。
+type redisConn struct {
+	conn   net.Conn      // 底层连接
+	reader *bufio.Reader // 回复读取缓冲
+}
+
+// redisClient 最小的Redis客户端，只支持会话存储用到的命令。
+//
+// 使用RESP协议逐条发送命令，连接在命令之间复用，出现网络错误的连接直接丢弃。
+type redisClient struct {
+	addr     string          // 服务器地址
+	username string          // ACL用户名，为空时只用密码认证
+	password string          // 认证密码，为空时不认证
+	db       int             // 数据库编号
+	tls      *tls.Config     // rediss:// 使用的TLS配置，明文连接为nil
+	idle     chan *redisConn // 空闲连接
+}
+
+// newRedisClient 按 redis://[user:password@]host:port[/db] 格式的URL创建客户端。
+//
+// rediss:// 表示通过TLS连接。
+//
+// 参数：
+//   - rawURL: Redis URL
+//
+// 返回值：
+//   - *redisClient: Redis客户端
+//   - error: URL无效
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("无效的Redis URL: %v", err)
+	}
+	c := &redisClient{idle: make(chan *redisConn, redisMaxIdle)}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("无效的Redis URL: 不支持的协议 %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("无效的Redis URL: 缺少服务器地址")
+	}
+	c.addr = u.Host
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			// redis://secret@host 形式只给出密码
+			c.username, c.password = "", c.username
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("无效的Redis数据库编号: %s", db)
+		}
+	}
+	return c, nil
+}
+
+// dial 建立新连接，并完成认证和数据库选择。
+func (c *redisClient) dial() (*redisConn, error) {
+	d := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(d, "tcp", c.addr, c.tls)
+	} else {
+		conn, err = d.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	var setup [][]string
+	switch {
+	case c.username != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := rc.do(args); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do 执行一条命令。
+//
+// 复用的空闲连接可能已被服务器关闭，此时换一条新连接重试一次。
+//
+// 参数：
+//   - args: 命令及其参数
+//
+// 返回值：
+//   - any: 回复，类型为 string、int64、[]byte、[]any 或 nil
+//   - error: 网络错误或Redis返回的错误
+func (c *redisClient) do(args ...string) (any, error) {
+	for attempt := 0; ; attempt++ {
+		var conn *redisConn
+		reused := false
+		select {
+		case conn = <-c.idle:
+			reused = true
+		default:
+			var err error
+			if conn, err = c.dial(); err != nil {
+				return nil, err
+			}
+		}
+
+		reply, err := conn.do(args)
+		var replyErr redisError
+		if err != nil && !errors.As(err, &replyErr) {
+			conn.conn.Close()
+			if reused && attempt == 0 {
+				continue
+			}
+			return nil, err
+		}
+		select {
+		case c.idle <- conn:
+		default:
+			conn.conn.Close()
+		}
+		return reply, err
+	}
+}
+
+// close 关闭所有空闲连接。
+func (c *redisClient) close() {
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// do 在当前连接上发送命令并读取回复。
+func (rc *redisConn) do(args []string) (any, error) {
+	rc.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return rc.read()
+}
+
+// read 读取一条RESP回复。
+func (rc *redisConn) read() (any, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("无效的Redis回复")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("无效的Redis回复: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("无效的Redis回复: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = rc.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("无效的Redis回复: %q", line)
+}
+
+// redisSession 会话绑定在Redis中的存储格式。
+//
+// 不保存代理凭据，URL中的用户信息也会去掉，取回后由代理池恢复凭据。
+type redisSession struct {
+	URL    string            `json:"url"`
+	Host   string            `json:"host"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Weight int               `json:"weight,omitempty"`
+}
+
+// redisStore 保存在Redis中的粘性会话存储。
+//
+// 会话绑定在进程重启后仍然有效，并且可以由多个实例共享。会话过期交给Redis的键过期处理，
+// 每次使用会话时刷新过期时间；会话数上限由Redis的内存策略控制。
+// Redis不可用时所有会话视为不存在，请求退化为非粘性选择，恢复后自动继续使用。
+type redisStore struct {
+	client  *redisClient  // Redis客户端
+	prefix  string        // 键前缀
+	ttl     time.Duration // 会话空闲过期时间，0表示不过期
+	lastLog atomic.Int64  // 最近一次记录错误日志的时间（UnixNano）
+}
+
+// NewRedisSessionStore 创建保存在Redis中的粘性会话存储，并检查Redis是否可用。
+//
+// 参数：
+//   - rawURL: Redis URL，格式为 redis://[user:password@]host:port[/db]，rediss:// 表示使用TLS
+//   - prefix: 键前缀，会话ID直接拼接在前缀之后
+//   - ttl: 会话空闲过期时间，小于等于0时会话永不过期
+//
+// 返回值：
+//   - SessionStore: 粘性会话存储
+//   - error: URL无效或无法连接Redis
+func NewRedisSessionStore(rawURL, prefix string, ttl time.Duration) (SessionStore, error) {
+	client, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.do("PING"); err != nil {
+		client.close()
+		return nil, fmt.Errorf("连接Redis失败: %v", err)
+	}
+	return &redisStore{client: client, prefix: prefix, ttl: max(ttl, 0)}, nil
+}
+
+// Get 获取会话当前绑定的代理，并刷新其过期时间。
+//
+// 参数：
+//   - id: 会话ID
+//
+// 返回值：
+//   - models.ProxyInfo: 会话绑定的代理
+//   - bool: 会话是否存在
+func (s *redisStore) Get(id string) (models.ProxyInfo, bool) {
+	proxy, ok := s.Peek(id)
+	if ok && s.ttl > 0 {
+		if _, err := s.client.do("PEXPIRE", s.prefix+id, s.ttlMillis()); err != nil {
+			s.logError(err)
+		}
+	}
+	return proxy, ok
+}
+
+// Peek 获取会话当前绑定的代理，不刷新过期时间。
+//
+// 参数：
+//   - id: 会话ID
+//
+// 返回值：
+//   - models.ProxyInfo: 会话绑定的代理
+//   - bool: 会话是否存在
+func (s *redisStore) Peek(id string) (models.ProxyInfo, bool) {
+	reply, err := s.client.do("GET", s.prefix+id)
+	if err != nil {
+		s.logError(err)
+		return models.ProxyInfo{}, false
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return models.ProxyInfo{}, false
+	}
+	var record redisSession
+	if err := json.Unmarshal(data, &record); err != nil {
+		s.logError(fmt.Errorf("无效的会话记录 %s: %v", id, err))
+		return models.ProxyInfo{}, false
+	}
+	proxyURL, err := url.Parse(record.URL)
+	if err != nil {
+		s.logError(fmt.Errorf("无效的会话记录 %s: %v", id, err))
+		return models.ProxyInfo{}, false
+	}
+	proxyURL.User = nil
+	return models.ProxyInfo{
+		URL:    proxyURL,
+		Host:   record.Host,
+		Tags:   record.Tags,
+		Weight: record.Weight,
+	}, true
+}
+
+// Bind 将会话绑定到指定代理，不保存代理凭据。
+//
+// 参数：
+//   - id: 会话ID
+//   - proxy: 要绑定的代理
+func (s *redisStore) Bind(id string, proxy models.ProxyInfo) {
+	record := redisSession{
+		Host:   proxy.Host,
+		Tags:   proxy.Tags,
+		Weight: proxy.Weight,
+	}
+	if proxy.URL != nil {
+		u := *proxy.URL
+		u.User = nil
+		record.URL = u.String()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		s.logError(err)
+		return
+	}
+	args := []string{"SET", s.prefix + id, string(data)}
+	if s.ttl > 0 {
+		args = append(args, "PX", s.ttlMillis())
+	}
+	if _, err := s.client.do(args...); err != nil {
+		s.logError(err)
+	}
+}
+
+// Remove 解除会话绑定。
+//
+// 参数：
+//   - id: 会话ID
+//
+// 返回值：
+//   - models.ProxyInfo: 解除前绑定的代理
+//   - bool: 会话是否存在
+func (s *redisStore) Remove(id string) (models.ProxyInfo, bool) {
+	proxy, ok := s.Peek(id)
+	if _, err := s.client.do("DEL", s.prefix+id); err != nil {
+		s.logError(err)
+	}
+	return proxy, ok
+}
+
+// ttlMillis 返回以毫秒表示的会话过期时间。
+func (s *redisStore) ttlMillis() string {
+	return strconv.FormatInt(s.ttl.Milliseconds(), 10)
+}
+
+// logError 记录Redis错误，同一存储两次日志之间至少间隔 redisErrorLogInterval。
+func (s *redisStore) logError(err error) {
+	now := time.Now().UnixNano()
+	last := s.lastLog.Load()
+	if now-last < int64(redisErrorLogInterval) || !s.lastLog.CompareAndSwap(last, now) {
+		return
+	}
+	log.Printf("Redis会话存储出错: %v", err)
+}
@@ -0,0 +1,156 @@
+package pool
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/daytime"
+)
+
+const (
+	// DefaultPoolName 由 PROXY_API 创建的主代理池名称
+	DefaultPoolName = "default"
+	// FallbackPoolName 流量预算用尽后使用的备用代理池名称
+	FallbackPoolName = "fallback"
+)
+
+// WeightedPool 带权重的代理池名称。
+type WeightedPool struct {
+	Name   string `json:"name"`   // 代理池名称
+	Weight int    `json:"weight"` // 选择权重
+}
+
+// ScheduleRule 定时规则：每天在指定时间段内按权重选择代理池。
+type ScheduleRule struct {
+	daytime.Window                // 时间段，JSON中展开为start和end
+	Pools          []WeightedPool `json:"pools"` // 时间段内使用的代理池及权重
+}
+
+// Schedule 代理池切换计划。
+//
+// 按本地时间匹配第一条覆盖当前时刻的规则，并按权重随机选择代理池，
+// 例如夜间使用数据中心代理、工作时间使用住宅代理。没有规则匹配时使用主代理池。
+type Schedule struct {
+	rules []ScheduleRule
+}
+
+// ParseSchedule 解析代理池切换计划。
+//
+// 规则之间用分号分隔，每条规则形如 "09:00-18:00 residential:80,datacenter:20"，
+// 省略权重时权重为1。
+//
+// 参数：
+//   - spec: 计划字符串
+//
+// 返回值：
+//   - *Schedule: 切换计划，spec为空时为nil
+//   - error: 格式错误
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	schedule := &Schedule{}
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		window, pools, ok := strings.Cut(item, " ")
+		if !ok {
+			return nil, fmt.Errorf("定时规则缺少代理池: %s", item)
+		}
+		rule := ScheduleRule{}
+		var err error
+		if rule.Window, err = daytime.ParseWindow(window); err != nil {
+			return nil, fmt.Errorf("定时规则无效: %w", err)
+		}
+		for _, entry := range strings.Split(pools, ",") {
+			name, weightText, hasWeight := strings.Cut(strings.TrimSpace(entry), ":")
+			if name == "" {
+				continue
+			}
+			weight := 1
+			if hasWeight {
+				if weight, err = strconv.Atoi(weightText); err != nil || weight <= 0 {
+					return nil, fmt.Errorf("代理池 %s 的权重无效: %s", name, weightText)
+				}
+			}
+			rule.Pools = append(rule.Pools, WeightedPool{Name: name, Weight: weight})
+		}
+		if len(rule.Pools) == 0 {
+			return nil, fmt.Errorf("定时规则缺少代理池: %s", item)
+		}
+		schedule.rules = append(schedule.rules, rule)
+	}
+	return schedule, nil
+}
+
+// Names 返回计划中引用的全部代理池名称。
+func (s *Schedule) Names() []string {
+	if s == nil {
+		return nil
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, rule := range s.rules {
+		for _, p := range rule.Pools {
+			if !seen[p.Name] {
+				seen[p.Name] = true
+				names = append(names, p.Name)
+			}
+		}
+	}
+	return names
+}
+
+// Active 返回覆盖指定时刻的规则。
+//
+// 参数：
+//   - now: 当前时间
+//
+// 返回值：
+//   - *ScheduleRule: 匹配的规则，没有匹配时为nil
+func (s *Schedule) Active(now time.Time) *ScheduleRule {
+	if s == nil {
+		return nil
+	}
+	minute := daytime.Minute(now)
+	for i := range s.rules {
+		if s.rules[i].Contains(minute) {
+			return &s.rules[i]
+		}
+	}
+	return nil
+}
+
+// Pick 按当前时刻的规则和权重选择代理池。
+//
+// 参数：
+//   - now: 当前时间
+//
+// 返回值：
+//   - string: 代理池名称，没有规则匹配时为 DefaultPoolName
+func (s *Schedule) Pick(now time.Time) string {
+	rule := s.Active(now)
+	if rule == nil {
+		return DefaultPoolName
+	}
+
+	total := 0
+	for _, p := range rule.Pools {
+		total += p.Weight
+	}
+	n := rand.IntN(total)
+	for _, p := range rule.Pools {
+		if n < p.Weight {
+			return p.Name
+		}
+		n -= p.Weight
+	}
+	return rule.Pools[len(rule.Pools)-1].Name
+}
@@ -0,0 +1,723 @@
+package pool
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+const (
+	// SourceAPI 代理API来源名称
+	SourceAPI = "api"
+	// SourceFile 静态代理列表文件来源名称
+	SourceFile = "file"
+	// SourceList 环境变量中的静态代理列表来源名称
+	SourceList = "list"
+
+	// maxSourceOwners 最多记录多少个代理所属的来源，超出时清空重新记录
+	maxSourceOwners = 10000
+)
+
+// SourceStats 单个代理来源的统计。
+type SourceStats struct {
+	Name        string    `json:"name"`                   // 来源名称：api、file、list或PROXY_APIS中的名称
+	Weight      int       `json:"weight"`                 // 选择权重
+	Proxies     int       `json:"proxies,omitempty"`      // 列表型来源当前的代理数
+	Duplicates  int       `json:"duplicates,omitempty"`   // 合并时因与其他来源重复而去掉的代理数
+	Fetches     int64     `json:"fetches"`                // 从该来源获取代理的次数
+	FetchErrors int64     `json:"fetch_errors"`           // 获取失败的次数
+	Successes   int64     `json:"successes"`              // 该来源代理的实际流量成功次数
+	Failures    int64     `json:"failures"`               // 该来源代理的实际流量失败次数
+	SuccessRate float64   `json:"success_rate"`           // 实际流量成功率，没有流量时为1
+	Reloads     int64     `json:"reloads,omitempty"`      // 列表内容变化后重新加载的次数
+	ReloadError string    `json:"reload_error,omitempty"` // 最近一次重新加载的错误，成功后清空
+	Refresh     string    `json:"refresh,omitempty"`      // 列表型来源的刷新间隔
+	Path        string    `json:"path,omitempty"`         // 静态列表文件路径
+	Endpoint    string    `json:"endpoint,omitempty"`     // 代理API端点
+	API         *APIStats `json:"api,omitempty"`          // 逐次请求的代理API的统计
+
+	Prefetch *PrefetchStats `json:"prefetch,omitempty"` // 逐次请求的代理API的预取缓冲统计，未启用预取时省略
+}
+
+// proxySource 一个代理来源。
+//
+// 逐次请求的API来源每次获取一个代理；列表型来源（代理列表文件、环境变量中的列表、定期拉取完整列表的API）
+// 提供完整的代理列表，全部列表型来源合并去重后按选择策略选择。
+type proxySource struct {
+	name     string                            // 来源名称
+	kind     string                            // 来源类型：SourceAPI、SourceFile或SourceList
+	weight   int                               // 选择权重
+	location string                            // API端点或文件路径
+	list     *proxyList                        // 代理列表，逐次请求的API来源为nil
+	api      *apiCache                         // 代理API的失败缓存，列表型来源为nil
+	prefetch *prefetcher                       // 代理API的预取缓冲，未启用预取时为nil
+	merged   *mergedList                       // 合并列表对应的选择项才非nil
+	fetch    func() (*models.ProxyInfo, error) // 获取一个代理
+
+	fetches     atomic.Int64 // 获取次数
+	fetchErrors atomic.Int64 // 获取失败次数
+	successes   atomic.Int64 // 实际流量成功次数
+	failures    atomic.Int64 // 实际流量失败次数
+}
+
+// sourceSet 按权重组合的多个代理来源。
+//
+// 每次按权重随机选择一个逐次请求的API来源或合并后的代理列表获取代理，失败时依次尝试其他选择项；
+// 合并列表的权重为各列表型来源的权重之和。记录每个代理来自哪个来源，实际流量的结果按来源分别统计，
+// 便于比较不同来源的质量。
+type sourceSet struct {
+	sources  []*proxySource          // 全部来源，用于统计
+	pickable []*proxySource          // 参与按权重选择的选择项
+	merged   *mergedList             // 列表型来源合并去重后的代理列表，没有列表型来源时为nil
+	total    int                     // 选择项的权重之和
+	owners   map[string]*proxySource // 代理地址到来源的映射
+	mutex    sync.Mutex              // 保护owners
+}
+
+// newSourceSet 创建代理来源组合，合并列表型来源并开始定期刷新。
+//
+// 参数：
+//   - sources: 全部来源
+//   - strategy: 从合并列表中选择代理的策略
+//
+// 返回值：
+//   - *sourceSet: 代理来源组合
+func newSourceSet(sources []*proxySource, strategy Strategy) *sourceSet {
+	set := &sourceSet{sources: sources, owners: make(map[string]*proxySource)}
+	var members []*proxySource
+	for _, source := range sources {
+		if source.list == nil {
+			set.pickable = append(set.pickable, source)
+		} else {
+			members = append(members, source)
+		}
+	}
+	if len(members) > 0 {
+		set.merged = newMergedList(members, strategy)
+		item := &proxySource{name: "merged", merged: set.merged, fetch: set.merged.next}
+		for _, member := range members {
+			item.weight += member.weight
+		}
+		set.pickable = append(set.pickable, item)
+	}
+	for _, source := range set.pickable {
+		set.total += source.weight
+	}
+	for _, member := range members {
+		member.list.start(set.merged.rebuild)
+	}
+	return set
+}
+
+// next 按权重选择来源并获取一个代理。
+//
+// 参数：
+//   - accept: 合并列表的候选过滤条件，为nil时不过滤；逐次请求的API来源不受影响
+//
+// 返回值：
+//   - *models.ProxyInfo: 代理信息
+//   - error: 全部来源都获取失败时返回最后一个错误
+func (s *sourceSet) next(accept func(*models.ProxyInfo) bool) (*models.ProxyInfo, error) {
+	start := 0
+	if len(s.pickable) > 1 && s.total > 0 {
+		pick := rand.IntN(s.total)
+		for i, source := range s.pickable {
+			if pick < source.weight {
+				start = i
+				break
+			}
+			pick -= source.weight
+		}
+	}
+
+	var lastErr error
+	for i := range s.pickable {
+		source := s.pickable[(start+i)%len(s.pickable)]
+		var proxy *models.ProxyInfo
+		var err error
+		if source.merged != nil {
+			proxy, err = source.merged.pick(accept)
+		} else {
+			proxy, err = source.fetch()
+		}
+		if err != nil {
+			source.fetches.Add(1)
+			source.fetchErrors.Add(1)
+			lastErr = err
+			continue
+		}
+		if source.merged != nil {
+			source = source.merged.owner(proxy.Host)
+		}
+		source.fetches.Add(1)
+		s.remember(proxy.Host, source)
+		return proxy, nil
+	}
+	return nil, lastErr
+}
+
+// size 返回可供选择的代理数量。
+//
+// 参数：
+//   - removed: 判断代理是否已被移除，为nil表示没有被移除的代理
+//
+// 返回值：
+//   - int: 合并列表中未被移除的代理数，加上逐次请求的API来源数
+func (s *sourceSet) size(removed func(host string) bool) int {
+	n := 0
+	for _, source := range s.pickable {
+		if source.merged == nil {
+			n++
+		}
+	}
+	if s.merged != nil {
+		proxies := s.merged.view.Load().proxies
+		if removed == nil {
+			return n + len(proxies)
+		}
+		for _, proxy := range proxies {
+			if !removed(proxy.Host) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// remember 记录代理所属的来源。
+func (s *sourceSet) remember(host string, source *proxySource) {
+	if len(s.sources) < 2 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.owners[host]; !ok && len(s.owners) >= maxSourceOwners {
+		s.owners = make(map[string]*proxySource)
+	}
+	s.owners[host] = source
+}
+
+// report 将实际流量的结果计入代理所属来源。
+//
+// 参数：
+//   - host: 代理地址
+//   - err: 流量的错误，成功时为nil
+func (s *sourceSet) report(host string, err error) {
+	source := s.sources[0]
+	if len(s.sources) > 1 {
+		s.mutex.Lock()
+		owner, ok := s.owners[host]
+		s.mutex.Unlock()
+		if !ok {
+			return
+		}
+		source = owner
+	}
+	if err != nil {
+		source.failures.Add(1)
+	} else {
+		source.successes.Add(1)
+	}
+}
+
+// close 停止列表型来源的定期刷新和代理API的预取。
+func (s *sourceSet) close() {
+	for _, source := range s.sources {
+		if source.list != nil {
+			source.list.close()
+		}
+		if source.prefetch != nil {
+			source.prefetch.close()
+		}
+	}
+}
+
+// stats 返回各来源的统计。
+func (s *sourceSet) stats() []SourceStats {
+	result := make([]SourceStats, 0, len(s.sources))
+	for _, source := range s.sources {
+		stats := SourceStats{
+			Name:        source.name,
+			Weight:      source.weight,
+			Proxies:     source.list.len(),
+			Reloads:     source.list.reloadCount(),
+			ReloadError: source.list.reloadError(),
+			Fetches:     source.fetches.Load(),
+			FetchErrors: source.fetchErrors.Load(),
+			Successes:   source.successes.Load(),
+			Failures:    source.failures.Load(),
+			SuccessRate: 1,
+		}
+		if source.list != nil {
+			stats.Duplicates = s.merged.duplicates(source)
+			if source.list.interval > 0 {
+				stats.Refresh = source.list.interval.String()
+			}
+		}
+		switch source.kind {
+		case SourceFile:
+			stats.Path = source.location
+		case SourceAPI:
+			stats.Endpoint = source.location
+			if source.api != nil {
+				stats.API = source.api.stats()
+			}
+			stats.Prefetch = source.prefetch.stats()
+		}
+		if total := stats.Successes + stats.Failures; total > 0 {
+			stats.SuccessRate = float64(stats.Successes) / float64(total)
+		}
+		result = append(result, stats)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Weight > result[j].Weight })
+	return result
+}
+
+// describe 返回来源组合的说明，用于启动日志。
+func (s *sourceSet) describe() string {
+	parts := make([]string, 0, len(s.sources))
+	for _, source := range s.sources {
+		if source.list == nil {
+			parts = append(parts, fmt.Sprintf("API端点 %s（权重 %d）", source.location, source.weight))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s（%d 个代理，权重 %d）", source.list.reader.describe(), source.list.len(), source.weight))
+		}
+	}
+	return strings.Join(parts, "，")
+}
+
+// mergedList 列表型来源合并去重后的代理列表。
+//
+// 任一来源刷新后重新合并：按来源顺序保留每个代理（以去掉标签后的代理URL区分）第一次出现的位置，
+// 代理归属于最先提供它的来源。
+type mergedList struct {
+	members  []*proxySource             // 列表型来源
+	strategy Strategy                   // 选择策略
+	view     atomic.Pointer[mergedView] // 当前合并结果
+	mutex    sync.Mutex                 // 串行化重新合并
+}
+
+// mergedView 一次合并的结果。
+type mergedView struct {
+	proxies    []*models.ProxyInfo     // 去重后的代理
+	owners     map[string]*proxySource // 代理地址到最先提供它的来源
+	duplicates map[*proxySource]int    // 各来源被去掉的重复代理数
+}
+
+// newMergedList 创建合并列表并完成第一次合并。
+func newMergedList(members []*proxySource, strategy Strategy) *mergedList {
+	m := &mergedList{members: members, strategy: strategy}
+	m.rebuild()
+	return m
+}
+
+// rebuild 重新合并各来源当前的代理列表。
+func (m *mergedList) rebuild() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	view := &mergedView{owners: make(map[string]*proxySource), duplicates: make(map[*proxySource]int)}
+	seen := make(map[string]bool)
+	for _, member := range m.members {
+		for _, proxy := range member.list.current() {
+			key := proxy.URL.String()
+			if seen[key] {
+				view.duplicates[member]++
+				continue
+			}
+			seen[key] = true
+			view.proxies = append(view.proxies, proxy)
+			if _, ok := view.owners[proxy.Host]; !ok {
+				view.owners[proxy.Host] = member
+			}
+		}
+	}
+	m.view.Store(view)
+}
+
+// next 按选择策略返回合并列表中的代理。
+func (m *mergedList) next() (*models.ProxyInfo, error) {
+	return m.pick(nil)
+}
+
+// pick 先按条件过滤合并列表，再按选择策略从满足条件的代理中选择一个。
+//
+// 先过滤再选择，不满足条件的代理再多也不会使选择失败，只要列表中还有满足条件的代理。
+//
+// 参数：
+//   - accept: 候选过滤条件，为nil时不过滤
+//
+// 返回值：
+//   - *models.ProxyInfo: 选中代理的副本
+//   - error: 列表为空，或没有满足条件的代理时返回 errNoCandidate
+func (m *mergedList) pick(accept func(*models.ProxyInfo) bool) (*models.ProxyInfo, error) {
+	proxies := m.view.Load().proxies
+	if len(proxies) == 0 {
+		return nil, errors.New("合并后的代理列表为空")
+	}
+	if accept != nil {
+		candidates := make([]*models.ProxyInfo, 0, len(proxies))
+		for _, proxy := range proxies {
+			if accept(proxy) {
+				candidates = append(candidates, proxy)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, errNoCandidate
+		}
+		proxies = candidates
+	}
+	proxy := *proxies[m.strategy.Pick(proxies)]
+	return &proxy, nil
+}
+
+// credentials 返回合并列表中该代理地址的凭据，地址不在列表中或没有凭据时返回false。
+func (m *mergedList) credentials(host string) (Credentials, bool) {
+	for _, proxy := range m.view.Load().proxies {
+		if proxy.Host == host && (proxy.Username != "" || proxy.Password != "") {
+			return Credentials{Username: proxy.Username, Password: proxy.Password}, true
+		}
+	}
+	return Credentials{}, false
+}
+
+// owner 返回最先提供该代理地址的来源，地址已不在列表中时返回第一个来源。
+func (m *mergedList) owner(host string) *proxySource {
+	if owner, ok := m.view.Load().owners[host]; ok {
+		return owner
+	}
+	return m.members[0]
+}
+
+// duplicates 返回来源在合并时被去掉的重复代理数，合并列表为nil时返回0。
+func (m *mergedList) duplicates(source *proxySource) int {
+	if m == nil {
+		return 0
+	}
+	return m.view.Load().duplicates[source]
+}
+
+// listReader 读取列表型来源的完整代理列表。
+type listReader interface {
+	// read 读取并解析完整列表，没有代理时返回错误
+	read() ([]*models.ProxyInfo, error)
+	// changed 判断自上次成功读取后列表是否可能变化
+	changed() bool
+	// describe 返回日志中的来源说明
+	describe() string
+}
+
+// newListSource 创建列表型来源并读取初始列表。
+//
+// 参数：
+//   - name: 来源名称
+//   - kind: 来源类型
+//   - weight: 选择权重
+//   - location: API端点或文件路径
+//   - reader: 列表读取器
+//   - interval: 刷新间隔，0表示不刷新
+//   - required: 初始列表读取失败时是否返回错误，为false时记录错误并以空列表启动，等待下一次刷新
+//
+// 返回值：
+//   - *proxySource: 代理来源
+//   - error: 初始列表读取失败
+func newListSource(name, kind string, weight int, location string, reader listReader, interval time.Duration, required bool) (*proxySource, error) {
+	list := &proxyList{reader: reader, interval: interval, stop: make(chan struct{})}
+	empty := []*models.ProxyInfo{}
+	list.proxies.Store(&empty)
+	if err := list.load(); err != nil {
+		if required || interval <= 0 {
+			return nil, err
+		}
+		list.fail(err)
+	}
+	return &proxySource{name: name, kind: kind, weight: weight, location: location, list: list}, nil
+}
+
+// proxyList 列表型来源的代理列表，刷新时整体替换。
+type proxyList struct {
+	reader   listReader                          // 列表读取器
+	interval time.Duration                       // 刷新间隔，0表示不刷新
+	proxies  atomic.Pointer[[]*models.ProxyInfo] // 当前代理列表
+	reloads  atomic.Int64                        // 内容变化后成功重新加载的次数
+	stop     chan struct{}                       // 关闭时停止刷新
+
+	lastErr  atomic.Pointer[string] // 最近一次重新加载的错误信息，成功后清空
+	stopOnce sync.Once              // 保证只关闭一次
+}
+
+// current 返回当前代理列表。
+func (l *proxyList) current() []*models.ProxyInfo {
+	return *l.proxies.Load()
+}
+
+// len 返回列表中的代理数，列表为nil时返回0。
+func (l *proxyList) len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.current())
+}
+
+// load 读取并替换代理列表。
+//
+// 返回值：
+//   - error: 读取失败、格式错误或没有代理，此时保留原列表
+func (l *proxyList) load() error {
+	_, err := l.reload()
+	return err
+}
+
+// reload 读取列表，内容变化时替换。
+//
+// 返回值：
+//   - bool: 列表内容是否发生变化
+//   - error: 读取失败、格式错误或没有代理，此时保留原列表
+func (l *proxyList) reload() (bool, error) {
+	proxies, err := l.reader.read()
+	if err != nil {
+		return false, err
+	}
+	if sameProxies(l.current(), proxies) {
+		return false, nil
+	}
+	l.proxies.Store(&proxies)
+	return true, nil
+}
+
+// sameProxies 判断两个代理列表是否相同（按顺序比较代理URL和标签）。
+func sameProxies(a, b []*models.ProxyInfo) bool {
+	return slices.EqualFunc(a, b, func(x, y *models.ProxyInfo) bool {
+		return x.URL.String() == y.URL.String() && FormatTags(x.Tags) == FormatTags(y.Tags) && x.Weight == y.Weight
+	})
+}
+
+// start 开始定期刷新，刷新间隔为0时不刷新。
+//
+// 参数：
+//   - onChange: 列表内容变化后调用
+func (l *proxyList) start(onChange func()) {
+	if l.interval > 0 {
+		go l.watch(onChange)
+	}
+}
+
+// watch 定期检查列表是否变化，变化时重新加载。
+//
+// 读取失败过的列表也会在下一次检查时重试，
+// 避免编辑器分多次写入时读到不完整的文件后不再更新。
+func (l *proxyList) watch(onChange func()) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !l.reader.changed() && l.lastErr.Load() == nil {
+			continue
+		}
+		previous := l.len()
+		changed, err := l.reload()
+		if err != nil {
+			l.fail(err)
+			continue
+		}
+		l.lastErr.Store(nil)
+		if !changed {
+			continue
+		}
+		l.reloads.Add(1)
+		onChange()
+		log.Printf("%s 已重新加载: %d 个代理（原 %d 个）", l.reader.describe(), l.len(), previous)
+	}
+}
+
+// fail 记录重新加载失败，同一错误只输出一次日志。
+func (l *proxyList) fail(err error) {
+	if last := l.lastErr.Load(); last != nil && *last == err.Error() {
+		return
+	}
+	message := err.Error()
+	l.lastErr.Store(&message)
+	log.Printf("重新加载%s失败，继续使用原列表: %v", l.reader.describe(), err)
+}
+
+// reloadCount 返回重新加载的次数，列表为nil时返回0。
+func (l *proxyList) reloadCount() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.reloads.Load()
+}
+
+// reloadError 返回最近一次重新加载的错误信息，没有错误时为空。
+func (l *proxyList) reloadError() string {
+	if l == nil {
+		return ""
+	}
+	if last := l.lastErr.Load(); last != nil {
+		return *last
+	}
+	return ""
+}
+
+// close 停止刷新。
+func (l *proxyList) close() {
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+// parseProxyLines 逐行解析代理列表，空行和以#开头的行被忽略。
+//
+// 参数：
+//   - r: 列表内容
+//   - what: 错误信息中的列表说明
+//   - parse: 代理URL解析函数
+//
+// 返回值：
+//   - []*models.ProxyInfo: 解析出的代理
+//   - error: 读取失败、有无效的行或没有代理
+func parseProxyLines(r io.Reader, what string, parse func(string) (*models.ProxyInfo, error)) ([]*models.ProxyInfo, error) {
+	var proxies []*models.ProxyInfo
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		proxy, err := parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s第 %d 行: %w", what, line, err)
+		}
+		proxies = append(proxies, proxy)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取%s失败: %v", what, err)
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("%s中没有代理", what)
+	}
+	return proxies, nil
+}
+
+// fileReader 从静态代理列表文件读取代理，按修改时间和大小判断文件是否变化。
+//
+// 配置了密钥时文件必须是 EncryptList 加密的，读取后在内存中解密，明文不落盘。
+type fileReader struct {
+	path  string                                  // 文件路径
+	key   []byte                                  // 解密文件的密钥，nil表示文件为明文
+	parse func(string) (*models.ProxyInfo, error) // 代理URL解析函数
+
+	modTime  time.Time // 最近一次成功读取时文件的修改时间，只由读取方访问
+	fileSize int64     // 最近一次成功读取时文件的大小，只由读取方访问
+}
+
+// read 读取文件中的代理。
+func (f *fileReader) read() ([]*models.ProxyInfo, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("读取代理列表文件失败: %v", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("读取代理列表文件失败: %v", err)
+	}
+	switch {
+	case f.key != nil:
+		if data, err = DecryptList(data, f.key); err != nil {
+			return nil, fmt.Errorf("%v: %s", err, f.path)
+		}
+	case isEncryptedList(data):
+		return nil, fmt.Errorf("代理列表文件已加密，需要配置 PROXY_FILE_KEY 或 PROXY_FILE_KEY_FILE: %s", f.path)
+	}
+
+	proxies, err := parseProxyLines(bytes.NewReader(data), "代理列表文件", f.parse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, f.path)
+	}
+	f.modTime, f.fileSize = info.ModTime(), info.Size()
+	return proxies, nil
+}
+
+// changed 文件的修改时间或大小变化、或者无法读取文件信息时返回true。
+func (f *fileReader) changed() bool {
+	info, err := os.Stat(f.path)
+	return err != nil || !info.ModTime().Equal(f.modTime) || info.Size() != f.fileSize
+}
+
+// describe 返回文件说明。
+func (f *fileReader) describe() string {
+	if f.key != nil {
+		return "加密的代理列表文件 " + f.path
+	}
+	return "代理列表文件 " + f.path
+}
+
+// apiListReader 定期从代理API拉取完整的代理列表，响应每行一个代理URL。
+type apiListReader struct {
+	url    string                                  // API端点
+	client *http.Client                            // HTTP客户端
+	parse  func(string) (*models.ProxyInfo, error) // 代理URL解析函数
+}
+
+// read 请求API并解析响应中的代理。
+func (a *apiListReader) read() ([]*models.ProxyInfo, error) {
+	resp, err := a.client.Get(a.url)
+	if err != nil {
+		return nil, fmt.Errorf("API请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API返回错误状态码: %d", resp.StatusCode)
+	}
+	return parseProxyLines(resp.Body, "API响应", a.parse)
+}
+
+// changed API的列表无法预先判断是否变化，总是返回true。
+func (a *apiListReader) changed() bool {
+	return true
+}
+
+// describe 返回API说明。
+func (a *apiListReader) describe() string {
+	return "代理API列表 " + a.url
+}
+
+// staticReader 配置中给出的固定代理列表，不会变化。
+type staticReader struct {
+	entries []string                                // 代理URL
+	parse   func(string) (*models.ProxyInfo, error) // 代理URL解析函数
+}
+
+// read 解析配置中的代理。
+func (s *staticReader) read() ([]*models.ProxyInfo, error) {
+	return parseProxyLines(strings.NewReader(strings.Join(s.entries, "\n")), "PROXY_LIST", s.parse)
+}
+
+// changed 固定列表不会变化。
+func (s *staticReader) changed() bool {
+	return false
+}
+
+// describe 返回列表说明。
+func (s *staticReader) describe() string {
+	return "PROXY_LIST"
+}
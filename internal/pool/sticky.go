@@ -0,0 +1,207 @@
+package pool
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// SessionStore 粘性会话存储。
+//
+// 维护会话ID到上游代理的绑定关系，同一会话的后续连接复用同一个代理，
+// 从而保持出口IP不变。实现需要自行处理会话过期和容量上限，并且可以被并发调用。
+type SessionStore interface {
+	// Get 获取会话当前绑定的代理并刷新其使用时间，会话不存在或已过期时返回false
+	Get(id string) (models.ProxyInfo, bool)
+	// Peek 获取会话当前绑定的代理，不刷新使用时间
+	Peek(id string) (models.ProxyInfo, bool)
+	// Bind 将会话绑定到指定代理，覆盖原有绑定
+	Bind(id string, proxy models.ProxyInfo)
+	// Remove 解除会话绑定，返回解除前绑定的代理
+	Remove(id string) (models.ProxyInfo, bool)
+}
+
+// stickyEntry 粘性会话绑定记录。
+type stickyEntry struct {
+	id       string           // 会话ID
+	proxy    models.ProxyInfo // 会话绑定的上游代理
+	lastUsed time.Time        // 最近一次使用时间
+}
+
+// memoryStore 进程内的粘性会话存储。
+//
+// 会话按最近使用时间排列在链表中，表头最新、表尾最久未使用，通过map按会话ID定位链表元素。
+// 会话在超过TTL未被使用后失效：Get时发现过期即删除，后台定期从表尾清理；
+// 会话数达到上限时，绑定新会话会淘汰表尾的会话。
+type memoryStore struct {
+	ttl         time.Duration            // 会话空闲过期时间
+	maxSessions int                      // 会话数上限，0表示不限制
+	sessions    map[string]*list.Element // 会话ID到链表元素的索引，元素值为*stickyEntry
+	order       *list.List               // 按最近使用时间排列的会话
+	stop        chan struct{}            // 停止后台清理的信号
+	once        sync.Once                // 保证只关闭一次
+	mutex       sync.Mutex               // 互斥锁
+}
+
+// NewMemorySessionStore 创建进程内的粘性会话存储。
+//
+// ttl大于0时启动后台任务定期清理过期会话，由代理池的 Close 停止。
+//
+// 参数：
+//   - ttl: 会话空闲过期时间，小于等于0时会话永不过期
+//   - maxSessions: 会话数上限，小于等于0时不限制
+//
+// 返回值：
+//   - SessionStore: 粘性会话存储
+func NewMemorySessionStore(ttl time.Duration, maxSessions int) SessionStore {
+	s := &memoryStore{
+		ttl:         ttl,
+		maxSessions: max(maxSessions, 0),
+		sessions:    make(map[string]*list.Element),
+		order:       list.New(),
+		stop:        make(chan struct{}),
+	}
+	if ttl > 0 {
+		go s.sweep(max(ttl, time.Second))
+	}
+	return s
+}
+
+// Get 获取会话当前绑定的代理，并刷新其使用时间。
+//
+// 参数：
+//   - id: 会话ID
+//
+// 返回值：
+//   - models.ProxyInfo: 会话绑定的代理
+//   - bool: 会话是否存在且未过期
+func (s *memoryStore) Get(id string) (models.ProxyInfo, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elem, ok := s.sessions[id]
+	if !ok {
+		return models.ProxyInfo{}, false
+	}
+	entry := elem.Value.(*stickyEntry)
+	now := time.Now()
+	if s.expired(entry, now) {
+		s.remove(elem)
+		return models.ProxyInfo{}, false
+	}
+	entry.lastUsed = now
+	s.order.MoveToFront(elem)
+	return entry.proxy, true
+}
+
+// Peek 获取会话当前绑定的代理，不刷新使用时间。
+//
+// 参数：
+//   - id: 会话ID
+//
+// 返回值：
+//   - models.ProxyInfo: 会话绑定的代理
+//   - bool: 会话是否存在且未过期
+func (s *memoryStore) Peek(id string) (models.ProxyInfo, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elem, ok := s.sessions[id]
+	if !ok || s.expired(elem.Value.(*stickyEntry), time.Now()) {
+		return models.ProxyInfo{}, false
+	}
+	return elem.Value.(*stickyEntry).proxy, true
+}
+
+// Bind 将会话绑定到指定代理，并将其移到表头。
+//
+// 绑定新会话时若会话数已达到上限，淘汰表尾最久未使用的会话。
+//
+// 参数：
+//   - id: 会话ID
+//   - proxy: 要绑定的代理
+func (s *memoryStore) Bind(id string, proxy models.ProxyInfo) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if elem, ok := s.sessions[id]; ok {
+		entry := elem.Value.(*stickyEntry)
+		entry.proxy, entry.lastUsed = proxy, now
+		s.order.MoveToFront(elem)
+		return
+	}
+	if s.maxSessions > 0 && len(s.sessions) >= s.maxSessions {
+		s.remove(s.order.Back())
+	}
+	s.sessions[id] = s.order.PushFront(&stickyEntry{id: id, proxy: proxy, lastUsed: now})
+}
+
+// Remove 解除会话绑定。
+//
+// 参数：
+//   - id: 会话ID
+//
+// 返回值：
+//   - models.ProxyInfo: 解除前绑定的代理
+//   - bool: 会话是否存在
+func (s *memoryStore) Remove(id string) (models.ProxyInfo, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elem, ok := s.sessions[id]
+	if !ok {
+		return models.ProxyInfo{}, false
+	}
+	s.remove(elem)
+	return elem.Value.(*stickyEntry).proxy, true
+}
+
+// close 停止后台清理。
+func (s *memoryStore) close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// sweep 定期从表尾清理过期会话，直到停止。
+//
+// 参数：
+//   - interval: 清理间隔
+func (s *memoryStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.expire(now)
+		}
+	}
+}
+
+// expire 从表尾开始删除过期会话，遇到未过期的会话即停止。
+//
+// 参数：
+//   - now: 当前时间
+func (s *memoryStore) expire(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for elem := s.order.Back(); elem != nil && s.expired(elem.Value.(*stickyEntry), now); elem = s.order.Back() {
+		s.remove(elem)
+	}
+}
+
+// remove 删除链表元素对应的会话，调用方需持有锁。
+func (s *memoryStore) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.sessions, elem.Value.(*stickyEntry).id)
+}
+
+// expired 判断会话是否已过期，调用方需持有锁。
+func (s *memoryStore) expired(entry *stickyEntry, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(entry.lastUsed) > s.ttl
+}
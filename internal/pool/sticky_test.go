@@ -0,0 +1,109 @@
+package pool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// newTestStore 创建测试用的进程内会话存储，测试结束时停止后台清理。
+func newTestStore(t *testing.T, ttl time.Duration, maxSessions int) *memoryStore {
+	t.Helper()
+	s := NewMemorySessionStore(ttl, maxSessions).(*memoryStore)
+	t.Cleanup(s.close)
+	return s
+}
+
+// age 将会话的最近使用时间提前，模拟会话空闲了一段时间。
+func age(t *testing.T, s *memoryStore, id string, d time.Duration) {
+	t.Helper()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	elem, ok := s.sessions[id]
+	if !ok {
+		t.Fatalf("会话 %s 不存在", id)
+	}
+	elem.Value.(*stickyEntry).lastUsed = time.Now().Add(-d)
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		touch   string   // 绑定a、b后刷新的会话，为空表示不刷新
+		evicted string   // 绑定c后被淘汰的会话
+		kept    []string // 绑定c后仍然存在的会话
+	}{
+		{"淘汰最早绑定的会话", "", "a", []string{"b", "c"}},
+		{"Get刷新后淘汰另一个", "a", "b", []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, time.Hour, 2)
+			s.Bind("a", models.ProxyInfo{Host: "1.1.1.1:80"})
+			s.Bind("b", models.ProxyInfo{Host: "2.2.2.2:80"})
+			if tt.touch != "" {
+				if _, ok := s.Get(tt.touch); !ok {
+					t.Fatalf("会话 %s 应存在", tt.touch)
+				}
+			}
+			s.Bind("c", models.ProxyInfo{Host: "3.3.3.3:80"})
+
+			if _, ok := s.Peek(tt.evicted); ok {
+				t.Errorf("会话 %s 应已被淘汰", tt.evicted)
+			}
+			for _, id := range tt.kept {
+				if _, ok := s.Peek(id); !ok {
+					t.Errorf("会话 %s 应仍然存在", id)
+				}
+			}
+			if len(s.sessions) != 2 || s.order.Len() != 2 {
+				t.Errorf("会话数 = %d/%d，期望 2", len(s.sessions), s.order.Len())
+			}
+		})
+	}
+}
+
+func TestMemoryStoreRebindKeepsSize(t *testing.T) {
+	s := newTestStore(t, time.Hour, 2)
+	s.Bind("a", models.ProxyInfo{Host: "1.1.1.1:80"})
+	s.Bind("b", models.ProxyInfo{Host: "2.2.2.2:80"})
+	s.Bind("a", models.ProxyInfo{Host: "9.9.9.9:80"})
+
+	if proxy, ok := s.Get("a"); !ok || proxy.Host != "9.9.9.9:80" {
+		t.Errorf("Get(a) = %v %v，期望重新绑定后的代理", proxy.Host, ok)
+	}
+	if _, ok := s.Peek("b"); !ok {
+		t.Error("重新绑定已有会话不应淘汰其他会话")
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	s := newTestStore(t, time.Minute, 0)
+	s.Bind("old", models.ProxyInfo{Host: "1.1.1.1:80"})
+	s.Bind("stale", models.ProxyInfo{Host: "2.2.2.2:80"})
+	s.Bind("fresh", models.ProxyInfo{Host: "3.3.3.3:80"})
+	age(t, s, "old", 2*time.Minute)
+	age(t, s, "stale", 2*time.Minute)
+
+	if _, ok := s.Peek("old"); ok {
+		t.Error("Peek 不应返回已过期的会话")
+	}
+	if _, ok := s.Get("old"); ok {
+		t.Error("Get 不应返回已过期的会话")
+	}
+	if _, ok := s.sessions["old"]; ok {
+		t.Error("Get 应删除已过期的会话")
+	}
+
+	s.expire(time.Now())
+	if _, ok := s.sessions["stale"]; ok {
+		t.Error("定期清理应删除已过期的会话")
+	}
+	if _, ok := s.Get("fresh"); !ok {
+		t.Error("未过期的会话不应被清理")
+	}
+	if s.order.Len() != len(s.sessions) {
+		t.Errorf("链表长度 %d 与索引大小 %d 不一致", s.order.Len(), len(s.sessions))
+	}
+}
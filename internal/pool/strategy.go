@@ -0,0 +1,234 @@
+package pool
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// 代理选择策略名称。
+const (
+	// StrategyRoundRobin 按顺序轮流选择
+	StrategyRoundRobin = "round-robin"
+	// StrategyRandom 随机选择
+	StrategyRandom = "random"
+	// StrategyLeastConnections 优先选择进行中的连接和请求较少的代理
+	StrategyLeastConnections = "least-connections"
+	// StrategyWeighted 按代理列表中声明的权重随机选择
+	StrategyWeighted = "weighted"
+	// StrategyEWMA 优先选择平均延迟（指数加权移动平均）较低且负载较轻的代理
+	StrategyEWMA = "ewma"
+)
+
+const (
+	// ewmaDecay 延迟指数加权移动平均中新样本的权重
+	ewmaDecay = 0.3
+	// maxLoadEntries 最多记录多少个代理的负载，超出时清理没有进行中连接的记录
+	maxLoadEntries = 10000
+)
+
+// Strategy 从静态代理列表中选择代理的策略。
+type Strategy interface {
+	// Pick 从非空的候选代理中选出一个，返回其下标。会被并发调用。
+	Pick(proxies []*models.ProxyInfo) int
+}
+
+// newStrategy 按名称创建选择策略。
+//
+// 参数：
+//   - name: 策略名称，为空时使用 round-robin
+//   - load: 代理负载记录，least-connections 和 ewma 策略依据它选择
+//
+// 返回值：
+//   - Strategy: 选择策略
+//   - error: 策略名称未知
+func newStrategy(name string, load *loadTracker) (Strategy, error) {
+	switch name {
+	case "", StrategyRoundRobin:
+		return &roundRobin{}, nil
+	case StrategyRandom:
+		return randomStrategy{}, nil
+	case StrategyLeastConnections:
+		return &twoChoices{score: load.connections}, nil
+	case StrategyWeighted:
+		return weightedStrategy{}, nil
+	case StrategyEWMA:
+		return &twoChoices{score: load.latencyScore}, nil
+	default:
+		return nil, fmt.Errorf("未知的代理选择策略: %s（可选 %s、%s、%s、%s、%s）", name,
+			StrategyRoundRobin, StrategyRandom, StrategyLeastConnections, StrategyWeighted, StrategyEWMA)
+	}
+}
+
+// needsLoad 判断策略是否需要记录代理负载。
+func needsLoad(name string) bool {
+	return name == StrategyLeastConnections || name == StrategyEWMA
+}
+
+// roundRobin 按顺序轮流选择。
+type roundRobin struct {
+	cursor atomic.Uint64 // 轮流选择的位置
+}
+
+// Pick 返回下一个位置的代理。
+func (r *roundRobin) Pick(proxies []*models.ProxyInfo) int {
+	return int((r.cursor.Add(1) - 1) % uint64(len(proxies)))
+}
+
+// randomStrategy 随机选择。
+type randomStrategy struct{}
+
+// Pick 随机返回一个代理。
+func (randomStrategy) Pick(proxies []*models.ProxyInfo) int {
+	return rand.IntN(len(proxies))
+}
+
+// weightedStrategy 按代理的权重随机选择，未声明权重的代理权重为1。
+type weightedStrategy struct{}
+
+// Pick 按权重随机返回一个代理。
+func (weightedStrategy) Pick(proxies []*models.ProxyInfo) int {
+	total := 0
+	for _, proxy := range proxies {
+		total += proxyWeight(proxy)
+	}
+	pick := rand.IntN(total)
+	for i, proxy := range proxies {
+		if pick < proxyWeight(proxy) {
+			return i
+		}
+		pick -= proxyWeight(proxy)
+	}
+	return len(proxies) - 1
+}
+
+// proxyWeight 返回代理的选择权重，未声明时为1。
+func proxyWeight(proxy *models.ProxyInfo) int {
+	return max(proxy.Weight, 1)
+}
+
+// twoChoices 随机取两个代理并选择得分较低的一个（power of two choices）。
+//
+// 与总是选择全局最优的代理相比，避免大量请求同时涌向同一个代理，
+// 选中的代理不满足选择条件而重新选择时也能得到不同的结果。
+type twoChoices struct {
+	score func(host string) float64 // 代理得分，越低越优先
+}
+
+// Pick 返回两个随机候选中得分较低的代理。
+func (t *twoChoices) Pick(proxies []*models.ProxyInfo) int {
+	if len(proxies) == 1 {
+		return 0
+	}
+	a := rand.IntN(len(proxies))
+	b := rand.IntN(len(proxies) - 1)
+	if b >= a {
+		b++
+	}
+	if t.score(proxies[b].Host) < t.score(proxies[a].Host) {
+		return b
+	}
+	return a
+}
+
+// proxyLoad 单个代理的负载。
+type proxyLoad struct {
+	active  int64   // 进行中的连接和请求数
+	latency float64 // 延迟的指数加权移动平均（纳秒），0表示还没有样本
+}
+
+// loadTracker 按代理记录进行中的连接数和延迟，供 least-connections 和 ewma 策略使用。
+//
+// 未使用这两种策略时不创建，observe 在为nil时不做任何事。
+type loadTracker struct {
+	entries map[string]*proxyLoad // 代理地址到负载的映射
+	mutex   sync.Mutex            // 互斥锁
+}
+
+// newLoadTracker 创建代理负载记录。
+func newLoadTracker() *loadTracker {
+	return &loadTracker{entries: make(map[string]*proxyLoad)}
+}
+
+// entry 返回代理的负载记录，不存在时创建，调用方需持有锁。
+func (l *loadTracker) entry(host string) *proxyLoad {
+	entry, ok := l.entries[host]
+	if ok {
+		return entry
+	}
+	if len(l.entries) >= maxLoadEntries {
+		for key, e := range l.entries {
+			if e.active == 0 {
+				delete(l.entries, key)
+			}
+		}
+	}
+	entry = &proxyLoad{}
+	l.entries[host] = entry
+	return entry
+}
+
+// acquire 登记一个经由代理的连接或请求。
+//
+// 返回值：
+//   - func(): 连接或请求结束时调用的释放函数，可重复调用
+func (l *loadTracker) acquire(host string) func() {
+	l.mutex.Lock()
+	l.entry(host).active++
+	l.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			if entry, ok := l.entries[host]; ok && entry.active > 0 {
+				entry.active--
+			}
+		})
+	}
+}
+
+// observe 将一次延迟样本计入代理的指数加权移动平均。
+func (l *loadTracker) observe(host string, latency time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry := l.entry(host)
+	if entry.latency == 0 {
+		entry.latency = float64(latency)
+		return
+	}
+	entry.latency = ewmaDecay*float64(latency) + (1-ewmaDecay)*entry.latency
+}
+
+// connections 返回代理进行中的连接和请求数。
+func (l *loadTracker) connections(host string) float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if entry, ok := l.entries[host]; ok {
+		return float64(entry.active)
+	}
+	return 0
+}
+
+// latencyScore 返回代理的延迟得分：平均延迟乘以进行中的连接数加一。
+//
+// 还没有延迟样本的代理得分为0，会被优先选中以获得样本。
+func (l *loadTracker) latencyScore(host string) float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if entry, ok := l.entries[host]; ok {
+		return entry.latency * float64(entry.active+1)
+	}
+	return 0
+}
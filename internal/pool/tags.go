@@ -0,0 +1,82 @@
+package pool
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseTags 解析标签字符串。
+//
+// 标签格式为逗号分隔的 key=value 列表，例如 "isp=comcast,type=mobile"。
+// 键统一转换为小写，值保持原样；没有等号的项视为值为空的标签。
+//
+// 参数：
+//   - raw: 标签字符串
+//
+// 返回值：
+//   - map[string]string: 解析后的标签，输入为空时返回nil
+func ParseTags(raw string) map[string]string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+
+	tags := make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(item, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		tags[key] = strings.TrimSpace(value)
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// FormatTags 将标签格式化为稳定排序的字符串，用于日志和错误信息。
+//
+// 参数：
+//   - tags: 标签集合
+//
+// 返回值：
+//   - string: 形如 "isp=comcast,type=mobile" 的字符串
+func FormatTags(tags map[string]string) string {
+	items := make([]string, 0, len(tags))
+	for key, value := range tags {
+		items = append(items, key+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// matchTags 判断代理标签是否满足要求。
+//
+// 要求中的每个标签都必须在代理标签中存在且值相同（不区分大小写）；
+// 要求值为空时只检查键是否存在。
+//
+// 参数：
+//   - have: 代理拥有的标签
+//   - want: 请求要求的标签
+//
+// 返回值：
+//   - bool: 是否匹配
+func matchTags(have, want map[string]string) bool {
+	for key, value := range want {
+		got, ok := have[key]
+		if !ok {
+			return false
+		}
+		if value != "" && !strings.EqualFold(got, value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+// Package profile 提供出站请求头画像功能。
+//
+// 请求头画像是一组可轮换的 User-Agent、Accept-Language 等请求头，
+// 按目标主机匹配后应用到经由HTTP路径转发的请求上，使请求指纹
+// 随代理轮换一起变化。画像从JSON文件加载。
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+)
+
+// Off 请求中指定该画像名称时不应用任何画像。
+const Off = "off"
+
+// Profile 单个请求头画像。
+type Profile struct {
+	Name            string            `json:"name"`             // 画像名称
+	Destinations    []string          `json:"destinations"`     // 适用的目标主机模式（见 hostmatch 包），为空表示仅按名称选择
+	UserAgents      []string          `json:"user_agents"`      // 轮换的User-Agent列表
+	AcceptLanguages []string          `json:"accept_languages"` // 轮换的Accept-Language列表
+	Headers         map[string]string `json:"headers"`          // 固定附加的请求头
+
+	destinations hostmatch.List // 编译后的目标主机模式
+}
+
+// Set 请求头画像集合，按文件中的顺序匹配。
+type Set struct {
+	profiles []*Profile
+}
+
+// Load 从JSON文件加载请求头画像。
+//
+// 文件格式为 {"profiles": [...]}，每个画像至少需要名称。
+//
+// 参数：
+//   - path: 画像文件路径
+//
+// 返回值：
+//   - *Set: 画像集合
+//   - error: 读取或解析错误
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取请求头画像文件失败: %v", err)
+	}
+
+	var file struct {
+		Profiles []*Profile `json:"profiles"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析请求头画像文件失败: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, p := range file.Profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("第 %d 个请求头画像缺少名称", i+1)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("请求头画像名称重复: %s", p.Name)
+		}
+		seen[p.Name] = true
+		if p.destinations, err = hostmatch.CompileList(p.Destinations); err != nil {
+			return nil, fmt.Errorf("请求头画像 %s: %v", p.Name, err)
+		}
+	}
+	return &Set{profiles: file.Profiles}, nil
+}
+
+// Len 返回画像数量，集合为nil时返回0。
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.profiles)
+}
+
+// Select 为请求选择画像。
+//
+// 请求显式指定画像名称时按名称选择，名称为 Off 时不应用画像；
+// 否则返回第一个目标主机匹配的画像。
+//
+// 参数：
+//   - host: 目标主机名
+//   - name: 请求指定的画像名称，可为空
+//
+// 返回值：
+//   - *Profile: 选中的画像，没有匹配时为nil
+func (s *Set) Select(host, name string) *Profile {
+	if s == nil || strings.EqualFold(name, Off) {
+		return nil
+	}
+	if name != "" {
+		for _, p := range s.profiles {
+			if p.Name == name {
+				return p
+			}
+		}
+		return nil
+	}
+
+	host = hostmatch.Normalize(host)
+	for _, p := range s.profiles {
+		if p.destinations.Match(host) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Apply 将画像应用到请求头，覆盖客户端发送的同名请求头。
+//
+// User-Agent和Accept-Language每次随机选取，固定请求头原样设置。
+//
+// 参数：
+//   - header: 出站请求头
+func (p *Profile) Apply(header http.Header) {
+	if p == nil {
+		return
+	}
+	if len(p.UserAgents) > 0 {
+		header.Set("User-Agent", p.UserAgents[rand.IntN(len(p.UserAgents))])
+	}
+	if len(p.AcceptLanguages) > 0 {
+		header.Set("Accept-Language", p.AcceptLanguages[rand.IntN(len(p.AcceptLanguages))])
+	}
+	for key, value := range p.Headers {
+		header.Set(key, value)
+	}
+}
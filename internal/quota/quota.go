@@ -0,0 +1,264 @@
+// Package quota 提供按目标主机的每日请求上限。
+//
+// 爬虫团队需要对每个目标站点遵守礼貌的抓取预算。本包按UTC自然日统计
+// 命中各目标模式的请求数，达到上限后拒绝当天的后续请求，直到次日零点(UTC)重置。
+// 上限可以由全部用户共享，也可以按认证用户分别计数。
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+)
+
+// 上限的计数范围
+const (
+	ScopeGlobal = "global" // 全部用户共享一个计数
+	ScopeUser   = "user"   // 每个认证用户分别计数，匿名请求共用一个计数
+)
+
+// ErrCapExceeded 目标今日的请求数已达上限
+var ErrCapExceeded = errors.New("目标今日请求数已达上限")
+
+// Rule 单条每日请求上限。
+type Rule struct {
+	Pattern string `json:"pattern"` // 目标主机模式（见 hostmatch 包）
+	Limit   int64  `json:"limit"`   // 每日请求数上限
+	Scope   string `json:"scope"`   // 计数范围：global或user
+
+	host hostmatch.Pattern // 编译后的目标主机模式
+}
+
+// ExceededError 请求数达到上限的错误，附带上限重置时间。
+type ExceededError struct {
+	Rule  Rule      // 达到上限的规则
+	Reset time.Time // 计数重置的时间
+}
+
+// Error 返回错误信息。
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s: %s 每日上限 %d 次，%s 重置", ErrCapExceeded, e.Rule.Pattern, e.Rule.Limit,
+		e.Reset.Format(time.RFC3339))
+}
+
+// Unwrap 返回ErrCapExceeded。
+func (e *ExceededError) Unwrap() error {
+	return ErrCapExceeded
+}
+
+// RetryAfter 返回距离计数重置的时间。
+func (e *ExceededError) RetryAfter() time.Duration {
+	return time.Until(e.Reset)
+}
+
+// ResetAt 返回计数重置的时间。
+func (e *ExceededError) ResetAt() time.Time {
+	return e.Reset
+}
+
+// Usage 单条规则（按用户计数时为单个用户）的当日用量。
+type Usage struct {
+	Pattern string `json:"pattern"`        // 目标模式
+	Scope   string `json:"scope"`          // 计数范围
+	User    string `json:"user,omitempty"` // 认证用户名，全局计数时为空
+	Limit   int64  `json:"limit"`          // 每日请求数上限
+	Used    int64  `json:"used"`           // 当日已用请求数
+}
+
+// Status 每日请求上限的状态。
+type Status struct {
+	Day     string    `json:"day"`      // 统计日期（UTC，YYYY-MM-DD）
+	ResetAt time.Time `json:"reset_at"` // 计数重置的时间
+	Rules   []Rule    `json:"rules"`    // 全部规则
+	Usage   []Usage   `json:"usage"`    // 当日有请求的规则和用户
+}
+
+// countKey 计数的键。
+type countKey struct {
+	rule int    // 规则序号
+	user string // 认证用户名，全局计数时为空
+}
+
+// Caps 按目标主机的每日请求上限。
+type Caps struct {
+	rules  []Rule             // 规则列表
+	day    string             // 当前统计日期
+	counts map[countKey]int64 // 当日计数
+	mutex  sync.Mutex         // 互斥锁
+}
+
+// ParseRules 解析每日请求上限规则。
+//
+// 参数：
+//   - global: 全部用户共享计数的上限（目标模式到次数）
+//   - perUser: 按用户分别计数的上限（目标模式到次数）
+//
+// 返回值：
+//   - []Rule: 按计数范围和模式排序的规则
+//   - error: 模式为空或次数不是正整数时返回错误
+func ParseRules(global, perUser map[string]string) ([]Rule, error) {
+	var rules []Rule
+	for _, group := range []struct {
+		scope  string
+		limits map[string]string
+	}{{ScopeGlobal, global}, {ScopeUser, perUser}} {
+		patterns := make([]string, 0, len(group.limits))
+		for pattern := range group.limits {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			limit, err := strconv.ParseInt(strings.TrimSpace(group.limits[pattern]), 10, 64)
+			if err != nil || limit <= 0 {
+				return nil, fmt.Errorf("目标 %s 的每日请求上限必须是正整数: %s", pattern, group.limits[pattern])
+			}
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				return nil, errors.New("每日请求上限的目标模式为空")
+			}
+			host, err := hostmatch.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("每日请求上限: %v", err)
+			}
+			rules = append(rules, Rule{Pattern: pattern, Limit: limit, Scope: group.scope, host: host})
+		}
+	}
+	return rules, nil
+}
+
+// New 创建每日请求上限。
+//
+// 参数：
+//   - rules: 上限规则
+//
+// 返回值：
+//   - *Caps: 每日请求上限，没有规则时为nil
+func New(rules []Rule) *Caps {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Caps{rules: rules, counts: make(map[countKey]int64)}
+}
+
+// Len 返回规则数量，上限为nil时返回0。
+func (c *Caps) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.rules)
+}
+
+// Allow 检查并计入一次请求。
+//
+// 目标同时命中多条规则时须全部未达上限，请求才会放行并计入每条规则；
+// 被拒绝的请求不计数。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//   - user: 认证用户名，匿名为空
+//
+// 返回值：
+//   - error: 达到上限时返回*ExceededError，上限为nil时始终为nil
+func (c *Caps) Allow(host, user string) error {
+	return c.check(host, user, true)
+}
+
+// Peek 检查一次请求是否会被放行，不计数。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//   - user: 认证用户名，匿名为空
+//
+// 返回值：
+//   - error: 达到上限时返回*ExceededError，上限为nil时始终为nil
+func (c *Caps) Peek(host, user string) error {
+	return c.check(host, user, false)
+}
+
+// check 检查请求是否达到上限，count为true且放行时计数。
+func (c *Caps) check(host, user string, count bool) error {
+	if c == nil {
+		return nil
+	}
+	host = hostmatch.Normalize(host)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now().UTC()
+	c.rollover(now)
+
+	var keys []countKey
+	for i, rule := range c.rules {
+		if !rule.host.Match(host) {
+			continue
+		}
+		key := countKey{rule: i}
+		if rule.Scope == ScopeUser {
+			key.user = user
+		}
+		if c.counts[key] >= rule.Limit {
+			return &ExceededError{Rule: rule, Reset: nextDay(now)}
+		}
+		keys = append(keys, key)
+	}
+	if count {
+		for _, key := range keys {
+			c.counts[key]++
+		}
+	}
+	return nil
+}
+
+// rollover 进入新的一天时清空计数，调用方需持有锁。
+func (c *Caps) rollover(now time.Time) {
+	if day := now.Format(time.DateOnly); day != c.day {
+		c.day = day
+		clear(c.counts)
+	}
+}
+
+// Status 返回每日请求上限的状态。
+//
+// 返回值：
+//   - Status: 规则和当日用量，按用量从多到少排列
+func (c *Caps) Status() Status {
+	if c == nil {
+		return Status{Rules: []Rule{}, Usage: []Usage{}}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now().UTC()
+	c.rollover(now)
+
+	status := Status{Day: c.day, ResetAt: nextDay(now), Rules: c.rules, Usage: make([]Usage, 0, len(c.counts))}
+	for key, used := range c.counts {
+		rule := c.rules[key.rule]
+		status.Usage = append(status.Usage, Usage{
+			Pattern: rule.Pattern, Scope: rule.Scope, User: key.user, Limit: rule.Limit, Used: used,
+		})
+	}
+	sort.Slice(status.Usage, func(i, j int) bool {
+		a, b := status.Usage[i], status.Usage[j]
+		if a.Used != b.Used {
+			return a.Used > b.Used
+		}
+		if a.Pattern != b.Pattern {
+			return a.Pattern < b.Pattern
+		}
+		return a.User < b.User
+	})
+	return status
+}
+
+// nextDay 返回下一个UTC零点。
+func nextDay(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,299 @@
+// Package rewrite 提供按规则改写响应体的功能。
+//
+// 规则按目标主机和内容类型匹配，支持正则替换和URL前缀改写（例如把页面中的
+// https:// 链接改写为 http://，使后续请求继续经由代理的HTTP路径）。改写需要
+// 完整的响应体，只处理不超过大小上限的响应，超过上限的响应原样流式转发；
+// 压缩的响应只在规则要求解压时处理，其余流量不受影响。规则从JSON文件加载。
+package rewrite
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+)
+
+// DefaultMaxBytes 未配置上限时可改写的响应体大小上限
+const DefaultMaxBytes = 1 << 20
+
+// defaultContentTypes 规则未指定内容类型时改写的类型，以 "/" 结尾的按前缀匹配
+var defaultContentTypes = []string{"text/", "application/json", "application/javascript", "application/xml", "application/xhtml+xml"}
+
+// Rule 单条响应体改写规则。
+type Rule struct {
+	Name         string            `json:"name"`          // 规则名称
+	Destinations []string          `json:"destinations"`  // 适用的目标主机模式（见 hostmatch 包）
+	ContentTypes []string          `json:"content_types"` // 适用的内容类型，以 "/" 结尾的按前缀匹配，为空使用文本类型
+	Find         string            `json:"find"`          // 正则表达式
+	Replace      string            `json:"replace"`       // 替换内容，支持 $1、${name} 引用分组
+	URLs         map[string]string `json:"urls"`          // URL前缀改写，原前缀到新前缀
+	Decompress   bool              `json:"decompress"`    // 是否解压gzip、deflate压缩的响应后再处理，解压后以未压缩形式转发
+
+	find *regexp.Regexp // 编译后的正则表达式
+	urls *regexp.Regexp // 匹配全部URL前缀的正则表达式，最长的前缀优先
+	dest hostmatch.List // 编译后的目标主机模式
+}
+
+// Set 响应体改写规则集合，按文件中的顺序依次应用。
+type Set struct {
+	rules    []*Rule // 改写规则
+	maxBytes int64   // 可改写的响应体大小上限
+}
+
+// Load 从JSON文件加载改写规则。
+//
+// 文件格式为 {"rules": [...]}，每条规则需要名称、目标主机，以及正则替换、URL前缀改写或解压之一。
+//
+// 参数：
+//   - path: 规则文件路径
+//   - maxBytes: 可改写的响应体大小上限，0使用 DefaultMaxBytes
+//
+// 返回值：
+//   - *Set: 规则集合
+//   - error: 读取、解析错误或规则无效
+func Load(path string, maxBytes int64) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应改写规则文件失败: %v", err)
+	}
+
+	var file struct {
+		Rules []*Rule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析响应改写规则文件失败: %v", err)
+	}
+
+	for i, r := range file.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("第 %d 条响应改写规则缺少名称", i+1)
+		}
+		if len(r.Destinations) == 0 {
+			return nil, fmt.Errorf("响应改写规则 %s 缺少目标主机", r.Name)
+		}
+		if r.Find == "" && len(r.URLs) == 0 && !r.Decompress {
+			return nil, fmt.Errorf("响应改写规则 %s 没有 find、urls 或 decompress", r.Name)
+		}
+		if r.dest, err = hostmatch.CompileList(r.Destinations); err != nil {
+			return nil, fmt.Errorf("响应改写规则 %s: %v", r.Name, err)
+		}
+		if r.Find != "" {
+			if r.find, err = regexp.Compile(r.Find); err != nil {
+				return nil, fmt.Errorf("响应改写规则 %s 的正则表达式无效: %v", r.Name, err)
+			}
+		}
+		if len(r.URLs) > 0 {
+			r.urls = prefixPattern(r.URLs)
+		}
+	}
+
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &Set{rules: file.Rules, maxBytes: maxBytes}, nil
+}
+
+// prefixPattern 生成匹配全部URL前缀的正则表达式。
+func prefixPattern(urls map[string]string) *regexp.Regexp {
+	prefixes := make([]string, 0, len(urls))
+	for from := range urls {
+		prefixes = append(prefixes, from)
+	}
+	// 较长的前缀排在前面，避免被其前缀抢先匹配
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	quoted := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		quoted[i] = regexp.QuoteMeta(prefix)
+	}
+	return regexp.MustCompile(strings.Join(quoted, "|"))
+}
+
+// Len 返回规则数量，集合为nil时返回0。
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+// Match 返回适用于目标主机和内容类型的规则。
+//
+// 参数：
+//   - host: 目标主机名
+//   - contentType: 响应的Content-Type
+//
+// 返回值：
+//   - []*Rule: 按顺序排列的适用规则，集合为nil时为空
+func (s *Set) Match(host, contentType string) []*Rule {
+	if s == nil {
+		return nil
+	}
+	host = hostmatch.Normalize(host)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	var matched []*Rule
+	for _, r := range s.rules {
+		if r.dest.Match(host) && r.matchesType(mediaType) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// matchesType 判断规则是否适用于内容类型。
+func (r *Rule) matchesType(mediaType string) bool {
+	types := r.ContentTypes
+	if len(types) == 0 {
+		types = defaultContentTypes
+	}
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply 对响应体应用规则。
+func (r *Rule) apply(body []byte) []byte {
+	if r.find != nil {
+		body = r.find.ReplaceAll(body, []byte(r.Replace))
+	}
+	if r.urls != nil {
+		body = r.urls.ReplaceAllFunc(body, func(prefix []byte) []byte {
+			return []byte(r.URLs[string(prefix)])
+		})
+	}
+	return body
+}
+
+// Apply 按规则改写响应体。
+//
+// 未压缩（没有Content-Encoding或为identity）的响应直接处理；gzip、deflate压缩的响应
+// 只在有匹配规则要求解压时解压后处理，以未压缩形式转发并移除Content-Encoding，
+// 其他压缩方式原样转发。读取时最多缓冲上限加一个字节（解压后同样受上限约束），
+// 超过上限或解压失败的响应体原样转发；读完的响应体替换为处理结果，并按实际长度更新Content-Length。
+//
+// 参数：
+//   - host: 目标主机名
+//   - method: 请求方法
+//   - resp: 上游响应，响应体可能被替换
+//
+// 返回值：
+//   - []string: 改变了响应体（含解压）的规则名称，未改写时为空
+func (s *Set) Apply(host, method string, resp *http.Response) []string {
+	if s == nil || method == http.MethodHead || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified || resp.ContentLength > s.maxBytes {
+		return nil
+	}
+	rules := s.Match(host, resp.Header.Get("Content-Type"))
+	if len(rules) == 0 {
+		return nil
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		encoding = ""
+	}
+	if encoding != "" && (!canDecode(encoding) || !slices.ContainsFunc(rules, func(r *Rule) bool { return r.Decompress })) {
+		return nil
+	}
+
+	original := resp.Body
+	raw, err := io.ReadAll(io.LimitReader(original, s.maxBytes+1))
+	if err != nil || int64(len(raw)) > s.maxBytes {
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(raw), original), Closer: original}
+		return nil
+	}
+	body := raw
+	if encoding != "" {
+		if body, err = decode(encoding, raw, s.maxBytes); err != nil {
+			resp.Body = readCloser{Reader: bytes.NewReader(raw), Closer: original}
+			return nil
+		}
+	}
+
+	var applied []string
+	for _, r := range rules {
+		rewritten := r.apply(body)
+		if !bytes.Equal(rewritten, body) || encoding != "" && r.Decompress {
+			applied = append(applied, r.Name)
+		}
+		body = rewritten
+	}
+	if encoding != "" {
+		resp.Header.Del("Content-Encoding")
+		resp.Uncompressed = true
+	}
+	resp.Body = readCloser{Reader: bytes.NewReader(body), Closer: original}
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.TransferEncoding = nil
+	return applied
+}
+
+// canDecode 判断是否支持解压该内容编码。
+func canDecode(encoding string) bool {
+	switch encoding {
+	case "gzip", "x-gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+// decode 解压响应体，解压后超过上限时返回错误。
+//
+// HTTP的deflate编码应为zlib格式，部分服务器发送裸deflate数据，两种都接受。
+func decode(encoding string, data []byte, maxBytes int64) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	default:
+		if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			r = zr
+		} else {
+			r = flate.NewReader(bytes.NewReader(data))
+		}
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > maxBytes {
+		return nil, fmt.Errorf("解压后的响应体超过 %d 字节", maxBytes)
+	}
+	return decoded, nil
+}
+
+// readCloser 组合读取与关闭，用于替换已读取的响应体。
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,428 @@
+// Package robots 提供robots.txt合规检查。
+//
+// 需要证明抓取行为合规的团队可以在代理层统一执行robots.txt：本包按目标站点
+// 获取并缓存robots.txt（RFC 9309），对配置的爬虫身份拒绝被禁止的路径。
+// 只能检查能看到路径的普通HTTP请求，CONNECT隧道内的HTTPS请求无法检查。
+package robots
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxSize robots.txt的最大解析字节数，超出部分忽略（RFC 9309要求至少500KiB）
+	maxSize = 500 << 10
+	// failureTTL robots.txt获取失败或服务端错误时的缓存时长
+	failureTTL = time.Minute
+	// maxEntries 最多缓存的站点数
+	maxEntries = 10000
+	// fetchTimeout 获取robots.txt的超时时间
+	fetchTimeout = 10 * time.Second
+)
+
+// ErrDisallowed robots.txt禁止访问该路径
+var ErrDisallowed = errors.New("robots.txt 禁止访问")
+
+// DisallowedError 路径被robots.txt禁止的错误。
+type DisallowedError struct {
+	Site  string // 站点（scheme://host）
+	Path  string // 请求路径
+	Agent string // 匹配的爬虫身份
+	Rule  string // 生效的规则，站点不可用而全部禁止时为空
+}
+
+// Error 返回错误信息。
+func (e *DisallowedError) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf("%s %s%s（爬虫 %s，robots.txt 暂时无法获取）", ErrDisallowed, e.Site, e.Path, e.Agent)
+	}
+	return fmt.Sprintf("%s %s%s（爬虫 %s，规则 Disallow: %s）", ErrDisallowed, e.Site, e.Path, e.Agent, e.Rule)
+}
+
+// Unwrap 返回ErrDisallowed。
+func (e *DisallowedError) Unwrap() error {
+	return ErrDisallowed
+}
+
+// Options robots.txt检查配置。
+type Options struct {
+	Agents []string      // 需要遵守robots.txt的爬虫身份，按User-Agent包含匹配；"*" 表示全部请求
+	TTL    time.Duration // robots.txt缓存时长，0表示1小时
+	// Fetch 获取robots.txt的函数，一般经由代理池发送请求
+	Fetch func(ctx context.Context, target string) (*http.Response, error)
+}
+
+// SiteStatus 单个站点的robots.txt缓存状态。
+type SiteStatus struct {
+	Site        string    `json:"site"`         // 站点（scheme://host）
+	Status      int       `json:"status"`       // robots.txt的HTTP状态码，获取失败时为0
+	DisallowAll bool      `json:"disallow_all"` // 是否因站点不可用而全部禁止
+	Groups      int       `json:"groups"`       // 解析出的规则组数
+	Fetched     time.Time `json:"fetched"`      // 获取时间
+	Expires     time.Time `json:"expires"`      // 缓存过期时间
+	Blocked     int64     `json:"blocked"`      // 被拒绝的请求数
+}
+
+// rule 单条Allow或Disallow规则。
+type rule struct {
+	allow   bool   // 是否为Allow规则
+	pattern string // 路径模式，支持 * 和结尾的 $
+}
+
+// group 适用于一组爬虫的规则。
+type group struct {
+	agents []string // 小写的爬虫名称
+	rules  []rule   // 规则
+}
+
+// entry 单个站点的缓存。
+type entry struct {
+	ready       chan struct{} // 获取完成后关闭
+	groups      []group       // 解析出的规则组
+	status      int           // HTTP状态码，获取失败时为0
+	disallowAll bool          // 站点不可用时全部禁止
+	fetched     time.Time     // 获取时间
+	expires     time.Time     // 缓存过期时间
+	blocked     atomic.Int64  // 被拒绝的请求数
+}
+
+// Checker robots.txt检查器。
+type Checker struct {
+	opts    Options           // 配置
+	agents  []string          // 小写的爬虫身份，不含 "*"
+	all     bool              // 是否检查全部请求
+	entries map[string]*entry // 按站点的缓存
+	mutex   sync.Mutex        // 互斥锁
+}
+
+// New 创建robots.txt检查器。
+//
+// 参数：
+//   - opts: 检查配置
+//
+// 返回值：
+//   - *Checker: 检查器，没有配置爬虫身份时为nil
+func New(opts Options) *Checker {
+	if len(opts.Agents) == 0 {
+		return nil
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Hour
+	}
+	c := &Checker{opts: opts, entries: make(map[string]*entry)}
+	for _, agent := range opts.Agents {
+		agent = strings.ToLower(strings.TrimSpace(agent))
+		switch agent {
+		case "":
+		case "*":
+			c.all = true
+		default:
+			c.agents = append(c.agents, agent)
+		}
+	}
+	return c
+}
+
+// Check 检查请求是否被目标站点的robots.txt禁止。
+//
+// User-Agent包含配置的爬虫身份时按该身份的规则组检查，没有对应规则组时使用 "*" 规则组；
+// 配置了 "*" 时其余请求按 "*" 规则组检查。robots.txt返回4xx视为不限制，
+// 获取失败或返回5xx时按RFC 9309视为全部禁止，并在1分钟后重试。
+//
+// 参数：
+//   - ctx: 请求上下文，取消时停止等待robots.txt
+//   - target: 请求的URL
+//   - userAgent: 客户端的User-Agent
+//
+// 返回值：
+//   - error: 路径被禁止时返回*DisallowedError，检查器为nil时始终为nil
+func (c *Checker) Check(ctx context.Context, target *url.URL, userAgent string) error {
+	if c == nil || target.Host == "" {
+		return nil
+	}
+	agent := c.agentFor(userAgent)
+	path := target.EscapedPath()
+	if agent == "" || path == "/robots.txt" {
+		return nil
+	}
+	if path == "" {
+		path = "/"
+	}
+	if target.RawQuery != "" {
+		path += "?" + target.RawQuery
+	}
+
+	site := strings.ToLower(target.Scheme + "://" + target.Host)
+	e, err := c.entry(ctx, site)
+	if err != nil {
+		return nil
+	}
+	if e.disallowAll {
+		e.blocked.Add(1)
+		return &DisallowedError{Site: site, Path: path, Agent: agent}
+	}
+	if pattern, allowed := decide(selectGroup(e.groups, agent), path); !allowed {
+		e.blocked.Add(1)
+		return &DisallowedError{Site: site, Path: path, Agent: agent, Rule: pattern}
+	}
+	return nil
+}
+
+// agentFor 返回请求对应的爬虫身份，不需要检查时为空。
+func (c *Checker) agentFor(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	for _, agent := range c.agents {
+		if strings.Contains(userAgent, agent) {
+			return agent
+		}
+	}
+	if c.all {
+		return "*"
+	}
+	return ""
+}
+
+// entry 返回站点的缓存，过期或不存在时获取robots.txt，同一站点同时只获取一次。
+//
+// 返回值：
+//   - *entry: 站点的缓存
+//   - error: 等待获取期间请求上下文被取消
+func (c *Checker) entry(ctx context.Context, site string) (*entry, error) {
+	c.mutex.Lock()
+	e := c.entries[site]
+	if e != nil {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) {
+				e = nil
+			}
+		default:
+		}
+	}
+	if e == nil {
+		c.evict()
+		e = &entry{ready: make(chan struct{})}
+		c.entries[site] = e
+		c.mutex.Unlock()
+		c.fetch(site, e)
+		return e, nil
+	}
+	c.mutex.Unlock()
+
+	select {
+	case <-e.ready:
+		return e, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// evict 缓存已满时删除过期的站点，仍然已满时任意删除一个，调用方需持有锁。
+func (c *Checker) evict() {
+	if len(c.entries) < maxEntries {
+		return
+	}
+	now := time.Now()
+	for site, e := range c.entries {
+		select {
+		case <-e.ready:
+			if now.After(e.expires) {
+				delete(c.entries, site)
+			}
+		default:
+		}
+	}
+	for site := range c.entries {
+		if len(c.entries) < maxEntries {
+			break
+		}
+		delete(c.entries, site)
+	}
+}
+
+// fetch 获取并解析站点的robots.txt，完成后关闭e.ready。
+func (c *Checker) fetch(site string, e *entry) {
+	defer close(e.ready)
+	e.fetched = time.Now()
+	e.expires = e.fetched.Add(c.opts.TTL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	resp, err := c.opts.Fetch(ctx, site+"/robots.txt")
+	if err != nil {
+		log.Printf("获取 %s/robots.txt 失败，暂时禁止访问该站点: %v", site, err)
+		e.disallowAll = true
+		e.expires = e.fetched.Add(failureTTL)
+		return
+	}
+	defer resp.Body.Close()
+
+	e.status = resp.StatusCode
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		log.Printf("获取 %s/robots.txt 返回 %d，暂时禁止访问该站点", site, resp.StatusCode)
+		e.disallowAll = true
+		e.expires = e.fetched.Add(failureTTL)
+	case resp.StatusCode >= http.StatusBadRequest:
+		// 不存在或无权访问的robots.txt视为不限制
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		e.groups = parse(io.LimitReader(resp.Body, maxSize))
+	}
+}
+
+// Status 返回已缓存站点的robots.txt状态。
+//
+// 返回值：
+//   - []SiteStatus: 按站点排序的状态，检查器为nil时为空
+func (c *Checker) Status() []SiteStatus {
+	result := []SiteStatus{}
+	if c == nil {
+		return result
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for site, e := range c.entries {
+		select {
+		case <-e.ready:
+		default:
+			continue
+		}
+		result = append(result, SiteStatus{
+			Site:        site,
+			Status:      e.status,
+			DisallowAll: e.disallowAll,
+			Groups:      len(e.groups),
+			Fetched:     e.fetched,
+			Expires:     e.expires,
+			Blocked:     e.blocked.Load(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Site < result[j].Site })
+	return result
+}
+
+// parse 解析robots.txt。
+//
+// 连续的User-agent行开始一个规则组，之后的Allow和Disallow规则属于该组；
+// 其他字段（如Sitemap、Crawl-delay）和无法识别的行被忽略。
+func parse(r io.Reader) []group {
+	var groups []group
+	var current *group
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if current == nil || inRules {
+				groups = append(groups, group{})
+				current = &groups[len(groups)-1]
+				inRules = false
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			if value != "" {
+				current.rules = append(current.rules, rule{allow: key == "allow", pattern: value})
+			}
+		}
+	}
+	return groups
+}
+
+// selectGroup 返回适用于爬虫身份的规则，合并同名的全部规则组；没有同名规则组时使用 "*" 规则组。
+func selectGroup(groups []group, agent string) []rule {
+	var matched, fallback []rule
+	found := false
+	for _, g := range groups {
+		for _, name := range g.agents {
+			switch name {
+			case agent:
+				matched = append(matched, g.rules...)
+				found = true
+			case "*":
+				fallback = append(fallback, g.rules...)
+			}
+		}
+	}
+	if found {
+		return matched
+	}
+	return fallback
+}
+
+// decide 按最长匹配原则判断路径是否允许访问，长度相同时Allow优先。
+//
+// 返回值：
+//   - string: 禁止访问时生效的Disallow规则
+//   - bool: 是否允许访问
+func decide(rules []rule, path string) (string, bool) {
+	best := -1
+	allowed := true
+	var pattern string
+	for _, r := range rules {
+		if !matchPattern(r.pattern, path) {
+			continue
+		}
+		if n := len(r.pattern); n > best || (n == best && r.allow) {
+			best = n
+			allowed = r.allow
+			pattern = r.pattern
+		}
+	}
+	if allowed {
+		return "", true
+	}
+	return pattern, false
+}
+
+// matchPattern 判断路径是否匹配规则模式，* 匹配任意字符序列，结尾的 $ 表示匹配到路径末尾。
+func matchPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
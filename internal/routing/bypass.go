@@ -0,0 +1,175 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// bypassEntry 绕过列表中的一项。
+type bypassEntry struct {
+	all     bool       // "*"，匹配所有目标
+	network *net.IPNet // CIDR网段
+	ip      net.IP     // 单个IP地址
+	domain  string     // 域名，不含开头的 "."
+	subOnly bool       // 只匹配子域名（以 "." 或 "*." 开头的写法）
+	port    int        // 限定的目标端口，0表示任意端口
+}
+
+// Bypass 直连绕过列表，格式与 NO_PROXY 相同。
+//
+// 命中的目标不经过上游代理，直接连接。
+type Bypass struct {
+	patterns []string      // 原始写法
+	entries  []bypassEntry // 解析后的条目
+	hits     atomic.Int64  // 命中次数
+}
+
+// ParseBypass 解析直连绕过列表。
+//
+// 每项可以是：
+//   - "*" 所有目标
+//   - "example.com" example.com及其所有子域名，".example.com" 和 "*.example.com" 只匹配子域名
+//   - "10.0.0.0/8"、"127.0.0.1"、"::1" 网段或IP地址，只匹配以IP地址访问的目标，不解析域名
+//   - ":8080" 任意主机的8080端口
+//   - "example.com:8443"、"[::1]:8080" 限定端口的域名或IP地址
+//
+// 参数：
+//   - patterns: 绕过列表
+//
+// 返回值：
+//   - *Bypass: 绕过列表，为空时为nil
+//   - error: 某项格式无效
+func ParseBypass(patterns []string) (*Bypass, error) {
+	b := &Bypass{}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		entry, err := parseBypassEntry(pattern)
+		if err != nil {
+			return nil, err
+		}
+		b.patterns = append(b.patterns, pattern)
+		b.entries = append(b.entries, entry)
+	}
+	if len(b.entries) == 0 {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// parseBypassEntry 解析单项，pattern已转为小写。
+func parseBypassEntry(pattern string) (bypassEntry, error) {
+	if pattern == "*" {
+		return bypassEntry{all: true}, nil
+	}
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return bypassEntry{}, fmt.Errorf("直连绕过列表中的网段无效: %s", pattern)
+		}
+		return bypassEntry{network: network}, nil
+	}
+	if ip := net.ParseIP(pattern); ip != nil {
+		return bypassEntry{ip: ip}, nil
+	}
+
+	var entry bypassEntry
+	host := pattern
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return bypassEntry{}, fmt.Errorf("直连绕过列表中的端口无效: %s", pattern)
+		}
+		host, entry.port = h, port
+	}
+	if host == "" {
+		return entry, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		entry.ip = ip
+		return entry, nil
+	}
+	switch {
+	case strings.HasPrefix(host, "*."):
+		entry.domain, entry.subOnly = host[2:], true
+	case strings.HasPrefix(host, "."):
+		entry.domain, entry.subOnly = host[1:], true
+	default:
+		entry.domain = host
+	}
+	if entry.domain == "" || strings.ContainsAny(entry.domain, "*[]") {
+		return bypassEntry{}, fmt.Errorf("直连绕过列表中的主机无效: %s", pattern)
+	}
+	return entry, nil
+}
+
+// Match 判断目标是否在绕过列表中，命中时计入统计。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//   - port: 目标端口
+//
+// 返回值：
+//   - bool: 是否直连，列表为nil时返回false
+func (b *Bypass) Match(host string, port int) bool {
+	if b.Peek(host, port) {
+		b.hits.Add(1)
+		return true
+	}
+	return false
+}
+
+// Peek 判断目标是否在绕过列表中，不计入统计，用于预演。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//   - port: 目标端口
+//
+// 返回值：
+//   - bool: 是否直连，列表为nil时返回false
+func (b *Bypass) Peek(host string, port int) bool {
+	if b == nil {
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	ip := net.ParseIP(host)
+	for i := range b.entries {
+		entry := &b.entries[i]
+		if entry.port != 0 && entry.port != port {
+			continue
+		}
+		switch {
+		case entry.all:
+			return true
+		case entry.network != nil:
+			if ip != nil && entry.network.Contains(ip) {
+				return true
+			}
+		case entry.ip != nil:
+			if ip != nil && entry.ip.Equal(ip) {
+				return true
+			}
+		case entry.domain != "":
+			if ip == nil && (strings.HasSuffix(host, "."+entry.domain) || (!entry.subOnly && host == entry.domain)) {
+				return true
+			}
+		default:
+			// 只限定端口的 ":8080"
+			return true
+		}
+	}
+	return false
+}
+
+// Stats 返回绕过列表的命中统计，形式与直连路由规则相同。
+//
+// 返回值：
+//   - RuleStats: 命中统计
+func (b *Bypass) Stats() RuleStats {
+	return RuleStats{Hosts: b.patterns, Action: ActionDirect, Hits: b.hits.Load()}
+}
@@ -0,0 +1,240 @@
+// Package routing 按目标主机选择请求的去向。
+//
+// 路由规则把目标主机映射到指定的代理池、直连或拦截，例如内部域名直连、
+// 只有外部采集目标使用付费代理池。规则从YAML文件加载，按顺序匹配第一条命中的规则。
+package routing
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+)
+
+const (
+	// ActionProxy 通过代理池转发，指定了代理池时使用该代理池
+	ActionProxy = "proxy"
+	// ActionDirect 不经过上游代理，直接连接目标
+	ActionDirect = "direct"
+	// ActionBlock 拒绝请求
+	ActionBlock = "block"
+)
+
+// Route 一次匹配的结果。
+type Route struct {
+	Pattern string // 命中的主机模式，使用默认路由时为 "default"
+	Action  string // 动作：proxy、direct 或 block
+	Pool    string // 动作为proxy时使用的代理池，为空表示按切换计划选择
+}
+
+// RuleStats 单条规则的命中统计。
+type RuleStats struct {
+	Hosts  []string `json:"hosts"`          // 主机模式
+	Action string   `json:"action"`         // 动作
+	Pool   string   `json:"pool,omitempty"` // 使用的代理池
+	Hits   int64    `json:"hits"`           // 命中次数
+}
+
+// rule 一条路由规则。
+type rule struct {
+	Hosts  []string `yaml:"hosts"`  // 主机模式
+	Action string   `yaml:"action"` // 动作，为空时按proxy处理
+	Pool   string   `yaml:"pool"`   // 使用的代理池
+
+	matchers hostmatch.List // 编译后的主机模式
+	hits     atomic.Int64   // 命中次数
+}
+
+// file 路由规则文件的结构。
+type file struct {
+	Rules   []*rule `yaml:"rules"`   // 按顺序匹配的规则
+	Default *rule   `yaml:"default"` // 没有规则命中时使用的路由，省略时按切换计划选择代理池
+}
+
+// Router 路由规则集合。
+type Router struct {
+	rules []*rule // 按顺序匹配的规则
+	def   *rule   // 默认路由，nil表示不改变请求去向
+}
+
+// Load 从YAML文件加载路由规则。
+//
+// 主机模式的写法见 hostmatch 包，支持精确匹配、".example.com" 后缀、通配符和 "regex:" 正则表达式。
+//
+// 参数：
+//   - path: 规则文件路径
+//
+// 返回值：
+//   - *Router: 路由规则集合，文件中没有任何规则时为nil
+//   - error: 读取文件失败或规则无效
+func Load(path string) (*Router, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取路由规则文件失败: %v", err)
+	}
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("解析路由规则文件失败: %v", err)
+	}
+
+	for i, r := range f.Rules {
+		if len(r.Hosts) == 0 {
+			return nil, fmt.Errorf("第 %d 条路由规则没有主机模式", i+1)
+		}
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("第 %d 条路由规则: %w", i+1, err)
+		}
+	}
+	if f.Default != nil {
+		if len(f.Default.Hosts) > 0 {
+			return nil, fmt.Errorf("默认路由不能指定主机模式")
+		}
+		if err := f.Default.compile(); err != nil {
+			return nil, fmt.Errorf("默认路由: %w", err)
+		}
+	}
+
+	if len(f.Rules) == 0 && f.Default == nil {
+		return nil, nil
+	}
+	return &Router{rules: f.Rules, def: f.Default}, nil
+}
+
+// compile 检查动作并编译主机模式。
+func (r *rule) compile() error {
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	switch r.Action {
+	case "":
+		r.Action = ActionProxy
+	case ActionProxy:
+	case ActionDirect, ActionBlock:
+		if r.Pool != "" {
+			return fmt.Errorf("动作 %s 不能指定代理池", r.Action)
+		}
+	default:
+		return fmt.Errorf("未知的路由动作: %s", r.Action)
+	}
+	matchers, err := hostmatch.CompileList(r.Hosts)
+	if err != nil {
+		return err
+	}
+	r.matchers = matchers
+	return nil
+}
+
+// Match 查找目标主机的路由，并计入命中规则的统计。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//
+// 返回值：
+//   - Route: 命中的路由
+//   - bool: 是否有规则或默认路由命中，集合为nil时返回false
+func (r *Router) Match(host string) (Route, bool) {
+	route, rule := r.find(host)
+	if rule == nil {
+		return Route{}, false
+	}
+	rule.hits.Add(1)
+	return route, true
+}
+
+// Peek 查找目标主机的路由，不计入统计，用于预演。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//
+// 返回值：
+//   - Route: 命中的路由
+//   - bool: 是否有规则或默认路由命中，集合为nil时返回false
+func (r *Router) Peek(host string) (Route, bool) {
+	route, rule := r.find(host)
+	return route, rule != nil
+}
+
+// find 按顺序查找第一条命中的规则，没有命中时使用默认路由。
+func (r *Router) find(host string) (Route, *rule) {
+	if r == nil {
+		return Route{}, nil
+	}
+	host = hostmatch.Normalize(host)
+	for _, rule := range r.rules {
+		for _, m := range rule.matchers {
+			if m.Match(host) {
+				return Route{Pattern: m.String(), Action: rule.Action, Pool: rule.Pool}, rule
+			}
+		}
+	}
+	if r.def != nil {
+		return Route{Pattern: "default", Action: r.def.Action, Pool: r.def.Pool}, r.def
+	}
+	return Route{}, nil
+}
+
+// Pools 返回规则引用的全部代理池名称，用于启动时检查代理池是否存在。
+//
+// 返回值：
+//   - []string: 代理池名称，可能重复
+func (r *Router) Pools() []string {
+	if r == nil {
+		return nil
+	}
+	var pools []string
+	for _, rule := range r.all() {
+		if rule.Pool != "" {
+			pools = append(pools, rule.Pool)
+		}
+	}
+	return pools
+}
+
+// HasDirect 判断是否有直连规则。
+func (r *Router) HasDirect() bool {
+	if r == nil {
+		return false
+	}
+	for _, rule := range r.all() {
+		if rule.Action == ActionDirect {
+			return true
+		}
+	}
+	return false
+}
+
+// Len 返回规则数量（不含默认路由），集合为nil时返回0。
+func (r *Router) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.rules)
+}
+
+// Stats 返回各规则的命中统计，默认路由排在最后，主机模式为 ["default"]。
+//
+// 返回值：
+//   - []RuleStats: 按规则顺序排列的统计，集合为nil时为空
+func (r *Router) Stats() []RuleStats {
+	if r == nil {
+		return []RuleStats{}
+	}
+	stats := make([]RuleStats, 0, len(r.rules)+1)
+	for _, rule := range r.rules {
+		stats = append(stats, RuleStats{Hosts: rule.Hosts, Action: rule.Action, Pool: rule.Pool, Hits: rule.hits.Load()})
+	}
+	if r.def != nil {
+		stats = append(stats, RuleStats{Hosts: []string{"default"}, Action: r.def.Action, Pool: r.def.Pool, Hits: r.def.hits.Load()})
+	}
+	return stats
+}
+
+// all 返回包括默认路由在内的全部规则。
+func (r *Router) all() []*rule {
+	if r.def == nil {
+		return r.rules
+	}
+	return append(r.rules[:len(r.rules):len(r.rules)], r.def)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	// acceptMinDelay 接受连接出现临时错误后第一次重试前的等待时间
+	acceptMinDelay = 5 * time.Millisecond
+	// acceptMaxDelay 接受连接连续出现临时错误时重试等待时间的上限
+	acceptMaxDelay = time.Second
+)
+
+// acceptLoop 持续接受连接并交给处理函数，直到监听器关闭或出现不可恢复的错误。
+//
+// 客户端IP不被访问控制接受的连接在读取任何数据之前直接关闭。
+// 文件描述符耗尽（EMFILE、ENFILE）、客户端在握手完成前断开（ECONNABORTED）等临时错误不会结束监听，
+// 而是等待一段时间后重试，连续出错时等待时间加倍，不超过 acceptMaxDelay；成功接受连接后重新计算。
+//
+// 参数：
+//   - listener: 监听器
+//   - name: 监听器在日志中的名称
+//   - handle: 连接处理函数，在新协程中调用
+//
+// 返回值：
+//   - error: 不可恢复的错误，通过Shutdown关闭或监听器被主动关闭时为nil
+func (s *Server) acceptLoop(listener net.Listener, name string, handle func(net.Conn)) error {
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.shuttingDown.Load() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if !isTransientAcceptError(err) {
+				log.Printf("%s接受连接时出错: %v", name, err)
+				return err
+			}
+			delay = min(max(2*delay, acceptMinDelay), acceptMaxDelay)
+			log.Printf("%s接受连接时出现临时错误，%v 后重试: %v", name, delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if !s.clientIPs.Permits(conn.RemoteAddr().String()) {
+			conn.Close()
+			continue
+		}
+		go handle(conn)
+	}
+}
+
+// isTransientAcceptError 判断接受连接的错误是否为可以重试的临时错误。
+//
+// 资源暂时耗尽和单个客户端连接的异常属于临时错误；监听器已关闭等其他错误不可恢复。
+func isTransientAcceptError(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	for _, errno := range []syscall.Errno{
+		syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM,
+		syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EPROTO, syscall.EINTR, syscall.EAGAIN,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
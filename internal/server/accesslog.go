@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+)
+
+// accessEntry 一条访问日志记录。
+//
+// JSON格式下字段名保持稳定，便于Loki、ELK等系统解析。
+type accessEntry struct {
+	Type       string `json:"type"`            // 记录类型，固定为 access
+	Time       string `json:"time"`            // 处理结束时间（RFC 3339，UTC）
+	Client     string `json:"client"`          // 客户端IP
+	User       string `json:"user,omitempty"`  // 认证用户名
+	Listener   string `json:"listener"`        // 接受连接的监听器名称
+	Method     string `json:"method"`          // HTTP方法，隧道为 CONNECT
+	Host       string `json:"host"`            // 目标主机，隧道包含端口
+	URL        string `json:"url,omitempty"`   // HTTP请求的完整地址，隧道为空
+	Proxy      string `json:"proxy,omitempty"` // 使用的上游代理，未能连接时为空
+	Status     int    `json:"status"`          // 返回给客户端的状态码，隧道建立成功为200
+	BytesIn    int64  `json:"bytes_in"`        // 客户端发往目标的字节数
+	BytesOut   int64  `json:"bytes_out"`       // 目标发往客户端的字节数
+	DurationMs int64  `json:"duration_ms"`     // 从开始选择代理到处理结束的耗时（毫秒）
+	Label      string `json:"label,omitempty"` // 流量标签
+	Error      string `json:"error,omitempty"` // 失败或隧道转发出错的原因
+}
+
+// accessLog 访问日志。
+//
+// 每个转发的HTTP请求在响应发送完毕后、每条隧道在关闭后输出一条记录。
+// 配置了JSON输出时每条记录编码为一行JSON；否则以文本格式写入主日志。
+type accessLog struct {
+	w io.Writer // JSON格式的输出，nil表示以文本格式写入主日志
+}
+
+// newAccessLog 创建访问日志。
+//
+// 参数：
+//   - w: JSON格式的输出，nil表示以文本格式写入主日志
+//
+// 返回值：
+//   - *accessLog: 访问日志
+func newAccessLog(w io.Writer) *accessLog {
+	return &accessLog{w: w}
+}
+
+// newAccessEntry 创建访问日志记录，填入请求方的信息。
+//
+// 参数：
+//   - client: 客户端地址
+//   - authHeader: Proxy-Authorization头，用于确定用户名
+//   - listener: 监听器名称
+//   - method: HTTP方法
+//
+// 返回值：
+//   - accessEntry: 访问日志记录
+func newAccessEntry(client, authHeader, listener, method string) accessEntry {
+	entry := accessEntry{Client: hostOnly(client), Listener: listener, Method: method}
+	if authHeader != "" {
+		entry.User, _, _ = auth.DecodeBasicAuth(authHeader)
+	}
+	return entry
+}
+
+// record 输出一条访问日志。
+//
+// 参数：
+//   - entry: 访问日志记录
+//   - start: 开始处理的时间，用于计算耗时
+func (l *accessLog) record(entry accessEntry, start time.Time) {
+	entry.Type = "access"
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	entry.DurationMs = time.Since(start).Milliseconds()
+
+	if l.w == nil {
+		log.Print(entry.text())
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("编码访问日志失败: %v", err)
+		return
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		log.Printf("写入访问日志失败: %v", err)
+	}
+}
+
+// text 返回记录的文本格式，与转发日志原有的格式保持一致。
+func (e accessEntry) text() string {
+	method := e.Method
+	if e.Listener == ListenerSOCKS {
+		method = "SOCKS5 " + method
+	}
+	target := e.URL
+	if target == "" {
+		target = e.Host
+	}
+	if e.Proxy == "" {
+		return fmt.Sprintf("%s %s 失败（%d）: %s", method, target, e.Status, e.Error)
+	}
+	line := fmt.Sprintf("%s %s -> 代理: %s%s，状态 %d，发送 %d 字节，接收 %d 字节，耗时 %dms",
+		method, target, e.Proxy, labelSuffix(e.Label), e.Status, e.BytesIn, e.BytesOut, e.DurationMs)
+	if e.Error != "" {
+		line += "，错误: " + e.Error
+	}
+	return line
+}
+
+// recordTunnel 隧道关闭后输出访问日志，字节数取自隧道记录，转发错误取第一个。
+//
+// 参数：
+//   - entry: 已填入请求方、目标和代理的访问日志记录
+//   - t: 隧道记录
+//   - start: 开始选择代理的时间
+func (l *accessLog) recordTunnel(entry accessEntry, t *tunnel, start time.Time) {
+	entry.Status = http.StatusOK
+	entry.BytesIn = t.sent.Load()
+	entry.BytesOut = t.received.Load()
+	entry.Label = t.label
+	if errs := t.errors(); len(errs) > 0 {
+		entry.Error = errs[0].Error()
+	}
+	l.record(entry, start)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// authCacheMaxEntries 按客户端IP缓存的认证结果数量上限
+const authCacheMaxEntries = 10000
+
+// authCache 按客户端IP缓存认证通过的结果。
+//
+// 同一客户端IP在TTL内再次携带相同的认证头时不再解码和比对凭据，
+// 新建连接的客户端（如不复用连接的脚本）也能跳过认证开销。只缓存认证通过的结果，
+// 失败的请求每次都会重新校验和记录。认证用户重新加载后缓存全部作废，
+// 被删除或修改了密码的用户不会继续凭缓存通过认证。
+type authCache struct {
+	ttl        time.Duration        // 缓存有效期
+	entries    map[string]time.Time // 客户端IP和认证头到过期时间
+	generation uint64               // 缓存结果对应的认证用户代数
+	mutex      sync.Mutex           // 互斥锁
+}
+
+// newAuthCache 创建按客户端IP的认证结果缓存。
+//
+// 参数：
+//   - ttl: 缓存有效期
+//
+// 返回值：
+//   - *authCache: 认证结果缓存，ttl小于等于0时为nil
+func newAuthCache(ttl time.Duration) *authCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &authCache{ttl: ttl, entries: make(map[string]time.Time)}
+}
+
+// hit 判断客户端IP最近是否用同一认证头认证通过，generation为当前认证用户的代数。
+func (c *authCache) hit(clientIP, authHeader string, generation uint64) bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset(generation)
+	expires, ok := c.entries[clientIP+" "+authHeader]
+	return ok && time.Now().Before(expires)
+}
+
+// store 记录客户端IP用认证头认证通过，缓存已满时先清理过期条目，仍然已满则不记录。
+//
+// generation为验证凭据前读取的认证用户代数，验证期间用户重新加载时不会记录。
+func (c *authCache) store(clientIP, authHeader string, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset(generation)
+	if generation < c.generation {
+		return
+	}
+
+	now := time.Now()
+	if len(c.entries) >= authCacheMaxEntries {
+		for key, expires := range c.entries {
+			if !now.Before(expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= authCacheMaxEntries {
+			return
+		}
+	}
+	c.entries[clientIP+" "+authHeader] = now.Add(c.ttl)
+}
+
+// reset 认证用户的代数变化时清空缓存，调用方需持有锁。
+//
+// 代数只会增大，较旧的代数（验证期间发生了重新加载）不会清空较新的缓存。
+func (c *authCache) reset(generation uint64) {
+	if generation > c.generation {
+		c.generation = generation
+		clear(c.entries)
+	}
+}
+
+// authorizedFrom 验证客户端的代理认证，认证通过的结果按客户端IP缓存。
+//
+// 启用允许列表免认证时，允许列表中的客户端不提供凭据也视为通过；提供了凭据时照常验证。
+//
+// 参数：
+//   - client: 客户端地址
+//   - authHeader: 认证头字符串
+//
+// 返回值：
+//   - bool: 认证是否通过
+func (s *Server) authorizedFrom(client, authHeader string) bool {
+	if authHeader == "" {
+		return s.clientIPs.BypassesAuth(client) || s.authorized(authHeader)
+	}
+	clientIP := hostOnly(client)
+	generation := s.users.Generation()
+	if s.authCache.hit(clientIP, authHeader, generation) {
+		return true
+	}
+	if !s.authorized(authHeader) {
+		return false
+	}
+	s.authCache.store(clientIP, authHeader, generation)
+	return true
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthCacheResetsOnUsersReload(t *testing.T) {
+	cache := newAuthCache(time.Minute)
+	cache.store("10.0.0.1", "Basic dTpw", 0)
+	if !cache.hit("10.0.0.1", "Basic dTpw", 0) {
+		t.Fatal("同一代数内应命中缓存")
+	}
+	if cache.hit("10.0.0.1", "Basic dTpw", 1) {
+		t.Fatal("认证用户重新加载后不应命中之前的缓存")
+	}
+	if cache.hit("10.0.0.1", "Basic dTpw", 0) {
+		t.Fatal("缓存清空后不应再命中")
+	}
+
+	// 验证凭据期间发生重新加载时，按旧代数验证的结果不能写入缓存
+	cache.store("10.0.0.2", "Basic dTpx", 0)
+	if cache.hit("10.0.0.2", "Basic dTpx", 1) {
+		t.Fatal("旧代数的验证结果不应被缓存")
+	}
+	cache.store("10.0.0.2", "Basic dTpx", 1)
+	if !cache.hit("10.0.0.2", "Basic dTpx", 1) {
+		t.Fatal("当前代数的验证结果应被缓存")
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+)
+
+// authFailureTag 认证失败记录的固定标记，便于fail2ban、crowdsec等工具匹配。
+const authFailureTag = "auth-failure"
+
+// 认证失败的原因。
+const (
+	// authFailureMissing 请求没有携带认证头
+	authFailureMissing = "missing"
+	// authFailureMalformed 认证头格式无效
+	authFailureMalformed = "malformed"
+	// authFailureInvalid 用户名或密码错误
+	authFailureInvalid = "invalid"
+)
+
+// authFailureLog 认证失败记录。
+//
+// 每次认证失败输出一行固定格式的记录：
+//
+//	2026-01-02T15:04:05Z proxyflow auth-failure client=203.0.113.7 listener=http user="bob" reason=invalid
+//
+// 字段顺序和名称保持稳定。写入独立文件时每行带UTC时间戳；
+// 写入主日志时由主日志提供时间戳。同时按监听器和原因累计失败次数。
+type authFailureLog struct {
+	w      io.Writer                   // 独立的记录文件，nil表示写入主日志
+	counts map[string]map[string]int64 // 按监听器和原因累计的失败次数
+	mutex  sync.Mutex                  // 互斥锁
+}
+
+// AuthFailureStats 自启动以来的认证失败次数。
+//
+// 未携带凭据多为客户端在收到407后重试前的首个请求，凭据错误才需要关注。
+type AuthFailureStats struct {
+	Missing   int64                       `json:"missing"`   // 未携带认证头
+	Malformed int64                       `json:"malformed"` // 认证头格式无效
+	Invalid   int64                       `json:"invalid"`   // 用户名或密码错误
+	Listeners map[string]map[string]int64 `json:"listeners"` // 按监听器和原因的失败次数
+}
+
+// newAuthFailureLog 创建认证失败记录。
+//
+// 参数：
+//   - w: 独立的记录文件，nil表示写入主日志
+//
+// 返回值：
+//   - *authFailureLog: 认证失败记录
+func newAuthFailureLog(w io.Writer) *authFailureLog {
+	return &authFailureLog{w: w, counts: make(map[string]map[string]int64)}
+}
+
+// record 记录一次认证失败。
+//
+// 参数：
+//   - client: 客户端地址
+//   - listener: 监听器名称
+//   - authHeader: 认证头字符串
+func (l *authFailureLog) record(client, listener, authHeader string) {
+	reason := authFailureInvalid
+	var username string
+	if authHeader == "" {
+		reason = authFailureMissing
+	} else if user, _, err := auth.DecodeBasicAuth(authHeader); err != nil {
+		reason = authFailureMalformed
+	} else {
+		username = user
+	}
+
+	line := fmt.Sprintf("%s client=%s listener=%s user=%s reason=%s",
+		authFailureTag, hostOnly(client), listener, strconv.Quote(username), reason)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.counts[listener] == nil {
+		l.counts[listener] = make(map[string]int64)
+	}
+	l.counts[listener][reason]++
+
+	if l.w == nil {
+		log.Printf("%s", line)
+		return
+	}
+	if _, err := fmt.Fprintf(l.w, "%s proxyflow %s\n", time.Now().UTC().Format(time.RFC3339), line); err != nil {
+		log.Printf("写入认证失败记录失败: %v", err)
+	}
+}
+
+// stats 返回累计的认证失败次数。
+func (l *authFailureLog) stats() *AuthFailureStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	stats := &AuthFailureStats{Listeners: make(map[string]map[string]int64, len(l.counts))}
+	for listener, reasons := range l.counts {
+		stats.Listeners[listener] = maps.Clone(reasons)
+		stats.Missing += reasons[authFailureMissing]
+		stats.Malformed += reasons[authFailureMalformed]
+		stats.Invalid += reasons[authFailureInvalid]
+	}
+	return stats
+}
+
+// AuthFailures 返回按原因和监听器统计的认证失败次数。
+//
+// 返回值：
+//   - *AuthFailureStats: 自启动以来的认证失败次数
+func (s *Server) AuthFailures() *AuthFailureStats {
+	return s.authFailures.stats()
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/quota"
+)
+
+// checkCap 检查并计入目标的每日请求上限。
+//
+// 达到上限时返回附带重置时间的错误，调用方应以429拒绝请求。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//   - authHeader: Proxy-Authorization头，按用户计数时用于区分用户
+//
+// 返回值：
+//   - error: 达到上限时返回包装quota.ErrCapExceeded的错误
+func (s *Server) checkCap(host, authHeader string) error {
+	if s.caps == nil {
+		return nil
+	}
+	var username string
+	if authHeader != "" {
+		username, _, _ = auth.DecodeBasicAuth(authHeader)
+	}
+	return s.caps.Allow(host, username)
+}
+
+// CapStatus 获取按目标主机的每日请求上限及当日用量。
+//
+// 返回值：
+//   - quota.Status: 规则和当日用量
+func (s *Server) CapStatus() quota.Status {
+	return s.caps.Status()
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+const (
+	// BodyHashHeader 返回响应体SHA-256校验和的响应头
+	BodyHashHeader = "X-Proxy-Body-Sha256"
+	// BodyDuplicateHeader 响应体与该目标近期的响应相同时设置的响应头
+	BodyDuplicateHeader = "X-Proxy-Body-Duplicate"
+)
+
+// hashResponse 计算不超过上限的响应体的校验和，写入响应头并计入目标主机的重复率统计。
+//
+// 相同的响应体频繁出现通常意味着目标返回了软封禁页面。计算校验和需要先读完响应体，
+// 响应体超过上限时原样转发，不设置响应头；响应体长度确定后改为按Content-Length返回。
+// 需要在改写响应体之后调用，校验和对应客户端实际收到的响应体。
+//
+// 参数：
+//   - host: 目标主机
+//   - method: 请求方法
+//   - resp: 上游响应，响应体会被替换为可重新读取的副本
+func (s *Server) hashResponse(host, method string, resp *http.Response) {
+	if s.bodyHashLimit <= 0 || method == http.MethodHead || resp.ContentLength > s.bodyHashLimit {
+		return
+	}
+	original := resp.Body
+	buf, err := io.ReadAll(io.LimitReader(original, s.bodyHashLimit+1))
+	if err != nil || int64(len(buf)) > s.bodyHashLimit {
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buf), original), Closer: original}
+		return
+	}
+	resp.Body = readCloser{Reader: bytes.NewReader(buf), Closer: original}
+	resp.ContentLength = int64(len(buf))
+	if len(buf) == 0 {
+		return
+	}
+
+	sum := sha256.Sum256(buf)
+	digest := hex.EncodeToString(sum[:])
+	resp.Header.Set(BodyHashHeader, digest)
+	if s.destinations.recordBody(host, digest) {
+		resp.Header.Set(BodyDuplicateHeader, "true")
+	}
+}
+
+// readCloser 组合读取与关闭，用于替换已部分读取的响应体。
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
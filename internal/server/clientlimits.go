@@ -0,0 +1,320 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+)
+
+const (
+	// maxClientLimitEntries 最多记录多少个客户端IP和用户的限流状态，超出时清理空闲的记录
+	maxClientLimitEntries = 10000
+	// maxClientLimitStats 管理API最多返回多少个客户端的限流状态
+	maxClientLimitStats = 100
+)
+
+// ErrClientLimited 客户端IP或用户的请求速率或并发连接数已达上限
+var ErrClientLimited = errors.New("客户端请求过于频繁")
+
+// ClientLimitOptions 按客户端IP和认证用户的限流配置。
+type ClientLimitOptions struct {
+	IPRate          float64       // 单个客户端IP每秒允许的请求数，0表示不限制
+	IPConnections   int           // 单个客户端IP同时进行的请求和隧道数上限，0表示不限制
+	UserRate        float64       // 单个用户每秒允许的请求数，0表示不限制
+	UserConnections int           // 单个用户同时进行的请求和隧道数上限，0表示不限制
+	Burst           int           // 允许的突发请求数，小于1时按1处理
+	MaxWait         time.Duration // 超过速率时最多延迟多久再处理，0表示直接拒绝
+}
+
+// ClientLimitError 客户端被限流的原因。
+type ClientLimitError struct {
+	Client string        // 被限流的客户端IP或用户名
+	Reason string        // 触及的上限
+	Wait   time.Duration // 令牌可用前需要等待的时间，并发数超限时为0
+}
+
+// Error 返回错误描述。
+func (e *ClientLimitError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrClientLimited, e.Client, e.Reason)
+}
+
+// Unwrap 返回ErrClientLimited。
+func (e *ClientLimitError) Unwrap() error {
+	return ErrClientLimited
+}
+
+// RetryAfter 返回建议的重试等待时间。
+func (e *ClientLimitError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+// ClientLimitStats 按客户端IP和用户的限流统计。
+type ClientLimitStats struct {
+	Delayed  int64              `json:"delayed"`  // 超过速率后被延迟处理的请求数
+	Rejected int64              `json:"rejected"` // 被拒绝的请求数
+	Clients  []ClientLimitEntry `json:"clients"`  // 进行中请求最多的客户端
+}
+
+// ClientLimitEntry 单个客户端IP或用户的限流状态。
+type ClientLimitEntry struct {
+	Client   string  `json:"client"`    // 客户端，格式为 ip:<地址> 或 user:<用户名>
+	InFlight int     `json:"in_flight"` // 进行中的请求和隧道数
+	Tokens   float64 `json:"tokens"`    // 当前可用的令牌数，为负数表示已预约的未来令牌
+}
+
+// clientBucket 单个客户端IP或用户的令牌桶和并发计数。
+type clientBucket struct {
+	tokens   float64   // 当前令牌数，为负数表示已预约的未来令牌
+	last     time.Time // 上次更新令牌数的时间
+	inflight int       // 进行中的请求和隧道数
+}
+
+// clientScope 一类客户端（IP或用户）的上限。
+type clientScope struct {
+	rate  float64 // 每秒补充的令牌数，0表示不限制速率
+	conns int     // 并发数上限，0表示不限制
+}
+
+// limited 判断该类客户端是否设置了任意上限。
+func (c clientScope) limited() bool {
+	return c.rate > 0 || c.conns > 0
+}
+
+// clientLimiter 按客户端IP和认证用户限制请求速率和并发连接数。
+//
+// 每个客户端IP和每个用户各有一个令牌桶及并发计数，两者都满足时才准入，
+// 使单个客户端的突发请求不会占满全部容量而挤占其他客户端。
+// 速率超限时在 MaxWait 之内预约未来的令牌并延迟处理，超出后以429拒绝。
+type clientLimiter struct {
+	opts     ClientLimitOptions       // 配置
+	ip       clientScope              // 按客户端IP的上限
+	user     clientScope              // 按用户的上限
+	burst    float64                  // 令牌桶容量
+	buckets  map[string]*clientBucket // 客户端到限流状态的映射
+	delayed  atomic.Int64             // 被延迟处理的请求数
+	rejected atomic.Int64             // 被拒绝的请求数
+	mutex    sync.Mutex               // 互斥锁
+}
+
+// newClientLimiter 创建按客户端IP和用户的限流器。
+//
+// 参数：
+//   - opts: 限流配置
+//
+// 返回值：
+//   - *clientLimiter: 限流器，没有设置任何上限时为nil
+func newClientLimiter(opts ClientLimitOptions) *clientLimiter {
+	l := &clientLimiter{
+		opts:    opts,
+		ip:      clientScope{rate: max(opts.IPRate, 0), conns: max(opts.IPConnections, 0)},
+		user:    clientScope{rate: max(opts.UserRate, 0), conns: max(opts.UserConnections, 0)},
+		burst:   float64(max(opts.Burst, 1)),
+		buckets: make(map[string]*clientBucket),
+	}
+	if !l.ip.limited() && !l.user.limited() {
+		return nil
+	}
+	return l
+}
+
+// enabled 判断是否启用了按客户端的限流。
+func (l *clientLimiter) enabled() bool {
+	return l != nil
+}
+
+// bucket 返回客户端的限流状态并补充令牌，不存在时创建，调用方需持有锁。
+func (l *clientLimiter) bucket(key string, scope clientScope, now time.Time) *clientBucket {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		l.cleanup(now)
+		bucket = &clientBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	if scope.rate > 0 {
+		bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*scope.rate)
+	}
+	bucket.last = now
+	return bucket
+}
+
+// cleanup 记录数达到上限时清理没有进行中请求且令牌已填满的记录，调用方需持有锁。
+func (l *clientLimiter) cleanup(now time.Time) {
+	if len(l.buckets) < maxClientLimitEntries {
+		return
+	}
+	rate := max(l.ip.rate, l.user.rate)
+	for key, bucket := range l.buckets {
+		if bucket.inflight == 0 && (rate == 0 || bucket.tokens+now.Sub(bucket.last).Seconds()*rate >= l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// check 检查客户端是否还能发起一个请求，调用方需持有锁。
+//
+// 返回值：
+//   - time.Duration: 令牌可用前需要等待的时间
+//   - error: 超过上限时返回 *ClientLimitError
+func (l *clientLimiter) check(client string, bucket *clientBucket, scope clientScope) (time.Duration, error) {
+	if scope.conns > 0 && bucket.inflight >= scope.conns {
+		return 0, &ClientLimitError{Client: client, Reason: fmt.Sprintf("同时进行的请求数已达上限 %d", scope.conns)}
+	}
+	if scope.rate == 0 || bucket.tokens >= 1 {
+		return 0, nil
+	}
+	wait := time.Duration((1 - bucket.tokens) / scope.rate * float64(time.Second))
+	if wait > l.opts.MaxWait {
+		return wait, &ClientLimitError{Client: client, Reason: fmt.Sprintf("请求速率超过每秒 %g 次", scope.rate), Wait: wait}
+	}
+	return wait, nil
+}
+
+// admit 为客户端IP和用户的一个请求或隧道预约令牌并登记并发数。
+//
+// 参数：
+//   - ip: 客户端IP
+//   - username: 认证用户名，为空时只按客户端IP限流
+//
+// 返回值：
+//   - func(): 请求或隧道结束时调用的释放函数，可重复调用；被拒绝时为nil
+//   - time.Duration: 处理请求前需要延迟的时间
+//   - error: 超过上限时返回 *ClientLimitError，此时不占用令牌和并发数
+func (l *clientLimiter) admit(ip, username string) (func(), time.Duration, error) {
+	if !l.enabled() {
+		return func() {}, 0, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	type scoped struct {
+		bucket *clientBucket
+		scope  clientScope
+	}
+	now := time.Now()
+	var targets []scoped
+	var wait time.Duration
+	add := func(key, client string, scope clientScope) error {
+		bucket := l.bucket(key, scope, now)
+		delay, err := l.check(client, bucket, scope)
+		if err != nil {
+			return err
+		}
+		wait = max(wait, delay)
+		targets = append(targets, scoped{bucket: bucket, scope: scope})
+		return nil
+	}
+	if l.ip.limited() && ip != "" {
+		if err := add("ip:"+ip, ip, l.ip); err != nil {
+			l.rejected.Add(1)
+			return nil, 0, err
+		}
+	}
+	if l.user.limited() && username != "" {
+		if err := add("user:"+username, "用户 "+username, l.user); err != nil {
+			l.rejected.Add(1)
+			return nil, 0, err
+		}
+	}
+
+	// 两类上限都满足后才占用令牌和并发数
+	for _, t := range targets {
+		if t.scope.rate > 0 {
+			t.bucket.tokens--
+		}
+		t.bucket.inflight++
+	}
+	if wait > 0 {
+		l.delayed.Add(1)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			for _, t := range targets {
+				if t.bucket.inflight > 0 {
+					t.bucket.inflight--
+				}
+			}
+		})
+	}, wait, nil
+}
+
+// stats 返回限流统计。
+//
+// 返回值：
+//   - *ClientLimitStats: 限流统计，未启用时为nil
+func (l *clientLimiter) stats() *ClientLimitStats {
+	if !l.enabled() {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	stats := &ClientLimitStats{
+		Delayed:  l.delayed.Load(),
+		Rejected: l.rejected.Load(),
+		Clients:  make([]ClientLimitEntry, 0, min(len(l.buckets), maxClientLimitStats)),
+	}
+	for key, bucket := range l.buckets {
+		stats.Clients = append(stats.Clients, ClientLimitEntry{Client: key, InFlight: bucket.inflight, Tokens: bucket.tokens})
+	}
+	sort.Slice(stats.Clients, func(i, j int) bool {
+		if stats.Clients[i].InFlight != stats.Clients[j].InFlight {
+			return stats.Clients[i].InFlight > stats.Clients[j].InFlight
+		}
+		if stats.Clients[i].Tokens != stats.Clients[j].Tokens {
+			return stats.Clients[i].Tokens < stats.Clients[j].Tokens
+		}
+		return stats.Clients[i].Client < stats.Clients[j].Client
+	})
+	if len(stats.Clients) > maxClientLimitStats {
+		stats.Clients = stats.Clients[:maxClientLimitStats]
+	}
+	return stats
+}
+
+// ClientLimits 返回按客户端IP和用户的限流统计。
+//
+// 返回值：
+//   - *ClientLimitStats: 限流统计，未启用时为nil
+func (s *Server) ClientLimits() *ClientLimitStats {
+	return s.clientLimits.stats()
+}
+
+// admitClient 按客户端IP和认证用户限流，速率超限但在最长延迟之内时等待令牌可用后返回。
+//
+// 参数：
+//   - remoteAddr: 客户端地址（host:port）
+//   - authHeader: Proxy-Authorization头，用于确定用户
+//
+// 返回值：
+//   - func(): 请求或隧道结束时调用的释放函数；被拒绝时为nil
+//   - error: 超过上限时返回 *ClientLimitError，调用方应以429拒绝请求
+func (s *Server) admitClient(remoteAddr, authHeader string) (func(), error) {
+	if !s.clientLimits.enabled() {
+		return func() {}, nil
+	}
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	var username string
+	if authHeader != "" {
+		username, _, _ = auth.DecodeBasicAuth(authHeader)
+	}
+	release, wait, err := s.clientLimits.admit(ip, username)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return release, nil
+}
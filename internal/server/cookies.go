@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+)
+
+// sessionJar 单个粘性会话的Cookie Jar。
+type sessionJar struct {
+	jar      *cookiejar.Jar // 会话的Cookie
+	lastUsed time.Time      // 最近一次使用时间
+}
+
+// cookieJars 按粘性会话保存的服务端Cookie Jar。
+//
+// 不自行管理Cookie的客户端（如简单的抓取脚本）携带 X-Proxy-Session 时，
+// 代理替它保存目标下发的Cookie并在后续请求中带上，使目标看到一致的会话。
+// 只对匹配目标模式的主机生效，会话空闲超过TTL或被轮换后丢弃。
+type cookieJars struct {
+	hosts hostmatch.List         // 启用Cookie Jar的目标模式
+	ttl   time.Duration          // 会话空闲过期时间，小于等于0表示不过期
+	jars  map[string]*sessionJar // 按会话ID索引的Cookie Jar
+	mutex sync.Mutex             // 互斥锁
+}
+
+// newCookieJars 创建按会话的Cookie Jar存储。
+//
+// 参数：
+//   - hosts: 启用Cookie Jar的目标模式
+//   - ttl: 会话空闲过期时间
+//
+// 返回值：
+//   - *cookieJars: Cookie Jar存储，没有目标模式时为nil
+func newCookieJars(hosts hostmatch.List, ttl time.Duration) *cookieJars {
+	if len(hosts) == 0 {
+		return nil
+	}
+	return &cookieJars{hosts: hosts, ttl: ttl, jars: make(map[string]*sessionJar)}
+}
+
+// jarFor 返回会话访问目标时使用的Cookie Jar。
+//
+// 参数：
+//   - session: 粘性会话ID
+//   - host: 目标主机名
+//
+// 返回值：
+//   - http.CookieJar: 会话的Cookie Jar，未携带会话或目标不匹配时为nil
+func (c *cookieJars) jarFor(session, host string) http.CookieJar {
+	if c == nil || session == "" || !c.matches(host) {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if entry, ok := c.jars[session]; ok && !c.expired(entry, now) {
+		entry.lastUsed = now
+		return entry.jar
+	}
+	for id, entry := range c.jars {
+		if c.expired(entry, now) {
+			delete(c.jars, id)
+		}
+	}
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	c.jars[session] = &sessionJar{jar: jar, lastUsed: now}
+	return jar
+}
+
+// remove 丢弃会话的Cookie，会话轮换出口后目标应看到新的会话。
+//
+// 返回值：
+//   - bool: 会话是否保存过Cookie Jar
+func (c *cookieJars) remove(session string) bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, ok := c.jars[session]
+	delete(c.jars, session)
+	return ok
+}
+
+// matches 判断目标主机是否启用Cookie Jar。
+func (c *cookieJars) matches(host string) bool {
+	return c.hosts.Match(hostmatch.Normalize(host))
+}
+
+// expired 判断会话是否已过期，调用方需持有锁。
+func (c *cookieJars) expired(entry *sessionJar, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(entry.lastUsed) > c.ttl
+}
+
+// addJarCookies 将Cookie Jar中的Cookie加入请求，客户端自带的同名Cookie优先。
+func addJarCookies(req *http.Request, jar http.CookieJar) {
+	if jar == nil {
+		return
+	}
+	sent := make(map[string]bool)
+	for _, cookie := range req.Cookies() {
+		sent[cookie.Name] = true
+	}
+	for _, cookie := range jar.Cookies(req.URL) {
+		if !sent[cookie.Name] {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+// storeJarCookies 保存响应下发的Cookie，Set-Cookie仍原样转发给客户端。
+func storeJarCookies(jar http.CookieJar, req *http.Request, resp *http.Response) {
+	if jar == nil {
+		return
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		jar.SetCookies(req.URL, cookies)
+	}
+}
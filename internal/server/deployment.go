@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/pool"
+)
+
+// 代理池在蓝绿切换中的角色
+const (
+	RoleActive    = "active"    // 承载主代理池流量的代理池
+	RoleCandidate = "candidate" // 正在试运行的候选代理池
+)
+
+// errNoCandidate 没有正在试运行的候选代理池
+var errNoCandidate = errors.New("没有正在试运行的候选代理池")
+
+// DeploymentPool 蓝绿切换中单个代理池的对比数据。
+type DeploymentPool struct {
+	Name         string  `json:"name"`           // 代理池名称
+	Role         string  `json:"role"`           // 角色：active或candidate
+	Requests     int64   `json:"requests"`       // 试运行以来承载的请求数
+	Failures     int64   `json:"failures"`       // 其中连接上游失败的请求数
+	SuccessRate  float64 `json:"success_rate"`   // 成功率，没有请求时为1
+	AvgLatencyMs float64 `json:"avg_latency_ms"` // 成功请求连接上游的平均耗时（毫秒）
+	HealthyRatio float64 `json:"healthy_ratio"`  // 健康代理的比例
+}
+
+// DeploymentStatus 蓝绿切换状态。
+type DeploymentStatus struct {
+	Active    string           `json:"active"`              // 承载主代理池流量的代理池
+	Candidate string           `json:"candidate,omitempty"` // 正在试运行的候选代理池
+	Percent   int              `json:"percent"`             // 分给候选代理池的流量百分比
+	Since     *time.Time       `json:"since,omitempty"`     // 开始试运行的时间
+	Pools     []DeploymentPool `json:"pools"`               // 两个代理池的对比数据
+}
+
+// deploymentStats 单个代理池在试运行期间的计数。
+type deploymentStats struct {
+	requests int64         // 请求数
+	failures int64         // 失败数
+	latency  time.Duration // 成功请求的累计耗时
+}
+
+// deployment 主代理池流量的蓝绿切换。
+//
+// 原本使用主代理池的流量按百分比分给候选代理池试运行，对比两者的成功率、延迟和健康度后，
+// 提升候选代理池承载全部流量或回滚。提升只在内存中生效，重启后恢复为配置的主代理池。
+type deployment struct {
+	active    string                      // 承载主代理池流量的代理池
+	candidate string                      // 候选代理池，为空表示没有试运行
+	percent   int                         // 分给候选代理池的流量百分比
+	since     time.Time                   // 开始试运行的时间
+	stats     map[string]*deploymentStats // 按代理池名称的计数
+	mutex     sync.Mutex                  // 互斥锁
+}
+
+// newDeployment 创建蓝绿切换状态，初始时主代理池承载全部流量。
+func newDeployment() *deployment {
+	return &deployment{active: pool.DefaultPoolName, stats: make(map[string]*deploymentStats)}
+}
+
+// pick 为原本使用主代理池的请求选择代理池。
+//
+// 返回值：
+//   - string: 代理池名称
+func (d *deployment) pick() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.candidate != "" && rand.IntN(100) < d.percent {
+		return d.candidate
+	}
+	return d.active
+}
+
+// split 返回当前的流量分配。
+//
+// 返回值：
+//   - string: 承载主代理池流量的代理池
+//   - string: 候选代理池，为空表示没有试运行
+//   - int: 分给候选代理池的流量百分比
+func (d *deployment) split() (string, string, int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.active, d.candidate, d.percent
+}
+
+// observe 记录一次请求的结果，只统计试运行中的两个代理池。
+//
+// 参数：
+//   - name: 代理池名称
+//   - latency: 连接上游的耗时
+//   - err: 连接上游的错误，成功时为nil
+func (d *deployment) observe(name string, latency time.Duration, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.candidate == "" || (name != d.active && name != d.candidate) {
+		return
+	}
+	stats := d.stats[name]
+	if stats == nil {
+		stats = &deploymentStats{}
+		d.stats[name] = stats
+	}
+	stats.requests++
+	if err != nil {
+		stats.failures++
+	} else {
+		stats.latency += latency
+	}
+}
+
+// stage 开始或调整候选代理池的试运行，更换候选代理池时清空计数。
+func (d *deployment) stage(candidate string, percent int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if candidate != d.candidate {
+		d.candidate = candidate
+		d.since = time.Now()
+		d.stats = make(map[string]*deploymentStats)
+	}
+	d.percent = percent
+}
+
+// finish 结束试运行，promote为true时候选代理池改为承载全部流量。
+//
+// 返回值：
+//   - string: 结束前的候选代理池
+//   - error: 没有正在试运行的候选代理池时返回errNoCandidate
+func (d *deployment) finish(promote bool) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.candidate == "" {
+		return "", errNoCandidate
+	}
+	candidate := d.candidate
+	if promote {
+		d.active = candidate
+	}
+	d.candidate = ""
+	d.percent = 0
+	d.since = time.Time{}
+	d.stats = make(map[string]*deploymentStats)
+	return candidate, nil
+}
+
+// StageCandidate 开始或调整候选代理池的试运行。
+//
+// 原本使用主代理池的流量中，percent对应的比例改用候选代理池；其他代理池的流量不受影响。
+//
+// 参数：
+//   - name: 候选代理池名称
+//   - percent: 分给候选代理池的流量百分比（0-100）
+//
+// 返回值：
+//   - DeploymentStatus: 调整后的状态
+//   - error: 代理池不存在、与当前代理池相同或百分比无效时返回错误
+func (s *Server) StageCandidate(name string, percent int) (DeploymentStatus, error) {
+	if percent < 0 || percent > 100 {
+		return DeploymentStatus{}, errors.New("percent 必须在0到100之间")
+	}
+	if s.poolByName(name) == nil {
+		return DeploymentStatus{}, fmt.Errorf("代理池 %s 不存在", name)
+	}
+	if active, _, _ := s.deployment.split(); name == active {
+		return DeploymentStatus{}, fmt.Errorf("代理池 %s 已在承载全部流量", name)
+	}
+	s.deployment.stage(name, percent)
+	log.Printf("候选代理池 %s 开始试运行，分配 %d%% 的主代理池流量", name, percent)
+	return s.DeploymentStatus(), nil
+}
+
+// PromoteCandidate 提升候选代理池，由其承载全部主代理池流量。
+//
+// 返回值：
+//   - DeploymentStatus: 提升后的状态
+//   - error: 没有正在试运行的候选代理池时返回错误
+func (s *Server) PromoteCandidate() (DeploymentStatus, error) {
+	candidate, err := s.deployment.finish(true)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+	log.Printf("候选代理池 %s 已提升为承载全部主代理池流量", candidate)
+	return s.DeploymentStatus(), nil
+}
+
+// RollbackCandidate 结束候选代理池的试运行，流量全部回到当前代理池。
+//
+// 返回值：
+//   - DeploymentStatus: 回滚后的状态
+//   - error: 没有正在试运行的候选代理池时返回错误
+func (s *Server) RollbackCandidate() (DeploymentStatus, error) {
+	candidate, err := s.deployment.finish(false)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+	log.Printf("候选代理池 %s 的试运行已回滚", candidate)
+	return s.DeploymentStatus(), nil
+}
+
+// DeploymentStatus 获取蓝绿切换状态，试运行期间包含两个代理池的对比数据。
+//
+// 返回值：
+//   - DeploymentStatus: 蓝绿切换状态
+func (s *Server) DeploymentStatus() DeploymentStatus {
+	d := s.deployment
+	d.mutex.Lock()
+	status := DeploymentStatus{Active: d.active, Candidate: d.candidate, Percent: d.percent, Pools: []DeploymentPool{}}
+	roles := []string{RoleActive}
+	names := []string{d.active}
+	if d.candidate != "" {
+		since := d.since
+		status.Since = &since
+		roles = append(roles, RoleCandidate)
+		names = append(names, d.candidate)
+	}
+	for i, name := range names {
+		p := DeploymentPool{Name: name, Role: roles[i], SuccessRate: 1}
+		if stats := d.stats[name]; stats != nil {
+			p.Requests, p.Failures = stats.requests, stats.failures
+			p.SuccessRate = float64(stats.requests-stats.failures) / float64(stats.requests)
+			if succeeded := stats.requests - stats.failures; succeeded > 0 {
+				p.AvgLatencyMs = float64(stats.latency) / float64(succeeded) / float64(time.Millisecond)
+			}
+		}
+		status.Pools = append(status.Pools, p)
+	}
+	d.mutex.Unlock()
+
+	for i := range status.Pools {
+		status.Pools[i].HealthyRatio = s.healthyRatio(status.Pools[i].Name)
+	}
+	return status
+}
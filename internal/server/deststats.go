@@ -0,0 +1,239 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DestinationStats 单个目标主机的统计，计数随时间按半衰期衰减。
+type DestinationStats struct {
+	Host          string  `json:"host"`           // 目标主机
+	Requests      float64 `json:"requests"`       // 请求数（含CONNECT隧道）
+	Failures      float64 `json:"failures"`       // 失败数
+	SuccessRate   float64 `json:"success_rate"`   // 成功率
+	AvgLatencyMs  float64 `json:"avg_latency_ms"` // 平均建立延迟（毫秒）
+	BytesSent     float64 `json:"bytes_sent"`     // 发往目标的字节数
+	BytesReceived float64 `json:"bytes_received"` // 从目标收到的字节数
+
+	HashedResponses    float64 `json:"hashed_responses,omitempty"`    // 计算了响应体校验和的响应数
+	DuplicateResponses float64 `json:"duplicate_responses,omitempty"` // 响应体与近期响应相同的响应数
+	DuplicateRate      float64 `json:"duplicate_rate,omitempty"`      // 重复响应体的比例
+}
+
+// maxRecentBodies 每个目标主机保留的近期响应体校验和数量
+const maxRecentBodies = 256
+
+// destEntry 目标主机的衰减计数。
+type destEntry struct {
+	requests      float64   // 请求数
+	failures      float64   // 失败数
+	latencyMs     float64   // 成功请求的延迟总和（毫秒）
+	successes     float64   // 成功请求数，用于计算平均延迟
+	bytesSent     float64   // 发送字节数
+	bytesReceived float64   // 接收字节数
+	hashed        float64   // 计算了响应体校验和的响应数
+	duplicates    float64   // 响应体重复的响应数
+	updated       time.Time // 最近一次衰减的时间
+
+	bodies     map[string]struct{} // 近期响应体的校验和
+	bodyOrder  []string            // 校验和的登记顺序，环形保存
+	bodyCursor int                 // 下一个被替换的位置
+}
+
+// decay 将计数按距上次更新的时长衰减到当前时间。
+func (e *destEntry) decay(now time.Time, halfLife time.Duration) {
+	if halfLife > 0 {
+		factor := math.Exp2(-float64(now.Sub(e.updated)) / float64(halfLife))
+		e.requests *= factor
+		e.failures *= factor
+		e.latencyMs *= factor
+		e.successes *= factor
+		e.bytesSent *= factor
+		e.bytesReceived *= factor
+		e.hashed *= factor
+		e.duplicates *= factor
+	}
+	e.updated = now
+}
+
+// destinationTracker 按目标主机聚合请求统计。
+//
+// 计数按半衰期指数衰减，近期流量权重更高；登记的主机数超过上限时
+// 淘汰衰减后请求数最少的主机，避免大量一次性目标占满内存。
+type destinationTracker struct {
+	halfLife time.Duration         // 计数半衰期，0表示不衰减
+	maxHosts int                   // 最多跟踪的主机数
+	entries  map[string]*destEntry // 按主机索引的统计
+	mutex    sync.Mutex            // 互斥锁
+}
+
+// newDestinationTracker 创建目标主机统计器。
+func newDestinationTracker(halfLife time.Duration, maxHosts int) *destinationTracker {
+	return &destinationTracker{
+		halfLife: halfLife,
+		maxHosts: maxHosts,
+		entries:  make(map[string]*destEntry),
+	}
+}
+
+// enabled 判断是否启用了目标主机统计。
+func (d *destinationTracker) enabled() bool {
+	return d.maxHosts > 0
+}
+
+// entry 返回主机的统计并衰减到当前时间，调用方需持有锁。
+func (d *destinationTracker) entry(host string, now time.Time) *destEntry {
+	e, ok := d.entries[host]
+	if ok {
+		e.decay(now, d.halfLife)
+		return e
+	}
+
+	if len(d.entries) >= d.maxHosts {
+		var victim string
+		lowest := math.Inf(1)
+		for h, other := range d.entries {
+			other.decay(now, d.halfLife)
+			if other.requests < lowest {
+				victim, lowest = h, other.requests
+			}
+		}
+		delete(d.entries, victim)
+	}
+
+	e = &destEntry{updated: now}
+	d.entries[host] = e
+	return e
+}
+
+// record 记录一次请求结果。
+//
+// 参数：
+//   - host: 目标主机
+//   - ok: 是否成功建立到目标的请求或隧道
+//   - latency: 从选择代理到收到响应（或隧道建立）的耗时
+func (d *destinationTracker) record(host string, ok bool, latency time.Duration) {
+	if !d.enabled() {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	e := d.entry(host, time.Now())
+	e.requests++
+	if !ok {
+		e.failures++
+		return
+	}
+	e.successes++
+	e.latencyMs += float64(latency) / float64(time.Millisecond)
+}
+
+// addBytes 累加与目标主机之间传输的字节数。
+func (d *destinationTracker) addBytes(host string, sent, received int64) {
+	if !d.enabled() || sent+received == 0 {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	e := d.entry(host, time.Now())
+	e.bytesSent += float64(sent)
+	e.bytesReceived += float64(received)
+}
+
+// recordBody 登记一次响应体校验和。
+//
+// 参数：
+//   - host: 目标主机
+//   - sum: 响应体校验和
+//
+// 返回值：
+//   - bool: 该目标近期是否返回过相同的响应体，未启用统计时为false
+func (d *destinationTracker) recordBody(host, sum string) bool {
+	if !d.enabled() {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	e := d.entry(host, time.Now())
+	e.hashed++
+	if _, ok := e.bodies[sum]; ok {
+		e.duplicates++
+		return true
+	}
+	if e.bodies == nil {
+		e.bodies = make(map[string]struct{})
+	}
+	if len(e.bodyOrder) < maxRecentBodies {
+		e.bodyOrder = append(e.bodyOrder, sum)
+	} else {
+		delete(e.bodies, e.bodyOrder[e.bodyCursor])
+		e.bodyOrder[e.bodyCursor] = sum
+		e.bodyCursor = (e.bodyCursor + 1) % maxRecentBodies
+	}
+	e.bodies[sum] = struct{}{}
+	return false
+}
+
+// top 返回按指定维度排序的前N个目标主机统计。
+//
+// 参数：
+//   - n: 返回数量，不大于0时返回全部
+//   - sortBy: 排序维度，可选 requests、failures、bytes、latency、duplicates
+//
+// 返回值：
+//   - []DestinationStats: 降序排列的统计列表
+func (d *destinationTracker) top(n int, sortBy string) []DestinationStats {
+	d.mutex.Lock()
+	now := time.Now()
+	result := make([]DestinationStats, 0, len(d.entries))
+	for host, e := range d.entries {
+		e.decay(now, d.halfLife)
+		stats := DestinationStats{
+			Host:          host,
+			Requests:      e.requests,
+			Failures:      e.failures,
+			BytesSent:     e.bytesSent,
+			BytesReceived: e.bytesReceived,
+
+			HashedResponses:    e.hashed,
+			DuplicateResponses: e.duplicates,
+		}
+		if e.hashed > 0 {
+			stats.DuplicateRate = e.duplicates / e.hashed
+		}
+		if e.requests > 0 {
+			stats.SuccessRate = 1 - e.failures/e.requests
+		}
+		if e.successes > 0 {
+			stats.AvgLatencyMs = e.latencyMs / e.successes
+		}
+		result = append(result, stats)
+	}
+	d.mutex.Unlock()
+
+	key := func(s DestinationStats) float64 {
+		switch sortBy {
+		case "failures":
+			return s.Failures
+		case "bytes":
+			return s.BytesSent + s.BytesReceived
+		case "latency":
+			return s.AvgLatencyMs
+		case "duplicates":
+			return s.DuplicateRate
+		default:
+			return s.Requests
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return key(result[i]) > key(result[j]) })
+
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/pool"
+)
+
+// drain 一个正在排空的上游代理。
+type drain struct {
+	pool      string      // 代理池名称
+	proxy     string      // 代理地址
+	startedAt time.Time   // 开始排空的时间
+	deadline  time.Time   // 强制关闭剩余隧道的时间，零值表示不强制关闭
+	timer     *time.Timer // 排空超时定时器
+}
+
+// DrainStatus 上游代理排空进度。
+type DrainStatus struct {
+	Pool             string     `json:"pool"`               // 代理池名称
+	Proxy            string     `json:"proxy"`              // 代理地址
+	StartedAt        time.Time  `json:"started_at"`         // 开始排空的时间
+	Deadline         *time.Time `json:"deadline,omitempty"` // 强制关闭剩余隧道的时间
+	RemainingTunnels int        `json:"remaining_tunnels"`  // 仍在使用该代理的隧道数
+	Done             bool       `json:"done"`               // 是否已排空
+}
+
+// drainSet 正在排空的上游代理，按代理池和代理地址索引。
+type drainSet struct {
+	drains map[string]*drain // 代理池名称/代理地址 到排空记录的映射
+	mutex  sync.Mutex        // 互斥锁
+}
+
+// drainKey 生成排空记录的索引。
+func drainKey(poolName, proxy string) string {
+	return poolName + "/" + proxy
+}
+
+// RemoveProxy 从代理池移除上游代理并排空其隧道。
+//
+// 移除后不再为新连接分配该代理，已建立的隧道继续服务直到自然结束；
+// 超过排空超时后仍未结束的隧道会被关闭。
+//
+// 参数：
+//   - poolName: 代理池名称，为空时表示主代理池
+//   - proxy: 代理地址（host:port格式）
+//   - timeout: 排空超时，0表示不强制关闭，为负数时使用配置的默认值
+//
+// 返回值：
+//   - DrainStatus: 排空进度
+//   - error: 代理池不存在时返回错误
+func (s *Server) RemoveProxy(poolName, proxy string, timeout time.Duration) (DrainStatus, error) {
+	if poolName == "" {
+		poolName = pool.DefaultPoolName
+	}
+	p := s.poolByName(poolName)
+	if p == nil {
+		return DrainStatus{}, fmt.Errorf("代理池 %s 不存在", poolName)
+	}
+	p.Remove(proxy)
+	if timeout < 0 {
+		timeout = s.drainTimeout
+	}
+
+	d := &drain{pool: poolName, proxy: proxy, startedAt: time.Now()}
+	if timeout > 0 {
+		d.deadline = d.startedAt.Add(timeout)
+		d.timer = time.AfterFunc(timeout, func() {
+			if closed := s.tunnels.closeProxy(proxy); closed > 0 {
+				log.Printf("代理 %s 排空超时，强制关闭剩余隧道 %d 条", proxy, closed)
+			}
+		})
+	}
+
+	s.drains.mutex.Lock()
+	if previous, ok := s.drains.drains[drainKey(poolName, proxy)]; ok && previous.timer != nil {
+		previous.timer.Stop()
+	}
+	s.drains.drains[drainKey(poolName, proxy)] = d
+	s.drains.mutex.Unlock()
+
+	status := s.drainStatus(d, s.tunnels.stats())
+	log.Printf("开始排空代理 %s，剩余隧道 %d 条", proxy, status.RemainingTunnels)
+	return status, nil
+}
+
+// RestoreProxy 恢复被移除的上游代理，取消尚未完成的排空。
+//
+// 参数：
+//   - poolName: 代理池名称，为空时表示主代理池
+//   - proxy: 代理地址（host:port格式）
+//
+// 返回值：
+//   - bool: 该代理此前是否处于移除状态
+//   - error: 代理池不存在时返回错误
+func (s *Server) RestoreProxy(poolName, proxy string) (bool, error) {
+	if poolName == "" {
+		poolName = pool.DefaultPoolName
+	}
+	p := s.poolByName(poolName)
+	if p == nil {
+		return false, fmt.Errorf("代理池 %s 不存在", poolName)
+	}
+
+	s.drains.mutex.Lock()
+	if d, ok := s.drains.drains[drainKey(poolName, proxy)]; ok {
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+		delete(s.drains.drains, drainKey(poolName, proxy))
+	}
+	s.drains.mutex.Unlock()
+
+	return p.Restore(proxy), nil
+}
+
+// Drains 获取被移除代理的排空进度。
+//
+// 返回值：
+//   - []DrainStatus: 按开始时间排序的排空进度
+func (s *Server) Drains() []DrainStatus {
+	stats := s.tunnels.stats()
+
+	s.drains.mutex.Lock()
+	result := make([]DrainStatus, 0, len(s.drains.drains))
+	for _, d := range s.drains.drains {
+		result = append(result, s.drainStatus(d, stats))
+	}
+	s.drains.mutex.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// drainStatus 根据隧道统计生成排空进度。
+func (s *Server) drainStatus(d *drain, stats TunnelStats) DrainStatus {
+	status := DrainStatus{
+		Pool:             d.pool,
+		Proxy:            d.proxy,
+		StartedAt:        d.startedAt,
+		RemainingTunnels: stats.PerProxy[d.proxy].Active,
+	}
+	if !d.deadline.IsZero() {
+		deadline := d.deadline
+		status.Deadline = &deadline
+	}
+	status.Done = status.RemainingTunnels == 0
+	return status
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/pool"
+)
+
+var (
+	// ErrDynamicPortsDisabled 没有配置可动态分配的端口范围
+	ErrDynamicPortsDisabled = errors.New("未配置动态端口范围")
+	// ErrNoFreePort 动态端口范围内没有可用的端口
+	ErrNoFreePort = errors.New("动态端口范围内没有可用的端口")
+	// ErrUnknownPool 分配动态端口时指定的代理池不存在
+	ErrUnknownPool = errors.New("代理池不存在")
+)
+
+// DynamicPort 通过管理API分配的专用代理端口。
+//
+// 端口上未指定会话的请求都使用分配时新建的粘性会话，即经由同一个出口，直到端口到期或被释放。
+type DynamicPort struct {
+	Port      string    `json:"port"`       // 监听端口
+	Session   string    `json:"session"`    // 端口绑定的粘性会话ID
+	Pool      string    `json:"pool"`       // 端口使用的代理池
+	Proxy     string    `json:"proxy"`      // 分配时会话绑定的上游代理
+	CreatedAt time.Time `json:"created_at"` // 分配时间
+	ExpiresAt time.Time `json:"expires_at"` // 到期时间，到期后关闭端口并轮换会话
+}
+
+// dynamicListener 一个已分配的动态端口。
+type dynamicListener struct {
+	info     DynamicPort  // 分配信息
+	listener net.Listener // 端口的监听器
+	timer    *time.Timer  // 到期计时器
+}
+
+// dynamicPorts 动态端口分配表。
+type dynamicPorts struct {
+	spec   string                      // 可分配的端口列表或范围，为空表示不启用
+	ports  []string                    // 解析后可分配的端口
+	ttl    time.Duration               // 未指定有效期时的默认有效期
+	active map[string]*dynamicListener // 端口到已分配端口的映射
+	mutex  sync.Mutex                  // 互斥锁
+}
+
+// newDynamicPorts 创建动态端口分配表，端口列表在代理服务器启动时解析。
+//
+// 参数：
+//   - spec: 可分配的端口列表或范围，为空表示不启用
+//   - ttl: 默认有效期
+//
+// 返回值：
+//   - *dynamicPorts: 动态端口分配表
+func newDynamicPorts(spec string, ttl time.Duration) *dynamicPorts {
+	return &dynamicPorts{spec: spec, ttl: ttl, active: make(map[string]*dynamicListener)}
+}
+
+// parse 解析可分配的端口列表。
+//
+// 返回值：
+//   - error: 端口列表格式错误
+func (d *dynamicPorts) parse() error {
+	if d.spec == "" {
+		return nil
+	}
+	ports, err := parsePorts(d.spec)
+	if err != nil {
+		return fmt.Errorf("解析动态端口范围失败: %w", err)
+	}
+	d.mutex.Lock()
+	d.ports = ports
+	d.mutex.Unlock()
+	return nil
+}
+
+// AllocatePort 分配一个专用代理端口，并为其绑定新的粘性会话。
+//
+// 从动态端口范围中选择未被占用的端口开始监听，立即在代理池中为新会话选择上游代理，
+// 该端口上的请求（未通过 X-Proxy-Session 指定其他会话时）都经由这个代理。
+// 到期后关闭端口，轮换会话并关闭其隧道。
+//
+// 参数：
+//   - poolName: 代理池名称，为空时表示主代理池
+//   - ttl: 有效期，不大于0时使用配置的默认有效期
+//
+// 返回值：
+//   - DynamicPort: 分配的端口
+//   - error: 未启用动态端口、代理池不存在、没有可用端口或选择代理失败时返回错误
+func (s *Server) AllocatePort(poolName string, ttl time.Duration) (DynamicPort, error) {
+	d := s.dynamicPorts
+	d.mutex.Lock()
+	enabled := len(d.ports) > 0
+	d.mutex.Unlock()
+	if !enabled {
+		return DynamicPort{}, ErrDynamicPortsDisabled
+	}
+	if poolName == "" {
+		poolName = pool.DefaultPoolName
+	}
+	p := s.poolByName(poolName)
+	if p == nil {
+		return DynamicPort{}, fmt.Errorf("%w: %s", ErrUnknownPool, poolName)
+	}
+	if ttl <= 0 {
+		ttl = d.ttl
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var port string
+	var listener net.Listener
+	for _, candidate := range d.ports {
+		if _, used := d.active[candidate]; used {
+			continue
+		}
+		l, err := s.listen(candidate)
+		if err != nil {
+			continue
+		}
+		port, listener = candidate, l
+		break
+	}
+	if listener == nil {
+		return DynamicPort{}, ErrNoFreePort
+	}
+
+	session := newDynamicSessionID(port)
+	proxy, err := p.Select(pool.Selection{SessionID: session})
+	if err != nil {
+		listener.Close()
+		return DynamicPort{}, fmt.Errorf("为会话 %s 选择代理失败: %w", session, err)
+	}
+
+	now := time.Now()
+	entry := &dynamicListener{
+		info: DynamicPort{
+			Port:      port,
+			Session:   session,
+			Pool:      poolName,
+			Proxy:     s.formatProxyURL(proxy),
+			CreatedAt: now,
+			ExpiresAt: now.Add(ttl),
+		},
+		listener: listener,
+	}
+	entry.timer = time.AfterFunc(ttl, func() {
+		if _, ok := s.ReleasePort(port); ok {
+			log.Printf("动态端口 %s 已到期", port)
+		}
+	})
+	d.active[port] = entry
+
+	info := connInfo{listener: ListenerHTTP, pool: poolName, session: session}
+	go s.acceptLoop(listener, "动态端口 "+port, func(conn net.Conn) {
+		info := info
+		info.start = time.Now()
+		s.serveConnection(conn, info)
+	})
+	log.Printf("已分配动态端口 %s，会话 %s 绑定到代理 %s，有效期 %v", port, session, entry.info.Proxy, ttl)
+	return entry.info, nil
+}
+
+// ReleasePort 释放动态端口：关闭监听器，轮换其会话并关闭会话的隧道。
+//
+// 参数：
+//   - port: 端口
+//
+// 返回值：
+//   - DynamicPort: 释放的端口
+//   - bool: 端口是否处于已分配状态
+func (s *Server) ReleasePort(port string) (DynamicPort, bool) {
+	d := s.dynamicPorts
+	d.mutex.Lock()
+	entry, ok := d.active[port]
+	delete(d.active, port)
+	d.mutex.Unlock()
+	if !ok {
+		return DynamicPort{}, false
+	}
+
+	entry.timer.Stop()
+	entry.listener.Close()
+	s.RotateSession(entry.info.Session)
+	return entry.info, true
+}
+
+// DynamicPorts 获取当前已分配的动态端口，按到期时间排序。
+func (s *Server) DynamicPorts() []DynamicPort {
+	d := s.dynamicPorts
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ports := make([]DynamicPort, 0, len(d.active))
+	for _, entry := range d.active {
+		ports = append(ports, entry.info)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].ExpiresAt.Before(ports[j].ExpiresAt) })
+	return ports
+}
+
+// closeDynamicPorts 关闭全部动态端口的监听器，用于关闭代理服务器。
+func (s *Server) closeDynamicPorts() {
+	d := s.dynamicPorts
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for port, entry := range d.active {
+		entry.timer.Stop()
+		entry.listener.Close()
+		delete(d.active, port)
+	}
+}
+
+// newDynamicSessionID 为动态端口生成新的会话ID，每次分配都不同，保证分配到新的出口绑定。
+func newDynamicSessionID(port string) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("dyn-%s-%s", port, hex.EncodeToString(suffix))
+}
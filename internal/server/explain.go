@@ -0,0 +1,312 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/budget"
+	"github.com/rfym21/ProxyFlow/internal/pool"
+	"github.com/rfym21/ProxyFlow/internal/routing"
+	"github.com/rfym21/ProxyFlow/internal/watchlist"
+)
+
+// ExplainRequest 路由预演的输入。
+type ExplainRequest struct {
+	URL      string // 目标URL，https按CONNECT隧道处理，http按普通代理请求处理
+	User     string // 认证用户名，为空表示匿名
+	Listener string // 监听器名称，为空时为http
+	Agent    string // 客户端代理身份，为空表示直连
+	Session  string // 粘性会话ID
+	Tags     string // 代理标签，格式与X-Proxy-Tags相同
+}
+
+// ExplainStep 路由预演中的一步。
+type ExplainStep struct {
+	Stage  string `json:"stage"`  // 步骤名称
+	Result string `json:"result"` // 结论
+}
+
+// Explanation 路由预演的结果。
+type Explanation struct {
+	Destination string                        `json:"destination"`          // 目标地址（host:port）
+	Method      string                        `json:"method"`               // 请求方式：CONNECT或HTTP
+	Allowed     bool                          `json:"allowed"`              // 请求是否会被放行
+	Status      int                           `json:"status,omitempty"`     // 被拒绝时返回的状态码
+	Label       string                        `json:"label,omitempty"`      // 请求的流量标签
+	Pool        string                        `json:"pool,omitempty"`       // 将使用的代理池，有多个候选时为空
+	Candidates  []string                      `json:"candidates,omitempty"` // 可能使用的代理池，按权重随机或轮询选择时有多个
+	Selection   map[string]pool.SelectionPlan `json:"selection,omitempty"`  // 各候选代理池内的选择过程
+	Steps       []ExplainStep                 `json:"steps"`                // 依次评估的步骤
+}
+
+// Explain 预演一次请求的路由，报告会被放行还是拒绝、使用哪个代理池和代理以及原因。
+//
+// 依次评估维护模式、访问时间段、分层设置、流量标签、可疑目标规则、每日请求上限、
+// 代理池选择（绑定、故障转移、切换计划、蓝绿切换、流量预算）和代理池内的筛选条件，与实际处理请求的顺序一致。
+// 预演不获取代理、不记录告警，也不改变故障转移和粘性会话等任何状态；
+// 按权重随机或轮询的选择无法预先确定，此时列出全部候选。
+//
+// 参数：
+//   - req: 预演的输入
+//
+// 返回值：
+//   - Explanation: 预演结果
+//   - error: URL无效时返回错误
+func (s *Server) Explain(req ExplainRequest) (Explanation, error) {
+	target, err := url.Parse(req.URL)
+	if err != nil || target.Hostname() == "" {
+		return Explanation{}, errors.New("url 必须是包含主机名的绝对URL")
+	}
+	method, port := "HTTP", "80"
+	if strings.EqualFold(target.Scheme, "https") {
+		method, port = "CONNECT", DefaultHTTPSPort
+	}
+	if target.Port() != "" {
+		port = target.Port()
+	}
+	destHost := target.Hostname()
+	e := Explanation{Destination: net.JoinHostPort(destHost, port), Method: method, Steps: []ExplainStep{}}
+	step := func(stage, format string, args ...interface{}) {
+		e.Steps = append(e.Steps, ExplainStep{Stage: stage, Result: fmt.Sprintf(format, args...)})
+	}
+	reject := func(status int) (Explanation, error) {
+		e.Status = status
+		return e, nil
+	}
+
+	if state := s.maintenance.Load(); state != nil {
+		step("maintenance", "维护模式中，返回 %d: %s", state.Status, state.Message)
+		return reject(state.Status)
+	}
+	step("maintenance", "正常服务")
+
+	switch {
+	case req.User == "":
+		step("access", "匿名请求，不检查访问时间段")
+	case len(s.access) == 0:
+		step("access", "未配置访问时间段")
+	default:
+		if err := s.access.Check(req.User, time.Now()); err != nil {
+			step("access", "拒绝: %v", err)
+			return reject(http.StatusForbidden)
+		}
+		step("access", "在允许的访问时间段内")
+	}
+
+	listener := req.Listener
+	if listener == "" {
+		listener = ListenerHTTP
+	}
+	settings := s.layers.Resolve(listener, req.User)
+	step("settings", "监听器 %s、用户 %q: 请求超时 %v，最大存活时间 %v，优先级 %s",
+		listener, req.User, settings.RequestTimeout, settings.MaxConnAge, settings.Priority)
+
+	if s.labels != nil {
+		if e.Label = s.labels.Match(req.User, listener, destHost); e.Label != "" {
+			step("label", "流量标签为 %s", e.Label)
+		} else {
+			step("label", "未命中流量标签规则")
+		}
+	}
+
+	if rule := s.watchlist.Match(destHost); rule != nil {
+		if rule.Action == watchlist.ActionBlock {
+			step("destination", "命中拦截规则 %s，拒绝", rule.Pattern)
+			return reject(http.StatusForbidden)
+		}
+		step("destination", "命中告警规则 %s，放行并记录告警", rule.Pattern)
+	} else {
+		step("destination", "未命中可疑目标规则")
+	}
+
+	if p, _ := strconv.Atoi(port); s.bypass.Peek(destHost, p) {
+		step("route", "命中直连绕过列表，不经过上游代理直接连接目标")
+		e.Pool, e.Allowed = directHost, true
+		return e, nil
+	}
+	route, routed := s.routes.Peek(destHost)
+	switch {
+	case !routed:
+		if s.routes != nil {
+			step("route", "未命中路由规则")
+		}
+	case route.Action == routing.ActionBlock:
+		step("route", "命中路由规则 %s，拒绝", route.Pattern)
+		return reject(http.StatusForbidden)
+	case route.Action == routing.ActionDirect:
+		step("route", "命中路由规则 %s，不经过上游代理直接连接目标", route.Pattern)
+		e.Pool, e.Allowed = directHost, true
+		return e, nil
+	case route.Pool != "":
+		step("route", "命中路由规则 %s，使用代理池 %s", route.Pattern, route.Pool)
+	default:
+		step("route", "命中路由规则 %s，按常规方式选择代理池", route.Pattern)
+	}
+
+	if s.caps != nil {
+		if err := s.caps.Peek(destHost, req.User); err != nil {
+			step("quota", "拒绝: %v", err)
+			return reject(http.StatusTooManyRequests)
+		}
+		step("quota", "未达到每日请求上限")
+	}
+
+	e.Candidates = s.explainPool(req.Agent, route.Pool, step)
+	if len(e.Candidates) == 0 {
+		return reject(http.StatusServiceUnavailable)
+	}
+	if len(e.Candidates) == 1 {
+		e.Pool = e.Candidates[0]
+	}
+
+	sel := s.buildSelection(destHost, map[string]string{TagsHeader: req.Tags, SessionHeader: req.Session})
+	if method == "CONNECT" {
+		sel.DestPort, _ = strconv.Atoi(port)
+	}
+	e.Selection = make(map[string]pool.SelectionPlan, len(e.Candidates))
+	for _, name := range e.Candidates {
+		p := s.poolByName(name)
+		if p == nil {
+			step("proxy", "代理池 %s 不存在", name)
+			continue
+		}
+		plan := p.Plan(sel)
+		e.Selection[name] = plan
+		if plan.SessionProxy != "" {
+			step("proxy", "代理池 %s 中会话 %s 已绑定代理 %s，直接使用", name, sel.SessionID, plan.SessionProxy)
+		} else {
+			step("proxy", "从代理池 %s 的来源获取代理，应用 %d 个筛选条件", name, len(plan.Filters))
+		}
+	}
+	e.Allowed = true
+	return e, nil
+}
+
+// explainPool 预演代理池的选择，规则与upstreamFor相同。
+//
+// 参数：
+//   - agent: 客户端代理身份
+//   - routed: 路由规则指定的代理池，为空表示没有指定
+//   - step: 记录步骤的函数
+//
+// 返回值：
+//   - []string: 可能使用的代理池，按权重随机或轮询选择时有多个，流量预算用尽且拒绝请求时为空
+func (s *Server) explainPool(agent, routed string, step func(stage, format string, args ...interface{})) []string {
+	var bound string
+	if agent != "" && routed == "" {
+		s.muxMutex.Lock()
+		if s.mux != nil {
+			bound = s.mux.opts.AgentPools[agent]
+		}
+		s.muxMutex.Unlock()
+	}
+
+	var names []string
+	switch {
+	case routed != "":
+		bound = routed
+		names = []string{bound}
+		step("pool", "路由规则指定代理池 %s，不参与切换计划", bound)
+	case bound != "":
+		names = []string{bound}
+		step("pool", "客户端代理 %s 绑定代理池 %s，不参与切换计划", agent, bound)
+	case s.failover != nil:
+		status := s.failover.Status()
+		tier := status.Tiers[status.Active]
+		names = tier.Pools
+		step("pool", "故障转移当前使用第 %d 层（健康比例 %.0f%%），层内轮流使用 %s",
+			status.Active, tier.HealthyRatio*100, strings.Join(tier.Pools, ","))
+	default:
+		rule := s.schedule.Active(time.Now())
+		if rule == nil {
+			names = []string{pool.DefaultPoolName}
+			step("pool", "没有生效的切换计划，使用主代理池")
+			break
+		}
+		items := make([]string, 0, len(rule.Pools))
+		for _, p := range rule.Pools {
+			names = append(names, p.Name)
+			items = append(items, fmt.Sprintf("%s=%d", p.Name, p.Weight))
+		}
+		step("pool", "切换计划 %02d:%02d-%02d:%02d 生效，按权重随机选择 %s",
+			rule.Start/60, rule.Start%60, rule.End/60, rule.End%60, strings.Join(items, ","))
+	}
+
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != pool.DefaultPoolName {
+			result = append(result, name)
+			continue
+		}
+		deployed := []string{name}
+		if bound == "" {
+			deployed = s.explainDeployment(step)
+		}
+		for _, name := range deployed {
+			if name == pool.DefaultPoolName {
+				name = s.explainBudget(step)
+			}
+			if name != "" && !slices.Contains(result, name) {
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
+
+// explainDeployment 预演选中主代理池时蓝绿切换的处理。
+//
+// 参数：
+//   - step: 记录步骤的函数
+//
+// 返回值：
+//   - []string: 可能使用的代理池，试运行期间包含候选代理池
+func (s *Server) explainDeployment(step func(stage, format string, args ...interface{})) []string {
+	active, candidate, percent := s.deployment.split()
+	switch {
+	case candidate != "" && percent > 0:
+		step("deployment", "候选代理池 %s 试运行中，%d%% 的主代理池流量改用候选代理池，其余使用 %s", candidate, percent, active)
+		return []string{active, candidate}
+	case active != pool.DefaultPoolName:
+		step("deployment", "代理池 %s 已提升，承载全部主代理池流量", active)
+	}
+	return []string{active}
+}
+
+// explainBudget 预演选中主代理池时流量预算的处理。
+//
+// 参数：
+//   - step: 记录步骤的函数
+//
+// 返回值：
+//   - string: 实际使用的代理池名称，请求被拒绝时为空
+func (s *Server) explainBudget(step func(stage, format string, args ...interface{})) string {
+	if !s.budget.Exhausted() {
+		if s.budget != nil {
+			step("budget", "主代理池流量预算未用尽")
+		}
+		return pool.DefaultPoolName
+	}
+	switch s.budget.Action() {
+	case budget.ActionFallback:
+		if s.fallback != nil {
+			step("budget", "主代理池流量预算已用尽，改用备用代理池")
+			return pool.FallbackPoolName
+		}
+		step("budget", "主代理池流量预算已用尽且没有备用代理池，拒绝")
+		return ""
+	case budget.ActionBlock:
+		step("budget", "主代理池流量预算已用尽，拒绝")
+		return ""
+	default:
+		step("budget", "主代理池流量预算已用尽，按策略继续使用主代理池")
+		return pool.DefaultPoolName
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// framingError 请求行、请求头或请求体长度存在歧义或格式错误。
+//
+// 代理按自己的理解重新构造请求发往上游，与上游对请求边界的理解不一致时可能被用来夹带请求（请求走私），
+// 因此这类请求一律拒绝，而不是按宽松的规则猜测。
+type framingError struct {
+	status int    // 返回给客户端的状态码
+	reason string // 拒绝原因
+}
+
+// Error 返回拒绝原因。
+func (e *framingError) Error() string {
+	return e.reason
+}
+
+// badRequest 创建以400拒绝的错误。
+func badRequest(format string, args ...any) error {
+	return &framingError{status: http.StatusBadRequest, reason: fmt.Sprintf(format, args...)}
+}
+
+// bodyTooLarge 创建请求体超过上限时以413拒绝的错误。
+func bodyTooLarge(maxBytes int64) error {
+	return &framingError{status: http.StatusRequestEntityTooLarge, reason: fmt.Sprintf("请求体超过上限 %d 字节", maxBytes)}
+}
+
+// parseRequestLine 解析并校验请求行。
+//
+// 请求行必须由单个空格分隔的方法、请求目标和协议版本组成；请求目标只能包含可见的ASCII字符，
+// 空白、控制字符和未编码的非ASCII字节会被拒绝。
+//
+// 参数：
+//   - line: 请求行，可以带有行尾的换行符
+//
+// 返回值：
+//   - string: 请求方法
+//   - string: 请求目标
+//   - string: 协议版本
+//   - error: 格式错误时返回 *framingError
+func parseRequestLine(line string) (string, string, string, error) {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 {
+		return "", "", "", badRequest("无效的请求行")
+	}
+	method, target, protocol := parts[0], parts[1], parts[2]
+	if !httpguts.ValidHeaderFieldName(method) {
+		return "", "", "", badRequest("无效的请求方法 %q", method)
+	}
+	if target == "" {
+		return "", "", "", badRequest("请求目标为空")
+	}
+	for i := 0; i < len(target); i++ {
+		if c := target[i]; c <= ' ' || c >= 0x7f {
+			return "", "", "", badRequest("请求目标包含非法字符 %q", c)
+		}
+	}
+	if protocol != "HTTP/1.1" && protocol != "HTTP/1.0" {
+		return "", "", "", badRequest("不支持的协议版本 %q", protocol)
+	}
+	return method, target, protocol, nil
+}
+
+// parseHeaderLine 解析并校验一行请求头。
+//
+// 字段名必须是合法的token（冒号前不能有空白），值不能包含控制字符；
+// 以空白开头的折行（obs-fold）会被拒绝。
+//
+// 参数：
+//   - line: 请求头行，可以带有行尾的换行符
+//
+// 返回值：
+//   - string: 小写的字段名
+//   - string: 去掉首尾空白的值
+//   - error: 格式错误时返回 *framingError
+func parseHeaderLine(line string) (string, string, error) {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if line == "" {
+		return "", "", badRequest("请求头为空")
+	}
+	if line[0] == ' ' || line[0] == '\t' {
+		return "", "", badRequest("请求头不允许折行")
+	}
+	name, value, ok := strings.Cut(line, ":")
+	if !ok || !httpguts.ValidHeaderFieldName(name) {
+		return "", "", badRequest("无效的请求头字段名 %q", name)
+	}
+	value = strings.Trim(value, " \t")
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return "", "", badRequest("请求头 %s 的值包含非法字符", name)
+	}
+	return strings.ToLower(name), value, nil
+}
+
+// requestFraming 决定请求体边界的请求头。
+type requestFraming struct {
+	contentLengths    []string // 各个 Content-Length 头的值
+	transferEncodings []string // 各个 Transfer-Encoding 头的值
+	hosts             int      // Host 头的个数
+}
+
+// observe 记录一个请求头。
+//
+// 参数：
+//   - key: 小写的字段名
+//   - value: 值
+func (f *requestFraming) observe(key, value string) {
+	switch key {
+	case "content-length":
+		f.contentLengths = append(f.contentLengths, value)
+	case "transfer-encoding":
+		f.transferEncodings = append(f.transferEncodings, value)
+	case "host":
+		f.hosts++
+	}
+}
+
+// body 按请求头确定请求体的长度。
+//
+// 同时出现 Content-Length 和 Transfer-Encoding、出现多个 Content-Length 或 Host、
+// Content-Length 不是十进制数字时返回400；Transfer-Encoding 只支持单独的 chunked，其余返回501；
+// Content-Length 超过请求体上限时返回413。
+//
+// 参数：
+//   - maxBytes: 请求体的最大字节数，0表示不限制
+//
+// 返回值：
+//   - int64: Content-Length 指定的请求体长度，没有请求体或分块传输时为0
+//   - bool: 请求体是否为分块传输
+//   - error: 请求体边界存在歧义或不受支持时返回 *framingError
+func (f *requestFraming) body(maxBytes int64) (int64, bool, error) {
+	switch {
+	case f.hosts > 1:
+		return 0, false, badRequest("请求包含多个 Host 头")
+	case len(f.contentLengths) > 0 && len(f.transferEncodings) > 0:
+		return 0, false, badRequest("请求同时包含 Content-Length 和 Transfer-Encoding")
+	case len(f.contentLengths) > 1:
+		return 0, false, badRequest("请求包含多个 Content-Length 头")
+	case len(f.transferEncodings) > 0:
+		var codings []string
+		for _, value := range f.transferEncodings {
+			for _, coding := range strings.Split(value, ",") {
+				codings = append(codings, strings.ToLower(strings.Trim(coding, " \t")))
+			}
+		}
+		if len(codings) != 1 || codings[0] != "chunked" {
+			return 0, false, &framingError{
+				status: http.StatusNotImplemented,
+				reason: fmt.Sprintf("不支持的 Transfer-Encoding: %s", strings.Join(f.transferEncodings, ", ")),
+			}
+		}
+		return 0, true, nil
+	case len(f.contentLengths) == 1:
+		value := f.contentLengths[0]
+		if value == "" || strings.TrimLeft(value, "0123456789") != "" {
+			return 0, false, badRequest("无效的 Content-Length: %q", value)
+		}
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, false, badRequest("无效的 Content-Length: %q", value)
+		}
+		if maxBytes > 0 && length > maxBytes {
+			return 0, false, bodyTooLarge(maxBytes)
+		}
+		return length, false, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+// readChunkedBody 读取分块传输的请求体及其后的trailer。
+//
+// 解码后的请求体超过上限时立即停止读取，不会把整个请求体读入内存；
+// trailer 计入请求头的大小和数量上限，读取后丢弃。
+//
+// 参数：
+//   - reader: 请求头读取器，读取位置在请求体开头
+//   - maxBytes: 请求体的最大字节数，0表示不限制
+//
+// 返回值：
+//   - []byte: 解码后的请求体
+//   - error: 分块格式错误、超过上限或连接中断
+func readChunkedBody(reader *headerReader, maxBytes int64) ([]byte, error) {
+	// 直接使用内嵌的 bufio.Reader，避免分块读取器另建缓冲而多读后续数据
+	var chunked io.Reader = httputil.NewChunkedReader(reader.Reader)
+	if maxBytes > 0 {
+		chunked = io.LimitReader(chunked, maxBytes+1)
+	}
+	body, err := io.ReadAll(chunked)
+	if err != nil {
+		return nil, badRequest("无效的分块请求体: %v", err)
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		return nil, bodyTooLarge(maxBytes)
+	}
+	for {
+		line, err := reader.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if line == "\r\n" || line == "\n" {
+			return body, nil
+		}
+	}
+}
+
+// rejectMalformedTCP 请求存在歧义或格式错误时记录日志并向客户端返回错误响应。
+//
+// 参数：
+//   - conn: 客户端连接
+//   - err: 解析请求的错误
+//
+// 返回值：
+//   - bool: 是否为格式错误并已返回错误响应
+func (s *Server) rejectMalformedTCP(conn net.Conn, err error) bool {
+	var framingErr *framingError
+	if !errors.As(err, &framingErr) {
+		return false
+	}
+	log.Printf("拒绝请求 %s: %v", conn.RemoteAddr(), framingErr)
+	s.sendErrorTCP(conn, framingErr.status, framingErr.Error())
+	return true
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// framingStatus 返回解析错误对应的状态码，没有错误时返回0。
+func framingStatus(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var framingErr *framingError
+	if !errors.As(err, &framingErr) {
+		t.Fatalf("错误类型应为 *framingError，实际为 %T: %v", err, err)
+	}
+	return framingErr.status
+}
+
+func TestParseRequestLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		status int
+	}{
+		{"绝对形式", "GET http://example.com/a?b=c HTTP/1.1\r\n", 0},
+		{"authority形式", "CONNECT example.com:443 HTTP/1.1\r\n", 0},
+		{"HTTP/1.0", "GET http://example.com/ HTTP/1.0\n", 0},
+		{"连续空格", "GET  http://example.com/ HTTP/1.1\r\n", http.StatusBadRequest},
+		{"目标中的空格", "GET http://example.com/a b HTTP/1.1\r\n", http.StatusBadRequest},
+		{"制表符分隔", "GET\thttp://example.com/\tHTTP/1.1\r\n", http.StatusBadRequest},
+		{"缺少协议版本", "GET http://example.com/\r\n", http.StatusBadRequest},
+		{"目标中的控制字符", "GET http://example.com/a\x01b HTTP/1.1\r\n", http.StatusBadRequest},
+		{"目标中的DEL", "GET http://example.com/\x7f HTTP/1.1\r\n", http.StatusBadRequest},
+		{"目标中的NUL", "GET http://example.com/\x00 HTTP/1.1\r\n", http.StatusBadRequest},
+		{"目标中的非ASCII", "GET http://example.com/é HTTP/1.1\r\n", http.StatusBadRequest},
+		{"CONNECT目标中的控制字符", "CONNECT example.com:443\x7f HTTP/1.1\r\n", http.StatusBadRequest},
+		{"HTTP/2.0", "GET http://example.com/ HTTP/2.0\r\n", http.StatusBadRequest},
+		{"HTTP/0.9", "GET http://example.com/ HTTP/0.9\r\n", http.StatusBadRequest},
+		{"小写协议", "GET http://example.com/ http/1.1\r\n", http.StatusBadRequest},
+		{"无效方法", "G(T http://example.com/ HTTP/1.1\r\n", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, target, protocol, err := parseRequestLine(tt.line)
+			if got := framingStatus(t, err); got != tt.status {
+				t.Fatalf("状态码 = %d，期望 %d（错误: %v）", got, tt.status, err)
+			}
+			if err == nil && (method == "" || target == "" || protocol == "") {
+				t.Fatalf("解析结果不完整: %q %q %q", method, target, protocol)
+			}
+		})
+	}
+}
+
+func TestParseHeaderLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		key    string
+		value  string
+		status int
+	}{
+		{"普通请求头", "Content-Type: text/plain\r\n", "content-type", "text/plain", 0},
+		{"值两侧的空白", "X-Test: \t value \t\r\n", "x-test", "value", 0},
+		{"空值", "X-Empty:\r\n", "x-empty", "", 0},
+		{"值中的冒号", "Host: example.com:8080\r\n", "host", "example.com:8080", 0},
+		{"空格折行", " continued\r\n", "", "", http.StatusBadRequest},
+		{"制表符折行", "\tcontinued\r\n", "", "", http.StatusBadRequest},
+		{"冒号前的空格", "Content-Length : 5\r\n", "", "", http.StatusBadRequest},
+		{"冒号前的制表符", "Transfer-Encoding\t: chunked\r\n", "", "", http.StatusBadRequest},
+		{"缺少冒号", "NoColon\r\n", "", "", http.StatusBadRequest},
+		{"空字段名", ": value\r\n", "", "", http.StatusBadRequest},
+		{"值中的NUL", "X-Test: a\x00b\r\n", "", "", http.StatusBadRequest},
+		{"值中的CR", "X-Test: a\rb\r\n", "", "", http.StatusBadRequest},
+		{"值中的控制字符", "X-Test: a\x01b\r\n", "", "", http.StatusBadRequest},
+		{"值中的DEL", "X-Test: a\x7fb\r\n", "", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, value, err := parseHeaderLine(tt.line)
+			if got := framingStatus(t, err); got != tt.status {
+				t.Fatalf("状态码 = %d，期望 %d（错误: %v）", got, tt.status, err)
+			}
+			if err == nil && (key != tt.key || value != tt.value) {
+				t.Fatalf("解析结果 = %q: %q，期望 %q: %q", key, value, tt.key, tt.value)
+			}
+		})
+	}
+}
+
+func TestRequestFramingBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  [][2]string
+		maxBytes int64
+		length   int64
+		chunked  bool
+		status   int
+	}{
+		{"没有请求体", nil, 0, 0, false, 0},
+		{"Content-Length", [][2]string{{"content-length", "5"}}, 0, 5, false, 0},
+		{"前导零", [][2]string{{"content-length", "005"}}, 0, 5, false, 0},
+		{"chunked", [][2]string{{"transfer-encoding", "chunked"}}, 0, 0, true, 0},
+		{"chunked大小写", [][2]string{{"transfer-encoding", "Chunked"}}, 0, 0, true, 0},
+		{"单个Host", [][2]string{{"host", "example.com"}}, 0, 0, false, 0},
+		{"同时有CL和TE", [][2]string{{"content-length", "5"}, {"transfer-encoding", "chunked"}}, 0, 0, false, http.StatusBadRequest},
+		{"同时有TE和CL", [][2]string{{"transfer-encoding", "chunked"}, {"content-length", "0"}}, 0, 0, false, http.StatusBadRequest},
+		{"重复的CL", [][2]string{{"content-length", "5"}, {"content-length", "6"}}, 0, 0, false, http.StatusBadRequest},
+		{"相同值的重复CL", [][2]string{{"content-length", "5"}, {"content-length", "5"}}, 0, 0, false, http.StatusBadRequest},
+		{"逗号合并的CL", [][2]string{{"content-length", "5, 5"}}, 0, 0, false, http.StatusBadRequest},
+		{"重复的Host", [][2]string{{"host", "a.example"}, {"host", "b.example"}}, 0, 0, false, http.StatusBadRequest},
+		{"带符号的CL", [][2]string{{"content-length", "+5"}}, 0, 0, false, http.StatusBadRequest},
+		{"负数CL", [][2]string{{"content-length", "-1"}}, 0, 0, false, http.StatusBadRequest},
+		{"十六进制CL", [][2]string{{"content-length", "0x10"}}, 0, 0, false, http.StatusBadRequest},
+		{"空CL", [][2]string{{"content-length", ""}}, 0, 0, false, http.StatusBadRequest},
+		{"溢出的CL", [][2]string{{"content-length", "99999999999999999999"}}, 0, 0, false, http.StatusBadRequest},
+		{"gzip, chunked", [][2]string{{"transfer-encoding", "gzip, chunked"}}, 0, 0, false, http.StatusNotImplemented},
+		{"分两行的TE", [][2]string{{"transfer-encoding", "gzip"}, {"transfer-encoding", "chunked"}}, 0, 0, false, http.StatusNotImplemented},
+		{"重复的chunked", [][2]string{{"transfer-encoding", "chunked, chunked"}}, 0, 0, false, http.StatusNotImplemented},
+		{"identity", [][2]string{{"transfer-encoding", "identity"}}, 0, 0, false, http.StatusNotImplemented},
+		{"CL超过上限", [][2]string{{"content-length", "11"}}, 10, 0, false, http.StatusRequestEntityTooLarge},
+		{"CL等于上限", [][2]string{{"content-length", "10"}}, 10, 10, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var framing requestFraming
+			for _, h := range tt.headers {
+				framing.observe(h[0], h[1])
+			}
+			length, chunked, err := framing.body(tt.maxBytes)
+			if got := framingStatus(t, err); got != tt.status {
+				t.Fatalf("状态码 = %d，期望 %d（错误: %v）", got, tt.status, err)
+			}
+			if length != tt.length || chunked != tt.chunked {
+				t.Fatalf("结果 = (%d, %v)，期望 (%d, %v)", length, chunked, tt.length, tt.chunked)
+			}
+		})
+	}
+}
+
+func TestReadChunkedBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		maxBytes int64
+		body     string
+		status   int
+	}{
+		{"两个分块", "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\nNEXT", 0, "hello world", 0},
+		{"带trailer", "5\r\nhello\r\n0\r\nX-Trailer: 1\r\n\r\nNEXT", 0, "hello", 0},
+		{"等于上限", "5\r\nhello\r\n0\r\n\r\nNEXT", 5, "hello", 0},
+		{"超过上限", "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", 8, "", http.StatusRequestEntityTooLarge},
+		{"无效的分块大小", "zz\r\nhello\r\n0\r\n\r\n", 0, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &headerReader{Reader: bufio.NewReader(strings.NewReader(tt.input))}
+			body, err := readChunkedBody(reader, tt.maxBytes)
+			if got := framingStatus(t, err); got != tt.status {
+				t.Fatalf("状态码 = %d，期望 %d（错误: %v）", got, tt.status, err)
+			}
+			if err != nil {
+				return
+			}
+			if string(body) != tt.body {
+				t.Fatalf("请求体 = %q，期望 %q", body, tt.body)
+			}
+			// 请求体和trailer读完后，连接上的下一个请求应原样保留
+			rest, _ := reader.ReadString(0)
+			if rest != "NEXT" {
+				t.Fatalf("剩余数据 = %q，期望 %q", rest, "NEXT")
+			}
+		})
+	}
+}
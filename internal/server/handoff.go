@@ -0,0 +1,37 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/update"
+)
+
+// Drain 停止接受新连接并等待活跃隧道结束，用于进程交接成功后的旧进程。
+//
+// 监听器的副本已交给新进程，关闭后新连接全部由新进程接受；
+// 已建立的隧道和keep-alive连接继续由本进程处理，直到结束或超时。
+//
+// 参数：
+//   - timeout: 最长等待时间，0表示不等待
+//
+// 返回值：
+//   - int: 超时时仍未结束的隧道数
+func (s *Server) Drain(timeout time.Duration) int {
+	s.shuttingDown.Store(true)
+	update.CloseListeners()
+
+	deadline := time.Now().Add(timeout)
+	active := s.tunnels.stats().Active
+	if active > 0 {
+		log.Printf("已停止接受新连接，等待 %d 个活跃隧道结束", active)
+	}
+	for active > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		active = s.tunnels.stats().Active
+	}
+	if active > 0 {
+		log.Printf("等待超时，仍有 %d 个活跃隧道将被关闭", active)
+	}
+	return active
+}
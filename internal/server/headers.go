@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// headerLimits 客户端请求头的大小和数量上限。
+type headerLimits struct {
+	maxBytes int // 请求行和请求头合计的最大字节数，0表示不限制
+	maxCount int // 请求头的最大数量，0表示不限制
+}
+
+// headerLimitError 请求头超过上限。
+type headerLimitError struct {
+	reason string // 超过的上限说明
+}
+
+// Error 返回带上限的错误描述。
+func (e *headerLimitError) Error() string {
+	return "请求头过大: " + e.reason
+}
+
+// headerReader 按上限读取一个请求的请求行和请求头。
+//
+// 每个请求创建一个，读取请求体时直接使用内嵌的 bufio.Reader。
+// 超过上限时立即停止读取并返回 *headerLimitError，而不是把过长的行整个读入内存。
+type headerReader struct {
+	*bufio.Reader
+	limits headerLimits // 上限
+	read   int          // 已读取的请求行和请求头字节数
+	count  int          // 已读取的请求头数量
+}
+
+// newHeaderReader 为连接上的下一个请求创建请求头读取器。
+func (s *Server) newHeaderReader(reader *bufio.Reader) *headerReader {
+	return &headerReader{Reader: reader, limits: s.headerLimits}
+}
+
+// readLine 读取一行（含换行符），累计字节数超过上限时返回 *headerLimitError。
+//
+// 返回值：
+//   - string: 读到的行，连接在行中间结束时为已读到的部分
+//   - error: 读取错误或超过上限
+func (h *headerReader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := h.ReadSlice('\n')
+		h.read += len(chunk)
+		if h.limits.maxBytes > 0 && h.read > h.limits.maxBytes {
+			return "", &headerLimitError{reason: fmt.Sprintf("请求行和请求头合计超过上限 %d 字节", h.limits.maxBytes)}
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return string(line), err
+	}
+}
+
+// readHeader 读取下一个请求头行，请求头数量超过上限时返回 *headerLimitError。
+//
+// 返回值：
+//   - string: 读到的行，空行（"\r\n" 或 "\n"）表示请求头结束
+//   - error: 读取错误或超过上限
+func (h *headerReader) readHeader() (string, error) {
+	line, err := h.readLine()
+	if err != nil || line == "\r\n" || line == "\n" {
+		return line, err
+	}
+	if h.count++; h.limits.maxCount > 0 && h.count > h.limits.maxCount {
+		return "", &headerLimitError{reason: fmt.Sprintf("请求头数量超过上限 %d 个", h.limits.maxCount)}
+	}
+	return line, nil
+}
+
+// rejectOversizedTCP 请求头超过上限时记录日志并向客户端返回431。
+//
+// 参数：
+//   - conn: 客户端连接
+//   - err: 读取请求头的错误
+//
+// 返回值：
+//   - bool: 是否为超过上限的错误并已返回431
+func (s *Server) rejectOversizedTCP(conn net.Conn, err error) bool {
+	var limitErr *headerLimitError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	log.Printf("拒绝请求 %s: %v", conn.RemoteAddr(), limitErr)
+	s.sendErrorTCP(conn, http.StatusRequestHeaderFieldsTooLarge, limitErr.Error())
+	return true
+}
+
+// checkHeaderCount 检查HTTP/2请求的请求头数量，超过上限时返回431。
+//
+// 请求头的总字节数已由HTTP服务的 MaxHeaderBytes 限制。
+//
+// 参数：
+//   - w: 响应写入器
+//   - r: 客户端请求
+//
+// 返回值：
+//   - bool: 是否在上限之内
+func (s *Server) checkHeaderCount(w http.ResponseWriter, r *http.Request) bool {
+	if s.headerLimits.maxCount <= 0 {
+		return true
+	}
+	count := 0
+	for _, values := range r.Header {
+		count += len(values)
+	}
+	if count <= s.headerLimits.maxCount {
+		return true
+	}
+	err := &headerLimitError{reason: fmt.Sprintf("请求头数量超过上限 %d 个", s.headerLimits.maxCount)}
+	log.Printf("拒绝请求 %s: %v", r.RemoteAddr, err)
+	http.Error(w, err.Error(), http.StatusRequestHeaderFieldsTooLarge)
+	return false
+}
@@ -0,0 +1,379 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/config"
+)
+
+// hopByHopHeaders 逐跳头部，只对单个连接有效，不应转发。
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Connection":    true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// serveHTTP2 处理HTTP/2连接上的代理请求。
+func (s *Server) serveHTTP2(w http.ResponseWriter, r *http.Request) {
+	s.serveStreams(w, r, ListenerTLS)
+}
+
+// serveStreams 处理HTTP/2或HTTP/3请求流上的代理请求。
+//
+// 支持四类请求：
+// - 标准CONNECT：建立到目标地址的隧道
+// - 扩展CONNECT（:protocol=websocket）：转换为HTTP/1.1 WebSocket升级请求
+// - HTTP/3上的CONNECT-UDP（:protocol=connect-udp）：在HTTP数据报与目标UDP端口之间转发
+// - 其他方法：按正向代理转发HTTP请求
+//
+// 参数：
+//   - w: 响应写入器
+//   - r: 代理请求，CONNECT-UDP请求的Host已替换为目标地址
+//   - listener: 监听器名称，用于分层配置、流量标签和日志
+func (s *Server) serveStreams(w http.ResponseWriter, r *http.Request, listener string) {
+	if s.rejectForMaintenance(w) || !s.checkHeaderCount(w, r) {
+		return
+	}
+
+	// 转换为小写键的请求头，与HTTP/1.1路径共用会话和代理选择逻辑
+	headers := make(map[string]string, len(r.Header))
+	for key, values := range r.Header {
+		if len(values) > 0 {
+			headers[strings.ToLower(key)] = values[0]
+		}
+	}
+	s.sessions.resolve(headers, r.RemoteAddr, "")
+	s.userRoutes.apply(headers)
+	if authHeader := headers["proxy-authorization"]; authHeader != "" {
+		r.Header.Set("Proxy-Authorization", authHeader)
+	}
+
+	if !s.authorizedFrom(r.RemoteAddr, r.Header.Get("Proxy-Authorization")) {
+		s.authFailures.record(r.RemoteAddr, listener, r.Header.Get("Proxy-Authorization"))
+		s.writeAuthRequired(w)
+		return
+	}
+	if err := s.checkAccess(r.Header.Get("Proxy-Authorization")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	settings := s.settingsFor(listener, r.Header.Get("Proxy-Authorization"))
+	releaseClient, err := s.admitClient(r.RemoteAddr, r.Header.Get("Proxy-Authorization"))
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	defer releaseClient()
+	release, ok := s.shedder.admit(settings.Priority)
+	if !ok {
+		http.Error(w, errOverloaded, http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+	if err := s.checkDestination(hostOnly(r.Host), r.Header.Get("Proxy-Authorization"), r.RemoteAddr); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if _, err := s.boundPool("", headers); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkCap(hostOnly(r.Host), r.Header.Get("Proxy-Authorization")); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	if err := s.checkTraffic(r.Header.Get("Proxy-Authorization")); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		switch protocol := r.Header.Get(":protocol"); {
+		case protocol == "":
+			s.serveConnectHTTP2(w, r, listener, headers, settings)
+		case listener == ListenerHTTP3 && protocol == connectUDPProtocol:
+			s.serveConnectUDP(w, r, headers, settings)
+		default:
+			s.serveExtendedConnect(w, r, listener, protocol, headers, settings)
+		}
+		return
+	}
+	s.serveForwardHTTP2(w, r, listener, headers, settings)
+}
+
+// serveConnectHTTP2 处理HTTP/2或HTTP/3标准CONNECT请求。
+//
+// 请求流本身作为隧道的客户端一侧，数据帧在流与上游连接之间双向转发。
+func (s *Server) serveConnectHTTP2(w http.ResponseWriter, r *http.Request, listener string, headers map[string]string, settings config.Settings) {
+	destAddr := withDefaultPort(r.Host, DefaultHTTPSPort)
+	destHost, destPort, _ := net.SplitHostPort(destAddr)
+	sel := s.buildSelection(destHost, headers)
+	sel.DestPort, _ = strconv.Atoi(destPort)
+	sel.Label = s.labelFor(listener, headers["proxy-authorization"], destHost)
+
+	start := time.Now()
+	entry := newAccessEntry(r.RemoteAddr, headers["proxy-authorization"], listener, http.MethodConnect)
+	entry.Host = destAddr
+	upstreamConn, proxy, err := s.dialUpstream(destAddr, sel, headers[userPoolKey], settings.RequestTimeout)
+	if err != nil {
+		entry.Status, entry.Error, entry.Label = upstreamErrorStatus(err), err.Error(), sel.Label
+		s.accessLog.record(entry, start)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer upstreamConn.Close()
+	s.recordExit(headers["proxy-authorization"], proxy.Host)
+	entry.Proxy = s.formatProxyURL(proxy)
+
+	t := newTunnel(r.Body, upstreamConn, proxy.Host, destAddr, sel.SessionID)
+	t.label = sel.Label
+	t.flow = s.openFlow(headers["proxy-authorization"])
+	s.tunnels.add(t)
+	defer s.releaseTunnel(t)
+	defer s.accessLog.recordTunnel(entry, t, start)
+
+	// 超过最大存活时间或空闲超时后关闭隧道
+	defer s.watchTunnel(t, t.startedAt, settings)()
+
+	s.stampVersion(w.Header())
+	w.WriteHeader(http.StatusOK)
+	s.pipeHTTP2(w, r.Body, upstreamConn, upstreamConn, t)
+}
+
+// serveExtendedConnect 处理HTTP/2扩展CONNECT请求（RFC 8441）或HTTP/3扩展CONNECT请求（RFC 9220）。
+//
+// 目前支持WebSocket：通过代理池连接目标后发送HTTP/1.1升级请求，
+// 升级成功后返回200，并在请求流与WebSocket连接之间转发数据。
+func (s *Server) serveExtendedConnect(w http.ResponseWriter, r *http.Request, listener, protocol string, headers map[string]string, settings config.Settings) {
+	if !strings.EqualFold(protocol, "websocket") {
+		http.Error(w, fmt.Sprintf("不支持的扩展CONNECT协议: %s", protocol), http.StatusNotImplemented)
+		return
+	}
+
+	secure := r.TLS != nil
+	defaultPort := "80"
+	if secure {
+		defaultPort = DefaultHTTPSPort
+	}
+	destAddr := withDefaultPort(r.Host, defaultPort)
+	destHost, destPort, _ := net.SplitHostPort(destAddr)
+	sel := s.buildSelection(destHost, headers)
+	sel.DestPort, _ = strconv.Atoi(destPort)
+	sel.Label = s.labelFor(listener, headers["proxy-authorization"], destHost)
+
+	start := time.Now()
+	entry := newAccessEntry(r.RemoteAddr, headers["proxy-authorization"], listener, http.MethodConnect)
+	entry.Host, entry.URL = destAddr, "ws://"+r.Host+r.URL.Path
+	if secure {
+		entry.URL = "wss://" + r.Host + r.URL.Path
+	}
+	upstreamConn, proxy, err := s.dialUpstream(destAddr, sel, headers[userPoolKey], settings.RequestTimeout)
+	if err != nil {
+		entry.Status, entry.Error, entry.Label = upstreamErrorStatus(err), err.Error(), sel.Label
+		s.accessLog.record(entry, start)
+		writeUpstreamError(w, err)
+		return
+	}
+	var targetConn net.Conn = upstreamConn
+	if secure {
+		tlsConn := tls.Client(upstreamConn, &tls.Config{ServerName: destHost, NextProtos: []string{"http/1.1"}})
+		if err := tlsConn.HandshakeContext(r.Context()); err != nil {
+			upstreamConn.Close()
+			http.Error(w, fmt.Sprintf("与目标建立TLS连接失败: %v", err), upstreamErrorStatus(err))
+			return
+		}
+		targetConn = tlsConn
+	}
+	defer targetConn.Close()
+	s.recordExit(headers["proxy-authorization"], proxy.Host)
+
+	// HTTP/2下没有Sec-WebSocket-Key，由代理为HTTP/1.1一侧生成
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	var upgrade strings.Builder
+	fmt.Fprintf(&upgrade, "GET %s HTTP/1.1\r\nHost: %s\r\n", r.URL.RequestURI(), r.Host)
+	upgrade.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(&upgrade, "Sec-WebSocket-Key: %s\r\n", base64.StdEncoding.EncodeToString(key))
+	for name, values := range r.Header {
+		if hopByHopHeaders[name] || strings.HasPrefix(name, ":") || name == "Sec-Websocket-Key" ||
+			isProxyControlHeader(strings.ToLower(name)) {
+			continue
+		}
+		for _, value := range values {
+			fmt.Fprintf(&upgrade, "%s: %s\r\n", name, value)
+		}
+	}
+	upgrade.WriteString("\r\n")
+
+	if _, err := io.WriteString(targetConn, upgrade.String()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	targetReader := bufio.NewReader(targetConn)
+	resp, err := http.ReadResponse(targetReader, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("读取WebSocket升级响应失败: %v", err), upstreamErrorStatus(err))
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		copyResponseHeaders(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	for _, name := range []string{"Sec-Websocket-Protocol", "Sec-Websocket-Extensions"} {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+
+	t := newTunnel(r.Body, targetConn, proxy.Host, destAddr, sel.SessionID)
+	t.label = sel.Label
+	t.flow = s.openFlow(headers["proxy-authorization"])
+	s.tunnels.add(t)
+	defer s.releaseTunnel(t)
+	entry.Proxy = s.formatProxyURL(proxy)
+	defer s.accessLog.recordTunnel(entry, t, start)
+	s.stampVersion(w.Header())
+	w.WriteHeader(http.StatusOK)
+	s.pipeHTTP2(w, r.Body, targetReader, targetConn, t)
+}
+
+// serveForwardHTTP2 处理HTTP/2或HTTP/3上的正向代理HTTP请求。
+func (s *Server) serveForwardHTTP2(w http.ResponseWriter, r *http.Request, listener string, headers map[string]string, settings config.Settings) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	target := scheme + "://" + r.Host + r.URL.RequestURI()
+
+	flow := s.openFlow(headers["proxy-authorization"])
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, flow.Reader(r.Body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.ContentLength = r.ContentLength
+	for name, values := range r.Header {
+		if hopByHopHeaders[name] || strings.HasPrefix(name, ":") || isProxyControlHeader(strings.ToLower(name)) {
+			continue
+		}
+		req.Header[name] = values
+	}
+	if err := s.robots.Check(r.Context(), req.URL, r.Header.Get("User-Agent")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.profiles.Select(req.URL.Hostname(), headers[ProfileHeader]).Apply(req.Header)
+
+	sel := s.buildSelection(req.URL.Hostname(), headers)
+	sel.Label = s.labelFor(listener, headers["proxy-authorization"], req.URL.Hostname())
+	jar := s.cookies.jarFor(sel.SessionID, req.URL.Hostname())
+	addJarCookies(req, jar)
+	start := time.Now()
+	entry := newAccessEntry(r.RemoteAddr, headers["proxy-authorization"], listener, r.Method)
+	entry.Host, entry.URL, entry.Label = req.URL.Host, target, sel.Label
+	resp, usedProxy, err := s.forward(req, sel, headers[userPoolKey], settings.RequestTimeout)
+	if err != nil {
+		entry.Status, entry.Error = upstreamErrorStatus(err), err.Error()
+		s.accessLog.record(entry, start)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+	s.recordExit(headers["proxy-authorization"], usedProxy.Host)
+	storeJarCookies(jar, req, resp)
+	s.rewriteResponse(req.URL.Hostname(), r.Method, resp)
+	s.hashResponse(req.URL.Hostname(), r.Method, resp)
+	s.stampVersion(resp.Header)
+
+	copyResponseHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	received, err := io.Copy(w, flow.Reader(resp.Body))
+	s.destinations.addBytes(req.URL.Hostname(), max(r.ContentLength, 0), received)
+	s.sizes.observe(req.URL.Hostname(), max(r.ContentLength, 0), received)
+	s.labels.AddBytes(sel.Label, max(r.ContentLength, 0), received)
+	entry.Proxy, entry.Status, entry.BytesIn, entry.BytesOut = s.formatProxyURL(usedProxy), resp.StatusCode, max(r.ContentLength, 0), received
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	s.accessLog.record(entry, start)
+}
+
+// pipeHTTP2 在HTTP/2请求流与上游连接之间双向转发数据。
+//
+// 参数：
+//   - w: 响应写入器，写入后立即刷新
+//   - body: 请求流
+//   - upstreamReader: 上游数据来源
+//   - upstreamWriter: 上游数据去向
+//   - t: 隧道记录
+func (s *Server) pipeHTTP2(w http.ResponseWriter, body io.Reader, upstreamReader io.Reader, upstreamWriter io.Writer, t *tunnel) {
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	go s.pump(upstreamWriter, &activityReader{r: t.flow.Reader(body), t: t, count: &t.sent})
+	s.pump(&flushWriter{w: w, rc: rc}, &activityReader{r: t.flow.Reader(upstreamReader), t: t, count: &t.received, upstream: true})
+}
+
+// flushWriter 每次写入后立即刷新的响应写入器，保证隧道数据及时送达。
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+// Write 写入数据并刷新。
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
+
+// copyResponseHeaders 复制上游响应头，跳过逐跳头部。
+func copyResponseHeaders(dst, src http.Header) {
+	for name, values := range src {
+		if hopByHopHeaders[name] {
+			continue
+		}
+		dst[name] = values
+	}
+}
+
+// withDefaultPort 为缺少端口的地址补充默认端口。
+//
+// 参数：
+//   - addr: 地址，可能不含端口
+//   - port: 默认端口
+//
+// 返回值：
+//   - string: host:port格式的地址
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
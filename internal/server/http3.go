@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+
+	"github.com/rfym21/ProxyFlow/internal/config"
+	"github.com/rfym21/ProxyFlow/internal/pool"
+)
+
+const (
+	// ListenerHTTP3 HTTP/3代理监听器名称
+	ListenerHTTP3 = "h3"
+
+	// connectUDPProtocol CONNECT-UDP（RFC 9298）扩展CONNECT的 :protocol 值
+	connectUDPProtocol = "connect-udp"
+	// masqueUDPPrefix CONNECT-UDP默认URI模板的路径前缀，之后依次为目标主机和端口
+	masqueUDPPrefix = "/.well-known/masque/udp/"
+	// maxUDPPayload 单个UDP报文的最大长度
+	maxUDPPayload = 65535
+)
+
+// errUDPNotDirect CONNECT-UDP的目标没有按绕过列表或路由规则直连
+var errUDPNotDirect = errors.New("上游代理池只支持TCP隧道，UDP目标需要通过 PROXY_BYPASS 或路由规则设为直连")
+
+// StartHTTP3 启动HTTP/3代理监听器。
+//
+// 客户端通过QUIC连接到代理本身，请求流上的代理请求与TLS监听器的HTTP/2请求处理方式相同：
+// 标准CONNECT建立TCP隧道，扩展CONNECT（RFC 9220）转发WebSocket，其他方法按正向代理转发。
+// 另外支持CONNECT-UDP（RFC 9298），UDP报文以HTTP数据报（RFC 9297）承载；
+// 上游代理池只提供TCP隧道，因此只有直连的目标可以使用CONNECT-UDP。
+//
+// 证书和曲线配置与TLS监听器相同，QUIC固定使用TLS 1.3，不接受0-RTT早期数据。
+//
+// 参数：
+//   - port: 监听的UDP端口号
+//   - opts: TLS配置，连接分流和握手限速不适用于HTTP/3
+//
+// 返回值：
+//   - error: 监听器启动错误或运行中的致命错误，通过Shutdown关闭时为nil
+func (s *Server) StartHTTP3(port string, opts TLSOptions) error {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载TLS证书失败: %v", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if err := applyTLSPolicy(tlsConfig, opts); err != nil {
+		return err
+	}
+	tlsConfig.MinVersion = tls.VersionTLS13
+
+	conn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	h3Server := &http3.Server{
+		Handler:         http.HandlerFunc(s.serveHTTP3),
+		TLSConfig:       tlsConfig,
+		QUICConfig:      &quic.Config{}, // 零值不允许0-RTT
+		EnableDatagrams: true,
+		MaxHeaderBytes:  s.headerLimits.maxBytes,
+	}
+	s.h3Mutex.Lock()
+	s.h3Server = h3Server
+	s.h3Mutex.Unlock()
+
+	log.Printf("HTTP/3代理监听器正在UDP端口 %s 上启动（支持 CONNECT、CONNECT-UDP）", port)
+	err = h3Server.Serve(conn)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, quic.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// shutdownHTTP3 关闭HTTP/3监听器及其上的连接。
+func (s *Server) shutdownHTTP3() {
+	s.h3Mutex.Lock()
+	defer s.h3Mutex.Unlock()
+
+	if s.h3Server != nil {
+		if err := s.h3Server.Close(); err != nil {
+			log.Printf("关闭HTTP/3监听器时出错: %v", err)
+		}
+	}
+}
+
+// serveHTTP3 处理HTTP/3请求流上的代理请求。
+//
+// quic-go 把扩展CONNECT的 :protocol 放在 r.Proto 中，这里转为与HTTP/2相同的 ":protocol" 请求头；
+// 与标准库HTTP/2一致，只在 :scheme 为https时保留 r.TLS，后续逻辑据此选择连接目标使用的协议。
+// CONNECT-UDP的目标写在请求路径中，解析后替换Host，目标检查和每日上限与其他请求一致。
+func (s *Server) serveHTTP3(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect && r.Proto != "HTTP/3.0" {
+		r.Header.Set(":protocol", r.Proto)
+	}
+	if r.URL.Scheme != "https" {
+		r.TLS = nil
+	}
+	if r.Header.Get(":protocol") == connectUDPProtocol {
+		target, err := parseUDPTarget(r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Host = target
+	}
+	s.serveStreams(w, r, ListenerHTTP3)
+}
+
+// parseUDPTarget 从CONNECT-UDP请求路径中解析目标地址。
+//
+// 参数：
+//   - path: 已解码的请求路径，格式为 /.well-known/masque/udp/{主机}/{端口}/
+//
+// 返回值：
+//   - string: host:port格式的目标地址
+//   - error: 路径不符合默认URI模板或端口无效
+func parseUDPTarget(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, masqueUDPPrefix)
+	parts := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	if !ok || len(parts) != 2 || parts[0] == "" {
+		return "", fmt.Errorf("无效的CONNECT-UDP路径: %s", path)
+	}
+	if port, err := strconv.Atoi(parts[1]); err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("无效的CONNECT-UDP目标端口: %s", parts[1])
+	}
+	return net.JoinHostPort(parts[0], parts[1]), nil
+}
+
+// serveConnectUDP 处理HTTP/3 CONNECT-UDP请求（RFC 9298）。
+//
+// 目标必须按绕过列表或路由规则直连。返回200后接管请求流：上下文ID为0的HTTP数据报载荷发往目标，
+// 目标返回的报文以同样格式发回客户端，其他上下文ID的数据报被丢弃；
+// 请求流上的胶囊读取后丢弃，客户端关闭请求流或隧道被关闭时结束转发。
+func (s *Server) serveConnectUDP(w http.ResponseWriter, r *http.Request, headers map[string]string, settings config.Settings) {
+	destAddr := r.Host
+	destHost, destPort, _ := net.SplitHostPort(destAddr)
+	sel := s.buildSelection(destHost, headers)
+	sel.DestPort, _ = strconv.Atoi(destPort)
+	sel.Label = s.labelFor(ListenerHTTP3, headers["proxy-authorization"], destHost)
+
+	start := time.Now()
+	entry := newAccessEntry(r.RemoteAddr, headers["proxy-authorization"], ListenerHTTP3, "CONNECT-UDP")
+	entry.Host = destAddr
+	if _, direct := s.route(destAddr, headers[userPoolKey]); !direct {
+		entry.Status, entry.Error, entry.Label = http.StatusNotImplemented, errUDPNotDirect.Error(), sel.Label
+		s.accessLog.record(entry, start)
+		http.Error(w, errUDPNotDirect.Error(), http.StatusNotImplemented)
+		return
+	}
+	streamer, ok := w.(http3.HTTPStreamer)
+	if !ok {
+		http.Error(w, "CONNECT-UDP只支持HTTP/3", http.StatusNotImplemented)
+		return
+	}
+	upstreamConn, err := s.dialUDP(destAddr, sel, settings.RequestTimeout)
+	if err != nil {
+		entry.Status, entry.Error, entry.Label = upstreamErrorStatus(err), err.Error(), sel.Label
+		s.accessLog.record(entry, start)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer upstreamConn.Close()
+	s.recordExit(headers["proxy-authorization"], directHost)
+	entry.Proxy = s.formatProxyURL(directProxy)
+
+	w.Header().Set("Capsule-Protocol", "?1")
+	s.stampVersion(w.Header())
+	w.WriteHeader(http.StatusOK)
+	str := streamer.HTTPStream()
+
+	t := newTunnel(streamCloser{str: str}, upstreamConn, directHost, destAddr, sel.SessionID)
+	t.label = sel.Label
+	t.flow = s.openFlow(headers["proxy-authorization"])
+	s.tunnels.add(t)
+	defer s.releaseTunnel(t)
+	defer s.accessLog.recordTunnel(entry, t, start)
+
+	// 超过最大存活时间或空闲超时后关闭隧道
+	defer s.watchTunnel(t, t.startedAt, settings)()
+
+	go func() {
+		// 目前没有需要处理的胶囊类型，客户端关闭请求流时结束转发
+		io.Copy(io.Discard, str)
+		t.close()
+	}()
+	go s.pumpDatagrams(upstreamConn, &activityReader{r: datagramReader{str: str}, t: t, count: &t.sent})
+	s.pumpDatagrams(datagramWriter{str: str}, &activityReader{r: upstreamConn, t: t, count: &t.received, upstream: true})
+}
+
+// dialUDP 直接连接目标UDP地址，统计方式与 dialDirect 相同。
+//
+// 参数：
+//   - destAddr: 目标地址（host:port格式）
+//   - sel: 代理选择条件，用于记录目标主机和流量标签统计
+//   - timeout: 解析目标地址的超时时间，0表示不限制
+//
+// 返回值：
+//   - net.Conn: 已连接到目标的UDP套接字
+//   - error: 连接错误，成功时为nil
+func (s *Server) dialUDP(destAddr string, sel pool.Selection, timeout time.Duration) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := d.Dial("udp", destAddr)
+	s.destinations.record(sel.DestHost, err == nil, time.Since(start))
+	s.labels.Record(sel.Label, err == nil)
+	return conn, err
+}
+
+// pumpDatagrams 逐个转发报文，直到任一方向出错或隧道被关闭。
+//
+// 报文不能按字节切分，因此不经过带宽限制读取器，而是整包计入带宽和流量配额。
+// 目标端口不可达（ICMP）的报错不结束转发，与普通UDP套接字的行为一致。
+//
+// 参数：
+//   - dst: 报文去向
+//   - src: 报文来源，每次读取返回一个完整报文
+func (s *Server) pumpDatagrams(dst io.Writer, src *activityReader) {
+	defer src.t.close()
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, err := src.Read(buf)
+		if err == nil {
+			err = src.t.flow.Packet(n)
+		}
+		if err == nil {
+			_, err = dst.Write(buf[:n])
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			continue
+		}
+		if err != nil {
+			src.t.recordError(src, err)
+			return
+		}
+	}
+}
+
+// datagramReader 每次读取返回一个上下文ID为0的HTTP数据报载荷。
+type datagramReader struct {
+	str *http3.Stream
+}
+
+// Read 读取下一个上下文ID为0的数据报，其他上下文ID的数据报被丢弃。
+func (d datagramReader) Read(p []byte) (int, error) {
+	for {
+		data, err := d.str.ReceiveDatagram(d.str.Context())
+		if err != nil {
+			if d.str.Context().Err() != nil {
+				return 0, net.ErrClosed
+			}
+			return 0, err
+		}
+		contextID, n, err := quicvarint.Parse(data)
+		if err != nil || contextID != 0 {
+			continue
+		}
+		return copy(p, data[n:]), nil
+	}
+}
+
+// datagramWriter 把每次写入的数据作为一个上下文ID为0的HTTP数据报发送。
+type datagramWriter struct {
+	str *http3.Stream
+}
+
+// Write 发送一个数据报，超过QUIC数据报上限的报文被丢弃，与UDP报文在路径上丢失的效果相同。
+func (d datagramWriter) Write(p []byte) (int, error) {
+	err := d.str.SendDatagram(append(quicvarint.Append(make([]byte, 0, len(p)+1), 0), p...))
+	var tooLarge *quic.DatagramTooLargeError
+	if errors.As(err, &tooLarge) {
+		return len(p), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// streamCloser 关闭被接管的HTTP/3请求流的两个方向。
+type streamCloser struct {
+	str *http3.Stream
+}
+
+// Close 停止读取并结束请求流。
+func (c streamCloser) Close() error {
+	c.str.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+	return c.str.Close()
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+
+	"github.com/rfym21/ProxyFlow/internal/pool"
+	"github.com/rfym21/ProxyFlow/internal/routing"
+)
+
+// startHTTP3Proxy 启动只有HTTP/3监听器的代理，到127.0.0.1的连接直连，返回已完成设置交换的客户端连接。
+func startHTTP3Proxy(t *testing.T) *http3.ClientConn {
+	t.Helper()
+	proxyPool, err := pool.NewPool("", pool.Options{List: []string{"http://127.0.0.2:9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(proxyPool.Close)
+	bypass, err := routing.ParseBypass([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(proxyPool, Options{Bypass: bypass})
+	t.Cleanup(func() { s.Shutdown() })
+
+	// 先占用一个空闲UDP端口再释放，交给监听器使用
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(probe.LocalAddr().String())
+	probe.Close()
+	certFile, keyFile := writeTestCert(t)
+	go s.StartHTTP3(port, TLSOptions{CertFile: certFile, KeyFile: keyFile})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, net.JoinHostPort("127.0.0.1", port),
+		&tls.Config{InsecureSkipVerify: true, NextProtos: []string{http3.NextProtoH3}},
+		&quic.Config{EnableDatagrams: true})
+	if err != nil {
+		t.Fatalf("连接HTTP/3监听器失败: %v", err)
+	}
+	t.Cleanup(func() { conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "") })
+	client := (&http3.Transport{EnableDatagrams: true}).NewClientConn(conn)
+	select {
+	case <-client.ReceivedSettings():
+	case <-ctx.Done():
+		t.Fatal("未收到服务端的SETTINGS")
+	}
+	return client
+}
+
+// openRequest 在新的请求流上发送请求头并读取响应。
+func openRequest(t *testing.T, client *http3.ClientConn, req *http.Request) (*http3.RequestStream, *http.Response) {
+	t.Helper()
+	str, err := client.OpenRequestStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { str.Close() })
+	str.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := str.SendRequestHeader(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := str.ReadResponse()
+	if err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	return str, resp
+}
+
+// startTCPEcho 启动原样回显数据的TCP目标。
+func startTCPEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startUDPEcho 启动原样回显报文的UDP目标。
+func startUDPEcho(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxUDPPayload)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// connectUDPRequest 构造指向目标的CONNECT-UDP请求。
+func connectUDPRequest(host, port string) *http.Request {
+	return &http.Request{
+		Method: http.MethodConnect,
+		Proto:  connectUDPProtocol,
+		Host:   "proxy.test",
+		URL:    &url.URL{Scheme: "https", Host: "proxy.test", Path: masqueUDPPrefix + host + "/" + port + "/"},
+		Header: http.Header{"Capsule-Protocol": {"?1"}},
+	}
+}
+
+func TestHTTP3Settings(t *testing.T) {
+	client := startHTTP3Proxy(t)
+	settings := client.Settings()
+	if !settings.EnableExtendedConnect {
+		t.Error("服务端未声明支持扩展CONNECT")
+	}
+	if !settings.EnableDatagrams {
+		t.Error("服务端未声明支持HTTP数据报")
+	}
+}
+
+func TestHTTP3Connect(t *testing.T) {
+	target := startTCPEcho(t)
+	client := startHTTP3Proxy(t)
+
+	str, resp := openRequest(t, client, &http.Request{
+		Method: http.MethodConnect,
+		Host:   target,
+		URL:    &url.URL{Host: target},
+		Header: http.Header{},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT响应状态 = %d，期望 200", resp.StatusCode)
+	}
+	if _, err := str.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(str, buf); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("回显 = %q，期望 ping", buf)
+	}
+}
+
+func TestHTTP3ConnectUDP(t *testing.T) {
+	target := startUDPEcho(t)
+	client := startHTTP3Proxy(t)
+
+	host, port, _ := net.SplitHostPort(target)
+	str, resp := openRequest(t, client, connectUDPRequest(host, port))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT-UDP响应状态 = %d，期望 200", resp.StatusCode)
+	}
+	if resp.Header.Get("Capsule-Protocol") != "?1" {
+		t.Error("响应缺少 Capsule-Protocol 头")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// 非0上下文ID的数据报应被丢弃，只回显上下文ID为0的载荷
+	if err := str.SendDatagram(append(quicvarint.Append(nil, 2), "drop"...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := str.SendDatagram(append(quicvarint.Append(nil, 0), "ping"...)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := str.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatalf("接收数据报失败: %v", err)
+	}
+	contextID, n, err := quicvarint.Parse(data)
+	if err != nil || contextID != 0 || string(data[n:]) != "ping" {
+		t.Errorf("回显数据报 = %q（上下文ID %d），期望上下文ID 0 的 ping", data[n:], contextID)
+	}
+}
+
+func TestHTTP3ConnectUDPRequiresDirect(t *testing.T) {
+	client := startHTTP3Proxy(t)
+
+	_, resp := openRequest(t, client, connectUDPRequest("10.0.0.9", "53"))
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("非直连目标的CONNECT-UDP响应状态 = %d，期望 501", resp.StatusCode)
+	}
+}
+
+func TestParseUDPTarget(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"/.well-known/masque/udp/192.0.2.6/443/", "192.0.2.6:443", false},
+		{"/.well-known/masque/udp/example.com/53", "example.com:53", false},
+		{"/.well-known/masque/udp/2001:db8::42/443/", "[2001:db8::42]:443", false},
+		{"/.well-known/masque/udp/example.com/0/", "", true},
+		{"/.well-known/masque/udp/example.com/", "", true},
+		{"/.well-known/masque/udp//443/", "", true},
+		{"/masque/udp/example.com/443/", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parseUDPTarget(tt.path)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseUDPTarget(%q) = %q, %v，期望 %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/labels"
+)
+
+// labelFor 按流量标签规则确定请求的标签。
+//
+// 参数：
+//   - listener: 接受请求的监听器名称
+//   - authHeader: Proxy-Authorization头，用于确定认证用户
+//   - host: 目标主机名或IP地址（不含端口）
+//
+// 返回值：
+//   - string: 标签，未配置规则或未命中任何规则时为空
+func (s *Server) labelFor(listener, authHeader, host string) string {
+	if s.labels == nil {
+		return ""
+	}
+	var username string
+	if authHeader != "" {
+		username, _, _ = auth.DecodeBasicAuth(authHeader)
+	}
+	return s.labels.Match(username, listener, host)
+}
+
+// labelSuffix 返回日志中标注流量标签的后缀，没有标签时为空。
+func labelSuffix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return " [标签: " + label + "]"
+}
+
+// LabelStatus 获取流量标签规则和按标签的请求与流量统计。
+//
+// 返回值：
+//   - labels.Status: 规则和各标签的统计
+func (s *Server) LabelStatus() labels.Status {
+	return s.labels.Status()
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// MaintenanceState 维护模式状态。
+type MaintenanceState struct {
+	Enabled       bool       `json:"enabled"`         // 是否处于维护模式
+	Status        int        `json:"status"`          // 拒绝新请求时返回的状态码
+	Message       string     `json:"message"`         // 拒绝新请求时返回的说明
+	Since         *time.Time `json:"since,omitempty"` // 进入维护模式的时间
+	ActiveTunnels int        `json:"active_tunnels"`  // 仍在排空的活跃隧道数
+}
+
+// SetMaintenance 开启或关闭维护模式。
+//
+// 维护模式下新的代理请求会收到指定的状态码和说明，
+// 已建立的隧道不受影响，可以自然结束。
+//
+// 参数：
+//   - enabled: 是否开启
+//   - status: 返回的状态码，为0时使用默认值
+//   - message: 返回的说明，为空时使用默认值
+//
+// 返回值：
+//   - MaintenanceState: 切换后的维护模式状态
+func (s *Server) SetMaintenance(enabled bool, status int, message string) MaintenanceState {
+	if !enabled {
+		if s.maintenance.Swap(nil) != nil {
+			log.Printf("已退出维护模式")
+		}
+		return s.Maintenance()
+	}
+
+	if status == 0 {
+		status = s.maintenanceStatus
+	}
+	if message == "" {
+		message = s.maintenanceMessage
+	}
+	now := time.Now()
+	s.maintenance.Store(&MaintenanceState{
+		Enabled: true,
+		Status:  status,
+		Message: message,
+		Since:   &now,
+	})
+	log.Printf("已进入维护模式，新请求将返回 %d", status)
+	return s.Maintenance()
+}
+
+// Maintenance 获取当前维护模式状态。
+//
+// 返回值：
+//   - MaintenanceState: 维护模式状态，包含仍在排空的隧道数
+func (s *Server) Maintenance() MaintenanceState {
+	state := MaintenanceState{Status: s.maintenanceStatus, Message: s.maintenanceMessage}
+	if current := s.maintenance.Load(); current != nil {
+		state = *current
+	}
+	state.ActiveTunnels = s.tunnels.stats().Active
+	return state
+}
+
+// rejectForMaintenance 维护模式下拒绝HTTP/2请求。
+//
+// 返回值：
+//   - bool: 请求是否已被拒绝
+func (s *Server) rejectForMaintenance(w http.ResponseWriter) bool {
+	state := s.maintenance.Load()
+	if state == nil {
+		return false
+	}
+	http.Error(w, state.Message, state.Status)
+	return true
+}
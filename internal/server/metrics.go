@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// WriteMetrics 按Prometheus文本格式输出指标。
+//
+// 参数：
+//   - w: 输出目标
+func (s *Server) WriteMetrics(w io.Writer) {
+	s.sizes.write(w)
+	s.writeReuseMetrics(w)
+}
+
+// writeReuseMetrics 输出各代理池到上游代理的连接复用和TLS会话恢复计数。
+func (s *Server) writeReuseMetrics(w io.Writer) {
+	stats := s.ReuseStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fmt.Fprintln(w, "# HELP proxyflow_upstream_conn_requests_total 经由上游代理转发的HTTP请求取得连接的次数，按是否复用空闲连接区分")
+	fmt.Fprintln(w, "# TYPE proxyflow_upstream_conn_requests_total counter")
+	for _, name := range names {
+		total := stats[name].Total
+		pool := escapeLabel(name)
+		fmt.Fprintf(w, "proxyflow_upstream_conn_requests_total{pool=\"%s\",reused=\"true\"} %d\n", pool, total.ReusedConns)
+		fmt.Fprintf(w, "proxyflow_upstream_conn_requests_total{pool=\"%s\",reused=\"false\"} %d\n", pool, total.NewConns)
+	}
+	fmt.Fprintln(w, "# HELP proxyflow_upstream_tls_handshakes_total 与HTTPS上游代理完成的TLS握手数，按是否恢复会话区分")
+	fmt.Fprintln(w, "# TYPE proxyflow_upstream_tls_handshakes_total counter")
+	for _, name := range names {
+		total := stats[name].Total
+		pool := escapeLabel(name)
+		fmt.Fprintf(w, "proxyflow_upstream_tls_handshakes_total{pool=\"%s\",resumed=\"true\"} %d\n", pool, total.TLSResumed)
+		fmt.Fprintf(w, "proxyflow_upstream_tls_handshakes_total{pool=\"%s\",resumed=\"false\"} %d\n", pool, total.TLSFull)
+	}
+	fmt.Fprintln(w, "# HELP proxyflow_upstream_tls_handshake_seconds_sum 与HTTPS上游代理的TLS握手耗时总和")
+	fmt.Fprintln(w, "# TYPE proxyflow_upstream_tls_handshake_seconds_sum counter")
+	for _, name := range names {
+		total := stats[name].Total
+		seconds := total.TLSHandshakeMs * float64(total.TLSHandshakes) / 1000
+		fmt.Fprintf(w, "proxyflow_upstream_tls_handshake_seconds_sum{pool=\"%s\"} %s\n", escapeLabel(name), strconv.FormatFloat(seconds, 'f', -1, 64))
+	}
+}
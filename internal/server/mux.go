@@ -0,0 +1,290 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/mux"
+)
+
+const (
+	// ListenerMux 多路复用监听器名称
+	ListenerMux = "mux"
+
+	// AnonymousAgent 使用共享令牌连接的客户端代理的身份
+	AnonymousAgent = "anonymous"
+)
+
+// errAgentStreams 客户端代理的并发连接数已达上限
+var errAgentStreams = errors.New("客户端代理的并发连接数已达上限")
+
+// MuxOptions 多路复用监听器配置。
+type MuxOptions struct {
+	Token    string // 共享访问令牌，使用该令牌的客户端代理身份为anonymous，为空则不接受
+	CertFile string // 证书文件路径，为空则使用明文TCP
+	KeyFile  string // 私钥文件路径
+
+	Agents     map[string]string // 按身份分配的访问令牌（身份到令牌），令牌决定客户端代理的身份
+	AgentPools map[string]string // 客户端代理绑定的代理池（身份到代理池名称）
+	MaxStreams int               // 每个客户端代理的并发连接数上限，0表示不限制
+}
+
+// AgentStats 单个客户端代理的统计。
+type AgentStats struct {
+	Agent         string   `json:"agent"`          // 客户端代理身份
+	Pool          string   `json:"pool,omitempty"` // 绑定的代理池
+	Sessions      int      `json:"sessions"`       // 当前的多路复用连接数
+	Remotes       []string `json:"remotes"`        // 多路复用连接的来源地址
+	ActiveStreams int      `json:"active_streams"` // 当前的逻辑连接数
+	TotalStreams  int64    `json:"total_streams"`  // 累计的逻辑连接数
+	Rejected      int64    `json:"rejected"`       // 因并发上限被拒绝的逻辑连接数
+}
+
+// agentState 单个客户端代理的计数。
+type agentState struct {
+	active   int   // 当前的逻辑连接数
+	total    int64 // 累计的逻辑连接数
+	rejected int64 // 被拒绝的逻辑连接数
+}
+
+// muxSessions 多路复用监听器及其活跃会话。
+type muxSessions struct {
+	listener net.Listener            // 多路复用监听器
+	opts     MuxOptions              // 监听器配置
+	sessions map[*mux.Session]string // 活跃会话到客户端代理身份的映射
+	agents   map[string]*agentState  // 按客户端代理身份的计数
+	mutex    sync.Mutex              // 互斥锁
+}
+
+// authenticate 按令牌确定客户端代理身份。
+//
+// 参数：
+//   - token: 握手时提供的令牌
+//
+// 返回值：
+//   - string: 客户端代理身份
+//   - bool: 令牌是否有效
+func (m *muxSessions) authenticate(token string) (string, bool) {
+	for agent, expected := range m.opts.Agents {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			return agent, true
+		}
+	}
+	if m.opts.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.opts.Token)) == 1 {
+		return AnonymousAgent, true
+	}
+	return "", false
+}
+
+// add 登记会话。
+func (m *muxSessions) add(session *mux.Session, agent string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions[session] = agent
+	if m.agents[agent] == nil {
+		m.agents[agent] = &agentState{}
+	}
+}
+
+// remove 注销会话。
+func (m *muxSessions) remove(session *mux.Session) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, session)
+}
+
+// acquire 为客户端代理的一条逻辑连接占用并发名额。
+//
+// 参数：
+//   - agent: 客户端代理身份
+//
+// 返回值：
+//   - bool: 是否在并发上限以内
+func (m *muxSessions) acquire(agent string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	state := m.agents[agent]
+	if m.opts.MaxStreams > 0 && state.active >= m.opts.MaxStreams {
+		state.rejected++
+		return false
+	}
+	state.active++
+	state.total++
+	return true
+}
+
+// release 释放客户端代理的一个并发名额。
+func (m *muxSessions) release(agent string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.agents[agent].active--
+}
+
+// stats 返回按身份排序的客户端代理统计。
+func (m *muxSessions) stats() []AgentStats {
+	if m == nil {
+		return []AgentStats{}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	byAgent := make(map[string]*AgentStats, len(m.agents))
+	for agent, state := range m.agents {
+		byAgent[agent] = &AgentStats{
+			Agent:         agent,
+			Pool:          m.opts.AgentPools[agent],
+			Remotes:       []string{},
+			ActiveStreams: state.active,
+			TotalStreams:  state.total,
+			Rejected:      state.rejected,
+		}
+	}
+	for session, agent := range m.sessions {
+		byAgent[agent].Sessions++
+		byAgent[agent].Remotes = append(byAgent[agent].Remotes, session.RemoteAddr().String())
+	}
+	result := make([]AgentStats, 0, len(byAgent))
+	for _, stats := range byAgent {
+		sort.Strings(stats.Remotes)
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Agent < result[j].Agent })
+	return result
+}
+
+// close 关闭监听器和全部会话。
+func (m *muxSessions) close() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("关闭多路复用监听器时出错: %v", err)
+	}
+	for session := range m.sessions {
+		session.Close()
+	}
+}
+
+// StartMux 启动多路复用监听器。
+//
+// 供可信的客户端代理使用：一条连接上承载多条逻辑连接，每条逻辑连接
+// 按普通代理客户端连接处理（仍需代理认证），分层配置使用监听器名称 "mux"。
+// 一台抓取主机上的大量隧道因此只占用少量TCP连接，减少握手开销和NAT表压力。
+//
+// 客户端代理的身份由握手令牌决定，用于日志、并发上限和代理池绑定，
+// 多个团队可以共用同一个中心而互相隔离。
+//
+// 参数：
+//   - port: 监听端口号
+//   - opts: 多路复用监听器配置
+//
+// 返回值：
+//   - error: 监听器启动错误或运行中的致命错误，通过Shutdown关闭时为nil
+func (s *Server) StartMux(port string, opts MuxOptions) error {
+	if opts.Token == "" && len(opts.Agents) == 0 {
+		return errors.New("多路复用监听器必须配置访问令牌")
+	}
+	for agent, name := range opts.AgentPools {
+		if s.poolByName(name) == nil {
+			return fmt.Errorf("客户端代理 %s 绑定的代理池 %s 不存在", agent, name)
+		}
+	}
+
+	var cert tls.Certificate
+	if opts.CertFile != "" {
+		var err error
+		if cert, err = tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile); err != nil {
+			return fmt.Errorf("加载TLS证书失败: %v", err)
+		}
+	}
+	listener, err := s.listen(port)
+	if err != nil {
+		return err
+	}
+	if opts.CertFile != "" {
+		listener = tls.NewListener(listener, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+
+	sessions := &muxSessions{
+		listener: listener,
+		opts:     opts,
+		sessions: make(map[*mux.Session]string),
+		agents:   make(map[string]*agentState),
+	}
+	s.muxMutex.Lock()
+	s.mux = sessions
+	s.muxMutex.Unlock()
+
+	log.Printf("多路复用监听器正在端口 %s 上启动（TLS: %v）", port, opts.CertFile != "")
+
+	return s.acceptLoop(listener, "多路复用监听器", func(conn net.Conn) {
+		s.serveMux(conn, sessions)
+	})
+}
+
+// serveMux 完成握手并处理一条多路复用连接上的全部逻辑连接。
+//
+// 参数：
+//   - conn: 客户端代理的连接
+//   - sessions: 活跃会话登记表
+func (s *Server) serveMux(conn net.Conn, sessions *muxSessions) {
+	var agent string
+	err := mux.ServerHandshake(conn, func(token string) bool {
+		var ok bool
+		agent, ok = sessions.authenticate(token)
+		return ok
+	})
+	if err != nil {
+		log.Printf("多路复用握手失败 %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	session := mux.Server(conn)
+	sessions.add(session, agent)
+	defer sessions.remove(session)
+	log.Printf("客户端代理 %s 已连接: %s", agent, conn.RemoteAddr())
+
+	pool := sessions.opts.AgentPools[agent]
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			log.Printf("客户端代理 %s 已断开: %s", agent, conn.RemoteAddr())
+			return
+		}
+		if !sessions.acquire(agent) {
+			go func() {
+				s.sendErrorTCP(stream, http.StatusServiceUnavailable, errAgentStreams.Error())
+				stream.Close()
+			}()
+			continue
+		}
+		go func() {
+			defer sessions.release(agent)
+			s.serveConnection(stream, connInfo{listener: ListenerMux, start: time.Now(), agent: agent, pool: pool})
+		}()
+	}
+}
+
+// AgentStats 获取各客户端代理的统计。
+//
+// 返回值：
+//   - []AgentStats: 按身份排序的统计，未启用多路复用监听器时为空
+func (s *Server) AgentStats() []AgentStats {
+	s.muxMutex.Lock()
+	defer s.muxMutex.Unlock()
+	return s.mux.stats()
+}
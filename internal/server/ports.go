@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxListenPorts 代理端口列表最多包含的端口数，防止范围写错时占用大量端口
+const maxListenPorts = 1000
+
+// parsePorts 解析代理端口列表。
+//
+// 支持单个端口、逗号分隔的列表和闭区间范围，可以混合使用，例如 "8282"、"8282,8290"、"8282-8291,9000"。
+//
+// 参数：
+//   - spec: 端口列表
+//
+// 返回值：
+//   - []string: 按出现顺序排列的端口，不含重复项
+//   - error: 格式错误、端口超出范围或数量过多
+func parsePorts(spec string) ([]string, error) {
+	var ports []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		low, high, isRange := strings.Cut(item, "-")
+		first, err := parsePort(low)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePort(high); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("端口范围 %s 的结束端口小于起始端口", item)
+			}
+		}
+		if len(ports)+last-first+1 > maxListenPorts {
+			return nil, fmt.Errorf("代理端口超过 %d 个", maxListenPorts)
+		}
+		for port := first; port <= last; port++ {
+			if p := strconv.Itoa(port); !slices.Contains(ports, p) {
+				ports = append(ports, p)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("没有配置代理端口")
+	}
+	return ports, nil
+}
+
+// parsePort 解析单个端口号。
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("无效的端口: %s", s)
+	}
+	return port, nil
+}
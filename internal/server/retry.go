@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// budgetError 流量预算用尽的错误，附带距离预算重置的时间。
+type budgetError struct {
+	resetIn time.Duration // 距离下一个统计周期开始的时间
+}
+
+// Error 返回预算用尽的错误信息。
+func (e budgetError) Error() string {
+	return errBudgetExhausted.Error()
+}
+
+// Unwrap 返回errBudgetExhausted。
+func (e budgetError) Unwrap() error {
+	return errBudgetExhausted
+}
+
+// RetryAfter 返回距离预算重置的时间。
+func (e budgetError) RetryAfter() time.Duration {
+	return e.resetIn
+}
+
+// exhaustedResponse 代理池暂时无法服务时的结构化响应体。
+type exhaustedResponse struct {
+	Error      string     `json:"error"`              // 错误说明
+	RetryAfter int        `json:"retry_after"`        // 建议多少秒后重试
+	ResetAt    *time.Time `json:"reset_at,omitempty"` // 限额重置的时间，如每日请求上限
+}
+
+// retryAfter 从错误链中取出估计的重试等待时间。
+//
+// 健康检查冷却、上游请求速率限制和流量预算等错误会附带估计值。
+//
+// 参数：
+//   - err: 上游错误
+//
+// 返回值：
+//   - int: 建议多少秒后重试，向上取整且至少为1；没有估计值时为0
+func retryAfter(err error) int {
+	var e interface{ RetryAfter() time.Duration }
+	if !errors.As(err, &e) || e.RetryAfter() <= 0 {
+		return 0
+	}
+	return max(int(math.Ceil(e.RetryAfter().Seconds())), 1)
+}
+
+// exhaustedBody 生成带重试等待时间的结构化响应体。
+//
+// 错误附带确定的重置时间（如每日请求上限）时一并返回。
+//
+// 参数：
+//   - err: 上游错误
+//   - seconds: 建议多少秒后重试
+//
+// 返回值：
+//   - []byte: JSON响应体，以换行结尾
+func exhaustedBody(err error, seconds int) []byte {
+	response := exhaustedResponse{Error: err.Error(), RetryAfter: seconds}
+	var e interface{ ResetAt() time.Time }
+	if errors.As(err, &e) {
+		resetAt := e.ResetAt()
+		response.ResetAt = &resetAt
+	}
+	body, _ := json.Marshal(response)
+	return append(body, '\n')
+}
+
+// sendUpstreamErrorTCP 按上游错误向TCP客户端发送错误响应。
+//
+// 错误附带重试等待时间估计时，返回带Retry-After头的JSON响应，
+// 便于客户端按估计的冷却时间退避；否则返回纯文本错误说明。
+//
+// 参数：
+//   - conn: 客户端连接
+//   - status: HTTP状态码
+//   - err: 上游错误
+func (s *Server) sendUpstreamErrorTCP(conn net.Conn, status int, err error) {
+	seconds := retryAfter(err)
+	if seconds == 0 {
+		s.sendErrorTCP(conn, status, err.Error())
+		return
+	}
+	body := exhaustedBody(err, seconds)
+	response := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Type: application/json; charset=utf-8\r\nRetry-After: %d\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), seconds, len(body), body)
+	conn.Write([]byte(response))
+}
+
+// writeUpstreamError 按上游错误向HTTP/2客户端写入错误响应，规则与sendUpstreamErrorTCP相同。
+//
+// 参数：
+//   - w: 响应写入器
+//   - err: 上游错误
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	status := upstreamErrorStatus(err)
+	seconds := retryAfter(err)
+	if seconds == 0 {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(status)
+	w.Write(exhaustedBody(err, seconds))
+}
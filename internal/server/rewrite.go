@@ -0,0 +1,26 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// RewrittenHeader 列出改写了响应体的规则名称的响应头
+const RewrittenHeader = "X-Proxy-Rewritten"
+
+// rewriteResponse 按改写规则处理普通HTTP响应体，改写后在响应头中注明使用的规则。
+//
+// 参数：
+//   - host: 目标主机
+//   - method: 请求方法
+//   - resp: 上游响应，响应体可能被替换为改写结果
+func (s *Server) rewriteResponse(host, method string, resp *http.Response) {
+	applied := s.rewrites.Apply(host, method, resp)
+	if len(applied) == 0 {
+		return
+	}
+	names := strings.Join(applied, ",")
+	resp.Header.Set(RewrittenHeader, names)
+	log.Printf("已按规则 %s 改写 %s 的响应体", names, host)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rfym21/ProxyFlow/internal/robots"
+)
+
+// robotsUserAgent 获取robots.txt时使用的User-Agent
+const robotsUserAgent = "ProxyFlow-robots/1.0"
+
+// fetchRobots 经由代理池获取robots.txt，供robots.txt合规检查使用。
+//
+// 参数：
+//   - ctx: 请求上下文，控制超时
+//   - target: robots.txt的URL
+//
+// 返回值：
+//   - *http.Response: 响应
+//   - error: 请求失败时返回错误
+func (s *Server) fetchRobots(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", robotsUserAgent)
+	resp, _, err := s.forward(req, s.buildSelection(req.URL.Hostname(), nil), "", 0)
+	return resp, err
+}
+
+// RobotsStatus 获取已缓存站点的robots.txt状态。
+//
+// 返回值：
+//   - []robots.SiteStatus: 按站点排序的状态
+func (s *Server) RobotsStatus() []robots.SiteStatus {
+	return s.robots.Status()
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+)
+
+const (
+	// maxRotationEvents 每个用户最多保留的出口记录数，超出时丢弃最早的记录
+	maxRotationEvents = 10000
+	// maxRotationUsers 最多跟踪的用户数，超出时清理窗口内没有记录的用户
+	maxRotationUsers = 10000
+)
+
+// ExitUsage 单个出口在统计窗口内的使用次数。
+type ExitUsage struct {
+	Exit     string `json:"exit"`     // 出口IP，未知时为代理地址
+	Known    bool   `json:"known"`    // 是否为健康检查观察到的出口IP
+	Requests int    `json:"requests"` // 使用次数
+}
+
+// RotationStats 用户在统计窗口内的出口IP轮换情况。
+type RotationStats struct {
+	User          string      `json:"user"`           // 用户名，未认证的请求为空
+	Minutes       int         `json:"minutes"`        // 统计窗口（分钟）
+	Requests      int         `json:"requests"`       // 请求和隧道数
+	DistinctExits int         `json:"distinct_exits"` // 不同出口的数量
+	TopExitShare  float64     `json:"top_exit_share"` // 使用最多的出口所占比例
+	Exits         []ExitUsage `json:"exits"`          // 各出口的使用次数，按次数降序
+}
+
+// exitUse 一次请求使用的出口。
+type exitUse struct {
+	at    time.Time // 使用时间
+	exit  string    // 出口IP或代理地址
+	known bool      // 是否为观察到的出口IP
+}
+
+// rotationTracker 按用户记录最近使用的出口，用于核对出口IP轮换效果。
+type rotationTracker struct {
+	window time.Duration        // 保留记录的时长，0表示不统计
+	users  map[string][]exitUse // 按用户名索引的出口记录，按时间升序
+	mutex  sync.Mutex           // 互斥锁
+}
+
+// newRotationTracker 创建出口轮换统计器。
+func newRotationTracker(window time.Duration) *rotationTracker {
+	return &rotationTracker{window: window, users: make(map[string][]exitUse)}
+}
+
+// enabled 判断是否启用了出口轮换统计。
+func (r *rotationTracker) enabled() bool {
+	return r.window > 0
+}
+
+// record 记录用户一次请求使用的出口。
+//
+// 参数：
+//   - user: 用户名
+//   - exit: 出口IP或代理地址
+//   - known: 是否为观察到的出口IP
+func (r *rotationTracker) record(user, exit string, known bool) {
+	if !r.enabled() {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	events, ok := r.users[user]
+	if !ok && len(r.users) >= maxRotationUsers {
+		r.cleanup(now)
+	}
+	events = r.prune(events, now)
+	if len(events) >= maxRotationEvents {
+		events = events[1:]
+	}
+	r.users[user] = append(events, exitUse{at: now, exit: exit, known: known})
+}
+
+// prune 丢弃超出保留时长的记录。
+func (r *rotationTracker) prune(events []exitUse, now time.Time) []exitUse {
+	cutoff := now.Add(-r.window)
+	i := sort.Search(len(events), func(i int) bool { return events[i].at.After(cutoff) })
+	return events[i:]
+}
+
+// cleanup 删除保留时长内没有记录的用户，调用方需持有锁。
+func (r *rotationTracker) cleanup(now time.Time) {
+	for user, events := range r.users {
+		if events = r.prune(events, now); len(events) == 0 {
+			delete(r.users, user)
+		} else {
+			r.users[user] = events
+		}
+	}
+}
+
+// stats 统计用户最近一段时间的出口轮换情况。
+//
+// 参数：
+//   - user: 用户名
+//   - period: 统计时长，超过保留时长时按保留时长计算
+//
+// 返回值：
+//   - RotationStats: 出口轮换统计
+func (r *rotationTracker) stats(user string, period time.Duration) RotationStats {
+	period = min(period, r.window)
+	result := RotationStats{User: user, Minutes: int(period / time.Minute), Exits: []ExitUsage{}}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cutoff := time.Now().Add(-period)
+	counts := make(map[string]*ExitUsage)
+	for _, event := range r.users[user] {
+		if !event.at.After(cutoff) {
+			continue
+		}
+		result.Requests++
+		usage, ok := counts[event.exit]
+		if !ok {
+			usage = &ExitUsage{Exit: event.exit, Known: event.known}
+			counts[event.exit] = usage
+		}
+		usage.Requests++
+	}
+	for _, usage := range counts {
+		result.Exits = append(result.Exits, *usage)
+	}
+	sort.Slice(result.Exits, func(i, j int) bool {
+		if result.Exits[i].Requests != result.Exits[j].Requests {
+			return result.Exits[i].Requests > result.Exits[j].Requests
+		}
+		return result.Exits[i].Exit < result.Exits[j].Exit
+	})
+	result.DistinctExits = len(result.Exits)
+	if result.Requests > 0 {
+		result.TopExitShare = float64(result.Exits[0].Requests) / float64(result.Requests)
+	}
+	return result
+}
+
+// usernames 返回保留时长内有记录的用户名，按字典序排列。
+func (r *rotationTracker) usernames() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.cleanup(time.Now())
+	names := make([]string, 0, len(r.users))
+	for user := range r.users {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recordExit 记录请求方使用的出口，用于出口轮换统计。
+//
+// 出口优先取健康检查观察到的出口IP，未知时以代理地址代替。
+//
+// 参数：
+//   - authHeader: Proxy-Authorization头，用于确定用户
+//   - proxyHost: 使用的代理地址
+func (s *Server) recordExit(authHeader, proxyHost string) {
+	if !s.rotation.enabled() {
+		return
+	}
+	var username string
+	if authHeader != "" {
+		username, _, _ = auth.DecodeBasicAuth(authHeader)
+	}
+	exit := s.pool.ExitIP(proxyHost)
+	for _, up := range s.upstreams() {
+		if exit != "" {
+			break
+		}
+		exit = up.pool.ExitIP(proxyHost)
+	}
+	if exit == "" {
+		s.rotation.record(username, proxyHost, false)
+		return
+	}
+	s.rotation.record(username, exit, true)
+}
+
+// RotationStats 获取用户最近一段时间的出口IP轮换统计。
+//
+// 参数：
+//   - user: 用户名，为空时返回全部有记录的用户
+//   - period: 统计时长，不大于0或超过保留时长时按保留时长计算
+//
+// 返回值：
+//   - []RotationStats: 按用户名排序的统计
+func (s *Server) RotationStats(user string, period time.Duration) []RotationStats {
+	if !s.rotation.enabled() {
+		return []RotationStats{}
+	}
+	if period <= 0 {
+		period = s.rotation.window
+	}
+	if user != "" {
+		return []RotationStats{s.rotation.stats(user, period)}
+	}
+	names := s.rotation.usernames()
+	result := make([]RotationStats, 0, len(names))
+	for _, name := range names {
+		result = append(result, s.rotation.stats(name, period))
+	}
+	return result
+}
+
+// OwnRotationStats 用请求方的代理凭据认证，返回其本人的出口轮换统计。
+//
+// 供用户自行核对轮换质量，不需要管理API令牌，也看不到其他用户的记录；认证失败计入认证失败统计。
+//
+// 参数：
+//   - client: 请求方地址
+//   - authHeader: Basic认证头
+//   - period: 统计时长，不大于0或超过保留时长时按保留时长计算
+//
+// 返回值：
+//   - RotationStats: 认证用户的出口轮换统计
+//   - bool: 认证是否通过
+func (s *Server) OwnRotationStats(client, authHeader string, period time.Duration) (RotationStats, bool) {
+	if !s.authorizedFrom(client, authHeader) {
+		s.authFailures.record(client, "admin", authHeader)
+		return RotationStats{}, false
+	}
+	var username string
+	if authHeader != "" {
+		username, _, _ = auth.DecodeBasicAuth(authHeader)
+	}
+	if period <= 0 {
+		period = s.rotation.window
+	}
+	return s.rotation.stats(username, period), true
+}
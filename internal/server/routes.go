@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/models"
+	"github.com/rfym21/ProxyFlow/internal/pool"
+	"github.com/rfym21/ProxyFlow/internal/routing"
+)
+
+// directHost 直连时代替上游代理地址的名称，出现在访问日志和隧道统计中
+const directHost = "direct"
+
+// directProxy 直连时返回给调用方的代理信息
+var directProxy = models.ProxyInfo{URL: &url.URL{Scheme: routing.ActionDirect}, Host: directHost}
+
+// newDirectTransport 创建直连目标的HTTP传输层。
+//
+// 参数：
+//   - maxHeaderBytes: 响应头最大字节数，0表示使用默认值
+//
+// 返回值：
+//   - *http.Transport: 不经过上游代理的传输层
+func newDirectTransport(maxHeaderBytes int64) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialTCP(ctx, addr)
+		},
+		MaxIdleConns:        1000,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+
+		MaxResponseHeaderBytes: maxHeaderBytes,
+	}
+}
+
+// route 按直连绕过列表和路由规则确定请求使用的代理池。
+//
+// 绕过列表最先检查，命中时直连；路由规则优先于客户端代理和代理端口绑定的代理池，
+// 拦截规则已由 checkDestination 处理。
+//
+// 参数：
+//   - destAddr: 目标地址（host:port格式）
+//   - bound: 绑定的代理池名称，为空表示按切换计划选择
+//
+// 返回值：
+//   - string: 使用的代理池名称，为空表示按切换计划选择
+//   - bool: 是否直接连接目标
+func (s *Server) route(destAddr, bound string) (string, bool) {
+	host, portText, _ := net.SplitHostPort(destAddr)
+	port, _ := strconv.Atoi(portText)
+	if s.bypass.Match(host, port) {
+		return "", true
+	}
+	route, ok := s.routes.Match(host)
+	if !ok {
+		return bound, false
+	}
+	switch {
+	case route.Action == routing.ActionDirect:
+		return "", true
+	case route.Pool != "":
+		return route.Pool, false
+	}
+	return bound, false
+}
+
+// dialDirect 不经过上游代理，直接连接目标地址。
+//
+// 参数：
+//   - destAddr: 目标地址（host:port格式）
+//   - sel: 代理选择条件，用于记录目标主机和流量标签统计
+//   - timeout: 连接超时时间，0表示不限制
+//
+// 返回值：
+//   - net.Conn: 到目标的连接
+//   - models.ProxyInfo: 直连时固定为 directProxy
+//   - error: 连接错误，成功时为nil
+func (s *Server) dialDirect(destAddr string, sel pool.Selection, timeout time.Duration) (net.Conn, models.ProxyInfo, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	conn, err := dialer.DialTCP(ctx, destAddr)
+	s.destinations.record(sel.DestHost, err == nil, time.Since(start))
+	s.labels.Record(sel.Label, err == nil)
+	if err != nil {
+		return nil, models.ProxyInfo{}, err
+	}
+	return conn, directProxy, nil
+}
+
+// RouteStats 获取路由规则的命中统计。
+//
+// 配置了直连绕过列表时，它作为第一条直连规则列出。
+//
+// 返回值：
+//   - []routing.RuleStats: 按匹配顺序排列的统计，未配置任何规则时为空
+func (s *Server) RouteStats() []routing.RuleStats {
+	stats := s.routes.Stats()
+	if s.bypass != nil {
+		stats = append([]routing.RuleStats{s.bypass.Stats()}, stats...)
+	}
+	return stats
+}
+
+// requestAddr 返回HTTP请求的目标地址，URL中没有端口时按协议补上默认端口。
+func requestAddr(u *url.URL) string {
+	if strings.EqualFold(u.Scheme, "https") {
+		return withDefaultPort(u.Host, DefaultHTTPSPort)
+	}
+	return withDefaultPort(u.Host, "80")
+}
@@ -8,25 +8,65 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
+
 	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/budget"
 	"github.com/rfym21/ProxyFlow/internal/client"
+	"github.com/rfym21/ProxyFlow/internal/config"
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+	"github.com/rfym21/ProxyFlow/internal/labels"
 	"github.com/rfym21/ProxyFlow/internal/models"
 	"github.com/rfym21/ProxyFlow/internal/pool"
+	"github.com/rfym21/ProxyFlow/internal/profile"
+	"github.com/rfym21/ProxyFlow/internal/quota"
+	"github.com/rfym21/ProxyFlow/internal/rewrite"
+	"github.com/rfym21/ProxyFlow/internal/robots"
+	"github.com/rfym21/ProxyFlow/internal/routing"
+	"github.com/rfym21/ProxyFlow/internal/slo"
+	"github.com/rfym21/ProxyFlow/internal/traffic"
+	"github.com/rfym21/ProxyFlow/internal/update"
+	"github.com/rfym21/ProxyFlow/internal/version"
+	"github.com/rfym21/ProxyFlow/internal/watchlist"
 )
 
+// errBudgetExhausted 流量预算用尽且策略不允许继续转发
+var errBudgetExhausted = errors.New("本月流量预算已用尽，请求被拒绝")
+
 const (
 	// DefaultHTTPSPort HTTPS默认端口
 	DefaultHTTPSPort = "443"
-	// ProxyResponseBufferSize 代理响应缓冲区大小
+	// ProxyResponseBufferSize 读取上游代理CONNECT响应的缓冲区大小
 	ProxyResponseBufferSize = 1024
+	// halfCloseTimeout 隧道上游一侧结束后等待客户端结束发送的最长时间
+	halfCloseTimeout = 30 * time.Second
+	// TagsHeader 指定代理标签的请求头（小写）
+	TagsHeader = "x-proxy-tags"
+	// SessionHeader 指定粘性会话ID的请求头（小写）
+	SessionHeader = "x-proxy-session"
+	// ProfileHeader 指定请求头画像名称的请求头（小写）
+	ProfileHeader = "x-proxy-profile"
+
+	// ListenerHTTP 明文代理监听器名称
+	ListenerHTTP = "http"
+	// ListenerTLS TLS代理监听器名称
+	ListenerTLS = "tls"
 )
 
 // Server HTTP代理服务器。
@@ -34,62 +74,371 @@ const (
 // 代理服务器核心实现，支持HTTP和HTTPS流量代理。
 // 提供认证、连接池管理和上游代理负载均衡等功能。
 type Server struct {
-	pool         *pool.Pool     // 代理池
-	client       *client.Client // HTTP客户端
-	timeout      time.Duration  // 请求超时时间
-	authUsername string         // 认证用户名
-	authPassword string         // 认证密码
-	listener     net.Listener   // TCP监听器
+	pool         *pool.Pool           // 代理池
+	client       *client.Client       // HTTP客户端
+	users        *auth.Users          // 认证用户，为nil则不需要认证
+	clientIPs    *auth.IPFilter       // 客户端IP访问控制，为nil则不限制
+	access       auth.AccessSchedules // 按用户的访问时间段
+	authFailures *authFailureLog      // 认证失败记录
+	accessLog    *accessLog           // 访问日志
+	authCache    *authCache           // 按客户端IP缓存的认证结果，nil表示不缓存
+	challenge    *auth.Challenge      // 407响应的认证质询
+	keepAlive    net.KeepAliveConfig  // 入站连接的TCP keepalive配置
+	tunnels      *tunnelRegistry      // 活跃隧道登记表
+	drains       *drainSet            // 正在排空的上游代理
+	drainTimeout time.Duration        // 默认排空超时，0表示不强制关闭
+	shedder      *loadShedder         // 按优先级的负载削减，nil表示不限制
+	headerLimits headerLimits         // 客户端请求头的大小和数量上限
+	bodyLimit    int64                // 明文HTTP请求体的最大字节数，0表示不限制
+	clientLimits *clientLimiter       // 按客户端IP和用户的限流，nil表示不限制
+	layers       config.Layers        // 分层配置：全局 -> 监听器 -> 用户
+	strictDNS    bool                 // 严格DNS模式，目标主机名只由上游代理解析
+	profiles     *profile.Set         // 出站请求头画像，nil表示不启用
+	watchlist    *watchlist.List      // 可疑目标的告警与拦截规则，nil表示不检查
+	routes       *routing.Router      // 按目标主机的路由规则，nil表示不启用
+	bypass       *routing.Bypass      // 直连绕过列表，nil表示不启用
+	direct       *http.Transport      // 路由规则要求直连时使用的HTTP传输层
+	caps         *quota.Caps          // 按目标主机的每日请求上限，nil表示不限制
+	robots       *robots.Checker      // robots.txt合规检查，nil表示不检查
+	labels       *labels.Set          // 流量标签规则及按标签的统计，nil表示不打标签
+	destinations *destinationTracker  // 按目标主机聚合的统计
+	sizes        *sizeMetrics         // 按目标类别的请求和响应大小直方图，nil表示不统计
+	rotation     *rotationTracker     // 按用户的出口轮换统计
+	slo          *slo.Monitor         // 上游服务水平目标监控，nil表示不启用
+
+	bodyHashLimit int64 // 计算校验和的响应体大小上限，0表示不计算
+
+	rewrites *rewrite.Set // 响应体改写规则，nil表示不改写
+	cookies  *cookieJars  // 按粘性会话的服务端Cookie Jar，nil表示不启用
+
+	versionHeader string // X-ProxyFlow-Version 响应头的值，为空表示不添加
+
+	budget     *budget.Budget       // 主代理池流量预算，nil表示不启用
+	bandwidth  *traffic.Limiter     // 按连接和用户的带宽限制及流量配额，nil表示不限制
+	fallback   *upstream            // 预算用尽后使用的备用代理池，nil表示没有
+	scheduled  map[string]*upstream // 按计划切换的具名代理池
+	schedule   *pool.Schedule       // 代理池切换计划，nil表示始终使用主代理池
+	failover   *pool.Failover       // 按优先级分层的故障转移，nil表示不启用
+	deployment *deployment          // 主代理池流量的蓝绿切换
+
+	shuttingDown       atomic.Bool                      // 是否正在关闭
+	maintenance        atomic.Pointer[MaintenanceState] // 维护模式状态，nil表示正常服务
+	maintenanceStatus  int                              // 维护模式默认状态码
+	maintenanceMessage string                           // 维护模式默认说明
+
+	tlsListener net.Listener // TLS监听器
+	h2Server    *http.Server // HTTP/2服务
+	tlsRoutes   *tlsRouter   // 按SNI或ALPN分流的逻辑端点
+	tlsMutex    sync.Mutex   // TLS监听器锁
+
+	mux      *muxSessions // 多路复用监听器及其会话
+	muxMutex sync.Mutex   // 多路复用监听器锁
+
+	wsServer *http.Server // WebSocket监听器的HTTP服务
+	wsMutex  sync.Mutex   // WebSocket监听器锁
+
+	socksListener net.Listener // SOCKS5监听器
+	socksMutex    sync.Mutex   // SOCKS5监听器锁
+
+	h3Server *http3.Server // HTTP/3服务
+	h3Mutex  sync.Mutex    // HTTP/3监听器锁
+
+	listeners      []net.Listener    // 代理端口的TCP监听器
+	portPools      map[string]string // 代理端口绑定的代理池（端口到代理池名称）
+	portSessions   bool              // 是否为每个代理端口分配固定的粘性会话
+	dynamicPorts   *dynamicPorts     // 通过管理API分配的动态端口
+	sessions       *sessionResolver  // 粘性会话ID的解析规则
+	userRoutes     *userRouter       // 按认证用户选择代理池和代理标签
+	listenersMutex sync.Mutex        // 代理端口监听器锁
+}
+
+// Options 代理服务器配置。
+type Options struct {
+	Layers       config.Layers        // 分层配置，包含请求超时、最大存活时间和流式隧道识别窗口
+	Users        *auth.Users          // 代理服务器的认证用户，为nil则不需要认证
+	ClientIPs    *auth.IPFilter       // 客户端IP访问控制，为nil则不限制
+	Access       auth.AccessSchedules // 按用户名限制访问时间段，未配置的用户不受限制
+	AuthFailures io.Writer            // 认证失败记录的输出，nil表示写入主日志
+	AccessLog    io.Writer            // JSON格式访问日志的输出，nil表示以文本格式写入主日志
+	AuthCacheTTL time.Duration        // 按客户端IP缓存认证结果的时长，0表示只在连接内缓存
+	Challenge    *auth.Challenge      // 407响应的认证质询，nil表示Basic realm="ProxyFlow"且不带响应体
+	StrictDNS    bool                 // 严格DNS模式，目标主机名只由上游代理解析
+	KeepAlive    net.KeepAliveConfig  // 入站连接的TCP keepalive，Enable为false时关闭
+	PortPools    map[string]string    // 代理端口绑定的代理池（端口到代理池名称），未绑定的端口按切换计划选择
+	PortSessions bool                 // 是否为每个代理端口分配固定的粘性会话，会话ID为 port-<端口>
+	Profiles     *profile.Set         // 出站请求头画像，nil表示不启用
+	Watchlist    *watchlist.List      // 可疑目标的告警与拦截规则，nil表示不检查
+	Routes       *routing.Router      // 按目标主机的路由规则，nil表示不启用
+	Bypass       *routing.Bypass      // 直连绕过列表，nil表示不启用
+	Caps         *quota.Caps          // 按目标主机的每日请求上限，nil表示不限制
+	RobotsAgents []string             // 需要遵守robots.txt的爬虫身份，为空则不检查
+	RobotsTTL    time.Duration        // robots.txt缓存时长
+	Labels       *labels.Set          // 流量标签规则，nil表示不打标签
+	SLO          *slo.Monitor         // 上游服务水平目标监控，nil表示不启用
+
+	DynamicPorts   string        // 可通过管理API动态分配的端口列表或范围，为空表示不启用
+	DynamicPortTTL time.Duration // 动态端口的默认有效期
+
+	SessionSources   []string // 粘性会话ID的来源（header、username、port、ip），按优先级排列，为空时使用 header,port
+	SessionSeparator string   // 用户名中会话后缀的分隔符，为空时使用 DefaultSessionSeparator
+
+	UserPools       map[string]string // 认证用户绑定的代理池（用户名到代理池名称）
+	UsernameOptions []string          // 允许写在用户名中的参数键，pool 选择代理池，其他键作为代理标签
+
+	DestStatsHalfLife time.Duration // 目标主机统计的衰减半衰期，0表示不衰减
+	DestStatsMaxHosts int           // 最多统计的目标主机数，0表示不统计
+	RotationWindow    time.Duration // 按用户统计出口轮换的保留时长，0表示不统计
+	BodyHashMaxBytes  int64         // 计算校验和的响应体大小上限，0表示不计算
+
+	SizeMetricsTopHosts int // 大小直方图中单独统计的传输量最大的目标主机数，0表示全部归入 other，小于0表示不统计
+
+	Rewrites *rewrite.Set // 响应体改写规则，nil表示不改写
+
+	VersionHeader bool // 是否在响应和CONNECT成功响应中添加 X-ProxyFlow-Version 头
+
+	CookieJarHosts hostmatch.List // 按粘性会话保存Cookie的目标模式，为空表示不启用
+	CookieJarTTL   time.Duration  // 会话Cookie的空闲过期时间
+
+	MaxResponseHeaderBytes int64 // 上游响应头最大字节数，0表示使用标准库默认值
+	MaxResponseHeaders     int   // 上游响应头最大数量，0表示不限制
+
+	MaxRequestHeaderBytes int   // 客户端请求行和请求头合计的最大字节数，0表示不限制
+	MaxRequestHeaders     int   // 客户端请求头最大数量，0表示不限制
+	MaxRequestBodyBytes   int64 // 明文HTTP请求体的最大字节数，0表示不限制
+
+	Retry client.RetryPolicy // HTTP请求失败时改用其他代理重试的策略
+
+	Budget       *budget.Budget   // 主代理池流量预算，nil表示不启用
+	Traffic      *traffic.Limiter // 按连接和用户的带宽限制及流量配额，nil表示不限制
+	FallbackPool *pool.Pool       // 预算用尽后使用的备用代理池，nil表示没有备用代理池
+
+	Pools    map[string]*pool.Pool // 可按计划切换的具名代理池
+	Schedule *pool.Schedule        // 代理池切换计划，nil表示始终使用主代理池
+	Failover *pool.Failover        // 按优先级分层的故障转移，nil表示不启用
+
+	MaintenanceStatus  int    // 维护模式下拒绝新请求的默认状态码
+	MaintenanceMessage string // 维护模式下拒绝新请求的默认说明
+
+	DrainTimeout time.Duration // 移除上游代理时默认的排空超时，0表示不强制关闭
+
+	MaxConnections    int // 同时处理的请求和隧道数上限，0表示不限制
+	ShedLowPercent    int // 低优先级流量可使用的连接数百分比
+	ShedNormalPercent int // 普通优先级流量可使用的连接数百分比
+
+	ClientLimits ClientLimitOptions // 按客户端IP和用户的请求速率及并发连接数上限
 }
 
 // NewServer 创建新的代理服务器实例。
 //
 // 参数：
 //   - proxyPool: 代理池实例，用于管理上游代理
-//   - timeout: HTTP请求超时时间
-//   - authUsername: 代理服务器认证用户名，为空则不需要认证
-//   - authPassword: 代理服务器认证密码
+//   - opts: 代理服务器配置
 //
 // 返回值：
 //   - *Server: 配置完成的代理服务器实例
-func NewServer(proxyPool *pool.Pool, timeout time.Duration, authUsername, authPassword string) *Server {
-	return &Server{
+func NewServer(proxyPool *pool.Pool, opts Options) *Server {
+	// 请求超时按分层配置通过请求上下文控制，HTTP客户端本身不设置超时
+	clientOpts := client.Options{
+		StrictDNS:              opts.StrictDNS,
+		MaxResponseHeaderBytes: opts.MaxResponseHeaderBytes,
+		MaxResponseHeaders:     opts.MaxResponseHeaders,
+		Retry:                  opts.Retry,
+	}
+	// 每个代理池的HTTP客户端把每次尝试的结果计入该代理池的SLO统计
+	clientFor := func(name string, p *pool.Pool) *client.Client {
+		o := clientOpts
+		if opts.SLO != nil {
+			o.Observe = func(proxy string, latency time.Duration, err error) {
+				opts.SLO.Record(name, proxy, latency, err)
+			}
+		}
+		return client.NewClient(p, o)
+	}
+	var fallback *upstream
+	if opts.FallbackPool != nil {
+		fallback = &upstream{name: pool.FallbackPoolName, pool: opts.FallbackPool, client: clientFor(pool.FallbackPoolName, opts.FallbackPool)}
+	}
+	scheduled := make(map[string]*upstream, len(opts.Pools))
+	for name, p := range opts.Pools {
+		scheduled[name] = &upstream{name: name, pool: p, client: clientFor(name, p)}
+	}
+
+	challenge := opts.Challenge
+	if challenge == nil {
+		challenge = &auth.Challenge{Realm: auth.DefaultRealm, Schemes: []string{"Basic"}}
+	}
+
+	s := &Server{
 		pool:         proxyPool,
-		client:       client.NewClient(proxyPool, timeout),
-		timeout:      timeout,
-		authUsername: authUsername,
-		authPassword: authPassword,
+		client:       clientFor(pool.DefaultPoolName, proxyPool),
+		users:        opts.Users,
+		clientIPs:    opts.ClientIPs,
+		access:       opts.Access,
+		authFailures: newAuthFailureLog(opts.AuthFailures),
+		accessLog:    newAccessLog(opts.AccessLog),
+		challenge:    challenge,
+		keepAlive:    opts.KeepAlive,
+		portPools:    opts.PortPools,
+		portSessions: opts.PortSessions,
+		dynamicPorts: newDynamicPorts(opts.DynamicPorts, opts.DynamicPortTTL),
+		sessions:     newSessionResolver(opts.SessionSources, opts.SessionSeparator),
+		userRoutes:   newUserRouter(opts.UserPools, opts.UsernameOptions),
+		authCache:    newAuthCache(opts.AuthCacheTTL),
+		tunnels:      newTunnelRegistry(),
+		drains:       &drainSet{drains: make(map[string]*drain)},
+		drainTimeout: opts.DrainTimeout,
+		shedder:      newLoadShedder(opts.MaxConnections, opts.ShedLowPercent, opts.ShedNormalPercent),
+		headerLimits: headerLimits{maxBytes: max(opts.MaxRequestHeaderBytes, 0), maxCount: max(opts.MaxRequestHeaders, 0)},
+		bodyLimit:    max(opts.MaxRequestBodyBytes, 0),
+		clientLimits: newClientLimiter(opts.ClientLimits),
+		layers:       opts.Layers,
+		strictDNS:    opts.StrictDNS,
+		profiles:     opts.Profiles,
+		watchlist:    opts.Watchlist,
+		routes:       opts.Routes,
+		bypass:       opts.Bypass,
+		direct:       newDirectTransport(opts.MaxResponseHeaderBytes),
+		caps:         opts.Caps,
+		labels:       opts.Labels,
+		destinations: newDestinationTracker(opts.DestStatsHalfLife, opts.DestStatsMaxHosts),
+		rotation:     newRotationTracker(opts.RotationWindow),
+		slo:          opts.SLO,
+
+		bodyHashLimit: opts.BodyHashMaxBytes,
+
+		rewrites: opts.Rewrites,
+		cookies:  newCookieJars(opts.CookieJarHosts, opts.CookieJarTTL),
+
+		budget:     opts.Budget,
+		bandwidth:  opts.Traffic,
+		fallback:   fallback,
+		scheduled:  scheduled,
+		schedule:   opts.Schedule,
+		failover:   opts.Failover,
+		deployment: newDeployment(),
+
+		maintenanceStatus:  opts.MaintenanceStatus,
+		maintenanceMessage: opts.MaintenanceMessage,
 	}
+	s.robots = robots.New(robots.Options{Agents: opts.RobotsAgents, TTL: opts.RobotsTTL, Fetch: s.fetchRobots})
+	s.sizes = newSizeMetrics(opts.SizeMetricsTopHosts, s.topDestinations)
+	if opts.VersionHeader {
+		s.versionHeader = version.Get().Short()
+	}
+	return s
 }
 
 // Start 启动代理服务器并监听指定端口。
 //
-// 创建TCP监听器并开始接收客户端连接。每个连接
-// 在独立的goroutine中处理，支持并发请求。
+// 为每个代理端口创建TCP监听器并开始接收客户端连接。每个连接
+// 在独立的goroutine中处理，支持并发请求。绑定了代理池的端口上的请求
+// 只使用该代理池，其余端口按切换计划选择。启用按端口会话后，
+// 未指定会话的请求使用端口对应的固定会话，同一端口的请求始终经由同一出口。
 //
 // 参数：
-//   - port: 监听端口号
+//   - spec: 监听端口，可以是单个端口、逗号分隔的列表或范围（如 8282-8291）
 //
 // 返回值：
-//   - error: 服务器启动错误，成功时为nil
-func (s *Server) Start(port string) error {
-	listener, err := net.Listen("tcp", ":"+port)
+//   - error: 服务器启动或运行错误，通过Shutdown关闭时为nil；任一端口出现不可恢复的错误时关闭全部端口
+func (s *Server) Start(spec string) error {
+	ports, err := parsePorts(spec)
 	if err != nil {
 		return err
 	}
-	s.listener = listener
-
-	log.Printf("代理服务器正在端口 %s 上启动", port)
-	log.Printf("使用 %d 个代理进行轮询", s.pool.Size())
+	if err := s.dynamicPorts.parse(); err != nil {
+		return err
+	}
+	if err := s.sessions.validate(); err != nil {
+		return err
+	}
+	for user, name := range s.userRoutes.pools {
+		if s.poolByName(name) == nil {
+			return fmt.Errorf("用户 %s 绑定的代理池 %s 不存在", user, name)
+		}
+	}
+	for port, name := range s.portPools {
+		if !slices.Contains(ports, port) {
+			return fmt.Errorf("端口 %s 绑定了代理池，但不在代理端口列表中", port)
+		}
+		if s.poolByName(name) == nil {
+			return fmt.Errorf("端口 %s 绑定的代理池 %s 不存在", port, name)
+		}
+	}
 
-	for {
-		conn, err := listener.Accept()
+	listeners := make([]net.Listener, 0, len(ports))
+	for _, port := range ports {
+		listener, err := s.listen(port)
 		if err != nil {
-			log.Printf("接受连接时出错: %v", err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+	s.listenersMutex.Lock()
+	s.listeners = listeners
+	s.listenersMutex.Unlock()
+
+	if len(ports) == 1 {
+		log.Printf("代理服务器正在端口 %s 上启动", ports[0])
+	} else {
+		log.Printf("代理服务器正在 %d 个端口 %s 上启动", len(ports), spec)
+	}
+	for port, name := range s.portPools {
+		log.Printf("端口 %s 绑定到代理池 %s", port, name)
+	}
+	if s.portSessions {
+		log.Printf("已按端口分配粘性会话，会话ID形如 %s", portSessionID(ports[0]))
+	}
+	log.Printf("代理池当前有 %d 个可供选择的代理", s.pool.Size())
+	if s.strictDNS {
+		log.Printf("严格DNS模式已启用，目标主机名只由上游代理解析")
+	}
+	// 由进程交接启动时通知旧进程停止接受连接
+	update.Ready()
+
+	errs := make(chan error, len(listeners))
+	for i, listener := range listeners {
+		name := "代理服务器"
+		if len(listeners) > 1 {
+			name = fmt.Sprintf("代理服务器（端口 %s）", ports[i])
+		}
+		info := connInfo{listener: ListenerHTTP, pool: s.portPools[ports[i]]}
+		if s.portSessions {
+			info.session = portSessionID(ports[i])
+		}
+		go func() {
+			errs <- s.acceptLoop(listener, name, func(conn net.Conn) {
+				info := info
+				info.start = time.Now()
+				s.serveConnection(conn, info)
+			})
+		}()
+	}
+	for range listeners {
+		if err := <-errs; err != nil {
+			s.closeListeners()
 			return err
 		}
+	}
+	return nil
+}
 
-		go s.handleConnection(conn)
+// portSessionID 返回代理端口对应的粘性会话ID。
+func portSessionID(port string) string {
+	return "port-" + port
+}
+
+// closeListeners 关闭全部代理端口的监听器。
+func (s *Server) closeListeners() {
+	s.listenersMutex.Lock()
+	defer s.listenersMutex.Unlock()
+
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("关闭监听器时出错: %v", err)
+		}
 	}
 }
 
@@ -102,51 +451,158 @@ func (s *Server) Start(port string) error {
 //   - error: 关闭过程中的错误，成功时为nil
 func (s *Server) Shutdown() error {
 	log.Printf("正在关闭代理服务器...")
-	
-	// 关闭TCP监听器
-	if s.listener != nil {
-		if err := s.listener.Close(); err != nil {
-			log.Printf("关闭监听器时出错: %v", err)
-		}
-	}
-	
-	// 清理HTTP客户端连接池
+	s.shuttingDown.Store(true)
+
+	// 关闭TCP监听器和动态端口
+	s.closeListeners()
+	s.closeDynamicPorts()
+
+	// 关闭TLS监听器
+	s.shutdownTLS()
+
+	// 关闭多路复用监听器和客户端代理的连接
+	s.muxMutex.Lock()
+	s.mux.close()
+	s.muxMutex.Unlock()
+
+	// 关闭WebSocket监听器
+	s.shutdownWebSocket()
+
+	// 关闭SOCKS5监听器
+	s.shutdownSOCKS()
+
+	// 关闭HTTP/3监听器
+	s.shutdownHTTP3()
+
+	// 清理HTTP客户端连接池，停止代理池后台任务
 	s.client.Close()
-	
+	s.pool.Close()
+	for _, up := range s.upstreams() {
+		up.client.Close()
+		up.pool.Close()
+	}
+
+	// 停止SLO评估
+	s.slo.Close()
+
+	// 停止检查认证用户文件
+	s.users.Close()
+
+	// 保存流量预算用量
+	if err := s.budget.Close(); err != nil {
+		log.Printf("保存流量用量失败: %v", err)
+	}
+	if err := s.bandwidth.Close(); err != nil {
+		log.Printf("保存用户流量用量失败: %v", err)
+	}
+
 	log.Printf("代理服务器已成功关闭")
 	return nil
 }
 
 // handleConnection 处理单个TCP连接。
 //
-// 分析连接的第一行数据来判断请求类型：
-// - CONNECT方法：处理HTTPS隧道连接
-// - 其他方法：处理标准HTTP请求
+// 分析每个请求的第一行数据来判断请求类型：
+// - CONNECT方法：处理HTTPS隧道连接，隧道结束后关闭连接
+// - 其他方法：处理标准HTTP请求，支持keep-alive时继续读取下一个请求
 //
 // 参数：
 //   - conn: 客户端TCP连接
-func (s *Server) handleConnection(conn net.Conn) {
+//   - listener: 接受该连接的监听器名称
+func (s *Server) handleConnection(conn net.Conn, listener string) {
+	s.serveConnection(conn, connInfo{listener: listener, start: time.Now()})
+}
+
+// serveConnection 按连接信息处理单个客户端连接，规则与handleConnection相同。
+//
+// 参数：
+//   - conn: 客户端连接
+//   - info: 客户端连接信息
+func (s *Server) serveConnection(conn net.Conn, info connInfo) {
 	defer conn.Close()
 
 	// 获取客户端IP地址
 	clientIP := conn.RemoteAddr().String()
-	log.Printf("新连接来自: %s", clientIP)
+	if info.agent != "" {
+		log.Printf("新连接来自: %s（客户端代理 %s）", clientIP, info.agent)
+	} else {
+		log.Printf("新连接来自: %s", clientIP)
+	}
 
-	reader := bufio.NewReader(conn)
-	firstLine, err := reader.ReadString('\n')
-	if err != nil {
-		// EOF错误通常表示客户端正常断开连接，不需要记录为错误
-		if err != io.EOF {
-			log.Printf("读取第一行时出错: %v", err)
+	info.verified = new(authSeen)
+	connReader := bufio.NewReader(conn)
+	for {
+		reader := s.newHeaderReader(connReader)
+		firstLine, err := reader.readLine()
+		if err != nil {
+			if s.rejectOversizedTCP(conn, err) {
+				return
+			}
+			// EOF错误通常表示客户端正常断开连接，不需要记录为错误
+			if err != io.EOF {
+				log.Printf("读取第一行时出错: %v", err)
+			}
+			return
+		}
+
+		// 维护模式下拒绝新请求，已建立的隧道继续运行直到自然结束
+		if state := s.maintenance.Load(); state != nil {
+			s.sendErrorTCP(conn, state.Status, state.Message)
+			return
+		}
+
+		if strings.HasPrefix(firstLine, "CONNECT ") {
+			s.handleConnectTCP(conn, reader, firstLine, info)
+			return
+		}
+		if !s.handleHTTPTCP(conn, reader, firstLine, info) {
+			return
 		}
-		return
 	}
+}
 
-	if strings.HasPrefix(firstLine, "CONNECT ") {
-		s.handleConnectTCP(conn, reader, firstLine)
-	} else {
-		s.handleHTTPTCP(conn, reader, firstLine)
+// connInfo 客户端连接信息。
+type connInfo struct {
+	listener string    // 接受连接的监听器名称
+	start    time.Time // 连接建立时间
+	agent    string    // 经多路复用传输转发时的客户端代理身份，直连时为空
+	pool     string    // 客户端代理或代理端口绑定的代理池名称，为空表示按切换计划选择
+	session  string    // 代理端口对应的粘性会话ID，作为 port 会话来源，为空表示不绑定
+	verified *authSeen // 本连接上最近一次认证通过的认证头，keep-alive请求携带相同认证头时不再校验
+}
+
+// authSeen 连接上最近一次认证通过的结果。
+type authSeen struct {
+	header     string // 认证头
+	generation uint64 // 认证时的认证用户代数，用户重新加载后需要重新认证
+}
+
+// settingsFor 按监听器和认证用户解析本次请求的分层设置。
+//
+// 参数：
+//   - listener: 监听器名称
+//   - authHeader: Proxy-Authorization头，用于确定用户层覆盖项
+//
+// 返回值：
+//   - config.Settings: 逐层覆盖后的设置
+func (s *Server) settingsFor(listener, authHeader string) config.Settings {
+	var username string
+	if authHeader != "" {
+		username, _, _ = auth.DecodeBasicAuth(authHeader)
 	}
+	return s.layers.Resolve(listener, username)
+}
+
+// connectionExpired 判断客户端连接是否已超过最大存活时间。
+//
+// 参数：
+//   - connStart: 连接建立时间
+//   - maxAge: 最大存活时间，0表示不限制
+//
+// 返回值：
+//   - bool: 是否已超过最大存活时间，未配置最大存活时间时始终为false
+func connectionExpired(connStart time.Time, maxAge time.Duration) bool {
+	return maxAge > 0 && time.Since(connStart) >= maxAge
 }
 
 // handleConnectTCP 处理TCP CONNECT请求。
@@ -158,24 +614,27 @@ func (s *Server) handleConnection(conn net.Conn) {
 //   - conn: 客户端连接
 //   - reader: 缓冲读取器
 //   - firstLine: 已读取的第一行数据
-func (s *Server) handleConnectTCP(conn net.Conn, reader *bufio.Reader, firstLine string) {
+//   - info: 客户端连接信息
+func (s *Server) handleConnectTCP(conn net.Conn, reader *headerReader, firstLine string, info connInfo) {
 	// 解析CONNECT请求
-	parts := strings.Fields(firstLine)
-	if len(parts) < 2 {
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	_, destAddr, _, err := parseRequestLine(firstLine)
+	if err != nil {
+		s.rejectMalformedTCP(conn, err)
 		return
 	}
 
-	destAddr := strings.TrimSpace(parts[1])
 	if !strings.Contains(destAddr, ":") {
 		destAddr += ":" + DefaultHTTPSPort
 	}
 
 	// 读取请求头并检查认证
-	var authHeader string
+	headers := make(map[string]string)
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := reader.readHeader()
 		if err != nil {
+			if s.rejectOversizedTCP(conn, err) {
+				return
+			}
 			// EOF错误通常表示客户端正常断开连接
 			if err != io.EOF {
 				log.Printf("读取CONNECT请求头时出错: %v", err)
@@ -183,123 +642,422 @@ func (s *Server) handleConnectTCP(conn net.Conn, reader *bufio.Reader, firstLine
 			return
 		}
 
-		// 检查Proxy-Authorization头
-		if strings.HasPrefix(strings.ToLower(line), "proxy-authorization:") {
-			authHeader = strings.TrimSpace(line[len("proxy-authorization:"):])
-		}
-
 		// 空行表示请求头结束
 		if line == "\r\n" || line == "\n" {
 			break
 		}
+
+		// 解析头部，键统一转换为小写
+		key, value, err := parseHeaderLine(line)
+		if err != nil {
+			s.rejectMalformedTCP(conn, err)
+			return
+		}
+		headers[key] = value
 	}
+	s.sessions.resolve(headers, conn.RemoteAddr().String(), info.session)
+	s.userRoutes.apply(headers)
 
 	// 检查认证
-	if !s.checkAuthTCP(conn, authHeader) {
+	if !s.checkAuthTCP(conn, info, headers["proxy-authorization"]) {
 		return
 	}
+	settings := s.settingsFor(info.listener, headers["proxy-authorization"])
+	release, ok := s.admitTCP(conn, settings.Priority, headers["proxy-authorization"])
+	if !ok {
+		return
+	}
+	defer release()
 
 	// 尝试通过代理连接
-	var upstreamConn net.Conn
-	var err error
-
-	// 尝试通过代理连接
-	for i := 0; i < s.pool.Size(); i++ {
-		proxy := s.pool.NextProxy()
-		upstreamConn, err = s.connectThroughProxy(destAddr, proxy)
-		if err == nil {
-			log.Printf("CONNECT %s -> 代理: %s", destAddr, s.formatProxyURL(proxy))
-			break
-		}
+	destHost, destPort, _ := net.SplitHostPort(destAddr)
+	if err := s.checkDestination(destHost, headers["proxy-authorization"], conn.RemoteAddr().String()); err != nil {
+		s.sendErrorTCP(conn, http.StatusForbidden, err.Error())
+		return
 	}
-
+	poolName, err := s.boundPool(info.pool, headers)
 	if err != nil {
+		s.sendErrorTCP(conn, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.checkCap(destHost, headers["proxy-authorization"]); err != nil {
+		s.sendUpstreamErrorTCP(conn, http.StatusTooManyRequests, err)
+		return
+	}
+	if err := s.checkTraffic(headers["proxy-authorization"]); err != nil {
+		s.sendUpstreamErrorTCP(conn, http.StatusTooManyRequests, err)
+		return
+	}
+	sel := s.buildSelection(destHost, headers)
+	sel.DestPort, _ = strconv.Atoi(destPort)
+	sel.Label = s.labelFor(info.listener, headers["proxy-authorization"], destHost)
+	start := time.Now()
+	entry := newAccessEntry(conn.RemoteAddr().String(), headers["proxy-authorization"], info.listener, http.MethodConnect)
+	entry.Host = destAddr
+	upstreamConn, proxy, err := s.dialUpstream(destAddr, sel, poolName, settings.RequestTimeout)
+	if err != nil {
+		entry.Status, entry.Error, entry.Label = upstreamErrorStatus(err), err.Error(), sel.Label
+		s.accessLog.record(entry, start)
+		if status := upstreamErrorStatus(err); status != http.StatusBadGateway || len(sel.Tags) > 0 {
+			s.sendUpstreamErrorTCP(conn, status, err)
+			return
+		}
 		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
 		return
 	}
 	defer upstreamConn.Close()
+	s.recordExit(headers["proxy-authorization"], proxy.Host)
+	entry.Proxy = s.formatProxyURL(proxy)
+
+	// 登记隧道，用于统计以及会话轮换时关闭
+	t := newTunnel(conn, upstreamConn, proxy.Host, destAddr, sel.SessionID)
+	t.label = sel.Label
+	t.flow = s.openFlow(headers["proxy-authorization"])
+	s.tunnels.add(t)
+	defer s.releaseTunnel(t)
+	defer s.accessLog.recordTunnel(entry, t, start)
 
 	// 发送200 Connection Established响应
-	_, err = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	_, err = conn.Write(s.connectEstablished())
 	if err != nil {
 		return
 	}
 
+	// 超过最大存活时间或空闲超时后关闭隧道
+	defer s.watchTunnel(t, info.start, settings)()
+
 	// 双向数据转发
-	go s.copyData(upstreamConn, conn)
-	s.copyData(conn, upstreamConn)
+	s.relay(conn, upstreamConn, t)
+}
+
+// dialUpstream 通过代理池建立到目标地址的隧道连接。
+//
+// 按选择条件获取代理并发送CONNECT请求，失败时重新选择代理重试，最多尝试重试策略允许的次数。
+//
+// 参数：
+//   - destAddr: 目标地址（host:port格式）
+//   - sel: 代理选择条件
+//   - poolName: 绑定的代理池名称，为空表示按切换计划选择
+//   - timeout: 与每个代理握手的超时时间
+//
+// 返回值：
+//   - net.Conn: 建立的隧道连接
+//   - models.ProxyInfo: 使用的代理服务器信息
+//   - error: 连接错误，成功时为nil
+func (s *Server) dialUpstream(destAddr string, sel pool.Selection, poolName string, timeout time.Duration) (net.Conn, models.ProxyInfo, error) {
+	var upstreamConn net.Conn
+	var proxy models.ProxyInfo
+	var err error
+
+	poolName, direct := s.route(destAddr, poolName)
+	if direct {
+		return s.dialDirect(destAddr, sel, timeout)
+	}
+	up, metered, err := s.upstreamFor(poolName)
+	if err != nil {
+		return nil, models.ProxyInfo{}, err
+	}
+
+	start := time.Now()
+	for i := 0; i < up.client.Attempts(); i++ {
+		proxy, err = up.pool.Select(sel)
+		if err != nil {
+			continue
+		}
+		attempt := time.Now()
+		upstreamConn, err = s.connectThroughProxy(destAddr, proxy, timeout)
+		up.pool.ReportOutcome(proxy.Host, err)
+		s.slo.Record(up.name, proxy.Host, time.Since(attempt), err)
+		if err == nil {
+			up.pool.ObserveLatency(proxy.Host, time.Since(attempt))
+			if release := up.pool.Acquire(proxy.Host); release != nil {
+				upstreamConn = &trackedConn{Conn: upstreamConn, release: release}
+			}
+			s.destinations.record(sel.DestHost, true, time.Since(start))
+			s.labels.Record(sel.Label, true)
+			s.deployment.observe(up.name, time.Since(start), nil)
+			if metered {
+				upstreamConn = s.budget.WrapConn(upstreamConn)
+			}
+			return upstreamConn, proxy, nil
+		}
+	}
+	s.destinations.record(sel.DestHost, false, time.Since(start))
+	s.labels.Record(sel.Label, false)
+	s.deployment.observe(up.name, time.Since(start), err)
+	return nil, models.ProxyInfo{}, err
+}
+
+// releaseTunnel 注销隧道并将其传输字节数计入目标主机统计。
+//
+// 隧道有转发错误时输出日志；上游一侧的连接被重置、超时等错误计入该代理的被动健康状态，
+// 客户端一侧的错误与代理无关，不计入。
+func (s *Server) releaseTunnel(t *tunnel) {
+	s.tunnels.remove(t)
+	for _, e := range t.errors() {
+		log.Printf("CONNECT %s 隧道转发出错（代理 %s）: %v", t.destAddr, t.proxyHost, e)
+		if !e.upstream || t.proxyHost == directHost {
+			continue
+		}
+		s.pool.ReportOutcome(t.proxyHost, e.err)
+		for _, up := range s.upstreams() {
+			up.pool.ReportOutcome(t.proxyHost, e.err)
+		}
+	}
+	host, _, _ := net.SplitHostPort(t.destAddr)
+	s.destinations.addBytes(host, t.sent.Load(), t.received.Load())
+	s.sizes.observe(host, t.sent.Load(), t.received.Load())
+	s.labels.AddBytes(t.label, t.sent.Load(), t.received.Load())
+}
+
+// forward 通过代理池发送HTTP请求，并记录目标主机统计。
+//
+// 请求失败或目标返回5xx响应时计为失败。
+//
+// 参数：
+//   - req: 要转发的HTTP请求
+//   - sel: 代理选择条件
+//   - poolName: 绑定的代理池名称，为空表示按切换计划选择
+//   - timeout: 请求超时时间，覆盖到读取完响应体为止，0表示不限制
+//
+// 返回值：
+//   - *http.Response: HTTP响应
+//   - models.ProxyInfo: 使用的代理服务器信息
+//   - error: 请求错误，成功时为nil
+func (s *Server) forward(req *http.Request, sel pool.Selection, poolName string, timeout time.Duration) (*http.Response, models.ProxyInfo, error) {
+	poolName, direct := s.route(requestAddr(req.URL), poolName)
+	var up *upstream
+	var metered bool
+	if !direct {
+		var err error
+		if up, metered, err = s.upstreamFor(poolName); err != nil {
+			return nil, models.ProxyInfo{}, err
+		}
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), timeout)
+		req = req.WithContext(ctx)
+	}
+
+	start := time.Now()
+	var resp *http.Response
+	var usedProxy models.ProxyInfo
+	var err error
+	if direct {
+		if resp, err = s.direct.RoundTrip(req); err == nil {
+			usedProxy = directProxy
+		}
+	} else {
+		resp, usedProxy, err = up.client.Do(req, sel)
+	}
+	if err != nil {
+		cancel()
+	} else {
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	}
+	ok := err == nil && resp.StatusCode < http.StatusInternalServerError
+	s.destinations.record(req.URL.Hostname(), ok, time.Since(start))
+	s.labels.Record(sel.Label, ok)
+	if up != nil {
+		s.deployment.observe(up.name, time.Since(start), err)
+	}
+	if err == nil && metered {
+		s.budget.Add(max(req.ContentLength, 0))
+		resp.Body = s.budget.WrapBody(resp.Body)
+	}
+	return resp, usedProxy, err
+}
+
+// SLOStatus 获取上游服务水平目标的监控状态。
+//
+// 返回值：
+//   - slo.Status: 各代理池和代理的状态以及最近的告警事件
+func (s *Server) SLOStatus() slo.Status {
+	return s.slo.Status()
+}
+
+// DestinationStats 获取按目标主机聚合的统计。
+//
+// 参数：
+//   - n: 返回数量，不大于0时返回全部
+//   - sortBy: 排序维度，可选 requests、failures、bytes、latency、duplicates
+//
+// 返回值：
+//   - []DestinationStats: 降序排列的统计列表
+func (s *Server) DestinationStats(n int, sortBy string) []DestinationStats {
+	return s.destinations.top(n, sortBy)
+}
+
+// topDestinations 返回传输量最大的前n个目标主机，未启用目标主机统计时为空。
+func (s *Server) topDestinations(n int) []string {
+	top := s.destinations.top(n, "bytes")
+	hosts := make([]string, len(top))
+	for i, stats := range top {
+		hosts[i] = stats.Host
+	}
+	return hosts
+}
+
+// watchTunnel 按分层设置限制隧道的存活时间，到期后关闭隧道两端的连接。
+//
+// 超过最大存活时间（MaxConnAge）时关闭隧道，使长连接负载重新分散到代理池，
+// 到期时仍在持续传输的隧道视为WebSocket等流式长连接，不受此限制；
+// 双向都没有数据传输超过空闲超时，或超过绝对最长存活时间时，无论是否为流式隧道都会关闭，
+// 避免两端都不关闭的隧道一直占用文件描述符。
+//
+// 参数：
+//   - t: 隧道记录
+//   - connStart: 客户端连接建立时间
+//   - settings: 本次请求的分层设置
+//
+// 返回值：
+//   - func(): 停止计时的函数，隧道结束时调用
+func (s *Server) watchTunnel(t *tunnel, connStart time.Time, settings config.Settings) func() {
+	var timers []*time.Timer
+	if settings.MaxConnAge > 0 {
+		timers = append(timers, time.AfterFunc(settings.MaxConnAge-time.Since(connStart), func() {
+			if settings.StreamingWindow > 0 && t.idleFor() < settings.StreamingWindow {
+				t.streaming.Store(true)
+				log.Printf("CONNECT %s 仍在持续传输，识别为流式隧道，不受最大存活时间限制", t.destAddr)
+				return
+			}
+			log.Printf("CONNECT %s 已达到最大存活时间 %v，关闭隧道", t.destAddr, settings.MaxConnAge)
+			t.close()
+		}))
+	}
+	if settings.TunnelMaxDuration > 0 {
+		timers = append(timers, time.AfterFunc(settings.TunnelMaxDuration, func() {
+			log.Printf("CONNECT %s 已达到绝对最长存活时间 %v，关闭隧道", t.destAddr, settings.TunnelMaxDuration)
+			t.close()
+		}))
+	}
+	if timeout := settings.TunnelIdleTimeout; timeout > 0 {
+		var idle *time.Timer
+		idle = time.AfterFunc(timeout, func() {
+			if remaining := timeout - t.idleFor(); remaining > 0 {
+				idle.Reset(remaining)
+				return
+			}
+			log.Printf("CONNECT %s 已空闲超过 %v，关闭隧道", t.destAddr, timeout)
+			t.close()
+		})
+		timers = append(timers, idle)
+	}
+	return func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}
+}
+
+// TunnelStats 获取当前活跃隧道的统计快照。
+//
+// 流式长连接隧道同样计入各上游代理的活跃隧道数，
+// 并单独统计其数量。
+//
+// 返回值：
+//   - TunnelStats: 隧道统计快照
+func (s *Server) TunnelStats() TunnelStats {
+	return s.tunnels.stats()
 }
 
 // handleHTTPTCP 处理TCP HTTP请求。
 //
 // 处理标准HTTP请求，包括请求解析、认证验证、
 // 代理转发和响应返回。支持各种HTTP方法。
+// 当请求和响应都允许时保持连接，连接超过最大存活时间后
+// 在本次响应中发送 Connection: close 并结束连接。
 //
 // 参数：
 //   - conn: 客户端连接
 //   - reader: 缓冲读取器
 //   - firstLine: 已读取的第一行数据
-func (s *Server) handleHTTPTCP(conn net.Conn, reader *bufio.Reader, firstLine string) {
+//   - info: 客户端连接信息
+//
+// 返回值：
+//   - bool: 连接是否可以继续处理下一个请求
+func (s *Server) handleHTTPTCP(conn net.Conn, reader *headerReader, firstLine string, info connInfo) bool {
 	// 解析HTTP请求行
-	parts := strings.Fields(firstLine)
-	if len(parts) < 3 {
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+	method, url, protocol, err := parseRequestLine(firstLine)
+	if err != nil {
+		s.rejectMalformedTCP(conn, err)
+		return false
 	}
 
-	method := parts[0]
-	url := parts[1]
-
 	// 读取请求头并检查认证
 	headers := make(map[string]string)
 	var authHeader string
-	var contentLength int
+	var framing requestFraming
 
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := reader.readHeader()
 		if err != nil {
+			if s.rejectOversizedTCP(conn, err) {
+				return false
+			}
 			// EOF错误通常表示客户端正常断开连接
 			if err != io.EOF {
 				log.Printf("读取HTTP请求头时出错: %v", err)
 			}
-			return
+			return false
 		}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
+		if line == "\r\n" || line == "\n" {
 			break
 		}
 
-		// 解析头部
-		if colonIndex := strings.Index(line, ":"); colonIndex > 0 {
-			key := strings.TrimSpace(line[:colonIndex])
-			value := strings.TrimSpace(line[colonIndex+1:])
-			headers[strings.ToLower(key)] = value
-
-			// 检查特殊头部
-			if strings.ToLower(key) == "proxy-authorization" {
-				authHeader = value
-			} else if strings.ToLower(key) == "content-length" {
-				if cl, err := fmt.Sscanf(value, "%d", &contentLength); cl != 1 || err != nil {
-					contentLength = 0
-				}
-			}
+		// 解析头部，决定请求体边界的头部全部记录下来以检查是否存在歧义
+		key, value, err := parseHeaderLine(line)
+		if err != nil {
+			s.rejectMalformedTCP(conn, err)
+			return false
 		}
+		headers[key] = value
+		framing.observe(key, value)
+	}
+	contentLength, chunked, err := framing.body(s.bodyLimit)
+	if err != nil {
+		s.rejectMalformedTCP(conn, err)
+		return false
 	}
+	s.sessions.resolve(headers, conn.RemoteAddr().String(), info.session)
+	s.userRoutes.apply(headers)
+	authHeader = headers["proxy-authorization"]
 
 	// 检查认证
-	if !s.checkAuthTCP(conn, authHeader) {
-		return
+	if !s.checkAuthTCP(conn, info, authHeader) {
+		return false
+	}
+	settings := s.settingsFor(info.listener, authHeader)
+	release, ok := s.admitTCP(conn, settings.Priority, authHeader)
+	if !ok {
+		return false
 	}
+	defer release()
 
 	// 读取请求体
 	var body []byte
-	if contentLength > 0 {
-		body = make([]byte, contentLength)
-		_, err := io.ReadFull(reader, body)
+	switch {
+	case chunked:
+		body, err = readChunkedBody(reader, s.bodyLimit)
+		if err != nil {
+			if !s.rejectOversizedTCP(conn, err) && !s.rejectMalformedTCP(conn, err) {
+				conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			}
+			return false
+		}
+	case contentLength > 0:
+		// 按实际到达的数据分配内存，避免过大的 Content-Length 直接申请巨大的缓冲区
+		body, err = io.ReadAll(io.LimitReader(reader, contentLength))
+		if err == nil && int64(len(body)) < contentLength {
+			err = io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-			return
+			return false
 		}
 	}
 
@@ -307,66 +1065,154 @@ func (s *Server) handleHTTPTCP(conn net.Conn, reader *bufio.Reader, firstLine st
 	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
 		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+		return false
+	}
+
+	if err := s.checkDestination(req.URL.Hostname(), authHeader, conn.RemoteAddr().String()); err != nil {
+		s.sendErrorTCP(conn, http.StatusForbidden, err.Error())
+		return false
+	}
+	poolName, err := s.boundPool(info.pool, headers)
+	if err != nil {
+		s.sendErrorTCP(conn, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if err := s.checkCap(req.URL.Hostname(), authHeader); err != nil {
+		s.sendUpstreamErrorTCP(conn, http.StatusTooManyRequests, err)
+		return false
+	}
+	if err := s.checkTraffic(authHeader); err != nil {
+		s.sendUpstreamErrorTCP(conn, http.StatusTooManyRequests, err)
+		return false
+	}
+	if err := s.robots.Check(req.Context(), req.URL, headers["user-agent"]); err != nil {
+		s.sendErrorTCP(conn, http.StatusForbidden, err.Error())
+		return false
 	}
 
 	// 设置请求头（排除代理相关头部）
 	for key, value := range headers {
-		if key != "proxy-authorization" && key != "proxy-connection" {
+		if !isProxyControlHeader(key) {
 			req.Header.Set(key, value)
 		}
 	}
+	s.profiles.Select(req.URL.Hostname(), headers[ProfileHeader]).Apply(req.Header)
 
 	// 通过代理发送请求
-	resp, usedProxy, err := s.client.Do(req)
+	sel := s.buildSelection(req.URL.Hostname(), headers)
+	sel.Label = s.labelFor(info.listener, authHeader, req.URL.Hostname())
+	jar := s.cookies.jarFor(sel.SessionID, req.URL.Hostname())
+	addJarCookies(req, jar)
+	start := time.Now()
+	entry := newAccessEntry(conn.RemoteAddr().String(), authHeader, info.listener, method)
+	entry.Host, entry.URL, entry.Label, entry.BytesIn = req.URL.Host, url, sel.Label, int64(len(body))
+	resp, usedProxy, err := s.forward(req, sel, poolName, settings.RequestTimeout)
 	if err == nil {
-		log.Printf("%s %s -> 代理: %s", method, url, s.formatProxyURL(usedProxy))
+		s.recordExit(headers["proxy-authorization"], usedProxy.Host)
 	}
 
 	if err != nil {
+		entry.Status, entry.Error = upstreamErrorStatus(err), err.Error()
+		s.accessLog.record(entry, start)
+		if status := upstreamErrorStatus(err); status != http.StatusBadGateway || len(sel.Tags) > 0 {
+			s.sendUpstreamErrorTCP(conn, status, err)
+			return false
+		}
 		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
-		return
+		return false
 	}
 	defer resp.Body.Close()
+	storeJarCookies(jar, req, resp)
+	s.rewriteResponse(req.URL.Hostname(), method, resp)
+	s.hashResponse(req.URL.Hostname(), method, resp)
+	s.stampVersion(resp.Header)
+
+	// 判断连接是否可以复用：客户端要求保持连接、响应体长度已知且连接未超过最大存活时间；
+	// 请求体（包括分块传输的请求体）此时已完整读取，下一个请求从连接上的下一个字节开始
+	keepAlive := protocol == "HTTP/1.1" &&
+		!strings.EqualFold(headers["connection"], "close") &&
+		!strings.EqualFold(headers["proxy-connection"], "close") &&
+		resp.ContentLength >= 0 &&
+		!connectionExpired(info.start, settings.MaxConnAge)
 
 	// 发送响应状态行
 	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", resp.StatusCode, resp.Status[4:])
 	conn.Write([]byte(statusLine))
 
-	// 发送响应头
+	// 发送响应头（逐跳头部由本代理重新设置）
 	for key, values := range resp.Header {
+		if key == "Connection" || key == "Keep-Alive" {
+			continue
+		}
 		for _, value := range values {
 			headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
 			conn.Write([]byte(headerLine))
 		}
 	}
+	if resp.ContentLength >= 0 && resp.Header.Get("Content-Length") == "" {
+		conn.Write([]byte(fmt.Sprintf("Content-Length: %d\r\n", resp.ContentLength)))
+	}
+	if !keepAlive {
+		conn.Write([]byte("Connection: close\r\n"))
+	}
 
 	// 发送空行分隔头部和正文
 	conn.Write([]byte("\r\n"))
 
-	// 发送响应体
-	io.Copy(conn, resp.Body)
+	// 发送响应体，请求体已完整读取，只计入用量
+	flow := s.openFlow(authHeader)
+	flow.Add(int64(len(body)))
+	received, err := io.Copy(conn, flow.Reader(resp.Body))
+	s.destinations.addBytes(req.URL.Hostname(), int64(len(body)), received)
+	s.sizes.observe(req.URL.Hostname(), int64(len(body)), received)
+	s.labels.AddBytes(sel.Label, int64(len(body)), received)
+	entry.Proxy, entry.Status, entry.BytesOut = s.formatProxyURL(usedProxy), resp.StatusCode, received
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	s.accessLog.record(entry, start)
+	if err != nil {
+		return false
+	}
+	return keepAlive
 }
 
 // connectThroughProxy 通过代理服务器连接到目标地址。
 //
 // 建立到上游代理的连接，发送CONNECT请求以建立隧道。
-// 支持代理认证和响应验证。
+// 支持代理认证和响应验证。注册了拨号器的上游协议改由其拨号器连接目标。
 //
 // 参数：
 //   - destAddr: 目标地址（host:port格式）
 //   - proxy: 代理服务器信息
+//   - timeout: 连接和握手超时时间，0表示不限制
 //
 // 返回值：
 //   - net.Conn: 建立的代理连接
 //   - error: 连接错误，成功时为nil
-func (s *Server) connectThroughProxy(destAddr string, proxy models.ProxyInfo) (net.Conn, error) {
+func (s *Server) connectThroughProxy(destAddr string, proxy models.ProxyInfo, timeout time.Duration) (net.Conn, error) {
+	// 注册了拨号器的上游协议（如SSH、WireGuard）由拨号器直接连接目标，不需要CONNECT握手
+	if d, ok := dialer.Lookup(proxy); ok {
+		return dialer.Dial(d, proxy, destAddr, timeout)
+	}
+
 	// 连接到代理服务器
-	proxyConn, err := net.Dial("tcp", proxy.Host)
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	proxyConn, err := dialer.DialProxy(ctx, proxy)
 	if err != nil {
 		return nil, err
 	}
 
+	// 握手阶段受请求超时限制，隧道建立后取消
+	if timeout > 0 {
+		proxyConn.SetDeadline(time.Now().Add(timeout))
+	}
+
 	// 构建CONNECT请求
 	// Host 头应该指向目标主机
 	connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", destAddr, destAddr)
@@ -389,89 +1235,381 @@ func (s *Server) connectThroughProxy(destAddr string, proxy models.ProxyInfo) (n
 		return nil, err
 	}
 
-	// 读取代理响应
-	buffer := make([]byte, ProxyResponseBufferSize)
-	n, err := proxyConn.Read(buffer)
+	// 读取代理响应。响应头之后可能紧跟着目标已发来的数据（如服务器先发言的协议），
+	// 这部分数据留在缓冲区中，由返回的连接先行交给隧道
+	reader := bufio.NewReaderSize(proxyConn, ProxyResponseBufferSize)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
 	if err != nil {
 		proxyConn.Close()
-		return nil, err
+		return nil, fmt.Errorf("代理返回了无效的CONNECT响应: %v", err)
 	}
-
-	response := string(buffer[:n])
-	if !strings.Contains(response, "200") {
+	if resp.StatusCode != http.StatusOK {
 		proxyConn.Close()
-		return nil, fmt.Errorf("代理连接失败: %s", response)
+		return nil, fmt.Errorf("代理连接失败: %s", resp.Status)
 	}
 
+	proxyConn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: proxyConn, reader: reader}, nil
+	}
 	return proxyConn, nil
 }
 
+// listen 在端口上启动TCP监听，接受的连接按配置开启TCP keepalive。
+//
+// 长时间没有数据的隧道依靠keepalive发现NAT后面已经失效的客户端，及时释放连接。
+// 由进程交接启动时使用旧进程传来的同一端口的监听器。
+//
+// 参数：
+//   - port: 监听端口号
+//
+// 返回值：
+//   - net.Listener: TCP监听器
+//   - error: 监听失败的原因
+func (s *Server) listen(port string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAliveConfig: s.keepAlive}
+	if !s.keepAlive.Enable {
+		lc.KeepAlive = -1
+	}
+	return update.Listen(&lc, ":"+port)
+}
+
+// bufferedConn 读取时先返回缓冲区中已读入的数据的连接。
+//
+// 读取上游代理的CONNECT响应时可能一并读入了响应头之后的隧道数据，
+// 这些数据必须在连接上的后续数据之前交给客户端。
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader // 包含已读入数据的缓冲读取器
+}
+
+// Read 先读取缓冲区中的数据，读完后直接从连接读取。
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite 关闭发送方向，底层连接支持半关闭时生效。
+func (c *bufferedConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
+
+// trackedConn 关闭时释放代理池负载登记的上游连接。
+type trackedConn struct {
+	net.Conn
+	release func() // 释放负载登记，可重复调用
+}
+
+// Close 关闭连接并释放负载登记。
+func (c *trackedConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+// CloseWrite 关闭发送方向，底层连接支持半关闭时生效。
+func (c *trackedConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
+
 // copyData 在两个连接间复制数据。
 //
-// 用于隧道模式下的双向数据转发，直到数据传输完成
+// 用于隧道模式下的单向数据转发，直到数据传输完成
 // 或发生错误。该函数会阻塞直到数据传输结束。
+// 结束后如果目标支持半关闭（如TCP连接），关闭其发送方向，
+// 使对端收到EOF而另一方向的数据继续转发。
 //
 // 参数：
 //   - dst: 目标写入器
 //   - src: 源读取器
-func (s *Server) copyData(dst io.Writer, src io.Reader) {
-	io.Copy(dst, src)
+//
+// 返回值：
+//   - error: 读取或写入的错误，源正常结束时为nil
+func (s *Server) copyData(dst io.Writer, src io.Reader) error {
+	_, err := io.Copy(dst, src)
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	}
+	return err
+}
+
+// pump 单向转发隧道数据，并将转发错误记录到隧道。
+//
+// 参数：
+//   - dst: 目标写入器
+//   - src: 带隧道记录的源读取器
+func (s *Server) pump(dst io.Writer, src *activityReader) {
+	src.t.recordError(src, s.copyData(dst, src))
+}
+
+// relay 在客户端和上游之间双向转发隧道数据。
+//
+// 一方发送完毕（半关闭）后，另一方收到EOF，反方向的数据继续转发，
+// 使依赖FIN语义的协议能够正常结束。上游先结束时最多再等待 halfCloseTimeout
+// 让客户端发送剩余数据，之后由调用方关闭两个连接。
+//
+// 参数：
+//   - client: 客户端连接
+//   - upstream: 上游连接
+//   - t: 隧道记录
+func (s *Server) relay(client, upstream net.Conn, t *tunnel) {
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		s.pump(upstream, &activityReader{r: t.flow.Reader(client), t: t, count: &t.sent})
+	}()
+	s.pump(client, &activityReader{r: t.flow.Reader(upstream), t: t, count: &t.received, upstream: true})
+
+	timer := time.NewTimer(halfCloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-sent:
+	case <-timer.C:
+	}
 }
 
 // checkAuthTCP 检查TCP连接的代理认证。
 //
 // 验证客户端提供的认证凭据是否正确。如果未配置认证，
-// 则跳过验证。认证失败时发送407响应。
+// 则跳过验证。同一连接上携带相同认证头的后续请求直接视为通过，认证用户重新加载后重新验证。
+// 认证失败时记录失败并发送407响应；用户不在允许的访问时间段内时发送403响应。
 //
 // 参数：
 //   - conn: 客户端连接
+//   - info: 客户端连接信息
 //   - authHeader: 认证头字符串
 //
 // 返回值：
 //   - bool: 认证是否通过
-func (s *Server) checkAuthTCP(conn net.Conn, authHeader string) bool {
+func (s *Server) checkAuthTCP(conn net.Conn, info connInfo, authHeader string) bool {
+	generation := s.users.Generation()
+	if authHeader == "" || info.verified == nil || authHeader != info.verified.header || generation != info.verified.generation {
+		if !s.authorizedFrom(conn.RemoteAddr().String(), authHeader) {
+			s.authFailures.record(conn.RemoteAddr().String(), info.listener, authHeader)
+			s.sendAuthRequiredTCP(conn)
+			return false
+		}
+		if info.verified != nil {
+			*info.verified = authSeen{header: authHeader, generation: generation}
+		}
+	}
+	if err := s.checkAccess(authHeader); err != nil {
+		s.sendErrorTCP(conn, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+// checkAccess 检查认证用户当前是否在允许的访问时间段内。
+//
+// 参数：
+//   - authHeader: 认证头字符串
+//
+// 返回值：
+//   - error: 不在允许的时间段内时返回说明原因的错误
+func (s *Server) checkAccess(authHeader string) error {
+	if len(s.access) == 0 || authHeader == "" {
+		return nil
+	}
+	username, _, err := auth.DecodeBasicAuth(authHeader)
+	if err != nil {
+		return nil
+	}
+	if err := s.access.Check(username, time.Now()); err != nil {
+		log.Printf("拒绝访问时间段外的请求: %v", err)
+		return err
+	}
+	return nil
+}
+
+// authorized 验证认证头中的凭据。
+//
+// 参数：
+//   - authHeader: 认证头字符串
+//
+// 返回值：
+//   - bool: 认证是否通过，未配置认证时始终为true
+func (s *Server) authorized(authHeader string) bool {
 	// 如果没有设置认证，则跳过检查
-	if s.authUsername == "" && s.authPassword == "" {
+	if s.users == nil {
 		return true
 	}
 
 	// 检查是否有认证头
 	if authHeader == "" {
-		s.sendAuthRequiredTCP(conn)
 		return false
 	}
 
 	// 解析Basic认证
 	username, password, err := auth.DecodeBasicAuth(authHeader)
 	if err != nil {
-		s.sendAuthRequiredTCP(conn)
 		return false
 	}
 
 	// 验证用户名和密码
-	if username != s.authUsername || password != s.authPassword {
-		s.sendAuthRequiredTCP(conn)
-		return false
-	}
-
-	return true
+	return s.users.Verify(username, password)
 }
 
 // sendAuthRequiredTCP 发送TCP认证要求响应。
 //
 // 向客户端发送407 Proxy Authentication Required响应，
-// 要求客户端提供认证信息。
+// 按配置的认证质询要求客户端提供认证信息，并附带说明如何获取凭据的响应体。
 //
 // 参数：
 //   - conn: 客户端连接
 func (s *Server) sendAuthRequiredTCP(conn net.Conn) {
-	response := "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"ProxyFlow\"\r\n\r\n"
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 407 Proxy Authentication Required\r\n")
+	for _, value := range s.challenge.Headers() {
+		response.WriteString("Proxy-Authenticate: " + value + "\r\n")
+	}
+	if len(s.challenge.Body) > 0 {
+		response.WriteString("Content-Type: " + s.challenge.ContentType + "\r\n")
+	}
+	response.WriteString("Content-Length: " + strconv.Itoa(len(s.challenge.Body)) + "\r\nConnection: close\r\n\r\n")
+	response.Write(s.challenge.Body)
+	conn.Write([]byte(response.String()))
+}
+
+// writeAuthRequired 在HTTP/2连接上发送407响应，质询和响应体与 sendAuthRequiredTCP 相同。
+//
+// 参数：
+//   - w: 响应写入器
+func (s *Server) writeAuthRequired(w http.ResponseWriter) {
+	for _, value := range s.challenge.Headers() {
+		w.Header().Add("Proxy-Authenticate", value)
+	}
+	if len(s.challenge.Body) > 0 {
+		w.Header().Set("Content-Type", s.challenge.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.challenge.Body)))
+	w.WriteHeader(http.StatusProxyAuthRequired)
+	w.Write(s.challenge.Body)
+}
+
+// buildSelection 根据请求头构建代理选择条件。
+//
+// 会话ID取自 X-Proxy-Session，连接处理时已按会话来源规则把解析结果写入该请求头。
+//
+// 参数：
+//   - destHost: 目标主机名（不含端口）
+//   - headers: 请求头，键为小写
+//
+// 返回值：
+//   - pool.Selection: 代理选择条件
+func (s *Server) buildSelection(destHost string, headers map[string]string) pool.Selection {
+	return pool.Selection{
+		DestHost:  destHost,
+		Tags:      pool.ParseTags(headers[TagsHeader]),
+		SessionID: strings.TrimSpace(headers[SessionHeader]),
+	}
+}
+
+// isProxyControlHeader 判断请求头是否仅用于控制本代理，不应转发给上游。
+//
+// 参数：
+//   - key: 小写的请求头名称
+//
+// 返回值：
+//   - bool: 是否为代理控制头
+func isProxyControlHeader(key string) bool {
+	switch key {
+	case "proxy-authorization", "proxy-connection", TagsHeader, SessionHeader, ProfileHeader, userPoolKey:
+		return true
+	}
+	return false
+}
+
+// RotateSession 轮换粘性会话的上游代理。
+//
+// 解除会话与当前代理的绑定，并关闭该会话所有活跃的隧道，
+// 客户端重新建立连接时将分配到新的上游代理。
+//
+// 参数：
+//   - sessionID: 会话ID
+//
+// 返回值：
+//   - bool: 会话是否存在绑定
+//   - int: 关闭的隧道数量
+func (s *Server) RotateSession(sessionID string) (bool, int) {
+	previous, bound := s.pool.RotateSession(sessionID)
+	for _, up := range s.upstreams() {
+		if otherPrevious, otherBound := up.pool.RotateSession(sessionID); otherBound {
+			previous, bound = otherPrevious, true
+		}
+	}
+	closed := s.tunnels.closeSession(sessionID)
+	s.cookies.remove(sessionID)
+	if bound {
+		log.Printf("会话 %s 已轮换，原代理: %s，关闭隧道 %d 条", sessionID, s.formatProxyURL(previous), closed)
+	}
+	return bound, closed
+}
+
+// sendErrorTCP 发送带错误说明的HTTP错误响应。
+//
+// 响应体为纯文本错误信息，便于客户端定位失败原因。
+//
+// 参数：
+//   - conn: 客户端连接
+//   - status: HTTP状态码
+//   - message: 错误说明
+func (s *Server) sendErrorTCP(conn net.Conn, status int, message string) {
+	body := message + "\n"
+	response := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), len(body), body)
 	conn.Write([]byte(response))
 }
 
+// cancelOnClose 关闭时取消请求上下文的响应体。
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close 关闭响应体并取消请求上下文。
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// upstreamErrorStatus 根据上游错误选择返回给客户端的状态码。
+//
+// 连接上游或等待响应超时返回504，便于客户端的重试逻辑区分超时与其他失败；
+// 流量预算用尽、等待可用代理的请求过多、上游代理请求速率已达上限，或候选代理均处于冷却中
+// （错误附带重试等待时间估计）而拒绝请求时返回503；目标的每日请求数、客户端的请求速率或并发连接数已达上限，
+// 以及用户的流量配额用尽时返回429；
+// 其余错误返回502。
+//
+// 参数：
+//   - err: 上游错误
+//
+// 返回值：
+//   - int: HTTP状态码
+func upstreamErrorStatus(err error) int {
+	if errors.Is(err, quota.ErrCapExceeded) || errors.Is(err, ErrClientLimited) || errors.Is(err, traffic.ErrQuotaExceeded) {
+		return http.StatusTooManyRequests
+	}
+	if errors.Is(err, errBudgetExhausted) || errors.Is(err, pool.ErrQueueFull) || errors.Is(err, pool.ErrRateLimited) ||
+		retryAfter(err) > 0 {
+		return http.StatusServiceUnavailable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
 // formatProxyURL 格式化代理URL用于日志显示。
 //
-// 构建包含协议和主机信息的完整代理URL，如果包含认证信息则隐藏密码。
+// 构建包含协议和主机信息的完整代理URL，如果包含认证信息则隐藏密码；直连时返回 direct。
 //
 // 参数：
 //   - proxy: 代理服务器信息
@@ -479,6 +1617,9 @@ func (s *Server) sendAuthRequiredTCP(conn net.Conn) {
 // 返回值：
 //   - string: 格式化后的代理URL
 func (s *Server) formatProxyURL(proxy models.ProxyInfo) string {
+	if proxy.Host == directHost {
+		return directHost
+	}
 	if proxy.Username != "" {
 		return fmt.Sprintf("%s://%s:***@%s", proxy.URL.Scheme, proxy.Username, proxy.Host)
 	}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+)
+
+// 会话ID的来源名称。
+const (
+	// SessionFromHeader 取 X-Proxy-Session 请求头
+	SessionFromHeader = "header"
+	// SessionFromUsername 取认证用户名中分隔符之后的后缀，认证时使用去掉后缀的用户名
+	SessionFromUsername = "username"
+	// SessionFromPort 取入站端口的会话（PORT_SESSIONS 或动态端口）
+	SessionFromPort = "port"
+	// SessionFromIP 按客户端IP生成会话，会话ID为 ip-<客户端IP>
+	SessionFromIP = "ip"
+)
+
+// DefaultSessionSeparator 用户名中会话后缀的默认分隔符，例如 alice-session-job42
+const DefaultSessionSeparator = "-session-"
+
+// sessionResolver 按配置的来源顺序确定请求的粘性会话ID。
+//
+// HTTP/1.1、HTTP/2和SOCKS5共用同一套规则：依次尝试各来源，第一个非空的结果作为会话ID，
+// 会话绑定统一由代理池的会话存储管理。
+type sessionResolver struct {
+	sources   []string // 会话ID的来源，按优先级排列
+	separator string   // 用户名中会话后缀的分隔符
+	username  bool     // 是否启用 username 来源
+}
+
+// newSessionResolver 创建会话ID解析器。
+//
+// 参数：
+//   - sources: 会话ID的来源，为空时使用 header 和 port
+//   - separator: 用户名中会话后缀的分隔符，为空时使用 DefaultSessionSeparator
+//
+// 返回值：
+//   - *sessionResolver: 会话ID解析器
+func newSessionResolver(sources []string, separator string) *sessionResolver {
+	if len(sources) == 0 {
+		sources = []string{SessionFromHeader, SessionFromPort}
+	}
+	if separator == "" {
+		separator = DefaultSessionSeparator
+	}
+	r := &sessionResolver{sources: sources, separator: separator}
+	for _, source := range sources {
+		if source == SessionFromUsername {
+			r.username = true
+		}
+	}
+	return r
+}
+
+// validate 检查来源名称。
+//
+// 返回值：
+//   - error: 存在未知的来源名称
+func (r *sessionResolver) validate() error {
+	for _, source := range r.sources {
+		switch source {
+		case SessionFromHeader, SessionFromUsername, SessionFromPort, SessionFromIP:
+		default:
+			return fmt.Errorf("未知的会话来源: %s", source)
+		}
+	}
+	return nil
+}
+
+// splitUsername 从认证头的用户名中分离会话后缀。
+//
+// 参数：
+//   - authHeader: Proxy-Authorization头
+//
+// 返回值：
+//   - string: 去掉会话后缀后的认证头，没有后缀或未启用 username 来源时原样返回
+//   - string: 会话后缀，没有时为空
+func (r *sessionResolver) splitUsername(authHeader string) (string, string) {
+	if !r.username || authHeader == "" {
+		return authHeader, ""
+	}
+	username, password, err := auth.DecodeBasicAuth(authHeader)
+	if err != nil {
+		return authHeader, ""
+	}
+	base, session, ok := strings.Cut(username, r.separator)
+	if !ok || base == "" || session == "" {
+		return authHeader, ""
+	}
+	return auth.EncodeBasicAuth(base, password), session
+}
+
+// resolve 确定请求的会话ID，写入 headers[SessionHeader]。
+//
+// 启用 username 来源时，headers["proxy-authorization"] 中的用户名同时去掉会话后缀，
+// 之后的认证和按用户的配置都使用原用户名。
+//
+// 参数：
+//   - headers: 请求头，键为小写
+//   - client: 客户端地址
+//   - portSession: 入站端口的会话ID，没有时为空
+func (r *sessionResolver) resolve(headers map[string]string, client, portSession string) {
+	authHeader, userSession := r.splitUsername(headers["proxy-authorization"])
+	if userSession != "" {
+		headers["proxy-authorization"] = authHeader
+	}
+
+	session := ""
+	for _, source := range r.sources {
+		switch source {
+		case SessionFromHeader:
+			session = strings.TrimSpace(headers[SessionHeader])
+		case SessionFromUsername:
+			session = userSession
+		case SessionFromPort:
+			session = portSession
+		case SessionFromIP:
+			session = "ip-" + hostOnly(client)
+		}
+		if session != "" {
+			break
+		}
+	}
+	if session != "" {
+		headers[SessionHeader] = session
+	} else {
+		delete(headers, SessionHeader)
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 过载时的优先级，低优先级的流量最先被拒绝。
+const (
+	// PriorityHigh 高优先级，可使用全部连接数
+	PriorityHigh = "high"
+	// PriorityNormal 普通优先级
+	PriorityNormal = "normal"
+	// PriorityLow 低优先级
+	PriorityLow = "low"
+)
+
+// priorities 按从高到低排列的优先级。
+var priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+// priorityClass 一个优先级的准入上限和计数。
+type priorityClass struct {
+	limit    int64        // 该优先级准入时允许的最大在途连接数
+	admitted atomic.Int64 // 已准入的次数
+	shed     atomic.Int64 // 因过载被拒绝的次数
+}
+
+// loadShedder 按优先级准入请求和隧道。
+//
+// 在途连接数超过某个优先级的上限后，新到达的该优先级流量被拒绝，
+// 高优先级流量仍可使用剩余的容量，直到达到总上限。
+type loadShedder struct {
+	max      int64                     // 在途连接数上限
+	inFlight atomic.Int64              // 当前在途的请求和隧道数
+	classes  map[string]*priorityClass // 优先级到准入上限和计数的映射
+}
+
+// SheddingStats 负载削减统计。
+type SheddingStats struct {
+	MaxConnections int64                         `json:"max_connections"` // 在途连接数上限
+	InFlight       int64                         `json:"in_flight"`       // 当前在途的请求和隧道数
+	Classes        map[string]PriorityClassStats `json:"classes"`         // 按优先级统计
+}
+
+// PriorityClassStats 单个优先级的准入统计。
+type PriorityClassStats struct {
+	Limit    int64 `json:"limit"`    // 该优先级准入时允许的最大在途连接数
+	Admitted int64 `json:"admitted"` // 已准入的次数
+	Shed     int64 `json:"shed"`     // 因过载被拒绝的次数
+}
+
+// newLoadShedder 创建按优先级准入的负载削减器。
+//
+// 参数：
+//   - maxConns: 在途连接数上限，0表示不限制
+//   - lowPercent: 低优先级可使用的连接数百分比
+//   - normalPercent: 普通优先级可使用的连接数百分比
+//
+// 返回值：
+//   - *loadShedder: 负载削减器，未设置上限时为nil
+func newLoadShedder(maxConns, lowPercent, normalPercent int) *loadShedder {
+	if maxConns <= 0 {
+		return nil
+	}
+	limit := func(percent int) int64 {
+		percent = min(max(percent, 0), 100)
+		return int64(maxConns) * int64(percent) / 100
+	}
+	return &loadShedder{
+		max: int64(maxConns),
+		classes: map[string]*priorityClass{
+			PriorityHigh:   {limit: int64(maxConns)},
+			PriorityNormal: {limit: limit(normalPercent)},
+			PriorityLow:    {limit: limit(lowPercent)},
+		},
+	}
+}
+
+// normalizePriority 规范化优先级名称，未知的名称按普通优先级处理。
+func normalizePriority(priority string) string {
+	switch priority = strings.ToLower(strings.TrimSpace(priority)); priority {
+	case PriorityHigh, PriorityLow:
+		return priority
+	default:
+		return PriorityNormal
+	}
+}
+
+// admit 按优先级准入一个请求或隧道。
+//
+// 参数：
+//   - priority: 优先级名称
+//
+// 返回值：
+//   - func(): 请求或隧道结束时调用，释放占用的连接数；被拒绝时为nil
+//   - bool: 是否准入，未启用负载削减时始终为true
+func (l *loadShedder) admit(priority string) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	class := l.classes[normalizePriority(priority)]
+	if l.inFlight.Add(1) > class.limit {
+		l.inFlight.Add(-1)
+		class.shed.Add(1)
+		return nil, false
+	}
+	class.admitted.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { l.inFlight.Add(-1) }) }, true
+}
+
+// stats 返回负载削减统计。
+//
+// 返回值：
+//   - *SheddingStats: 负载削减统计，未启用时为nil
+func (l *loadShedder) stats() *SheddingStats {
+	if l == nil {
+		return nil
+	}
+	stats := &SheddingStats{
+		MaxConnections: l.max,
+		InFlight:       l.inFlight.Load(),
+		Classes:        make(map[string]PriorityClassStats, len(priorities)),
+	}
+	for _, name := range priorities {
+		class := l.classes[name]
+		stats.Classes[name] = PriorityClassStats{
+			Limit:    class.limit,
+			Admitted: class.admitted.Load(),
+			Shed:     class.shed.Load(),
+		}
+	}
+	return stats
+}
+
+// Shedding 返回按优先级的负载削减统计。
+//
+// 返回值：
+//   - *SheddingStats: 负载削减统计，未设置连接数上限时为nil
+func (s *Server) Shedding() *SheddingStats {
+	return s.shedder.stats()
+}
+
+// admitTCP 按客户端限流和优先级准入TCP连接上的请求。
+//
+// 客户端IP或用户超过请求速率或并发连接数上限时返回429，过载时返回503。
+//
+// 参数：
+//   - conn: 客户端连接
+//   - priority: 本次请求的优先级
+//   - authHeader: Proxy-Authorization头，用于按用户限流
+//
+// 返回值：
+//   - func(): 请求结束时调用的释放函数；被拒绝时为nil
+//   - bool: 是否准入
+func (s *Server) admitTCP(conn net.Conn, priority, authHeader string) (func(), bool) {
+	releaseClient, err := s.admitClient(conn.RemoteAddr().String(), authHeader)
+	if err != nil {
+		s.sendUpstreamErrorTCP(conn, http.StatusTooManyRequests, err)
+		return nil, false
+	}
+	release, ok := s.shedder.admit(priority)
+	if !ok {
+		releaseClient()
+		s.sendErrorTCP(conn, http.StatusServiceUnavailable, errOverloaded)
+		return nil, false
+	}
+	return func() {
+		release()
+		releaseClient()
+	}, true
+}
+
+// errOverloaded 过载拒绝请求时返回给客户端的说明。
+const errOverloaded = "代理服务器过载，请稍后重试"
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// otherDestinations 不在前N个目标主机中的目标归入的类别
+	otherDestinations = "other"
+	// sizeClassRefresh 重新选出前N个目标主机的间隔
+	sizeClassRefresh = time.Minute
+)
+
+// sizeBuckets 请求和响应大小直方图的桶上限（字节）
+var sizeBuckets = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20}
+
+// sizeHistogram 累计的大小分布。
+type sizeHistogram struct {
+	buckets []uint64 // 各桶的计数（不累加），最后一个为超过最大上限的计数
+	sum     float64  // 观测值总和
+	count   uint64   // 观测次数
+}
+
+// observe 记录一次观测。
+func (h *sizeHistogram) observe(size int64) {
+	if h.buckets == nil {
+		h.buckets = make([]uint64, len(sizeBuckets)+1)
+	}
+	i, _ := slices.BinarySearch(sizeBuckets, float64(size))
+	h.buckets[i]++
+	h.sum += float64(size)
+	h.count++
+}
+
+// write 按Prometheus文本格式输出直方图的样本。
+func (h *sizeHistogram) write(w io.Writer, name, destination string) {
+	label := escapeLabel(destination)
+	var cumulative uint64
+	for i, bound := range sizeBuckets {
+		if h.buckets != nil {
+			cumulative += h.buckets[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{destination=\"%s\",le=\"%s\"} %d\n", name, label, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{destination=\"%s\",le=\"+Inf\"} %d\n", name, label, h.count)
+	fmt.Fprintf(w, "%s_sum{destination=\"%s\"} %s\n", name, label, strconv.FormatFloat(h.sum, 'f', -1, 64))
+	fmt.Fprintf(w, "%s_count{destination=\"%s\"} %d\n", name, label, h.count)
+}
+
+// escapeLabel 转义Prometheus标签值中的反斜杠、双引号和换行。
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// sizeSeries 单个目标类别的请求和响应大小分布。
+type sizeSeries struct {
+	request  sizeHistogram // 请求大小
+	response sizeHistogram // 响应大小
+	demoted  time.Time     // 跌出前N个目标主机的时间，仍在其中时为零值
+}
+
+// sizeMetrics 按目标类别统计请求和响应大小的直方图。
+//
+// 传输量最大的前N个目标主机各自作为一个类别，其余目标归入 other，标签数量有上限。
+// 前N个主机每分钟按目标主机统计重新选出，每次观测计入当时所属的类别，
+// 因此各类别的计数只增不减；跌出前N的主机保留已有的计数，
+// 这类主机超过N个时丢弃最早跌出的，避免目标频繁变化时标签无限增长。
+type sizeMetrics struct {
+	topHosts  int                    // 单独统计的目标主机数
+	rank      func(n int) []string   // 返回传输量最大的前n个目标主机
+	classes   map[string]bool        // 当前单独统计的目标主机
+	refreshed time.Time              // 上次选出前N个主机的时间
+	series    map[string]*sizeSeries // 按目标类别索引的直方图
+	mutex     sync.Mutex             // 互斥锁
+}
+
+// newSizeMetrics 创建请求和响应大小直方图。
+//
+// 参数：
+//   - topHosts: 单独统计的目标主机数，0表示全部归入 other，小于0表示不统计
+//   - rank: 返回传输量最大的前n个目标主机
+//
+// 返回值：
+//   - *sizeMetrics: 大小直方图，不统计时为nil
+func newSizeMetrics(topHosts int, rank func(n int) []string) *sizeMetrics {
+	if topHosts < 0 {
+		return nil
+	}
+	return &sizeMetrics{
+		topHosts: topHosts,
+		rank:     rank,
+		classes:  make(map[string]bool),
+		series:   map[string]*sizeSeries{otherDestinations: {}},
+	}
+}
+
+// observe 记录一次请求的请求体和响应体大小。
+//
+// 参数：
+//   - host: 目标主机
+//   - sent: 请求大小（发往目标的字节数）
+//   - received: 响应大小（从目标收到的字节数）
+func (m *sizeMetrics) observe(host string, sent, received int64) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.refreshed) >= sizeClassRefresh {
+		m.refresh(now)
+	}
+	class := otherDestinations
+	if m.classes[host] {
+		class = host
+	}
+	series, ok := m.series[class]
+	if !ok {
+		series = &sizeSeries{}
+		m.series[class] = series
+	}
+	series.request.observe(sent)
+	series.response.observe(received)
+}
+
+// refresh 重新选出单独统计的目标主机，调用方需持有锁。
+func (m *sizeMetrics) refresh(now time.Time) {
+	m.refreshed = now
+	if m.topHosts == 0 {
+		return
+	}
+	m.classes = make(map[string]bool, m.topHosts)
+	for _, host := range m.rank(m.topHosts) {
+		m.classes[host] = true
+		if series, ok := m.series[host]; ok {
+			series.demoted = time.Time{}
+		}
+	}
+
+	var demoted []string
+	for class, series := range m.series {
+		if class == otherDestinations || m.classes[class] {
+			continue
+		}
+		if series.demoted.IsZero() {
+			series.demoted = now
+		}
+		demoted = append(demoted, class)
+	}
+	if len(demoted) <= m.topHosts {
+		return
+	}
+	slices.SortFunc(demoted, func(a, b string) int { return m.series[a].demoted.Compare(m.series[b].demoted) })
+	for _, class := range demoted[:len(demoted)-m.topHosts] {
+		delete(m.series, class)
+	}
+}
+
+// write 按Prometheus文本格式输出请求和响应大小直方图。
+func (m *sizeMetrics) write(w io.Writer) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	classes := make([]string, 0, len(m.series))
+	for class := range m.series {
+		classes = append(classes, class)
+	}
+	slices.Sort(classes)
+
+	fmt.Fprintln(w, "# HELP proxyflow_request_size_bytes 发往目标的请求体大小，隧道为客户端发送的总字节数")
+	fmt.Fprintln(w, "# TYPE proxyflow_request_size_bytes histogram")
+	for _, class := range classes {
+		m.series[class].request.write(w, "proxyflow_request_size_bytes", class)
+	}
+	fmt.Fprintln(w, "# HELP proxyflow_response_size_bytes 从目标收到的响应体大小，隧道为目标返回的总字节数")
+	fmt.Fprintln(w, "# TYPE proxyflow_response_size_bytes histogram")
+	for _, class := range classes {
+		m.series[class].response.write(w, "proxyflow_response_size_bytes", class)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/socks5"
+)
+
+// ListenerSOCKS SOCKS5监听器名称
+const ListenerSOCKS = "socks"
+
+// socksHandshakeTimeout SOCKS5握手（认证和CONNECT请求）的超时时间
+const socksHandshakeTimeout = 30 * time.Second
+
+// StartSOCKS 启动SOCKS5监听器。
+//
+// 客户端完成SOCKS5握手后，CONNECT请求与HTTP CONNECT隧道一样经上游代理池转发。
+// 配置了代理认证时要求用户名密码认证，凭据与HTTP代理相同；分层配置使用监听器名称 "socks"。
+//
+// 参数：
+//   - port: 监听端口号
+//
+// 返回值：
+//   - error: 监听器启动错误或运行中的致命错误，通过Shutdown关闭时为nil
+func (s *Server) StartSOCKS(port string) error {
+	listener, err := s.listen(port)
+	if err != nil {
+		return err
+	}
+	s.socksMutex.Lock()
+	s.socksListener = listener
+	s.socksMutex.Unlock()
+
+	log.Printf("SOCKS5监听器正在端口 %s 上启动", port)
+	return s.acceptLoop(listener, "SOCKS5监听器", s.handleSOCKS)
+}
+
+// shutdownSOCKS 关闭SOCKS5监听器，已建立的隧道继续运行直到自然结束。
+func (s *Server) shutdownSOCKS() {
+	s.socksMutex.Lock()
+	defer s.socksMutex.Unlock()
+
+	if s.socksListener != nil {
+		if err := s.socksListener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("关闭SOCKS5监听器时出错: %v", err)
+		}
+	}
+}
+
+// handleSOCKS 处理单个SOCKS5客户端连接。
+//
+// 认证、访问时间段、负载削减、目标检查和每日上限与HTTP CONNECT相同，
+// 拒绝时返回对应的SOCKS5应答码而不是HTTP状态码。
+//
+// 参数：
+//   - conn: 客户端连接
+func (s *Server) handleSOCKS(conn net.Conn) {
+	defer conn.Close()
+	start := time.Now()
+	log.Printf("新SOCKS5连接来自: %s", conn.RemoteAddr())
+
+	// 允许列表中免认证的客户端可以不提供凭据，提供了凭据时在握手后验证
+	bypassAuth := s.clientIPs.BypassesAuth(conn.RemoteAddr().String())
+	var authenticate func(username, password string) bool
+	if s.users != nil && !bypassAuth {
+		authenticate = func(username, password string) bool {
+			authHeader, _ := s.sessions.splitUsername(auth.EncodeBasicAuth(username, password))
+			authHeader, _ = s.userRoutes.split(authHeader)
+			return s.authorizedFrom(conn.RemoteAddr().String(), authHeader)
+		}
+	}
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	req, err := socks5.Accept(conn, authenticate)
+	if err != nil {
+		if errors.Is(err, socks5.ErrAuthFailed) {
+			s.authFailures.record(conn.RemoteAddr().String(), ListenerSOCKS, socksAuthHeader(req))
+		} else {
+			log.Printf("SOCKS5握手失败 %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	headers := map[string]string{"proxy-authorization": socksAuthHeader(req)}
+	s.sessions.resolve(headers, conn.RemoteAddr().String(), "")
+	s.userRoutes.apply(headers)
+	authHeader := headers["proxy-authorization"]
+	if bypassAuth && authHeader != "" && !s.authorizedFrom(conn.RemoteAddr().String(), authHeader) {
+		s.authFailures.record(conn.RemoteAddr().String(), ListenerSOCKS, socksAuthHeader(req))
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		return
+	}
+
+	// 维护模式下拒绝新连接，已建立的隧道继续运行直到自然结束
+	if s.maintenance.Load() != nil {
+		socks5.WriteReply(conn, socks5.ReplyGeneralFailure)
+		return
+	}
+	if err := s.checkAccess(authHeader); err != nil {
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		return
+	}
+	settings := s.settingsFor(ListenerSOCKS, authHeader)
+	releaseClient, err := s.admitClient(conn.RemoteAddr().String(), authHeader)
+	if err != nil {
+		log.Printf("拒绝SOCKS5请求 %s: %v", conn.RemoteAddr(), err)
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		return
+	}
+	defer releaseClient()
+	release, ok := s.shedder.admit(settings.Priority)
+	if !ok {
+		socks5.WriteReply(conn, socks5.ReplyGeneralFailure)
+		return
+	}
+	defer release()
+
+	destHost, destPort, _ := net.SplitHostPort(req.Addr)
+	if err := s.checkDestination(destHost, authHeader, conn.RemoteAddr().String()); err != nil {
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		return
+	}
+	poolName, err := s.boundPool("", headers)
+	if err != nil {
+		log.Printf("拒绝SOCKS5请求 %s: %v", conn.RemoteAddr(), err)
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		return
+	}
+	if err := s.checkCap(destHost, authHeader); err != nil {
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		return
+	}
+	if err := s.checkTraffic(authHeader); err != nil {
+		log.Printf("拒绝SOCKS5请求 %s: %v", conn.RemoteAddr(), err)
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		return
+	}
+	sel := s.buildSelection(destHost, headers)
+	sel.DestPort, _ = strconv.Atoi(destPort)
+	sel.Label = s.labelFor(ListenerSOCKS, authHeader, destHost)
+	dialStart := time.Now()
+	entry := newAccessEntry(conn.RemoteAddr().String(), authHeader, ListenerSOCKS, http.MethodConnect)
+	entry.Host = req.Addr
+	upstreamConn, proxy, err := s.dialUpstream(req.Addr, sel, poolName, settings.RequestTimeout)
+	if err != nil {
+		entry.Status, entry.Error, entry.Label = upstreamErrorStatus(err), err.Error(), sel.Label
+		s.accessLog.record(entry, dialStart)
+		socks5.WriteReply(conn, socksReplyCode(err))
+		return
+	}
+	defer upstreamConn.Close()
+	s.recordExit(authHeader, proxy.Host)
+	entry.Proxy = s.formatProxyURL(proxy)
+
+	// 登记隧道，用于统计以及会话轮换时关闭
+	t := newTunnel(conn, upstreamConn, proxy.Host, req.Addr, sel.SessionID)
+	t.label = sel.Label
+	t.flow = s.openFlow(authHeader)
+	s.tunnels.add(t)
+	defer s.releaseTunnel(t)
+	defer s.accessLog.recordTunnel(entry, t, dialStart)
+
+	if err := socks5.WriteReply(conn, socks5.ReplySucceeded); err != nil {
+		return
+	}
+
+	// 超过最大存活时间或空闲超时后关闭隧道
+	defer s.watchTunnel(t, start, settings)()
+
+	// 双向数据转发
+	s.relay(conn, upstreamConn, t)
+}
+
+// socksAuthHeader 将SOCKS5凭据转换为Basic认证头，以便复用按用户的配置和统计。
+func socksAuthHeader(req *socks5.Request) string {
+	if req == nil || req.Username == "" && req.Password == "" {
+		return ""
+	}
+	return auth.EncodeBasicAuth(req.Username, req.Password)
+}
+
+// socksReplyCode 将上游连接错误映射为SOCKS5应答码。
+func socksReplyCode(err error) byte {
+	var rejected *socks5.ReplyError
+	if errors.As(err, &rejected) {
+		return rejected.Code
+	}
+	switch upstreamErrorStatus(err) {
+	case http.StatusGatewayTimeout:
+		return socks5.ReplyTTLExpired
+	case http.StatusBadGateway:
+		return socks5.ReplyHostUnreachable
+	default:
+		return socks5.ReplyGeneralFailure
+	}
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TLSHandshakeTimeout TLS握手超时时间
+	TLSHandshakeTimeout = 10 * time.Second
+)
+
+// TLSOptions TLS代理监听器配置。
+type TLSOptions struct {
+	CertFile      string   // 证书文件路径
+	KeyFile       string   // 私钥文件路径
+	MinVersion    string   // 最低TLS版本（1.2或1.3），为空表示1.2
+	CipherSuites  []string // 允许的TLS 1.2密码套件名称，为空表示使用标准库默认值
+	Curves        []string // 允许的密钥交换曲线名称，为空表示使用标准库默认值
+	MaxHandshakes int      // 同时进行的握手数上限，0表示不限制
+	HandshakeRate int      // 单个来源IP每分钟允许的握手次数，0表示不限制
+
+	// Routes 同一端口上的其他逻辑端点，键为SNI主机名或 "alpn:<协议>"，
+	// 命中的连接交给对应的处理器，不经过代理认证；未命中的连接按代理处理
+	Routes map[string]http.Handler
+}
+
+// StartTLS 启动TLS代理监听器。
+//
+// 客户端通过TLS连接到代理本身（HTTPS代理）。握手时通过ALPN协商协议：
+// 协商为h2的连接交给HTTP/2服务处理，可在一条连接上复用多个代理请求，
+// 并支持扩展CONNECT（RFC 8441）；其余连接按HTTP/1.1代理协议处理。
+//
+// 作为公网入口，握手按来源IP限速并限制同时进行的握手数；
+// 服务端从不发起重协商，客户端发起的重协商请求会被标准库拒绝，
+// 也不接受0-RTT早期数据。
+//
+// 参数：
+//   - port: 监听端口号
+//   - opts: TLS监听器配置
+//
+// 返回值：
+//   - error: 监听器启动错误或运行中的致命错误，通过Shutdown关闭时为nil
+func (s *Server) StartTLS(port string, opts TLSOptions) error {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载TLS证书失败: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if err := applyTLSPolicy(tlsConfig, opts); err != nil {
+		return err
+	}
+	routes := newTLSRouter(opts.Routes)
+	tlsConfig.NextProtos = append(routes.protocols(), tlsConfig.NextProtos...)
+	limiter := newHandshakeLimiter(opts.MaxHandshakes, opts.HandshakeRate)
+
+	listener, err := s.listen(port)
+	if err != nil {
+		return err
+	}
+	listener = tls.NewListener(listener, tlsConfig)
+
+	h2Conns := newConnQueue(listener.Addr())
+	h2Server := &http.Server{
+		Handler:           http.HandlerFunc(s.serveHTTP2),
+		ReadHeaderTimeout: s.layers.Resolve(ListenerTLS, "").RequestTimeout,
+		MaxHeaderBytes:    s.headerLimits.maxBytes,
+	}
+
+	s.tlsMutex.Lock()
+	s.tlsListener = listener
+	s.h2Server = h2Server
+	s.tlsRoutes = routes
+	s.tlsMutex.Unlock()
+
+	go h2Server.Serve(h2Conns)
+	routes.serve(listener.Addr(), h2Server.ReadHeaderTimeout)
+
+	log.Printf("TLS代理监听器正在端口 %s 上启动（支持 h2、http/1.1）", port)
+	if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		log.Printf("提示: 未设置 GODEBUG=http2xconnect=1，HTTP/2扩展CONNECT不可用（还需要Go 1.24及以上构建）")
+	}
+
+	err = s.acceptLoop(listener, "TLS监听器", func(conn net.Conn) {
+		s.dispatchTLS(conn, h2Conns, routes, limiter)
+	})
+	h2Conns.Close()
+	routes.close()
+	return err
+}
+
+// dispatchTLS 完成TLS握手并按协商的应用层协议分发连接。
+//
+// 参数：
+//   - conn: 客户端TLS连接
+//   - h2Conns: HTTP/2连接队列
+//   - routes: 按SNI或ALPN分流的逻辑端点
+//   - limiter: 握手限速器
+func (s *Server) dispatchTLS(conn net.Conn, h2Conns *connQueue, routes *tlsRouter, limiter *handshakeLimiter) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		conn.Close()
+		return
+	}
+
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if !limiter.acquire(ip) {
+		conn.Close()
+		return
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(TLSHandshakeTimeout))
+	err := tlsConn.Handshake()
+	limiter.release()
+	if err != nil {
+		log.Printf("TLS握手失败 %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	if routes.dispatch(tlsConn) {
+		return
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		if !h2Conns.push(conn) {
+			conn.Close()
+		}
+		return
+	}
+
+	s.handleConnection(conn, ListenerTLS)
+}
+
+// tlsVersions 可配置的最低TLS版本。
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves 可配置的密钥交换曲线。
+var tlsCurves = map[string]tls.CurveID{
+	"x25519": tls.X25519,
+	"p256":   tls.CurveP256,
+	"p384":   tls.CurveP384,
+	"p521":   tls.CurveP521,
+}
+
+// applyTLSPolicy 将版本、密码套件和曲线策略应用到TLS配置。
+//
+// 密码套件只接受标准库认为安全的套件名称（如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256），
+// 仅作用于TLS 1.2；TLS 1.3的套件由标准库固定。
+//
+// 参数：
+//   - config: 待修改的TLS配置
+//   - opts: TLS监听器配置
+//
+// 返回值：
+//   - error: 配置中存在未知名称时返回错误
+func applyTLSPolicy(config *tls.Config, opts TLSOptions) error {
+	if opts.MinVersion != "" {
+		version, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return fmt.Errorf("不支持的最低TLS版本: %s", opts.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if len(opts.CipherSuites) > 0 {
+		secure := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			secure[suite.Name] = suite.ID
+		}
+		for _, name := range opts.CipherSuites {
+			id, ok := secure[name]
+			if !ok {
+				return fmt.Errorf("未知或不安全的TLS密码套件: %s", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	for _, name := range opts.Curves {
+		curve, ok := tlsCurves[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("不支持的TLS曲线: %s", name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+	return nil
+}
+
+// handshakeLimiter TLS握手限速器。
+//
+// 限制同时进行的握手数，并按来源IP统计每分钟的握手次数，
+// 防止大量ClientHello消耗CPU。超出限制的连接在握手前直接关闭。
+type handshakeLimiter struct {
+	pending chan struct{}  // 进行中握手的信号量，nil表示不限制
+	rate    int            // 单个IP每分钟允许的握手次数，0表示不限制
+	counts  map[string]int // 当前窗口内各IP的握手次数
+	window  time.Time      // 当前统计窗口的开始时间
+	mutex   sync.Mutex     // 互斥锁
+}
+
+// newHandshakeLimiter 创建握手限速器。
+func newHandshakeLimiter(maxPending, rate int) *handshakeLimiter {
+	l := &handshakeLimiter{
+		rate:   rate,
+		counts: make(map[string]int),
+		window: time.Now(),
+	}
+	if maxPending > 0 {
+		l.pending = make(chan struct{}, maxPending)
+	}
+	return l
+}
+
+// acquire 申请一次握手，成功后必须调用release。
+//
+// 参数：
+//   - ip: 来源IP
+//
+// 返回值：
+//   - bool: 是否允许握手
+func (l *handshakeLimiter) acquire(ip string) bool {
+	if l.rate > 0 {
+		l.mutex.Lock()
+		if time.Since(l.window) >= time.Minute {
+			l.counts = make(map[string]int)
+			l.window = time.Now()
+		}
+		l.counts[ip]++
+		exceeded := l.counts[ip] > l.rate
+		l.mutex.Unlock()
+		if exceeded {
+			log.Printf("来源 %s 的TLS握手过于频繁，拒绝连接", ip)
+			return false
+		}
+	}
+
+	if l.pending != nil {
+		select {
+		case l.pending <- struct{}{}:
+		default:
+			log.Printf("进行中的TLS握手已达上限，拒绝来自 %s 的连接", ip)
+			return false
+		}
+	}
+	return true
+}
+
+// release 结束一次握手。
+func (l *handshakeLimiter) release() {
+	if l.pending != nil {
+		<-l.pending
+	}
+}
+
+// shutdownTLS 关闭TLS监听器和HTTP/2服务。
+func (s *Server) shutdownTLS() {
+	s.tlsMutex.Lock()
+	defer s.tlsMutex.Unlock()
+
+	if s.tlsListener != nil {
+		if err := s.tlsListener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("关闭TLS监听器时出错: %v", err)
+		}
+	}
+	if s.h2Server != nil {
+		s.h2Server.Close()
+	}
+	s.tlsRoutes.close()
+}
+
+// connQueue 将已完成握手的连接交给http.Server的监听器适配。
+type connQueue struct {
+	addr   net.Addr      // 监听地址
+	conns  chan net.Conn // 待处理连接
+	closed chan struct{} // 关闭信号
+	once   sync.Once     // 保证只关闭一次
+}
+
+// newConnQueue 创建连接队列。
+func newConnQueue(addr net.Addr) *connQueue {
+	return &connQueue{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// push 将连接放入队列，队列已关闭时返回false。
+func (q *connQueue) push(conn net.Conn) bool {
+	select {
+	case q.conns <- conn:
+		return true
+	case <-q.closed:
+		return false
+	}
+}
+
+// Accept 实现net.Listener接口，返回队列中的下一个连接。
+func (q *connQueue) Accept() (net.Conn, error) {
+	select {
+	case conn := <-q.conns:
+		return conn, nil
+	case <-q.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 实现net.Listener接口，关闭队列。
+func (q *connQueue) Close() error {
+	q.once.Do(func() { close(q.closed) })
+	return nil
+}
+
+// Addr 实现net.Listener接口，返回监听地址。
+func (q *connQueue) Addr() net.Addr {
+	return q.addr
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// alpnRoutePrefix 按ALPN协议分流的路由键前缀
+const alpnRoutePrefix = "alpn:"
+
+// tlsRoute 一个逻辑端点：独立的HTTP服务及其连接队列。
+type tlsRoute struct {
+	key     string       // 路由键
+	handler http.Handler // 请求处理器
+	conns   *connQueue   // 分流到该端点的连接
+	server  *http.Server // 该端点的HTTP服务
+}
+
+// tlsRouter 在同一个TLS端口上按SNI或ALPN把连接分流到不同的逻辑端点。
+//
+// 防火墙只需开放一个端口，管理API等端点与代理共用TLS监听器。
+// 先按SNI主机名匹配，再按协商出的ALPN协议匹配，都未命中的连接按代理处理。
+type tlsRouter struct {
+	byName     map[string]*tlsRoute // SNI主机名（小写）到端点的映射
+	byProtocol map[string]*tlsRoute // ALPN协议到端点的映射
+	routes     []*tlsRoute          // 全部端点
+}
+
+// newTLSRouter 创建TLS分流器。
+//
+// 参数：
+//   - routes: 路由键到处理器的映射，键为SNI主机名或 "alpn:<协议>"
+//
+// 返回值：
+//   - *tlsRouter: 分流器，没有路由时为nil
+func newTLSRouter(routes map[string]http.Handler) *tlsRouter {
+	if len(routes) == 0 {
+		return nil
+	}
+	r := &tlsRouter{
+		byName:     make(map[string]*tlsRoute),
+		byProtocol: make(map[string]*tlsRoute),
+	}
+	for key, handler := range routes {
+		route := &tlsRoute{key: key, handler: handler}
+		if protocol, ok := strings.CutPrefix(key, alpnRoutePrefix); ok {
+			r.byProtocol[protocol] = route
+		} else {
+			r.byName[strings.ToLower(key)] = route
+		}
+		r.routes = append(r.routes, route)
+	}
+	return r
+}
+
+// protocols 返回需要在握手时声明的ALPN协议。
+func (r *tlsRouter) protocols() []string {
+	if r == nil {
+		return nil
+	}
+	result := make([]string, 0, len(r.byProtocol))
+	for protocol := range r.byProtocol {
+		result = append(result, protocol)
+	}
+	return result
+}
+
+// serve 为每个端点启动HTTP服务。
+//
+// 参数：
+//   - addr: TLS监听地址
+//   - readHeaderTimeout: 读取请求头的超时时间
+func (r *tlsRouter) serve(addr net.Addr, readHeaderTimeout time.Duration) {
+	if r == nil {
+		return
+	}
+	for _, route := range r.routes {
+		route.conns = newConnQueue(addr)
+		route.server = &http.Server{Handler: route.handler, ReadHeaderTimeout: readHeaderTimeout}
+		go route.server.Serve(route.conns)
+		log.Printf("TLS监听器已启用分流端点: %s", route.key)
+	}
+}
+
+// dispatch 将已完成握手的连接交给匹配的端点。
+//
+// 参数：
+//   - conn: 已完成握手的TLS连接
+//
+// 返回值：
+//   - bool: 连接是否已被端点接管，未命中时为false，由调用方按代理处理
+func (r *tlsRouter) dispatch(conn *tls.Conn) bool {
+	if r == nil {
+		return false
+	}
+	state := conn.ConnectionState()
+	var routed net.Conn = conn
+	route, ok := r.byName[strings.ToLower(state.ServerName)]
+	if !ok {
+		route, ok = r.byProtocol[state.NegotiatedProtocol]
+		// http.Server会直接关闭协商出未知ALPN协议的TLS连接，
+		// 隐藏TLS连接的类型，使其按HTTP/1.1处理
+		routed = plainConn{conn}
+	}
+	if !ok {
+		return false
+	}
+	if !route.conns.push(routed) {
+		conn.Close()
+	}
+	return true
+}
+
+// plainConn 隐藏底层连接具体类型的包装。
+type plainConn struct {
+	net.Conn
+}
+
+// close 关闭全部端点的HTTP服务。
+func (r *tlsRouter) close() {
+	if r == nil {
+		return
+	}
+	for _, route := range r.routes {
+		if route.server != nil {
+			route.server.Close()
+			route.conns.Close()
+		}
+	}
+}
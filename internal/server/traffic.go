@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/traffic"
+)
+
+// trafficUser 从Proxy-Authorization头中取出用于带宽限制和流量配额的用户名，匿名时为空。
+func trafficUser(authHeader string) string {
+	if authHeader == "" {
+		return ""
+	}
+	username, _, _ := auth.DecodeBasicAuth(authHeader)
+	return username
+}
+
+// checkTraffic 检查用户本周期的流量配额。
+//
+// 配额已用尽时返回附带重置时间的错误，调用方应以429拒绝请求。
+//
+// 参数：
+//   - authHeader: Proxy-Authorization头，用于确定用户
+//
+// 返回值：
+//   - error: 配额已用尽时返回 *traffic.QuotaError
+func (s *Server) checkTraffic(authHeader string) error {
+	return s.bandwidth.Check(trafficUser(authHeader))
+}
+
+// openFlow 为一个隧道或请求创建按连接和用户的带宽限制。
+//
+// 参数：
+//   - authHeader: Proxy-Authorization头，用于确定用户
+//
+// 返回值：
+//   - *traffic.Flow: 带宽限制，未启用时为nil
+func (s *Server) openFlow(authHeader string) *traffic.Flow {
+	return s.bandwidth.Open(trafficUser(authHeader))
+}
+
+// TrafficStatus 获取按连接和用户的带宽限制及各用户本周期的流量用量。
+//
+// 返回值：
+//   - traffic.Status: 带宽限制和流量用量
+func (s *Server) TrafficStatus() traffic.Status {
+	return s.bandwidth.Status()
+}
+
+// ResetTraffic 清零用户本周期的流量用量。
+//
+// 参数：
+//   - username: 用户名
+//
+// 返回值：
+//   - bool: 用户是否有用量记录
+func (s *Server) ResetTraffic(username string) bool {
+	return s.bandwidth.Reset(username)
+}
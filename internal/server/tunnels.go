@@ -0,0 +1,317 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/traffic"
+)
+
+// 隧道转发错误的类型。
+const (
+	copyErrorReset      = "reset"       // 连接被对端重置
+	copyErrorTimeout    = "timeout"     // 读写超时
+	copyErrorShortWrite = "short_write" // 写入的字节数少于读到的字节数
+	copyErrorBrokenPipe = "broken_pipe" // 向已关闭的连接写入
+	copyErrorQuota      = "quota"       // 用户的流量配额在传输中用尽
+	copyErrorOther      = "other"       // 其他错误
+)
+
+// tunnelError 隧道一个方向上的转发错误。
+type tunnelError struct {
+	upstream bool   // 出错的是否为上游一侧的连接
+	receive  bool   // 是否为目标发往客户端的方向
+	kind     string // 错误类型
+	err      error  // 原始错误
+}
+
+// Error 返回带方向和出错一侧的错误说明。
+func (e *tunnelError) Error() string {
+	direction, side := "上行", "客户端"
+	if e.receive {
+		direction = "下行"
+	}
+	if e.upstream {
+		side = "上游"
+	}
+	return fmt.Sprintf("%s %s %s: %v", direction, side, e.kind, e.err)
+}
+
+// Unwrap 返回原始错误。
+func (e *tunnelError) Unwrap() error {
+	return e.err
+}
+
+// key 返回用于统计的键，如 upstream_reset。
+func (e *tunnelError) key() string {
+	if e.upstream {
+		return "upstream_" + e.kind
+	}
+	return "client_" + e.kind
+}
+
+// classifyCopyError 返回转发错误的类型。
+//
+// 对端正常结束（EOF）和本端主动关闭连接（如隧道到期或会话轮换）不算错误。
+//
+// 返回值：
+//   - string: 错误类型，不算错误时为空
+func classifyCopyError(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return ""
+	case errors.Is(err, traffic.ErrQuotaExceeded):
+		return copyErrorQuota
+	case errors.Is(err, syscall.ECONNRESET):
+		return copyErrorReset
+	case errors.Is(err, syscall.EPIPE):
+		return copyErrorBrokenPipe
+	case errors.Is(err, io.ErrShortWrite):
+		return copyErrorShortWrite
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return copyErrorTimeout
+	default:
+		return copyErrorOther
+	}
+}
+
+// tunnel 一条活跃的CONNECT隧道。
+type tunnel struct {
+	clientConn   io.Closer     // 客户端连接（HTTP/2下为请求流）
+	upstreamConn net.Conn      // 上游代理连接
+	proxyHost    string        // 上游代理地址
+	destAddr     string        // 目标地址
+	sessionID    string        // 粘性会话ID，为空表示不属于任何会话
+	label        string        // 流量标签，为空表示未命中标签规则
+	flow         *traffic.Flow // 按连接和用户的带宽限制，nil表示不限制
+	startedAt    time.Time     // 隧道建立时间
+
+	lastActive atomic.Int64 // 最近一次传输数据的时间（UnixNano）
+	streaming  atomic.Bool  // 是否已被识别为流式长连接
+	sent       atomic.Int64 // 客户端发往目标的字节数
+	received   atomic.Int64 // 目标发往客户端的字节数
+
+	sendErr    atomic.Pointer[tunnelError] // 客户端发往目标方向的转发错误
+	receiveErr atomic.Pointer[tunnelError] // 目标发往客户端方向的转发错误
+}
+
+// newTunnel 创建隧道记录。
+func newTunnel(clientConn io.Closer, upstreamConn net.Conn, proxyHost, destAddr, sessionID string) *tunnel {
+	t := &tunnel{
+		clientConn:   clientConn,
+		upstreamConn: upstreamConn,
+		proxyHost:    proxyHost,
+		destAddr:     destAddr,
+		sessionID:    sessionID,
+		startedAt:    time.Now(),
+	}
+	t.touch()
+	return t
+}
+
+// close 关闭隧道两端的连接，使双向转发结束。
+func (t *tunnel) close() {
+	t.clientConn.Close()
+	t.upstreamConn.Close()
+}
+
+// touch 记录隧道的一次数据传输。
+func (t *tunnel) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor 返回隧道自最近一次传输以来的空闲时长。
+func (t *tunnel) idleFor() time.Duration {
+	return time.Since(time.Unix(0, t.lastActive.Load()))
+}
+
+// recordError 记录一个方向上的转发错误，每个方向只保留第一个错误。
+//
+// 参数：
+//   - src: 该方向的读取器，用于区分读取失败（读取一侧出错）和写入失败（另一侧出错）
+//   - err: 转发返回的错误
+func (t *tunnel) recordError(src *activityReader, err error) {
+	kind := classifyCopyError(err)
+	if kind == "" {
+		return
+	}
+	readFailed := src.err != nil && errors.Is(err, src.err)
+	e := &tunnelError{upstream: src.upstream == readFailed, receive: src.upstream, kind: kind, err: err}
+	if e.receive {
+		t.receiveErr.CompareAndSwap(nil, e)
+	} else {
+		t.sendErr.CompareAndSwap(nil, e)
+	}
+}
+
+// errors 返回隧道两个方向上的转发错误。
+func (t *tunnel) errors() []*tunnelError {
+	var errs []*tunnelError
+	for _, e := range []*tunnelError{t.sendErr.Load(), t.receiveErr.Load()} {
+		if e != nil {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// activityReader 在每次读到数据时刷新隧道活跃时间并累计字节数的读取器。
+type activityReader struct {
+	r        io.Reader
+	t        *tunnel
+	count    *atomic.Int64 // 累计字节数的计数器（隧道的sent或received）
+	upstream bool          // 是否读取上游一侧
+	err      error         // 最近一次读取的错误，用于区分转发错误发生在哪一侧
+}
+
+// Read 读取数据并刷新隧道活跃时间。
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.t.touch()
+		a.count.Add(int64(n))
+	}
+	a.err = err
+	return n, err
+}
+
+// ProxyTunnelStats 单个上游代理的隧道统计。
+type ProxyTunnelStats struct {
+	Active    int `json:"active"`    // 活跃隧道数（含流式隧道）
+	Streaming int `json:"streaming"` // 流式长连接隧道数
+}
+
+// TunnelStats 隧道统计快照。
+type TunnelStats struct {
+	Active    int                         `json:"active"`    // 活跃隧道总数
+	Streaming int                         `json:"streaming"` // 流式长连接隧道总数
+	PerProxy  map[string]ProxyTunnelStats `json:"per_proxy"` // 按上游代理统计
+	Errors    map[string]int64            `json:"errors"`    // 已结束隧道按出错一侧和类型的转发错误数，如 upstream_reset
+}
+
+// tunnelRegistry 活跃隧道登记表。
+//
+// 记录所有活跃隧道用于统计，并按粘性会话索引，
+// 以便在会话轮换时找到并关闭该会话仍在使用的隧道。
+type tunnelRegistry struct {
+	all       map[*tunnel]struct{}            // 全部活跃隧道
+	bySession map[string]map[*tunnel]struct{} // 会话ID到隧道集合的映射
+	errors    map[string]int64                // 已结束隧道的转发错误数
+	mutex     sync.Mutex                      // 互斥锁
+}
+
+// newTunnelRegistry 创建隧道登记表。
+func newTunnelRegistry() *tunnelRegistry {
+	return &tunnelRegistry{
+		all:       make(map[*tunnel]struct{}),
+		bySession: make(map[string]map[*tunnel]struct{}),
+		errors:    make(map[string]int64),
+	}
+}
+
+// add 登记一条隧道。
+func (r *tunnelRegistry) add(t *tunnel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.all[t] = struct{}{}
+	if t.sessionID == "" {
+		return
+	}
+	tunnels, ok := r.bySession[t.sessionID]
+	if !ok {
+		tunnels = make(map[*tunnel]struct{})
+		r.bySession[t.sessionID] = tunnels
+	}
+	tunnels[t] = struct{}{}
+}
+
+// remove 注销一条隧道，并累计其转发错误。
+func (r *tunnelRegistry) remove(t *tunnel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.all, t)
+	for _, e := range t.errors() {
+		r.errors[e.key()]++
+	}
+	tunnels, ok := r.bySession[t.sessionID]
+	if !ok {
+		return
+	}
+	delete(tunnels, t)
+	if len(tunnels) == 0 {
+		delete(r.bySession, t.sessionID)
+	}
+}
+
+// closeSession 关闭会话的全部隧道。
+//
+// 参数：
+//   - sessionID: 会话ID
+//
+// 返回值：
+//   - int: 关闭的隧道数量
+func (r *tunnelRegistry) closeSession(sessionID string) int {
+	r.mutex.Lock()
+	tunnels := r.bySession[sessionID]
+	delete(r.bySession, sessionID)
+	r.mutex.Unlock()
+
+	for t := range tunnels {
+		t.close()
+	}
+	return len(tunnels)
+}
+
+// closeProxy 关闭经由指定上游代理的全部隧道。
+//
+// 参数：
+//   - proxyHost: 上游代理地址
+//
+// 返回值：
+//   - int: 关闭的隧道数量
+func (r *tunnelRegistry) closeProxy(proxyHost string) int {
+	r.mutex.Lock()
+	var tunnels []*tunnel
+	for t := range r.all {
+		if t.proxyHost == proxyHost {
+			tunnels = append(tunnels, t)
+		}
+	}
+	r.mutex.Unlock()
+
+	for _, t := range tunnels {
+		t.close()
+	}
+	return len(tunnels)
+}
+
+// stats 生成隧道统计快照。
+func (r *tunnelRegistry) stats() TunnelStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := TunnelStats{PerProxy: make(map[string]ProxyTunnelStats), Errors: make(map[string]int64, len(r.errors))}
+	for key, n := range r.errors {
+		stats.Errors[key] = n
+	}
+	for t := range r.all {
+		proxyStats := stats.PerProxy[t.proxyHost]
+		proxyStats.Active++
+		stats.Active++
+		if t.streaming.Load() {
+			proxyStats.Streaming++
+			stats.Streaming++
+		}
+		stats.PerProxy[t.proxyHost] = proxyStats
+	}
+	return stats
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/budget"
+	"github.com/rfym21/ProxyFlow/internal/client"
+	"github.com/rfym21/ProxyFlow/internal/pool"
+)
+
+// upstream 一个代理池及其HTTP客户端。
+type upstream struct {
+	name   string         // 代理池名称
+	pool   *pool.Pool     // 代理池
+	client *client.Client // 对应的HTTP客户端
+}
+
+// ScheduleStatus 代理池切换计划状态。
+type ScheduleStatus struct {
+	Enabled bool               `json:"enabled"` // 是否配置了切换计划
+	Now     time.Time          `json:"now"`     // 服务器本地时间
+	Active  *pool.ScheduleRule `json:"active"`  // 当前生效的规则，nil表示使用主代理池
+	Pools   []string           `json:"pools"`   // 已配置的具名代理池
+}
+
+// upstreams 返回主代理池以外的全部代理池。
+func (s *Server) upstreams() []*upstream {
+	result := make([]*upstream, 0, len(s.scheduled)+1)
+	if s.fallback != nil {
+		result = append(result, s.fallback)
+	}
+	for _, up := range s.scheduled {
+		result = append(result, up)
+	}
+	return result
+}
+
+// upstreamFor 选择本次请求使用的代理池。
+//
+// 先按故障转移的当前层或切换计划和权重选出代理池；选中主代理池时，蓝绿切换试运行期间
+// 按比例改用候选代理池，提升后改用承载全部流量的代理池。最终使用主代理池且其流量预算已用尽时，
+// 按策略切换到备用代理池、拒绝请求或继续使用主代理池。
+// 没有配置备用代理池时，fallback策略等同于拒绝请求。
+// 绑定了代理池的请求（如来自绑定代理池的客户端代理）不参与切换计划。
+//
+// 参数：
+//   - bound: 绑定的代理池名称，为空表示按切换计划选择
+//
+// 返回值：
+//   - *upstream: 使用的代理池
+//   - bool: 流量是否计入主代理池预算
+//   - error: 预算用尽且请求被拒绝时返回包装errBudgetExhausted的错误
+func (s *Server) upstreamFor(bound string) (*upstream, bool, error) {
+	switch bound {
+	case "":
+		var name string
+		if s.failover != nil {
+			name = s.failover.Pick(s.healthyRatio)
+		} else {
+			name = s.schedule.Pick(time.Now())
+		}
+		if name == pool.DefaultPoolName {
+			name = s.deployment.pick()
+		}
+		if up, ok := s.scheduled[name]; ok {
+			return up, false, nil
+		}
+		if name == pool.FallbackPoolName && s.fallback != nil {
+			return s.fallback, false, nil
+		}
+	case pool.DefaultPoolName:
+	case pool.FallbackPoolName:
+		if s.fallback != nil {
+			return s.fallback, false, nil
+		}
+	default:
+		if up, ok := s.scheduled[bound]; ok {
+			return up, false, nil
+		}
+	}
+
+	primary := &upstream{name: pool.DefaultPoolName, pool: s.pool, client: s.client}
+	if !s.budget.Exhausted() {
+		return primary, true, nil
+	}
+	switch s.budget.Action() {
+	case budget.ActionFallback:
+		if s.fallback != nil {
+			return s.fallback, false, nil
+		}
+		return nil, false, budgetError{resetIn: s.budget.ResetIn()}
+	case budget.ActionBlock:
+		return nil, false, budgetError{resetIn: s.budget.ResetIn()}
+	default:
+		return primary, true, nil
+	}
+}
+
+// BudgetStatus 获取主代理池的流量预算状态。
+//
+// 返回值：
+//   - budget.Status: 流量预算状态
+func (s *Server) BudgetStatus() budget.Status {
+	return s.budget.Status()
+}
+
+// poolByName 按名称查找代理池。
+//
+// 参数：
+//   - name: 代理池名称，为空时表示主代理池
+//
+// 返回值：
+//   - *pool.Pool: 代理池，不存在时为nil
+func (s *Server) poolByName(name string) *pool.Pool {
+	switch name {
+	case "", pool.DefaultPoolName:
+		return s.pool
+	case pool.FallbackPoolName:
+		if s.fallback != nil {
+			return s.fallback.pool
+		}
+		return nil
+	}
+	if up, ok := s.scheduled[name]; ok {
+		return up.pool
+	}
+	return nil
+}
+
+// SetCredentials 在运行时更新或删除指定代理池中上游代理的凭据。
+//
+// 参数：
+//   - poolName: 代理池名称，为空时表示主代理池
+//   - host: 代理地址，pool.AllProxies表示该代理池的全部代理
+//   - creds: 新凭据，为nil时删除覆盖
+//
+// 返回值：
+//   - error: 代理池不存在时返回错误
+func (s *Server) SetCredentials(poolName, host string, creds *pool.Credentials) error {
+	p := s.poolByName(poolName)
+	if p == nil {
+		return fmt.Errorf("代理池 %s 不存在", poolName)
+	}
+	p.SetCredentials(host, creds)
+	return nil
+}
+
+// CredentialOverrides 获取各代理池设置了凭据覆盖的代理地址。
+//
+// 返回值：
+//   - map[string][]string: 按代理池名称索引的代理地址列表
+func (s *Server) CredentialOverrides() map[string][]string {
+	result := map[string][]string{pool.DefaultPoolName: s.pool.CredentialOverrides()}
+	if s.fallback != nil {
+		result[pool.FallbackPoolName] = s.fallback.pool.CredentialOverrides()
+	}
+	for name, up := range s.scheduled {
+		result[name] = up.pool.CredentialOverrides()
+	}
+	return result
+}
+
+// HealthStats 获取各代理池中代理的健康统计。
+//
+// 返回值：
+//   - map[string][]pool.ProxyHealth: 按代理池名称索引的健康统计
+func (s *Server) HealthStats() map[string][]pool.ProxyHealth {
+	stats := map[string][]pool.ProxyHealth{pool.DefaultPoolName: s.pool.HealthStats()}
+	if s.fallback != nil {
+		stats[pool.FallbackPoolName] = s.fallback.pool.HealthStats()
+	}
+	for name, up := range s.scheduled {
+		stats[name] = up.pool.HealthStats()
+	}
+	return stats
+}
+
+// ExitReports 获取各代理池的出口IP唯一性报告。
+//
+// 返回值：
+//   - map[string]pool.ExitReport: 按代理池名称索引的报告，备用代理池名为 pool.FallbackPoolName
+func (s *Server) ExitReports() map[string]pool.ExitReport {
+	reports := map[string]pool.ExitReport{pool.DefaultPoolName: s.pool.ExitReport()}
+	if s.fallback != nil {
+		reports[pool.FallbackPoolName] = s.fallback.pool.ExitReport()
+	}
+	for name, up := range s.scheduled {
+		reports[name] = up.pool.ExitReport()
+	}
+	return reports
+}
+
+// CertReports 获取各代理池观察到的目标TLS证书报告。
+//
+// 返回值：
+//   - map[string]pool.CertReport: 按代理池名称索引的报告，备用代理池名为 pool.FallbackPoolName
+func (s *Server) CertReports() map[string]pool.CertReport {
+	reports := map[string]pool.CertReport{pool.DefaultPoolName: s.pool.CertReport()}
+	if s.fallback != nil {
+		reports[pool.FallbackPoolName] = s.fallback.pool.CertReport()
+	}
+	for name, up := range s.scheduled {
+		reports[name] = up.pool.CertReport()
+	}
+	return reports
+}
+
+// SourceStats 获取各代理池的代理来源统计。
+//
+// 返回值：
+//   - map[string][]pool.SourceStats: 按代理池名称索引的来源统计，备用代理池名为 pool.FallbackPoolName
+func (s *Server) SourceStats() map[string][]pool.SourceStats {
+	stats := map[string][]pool.SourceStats{pool.DefaultPoolName: s.pool.SourceStats()}
+	if s.fallback != nil {
+		stats[pool.FallbackPoolName] = s.fallback.pool.SourceStats()
+	}
+	for name, up := range s.scheduled {
+		stats[name] = up.pool.SourceStats()
+	}
+	return stats
+}
+
+// ConcurrencyStats 获取各代理池中代理的自适应并发状态。
+//
+// 返回值：
+//   - map[string][]pool.ConcurrencyStats: 按代理池名称索引的并发状态，备用代理池名为 pool.FallbackPoolName
+func (s *Server) ConcurrencyStats() map[string][]pool.ConcurrencyStats {
+	stats := map[string][]pool.ConcurrencyStats{pool.DefaultPoolName: s.pool.ConcurrencyStats()}
+	for _, up := range s.upstreams() {
+		stats[up.name] = up.pool.ConcurrencyStats()
+	}
+	return stats
+}
+
+// ReuseStats 获取各代理池转发HTTP请求时的连接复用和TLS会话恢复统计。
+//
+// 返回值：
+//   - map[string]client.ReuseReport: 按代理池名称索引的统计，备用代理池名为 pool.FallbackPoolName
+func (s *Server) ReuseStats() map[string]client.ReuseReport {
+	stats := map[string]client.ReuseReport{pool.DefaultPoolName: s.client.ReuseStats()}
+	for _, up := range s.upstreams() {
+		stats[up.name] = up.client.ReuseStats()
+	}
+	return stats
+}
+
+// healthyRatio 返回指定代理池中健康代理的比例，代理池不存在时为0。
+func (s *Server) healthyRatio(name string) float64 {
+	if p := s.poolByName(name); p != nil {
+		return p.HealthyRatio()
+	}
+	return 0
+}
+
+// FailoverStatus 获取代理池故障转移的当前状态。
+//
+// 返回值：
+//   - pool.FailoverStatus: 故障转移状态
+func (s *Server) FailoverStatus() pool.FailoverStatus {
+	return s.failover.Status()
+}
+
+// ScheduleStatus 获取代理池切换计划的当前状态。
+//
+// 返回值：
+//   - ScheduleStatus: 切换计划状态
+func (s *Server) ScheduleStatus() ScheduleStatus {
+	now := time.Now()
+	status := ScheduleStatus{
+		Enabled: s.schedule != nil,
+		Now:     now,
+		Active:  s.schedule.Active(now),
+		Pools:   []string{pool.DefaultPoolName},
+	}
+	for name := range s.scheduled {
+		status.Pools = append(status.Pools, name)
+	}
+	return status
+}
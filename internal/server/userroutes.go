@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/pool"
+)
+
+const (
+	// UserPoolOption 用户名参数中选择代理池的键，例如 alice-pool-residential
+	UserPoolOption = "pool"
+	// userPoolKey 请求头映射中记录用户绑定的代理池的键，含冒号，不会与真实的请求头冲突
+	userPoolKey = ":pool"
+)
+
+// userRouter 按认证用户选择代理池和代理标签。
+//
+// 用户可以通过 USER_POOLS 固定绑定代理池，也可以像商业代理服务那样在用户名后附加参数，
+// 如 alice-pool-residential-country-us：pool 参数选择代理池，其他参数作为代理标签，
+// 认证和按用户的配置仍使用 alice。
+type userRouter struct {
+	pools map[string]string // 用户名到绑定的代理池名称
+	keys  map[string]bool   // 允许写在用户名中的参数键，为空表示不解析用户名参数
+}
+
+// newUserRouter 创建按用户的代理池选择。
+//
+// 参数：
+//   - pools: 用户名到代理池名称
+//   - keys: 允许写在用户名中的参数键，pool 表示代理池，其他键作为代理标签
+//
+// 返回值：
+//   - *userRouter: 按用户的代理池选择
+func newUserRouter(pools map[string]string, keys []string) *userRouter {
+	r := &userRouter{pools: pools, keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			r.keys[key] = true
+		}
+	}
+	return r
+}
+
+// split 从认证头的用户名中分离参数。
+//
+// 用户名按 - 分隔，从第一个允许的参数键开始到末尾必须全部是 键-值 对，否则视为没有参数，
+// 因此本身含有 - 的用户名（如 team-a）不受影响。
+//
+// 参数：
+//   - authHeader: Proxy-Authorization头
+//
+// 返回值：
+//   - string: 去掉参数后的认证头，没有参数时原样返回
+//   - map[string]string: 用户名中的参数，键为小写，没有参数时为nil
+func (r *userRouter) split(authHeader string) (string, map[string]string) {
+	if len(r.keys) == 0 || authHeader == "" {
+		return authHeader, nil
+	}
+	username, password, err := auth.DecodeBasicAuth(authHeader)
+	if err != nil {
+		return authHeader, nil
+	}
+	parts := strings.Split(username, "-")
+	for i := 1; i < len(parts); i++ {
+		if options := r.parseOptions(parts[i:]); options != nil {
+			return auth.EncodeBasicAuth(strings.Join(parts[:i], "-"), password), options
+		}
+	}
+	return authHeader, nil
+}
+
+// parseOptions 把用户名的后半部分解析为参数，不全是允许的 键-值 对时返回nil。
+func (r *userRouter) parseOptions(parts []string) map[string]string {
+	if len(parts)%2 != 0 {
+		return nil
+	}
+	options := make(map[string]string, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		key := strings.ToLower(parts[i])
+		if !r.keys[key] || parts[i+1] == "" {
+			return nil
+		}
+		options[key] = parts[i+1]
+	}
+	return options
+}
+
+// apply 去掉认证头用户名中的参数，记录用户选择的代理池并把其他参数合并到代理标签。
+//
+// 应在会话后缀分离之后调用。USER_POOLS 绑定了代理池的用户不能通过用户名参数改用其他代理池；
+// 与 X-Proxy-Tags 头中同名的标签以请求头为准。
+//
+// 参数：
+//   - headers: 请求头，键为小写
+func (r *userRouter) apply(headers map[string]string) {
+	authHeader, options := r.split(headers["proxy-authorization"])
+	if options != nil {
+		headers["proxy-authorization"] = authHeader
+	}
+
+	var poolName string
+	if username, _, err := auth.DecodeBasicAuth(authHeader); err == nil {
+		poolName = r.pools[username]
+	}
+	if poolName == "" {
+		poolName = options[UserPoolOption]
+	}
+	delete(options, UserPoolOption)
+	if poolName != "" {
+		headers[userPoolKey] = poolName
+	} else {
+		delete(headers, userPoolKey)
+	}
+
+	if len(options) == 0 {
+		return
+	}
+	tags := pool.ParseTags(headers[TagsHeader])
+	if tags == nil {
+		tags = make(map[string]string, len(options))
+	}
+	for key, value := range options {
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+	}
+	headers[TagsHeader] = pool.FormatTags(tags)
+}
+
+// boundPool 返回请求绑定的代理池。
+//
+// 入站端口绑定的代理池优先，其次是认证用户绑定或在用户名中选择的代理池。
+//
+// 参数：
+//   - portPool: 入站端口绑定的代理池，没有时为空
+//   - headers: 经过 userRouter.apply 处理的请求头
+//
+// 返回值：
+//   - string: 代理池名称，为空表示按切换计划选择
+//   - error: 用户名中选择的代理池不存在
+func (s *Server) boundPool(portPool string, headers map[string]string) (string, error) {
+	if portPool != "" {
+		return portPool, nil
+	}
+	name := headers[userPoolKey]
+	if name != "" && s.poolByName(name) == nil {
+		return "", fmt.Errorf("用户名指定的代理池 %s 不存在", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rfym21/ProxyFlow/internal/version"
+)
+
+// VersionHeader 标明处理请求的ProxyFlow构建版本的响应头，启用 VersionHeader 选项时添加
+const VersionHeader = "X-ProxyFlow-Version"
+
+// stampVersion 启用版本响应头时在响应头中写入构建版本。
+//
+// 参数：
+//   - header: 要写入的响应头
+func (s *Server) stampVersion(header http.Header) {
+	if s.versionHeader != "" {
+		header.Set(VersionHeader, s.versionHeader)
+	}
+}
+
+// connectEstablished 返回CONNECT隧道建立成功的响应，启用版本响应头时带上构建版本。
+func (s *Server) connectEstablished() []byte {
+	if s.versionHeader == "" {
+		return []byte("HTTP/1.1 200 Connection Established\r\n\r\n")
+	}
+	return []byte("HTTP/1.1 200 Connection Established\r\n" + VersionHeader + ": " + s.versionHeader + "\r\n\r\n")
+}
+
+// Version 返回当前程序的构建版本信息。
+//
+// 返回值：
+//   - version.Info: 版本信息
+func (s *Server) Version() version.Info {
+	return version.Get()
+}
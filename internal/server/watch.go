@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/auth"
+	"github.com/rfym21/ProxyFlow/internal/routing"
+	"github.com/rfym21/ProxyFlow/internal/watchlist"
+)
+
+// checkDestination 检查目标是否命中可疑目标规则。
+//
+// 命中拦截规则或动作为block的路由规则时返回错误，调用方应以403拒绝请求；
+// 命中可疑目标规则时还会记录告警事件并输出告警日志。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//   - authHeader: Proxy-Authorization头，用于记录认证用户
+//   - client: 客户端地址
+//
+// 返回值：
+//   - error: 目标被拦截时返回说明原因的错误
+func (s *Server) checkDestination(host, authHeader, client string) error {
+	if route, ok := s.routes.Peek(host); ok && route.Action == routing.ActionBlock {
+		s.routes.Match(host) // 计入规则的命中统计
+		return fmt.Errorf("目标 %s 已被路由规则 %s 禁止访问", host, route.Pattern)
+	}
+	rule := s.watchlist.Match(host)
+	if rule == nil {
+		return nil
+	}
+
+	var username string
+	if authHeader != "" {
+		username, _, _ = auth.DecodeBasicAuth(authHeader)
+	}
+	s.watchlist.Record(watchlist.Event{
+		Time:        time.Now(),
+		Destination: host,
+		Pattern:     rule.Pattern,
+		Action:      rule.Action,
+		User:        username,
+		Client:      client,
+	})
+	log.Printf("告警: 客户端 %s（用户 %q）访问可疑目标 %s，命中规则 %s，动作 %s",
+		client, username, host, rule.Pattern, rule.Action)
+
+	if rule.Action == watchlist.ActionBlock {
+		return fmt.Errorf("目标 %s 已被禁止访问", host)
+	}
+	return nil
+}
+
+// Alerts 返回可疑目标规则的命中统计和最近的告警事件。
+//
+// 返回值：
+//   - watchlist.Report: 告警统计
+func (s *Server) Alerts() watchlist.Report {
+	return s.watchlist.Report()
+}
+
+// hostOnly 去掉地址中的端口，没有端口时原样返回。
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
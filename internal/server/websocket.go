@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/websocket"
+)
+
+// ListenerWebSocket WebSocket监听器名称
+const ListenerWebSocket = "ws"
+
+// WebSocketOptions WebSocket监听器配置。
+type WebSocketOptions struct {
+	Path     string // 接受升级请求的路径，其他路径返回404
+	CertFile string // 证书文件路径，为空则使用明文HTTP
+	KeyFile  string // 私钥文件路径
+}
+
+// WebSocketHandler 返回把WebSocket连接当作代理客户端连接处理的HTTP处理器。
+//
+// 升级完成后，客户端在WebSocket上发送的字节流按普通代理客户端连接处理
+// （CONNECT隧道或HTTP代理请求，仍需代理认证），分层配置使用监听器名称 "ws"。
+//
+// 返回值：
+//   - http.Handler: 处理WebSocket升级请求的处理器
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.clientIPs.Permits(r.RemoteAddr) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			log.Printf("WebSocket升级失败 %s: %v", r.RemoteAddr, err)
+			return
+		}
+		s.serveConnection(conn, connInfo{listener: ListenerWebSocket, start: time.Now()})
+	})
+}
+
+// StartWebSocket 启动WebSocket监听器。
+//
+// 供只能访问80/443端口上Web服务的受限网络使用：客户端把代理流量封装在
+// 到指定路径的WebSocket连接中，由ProxyFlow解封后交给正常的代理池逻辑处理。
+//
+// 参数：
+//   - port: 监听端口号
+//   - opts: WebSocket监听器配置
+//
+// 返回值：
+//   - error: 监听器启动错误或运行中的致命错误，通过Shutdown关闭时为nil
+func (s *Server) StartWebSocket(port string, opts WebSocketOptions) error {
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(opts.Path, s.WebSocketHandler())
+
+	listener, err := s.listen(port)
+	if err != nil {
+		return err
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("加载TLS证书失败: %v", err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"http/1.1"},
+		})
+	}
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	s.wsMutex.Lock()
+	s.wsServer = server
+	s.wsMutex.Unlock()
+
+	log.Printf("WebSocket监听器正在端口 %s 上启动（路径: %s，TLS: %v）", port, opts.Path, opts.CertFile != "")
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) && !s.shuttingDown.Load() {
+		return err
+	}
+	return nil
+}
+
+// shutdownWebSocket 关闭WebSocket监听器及其连接。
+func (s *Server) shutdownWebSocket() {
+	s.wsMutex.Lock()
+	defer s.wsMutex.Unlock()
+
+	if s.wsServer != nil {
+		if err := s.wsServer.Close(); err != nil {
+			log.Printf("关闭WebSocket监听器时出错: %v", err)
+		}
+	}
+}
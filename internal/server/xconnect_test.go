@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"go/version"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/rfym21/ProxyFlow/internal/pool"
+	"github.com/rfym21/ProxyFlow/internal/routing"
+)
+
+// settingEnableConnectProtocol RFC 8441 定义的 SETTINGS_ENABLE_CONNECT_PROTOCOL
+const settingEnableConnectProtocol http2.SettingID = 0x8
+
+// writeTestCert 生成自签名证书并写入临时目录。
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "proxyflow-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// startWebSocketEcho 启动接受WebSocket升级后原样回显数据的目标服务器。
+func startWebSocketEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil || !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") || req.Header.Get("Sec-WebSocket-Key") == "" {
+					io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+				io.Copy(conn, reader)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startTLSProxy 启动只有TLS监听器的代理，到127.0.0.1的连接直连，返回监听地址。
+func startTLSProxy(t *testing.T) string {
+	t.Helper()
+	proxyPool, err := pool.NewPool("", pool.Options{List: []string{"http://127.0.0.2:9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(proxyPool.Close)
+	bypass, err := routing.ParseBypass([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(proxyPool, Options{Bypass: bypass})
+	t.Cleanup(func() { s.Shutdown() })
+
+	certFile, keyFile := writeTestCert(t)
+	go s.StartTLS("0", TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.tlsMutex.Lock()
+		listener := s.tlsListener
+		s.tlsMutex.Unlock()
+		if listener != nil {
+			_, port, _ := net.SplitHostPort(listener.Addr().String())
+			return net.JoinHostPort("127.0.0.1", port)
+		}
+	}
+	t.Fatal("TLS监听器未启动")
+	return ""
+}
+
+func TestExtendedConnectWebSocket(t *testing.T) {
+	if version.Compare(runtime.Version(), "go1.24") < 0 {
+		t.Skip("标准库HTTP/2服务端从Go 1.24起支持扩展CONNECT")
+	}
+	if godebug := os.Getenv("GODEBUG"); !strings.Contains(godebug, "http2xconnect=1") {
+		// 标准库在初始化时读取该设置，只能在设置了环境变量的子进程中启用
+		cmd := exec.Command(os.Args[0], "-test.run=^TestExtendedConnectWebSocket$", "-test.v")
+		cmd.Env = append(os.Environ(), "GODEBUG="+strings.TrimPrefix(godebug+",http2xconnect=1", ","))
+		if out, err := cmd.CombinedOutput(); err != nil || !bytes.Contains(out, []byte("--- PASS")) {
+			t.Fatalf("子进程测试失败: %v\n%s", err, out)
+		}
+		return
+	}
+
+	target := startWebSocketEcho(t)
+	proxyAddr := startTLSProxy(t)
+
+	conn, err := tls.Dial("tcp", proxyAddr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "h2" {
+		t.Fatalf("协商的协议 = %q，期望 h2", proto)
+	}
+
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	framer := http2.NewFramer(conn, conn)
+	if err := framer.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端的第一个SETTINGS帧必须声明支持扩展CONNECT
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, ok := frame.(*http2.SettingsFrame)
+	if !ok {
+		t.Fatalf("第一个帧 = %T，期望 SETTINGS", frame)
+	}
+	if value, ok := settings.Value(settingEnableConnectProtocol); !ok || value != 1 {
+		t.Fatal("服务端未声明 SETTINGS_ENABLE_CONNECT_PROTOCOL")
+	}
+	if err := framer.WriteSettingsAck(); err != nil {
+		t.Fatal(err)
+	}
+
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	for _, field := range [][2]string{
+		{":method", "CONNECT"},
+		{":protocol", "websocket"},
+		{":scheme", "http"},
+		{":authority", target},
+		{":path", "/chat"},
+		{"sec-websocket-version", "13"},
+	} {
+		encoder.WriteField(hpack.HeaderField{Name: field[0], Value: field[1]})
+	}
+	if err := framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block.Bytes(), EndHeaders: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := hpack.NewDecoder(4096, nil)
+	status := ""
+	for status == "" {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatalf("读取响应失败: %v", err)
+		}
+		switch f := frame.(type) {
+		case *http2.HeadersFrame:
+			fields, err := decoder.DecodeFull(f.HeaderBlockFragment())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range fields {
+				if field.Name == ":status" {
+					status = field.Value
+				}
+			}
+		case *http2.RSTStreamFrame:
+			t.Fatalf("流被重置: %v", f.ErrCode)
+		case *http2.GoAwayFrame:
+			t.Fatalf("连接被关闭: %v", f.ErrCode)
+		}
+	}
+	if status != "200" {
+		t.Fatalf("扩展CONNECT响应状态 = %s，期望 200", status)
+	}
+
+	if err := framer.WriteData(1, false, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatalf("读取回显失败: %v", err)
+		}
+		if data, ok := frame.(*http2.DataFrame); ok && data.StreamID == 1 && len(data.Data()) > 0 {
+			if got := string(data.Data()); got != "ping" {
+				t.Errorf("回显 = %q，期望 ping", got)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,386 @@
+// Package shadowsocks 提供以Shadowsocks服务器作为上游代理的连接能力。
+//
+// 实现AEAD加密方式的TCP客户端（aes-128-gcm、aes-192-gcm、aes-256-gcm、
+// chacha20-ietf-poly1305），上游条目写作SIP002格式的 ss:// URI，
+// 也兼容整体base64编码的旧格式。目标主机名交给服务器解析。
+//
+// 加密方式和密码保存在 models.ProxyInfo 的 Username 和 Password 中。
+package shadowsocks
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// Scheme Shadowsocks上游的URL协议
+const Scheme = "ss"
+
+// maxPayload 单个加密块的最大负载字节数
+const maxPayload = 0x3FFF
+
+// 目标地址类型
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// cipherSpec 一种AEAD加密方式。
+type cipherSpec struct {
+	keySize int                                   // 密钥和盐的字节数
+	newAEAD func(key []byte) (cipher.AEAD, error) // 按子密钥创建AEAD
+}
+
+// ciphers 支持的加密方式
+var ciphers = map[string]cipherSpec{
+	"aes-128-gcm":            {16, newGCM},
+	"aes-192-gcm":            {24, newGCM},
+	"aes-256-gcm":            {32, newGCM},
+	"chacha20-ietf-poly1305": {32, chacha20poly1305.New},
+}
+
+// newGCM 创建AES-GCM。
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NormalizeURI 将旧格式的 ss://base64(method:password@host:port) 转换为SIP002格式。
+//
+// 旧格式的base64中可能含有 "/"、"+" 等无法作为URL主机的字符，需要在解析URL前转换；
+// 其他格式原样返回。
+//
+// 参数：
+//   - raw: ss:// URI，可带 #片段
+//
+// 返回值：
+//   - string: SIP002格式的URI
+//   - error: 旧格式无法解码时返回错误
+func NormalizeURI(raw string) (string, error) {
+	rest, ok := strings.CutPrefix(raw, Scheme+"://")
+	if !ok {
+		return raw, nil
+	}
+	encoded, fragment, _ := strings.Cut(rest, "#")
+	if strings.Contains(encoded, "@") {
+		return raw, nil
+	}
+
+	decoded, err := decodeBase64(encoded)
+	if err != nil {
+		return "", fmt.Errorf("无效的Shadowsocks URI: %v", err)
+	}
+	at := strings.LastIndex(decoded, "@")
+	if at < 0 {
+		return "", errors.New("无效的Shadowsocks URI: 缺少服务器地址")
+	}
+	normalized := Scheme + "://" + base64.RawURLEncoding.EncodeToString([]byte(decoded[:at])) + "@" + decoded[at+1:]
+	if fragment != "" {
+		normalized += "#" + fragment
+	}
+	return normalized, nil
+}
+
+// ParseURL 解析SIP002格式的 ss:// URL，返回加密方式和密码。
+//
+// 用户信息可以是 base64url(method:password)，也可以是未编码的 method:password。
+// 需要插件（plugin 参数）的服务器不受支持。
+//
+// 参数：
+//   - u: 经 NormalizeURI 转换后解析的URL
+//
+// 返回值：
+//   - string: 加密方式
+//   - string: 密码
+//   - error: 格式错误或加密方式不受支持时返回错误
+func ParseURL(u *url.URL) (method, password string, err error) {
+	if u.Query().Get("plugin") != "" {
+		return "", "", errors.New("不支持需要插件的Shadowsocks服务器")
+	}
+	if u.User == nil {
+		return "", "", errors.New("无效的Shadowsocks URI: 缺少加密方式和密码")
+	}
+
+	userinfo := u.User.Username()
+	if pass, ok := u.User.Password(); ok {
+		userinfo += ":" + pass
+	} else if userinfo, err = decodeBase64(userinfo); err != nil {
+		return "", "", fmt.Errorf("无效的Shadowsocks用户信息: %v", err)
+	}
+
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok || password == "" {
+		return "", "", errors.New("无效的Shadowsocks URI: 缺少密码")
+	}
+	method = strings.ToLower(method)
+	if _, ok := ciphers[method]; !ok {
+		return "", "", fmt.Errorf("不支持的Shadowsocks加密方式: %s", method)
+	}
+	if u.Port() == "" {
+		return "", "", fmt.Errorf("Shadowsocks服务器地址缺少端口: %s", u.Host)
+	}
+	return method, password, nil
+}
+
+// decodeBase64 解码可能省略填充的标准或URL安全base64。
+func decodeBase64(s string) (string, error) {
+	s = strings.TrimRight(s, "=")
+	for _, enc := range []*base64.Encoding{base64.RawURLEncoding, base64.RawStdEncoding} {
+		if decoded, err := enc.DecodeString(s); err == nil {
+			return string(decoded), nil
+		}
+	}
+	return "", errors.New("base64解码失败")
+}
+
+// Dial 经Shadowsocks服务器连接目标地址。
+//
+// 参数：
+//   - ctx: 控制连接建立的上下文
+//   - proxy: Shadowsocks上游信息，Username为加密方式，Password为密码
+//   - addr: 目标地址（host:port格式）
+//
+// 返回值：
+//   - net.Conn: 已发送目标地址的加密连接
+//   - error: 连接失败的原因
+func Dial(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+	spec, ok := ciphers[proxy.Username]
+	if !ok {
+		return nil, fmt.Errorf("不支持的Shadowsocks加密方式: %s", proxy.Username)
+	}
+	target, err := encodeAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := dialer.DialProxy(ctx, proxy)
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{Conn: raw, spec: spec, key: deriveKey(proxy.Password, spec.keySize)}
+	if deadline, ok := ctx.Deadline(); ok {
+		raw.SetWriteDeadline(deadline)
+	}
+	if _, err := conn.Write(target); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	raw.SetWriteDeadline(time.Time{})
+	return conn, nil
+}
+
+// upstream 注册到 dialer 包的Shadowsocks上游拨号器。
+type upstream struct{}
+
+func init() {
+	dialer.Register(Scheme, upstream{})
+}
+
+// DialContext 经Shadowsocks服务器连接目标地址，见 Dial。
+func (upstream) DialContext(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+	return Dial(ctx, proxy, addr)
+}
+
+// Normalize 将旧格式的URI转换为SIP002格式，见 NormalizeURI。
+func (upstream) Normalize(raw string) (string, error) {
+	return NormalizeURI(raw)
+}
+
+// Prepare 将用户信息解码为加密方式和密码，分别存入Username和Password。
+func (upstream) Prepare(proxy *models.ProxyInfo) error {
+	var err error
+	proxy.Username, proxy.Password, err = ParseURL(proxy.URL)
+	return err
+}
+
+// encodeAddr 将目标地址编码为SOCKS地址格式。
+func encodeAddr(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口: %s", portStr)
+	}
+
+	var buf []byte
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("主机名过长: %s", host)
+		}
+		buf = append([]byte{atypDomain, byte(len(host))}, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		buf = append([]byte{atypIPv4}, ip4...)
+	} else {
+		buf = append([]byte{atypIPv6}, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// deriveKey 按 EVP_BytesToKey（MD5）从密码派生主密钥。
+func deriveKey(password string, size int) []byte {
+	var key, prev []byte
+	for len(key) < size {
+		sum := md5.Sum(append(prev, password...))
+		prev = sum[:]
+		key = append(key, prev...)
+	}
+	return key[:size]
+}
+
+// Conn Shadowsocks AEAD加密连接。
+//
+// 每个方向以随机盐开头，之后是若干加密块：2字节长度和负载分别加密，
+// 各自带认证标签，nonce为从0开始递增的小端计数器。
+type Conn struct {
+	net.Conn
+	spec cipherSpec // 加密方式
+	key  []byte     // 主密钥
+
+	writer     cipher.AEAD // 发送方向的AEAD，首次写入时创建
+	writeNonce []byte      // 发送方向的nonce
+	writeBuf   []byte      // 加密输出缓冲
+
+	reader    cipher.AEAD // 接收方向的AEAD，首次读取时创建
+	readNonce []byte      // 接收方向的nonce
+	readBuf   []byte      // 已解密尚未读取的数据
+}
+
+// newAEAD 按盐派生子密钥并创建AEAD。
+func (c *Conn) newAEAD(salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, c.spec.keySize)
+	if _, err := io.ReadFull(hkdf.New(sha1.New, c.key, salt, []byte("ss-subkey")), subkey); err != nil {
+		return nil, err
+	}
+	return c.spec.newAEAD(subkey)
+}
+
+// Write 加密并发送数据。
+func (c *Conn) Write(p []byte) (int, error) {
+	out := c.writeBuf[:0]
+	if c.writer == nil {
+		salt := make([]byte, c.spec.keySize)
+		if _, err := rand.Read(salt); err != nil {
+			return 0, err
+		}
+		aead, err := c.newAEAD(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.writer, c.writeNonce = aead, make([]byte, aead.NonceSize())
+		out = append(out, salt...)
+	}
+
+	for written := 0; written < len(p); {
+		chunk := p[written:min(written+maxPayload, len(p))]
+		out = c.writer.Seal(out, c.writeNonce, binary.BigEndian.AppendUint16(nil, uint16(len(chunk))), nil)
+		increment(c.writeNonce)
+		out = c.writer.Seal(out, c.writeNonce, chunk, nil)
+		increment(c.writeNonce)
+		written += len(chunk)
+	}
+	c.writeBuf = out
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read 读取并解密数据。
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(c.readBuf) == 0 {
+		if err := c.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// readChunk 读取并解密下一个加密块。
+func (c *Conn) readChunk() error {
+	if c.reader == nil {
+		salt := make([]byte, c.spec.keySize)
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return err
+		}
+		aead, err := c.newAEAD(salt)
+		if err != nil {
+			return err
+		}
+		c.reader, c.readNonce = aead, make([]byte, aead.NonceSize())
+	}
+
+	overhead := c.reader.Overhead()
+	header := make([]byte, 2+overhead)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return err
+	}
+	length, err := c.reader.Open(header[:0], c.readNonce, header, nil)
+	if err != nil {
+		return errors.New("Shadowsocks数据认证失败，密码或加密方式可能不正确")
+	}
+	increment(c.readNonce)
+
+	size := int(binary.BigEndian.Uint16(length)) & maxPayload
+	payload := make([]byte, size+overhead)
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return unexpectedEOF(err)
+	}
+	if c.readBuf, err = c.reader.Open(payload[:0], c.readNonce, payload, nil); err != nil {
+		return errors.New("Shadowsocks数据认证失败")
+	}
+	increment(c.readNonce)
+	return nil
+}
+
+// CloseWrite 关闭发送方向，底层连接支持半关闭时生效。
+func (c *Conn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
+
+// increment 将小端nonce加一。
+func increment(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// unexpectedEOF 将块中途结束的EOF转换为 io.ErrUnexpectedEOF。
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,456 @@
+// Package slo 提供上游代理的服务水平目标（SLO）监控。
+//
+// 按代理池（可选按单个代理）在滑动窗口内统计上游连接的延迟分位数和错误率，
+// 与配置的目标比较；目标被违反或恢复时记录告警事件、输出日志，
+// 并可推送到Webhook，便于在代理供应商质量下降时及时发现。
+package slo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MetricErrorRate 错误率指标
+	MetricErrorRate = "error_rate"
+
+	// ScopePool 按代理池统计
+	ScopePool = "pool"
+	// ScopeProxy 按单个代理统计
+	ScopeProxy = "proxy"
+
+	// StateViolated 目标被违反
+	StateViolated = "violated"
+	// StateRecovered 目标恢复
+	StateRecovered = "recovered"
+
+	// maxSamples 每个统计对象在窗口内保留的样本数上限
+	maxSamples = 10000
+	// maxTargets 最多统计的对象数（代理池和代理）
+	maxTargets = 10000
+	// maxEvents 保留的最近告警事件数
+	maxEvents = 200
+	// webhookTimeout 推送Webhook的超时时间
+	webhookTimeout = 5 * time.Second
+)
+
+// Objective 单个服务水平目标。
+type Objective struct {
+	Spec       string  // 原始写法，例如 "latency_p95<800ms"
+	Metric     string  // 指标：latency_pNN或error_rate
+	Percentile float64 // 延迟分位数（0-100），错误率指标为0
+	Threshold  float64 // 阈值：延迟为毫秒，错误率为0-1之间的比例
+}
+
+// Options SLO监控配置。
+type Options struct {
+	Objectives []Objective   // 服务水平目标
+	Window     time.Duration // 滑动窗口长度
+	MinSamples int           // 窗口内样本数少于该值时不评估
+	PerProxy   bool          // 是否同时按单个代理评估
+	Interval   time.Duration // 评估间隔
+	Webhook    string        // 告警推送地址，为空则不推送
+	Client     *http.Client  // 推送使用的HTTP客户端，nil表示默认客户端
+}
+
+// Event 一次目标状态变化的告警事件。
+type Event struct {
+	Time      time.Time `json:"time"`      // 发生时间
+	Scope     string    `json:"scope"`     // 统计范围：pool或proxy
+	Target    string    `json:"target"`    // 代理池名称，按代理统计时为 代理池/代理地址
+	Objective string    `json:"objective"` // 目标写法
+	Value     float64   `json:"value"`     // 评估时的实际值（毫秒或比例）
+	Samples   int       `json:"samples"`   // 评估时窗口内的样本数
+	State     string    `json:"state"`     // violated或recovered
+}
+
+// TargetStatus 单个统计对象的状态。
+type TargetStatus struct {
+	Scope      string             `json:"scope"`      // 统计范围
+	Target     string             `json:"target"`     // 统计对象
+	Samples    int                `json:"samples"`    // 窗口内的样本数
+	Values     map[string]float64 `json:"values"`     // 各目标最近一次评估的实际值
+	Violations []string           `json:"violations"` // 当前被违反的目标
+}
+
+// Status SLO监控状态。
+type Status struct {
+	Enabled    bool           `json:"enabled"`    // 是否配置了目标
+	Objectives []string       `json:"objectives"` // 目标写法
+	Window     string         `json:"window"`     // 滑动窗口长度
+	Targets    []TargetStatus `json:"targets"`    // 各统计对象的状态
+	Events     []Event        `json:"events"`     // 最近的告警事件，按时间从新到旧
+}
+
+// sample 一次上游连接的结果。
+type sample struct {
+	at      time.Time // 发生时间
+	latency float64   // 耗时（毫秒），失败时不计入延迟
+	failed  bool      // 是否失败
+}
+
+// target 一个统计对象的窗口数据和评估状态。
+type target struct {
+	scope    string             // 统计范围
+	name     string             // 统计对象
+	samples  []sample           // 窗口内的样本，按时间排列
+	values   map[string]float64 // 各目标最近一次评估的实际值
+	violated map[string]bool    // 当前被违反的目标
+}
+
+// Monitor SLO监控器。
+type Monitor struct {
+	opts    Options
+	targets map[string]*target // 按 范围:对象 索引
+	events  []Event            // 最近的告警事件，环形保存
+	next    int                // 下一条事件写入的位置
+	stop    chan struct{}      // 停止信号
+	mutex   sync.Mutex         // 互斥锁
+}
+
+// ParseObjectives 解析服务水平目标。
+//
+// 目标之间用分号分隔，每个目标形如 "latency_p95<800ms" 或 "error_rate<5%"：
+// 延迟阈值支持 ms 和 s 单位（省略时为毫秒），错误率阈值支持百分比或0-1之间的小数。
+//
+// 参数：
+//   - spec: 目标配置
+//
+// 返回值：
+//   - []Objective: 解析后的目标，spec为空时为nil
+//   - error: 格式错误
+func ParseObjectives(spec string) ([]Objective, error) {
+	var objectives []Objective
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		metric, value, ok := strings.Cut(item, "<")
+		if !ok {
+			return nil, fmt.Errorf("SLO目标格式错误，应形如 latency_p95<800ms: %s", item)
+		}
+		o := Objective{Spec: item, Metric: strings.ToLower(strings.TrimSpace(metric))}
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		var err error
+		switch {
+		case o.Metric == MetricErrorRate:
+			if percent, isPercent := strings.CutSuffix(value, "%"); isPercent {
+				o.Threshold, err = strconv.ParseFloat(strings.TrimSpace(percent), 64)
+				o.Threshold /= 100
+			} else {
+				o.Threshold, err = strconv.ParseFloat(value, 64)
+			}
+			if err == nil && (o.Threshold <= 0 || o.Threshold > 1) {
+				err = fmt.Errorf("错误率阈值必须在0到100%%之间")
+			}
+		case strings.HasPrefix(o.Metric, "latency_p"):
+			o.Percentile, err = strconv.ParseFloat(strings.TrimPrefix(o.Metric, "latency_p"), 64)
+			if err != nil || o.Percentile <= 0 || o.Percentile > 100 {
+				return nil, fmt.Errorf("SLO延迟分位数无效: %s", item)
+			}
+			multiplier := 1.0
+			if number, isMillis := strings.CutSuffix(value, "ms"); isMillis {
+				value = number
+			} else if number, isSeconds := strings.CutSuffix(value, "s"); isSeconds {
+				value, multiplier = number, 1000
+			}
+			o.Threshold, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			o.Threshold *= multiplier
+			if err == nil && o.Threshold <= 0 {
+				err = fmt.Errorf("延迟阈值必须大于0")
+			}
+		default:
+			return nil, fmt.Errorf("未知的SLO指标 %s，可选 latency_pNN 或 error_rate", o.Metric)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("SLO目标阈值无效 %s: %v", item, err)
+		}
+		objectives = append(objectives, o)
+	}
+	return objectives, nil
+}
+
+// New 创建SLO监控器并开始定期评估。
+//
+// 参数：
+//   - opts: SLO监控配置
+//
+// 返回值：
+//   - *Monitor: SLO监控器，没有配置目标时为nil
+func New(opts Options) *Monitor {
+	if len(opts.Objectives) == 0 {
+		return nil
+	}
+	if opts.Window <= 0 {
+		opts.Window = 5 * time.Minute
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 15 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: webhookTimeout}
+	}
+	m := &Monitor{
+		opts:    opts,
+		targets: make(map[string]*target),
+		stop:    make(chan struct{}),
+	}
+
+	specs := make([]string, len(opts.Objectives))
+	for i, o := range opts.Objectives {
+		specs[i] = o.Spec
+	}
+	log.Printf("SLO监控已启用: %s，窗口 %v，最少样本数 %d，按代理评估: %v",
+		strings.Join(specs, "; "), opts.Window, opts.MinSamples, opts.PerProxy)
+
+	go m.run()
+	return m
+}
+
+// Record 记录一次上游连接的结果。
+//
+// 参数：
+//   - pool: 代理池名称
+//   - proxy: 代理地址
+//   - latency: 与上游建立连接（CONNECT握手或收到HTTP响应头）的耗时
+//   - err: 连接错误，成功时为nil
+func (m *Monitor) Record(pool, proxy string, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	s := sample{at: time.Now(), latency: float64(latency) / float64(time.Millisecond), failed: err != nil}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.add(ScopePool, pool, s)
+	if m.opts.PerProxy && proxy != "" {
+		m.add(ScopeProxy, pool+"/"+proxy, s)
+	}
+}
+
+// add 将样本加入统计对象，调用方需持有锁。
+func (m *Monitor) add(scope, name string, s sample) {
+	key := scope + ":" + name
+	t, ok := m.targets[key]
+	if !ok {
+		if len(m.targets) >= maxTargets {
+			return
+		}
+		t = &target{scope: scope, name: name, values: make(map[string]float64), violated: make(map[string]bool)}
+		m.targets[key] = t
+	}
+	if len(t.samples) >= maxSamples {
+		t.samples = t.samples[1:]
+	}
+	t.samples = append(t.samples, s)
+}
+
+// run 定期评估全部目标。
+func (m *Monitor) run() {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, event := range m.evaluate(time.Now()) {
+				m.notify(event)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// evaluate 丢弃窗口外的样本并评估全部目标。
+//
+// 参数：
+//   - now: 当前时间
+//
+// 返回值：
+//   - []Event: 本次评估中状态发生变化的事件
+func (m *Monitor) evaluate(now time.Time) []Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var events []Event
+	cutoff := now.Add(-m.opts.Window)
+	for key, t := range m.targets {
+		drop := sort.Search(len(t.samples), func(i int) bool { return t.samples[i].at.After(cutoff) })
+		t.samples = append(t.samples[:0:0], t.samples[drop:]...)
+		if len(t.samples) == 0 && len(t.violated) == 0 {
+			delete(m.targets, key)
+			continue
+		}
+		if len(t.samples) < max(m.opts.MinSamples, 1) {
+			continue
+		}
+
+		for _, o := range m.opts.Objectives {
+			value, ok := measure(t.samples, o)
+			if !ok {
+				continue
+			}
+			t.values[o.Spec] = value
+			violated := value >= o.Threshold
+			if violated == t.violated[o.Spec] {
+				continue
+			}
+			state := StateRecovered
+			if violated {
+				state = StateViolated
+				t.violated[o.Spec] = true
+			} else {
+				delete(t.violated, o.Spec)
+			}
+			event := Event{
+				Time:      now,
+				Scope:     t.scope,
+				Target:    t.name,
+				Objective: o.Spec,
+				Value:     value,
+				Samples:   len(t.samples),
+				State:     state,
+			}
+			events = append(events, event)
+			m.record(event)
+		}
+	}
+	return events
+}
+
+// measure 计算样本在目标指标上的实际值。
+//
+// 返回值：
+//   - float64: 延迟分位数（毫秒）或错误率
+//   - bool: 是否有可用于计算的样本，延迟指标只统计成功的连接
+func measure(samples []sample, o Objective) (float64, bool) {
+	if o.Metric == MetricErrorRate {
+		failed := 0
+		for _, s := range samples {
+			if s.failed {
+				failed++
+			}
+		}
+		return float64(failed) / float64(len(samples)), true
+	}
+
+	latencies := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if !s.failed {
+			latencies = append(latencies, s.latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return 0, false
+	}
+	sort.Float64s(latencies)
+	index := int(float64(len(latencies))*o.Percentile/100+0.5) - 1
+	return latencies[min(max(index, 0), len(latencies)-1)], true
+}
+
+// record 保存告警事件，调用方需持有锁。
+func (m *Monitor) record(event Event) {
+	if len(m.events) < maxEvents {
+		m.events = append(m.events, event)
+		return
+	}
+	m.events[m.next] = event
+	m.next = (m.next + 1) % maxEvents
+}
+
+// notify 输出告警日志并推送到Webhook。
+func (m *Monitor) notify(event Event) {
+	value := fmt.Sprintf("%.0fms", event.Value)
+	if strings.HasPrefix(event.Objective, MetricErrorRate) {
+		value = fmt.Sprintf("%.1f%%", event.Value*100)
+	}
+	if event.State == StateViolated {
+		log.Printf("SLO告警: %s %s 违反目标 %s，当前 %s（%d 个样本）",
+			event.Scope, event.Target, event.Objective, value, event.Samples)
+	} else {
+		log.Printf("SLO恢复: %s %s 恢复满足目标 %s，当前 %s（%d 个样本）",
+			event.Scope, event.Target, event.Objective, value, event.Samples)
+	}
+
+	if m.opts.Webhook == "" {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	resp, err := m.opts.Client.Post(m.opts.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("推送SLO告警失败: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Printf("推送SLO告警失败: Webhook返回 %s", resp.Status)
+	}
+}
+
+// Status 返回SLO监控状态。
+//
+// 返回值：
+//   - Status: 各统计对象的状态和最近的告警事件
+func (m *Monitor) Status() Status {
+	status := Status{Objectives: []string{}, Targets: []TargetStatus{}, Events: []Event{}}
+	if m == nil {
+		return status
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status.Enabled = true
+	status.Window = m.opts.Window.String()
+	for _, o := range m.opts.Objectives {
+		status.Objectives = append(status.Objectives, o.Spec)
+	}
+	for _, t := range m.targets {
+		ts := TargetStatus{
+			Scope:      t.scope,
+			Target:     t.name,
+			Samples:    len(t.samples),
+			Values:     make(map[string]float64, len(t.values)),
+			Violations: []string{},
+		}
+		for spec, value := range t.values {
+			ts.Values[spec] = value
+		}
+		for spec := range t.violated {
+			ts.Violations = append(ts.Violations, spec)
+		}
+		sort.Strings(ts.Violations)
+		status.Targets = append(status.Targets, ts)
+	}
+	sort.Slice(status.Targets, func(i, j int) bool {
+		if status.Targets[i].Scope != status.Targets[j].Scope {
+			return status.Targets[i].Scope == ScopePool
+		}
+		return status.Targets[i].Target < status.Targets[j].Target
+	})
+	for i := len(m.events) - 1; i >= 0; i-- {
+		status.Events = append(status.Events, m.events[(m.next+i)%len(m.events)])
+	}
+	return status
+}
+
+// Close 停止定期评估。
+func (m *Monitor) Close() {
+	if m == nil {
+		return
+	}
+	close(m.stop)
+}
@@ -0,0 +1,144 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+)
+
+// 应答码
+const (
+	ReplySucceeded           = 0x00 // 成功
+	ReplyGeneralFailure      = 0x01 // 一般性失败
+	ReplyNotAllowed          = 0x02 // 规则不允许连接
+	ReplyNetworkUnreachable  = 0x03 // 网络不可达
+	ReplyHostUnreachable     = 0x04 // 主机不可达
+	ReplyConnectionRefused   = 0x05 // 连接被拒绝
+	ReplyTTLExpired          = 0x06 // TTL已过期
+	ReplyCommandNotSupported = 0x07 // 不支持的命令
+	ReplyAddressNotSupported = 0x08 // 不支持的地址类型
+)
+
+// ErrAuthFailed 客户端未提供用户名密码或凭据错误
+var ErrAuthFailed = errors.New("SOCKS5客户端认证失败")
+
+// Request 客户端的CONNECT请求。
+type Request struct {
+	Addr     string // 目标地址（host:port格式），域名原样保留
+	Username string // 用户名密码认证时的用户名，未认证时为空
+	Password string // 用户名密码认证时的密码
+}
+
+// Accept 完成服务端握手：协商认证方式、校验凭据并读取CONNECT请求。
+//
+// 要求认证时只接受用户名密码认证；不要求认证时优先选择无需认证，客户端只提供
+// 用户名密码认证时接受任意凭据。CONNECT以外的命令和未知地址类型会先发送对应应答再返回错误。
+//
+// 参数：
+//   - conn: 客户端连接
+//   - authenticate: 校验用户名密码的函数，nil表示不要求认证
+//
+// 返回值：
+//   - *Request: 客户端请求，认证失败时仍带有客户端提供的用户名
+//   - error: 握手失败的原因，认证失败时为 ErrAuthFailed
+func Accept(conn io.ReadWriter, authenticate func(username, password string) bool) (*Request, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != version5 {
+		return nil, fmt.Errorf("不支持的SOCKS版本 %#x", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+
+	method := byte(methodNoAcceptable)
+	switch {
+	case authenticate == nil && slices.Contains(methods, methodNoAuth):
+		method = methodNoAuth
+	case slices.Contains(methods, methodUserPass):
+		method = methodUserPass
+	}
+	if _, err := conn.Write([]byte{version5, method}); err != nil {
+		return nil, err
+	}
+	if method == methodNoAcceptable {
+		return &Request{}, ErrAuthFailed
+	}
+
+	req := &Request{}
+	if method == methodUserPass {
+		username, password, err := readUserPass(conn)
+		if err != nil {
+			return nil, err
+		}
+		req.Username, req.Password = username, password
+		if authenticate != nil && !authenticate(username, password) {
+			conn.Write([]byte{userPassVersion, 0x01})
+			return req, ErrAuthFailed
+		}
+		if _, err := conn.Write([]byte{userPassVersion, 0x00}); err != nil {
+			return nil, err
+		}
+	}
+
+	header = make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != version5 {
+		return nil, fmt.Errorf("不支持的SOCKS版本 %#x", header[0])
+	}
+	host, port, err := readAddr(conn, header[3])
+	if err != nil {
+		WriteReply(conn, ReplyAddressNotSupported)
+		return nil, err
+	}
+	if header[1] != cmdConnect {
+		WriteReply(conn, ReplyCommandNotSupported)
+		return nil, fmt.Errorf("不支持的SOCKS5命令 %#x", header[1])
+	}
+	req.Addr = net.JoinHostPort(host, port)
+	return req, nil
+}
+
+// readUserPass 读取用户名密码认证请求（RFC 1929）。
+func readUserPass(r io.Reader) (string, string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", "", err
+	}
+	if header[0] != userPassVersion {
+		return "", "", fmt.Errorf("不支持的用户名密码认证版本 %#x", header[0])
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(r, username); err != nil {
+		return "", "", err
+	}
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", "", err
+	}
+	password := make([]byte, length[0])
+	if _, err := io.ReadFull(r, password); err != nil {
+		return "", "", err
+	}
+	return string(username), string(password), nil
+}
+
+// WriteReply 发送CONNECT应答，绑定地址固定为 0.0.0.0:0。
+//
+// 参数：
+//   - w: 客户端连接
+//   - code: 应答码
+//
+// 返回值：
+//   - error: 写入错误
+func WriteReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{version5, code, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,279 @@
+// Package socks5 提供SOCKS5协议（RFC 1928）的上游拨号和服务端握手。
+//
+// 上游条目写作 socks5://[user:pass@]host[:port] 或 socks5h://...，用户名密码
+// 认证遵循 RFC 1929。socks5:// 在本地解析目标主机名后发送IP地址，socks5h://
+// 把主机名交给上游解析；严格DNS模式下两者都把主机名交给上游。
+// 服务端握手（Accept、WriteReply）供SOCKS5入站监听器使用。
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+const (
+	// Scheme 在本地解析目标主机名的SOCKS5上游URL协议
+	Scheme = "socks5"
+	// SchemeRemoteDNS 由上游解析目标主机名的SOCKS5上游URL协议
+	SchemeRemoteDNS = "socks5h"
+	// DefaultPort 未指定端口时使用的SOCKS5端口
+	DefaultPort = "1080"
+)
+
+// 协议常量
+const (
+	version5 = 0x05 // SOCKS协议版本
+
+	methodNoAuth       = 0x00 // 无需认证
+	methodUserPass     = 0x02 // 用户名密码认证
+	methodNoAcceptable = 0xFF // 没有可接受的认证方式
+
+	userPassVersion = 0x01 // 用户名密码认证子协议版本
+
+	cmdConnect = 0x01 // CONNECT命令
+
+	atypIPv4   = 0x01 // IPv4地址
+	atypDomain = 0x03 // 域名
+	atypIPv6   = 0x04 // IPv6地址
+)
+
+// Options SOCKS5上游配置。
+type Options struct {
+	StrictDNS bool // 严格DNS模式，socks5:// 同样把目标主机名交给上游解析
+}
+
+// opts 当前配置，只在启动时设置
+var opts Options
+
+// Configure 设置SOCKS5上游的行为。
+//
+// 参数：
+//   - o: SOCKS5上游配置
+func Configure(o Options) {
+	opts = o
+}
+
+// ReplyError 上游SOCKS5代理拒绝CONNECT请求。
+type ReplyError struct {
+	Code byte // 应答码
+}
+
+// Error 返回应答码对应的说明。
+func (e *ReplyError) Error() string {
+	return "SOCKS5上游拒绝连接: " + replyText(e.Code)
+}
+
+// replyText 返回应答码的说明。
+func replyText(code byte) string {
+	switch code {
+	case ReplyGeneralFailure:
+		return "一般性失败"
+	case ReplyNotAllowed:
+		return "规则不允许连接"
+	case ReplyNetworkUnreachable:
+		return "网络不可达"
+	case ReplyHostUnreachable:
+		return "主机不可达"
+	case ReplyConnectionRefused:
+		return "连接被拒绝"
+	case ReplyTTLExpired:
+		return "TTL已过期"
+	case ReplyCommandNotSupported:
+		return "不支持的命令"
+	case ReplyAddressNotSupported:
+		return "不支持的地址类型"
+	default:
+		return fmt.Sprintf("未知应答码 %#x", code)
+	}
+}
+
+// upstream 注册到 dialer 包的SOCKS5上游拨号器。
+type upstream struct{}
+
+func init() {
+	dialer.Register(Scheme, upstream{})
+	dialer.Register(SchemeRemoteDNS, upstream{})
+}
+
+// DialContext 经SOCKS5上游连接目标地址，见 Dial。
+func (upstream) DialContext(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+	return Dial(ctx, proxy, addr)
+}
+
+// Prepare 为未指定端口的SOCKS5上游补充默认端口。
+func (upstream) Prepare(proxy *models.ProxyInfo) error {
+	if proxy.URL.Port() == "" {
+		proxy.URL.Host = net.JoinHostPort(proxy.URL.Hostname(), DefaultPort)
+		proxy.Host = proxy.URL.Host
+	}
+	if len(proxy.Username) > 255 || len(proxy.Password) > 255 {
+		return errors.New("SOCKS5上游的用户名和密码不能超过255字节")
+	}
+	return nil
+}
+
+// Dial 经SOCKS5上游连接目标地址。
+//
+// 参数：
+//   - ctx: 控制连接建立和握手的上下文
+//   - proxy: SOCKS5上游信息，带用户名时使用用户名密码认证
+//   - addr: 目标地址（host:port格式）
+//
+// 返回值：
+//   - net.Conn: 握手完成、可直接传输数据的连接
+//   - error: 连接失败的原因，上游拒绝时为 *ReplyError
+func Dial(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxy.URL.Scheme == Scheme && !opts.StrictDNS && net.ParseIP(host) == nil {
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		host = ips[0].Unmap().String()
+	}
+	request, err := encodeRequest(cmdConnect, host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialer.DialProxy(ctx, proxy)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := handshake(conn, proxy.Username, proxy.Password, request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// handshake 完成认证协商和CONNECT请求。
+func handshake(conn net.Conn, username, password string, request []byte) error {
+	methods := []byte{version5, 1, methodNoAuth}
+	if username != "" {
+		methods = []byte{version5, 2, methodNoAuth, methodUserPass}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取SOCKS5认证方式失败: %v", err)
+	}
+	if reply[0] != version5 {
+		return fmt.Errorf("上游不是SOCKS5代理（版本 %#x）", reply[0])
+	}
+
+	switch reply[1] {
+	case methodNoAuth:
+	case methodUserPass:
+		if username == "" {
+			return errors.New("SOCKS5上游要求用户名密码认证")
+		}
+		auth := []byte{userPassVersion, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("读取SOCKS5认证结果失败: %v", err)
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5上游认证失败")
+		}
+	default:
+		return errors.New("SOCKS5上游不接受可用的认证方式")
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("读取SOCKS5应答失败: %v", err)
+	}
+	if header[1] != ReplySucceeded {
+		return &ReplyError{Code: header[1]}
+	}
+	// 跳过绑定地址和端口
+	_, _, err := readAddr(conn, header[3])
+	return err
+}
+
+// encodeRequest 编码SOCKS5请求：版本、命令、保留字节和目标地址。
+func encodeRequest(cmd byte, host, port string) ([]byte, error) {
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口: %s", port)
+	}
+	request := []byte{version5, cmd, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(append(request, atypIPv4), ip4...)
+		} else {
+			request = append(append(request, atypIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("目标主机名过长: %s", host)
+		}
+		request = append(append(request, atypDomain, byte(len(host))), host...)
+	}
+	return append(request, byte(portNum>>8), byte(portNum)), nil
+}
+
+// readAddr 按地址类型读取地址和端口。
+//
+// 返回值：
+//   - string: 主机名或IP地址
+//   - string: 端口
+//   - error: 读取失败或地址类型未知
+func readAddr(r io.Reader, atyp byte) (string, string, error) {
+	var host string
+	switch atyp {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, 4)
+		if atyp == atypIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", "", err
+		}
+		host = ip.String()
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", "", err
+		}
+		host = string(domain)
+	default:
+		return "", "", fmt.Errorf("未知的SOCKS5地址类型 %#x", atyp)
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", "", err
+	}
+	return host, strconv.Itoa(int(port[0])<<8 | int(port[1])), nil
+}
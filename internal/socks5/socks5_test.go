@@ -0,0 +1,101 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// fakeUpstream 启动一个只接受一次CONNECT的SOCKS5上游，返回收到的目标地址类型和主机。
+func fakeUpstream(t *testing.T) (string, <-chan [2]string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan [2]string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, make([]byte, greeting[1])); err != nil {
+			return
+		}
+		conn.Write([]byte{version5, methodNoAuth})
+
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		host, _, err := readAddr(conn, header[3])
+		if err != nil {
+			return
+		}
+		kind := "ip"
+		if header[3] == atypDomain {
+			kind = "domain"
+		}
+		received <- [2]string{kind, host}
+		conn.Write([]byte{version5, ReplySucceeded, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	}()
+	return listener.Addr().String(), received
+}
+
+// forbidResolver 在测试期间替换默认解析器，任何本地DNS查询都会使测试失败。
+func forbidResolver(t *testing.T) {
+	t.Helper()
+	original := net.DefaultResolver
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			t.Errorf("不应在本地解析目标主机名（连接DNS服务器 %s）", address)
+			return nil, errors.New("禁止本地解析")
+		},
+	}
+	t.Cleanup(func() { net.DefaultResolver = original })
+}
+
+func TestDialSendsHostnameToUpstream(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		strict bool
+	}{
+		{"socks5h", SchemeRemoteDNS, false},
+		{"严格DNS模式下的socks5", Scheme, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forbidResolver(t)
+			Configure(Options{StrictDNS: tt.strict})
+			t.Cleanup(func() { Configure(Options{}) })
+
+			addr, received := fakeUpstream(t)
+			proxy := models.ProxyInfo{URL: &url.URL{Scheme: tt.scheme, Host: addr}, Host: addr}
+			conn, err := Dial(context.Background(), proxy, "target.example:443")
+			if err != nil {
+				t.Fatalf("经SOCKS5上游连接失败: %v", err)
+			}
+			conn.Close()
+
+			got := <-received
+			if got != [2]string{"domain", "target.example"} {
+				t.Fatalf("上游收到的目标 = %v，期望由上游解析的域名 target.example", got)
+			}
+		})
+	}
+}
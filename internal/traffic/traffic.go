@@ -0,0 +1,541 @@
+// Package traffic 提供按连接和用户的带宽限制及按用户的流量配额。
+//
+// 多人共用同一个代理出口时，单个用户的大文件下载会占满上游带宽。本包为每个连接
+// 和每个认证用户各维护一个按字节计的令牌桶，转发数据时按速率延迟读取；同时按
+// 统计周期（UTC自然日、自然周或自然月）累计每个用户传输的字节数，用量达到配额后
+// 拒绝该用户的新请求并结束进行中的传输，进入下一个周期后自动重置。用量可持久化
+// 到文件，重启后继续累计。
+package traffic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/budget"
+)
+
+// 配额的统计周期
+const (
+	PeriodDay   = "day"   // UTC自然日
+	PeriodWeek  = "week"  // UTC自然周，从周一开始
+	PeriodMonth = "month" // UTC自然月
+)
+
+// ErrQuotaExceeded 用户在本统计周期的流量配额已用尽
+var ErrQuotaExceeded = errors.New("用户流量配额已用尽")
+
+// QuotaError 用户流量配额已用尽的详情。
+type QuotaError struct {
+	User  string    // 用户名
+	Limit int64     // 每个统计周期的配额（字节）
+	Reset time.Time // 配额重置的时间
+}
+
+// Error 返回错误描述。
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: 用户 %s 每个周期 %s，%s 重置", ErrQuotaExceeded, e.User, budget.FormatBytes(e.Limit),
+		e.Reset.Format(time.RFC3339))
+}
+
+// Unwrap 返回ErrQuotaExceeded。
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// RetryAfter 返回距离配额重置的时间。
+func (e *QuotaError) RetryAfter() time.Duration {
+	return time.Until(e.Reset)
+}
+
+// ResetAt 返回配额重置的时间。
+func (e *QuotaError) ResetAt() time.Time {
+	return e.Reset
+}
+
+// Options 带宽限制和流量配额配置。
+type Options struct {
+	ConnectionRate int64         // 单个连接或请求每秒允许传输的字节数，0表示不限制
+	UserRate       int64         // 单个用户所有连接合计每秒允许传输的字节数，0表示不限制
+	UserQuota      int64         // 单个用户每个统计周期允许传输的字节数，0表示不限制
+	Period         string        // 配额的统计周期：day、week、month
+	StateFile      string        // 用量持久化文件路径，为空则不持久化
+	SaveInterval   time.Duration // 用量持久化间隔，0表示每分钟
+}
+
+// UserUsage 单个用户本周期的流量用量。
+type UserUsage struct {
+	User      string `json:"user"`      // 用户名
+	Used      int64  `json:"used"`      // 本周期已传输的字节数
+	Exhausted bool   `json:"exhausted"` // 配额是否已用尽
+}
+
+// Status 带宽限制和流量配额状态。
+type Status struct {
+	ConnectionRate int64       `json:"connection_rate"`    // 单个连接每秒允许传输的字节数
+	UserRate       int64       `json:"user_rate"`          // 单个用户每秒允许传输的字节数
+	UserQuota      int64       `json:"user_quota"`         // 单个用户每个统计周期的配额
+	Period         string      `json:"period"`             // 当前统计周期
+	ResetAt        *time.Time  `json:"reset_at,omitempty"` // 配额重置的时间，未启用配额时省略
+	Users          []UserUsage `json:"users"`              // 按用量降序排列的用户
+}
+
+// state 持久化文件内容。
+type state struct {
+	Period string           `json:"period"`
+	Used   map[string]int64 `json:"used"`
+}
+
+// bucket 按字节计的令牌桶，最多积累一秒的传输量。
+type bucket struct {
+	rate   float64    // 每秒补充的字节数
+	tokens float64    // 当前可用的字节数，为负数表示已预约的未来字节数
+	last   time.Time  // 上次补充的时间
+	mutex  sync.Mutex // 互斥锁
+}
+
+// newBucket 创建令牌桶，rate不大于0时返回nil表示不限制。
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// reserve 预约n字节的传输量，返回需要等待多久令牌才可用。
+func (b *bucket) reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// user 单个用户的带宽令牌桶和本周期用量。
+type user struct {
+	bucket *bucket      // 用户所有连接共用的令牌桶，nil表示不限制
+	used   atomic.Int64 // 本周期已传输的字节数
+	warned atomic.Bool  // 本周期是否已记录配额用尽
+}
+
+// Limiter 按连接和用户的带宽限制及按用户的流量配额。
+type Limiter struct {
+	opts   Options          // 配置
+	period string           // 当前统计周期
+	users  map[string]*user // 用户名到带宽和用量的映射
+	mutex  sync.Mutex       // 保护统计周期和用户映射
+	stop   chan struct{}    // 停止持久化的信号
+}
+
+// New 创建带宽限制和流量配额，并从持久化文件恢复本周期用量。
+//
+// 参数：
+//   - opts: 带宽限制和流量配额配置
+//
+// 返回值：
+//   - *Limiter: 带宽限制和流量配额，没有设置任何限制时为nil
+//   - error: 统计周期无效或读取持久化文件失败
+func New(opts Options) (*Limiter, error) {
+	if opts.ConnectionRate <= 0 && opts.UserRate <= 0 && opts.UserQuota <= 0 {
+		return nil, nil
+	}
+	if opts.Period == "" {
+		opts.Period = PeriodMonth
+	}
+	if _, err := periodKey(opts.Period, time.Now()); err != nil {
+		return nil, err
+	}
+	if opts.SaveInterval <= 0 {
+		opts.SaveInterval = time.Minute
+	}
+
+	l := &Limiter{opts: opts, users: make(map[string]*user), stop: make(chan struct{})}
+	l.period, _ = periodKey(opts.Period, time.Now())
+	if opts.StateFile != "" && opts.UserQuota > 0 {
+		if err := l.load(); err != nil {
+			return nil, err
+		}
+		go l.persist()
+	}
+
+	log.Printf("带宽限制已启用: 每个连接 %s，每个用户 %s，每个用户每%s配额 %s",
+		formatLimit(opts.ConnectionRate, "/s"), formatLimit(opts.UserRate, "/s"), periodNames[opts.Period], formatLimit(opts.UserQuota, ""))
+	return l, nil
+}
+
+// periodNames 统计周期的中文名称，用于日志。
+var periodNames = map[string]string{PeriodDay: "日", PeriodWeek: "周", PeriodMonth: "月"}
+
+// periodKey 返回时间所在的统计周期标识。
+func periodKey(period string, now time.Time) (string, error) {
+	now = now.UTC()
+	switch period {
+	case PeriodDay:
+		return now.Format("2006-01-02"), nil
+	case PeriodWeek:
+		year, week := now.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week), nil
+	case PeriodMonth:
+		return now.Format("2006-01"), nil
+	default:
+		return "", fmt.Errorf("未知的流量配额统计周期: %s", period)
+	}
+}
+
+// resetAt 返回当前统计周期结束、配额重置的时间。
+func (l *Limiter) resetAt() time.Time {
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch l.opts.Period {
+	case PeriodDay:
+		return day.AddDate(0, 0, 1)
+	case PeriodWeek:
+		return day.AddDate(0, 0, 7-(int(now.Weekday())+6)%7)
+	default:
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// user 返回用户的带宽和用量，不存在时创建，调用方需持有锁。
+func (l *Limiter) user(name string) *user {
+	u, ok := l.users[name]
+	if !ok {
+		u = &user{bucket: newBucket(l.opts.UserRate)}
+		l.users[name] = u
+	}
+	return u
+}
+
+// rollover 进入新的统计周期时重置所有用户的用量。
+func (l *Limiter) rollover() {
+	period, _ := periodKey(l.opts.Period, time.Now())
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if period == l.period {
+		return
+	}
+	if l.opts.UserQuota > 0 {
+		log.Printf("用户流量配额进入新周期 %s，上一周期 %s 的用量已重置", period, l.period)
+	}
+	l.period = period
+	for _, u := range l.users {
+		u.used.Store(0)
+		u.warned.Store(false)
+	}
+}
+
+// exceeded 判断用户本周期的用量是否已达到配额，达到时返回 *QuotaError。
+func (l *Limiter) exceeded(name string, u *user) error {
+	if l.opts.UserQuota <= 0 || u.used.Load() < l.opts.UserQuota {
+		return nil
+	}
+	if u.warned.CompareAndSwap(false, true) {
+		log.Printf("用户 %s 的流量配额已用尽: 本周期已传输 %s，配额 %s", name, budget.FormatBytes(u.used.Load()), budget.FormatBytes(l.opts.UserQuota))
+	}
+	return &QuotaError{User: name, Limit: l.opts.UserQuota, Reset: l.resetAt()}
+}
+
+// Check 检查用户本周期的流量配额是否已用尽。
+//
+// 参数：
+//   - name: 认证用户名，为空表示匿名，不受配额限制
+//
+// 返回值：
+//   - error: 配额已用尽时返回 *QuotaError，调用方应以429拒绝请求
+func (l *Limiter) Check(name string) error {
+	if l == nil || name == "" || l.opts.UserQuota <= 0 {
+		return nil
+	}
+	l.rollover()
+	l.mutex.Lock()
+	u := l.user(name)
+	l.mutex.Unlock()
+	return l.exceeded(name, u)
+}
+
+// Open 为一个连接或请求创建带宽限制和用量统计。
+//
+// 参数：
+//   - name: 认证用户名，为空表示匿名，只受单个连接的带宽限制
+//
+// 返回值：
+//   - *Flow: 连接的带宽限制，未启用时为nil
+func (l *Limiter) Open(name string) *Flow {
+	if l == nil {
+		return nil
+	}
+	f := &Flow{limiter: l, user: name, conn: newBucket(l.opts.ConnectionRate)}
+	if name != "" {
+		l.mutex.Lock()
+		f.usage = l.user(name)
+		l.mutex.Unlock()
+	}
+	return f
+}
+
+// Reset 清零用户本周期的用量。
+//
+// 参数：
+//   - name: 用户名
+//
+// 返回值：
+//   - bool: 用户是否有用量记录
+func (l *Limiter) Reset(name string) bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	u, ok := l.users[name]
+	if !ok {
+		return false
+	}
+	u.used.Store(0)
+	u.warned.Store(false)
+	log.Printf("已清零用户 %s 本周期的流量用量", name)
+	return true
+}
+
+// Status 获取带宽限制和流量配额状态。
+func (l *Limiter) Status() Status {
+	if l == nil {
+		return Status{}
+	}
+	l.rollover()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	status := Status{
+		ConnectionRate: l.opts.ConnectionRate,
+		UserRate:       l.opts.UserRate,
+		UserQuota:      l.opts.UserQuota,
+		Period:         l.period,
+		Users:          make([]UserUsage, 0, len(l.users)),
+	}
+	if l.opts.UserQuota > 0 {
+		resetAt := l.resetAt()
+		status.ResetAt = &resetAt
+	}
+	for name, u := range l.users {
+		used := u.used.Load()
+		status.Users = append(status.Users, UserUsage{
+			User:      name,
+			Used:      used,
+			Exhausted: l.opts.UserQuota > 0 && used >= l.opts.UserQuota,
+		})
+	}
+	sort.Slice(status.Users, func(i, j int) bool {
+		if status.Users[i].Used != status.Users[j].Used {
+			return status.Users[i].Used > status.Users[j].Used
+		}
+		return status.Users[i].User < status.Users[j].User
+	})
+	return status
+}
+
+// Close 停止定期持久化并保存最终用量。
+func (l *Limiter) Close() error {
+	if l == nil || l.opts.StateFile == "" || l.opts.UserQuota <= 0 {
+		return nil
+	}
+	close(l.stop)
+	return l.save()
+}
+
+// load 从持久化文件恢复本周期用量，文件不存在或属于其他周期时从0开始。
+func (l *Limiter) load() error {
+	data, err := os.ReadFile(l.opts.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取用户流量用量文件失败: %v", err)
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("解析用户流量用量文件失败: %v", err)
+	}
+	if s.Period != l.period {
+		return nil
+	}
+	for name, used := range s.Used {
+		l.user(name).used.Store(used)
+	}
+	return nil
+}
+
+// save 将当前用量写入持久化文件。
+func (l *Limiter) save() error {
+	l.mutex.Lock()
+	s := state{Period: l.period, Used: make(map[string]int64, len(l.users))}
+	for name, u := range l.users {
+		if used := u.used.Load(); used > 0 {
+			s.Used[name] = used
+		}
+	}
+	l.mutex.Unlock()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.opts.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.opts.StateFile)
+}
+
+// persist 定期保存用量，直到Close被调用。
+func (l *Limiter) persist() {
+	ticker := time.NewTicker(l.opts.SaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.rollover()
+			if err := l.save(); err != nil {
+				log.Printf("保存用户流量用量失败: %v", err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Flow 单个连接或请求的带宽限制和用量统计。
+//
+// 隧道的两个方向共用同一个连接令牌桶，合计的传输速率不超过单个连接的带宽上限。
+type Flow struct {
+	limiter *Limiter // 所属的带宽限制
+	user    string   // 认证用户名，为空表示匿名
+	usage   *user    // 用户的带宽和用量，匿名时为nil
+	conn    *bucket  // 连接的令牌桶，nil表示不限制
+}
+
+// Add 计入已经传输、不需要限速的字节数，如已完整读取的请求体。
+//
+// 参数：
+//   - n: 字节数
+func (f *Flow) Add(n int64) {
+	if f == nil || f.usage == nil || n <= 0 {
+		return
+	}
+	f.usage.used.Add(n)
+}
+
+// chunk 返回单次读取的字节数上限，使每次预约的等待时间不超过约一秒。
+func (f *Flow) chunk() int {
+	limit := 0
+	for _, b := range []*bucket{f.conn, f.userBucket()} {
+		if b != nil && (limit == 0 || int(b.rate) < limit) {
+			limit = max(int(b.rate), 1)
+		}
+	}
+	return limit
+}
+
+// userBucket 返回用户的令牌桶，匿名或不限制时为nil。
+func (f *Flow) userBucket() *bucket {
+	if f.usage == nil {
+		return nil
+	}
+	return f.usage.bucket
+}
+
+// transfer 计入一次读取的字节数并按带宽上限等待。
+//
+// 返回值：
+//   - error: 用户的流量配额已用尽时返回 *QuotaError
+func (f *Flow) transfer(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	wait := f.conn.reserve(n)
+	if f.usage != nil {
+		f.usage.used.Add(int64(n))
+		wait = max(wait, f.usage.bucket.reserve(n))
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	if f.usage != nil {
+		return f.limiter.exceeded(f.user, f.usage)
+	}
+	return nil
+}
+
+// Packet 计入一个不可拆分的数据包（如UDP报文）并按带宽上限等待。
+//
+// 与 Reader 不同，数据包不会按单次读取的字节数上限切分。
+//
+// 参数：
+//   - n: 数据包的字节数
+//
+// 返回值：
+//   - error: 用户的流量配额已用尽时返回 *QuotaError
+func (f *Flow) Packet(n int) error {
+	if f == nil {
+		return nil
+	}
+	return f.transfer(n)
+}
+
+// Reader 包装读取器，读取的字节数受带宽上限限制并计入用户用量。
+//
+// 用户的流量配额在传输过程中用尽时，读取返回 *QuotaError 以结束转发。
+//
+// 参数：
+//   - r: 原始读取器
+//
+// 返回值：
+//   - io.Reader: 包装后的读取器，未启用时原样返回
+func (f *Flow) Reader(r io.Reader) io.Reader {
+	if f == nil {
+		return r
+	}
+	return &flowReader{r: r, f: f}
+}
+
+// flowReader 受带宽限制的读取器。
+type flowReader struct {
+	r io.Reader
+	f *Flow
+}
+
+// Read 读取数据，按带宽上限等待并检查流量配额。
+func (r *flowReader) Read(p []byte) (int, error) {
+	if limit := r.f.chunk(); limit > 0 && len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := r.r.Read(p)
+	if quotaErr := r.f.transfer(n); quotaErr != nil && err == nil {
+		err = quotaErr
+	}
+	return n, err
+}
+
+// formatLimit 格式化字节数上限，0表示不限制。
+func formatLimit(n int64, unit string) string {
+	if n <= 0 {
+		return "不限制"
+	}
+	return budget.FormatBytes(n) + unit
+}
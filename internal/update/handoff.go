@@ -0,0 +1,305 @@
+// Package update 实现自更新和不中断服务的进程交接。
+//
+// 自更新从发布清单下载当前平台的可执行文件，校验SHA-256和Ed25519签名后替换磁盘上的程序；
+// 进程交接由运行中的旧进程启动新的可执行文件，并把全部监听器的文件描述符交给它，
+// 新进程就绪后旧进程停止接受连接、等待已建立的隧道结束后退出，监听端口始终有进程在接受连接。
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// listenersEnv 传给新进程的监听地址，按继承的文件描述符顺序以逗号分隔
+	listenersEnv = "PROXYFLOW_INHERIT_LISTENERS"
+	// readyEnv 传给新进程的就绪通知管道的文件描述符
+	readyEnv = "PROXYFLOW_READY_FD"
+	// firstInheritedFD 第一个继承的文件描述符，0-2为标准输入输出
+	firstInheritedFD = 3
+	// unclaimedGrace 新进程就绪后仍未被使用的继承监听器的保留时间，
+	// 之后关闭，避免配置中已删除的端口继续占用
+	unclaimedGrace = 30 * time.Second
+)
+
+var (
+	loadOnce   sync.Once
+	readyOnce  sync.Once
+	executable string // 启动时的可执行文件路径，替换文件后交接时启动的仍是该路径
+
+	mutex     sync.Mutex
+	inherited map[string]net.Listener // 从旧进程继承、尚未使用的监听器，按监听地址索引
+	active    = make(map[*listener]struct{})
+	readyPipe *os.File // 向旧进程通知就绪的管道，不是由交接启动时为nil
+
+	handingOff atomic.Bool // 是否正在交接
+)
+
+// listener 登记在交接列表中的监听器。
+type listener struct {
+	net.Listener
+	addr      string            // 监听地址，交接时用于匹配新进程的监听请求
+	keepAlive *net.ListenConfig // 继承的监听器需要自行应用的keepalive配置，新建的监听器为nil
+	closeOnce sync.Once         // 保证只注销一次
+	tcp       *net.TCPListener  // 底层TCP监听器，用于取得文件描述符
+}
+
+// Accept 接受连接，继承的监听器按配置设置keepalive。
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || l.keepAlive == nil {
+		return conn, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if l.keepAlive.KeepAlive < 0 {
+			tcp.SetKeepAlive(false)
+		} else if l.keepAlive.KeepAliveConfig.Enable {
+			tcp.SetKeepAliveConfig(l.keepAlive.KeepAliveConfig)
+		}
+	}
+	return conn, nil
+}
+
+// Close 关闭监听器并从交接列表中移除。
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		mutex.Lock()
+		delete(active, l)
+		mutex.Unlock()
+	})
+	return l.Listener.Close()
+}
+
+// load 读取旧进程通过环境变量传来的监听器和就绪管道，只执行一次。
+func load() {
+	loadOnce.Do(func() {
+		executable, _ = os.Executable()
+
+		if fd, err := strconv.Atoi(os.Getenv(readyEnv)); err == nil {
+			readyPipe = os.NewFile(uintptr(fd), "ready")
+		}
+		addrs := os.Getenv(listenersEnv)
+		os.Unsetenv(readyEnv)
+		os.Unsetenv(listenersEnv)
+		if addrs == "" {
+			return
+		}
+
+		inherited = make(map[string]net.Listener)
+		for i, addr := range strings.Split(addrs, ",") {
+			file := os.NewFile(uintptr(firstInheritedFD+i), addr)
+			l, err := net.FileListener(file)
+			file.Close()
+			if err != nil {
+				log.Printf("继承监听器 %s 失败: %v", addr, err)
+				continue
+			}
+			inherited[addr] = l
+		}
+		log.Printf("已从旧进程继承 %d 个监听器", len(inherited))
+	})
+}
+
+// Listen 监听TCP地址，由交接启动时优先使用旧进程传来的同一地址的监听器。
+//
+// 返回的监听器登记在交接列表中，关闭后自动移除。
+//
+// 参数：
+//   - lc: 新建监听器使用的配置，继承的监听器对接受的连接应用其中的keepalive设置
+//   - addr: 监听地址，如 ":8080"
+//
+// 返回值：
+//   - net.Listener: 监听器
+//   - error: 监听失败
+func Listen(lc *net.ListenConfig, addr string) (net.Listener, error) {
+	load()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	l := &listener{addr: addr}
+	if inheritedListener, ok := inherited[addr]; ok {
+		delete(inherited, addr)
+		l.Listener, l.keepAlive = inheritedListener, lc
+	} else {
+		newListener, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		l.Listener = newListener
+	}
+	l.tcp, _ = l.Listener.(*net.TCPListener)
+	active[l] = struct{}{}
+	return l, nil
+}
+
+// Ready 通知启动本进程的旧进程已经就绪，不是由交接启动时不做任何事。
+//
+// 应在主要监听器开始接受连接后调用，重复调用只生效一次。
+// 就绪后一段时间内仍未被使用的继承监听器会被关闭。
+func Ready() {
+	load()
+	readyOnce.Do(func() {
+		if readyPipe == nil {
+			return
+		}
+		readyPipe.Write([]byte{1})
+		readyPipe.Close()
+		time.AfterFunc(unclaimedGrace, closeUnclaimed)
+	})
+}
+
+// closeUnclaimed 关闭配置中已不再使用的继承监听器。
+func closeUnclaimed() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for addr, l := range inherited {
+		log.Printf("关闭未使用的继承监听器 %s", addr)
+		l.Close()
+		delete(inherited, addr)
+	}
+}
+
+// Handoff 启动新进程并把全部监听器交给它，新进程就绪后返回。
+//
+// 新进程使用相同的可执行文件路径、命令行参数、环境变量、工作目录和标准输入输出，
+// 因此替换磁盘上的可执行文件后调用即可切换到新版本。旧进程的监听器保持打开，
+// 调用方应在成功后停止接受连接并退出；失败时新进程已被终止，旧进程照常运行。
+//
+// 参数：
+//   - timeout: 等待新进程就绪的时间
+//
+// 返回值：
+//   - int: 新进程的PID
+//   - error: 启动失败、新进程提前退出或等待超时
+func Handoff(timeout time.Duration) (int, error) {
+	load()
+	if !handingOff.CompareAndSwap(false, true) {
+		return 0, errors.New("已有进程交接正在进行")
+	}
+	pid, err := handoff(timeout)
+	if err != nil {
+		handingOff.Store(false)
+	}
+	return pid, err
+}
+
+// handoff 执行一次进程交接。
+func handoff(timeout time.Duration) (int, error) {
+	if executable == "" {
+		return 0, errors.New("无法确定可执行文件路径")
+	}
+
+	mutex.Lock()
+	listeners := make([]*listener, 0, len(active))
+	for l := range active {
+		listeners = append(listeners, l)
+	}
+	mutex.Unlock()
+	slices.SortFunc(listeners, func(a, b *listener) int { return strings.Compare(a.addr, b.addr) })
+
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	addrs := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		if l.tcp == nil {
+			continue
+		}
+		file, err := l.tcp.File()
+		if err != nil {
+			return 0, fmt.Errorf("取得监听器 %s 的文件描述符失败: %v", l.addr, err)
+		}
+		files = append(files, file)
+		addrs = append(addrs, l.addr)
+	}
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("创建就绪通知管道失败: %v", err)
+	}
+	defer readyRead.Close()
+
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, listenersEnv+"=") || strings.HasPrefix(kv, readyEnv+"=")
+	})
+	env = append(env,
+		listenersEnv+"="+strings.Join(addrs, ","),
+		readyEnv+"="+strconv.Itoa(firstInheritedFD+len(files)),
+	)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(slices.Clone(files), readyWrite)
+	err = cmd.Start()
+	readyWrite.Close()
+	// 传递文件描述符时套接字被切换为阻塞模式，阻塞的Accept会使关闭监听器永远等待，需要恢复
+	for _, l := range listeners {
+		if l.tcp == nil {
+			continue
+		}
+		if raw, err := l.tcp.SyscallConn(); err == nil {
+			raw.Control(setNonblock)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("启动新进程失败: %v", err)
+	}
+	log.Printf("已启动新进程 %d，交接 %d 个监听器", cmd.Process.Pid, len(files))
+
+	ready := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, 1)
+		n, _ := readyRead.Read(buf)
+		ready <- n == 1
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ok := <-ready:
+		if ok {
+			return cmd.Process.Pid, nil
+		}
+		// 管道被关闭而没有收到通知，新进程正在退出
+		return 0, fmt.Errorf("新进程在就绪前退出: %v", <-exited)
+	case err := <-exited:
+		return 0, fmt.Errorf("新进程在就绪前退出: %v", err)
+	case <-timer.C:
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("等待新进程就绪超时（%v）", timeout)
+	}
+}
+
+// CloseListeners 关闭交接列表中的全部监听器，交接成功后旧进程用它停止接受新连接。
+//
+// 新进程持有同一套接字的副本，关闭旧进程的监听器不影响新进程接受连接。
+func CloseListeners() {
+	mutex.Lock()
+	listeners := make([]*listener, 0, len(active))
+	for l := range active {
+		listeners = append(listeners, l)
+	}
+	mutex.Unlock()
+	for _, l := range listeners {
+		l.Close()
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package update
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signal 触发进程交接的信号
+var Signal os.Signal = syscall.SIGUSR2
+
+// setNonblock 恢复套接字的非阻塞模式。
+func setNonblock(fd uintptr) {
+	syscall.SetNonblock(int(fd), true)
+}
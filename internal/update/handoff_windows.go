@@ -0,0 +1,9 @@
+package update
+
+import "os"
+
+// Signal 触发进程交接的信号，Windows不支持交接监听器，为nil
+var Signal os.Signal
+
+// setNonblock Windows不需要处理。
+func setNonblock(fd uintptr) {}
@@ -0,0 +1,377 @@
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxManifestBytes 发布清单的大小上限
+	maxManifestBytes = 1 << 20
+	// maxBinaryBytes 下载的可执行文件的大小上限
+	maxBinaryBytes = 512 << 20
+	// signedPrefix 签名内容的前缀，避免同一密钥对其他内容的签名被当作发布签名
+	signedPrefix = "proxyflow-release-v1\n"
+)
+
+// Manifest 发布清单。
+//
+// 示例：
+//
+//	{
+//	  "version": "v1.5.0",
+//	  "assets": {
+//	    "linux/amd64": {
+//	      "url": "proxyflow-linux-amd64",
+//	      "sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+//	      "signature": "<对版本号、平台和SHA-256的Ed25519签名，Base64>"
+//	    }
+//	  }
+//	}
+type Manifest struct {
+	Version string           `json:"version"` // 发布的版本号
+	Assets  map[string]Asset `json:"assets"`  // 各平台的可执行文件，键为 "GOOS/GOARCH"
+}
+
+// Asset 单个平台的可执行文件。
+type Asset struct {
+	URL       string `json:"url"`       // 下载地址，相对地址按清单地址解析
+	SHA256    string `json:"sha256"`    // 文件的SHA-256（十六进制）
+	Signature string `json:"signature"` // 对 SignedMessage 的Ed25519签名（Base64）
+}
+
+// SignedMessage 返回发布签名覆盖的内容。
+//
+// 签名同时覆盖版本号、平台和文件的SHA-256，清单中的版本号无法被替换，
+// 旧版本的文件也无法冒充新版本发布（降级攻击）。
+//
+// 参数：
+//   - version: 发布的版本号
+//   - platform: 平台，格式为 "GOOS/GOARCH"
+//   - sha256Hex: 文件的SHA-256（十六进制）
+//
+// 返回值：
+//   - []byte: 待签名的内容
+func SignedMessage(version, platform, sha256Hex string) []byte {
+	return []byte(signedPrefix + version + "\n" + platform + "\n" + strings.ToLower(sha256Hex) + "\n")
+}
+
+// Sign 为可执行文件生成发布清单中的文件信息。
+//
+// 参数：
+//   - privateKey: Ed25519私钥
+//   - version: 发布的版本号
+//   - platform: 平台，格式为 "GOOS/GOARCH"
+//   - assetURL: 下载地址
+//   - data: 可执行文件内容
+//
+// 返回值：
+//   - Asset: 带有SHA-256和签名的文件信息
+func Sign(privateKey ed25519.PrivateKey, version, platform, assetURL string, data []byte) Asset {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	signature := ed25519.Sign(privateKey, SignedMessage(version, platform, hash))
+	return Asset{URL: assetURL, SHA256: hash, Signature: base64.StdEncoding.EncodeToString(signature)}
+}
+
+// Release 当前平台可用的发布。
+type Release struct {
+	Version string // 发布的版本号
+	URL     string // 可执行文件的下载地址
+	asset   Asset  // 清单中的文件信息
+}
+
+// Updater 从发布清单下载并校验新版本。
+type Updater struct {
+	manifestURL string            // 发布清单地址
+	publicKey   ed25519.PublicKey // 校验签名的公钥
+	client      *http.Client      // 下载使用的HTTP客户端
+}
+
+// NewUpdater 创建自更新器。
+//
+// 参数：
+//   - manifestURL: 发布清单地址
+//   - publicKey: Base64编码的Ed25519公钥，不能为空，签名无法校验时拒绝更新
+//
+// 返回值：
+//   - *Updater: 自更新器
+//   - error: 地址为空或公钥无效
+func NewUpdater(manifestURL, publicKey string) (*Updater, error) {
+	if manifestURL == "" {
+		return nil, errors.New("未配置 UPDATE_URL")
+	}
+	if publicKey == "" {
+		return nil, errors.New("未配置 UPDATE_PUBLIC_KEY，无法校验发布文件的签名")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("UPDATE_PUBLIC_KEY 不是有效的Ed25519公钥")
+	}
+	return &Updater{
+		manifestURL: manifestURL,
+		publicKey:   ed25519.PublicKey(key),
+		client:      &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// Check 读取发布清单，校验签名后返回当前平台的发布。
+//
+// 返回值：
+//   - Release: 当前平台的发布，版本号和SHA-256已通过签名校验
+//   - error: 读取或解析清单失败、清单中没有当前平台的文件或签名校验失败
+func (u *Updater) Check() (Release, error) {
+	data, err := u.get(u.manifestURL, maxManifestBytes)
+	if err != nil {
+		return Release{}, fmt.Errorf("读取发布清单失败: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Release{}, fmt.Errorf("解析发布清单失败: %v", err)
+	}
+	if manifest.Version == "" {
+		return Release{}, errors.New("发布清单中没有版本号")
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	asset, ok := manifest.Assets[platform]
+	if !ok || asset.URL == "" {
+		return Release{}, fmt.Errorf("版本 %s 没有 %s 平台的可执行文件", manifest.Version, platform)
+	}
+	if err := u.verify(manifest.Version, platform, asset); err != nil {
+		return Release{}, err
+	}
+	base, err := url.Parse(u.manifestURL)
+	if err != nil {
+		return Release{}, fmt.Errorf("发布清单地址无效: %v", err)
+	}
+	ref, err := url.Parse(asset.URL)
+	if err != nil {
+		return Release{}, fmt.Errorf("可执行文件地址无效: %v", err)
+	}
+	return Release{Version: manifest.Version, URL: base.ResolveReference(ref).String(), asset: asset}, nil
+}
+
+// verify 校验文件信息的签名。
+func (u *Updater) verify(version, platform string, asset Asset) error {
+	if sum, err := hex.DecodeString(asset.SHA256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("发布清单中 %s 平台的SHA-256无效", platform)
+	}
+	signature, err := base64.StdEncoding.DecodeString(asset.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return errors.New("发布清单中的签名无效")
+	}
+	if !ed25519.Verify(u.publicKey, SignedMessage(version, platform, asset.SHA256), signature) {
+		return errors.New("发布清单的签名校验失败")
+	}
+	return nil
+}
+
+// Download 下载发布的可执行文件并按签名过的SHA-256校验内容。
+//
+// 参数：
+//   - release: Check返回的发布
+//
+// 返回值：
+//   - []byte: 校验通过的文件内容
+//   - error: 下载失败或校验不通过
+func (u *Updater) Download(release Release) ([]byte, error) {
+	data, err := u.get(release.URL, maxBinaryBytes)
+	if err != nil {
+		return nil, fmt.Errorf("下载可执行文件失败: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), release.asset.SHA256) {
+		return nil, errors.New("可执行文件的SHA-256与发布清单不一致")
+	}
+	return data, nil
+}
+
+// CompareVersions 比较两个语义化版本号。
+//
+// 版本号格式为 [v]主版本.次版本.修订号[-预发布][+构建信息]，预发布版本低于对应的正式版本，
+// 构建信息不参与比较。
+//
+// 参数：
+//   - a: 版本号
+//   - b: 版本号
+//
+// 返回值：
+//   - int: a低于b时为-1，相同时为0，高于时为1
+//   - bool: 两个版本号是否都能解析，无法解析时比较结果无意义
+func CompareVersions(a, b string) (int, bool) {
+	va, ok := parseVersion(a)
+	if !ok {
+		return 0, false
+	}
+	vb, ok := parseVersion(b)
+	if !ok {
+		return 0, false
+	}
+	for i := range va.core {
+		if va.core[i] != vb.core[i] {
+			return compareInts(va.core[i], vb.core[i]), true
+		}
+	}
+	switch {
+	case va.pre == nil && vb.pre == nil:
+		return 0, true
+	case va.pre == nil:
+		return 1, true
+	case vb.pre == nil:
+		return -1, true
+	}
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		if c := comparePrerelease(va.pre[i], vb.pre[i]); c != 0 {
+			return c, true
+		}
+	}
+	return compareInts(len(va.pre), len(vb.pre)), true
+}
+
+// semver 解析后的版本号。
+type semver struct {
+	core [3]int   // 主版本、次版本和修订号
+	pre  []string // 预发布标识，正式版本为nil
+}
+
+// parseVersion 解析语义化版本号。
+func parseVersion(value string) (semver, bool) {
+	value = strings.TrimPrefix(value, "v")
+	value, _, _ = strings.Cut(value, "+")
+	value, pre, hasPre := strings.Cut(value, "-")
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	var v semver
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part == "" || part[0] == '+' {
+			return semver{}, false
+		}
+		v.core[i] = n
+	}
+	if hasPre {
+		if pre == "" {
+			return semver{}, false
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+	return v, true
+}
+
+// comparePrerelease 比较单个预发布标识，数字标识按数值比较且低于非数字标识。
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInts(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareInts 比较两个整数。
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// get 下载地址的内容，超过大小上限时返回错误。
+func (u *Updater) get(rawURL string, limit int64) ([]byte, error) {
+	resp, err := u.client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s 返回 %s", rawURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s 的内容超过 %d 字节", rawURL, limit)
+	}
+	return data, nil
+}
+
+// Install 用新的可执行文件替换当前程序，原文件保留为同目录下的 .old 文件。
+//
+// 新文件先写入同一目录再重命名，替换是原子的；运行中的进程不受影响，
+// 之后的进程交接或重启使用新版本。
+//
+// 参数：
+//   - data: 校验通过的可执行文件内容
+//
+// 返回值：
+//   - string: 被替换的可执行文件路径
+//   - error: 写入或替换失败
+func Install(data []byte) (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("无法确定可执行文件路径: %v", err)
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", fmt.Errorf("无法确定可执行文件路径: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".proxyflow-update-*")
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, bytes.NewReader(data)); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("写入新版本失败: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("写入新版本失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("写入新版本失败: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return "", err
+	}
+
+	old := path + ".old"
+	os.Remove(old)
+	if err := os.Link(path, old); err != nil {
+		return "", fmt.Errorf("备份当前版本失败: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("替换可执行文件失败: %v", err)
+	}
+	return path, nil
+}
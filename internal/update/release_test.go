@@ -0,0 +1,115 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		order int
+		ok    bool
+	}{
+		{"v1.5.0", "v1.4.9", 1, true},
+		{"v1.4.0", "v1.10.0", -1, true},
+		{"1.4.0", "v1.4.0", 0, true},
+		{"v1.4.0+build.7", "v1.4.0", 0, true},
+		{"v1.5.0-rc.1", "v1.5.0", -1, true},
+		{"v1.5.0-rc.2", "v1.5.0-rc.10", -1, true},
+		{"v1.5.0-rc.1", "v1.5.0-beta", 1, true},
+		{"v1.5.0-alpha", "v1.5.0-alpha.1", -1, true},
+		{"v1.5", "v1.4.0", 0, false},
+		{"dev", "v1.4.0", 0, false},
+		{"v1.+5.0", "v1.4.0", 0, false},
+	}
+	for _, tt := range tests {
+		order, ok := CompareVersions(tt.a, tt.b)
+		if ok != tt.ok || (ok && order != tt.order) {
+			t.Errorf("CompareVersions(%q, %q) = (%d, %v)，期望 (%d, %v)", tt.a, tt.b, order, ok, tt.order, tt.ok)
+		}
+	}
+}
+
+// serveManifest 启动提供发布清单和可执行文件的测试服务器。
+func serveManifest(t *testing.T, manifest Manifest, binary []byte) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(manifest)
+	})
+	mux.HandleFunc("/proxyflow", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL + "/latest.json"
+}
+
+func TestCheckVerifiesSignedManifest(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := base64.StdEncoding.EncodeToString(public)
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	binary := []byte("old release binary")
+	asset := Sign(private, "v1.3.0", platform, "proxyflow", binary)
+
+	tests := []struct {
+		name     string
+		manifest Manifest
+		valid    bool
+	}{
+		{"签名一致", Manifest{Version: "v1.3.0", Assets: map[string]Asset{platform: asset}}, true},
+		// 旧版本的文件和签名原样放进声称更高版本的清单
+		{"替换版本号", Manifest{Version: "v9.9.9", Assets: map[string]Asset{platform: asset}}, false},
+		{"替换平台", Manifest{Version: "v1.3.0", Assets: map[string]Asset{platform: Sign(private, "v1.3.0", "plan9/arm", "proxyflow", binary)}}, false},
+		{"缺少SHA-256", Manifest{Version: "v1.3.0", Assets: map[string]Asset{platform: {URL: "proxyflow", Signature: asset.Signature}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater, err := NewUpdater(serveManifest(t, tt.manifest, binary), publicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			release, err := updater.Check()
+			if (err == nil) != tt.valid {
+				t.Fatalf("Check() 错误 = %v，期望校验通过 = %v", err, tt.valid)
+			}
+			if err != nil {
+				return
+			}
+			data, err := updater.Download(release)
+			if err != nil || string(data) != string(binary) {
+				t.Fatalf("Download() = %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestDownloadRejectsModifiedBinary(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	manifest := Manifest{Version: "v1.3.0", Assets: map[string]Asset{platform: Sign(private, "v1.3.0", platform, "proxyflow", []byte("signed"))}}
+	updater, err := NewUpdater(serveManifest(t, manifest, []byte("tampered")), base64.StdEncoding.EncodeToString(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := updater.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updater.Download(release); err == nil {
+		t.Fatal("被修改的可执行文件应校验失败")
+	}
+}
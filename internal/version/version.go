@@ -0,0 +1,76 @@
+// Package version 提供构建版本信息。
+//
+// 版本号、提交和构建时间在构建时通过 -ldflags 注入，例如：
+//
+//	go build -ldflags "-X github.com/rfym21/ProxyFlow/internal/version.Version=v1.4.0 \
+//	  -X github.com/rfym21/ProxyFlow/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/rfym21/ProxyFlow/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/proxyflow
+//
+// 没有注入时，提交和构建时间取自Go工具链记录的版本控制信息（在git仓库中直接 go build 时可用）。
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// 构建时通过 -ldflags "-X" 注入的版本信息。
+var (
+	Version   = "dev"     // 版本号
+	Commit    = "unknown" // 提交哈希
+	BuildDate = "unknown" // 构建时间（UTC，RFC 3339）
+)
+
+// Info 构建版本信息。
+type Info struct {
+	Version   string `json:"version"`    // 版本号
+	Commit    string `json:"commit"`     // 提交哈希
+	BuildDate string `json:"build_date"` // 构建时间
+	GoVersion string `json:"go_version"` // 编译使用的Go版本
+	Modified  bool   `json:"modified"`   // 构建时工作区是否有未提交的修改，仅在使用版本控制信息时可知
+}
+
+// Get 返回当前程序的版本信息。
+//
+// 返回值：
+//   - Info: 版本信息
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "unknown" {
+				info.Commit = setting.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			}
+		case "vcs.time":
+			if info.BuildDate == "unknown" {
+				info.BuildDate = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// Short 返回用于响应头和日志的简短版本描述，如 "v1.4.0 (3f2a9c1)"。
+func (i Info) Short() string {
+	return fmt.Sprintf("%s (%s)", i.Version, i.Commit)
+}
+
+// String 返回完整的版本描述。
+func (i Info) String() string {
+	s := fmt.Sprintf("ProxyFlow %s\n提交: %s\n构建时间: %s\nGo版本: %s", i.Version, i.Commit, i.BuildDate, i.GoVersion)
+	if i.Modified {
+		s += "\n构建时工作区有未提交的修改"
+	}
+	return s
+}
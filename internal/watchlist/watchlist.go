@@ -0,0 +1,232 @@
+// Package watchlist 提供可疑目标的告警与拦截规则。
+//
+// 规则按目标主机名或IP地址匹配，例如内部企业域名、已知的C2地址列表。
+// 命中规则的请求会产生告警事件，拦截规则还会拒绝该请求，
+// 用于发现被盗用的凭据通过代理访问不应访问的目标。
+package watchlist
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfym21/ProxyFlow/internal/hostmatch"
+)
+
+const (
+	// ActionAlert 命中后只产生告警事件
+	ActionAlert = "alert"
+	// ActionBlock 命中后产生告警事件并拒绝请求
+	ActionBlock = "block"
+
+	// maxEvents 保留的最近告警事件数
+	maxEvents = 200
+)
+
+// Rule 单条目标规则。
+type Rule struct {
+	Pattern string            // 目标模式：主机模式（见 hostmatch 包）或 CIDR
+	Action  string            // 命中后的动作：alert或block
+	network *net.IPNet        // 模式为CIDR时的网段
+	host    hostmatch.Pattern // 模式为主机模式时编译后的模式
+}
+
+// Event 一次命中规则的告警事件。
+type Event struct {
+	Time        time.Time `json:"time"`           // 发生时间
+	Destination string    `json:"destination"`    // 目标主机
+	Pattern     string    `json:"pattern"`        // 命中的规则模式
+	Action      string    `json:"action"`         // 规则动作
+	User        string    `json:"user,omitempty"` // 认证用户名
+	Client      string    `json:"client"`         // 客户端地址
+}
+
+// Report 告警统计。
+type Report struct {
+	Hits   map[string]int64 `json:"hits"`   // 按规则模式统计的命中次数
+	Events []Event          `json:"events"` // 最近的告警事件，按时间从新到旧
+}
+
+// List 目标规则列表，按顺序匹配第一条命中的规则。
+type List struct {
+	rules  []Rule           // 规则列表
+	hits   map[string]int64 // 按规则模式统计的命中次数
+	events []Event          // 最近的告警事件，环形保存
+	next   int              // 下一条事件写入的位置
+	mutex  sync.Mutex       // 互斥锁
+}
+
+// New 创建目标规则列表。
+//
+// 拦截规则排在告警规则之前，同一目标同时命中两类规则时按拦截处理。
+//
+// 参数：
+//   - alert: 只告警的目标模式
+//   - block: 告警并拦截的目标模式
+//   - path: 规则文件路径，为空则不加载；每行形如 "block *.corp.example.com"，# 开头为注释
+//
+// 返回值：
+//   - *List: 规则列表，没有任何规则时为nil
+//   - error: 模式无效或读取规则文件失败
+func New(alert, block []string, path string) (*List, error) {
+	var blockRules, alertRules []Rule
+	add := func(action, pattern string) error {
+		rule, err := parseRule(action, pattern)
+		if err != nil {
+			return err
+		}
+		if action == ActionBlock {
+			blockRules = append(blockRules, rule)
+		} else {
+			alertRules = append(alertRules, rule)
+		}
+		return nil
+	}
+
+	for _, pattern := range block {
+		if err := add(ActionBlock, pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range alert {
+		if err := add(ActionAlert, pattern); err != nil {
+			return nil, err
+		}
+	}
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取目标规则文件失败: %v", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			action, pattern, ok := strings.Cut(text, " ")
+			if !ok || (action != ActionAlert && action != ActionBlock) {
+				return nil, fmt.Errorf("目标规则文件第 %d 行格式错误: %s", line, text)
+			}
+			if err := add(action, pattern); err != nil {
+				return nil, fmt.Errorf("目标规则文件第 %d 行: %w", line, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("读取目标规则文件失败: %v", err)
+		}
+	}
+
+	if len(blockRules)+len(alertRules) == 0 {
+		return nil, nil
+	}
+	return &List{rules: append(blockRules, alertRules...), hits: make(map[string]int64)}, nil
+}
+
+// parseRule 解析单条规则。
+func parseRule(action, pattern string) (Rule, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return Rule{}, fmt.Errorf("目标规则模式为空")
+	}
+	rule := Rule{Pattern: pattern, Action: action}
+	if strings.Contains(pattern, "/") && !strings.HasPrefix(pattern, "regex:") {
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return Rule{}, fmt.Errorf("目标规则网段无效: %s", pattern)
+		}
+		rule.network = network
+		return rule, nil
+	}
+	host, err := hostmatch.Compile(pattern)
+	if err != nil {
+		return Rule{}, fmt.Errorf("目标规则无效: %v", err)
+	}
+	rule.host = host
+	return rule, nil
+}
+
+// Len 返回规则数量，列表为nil时返回0。
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.rules)
+}
+
+// Match 查找目标命中的第一条规则。
+//
+// 参数：
+//   - host: 目标主机名或IP地址（不含端口）
+//
+// 返回值：
+//   - *Rule: 命中的规则，没有命中或列表为nil时为nil
+func (l *List) Match(host string) *Rule {
+	if l == nil {
+		return nil
+	}
+	host = hostmatch.Normalize(host)
+	ip := net.ParseIP(host)
+	for i := range l.rules {
+		rule := &l.rules[i]
+		if rule.network != nil {
+			if ip != nil && rule.network.Contains(ip) {
+				return rule
+			}
+			continue
+		}
+		if rule.host.Match(host) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Record 记录一次告警事件。
+//
+// 参数：
+//   - event: 告警事件
+func (l *List) Record(event Event) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.hits[event.Pattern]++
+	if len(l.events) < maxEvents {
+		l.events = append(l.events, event)
+		return
+	}
+	l.events[l.next] = event
+	l.next = (l.next + 1) % maxEvents
+}
+
+// Report 返回告警统计。
+//
+// 返回值：
+//   - Report: 命中次数和最近的告警事件
+func (l *List) Report() Report {
+	report := Report{Hits: map[string]int64{}, Events: []Event{}}
+	if l == nil {
+		return report
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for pattern, hits := range l.hits {
+		report.Hits[pattern] = hits
+	}
+	for i := len(l.events) - 1; i >= 0; i-- {
+		report.Events = append(report.Events, l.events[(l.next+i)%len(l.events)])
+	}
+	return report
+}
@@ -0,0 +1,291 @@
+// Package websocket 提供服务端WebSocket连接（RFC 6455）。
+//
+// 只实现把WebSocket当作字节流承载代理流量所需的部分：完成升级握手后，
+// 客户端发来的数据帧按顺序拼接为连续的字节流，写入的数据作为二进制帧发送，
+// 对外表现为一个普通的net.Conn。自动回应Ping，收到Close时回应并结束读取。
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID 计算Sec-WebSocket-Accept使用的固定GUID
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// 帧操作码
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxControlPayload 控制帧负载的最大字节数
+const maxControlPayload = 125
+
+// closeTimeout 发送Close帧的超时时间
+const closeTimeout = time.Second
+
+// 关闭状态码
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+)
+
+// ErrProtocol 客户端违反WebSocket协议
+var ErrProtocol = errors.New("WebSocket协议错误")
+
+// IsUpgrade 判断请求是否为WebSocket升级请求。
+func IsUpgrade(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade 完成WebSocket升级握手并接管底层连接。
+//
+// 请求不是有效的升级请求时写入错误响应并返回错误。
+//
+// 参数：
+//   - w: 响应写入器，必须支持Hijack（HTTP/1.1）
+//   - r: 升级请求
+//
+// 返回值：
+//   - *Conn: WebSocket连接
+//   - error: 握手失败的原因
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if !IsUpgrade(r) {
+		http.Error(w, "需要WebSocket升级请求", http.StatusBadRequest)
+		return nil, errors.New("不是WebSocket升级请求")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "不支持的WebSocket版本", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("不支持的WebSocket版本: %q", r.Header.Get("Sec-Websocket-Version"))
+	}
+	key := strings.TrimSpace(r.Header.Get("Sec-Websocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "无效的Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("无效的Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket需要HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return nil, errors.New("连接不支持接管，WebSocket需要HTTP/1.1")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := io.WriteString(conn, response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: rw.Reader}, nil
+}
+
+// headerContains 判断请求头的逗号分隔值中是否包含指定项（不区分大小写）。
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn 服务端WebSocket连接，以字节流方式读写。
+type Conn struct {
+	conn   net.Conn      // 底层连接
+	reader *bufio.Reader // 底层连接的缓冲读取器，可能含有握手后已读入的数据
+
+	remaining int64   // 当前数据帧尚未读取的负载字节数
+	mask      [4]byte // 当前数据帧的掩码
+	maskPos   int     // 下一个负载字节对应的掩码位置
+	readErr   error   // 读取结束的原因，之后的读取都返回该错误
+
+	writeMutex sync.Mutex // 保证帧写入不交错
+	closeSent  bool       // 是否已发送Close帧，之后不再发送任何帧
+}
+
+// Read 读取客户端数据帧的负载。
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for c.remaining == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if err := c.nextFrame(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[c.maskPos]
+		c.maskPos = (c.maskPos + 1) & 3
+	}
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame 读取帧头，处理控制帧，直到遇到有负载的数据帧。
+func (c *Conn) nextFrame() error {
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return err
+		}
+		opcode := header[0] & 0x0F
+		if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+			// 未协商扩展时保留位必须为0，客户端发出的帧必须带掩码
+			c.closeWith(closeProtocolError)
+			return ErrProtocol
+		}
+
+		length := int64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return err
+		}
+
+		switch opcode {
+		case opContinuation, opText, opBinary:
+			if length == 0 {
+				continue
+			}
+			c.remaining, c.mask, c.maskPos = length, mask, 0
+			return nil
+		case opClose, opPing, opPong:
+			if length > maxControlPayload || header[0]&0x80 == 0 {
+				c.closeWith(closeProtocolError)
+				return ErrProtocol
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.reader, payload); err != nil {
+				return err
+			}
+			for i := range payload {
+				payload[i] ^= mask[i&3]
+			}
+			switch opcode {
+			case opClose:
+				c.closeWith(closeNormal)
+				return io.EOF
+			case opPing:
+				if err := c.writeFrame(opPong, payload); err != nil {
+					return err
+				}
+			}
+		default:
+			c.closeWith(closeProtocolError)
+			return ErrProtocol
+		}
+	}
+}
+
+// Write 将数据作为一个二进制帧发送。
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame 发送一个不带掩码的完整帧。
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	if opcode == opClose {
+		c.closeSent = true
+		c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	}
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// closeWith 发送带状态码的Close帧，已发送过时不再发送。
+func (c *Conn) closeWith(code uint16) {
+	c.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+// Close 发送Close帧并关闭底层连接。
+func (c *Conn) Close() error {
+	c.closeWith(closeNormal)
+	return c.conn.Close()
+}
+
+// LocalAddr 返回本端地址。
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr 返回客户端地址。
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetDeadline 设置读写截止时间。
+func (c *Conn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+
+// SetReadDeadline 设置读取截止时间。
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline 设置写入截止时间。
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
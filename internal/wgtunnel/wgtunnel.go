@@ -0,0 +1,411 @@
+// Package wgtunnel 提供经用户态WireGuard隧道出站的能力。
+//
+// 每条隧道按 wg-quick 格式的配置文件在进程内创建（wireguard-go 加用户态网络栈），
+// 不需要内核模块或root权限。上游条目写作 wg://隧道名称，与HTTP代理一样参与轮换，
+// 到目标的连接经该隧道的对端出站。
+package wgtunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"github.com/rfym21/ProxyFlow/internal/dialer"
+	"github.com/rfym21/ProxyFlow/internal/models"
+)
+
+// Scheme WireGuard上游的URL协议
+const Scheme = "wg"
+
+// defaultMTU 配置文件未指定MTU时使用的值
+const defaultMTU = 1420
+
+// tunnel 一条已启动的WireGuard隧道。
+type tunnel struct {
+	device *device.Device // WireGuard设备
+	net    *netstack.Net  // 隧道内的用户态网络栈
+	dns    bool           // 是否配置了隧道内的DNS服务器
+}
+
+var (
+	tunnels = make(map[string]*tunnel) // 按名称索引的隧道
+	mutex   sync.RWMutex               // 读写锁
+)
+
+// interfaceConfig 解析后的 wg-quick 配置。
+type interfaceConfig struct {
+	privateKey string        // 十六进制私钥
+	listenPort string        // 本端UDP端口，为空则随机
+	addresses  []netip.Addr  // 隧道内的本端地址
+	dns        []netip.Addr  // 隧道内的DNS服务器
+	mtu        int           // MTU
+	peers      []*peerConfig // 对端
+}
+
+// peerConfig 一个对端的UAPI配置。
+type peerConfig struct {
+	publicKey string   // 十六进制公钥
+	settings  []string // 公钥以外的UAPI配置行
+}
+
+// uapi 生成交给wireguard-go的UAPI配置，每个对端以公钥开头。
+func (c *interfaceConfig) uapi() string {
+	lines := []string{"private_key=" + c.privateKey}
+	if c.listenPort != "" {
+		lines = append(lines, "listen_port="+c.listenPort)
+	}
+	for _, peer := range c.peers {
+		lines = append(lines, "public_key="+peer.publicKey)
+		lines = append(lines, peer.settings...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Configure 按配置文件创建并启动WireGuard隧道。
+//
+// 参数：
+//   - files: 隧道名称到 wg-quick 格式配置文件路径的映射
+//
+// 返回值：
+//   - error: 任一配置文件无法读取、格式错误或隧道无法启动时返回错误
+func Configure(files map[string]string) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cfg, err := loadConfig(files[name])
+		if err != nil {
+			return fmt.Errorf("WireGuard隧道 %s: %v", name, err)
+		}
+		t, err := start(name, cfg)
+		if err != nil {
+			return fmt.Errorf("启动WireGuard隧道 %s 失败: %v", name, err)
+		}
+
+		mutex.Lock()
+		tunnels[strings.ToLower(name)] = t
+		mutex.Unlock()
+		log.Printf("WireGuard隧道 %s 已启动，本端地址: %v", name, cfg.addresses)
+	}
+	return nil
+}
+
+// Close 关闭全部WireGuard隧道。
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for name, t := range tunnels {
+		t.device.Close()
+		delete(tunnels, name)
+	}
+}
+
+// WithoutDNS 返回没有配置隧道内DNS服务器的隧道名称。
+//
+// 经这些隧道连接主机名目标时在本机解析，严格DNS模式下不能使用。
+//
+// 返回值：
+//   - []string: 按名称排序的隧道名称（小写）
+func WithoutDNS() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var names []string
+	for name, t := range tunnels {
+		if !t.dns {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dial 经WireGuard隧道连接目标地址。
+//
+// 隧道配置了DNS服务器时目标主机名在隧道内解析，否则使用本机解析。
+//
+// 参数：
+//   - ctx: 控制连接建立的上下文
+//   - proxy: WireGuard上游信息，主机部分为隧道名称
+//   - addr: 目标地址（host:port格式）
+//
+// 返回值：
+//   - net.Conn: 到目标的连接
+//   - error: 隧道不存在或连接失败的原因
+func Dial(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+	mutex.RLock()
+	t, ok := tunnels[strings.ToLower(proxy.Host)]
+	mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未配置WireGuard隧道: %s", proxy.Host)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil || t.dns {
+		return t.net.DialContext(ctx, "tcp", addr)
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口: %s", port)
+	}
+	for _, ip := range ips {
+		var c net.Conn
+		if c, err = t.net.DialContextTCPAddrPort(ctx, netip.AddrPortFrom(ip.Unmap(), uint16(portNum))); err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// upstream 注册到 dialer 包的WireGuard上游拨号器。
+type upstream struct{}
+
+func init() {
+	dialer.Register(Scheme, upstream{})
+}
+
+// DialContext 经WireGuard隧道连接目标地址，见 Dial。
+func (upstream) DialContext(ctx context.Context, proxy models.ProxyInfo, addr string) (net.Conn, error) {
+	return Dial(ctx, proxy, addr)
+}
+
+// Prepare 检查上游是否指定了隧道名称，主机部分为 WIREGUARD_TUNNELS 中的隧道名称。
+func (upstream) Prepare(proxy *models.ProxyInfo) error {
+	if proxy.Host == "" {
+		return fmt.Errorf("WireGuard上游缺少隧道名称: %s", proxy.URL.Redacted())
+	}
+	return nil
+}
+
+// start 创建用户态网络栈和WireGuard设备并启动隧道。
+func start(name string, cfg *interfaceConfig) (*tunnel, error) {
+	tun, tnet, err := netstack.CreateNetTUN(cfg.addresses, cfg.dns, cfg.mtu)
+	if err != nil {
+		return nil, err
+	}
+	logger := &device.Logger{
+		Verbosef: device.DiscardLogf,
+		Errorf: func(format string, args ...any) {
+			log.Printf("WireGuard隧道 %s: %s", name, fmt.Sprintf(format, args...))
+		},
+	}
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	if err := dev.IpcSet(cfg.uapi()); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return &tunnel{device: dev, net: tnet, dns: len(cfg.dns) > 0}, nil
+}
+
+// loadConfig 读取 wg-quick 格式的配置文件。
+//
+// 支持 [Interface] 的 PrivateKey、Address、DNS、MTU、ListenPort，
+// 以及 [Peer] 的 PublicKey、PresharedKey、Endpoint、AllowedIPs、PersistentKeepalive，
+// 其余仅对 wg-quick 有意义的字段（如 PostUp）被忽略。
+func loadConfig(path string) (*interfaceConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	defer file.Close()
+
+	cfg := &interfaceConfig{mtu: defaultMTU}
+	var section string
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			if section == "peer" {
+				cfg.peers = append(cfg.peers, &peerConfig{})
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("第 %d 行格式错误: %s", lineNum, line)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		var lineErr error
+		switch section {
+		case "interface":
+			lineErr = cfg.setInterface(key, value)
+		case "peer":
+			lineErr = cfg.peers[len(cfg.peers)-1].set(key, value)
+		default:
+			lineErr = fmt.Errorf("配置项不在 [Interface] 或 [Peer] 中")
+		}
+		if lineErr != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", lineNum, lineErr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if cfg.privateKey == "" {
+		return nil, fmt.Errorf("缺少 [Interface] PrivateKey")
+	}
+	if len(cfg.addresses) == 0 {
+		return nil, fmt.Errorf("缺少 [Interface] Address")
+	}
+	if len(cfg.peers) == 0 {
+		return nil, fmt.Errorf("至少需要一个 [Peer]")
+	}
+	for i, peer := range cfg.peers {
+		if peer.publicKey == "" {
+			return nil, fmt.Errorf("第 %d 个 [Peer] 缺少 PublicKey", i+1)
+		}
+	}
+	return cfg, nil
+}
+
+// setInterface 处理 [Interface] 中的一个配置项。
+func (c *interfaceConfig) setInterface(key, value string) error {
+	switch key {
+	case "privatekey":
+		k, err := decodeKey(value)
+		if err != nil {
+			return err
+		}
+		c.privateKey = k
+	case "listenport":
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return fmt.Errorf("无效的ListenPort: %s", value)
+		}
+		c.listenPort = value
+	case "address":
+		for _, item := range splitList(value) {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(item)
+				if addrErr != nil {
+					return fmt.Errorf("无效的地址: %s", item)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			c.addresses = append(c.addresses, prefix.Addr())
+		}
+	case "dns":
+		for _, item := range splitList(value) {
+			// DNS 也可以是搜索域，用户态网络栈只使用其中的IP地址
+			if addr, err := netip.ParseAddr(item); err == nil {
+				c.dns = append(c.dns, addr)
+			}
+		}
+	case "mtu":
+		mtu, err := strconv.Atoi(value)
+		if err != nil || mtu < 576 {
+			return fmt.Errorf("无效的MTU: %s", value)
+		}
+		c.mtu = mtu
+	}
+	return nil
+}
+
+// set 处理 [Peer] 中的一个配置项。
+func (p *peerConfig) set(key, value string) error {
+	switch key {
+	case "publickey":
+		k, err := decodeKey(value)
+		if err != nil {
+			return err
+		}
+		p.publicKey = k
+	case "presharedkey":
+		k, err := decodeKey(value)
+		if err != nil {
+			return err
+		}
+		p.settings = append(p.settings, "preshared_key="+k)
+	case "endpoint":
+		endpoint, err := resolveEndpoint(value)
+		if err != nil {
+			return err
+		}
+		p.settings = append(p.settings, "endpoint="+endpoint)
+	case "allowedips":
+		for _, item := range splitList(value) {
+			if _, err := netip.ParsePrefix(item); err != nil {
+				return fmt.Errorf("无效的AllowedIPs: %s", item)
+			}
+			p.settings = append(p.settings, "allowed_ip="+item)
+		}
+	case "persistentkeepalive":
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return fmt.Errorf("无效的PersistentKeepalive: %s", value)
+		}
+		p.settings = append(p.settings, "persistent_keepalive_interval="+value)
+	}
+	return nil
+}
+
+// decodeKey 将base64编码的密钥转换为UAPI使用的十六进制。
+func decodeKey(value string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		return "", fmt.Errorf("无效的密钥")
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// resolveEndpoint 将对端地址中的主机名解析为IP地址，UAPI只接受IP地址。
+func resolveEndpoint(value string) (string, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return "", fmt.Errorf("无效的Endpoint: %s", value)
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return value, nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip", host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("解析Endpoint %s 失败: %v", host, err)
+	}
+	return net.JoinHostPort(ips[0].Unmap().String(), port), nil
+}
+
+// splitList 拆分逗号分隔的列表并去掉空白项。
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}